@@ -1,20 +1,23 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"math/big"
+	"os"
 
+	"github.com/Gealber/evm-simulator/logging"
 	"github.com/Gealber/evm-simulator/rpc"
 	"github.com/Gealber/evm-simulator/simulator"
 	"github.com/Gealber/evm-simulator/vm"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/core/rawdb"
-	"github.com/ethereum/go-ethereum/core/state"
-	"github.com/ethereum/go-ethereum/core/types"
 )
 
 func main() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	logging.SetLevel(logging.Simulator, slog.LevelDebug)
+
 	exampleSimulateBundle()
 }
 
@@ -33,7 +36,7 @@ func simpleSim() {
 	rpcClt := rpc.NewClient(rpcEndpoint)
 	sim, err := simulator.NewSimulator(rpcClt)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	gasPrice := big.NewInt(0)
@@ -50,20 +53,17 @@ func simpleSim() {
 		Input:       hexutil.MustDecode(`0x0000000000000000000000000000000000000000000000000000000000000020`),
 	}
 
-	stateDB, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	stateDB, err := sim.NewRemoteState(context.Background(), blkNumber, nil)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	result, err := sim.Simulate(simulation, stateDB, nil)
+	result, err := sim.Simulate(context.Background(), simulation, stateDB)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	log.Println("-----------------------------------------------------------")
-	// just log the returned value for now
-	log.Println(hexutil.Encode(result.ReturnedData))
-	log.Println(result.GasUsed)
+	slog.Info("simulated", "returnData", hexutil.Encode(result.ReturnedData), "gasUsed", result.GasUsed)
 }
 
 func exampleSimulateBundle() {
@@ -73,7 +73,7 @@ func exampleSimulateBundle() {
 	rpcClt := rpc.NewClient(rpcEndpoint)
 	sim, err := simulator.NewSimulator(rpcClt)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	gasPrice := big.NewInt(0)
@@ -108,27 +108,26 @@ func exampleSimulateBundle() {
 		},
 	}
 
-	stateDB, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	stateDB, err := sim.NewRemoteState(context.Background(), blkNumber, nil)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	result, err := sim.SimulateBundle(simulations, stateDB, nil)
+	result, err := sim.SimulateBundle(context.Background(), simulations, stateDB)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	for _, r := range result {
-		log.Println("-----------------------------------------------------------")
-		// just log the returned value for now
-		log.Println(hexutil.Encode(r.ReturnedData))
-		log.Println(r.GasUsed)
+	for i, r := range result {
+		slog.Info("simulated", "index", i, "returnData", hexutil.Encode(r.ReturnedData), "gasUsed", r.GasUsed)
 
 		for _, l := range r.Record.AccessList {
-			log.Println("ADDRESS: ", l.Address.Hex())
-			for _, st := range l.StorageKeys {
-				log.Println(st.Hex())
-			}
+			slog.Info("accessed", "index", i, "address", l.Address, "storageKeys", l.StorageKeys)
 		}
 	}
 }
+
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,116 @@
+// Package logging provides the structured loggers of the components of the
+// simulator, built on log/slog. Every component has its own level, and the lines
+// logged with a context carrying a simulation ID have it as the simulation_id
+// attribute, so the lines of concurrent simulations can be told apart.
+//
+// The loggers write to the handler of slog.Default at the time of logging, set it
+// to choose the format and the output. A line is logged when both the level of its
+// component and the default handler enable it.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+)
+
+// components of the simulator
+const (
+	RPC         = "rpc"
+	Interpreter = "interpreter"
+	Simulator   = "simulator"
+)
+
+var (
+	levelsMu sync.Mutex
+	levels   = make(map[string]*slog.LevelVar)
+)
+
+// level returns the level of component, info until set
+func level(component string) *slog.LevelVar {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	lvl, ok := levels[component]
+	if !ok {
+		lvl = new(slog.LevelVar)
+		levels[component] = lvl
+	}
+
+	return lvl
+}
+
+// SetLevel sets the minimum level of the lines logged by component, the loggers
+// already returned by Logger included.
+func SetLevel(component string, lvl slog.Level) {
+	level(component).Set(lvl)
+}
+
+// Logger returns the logger of component, its lines have the component attribute.
+func Logger(component string) *slog.Logger {
+	return slog.New(&handler{level: level(component)}).With("component", component)
+}
+
+type simulationIDKey struct{}
+
+// WithSimulationID returns a context whose lines are logged with id.
+func WithSimulationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, simulationIDKey{}, id)
+}
+
+// SimulationID returns the simulation ID of ctx, empty when it has none.
+func SimulationID(ctx context.Context) string {
+	id, _ := ctx.Value(simulationIDKey{}).(string)
+	return id
+}
+
+// NewSimulationID returns a random simulation ID.
+func NewSimulationID() string {
+	var b [8]byte
+	rand.Read(b[:])
+
+	return hex.EncodeToString(b[:])
+}
+
+// handler filters the lines of a component by its level and hands them to the
+// default handler with the simulation ID of their context
+type handler struct {
+	level *slog.LevelVar
+	// attrs and groups added with With and WithGroup, in order
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level() && slog.Default().Handler().Enabled(ctx, lvl)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := SimulationID(ctx); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("simulation_id", id))
+	}
+
+	next := slog.Default().Handler()
+	for _, op := range h.ops {
+		next = op(next)
+	}
+
+	return next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+
+	return &handler{level: h.level, ops: append(ops, op)}
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	logger := Logger(RPC).With("endpoint", "http://localhost")
+
+	// debug lines are dropped until the level of the component allows them
+	logger.Debug("dropped")
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected line: %s", buf.String())
+	}
+
+	SetLevel(RPC, slog.LevelDebug)
+	t.Cleanup(func() { SetLevel(RPC, slog.LevelInfo) })
+
+	ctx := WithSimulationID(context.Background(), "0102")
+	logger.DebugContext(ctx, "request", "method", "eth_getCode")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"msg":           "request",
+		"component":     RPC,
+		"endpoint":      "http://localhost",
+		"method":        "eth_getCode",
+		"simulation_id": "0102",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Fatalf("%s: %v expected: %v", key, line[key], value)
+		}
+	}
+
+	// the level of the other components is unchanged
+	buf.Reset()
+	Logger(Simulator).Debug("dropped")
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected line: %s", buf.String())
+	}
+}
@@ -0,0 +1,179 @@
+// Package metrics instruments the simulator and the RPC client with Prometheus
+// collectors, enabled with simulator.WithMetrics and rpc.WithMetrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "evm_simulator"
+
+// statuses of the simulations
+const (
+	StatusSuccess  = "success"
+	StatusReverted = "reverted"
+	StatusError    = "error"
+)
+
+// Metrics holds the collectors of a simulator and its RPC clients. The methods of a
+// nil Metrics do nothing, so the instrumented code doesn't check whether metrics are
+// enabled. It's safe for concurrent use.
+type Metrics struct {
+	// Simulations counts the simulations run by status, the ones of a bundle included
+	Simulations *prometheus.CounterVec
+	// SimulationDuration is the duration of Simulator.Simulate and Simulator.SimulateBundle
+	SimulationDuration *prometheus.HistogramVec
+	// OpcodesExecuted counts the opcodes executed by the final execution of the
+	// simulations run with Simulator.Simulate
+	OpcodesExecuted prometheus.Counter
+	// RPCRequests counts the requests sent to the node by method, every request of
+	// a batch included, and RPCErrors the ones failed
+	RPCRequests *prometheus.CounterVec
+	RPCErrors   *prometheus.CounterVec
+	// RPCDuration is the latency of the requests to the node by method, retries
+	// included. Batches are observed as a single "batch" method.
+	RPCDuration *prometheus.HistogramVec
+	// CacheLookups counts the lookups of the RPC cache by kind of data and result,
+	// hit or miss
+	CacheLookups *prometheus.CounterVec
+}
+
+// New returns metrics registered in reg.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		Simulations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "simulations_total",
+			Help:      "Simulations run by status.",
+		}, []string{"status"}),
+		SimulationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "simulation_duration_seconds",
+			Help:      "Duration of the simulations of transactions and bundles.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"kind"}),
+		OpcodesExecuted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "opcodes_executed_total",
+			Help:      "Opcodes executed by the simulations.",
+		}),
+		RPCRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rpc",
+			Name:      "requests_total",
+			Help:      "Requests sent to the node by method.",
+		}, []string{"method"}),
+		RPCErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rpc",
+			Name:      "errors_total",
+			Help:      "Requests to the node failed by method.",
+		}, []string{"method"}),
+		RPCDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "rpc",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of the requests to the node by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"method"}),
+		CacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rpc",
+			Name:      "cache_lookups_total",
+			Help:      "Lookups of the cache of fetched state by kind and result.",
+		}, []string{"kind", "result"}),
+	}
+
+	reg.MustRegister(
+		m.Simulations,
+		m.SimulationDuration,
+		m.OpcodesExecuted,
+		m.RPCRequests,
+		m.RPCErrors,
+		m.RPCDuration,
+		m.CacheLookups,
+	)
+
+	return m
+}
+
+// Handler returns the handler exposing the metrics of gatherer, usually mounted
+// at /metrics.
+func Handler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+}
+
+// ObserveSimulation records a simulation with the given status.
+func (m *Metrics) ObserveSimulation(status string) {
+	if m == nil {
+		return
+	}
+
+	m.Simulations.WithLabelValues(status).Inc()
+}
+
+// ObserveDuration records the duration of a simulation of the given kind, single
+// or bundle, started at start.
+func (m *Metrics) ObserveDuration(kind string, start time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.SimulationDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
+
+// ObserveOpcodes records n opcodes executed.
+func (m *Metrics) ObserveOpcodes(n uint64) {
+	if m == nil {
+		return
+	}
+
+	m.OpcodesExecuted.Add(float64(n))
+}
+
+// ObserveRPC records a request of method sent to the node, started at start and
+// failed when err isn't nil.
+func (m *Metrics) ObserveRPC(method string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.RPCRequests.WithLabelValues(method).Inc()
+	if err != nil {
+		m.RPCErrors.WithLabelValues(method).Inc()
+	}
+	m.RPCDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// ObserveBatch records a batch of requests of methods sent to the node, started at
+// start and failed as a whole when err isn't nil.
+func (m *Metrics) ObserveBatch(methods []string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	for _, method := range methods {
+		m.RPCRequests.WithLabelValues(method).Inc()
+		if err != nil {
+			m.RPCErrors.WithLabelValues(method).Inc()
+		}
+	}
+	m.RPCDuration.WithLabelValues("batch").Observe(time.Since(start).Seconds())
+}
+
+// ObserveCache records a lookup of the kind of data in the cache.
+func (m *Metrics) ObserveCache(kind string, hit bool) {
+	if m == nil {
+		return
+	}
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.CacheLookups.WithLabelValues(kind, result).Inc()
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrFetchBudgetExceeded is returned by the requests that would exceed the budget of
+// the Audit of their context, they aren't sent.
+var ErrFetchBudgetExceeded = errors.New("state fetch budget exceeded")
+
+// Fetch is a request sent to the node, every request of a batch included.
+type Fetch struct {
+	Method string
+	// Address and Slot requested, when the method has them
+	Address *common.Address
+	Slot    *common.Hash
+	Block   string
+	// Bytes of the result
+	Bytes   int
+	Latency time.Duration
+	Err     error
+}
+
+// Audit logs the requests sent to the node with the contexts carrying it, see
+// WithAudit, and bounds their number. Lookups answered by the cache aren't
+// requests. It's safe for concurrent use.
+type Audit struct {
+	mu      sync.Mutex
+	budget  int
+	sent    int
+	fetches []Fetch
+}
+
+// NewAudit returns an audit allowing up to budget requests, unbounded when zero or
+// negative.
+func NewAudit(budget int) *Audit {
+	return &Audit{budget: budget}
+}
+
+type auditKey struct{}
+
+// WithAudit returns a context whose requests are logged and bounded by audit.
+func WithAudit(ctx context.Context, audit *Audit) context.Context {
+	return context.WithValue(ctx, auditKey{}, audit)
+}
+
+// AuditFromContext returns the audit of ctx, nil when it has none.
+func AuditFromContext(ctx context.Context) *Audit {
+	audit, _ := ctx.Value(auditKey{}).(*Audit)
+	return audit
+}
+
+// Len returns the number of requests logged, a nil audit logs none.
+func (a *Audit) Len() int {
+	if a == nil {
+		return 0
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.fetches)
+}
+
+// Fetches returns the requests logged from the i-th on, in the order they were
+// answered.
+func (a *Audit) Fetches(i int) []Fetch {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if i >= len(a.fetches) {
+		return nil
+	}
+
+	return append([]Fetch(nil), a.fetches[i:]...)
+}
+
+// reserve counts n requests about to be sent, failing when they exceed the budget.
+// The requests of a nil audit are unbounded.
+func (a *Audit) reserve(n int) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.budget > 0 && a.sent+n > a.budget {
+		return fmt.Errorf("%w: %d requests sent of %d", ErrFetchBudgetExceeded, a.sent, a.budget)
+	}
+	a.sent += n
+
+	return nil
+}
+
+// log logs the request of method with params, answered with result after latency
+func (a *Audit) log(method string, params []interface{}, result []byte, latency time.Duration, err error) {
+	if a == nil {
+		return
+	}
+
+	fetch := Fetch{Method: method, Bytes: len(result), Latency: latency, Err: err}
+	switch method {
+	case "eth_getCode", "eth_getBalance", "eth_getTransactionCount", "eth_getProof":
+		fetch.Address = paramAddress(params, 0)
+		fetch.Block = paramString(params, len(params)-1)
+	case "eth_getStorageAt":
+		fetch.Address = paramAddress(params, 0)
+		if position := paramString(params, 1); position != "" {
+			slot := common.HexToHash(position)
+			fetch.Slot = &slot
+		}
+		fetch.Block = paramString(params, 2)
+	case "eth_getBlockByNumber":
+		fetch.Block = paramString(params, 0)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.fetches = append(a.fetches, fetch)
+}
+
+func paramString(params []interface{}, i int) string {
+	if i < 0 || i >= len(params) {
+		return ""
+	}
+
+	s, _ := params[i].(string)
+	return s
+}
+
+func paramAddress(params []interface{}, i int) *common.Address {
+	s := paramString(params, i)
+	if !common.IsHexAddress(s) {
+		return nil
+	}
+
+	addr := common.HexToAddress(s)
+	return &addr
+}
@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestAudit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// every slot holds its position
+		responses := make([]map[string]interface{}, len(requests))
+		for i, req := range requests {
+			responses[i] = map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": req.Params[1]}
+		}
+
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer srv.Close()
+
+	var (
+		clt       = NewClient(srv.URL, WithCache(NewCache(8)))
+		addr      = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		positions = []string{common.BigToHash(common.Big1).Hex(), common.BigToHash(common.Big2).Hex()}
+		audit     = NewAudit(3)
+		ctx       = WithAudit(context.Background(), audit)
+	)
+
+	// the cached slots aren't requests
+	for i := 0; i < 2; i++ {
+		if _, err := clt.GetStorageBatch(ctx, addr.Hex(), positions, "0x1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetches := audit.Fetches(0)
+	if len(fetches) != len(positions) {
+		t.Fatalf("fetches: %+v", fetches)
+	}
+	for i, fetch := range fetches {
+		if fetch.Method != "eth_getStorageAt" || *fetch.Address != addr || fetch.Slot.Hex() != positions[i] || fetch.Block != "0x1" || fetch.Bytes == 0 {
+			t.Errorf("fetch %d: %+v", i, fetch)
+		}
+	}
+
+	positions = append(positions, common.BigToHash(common.Big3).Hex(), common.HexToHash("0x04").Hex())
+	_, err := clt.GetStorageBatch(ctx, addr.Hex(), positions, "0x1")
+	if !errors.Is(err, ErrFetchBudgetExceeded) {
+		t.Fatalf("batch over budget failed with %v, want %v", err, ErrFetchBudgetExceeded)
+	}
+	if audit.Len() != 2 {
+		t.Errorf("batch over budget logged, %d fetches", audit.Len())
+	}
+}
@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// BatchElem is a single request of a batch. Result must be a pointer the result is
+// decoded into, Err is set when the node answered the request with an error.
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Err    error
+}
+
+// CodeElem requests the code of address, decoded into result.
+func CodeElem(address, blk string, result *hexutil.Bytes) BatchElem {
+	return BatchElem{Method: "eth_getCode", Params: []interface{}{address, blockParam(blk)}, Result: result}
+}
+
+// StorageAtElem requests the storage of address at position, decoded into result.
+func StorageAtElem(address, position, blk string, result *common.Hash) BatchElem {
+	return BatchElem{Method: "eth_getStorageAt", Params: []interface{}{address, position, blockParam(blk)}, Result: result}
+}
+
+// BalanceElem requests the balance of address, decoded into result.
+func BalanceElem(address, blk string, result *hexutil.Big) BatchElem {
+	return BatchElem{Method: "eth_getBalance", Params: []interface{}{address, blockParam(blk)}, Result: result}
+}
+
+// BatchCall sends all the elems in a single JSON-RPC batch request. An error is only
+// returned when the whole batch fails, the failure of each request is set in its Err.
+func (c *Client) BatchCall(ctx context.Context, elems []BatchElem) error {
+	if len(elems) == 0 {
+		return nil
+	}
+
+	requests := make([]RPCRequest, len(elems))
+	for i, elem := range elems {
+		requests[i] = RPCRequest{Method: elem.Method, Params: elem.Params}
+	}
+
+	responses, err := c.rpcPostBatch(ctx, requests)
+	if err != nil {
+		return err
+	}
+
+	for i, resp := range responses {
+		if resp.Err != nil {
+			elems[i].Err = fmt.Errorf("%w: %w", ErrRPCFetch, resp.Err)
+			continue
+		}
+
+		err = json.Unmarshal(resp.Result, elems[i].Result)
+		if err != nil {
+			elems[i].Err = fmt.Errorf("%w: %w", ErrRPCFetch, err)
+		}
+	}
+
+	return nil
+}
+
+// blockParam returns blk when it's a positive block number, latest otherwise
+func blockParam(blk string) string {
+	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
+		return "latest"
+	}
+
+	return blk
+}
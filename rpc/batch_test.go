@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestBatchCall(t *testing.T) {
+	var posts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+
+		var requests []RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// answer in reverse order, ids must be used to match the responses
+		responses := make([]map[string]interface{}, 0, len(requests))
+		for i := len(requests) - 1; i >= 0; i-- {
+			resp := map[string]interface{}{"jsonrpc": "2.0", "id": requests[i].ID}
+			switch requests[i].Method {
+			case "eth_getCode":
+				resp["result"] = "0x6001"
+			case "eth_getStorageAt":
+				resp["result"] = common.BigToHash(common.Big2).Hex()
+			case "eth_getBalance":
+				resp["error"] = map[string]interface{}{"code": -32000, "message": "header not found"}
+			}
+			responses = append(responses, resp)
+		}
+
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer srv.Close()
+
+	var (
+		clt     = NewClient(srv.URL)
+		addr    = "0x0000000000000000000000000000000000000011"
+		code    hexutil.Bytes
+		storage common.Hash
+		balance hexutil.Big
+		elems   = []BatchElem{
+			CodeElem(addr, "0x1", &code),
+			StorageAtElem(addr, common.Hash{}.Hex(), "0x1", &storage),
+			BalanceElem(addr, "0x1", &balance),
+		}
+	)
+
+	err := clt.BatchCall(context.Background(), elems)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if posts != 1 {
+		t.Fatalf("http requests: %d expected 1", posts)
+	}
+
+	if code.String() != "0x6001" || elems[0].Err != nil {
+		t.Fatalf("code: %s err: %v", code, elems[0].Err)
+	}
+
+	if storage != common.BigToHash(common.Big2) || elems[1].Err != nil {
+		t.Fatalf("storage: %s err: %v", storage.Hex(), elems[1].Err)
+	}
+
+	var rpcErr *ErrResponse
+	if !errors.Is(elems[2].Err, ErrRPCFetch) || !errors.As(elems[2].Err, &rpcErr) {
+		t.Fatalf("expected rpc error for balance got: %v", elems[2].Err)
+	}
+}
+
+func TestGetStorageBatch(t *testing.T) {
+	var batches [][]RPCRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		batches = append(batches, requests)
+
+		// every slot holds its position
+		responses := make([]map[string]interface{}, len(requests))
+		for i, req := range requests {
+			responses[i] = map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": req.Params[1]}
+		}
+
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer srv.Close()
+
+	var (
+		clt       = NewClient(srv.URL, WithCache(NewCache(8)))
+		addr      = "0x0000000000000000000000000000000000000011"
+		positions = []string{common.BigToHash(common.Big1).Hex(), common.BigToHash(common.Big2).Hex()}
+	)
+
+	// the cached slot isn't requested again
+	for _, positions := range [][]string{positions[:1], positions} {
+		values, err := clt.GetStorageBatch(context.Background(), addr, positions, "0x1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, position := range positions {
+			if values[i] != common.HexToHash(position) {
+				t.Fatalf("slot %s: %s", position, values[i].Hex())
+			}
+		}
+	}
+
+	if len(batches) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("batches: %v", batches)
+	}
+}
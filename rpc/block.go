@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrBlockNotFound is returned when the node doesn't know the requested block.
+var ErrBlockNotFound = errors.New("block not found")
+
+// BlockHeader holds the fields of a block header relevant for the block context
+// of a simulation, as returned by eth_getBlockByNumber.
+type BlockHeader struct {
+	Number        *hexutil.Big    `json:"number"`
+	Hash          common.Hash     `json:"hash"`
+	ParentHash    common.Hash     `json:"parentHash"`
+	StateRoot     common.Hash     `json:"stateRoot"`
+	Miner         common.Address  `json:"miner"`
+	Timestamp     hexutil.Uint64  `json:"timestamp"`
+	GasLimit      hexutil.Uint64  `json:"gasLimit"`
+	BaseFee       *hexutil.Big    `json:"baseFeePerGas"`
+	Difficulty    *hexutil.Big    `json:"difficulty"`
+	MixHash       common.Hash     `json:"mixHash"`
+	ExcessBlobGas *hexutil.Uint64 `json:"excessBlobGas"`
+}
+
+// EarliestBlock selects the genesis block, while a zero block number selects the
+// latest one as an empty block does.
+const EarliestBlock = "earliest"
+
+// GetBlockByNumber returns the header of the block, without its transactions.
+func (c *Client) GetBlockByNumber(ctx context.Context, blk string) (*BlockHeader, error) {
+	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	if blk != EarliestBlock && (!ok || blkNumber.Cmp(big.NewInt(0)) <= 0) {
+		blk = "latest"
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "eth_getBlockByNumber", []interface{}{blk, false})
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var header *BlockHeader
+	err = json.Unmarshal(rpcResp.Result, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	// unknown blocks are returned as null
+	if header == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, blk)
+	}
+
+	return header, nil
+}
+
+// GetBlockHash returns the hash of the block.
+func (c *Client) GetBlockHash(ctx context.Context, blk string) (common.Hash, error) {
+	header, err := c.GetBlockByNumber(ctx, blk)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	return header.Hash, nil
+}
+
+// GetHeader returns the whole header of the block, its hash can be computed with
+// Hash to check it against a trusted one.
+func (c *Client) GetHeader(ctx context.Context, blk string) (*types.Header, error) {
+	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
+		blk = "latest"
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "eth_getBlockByNumber", []interface{}{blk, false})
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	// unknown blocks are returned as null
+	if string(rpcResp.Result) == "null" {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, blk)
+	}
+
+	var header types.Header
+	err = json.Unmarshal(rpcResp.Result, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+// Block is a block with its transactions and withdrawals, as returned by
+// eth_getBlockByNumber with full transactions.
+type Block struct {
+	BlockHeader
+	Transactions []*Transaction      `json:"transactions"`
+	Withdrawals  []*types.Withdrawal `json:"withdrawals"`
+}
+
+// GetBlockWithTransactions returns the block with its transactions and withdrawals.
+func (c *Client) GetBlockWithTransactions(ctx context.Context, blk string) (*Block, error) {
+	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
+		blk = "latest"
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "eth_getBlockByNumber", []interface{}{blk, true})
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var block *Block
+	err = json.Unmarshal(rpcResp.Result, &block)
+	if err != nil {
+		return nil, err
+	}
+
+	// unknown blocks are returned as null
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, blk)
+	}
+
+	return block, nil
+}
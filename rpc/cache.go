@@ -0,0 +1,322 @@
+package rpc
+
+import (
+	"container/list"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// kinds of data held by the cache
+const (
+	cacheCode = iota
+	cacheBalance
+	cacheStorage
+	cacheFullStorage
+)
+
+type cacheKey struct {
+	kind    int
+	address common.Address
+	slot    common.Hash
+	blk     string
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	value interface{}
+}
+
+// Cache is an in-memory LRU cache of the code, balances and storage fetched at a
+// given block. It can be shared by several clients and it's safe for concurrent use.
+// Data at the latest block is never cached, as it changes with every block.
+//
+// The code is held once by code hash, so the same contract fetched at many blocks,
+// or deployed at many addresses, takes the memory of a single copy.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*list.Element
+	order   *list.List
+	// codes by code hash, the code entries hold the hash
+	codes *lru.Cache[common.Hash, []byte]
+}
+
+// NewCache returns a cache holding up to size entries.
+func NewCache(size int) *Cache {
+	return &Cache{
+		size:    size,
+		entries: make(map[cacheKey]*list.Element),
+		order:   list.New(),
+		codes:   lru.NewCache[common.Hash, []byte](size),
+	}
+}
+
+// WithCache makes the client look up code, balances and storage in cache before fetching them.
+func WithCache(cache *Cache) func(*Client) {
+	return func(c *Client) {
+		c.cache = cache
+	}
+}
+
+// Cache returns the cache of the client, nil when it has none.
+func (c *Client) Cache() *Cache {
+	return c.cache
+}
+
+// Cached returns a client with the endpoints and retries of c that uses cache.
+func (c *Client) Cached(cache *Cache) *Client {
+	clt := &Client{
+		Endpoint:   c.Endpoint,
+		retry:      c.retry,
+		endpoints:  c.endpoints,
+		roundRobin: c.roundRobin,
+		cache:      cache,
+		recorder:   c.recorder,
+		replay:     c.replay,
+		metrics:    c.metrics,
+	}
+	clt.current.Store(c.current.Load())
+
+	return clt
+}
+
+// Len returns the number of entries in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *Cache) get(key cacheKey) (interface{}, bool) {
+	if c == nil || key.blk == "latest" {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*cacheEntry).value, true
+}
+
+func (c *Cache) add(key cacheKey, value interface{}) {
+	if c == nil || key.blk == "latest" || c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Rebase caches the entries cached at the block from again at the block to, except the
+// ones modified by changes, the changes of the blocks after from up to to. It returns
+// the number of entries carried to to.
+func (c *Cache) Rebase(from, to string, changes *BlockChanges) int {
+	if c == nil {
+		return 0
+	}
+
+	from, to = cacheBlock(from), cacheBlock(to)
+	if from == "latest" || to == "latest" {
+		return 0
+	}
+
+	c.mu.Lock()
+	var carried []*cacheEntry
+	for key, elem := range c.entries {
+		if key.blk != from || changes.modify(key) {
+			continue
+		}
+
+		entry := elem.Value.(*cacheEntry)
+		key.blk = to
+		carried = append(carried, &cacheEntry{key: key, value: entry.value})
+	}
+	c.mu.Unlock()
+
+	// the values are never modified once cached, they can be shared
+	for _, entry := range carried {
+		c.add(entry.key, entry.value)
+	}
+
+	return len(carried)
+}
+
+// Drop removes the entries cached at the block blk, as after a reorganization
+// replacing it. It returns the number of entries removed.
+func (c *Cache) Drop(blk string) int {
+	if c == nil {
+		return 0
+	}
+
+	blk = cacheBlock(blk)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var dropped int
+	for key, elem := range c.entries {
+		if key.blk != blk {
+			continue
+		}
+
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		dropped++
+	}
+
+	return dropped
+}
+
+// cacheBlock normalizes blk so the same block is always cached under the same key
+func cacheBlock(blk string) string {
+	blk = blockParam(blk)
+	if blk == "latest" {
+		return blk
+	}
+
+	blkNumber, _ := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	return hexutil.EncodeBig(blkNumber)
+}
+
+func codeKey(address, blk string) cacheKey {
+	return cacheKey{kind: cacheCode, address: common.HexToAddress(address), blk: cacheBlock(blk)}
+}
+
+func balanceKey(address, blk string) cacheKey {
+	return cacheKey{kind: cacheBalance, address: common.HexToAddress(address), blk: cacheBlock(blk)}
+}
+
+func storageKey(address, position, blk string) cacheKey {
+	return cacheKey{kind: cacheStorage, address: common.HexToAddress(address), slot: common.HexToHash(position), blk: cacheBlock(blk)}
+}
+
+func fullStorageKey(address, blk string) cacheKey {
+	return cacheKey{kind: cacheFullStorage, address: common.HexToAddress(address), blk: cacheBlock(blk)}
+}
+
+// values are copied in and out of the cache so callers can't modify them
+
+func (c *Cache) code(address, blk string) ([]byte, bool) {
+	v, ok := c.get(codeKey(address, blk))
+	if !ok {
+		return nil, false
+	}
+
+	// the code may have been evicted before the entries holding its hash
+	code, ok := c.codes.Get(v.(common.Hash))
+	if !ok {
+		return nil, false
+	}
+
+	return common.CopyBytes(code), true
+}
+
+func (c *Cache) addCode(address, blk string, code []byte) {
+	if c == nil || cacheBlock(blk) == "latest" || c.size <= 0 {
+		return
+	}
+
+	hash := crypto.Keccak256Hash(code)
+	if _, ok := c.codes.Get(hash); !ok {
+		c.codes.Add(hash, common.CopyBytes(code))
+	}
+	c.add(codeKey(address, blk), hash)
+}
+
+func (c *Cache) balance(address, blk string) (*big.Int, bool) {
+	v, ok := c.get(balanceKey(address, blk))
+	if !ok {
+		return nil, false
+	}
+
+	return new(big.Int).Set(v.(*big.Int)), true
+}
+
+func (c *Cache) addBalance(address, blk string, balance *big.Int) {
+	c.add(balanceKey(address, blk), new(big.Int).Set(balance))
+}
+
+func (c *Cache) storage(address, position, blk string) (common.Hash, bool) {
+	v, ok := c.get(storageKey(address, position, blk))
+	if !ok {
+		return common.Hash{}, false
+	}
+
+	return v.(common.Hash), true
+}
+
+func (c *Cache) addStorage(address, position, blk string, storage common.Hash) {
+	c.add(storageKey(address, position, blk), storage)
+}
+
+// fullStorage is the whole storage of an account, or the number of slots it was found
+// to exceed when slots is nil
+type fullStorage struct {
+	slots    map[common.Hash]common.Hash
+	exceeded int
+}
+
+// fullStorage returns the whole storage of address, false when it isn't cached. The
+// storage is nil when it has more than maxSlots slots.
+func (c *Cache) fullStorage(address, blk string, maxSlots int) (map[common.Hash]common.Hash, bool) {
+	v, ok := c.get(fullStorageKey(address, blk))
+	if !ok {
+		return nil, false
+	}
+
+	full := v.(fullStorage)
+	switch {
+	case full.slots == nil:
+		// a larger limit may fit the storage
+		return nil, maxSlots <= full.exceeded
+	case len(full.slots) > maxSlots:
+		return nil, true
+	}
+
+	slots := make(map[common.Hash]common.Hash, len(full.slots))
+	for slot, value := range full.slots {
+		slots[slot] = value
+	}
+
+	return slots, true
+}
+
+// addFullStorage caches the whole storage of address, a nil storage records that it
+// has more than exceeded slots
+func (c *Cache) addFullStorage(address, blk string, slots map[common.Hash]common.Hash, exceeded int) {
+	full := fullStorage{exceeded: exceeded}
+	if slots != nil {
+		full.slots = make(map[common.Hash]common.Hash, len(slots))
+		for slot, value := range slots {
+			full.slots[slot] = value
+		}
+	}
+
+	c.add(fullStorageKey(address, blk), full)
+}
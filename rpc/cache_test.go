@@ -0,0 +1,131 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestCache(t *testing.T) {
+	posts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		posts[req.Method]++
+
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_getCode":
+			resp["result"] = "0x6001"
+		case "eth_getStorageAt":
+			resp["result"] = common.BigToHash(common.Big2).Hex()
+		case "eth_getBalance":
+			resp["result"] = "0x3"
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	var (
+		ctx   = context.Background()
+		cache = NewCache(2)
+		addr  = "0x0000000000000000000000000000000000000011"
+		slot  = common.Hash{}.Hex()
+	)
+
+	// the cache is shared by clients
+	for _, clt := range []*Client{NewClient(srv.URL, WithCache(cache)), NewClient(srv.URL, WithCache(cache))} {
+		code, err := clt.GetCode(ctx, addr, "0x1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// modifying the returned code doesn't change the cached one
+		code[0] = 0
+
+		storage, err := clt.GetStorageAt(ctx, addr, slot, "0x01")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if storage != common.BigToHash(common.Big2) {
+			t.Fatalf("storage: %s", storage.Hex())
+		}
+	}
+
+	code, err := NewClient(srv.URL, WithCache(cache)).GetCode(ctx, addr, "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code[0] != 0x60 {
+		t.Fatalf("cached code modified: %x", code)
+	}
+
+	if posts["eth_getCode"] != 1 || posts["eth_getStorageAt"] != 1 {
+		t.Fatalf("requests: %v", posts)
+	}
+
+	// the balance evicts the least recently used entry, the storage
+	clt := NewClient(srv.URL, WithCache(cache))
+	if _, err := clt.GetBalance(ctx, addr, "0x1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := clt.GetStorageAt(ctx, addr, slot, "0x1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Len() != 2 || posts["eth_getStorageAt"] != 2 {
+		t.Fatalf("cache entries: %d requests: %v", cache.Len(), posts)
+	}
+
+	// latest isn't cached
+	for i := 0; i < 2; i++ {
+		if _, err := clt.GetCode(ctx, addr, "latest"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if posts["eth_getCode"] != 3 {
+		t.Fatalf("requests: %v", posts)
+	}
+}
+
+func TestCacheCodeByHash(t *testing.T) {
+	var (
+		cache = NewCache(8)
+		code  = []byte{0x60, 0x01}
+		other = []byte{0x60, 0x02}
+	)
+
+	// the same code at two addresses and two blocks
+	for _, addr := range []string{"0x0000000000000000000000000000000000000011", "0x0000000000000000000000000000000000000012"} {
+		for _, blk := range []string{"0x1", "0x2"} {
+			cache.addCode(addr, blk, code)
+		}
+	}
+	cache.addCode("0x0000000000000000000000000000000000000013", "0x1", other)
+
+	if cache.Len() != 5 || cache.codes.Len() != 2 {
+		t.Fatalf("cache entries: %d codes: %d", cache.Len(), cache.codes.Len())
+	}
+
+	for addr, want := range map[string][]byte{
+		"0x0000000000000000000000000000000000000012": code,
+		"0x0000000000000000000000000000000000000013": other,
+	} {
+		got, ok := cache.code(addr, "0x1")
+		if !ok || string(got) != string(want) {
+			t.Fatalf("code of %s: %x expected %x", addr, got, want)
+		}
+	}
+}
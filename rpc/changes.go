@@ -0,0 +1,184 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// BlockChanges are the accounts and slots modified by one or more blocks.
+type BlockChanges struct {
+	// Accounts whose balance, nonce or code was modified
+	Accounts map[common.Address]struct{}
+	// Storage holds the slots modified by account, a nil set means any of its slots
+	// may have been
+	Storage map[common.Address]map[common.Hash]struct{}
+}
+
+// NewBlockChanges returns empty changes.
+func NewBlockChanges() *BlockChanges {
+	return &BlockChanges{
+		Accounts: make(map[common.Address]struct{}),
+		Storage:  make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+// Merge adds the changes of other to c.
+func (c *BlockChanges) Merge(other *BlockChanges) {
+	for addr := range other.Accounts {
+		c.Accounts[addr] = struct{}{}
+	}
+
+	for addr, slots := range other.Storage {
+		c.addSlots(addr, slots)
+	}
+}
+
+// addSlots marks slots of addr as modified, all of them when slots is nil
+func (c *BlockChanges) addSlots(addr common.Address, slots map[common.Hash]struct{}) {
+	modified, ok := c.Storage[addr]
+	switch {
+	case ok && modified == nil:
+		return
+	case slots == nil:
+		c.Storage[addr] = nil
+		return
+	case !ok:
+		modified = make(map[common.Hash]struct{}, len(slots))
+		c.Storage[addr] = modified
+	}
+
+	for slot := range slots {
+		modified[slot] = struct{}{}
+	}
+}
+
+// accountModified reports whether the balance, nonce or code of addr was modified
+func (c *BlockChanges) accountModified(addr common.Address) bool {
+	_, ok := c.Accounts[addr]
+	return ok
+}
+
+// storageModified reports whether the slot of addr was modified, any of its slots
+// when slot is nil
+func (c *BlockChanges) storageModified(addr common.Address, slot *common.Hash) bool {
+	modified, ok := c.Storage[addr]
+	if !ok {
+		return false
+	}
+
+	if modified == nil || slot == nil {
+		return true
+	}
+
+	_, ok = modified[*slot]
+	return ok
+}
+
+// modify reports whether the entry cached under key is modified by c
+func (c *BlockChanges) modify(key cacheKey) bool {
+	switch key.kind {
+	case cacheStorage:
+		return c.storageModified(key.address, &key.slot)
+	case cacheFullStorage:
+		return c.storageModified(key.address, nil)
+	default:
+		return c.accountModified(key.address)
+	}
+}
+
+// prestateAccount is an account in the result of the prestate tracer
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   *uint64                     `json:"nonce"`
+	Code    *hexutil.Bytes              `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// prestateDiff is the result of the prestate tracer in diff mode for a transaction
+type prestateDiff struct {
+	Pre  map[common.Address]*prestateAccount `json:"pre"`
+	Post map[common.Address]*prestateAccount `json:"post"`
+}
+
+// GetBlockChanges returns the accounts and slots modified by the block, from the
+// transactions traced with the prestate tracer of debug_traceBlockByNumber, the
+// withdrawals and the fees of the block. The slots of the EIP-4788 beacon roots
+// contract, written before the transactions, are all considered modified.
+func (c *Client) GetBlockChanges(ctx context.Context, blk string) (*BlockChanges, error) {
+	block, err := c.GetBlockWithTransactions(ctx, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	// traced by its number, as the block fetched
+	args := []interface{}{
+		hexutil.EncodeBig(block.Number.ToInt()),
+		map[string]interface{}{"tracer": "prestateTracer", "tracerConfig": map[string]interface{}{"diffMode": true}},
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "debug_traceBlockByNumber", args)
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var traces []struct {
+		Result prestateDiff `json:"result"`
+	}
+	err = json.Unmarshal(rpcResp.Result, &traces)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := NewBlockChanges()
+	changes.Accounts[block.Miner] = struct{}{}
+	changes.Storage[params.BeaconRootsAddress] = nil
+	for _, withdrawal := range block.Withdrawals {
+		changes.Accounts[withdrawal.Address] = struct{}{}
+	}
+
+	for _, trace := range traces {
+		for addr, pre := range trace.Result.Pre {
+			post, ok := trace.Result.Post[addr]
+			// the post state omits the accounts deleted
+			if !ok || post.Balance != nil || post.Nonce != nil || post.Code != nil {
+				changes.Accounts[addr] = struct{}{}
+			}
+
+			changes.addSlots(addr, slotSet(pre.Storage))
+			if !ok {
+				// a deleted account loses all its slots
+				changes.addSlots(addr, nil)
+			}
+		}
+
+		for addr, post := range trace.Result.Post {
+			if _, ok := trace.Result.Pre[addr]; !ok {
+				// created by the transaction
+				changes.Accounts[addr] = struct{}{}
+			}
+
+			changes.addSlots(addr, slotSet(post.Storage))
+		}
+	}
+
+	return changes, nil
+}
+
+// slotSet returns the slots of storage
+func slotSet(storage map[common.Hash]common.Hash) map[common.Hash]struct{} {
+	slots := make(map[common.Hash]struct{}, len(storage))
+	for slot := range storage {
+		slots[slot] = struct{}{}
+	}
+
+	return slots
+}
@@ -0,0 +1,56 @@
+package rpc
+
+// WithFallbackEndpoints adds endpoints the client fails over to when a request
+// to the current one fails with a retryable error, they're tried in the given order.
+func WithFallbackEndpoints(endpoints ...string) func(*Client) {
+	return func(c *Client) {
+		c.endpoints = append(c.endpoints, endpoints...)
+	}
+}
+
+// WithRoundRobin spreads the requests over all the endpoints of the client
+// instead of sticking to one until it fails.
+func WithRoundRobin() func(*Client) {
+	return func(c *Client) {
+		c.roundRobin = true
+	}
+}
+
+// Endpoints returns all the endpoints of the client, starting with Endpoint.
+func (c *Client) Endpoints() []string {
+	return append([]string(nil), c.allEndpoints()...)
+}
+
+// allEndpoints returns the endpoints the client fails over, starting with Endpoint
+// even when the client wasn't built by NewClient or Endpoint changed since
+func (c *Client) allEndpoints() []string {
+	if len(c.endpoints) == 0 {
+		return []string{c.Endpoint}
+	}
+
+	if c.endpoints[0] != c.Endpoint {
+		return append([]string{c.Endpoint}, c.endpoints[1:]...)
+	}
+
+	return c.endpoints
+}
+
+// firstEndpoint returns the index of the endpoint a new request starts with, out
+// of n endpoints
+func (c *Client) firstEndpoint(n uint64) uint64 {
+	if c.roundRobin {
+		return (c.current.Add(1) - 1) % n
+	}
+
+	return c.current.Load() % n
+}
+
+// failover moves the client away from the endpoint at index failed, out of n
+// endpoints, unless another request already did it
+func (c *Client) failover(failed, n uint64) {
+	if c.roundRobin {
+		return
+	}
+
+	c.current.CompareAndSwap(failed, (failed+1)%n)
+}
@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newEndpoint(t *testing.T, status int, posts *int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*posts++
+
+		var req RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": "0x6001"})
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestFailover(t *testing.T) {
+	var flakyPosts, healthyPosts int
+	flaky := newEndpoint(t, http.StatusTooManyRequests, &flakyPosts)
+	healthy := newEndpoint(t, http.StatusOK, &healthyPosts)
+
+	clt := NewClient(flaky.URL,
+		WithFallbackEndpoints(healthy.URL),
+		WithRetry(RetryConfig{MaxAttempts: 1}),
+	)
+
+	for i := 0; i < 3; i++ {
+		_, err := clt.GetCode(context.Background(), "0x0000000000000000000000000000000000000011", "0x1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// once failed the flaky endpoint is left alone
+	if flakyPosts != 1 || healthyPosts != 3 {
+		t.Fatalf("flaky requests: %d healthy requests: %d", flakyPosts, healthyPosts)
+	}
+}
+
+func TestFailoverAllEndpointsDown(t *testing.T) {
+	var firstPosts, secondPosts int
+	first := newEndpoint(t, http.StatusServiceUnavailable, &firstPosts)
+	second := newEndpoint(t, http.StatusBadGateway, &secondPosts)
+
+	clt := NewClient(first.URL,
+		WithFallbackEndpoints(second.URL),
+		WithRetry(RetryConfig{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+	)
+
+	_, err := clt.GetCode(context.Background(), "0x0000000000000000000000000000000000000011", "0x1")
+	if err == nil {
+		t.Fatal("expected error with all endpoints down")
+	}
+
+	if firstPosts != 2 || secondPosts != 2 {
+		t.Fatalf("first requests: %d second requests: %d", firstPosts, secondPosts)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	var firstPosts, secondPosts int
+	first := newEndpoint(t, http.StatusOK, &firstPosts)
+	second := newEndpoint(t, http.StatusOK, &secondPosts)
+
+	clt := NewClient(first.URL, WithFallbackEndpoints(second.URL), WithRoundRobin())
+	for i := 0; i < 4; i++ {
+		_, err := clt.GetCode(context.Background(), "0x0000000000000000000000000000000000000011", "0x1")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if firstPosts != 2 || secondPosts != 2 {
+		t.Fatalf("first requests: %d second requests: %d", firstPosts, secondPosts)
+	}
+}
+
+func TestClientLiteralEndpoint(t *testing.T) {
+	var oldPosts, literalPosts int
+	old := newEndpoint(t, http.StatusOK, &oldPosts)
+	literal := newEndpoint(t, http.StatusOK, &literalPosts)
+
+	clt := &Client{Endpoint: literal.URL}
+	if _, err := clt.GetCode(context.Background(), "0x0000000000000000000000000000000000000011", "0x1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the endpoint set after NewClient replaces the one it was built with
+	moved := NewClient(old.URL)
+	moved.Endpoint = literal.URL
+	if _, err := moved.GetCode(context.Background(), "0x0000000000000000000000000000000000000011", "0x1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if oldPosts != 0 || literalPosts != 2 {
+		t.Fatalf("old requests: %d literal requests: %d", oldPosts, literalPosts)
+	}
+
+	if endpoints := moved.Endpoints(); len(endpoints) != 1 || endpoints[0] != literal.URL {
+		t.Fatalf("endpoints: %v", endpoints)
+	}
+}
@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// FeeHistory is the fee market of a range of blocks, as returned by eth_feeHistory.
+// BaseFee has an entry more than the blocks, the base fee of the block following the
+// newest one. Reward holds, for every block, the priority fees paid at the requested
+// percentiles of its gas.
+type FeeHistory struct {
+	OldestBlock  *hexutil.Big     `json:"oldestBlock"`
+	BaseFee      []*hexutil.Big   `json:"baseFeePerGas"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+	Reward       [][]*hexutil.Big `json:"reward"`
+}
+
+// FeeHistory returns the fee market of the blockCount blocks up to newest, with the
+// priority fees at the given percentiles.
+func (c *Client) FeeHistory(ctx context.Context, blockCount uint64, newest string, percentiles []float64) (*FeeHistory, error) {
+	if percentiles == nil {
+		percentiles = []float64{}
+	}
+
+	params := []interface{}{
+		hexutil.EncodeUint64(blockCount), blockParam(newest), percentiles,
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "eth_feeHistory", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var history FeeHistory
+	err = json.Unmarshal(rpcResp.Result, &history)
+	if err != nil {
+		return nil, err
+	}
+
+	return &history, nil
+}
@@ -0,0 +1,211 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrNotRecorded is returned by replay clients for requests missing in their fixture.
+var ErrNotRecorded = errors.New("request not recorded")
+
+// Interaction is a request made to the node and its response.
+type Interaction struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Err    *ErrResponse    `json:"error,omitempty"`
+}
+
+// Fixture holds the interactions with a node, recorded by a client created WithRecorder
+// and served back by a client of NewReplayClient, so simulations can be reproduced
+// without the node. Requests at the latest block are recorded as they were answered,
+// pin a block to make them reproducible. It's safe for concurrent use.
+type Fixture struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	// index of the interaction of every request
+	index map[string]int
+}
+
+// NewFixture returns an empty fixture.
+func NewFixture() *Fixture {
+	return &Fixture{index: make(map[string]int)}
+}
+
+// LoadFixture reads a fixture saved at path.
+func LoadFixture(path string) (*Fixture, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var interactions []Interaction
+	err = json.Unmarshal(b, &interactions)
+	if err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", path, err)
+	}
+
+	f := NewFixture()
+	for _, interaction := range interactions {
+		f.add(interaction)
+	}
+
+	return f, nil
+}
+
+// Save writes the interactions of the fixture to path as JSON.
+func (f *Fixture) Save(path string) error {
+	f.mu.Lock()
+	b, err := json.MarshalIndent(f.interactions, "", "  ")
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// Interactions returns the interactions of the fixture, in the order they were recorded.
+func (f *Fixture) Interactions() []Interaction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Interaction(nil), f.interactions...)
+}
+
+// add records interaction, replacing the one of the same request
+func (f *Fixture) add(interaction Interaction) {
+	key := interactionKey(interaction.Method, interaction.Params)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if i, ok := f.index[key]; ok {
+		f.interactions[i] = interaction
+		return
+	}
+
+	f.index[key] = len(f.interactions)
+	f.interactions = append(f.interactions, interaction)
+}
+
+func (f *Fixture) get(method string, params json.RawMessage) (Interaction, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i, ok := f.index[interactionKey(method, params)]
+	if !ok {
+		return Interaction{}, false
+	}
+
+	return f.interactions[i], true
+}
+
+// record adds the responses of the node to the requests of payload
+func (f *Fixture) record(payload interface{}, b []byte) {
+	requests, batch := fixtureRequests(payload)
+
+	responses := make([]*RPCResponse, 0, len(requests))
+	if batch {
+		if json.Unmarshal(b, &responses) != nil {
+			return
+		}
+	} else {
+		var resp RPCResponse
+		if json.Unmarshal(b, &resp) != nil {
+			return
+		}
+		responses = append(responses, &resp)
+	}
+
+	byID := make(map[int]*RPCResponse, len(responses))
+	for _, resp := range responses {
+		byID[resp.ID] = resp
+	}
+
+	for _, req := range requests {
+		resp, ok := byID[req.ID]
+		// rate limits aren't answers of the node
+		if !ok || resp.Err != nil && isRateLimit(resp.Err) {
+			continue
+		}
+
+		params, err := json.Marshal(req.Params)
+		if err != nil {
+			continue
+		}
+
+		f.add(Interaction{Method: req.Method, Params: params, Result: resp.Result, Err: resp.Err})
+	}
+}
+
+// respond answers the requests of payload with the recorded responses
+func (f *Fixture) respond(payload interface{}) ([]byte, error) {
+	requests, batch := fixtureRequests(payload)
+
+	responses := make([]*RPCResponse, len(requests))
+	for i, req := range requests {
+		params, err := json.Marshal(req.Params)
+		if err != nil {
+			return nil, err
+		}
+
+		interaction, ok := f.get(req.Method, params)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.Method, params)
+		}
+
+		responses[i] = &RPCResponse{ID: req.ID, JSONRpc: "2.0", Result: interaction.Result, Err: interaction.Err}
+	}
+
+	if batch {
+		return json.Marshal(responses)
+	}
+
+	return json.Marshal(responses[0])
+}
+
+// fixtureRequests returns the requests of payload and whether they're a batch
+func fixtureRequests(payload interface{}) ([]RPCRequest, bool) {
+	switch payload := payload.(type) {
+	case *RPCRequest:
+		return []RPCRequest{*payload}, false
+	case []RPCRequest:
+		return payload, true
+	}
+
+	return nil, false
+}
+
+// interactionKey identifies a request, params are compacted so the indentation of
+// saved fixtures doesn't matter
+func interactionKey(method string, params json.RawMessage) string {
+	var compact []byte
+	if len(params) > 0 {
+		var v interface{}
+		if json.Unmarshal(params, &v) == nil {
+			compact, _ = json.Marshal(v)
+		}
+	}
+
+	return method + string(compact)
+}
+
+// WithRecorder makes the client add to fixture the responses of the node.
+func WithRecorder(fixture *Fixture) func(*Client) {
+	return func(c *Client) {
+		c.recorder = fixture
+	}
+}
+
+// NewReplayClient returns a client answering its requests with the responses of
+// fixture, without any node. Requests missing in the fixture fail with ErrNotRecorded.
+func NewReplayClient(fixture *Fixture, opts ...func(*Client)) *Client {
+	c := NewClient("replay", opts...)
+	c.replay = fixture
+
+	return c
+}
@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestFixtureRecordAndReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		respond := func(req RPCRequest) map[string]interface{} {
+			resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+			switch req.Method {
+			case "eth_getCode":
+				resp["result"] = "0x6001"
+			case "eth_getStorageAt":
+				resp["result"] = common.BigToHash(common.Big2).Hex()
+			default:
+				resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+			}
+
+			return resp
+		}
+
+		var requests []RPCRequest
+		if json.Unmarshal(raw, &requests) == nil {
+			responses := make([]map[string]interface{}, len(requests))
+			for i, req := range requests {
+				responses[i] = respond(req)
+			}
+			json.NewEncoder(w).Encode(responses)
+			return
+		}
+
+		var req RPCRequest
+		json.Unmarshal(raw, &req)
+		json.NewEncoder(w).Encode(respond(req))
+	}))
+	defer srv.Close()
+
+	var (
+		ctx     = context.Background()
+		addr    = "0x0000000000000000000000000000000000000011"
+		fixture = NewFixture()
+		clt     = NewClient(srv.URL, WithRecorder(fixture))
+	)
+
+	if _, err := clt.GetCode(ctx, addr, "0x1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var storage common.Hash
+	elems := []BatchElem{StorageAtElem(addr, common.Hash{}.Hex(), "0x1", &storage)}
+	if err := clt.BatchCall(ctx, elems); err != nil {
+		t.Fatal(err)
+	}
+
+	// errors of the node are recorded too
+	if _, err := clt.ChainID(ctx); err == nil {
+		t.Fatal("expected chain id to fail")
+	}
+
+	if n := len(fixture.Interactions()); n != 3 {
+		t.Fatalf("recorded %d interactions expected 3", n)
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := fixture.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Close()
+
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := NewReplayClient(fixture)
+
+	code, err := replay.GetCode(ctx, addr, "0x1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hexutil.Encode(code) != "0x6001" {
+		t.Fatalf("code: %x", code)
+	}
+
+	storage = common.Hash{}
+	elems = []BatchElem{StorageAtElem(addr, common.Hash{}.Hex(), "0x1", &storage)}
+	if err := replay.BatchCall(ctx, elems); err != nil {
+		t.Fatal(err)
+	}
+	if elems[0].Err != nil || storage != common.BigToHash(common.Big2) {
+		t.Fatalf("storage: %s %v", storage.Hex(), elems[0].Err)
+	}
+
+	var rpcErr *ErrResponse
+	if _, err := replay.ChainID(ctx); !errors.As(err, &rpcErr) || rpcErr.Code != -32601 {
+		t.Fatalf("expected recorded error, got %v", err)
+	}
+
+	if _, err := replay.GetCode(ctx, addr, "0x2"); !errors.Is(err, ErrNotRecorded) {
+		t.Fatalf("expected ErrNotRecorded, got %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package rpc
+
+import "github.com/Gealber/evm-simulator/metrics"
+
+// WithMetrics records the requests of the client and the lookups of its cache in m.
+func WithMetrics(m *metrics.Metrics) func(*Client) {
+	return func(c *Client) {
+		c.metrics = m
+	}
+}
+
+// observeCache records a lookup of the cache, lookups at the latest block aren't
+// as they are never cached
+func (c *Client) observeCache(kind, blk string, hit bool) {
+	if c.cache == nil || cacheBlock(blk) == "latest" {
+		return
+	}
+
+	c.metrics.ObserveCache(kind, hit)
+}
@@ -0,0 +1,252 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// StorageResult is the proof of a storage slot as returned by eth_getProof.
+type StorageResult struct {
+	Key   string       `json:"key"`
+	Value *hexutil.Big `json:"value"`
+	Proof []string     `json:"proof"`
+}
+
+// AccountResult is the proof of an account as returned by eth_getProof,
+// Code is filled by GetProofBatch.
+type AccountResult struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []string        `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []StorageResult `json:"storageProof"`
+	Code         []byte          `json:"-"`
+}
+
+// ProofBatch holds the proofs of several accounts at the same block.
+type ProofBatch struct {
+	StateRoot common.Hash
+	Accounts  []*AccountResult
+}
+
+// GetProofBatch fetches in a single batch request the header of the block, together with
+// the proof and code of every account in addrs, including the proofs of the given slots.
+func (c *Client) GetProofBatch(ctx context.Context, addrs map[common.Address][]common.Hash, blk string) (*ProofBatch, error) {
+	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
+		blk = "latest"
+	}
+
+	// keep the order of requests stable
+	accounts := make([]common.Address, 0, len(addrs))
+	for addr := range addrs {
+		accounts = append(accounts, addr)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return bytes.Compare(accounts[i][:], accounts[j][:]) < 0
+	})
+
+	requests := []RPCRequest{
+		{Method: "eth_getBlockByNumber", Params: []interface{}{blk, false}},
+	}
+	for _, addr := range accounts {
+		keys := make([]string, len(addrs[addr]))
+		for i, slot := range addrs[addr] {
+			keys[i] = slot.Hex()
+		}
+
+		requests = append(requests,
+			RPCRequest{Method: "eth_getProof", Params: []interface{}{addr.Hex(), keys, blk}},
+			RPCRequest{Method: "eth_getCode", Params: []interface{}{addr.Hex(), blk}},
+		)
+	}
+
+	responses, err := c.rpcPostBatch(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, resp := range responses {
+		if resp.Err != nil {
+			return nil, resp.Err
+		}
+	}
+
+	var header struct {
+		StateRoot common.Hash `json:"stateRoot"`
+	}
+	err = json.Unmarshal(responses[0].Result, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &ProofBatch{
+		StateRoot: header.StateRoot,
+		Accounts:  make([]*AccountResult, len(accounts)),
+	}
+	for i := range accounts {
+		var account AccountResult
+		err = json.Unmarshal(responses[1+2*i].Result, &account)
+		if err != nil {
+			return nil, err
+		}
+
+		var code hexutil.Bytes
+		err = json.Unmarshal(responses[2+2*i].Result, &code)
+		if err != nil {
+			return nil, err
+		}
+
+		account.Code = code
+		batch.Accounts[i] = &account
+	}
+
+	return batch, nil
+}
+
+// GetProof returns the proof of address, with the proofs of the slots at positions,
+// in the given block. Its Code isn't fetched.
+func (c *Client) GetProof(ctx context.Context, address string, positions []string, blk string) (*AccountResult, error) {
+	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
+		blk = "latest"
+	}
+
+	if positions == nil {
+		positions = []string{}
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "eth_getProof", []interface{}{address, positions, blk})
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var account AccountResult
+	err = json.Unmarshal(rpcResp.Result, &account)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// Verify checks the account, its code and storage proofs against the state root.
+func (r *AccountResult) Verify(stateRoot common.Hash) error {
+	value, err := verifyProof(stateRoot, crypto.Keccak256(r.Address.Bytes()), r.AccountProof)
+	if err != nil {
+		return fmt.Errorf("account %s: %w", r.Address.Hex(), err)
+	}
+
+	var balance *big.Int
+	if r.Balance != nil {
+		balance = r.Balance.ToInt()
+	} else {
+		balance = new(big.Int)
+	}
+
+	codeHash := r.CodeHash
+	if codeHash == (common.Hash{}) {
+		codeHash = types.EmptyCodeHash
+	}
+
+	storageHash := r.StorageHash
+	if storageHash == (common.Hash{}) {
+		storageHash = types.EmptyRootHash
+	}
+
+	if value == nil {
+		// proof of absence, the account must be empty
+		if balance.Sign() != 0 || r.Nonce != 0 || codeHash != types.EmptyCodeHash || storageHash != types.EmptyRootHash {
+			return fmt.Errorf("account %s: not in state but reported as non empty", r.Address.Hex())
+		}
+	} else {
+		var account types.StateAccount
+		err = rlp.DecodeBytes(value, &account)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", r.Address.Hex(), err)
+		}
+
+		if account.Nonce != uint64(r.Nonce) ||
+			account.Balance.ToBig().Cmp(balance) != 0 ||
+			common.BytesToHash(account.CodeHash) != codeHash ||
+			account.Root != storageHash {
+			return fmt.Errorf("account %s: reported fields don't match the proof", r.Address.Hex())
+		}
+	}
+
+	if crypto.Keccak256Hash(r.Code) != codeHash {
+		return fmt.Errorf("account %s: code doesn't match code hash", r.Address.Hex())
+	}
+
+	for _, storage := range r.StorageProof {
+		slot := common.HexToHash(storage.Key)
+		value, err := verifyProof(storageHash, crypto.Keccak256(slot.Bytes()), storage.Proof)
+		if err != nil {
+			return fmt.Errorf("slot %s of %s: %w", slot.Hex(), r.Address.Hex(), err)
+		}
+
+		var content []byte
+		if value != nil {
+			_, content, _, err = rlp.Split(value)
+			if err != nil {
+				return fmt.Errorf("slot %s of %s: %w", slot.Hex(), r.Address.Hex(), err)
+			}
+		}
+
+		reported := new(big.Int)
+		if storage.Value != nil {
+			reported = storage.Value.ToInt()
+		}
+
+		if new(big.Int).SetBytes(content).Cmp(reported) != 0 {
+			return fmt.Errorf("slot %s of %s: reported value doesn't match the proof", slot.Hex(), r.Address.Hex())
+		}
+	}
+
+	return nil
+}
+
+func verifyProof(root common.Hash, key []byte, proof []string) ([]byte, error) {
+	if len(proof) == 0 {
+		if root == types.EmptyRootHash {
+			return nil, nil
+		}
+
+		return nil, errors.New("missing proof")
+	}
+
+	proofDB := memorydb.New()
+	for _, node := range proof {
+		b, err := hexutil.Decode(node)
+		if err != nil {
+			return nil, err
+		}
+
+		err = proofDB.Put(crypto.Keccak256(b), b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return trie.VerifyProof(root, key, proofDB)
+}
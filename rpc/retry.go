@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"time"
+)
+
+// JSON-RPC error codes used by providers to signal rate limiting
+const (
+	codeLimitExceeded  = -32005
+	codeTooManyRequest = 429
+)
+
+// RetryConfig controls how requests failing with a transient error are retried.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, doubled on each retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter randomizes each delay by up to the given fraction of it, between 0 and 1.
+	Jitter float64
+	// Retryable classifies the errors worth retrying, IsRetryable when nil.
+	Retryable func(error) bool
+}
+
+// DefaultRetryConfig is the retry configuration of clients created with NewClient.
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+	Jitter:      0.2,
+}
+
+// WithRetry replaces the retry configuration of the client.
+func WithRetry(cfg RetryConfig) func(*Client) {
+	return func(c *Client) {
+		c.retry = cfg
+	}
+}
+
+// HTTPError is returned when the node answers with a status code that doesn't
+// carry a JSON-RPC response, like 429 or 503.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected http status %d: %s", e.StatusCode, e.Body)
+}
+
+// IsRetryable reports whether err is a transient failure of the node or the transport:
+// rate limits, 5xx responses and connection errors. Errors of the context and
+// errors returned by the node for the request itself are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return true
+	}
+
+	var rpcErr *ErrResponse
+	if errors.As(err, &rpcErr) {
+		return isRateLimit(rpcErr)
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+func isRateLimit(err *ErrResponse) bool {
+	return err.Code == codeLimitExceeded || err.Code == codeTooManyRequest
+}
+
+// withRetry calls f until it succeeds, fails with a non retryable error or runs out of attempts.
+// On each attempt every endpoint of the client is tried once, failing over to the next
+// one on retryable errors, the delay is only waited once all of them failed.
+func (c *Client) withRetry(ctx context.Context, f func(endpoint string) error) error {
+	retryable := c.retry.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	endpoints := c.allEndpoints()
+	n := uint64(len(endpoints))
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		idx := c.firstEndpoint(n)
+		for i := 0; i < len(endpoints); i++ {
+			err = f(endpoints[idx])
+			if err == nil || !retryable(err) {
+				return err
+			}
+
+			logger.WarnContext(ctx, "request to the node failed", "endpoint", endpoints[idx], "attempt", attempt+1, "error", err)
+			c.failover(idx, n)
+			idx = (idx + 1) % n
+		}
+
+		if attempt+1 >= c.retry.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.retry.delay(attempt)):
+		}
+	}
+}
+
+// delay returns the wait before the retry following the given attempt
+func (r RetryConfig) delay(attempt int) time.Duration {
+	d := r.BaseDelay << attempt
+	if d <= 0 || (r.MaxDelay > 0 && d > r.MaxDelay) {
+		d = r.MaxDelay
+	}
+
+	if r.Jitter > 0 && d > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * r.Jitter * float64(d))
+	}
+
+	return d
+}
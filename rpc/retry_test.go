@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Jitter: 0.5}
+
+	tests := []struct {
+		name     string
+		failures int
+		fail     func(w http.ResponseWriter, id int)
+		posts    int
+		err      error
+	}{
+		{
+			name:     "retries 429",
+			failures: 2,
+			fail: func(w http.ResponseWriter, id int) {
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+			},
+			posts: 3,
+		},
+		{
+			name:     "retries rate limit error",
+			failures: 1,
+			fail: func(w http.ResponseWriter, id int) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"jsonrpc": "2.0", "id": id,
+					"error": map[string]interface{}{"code": codeLimitExceeded, "message": "limit exceeded"},
+				})
+			},
+			posts: 2,
+		},
+		{
+			name:     "runs out of attempts",
+			failures: 5,
+			fail: func(w http.ResponseWriter, id int) {
+				http.Error(w, "bad gateway", http.StatusBadGateway)
+			},
+			posts: 3,
+			err:   ErrRPCFetch,
+		},
+		{
+			name:     "execution errors are not retried",
+			failures: 5,
+			fail: func(w http.ResponseWriter, id int) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"jsonrpc": "2.0", "id": id,
+					"error": map[string]interface{}{"code": -32000, "message": "header not found"},
+				})
+			},
+			posts: 1,
+			err:   ErrRPCFetch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var posts int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				posts++
+
+				var req RPCRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				if posts <= tt.failures {
+					tt.fail(w, req.ID)
+					return
+				}
+
+				json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": "0x6001"})
+			}))
+			defer srv.Close()
+
+			code, err := NewClient(srv.URL, WithRetry(cfg)).GetCode(context.Background(), "0x0000000000000000000000000000000000000011", "0x1")
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v got: %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if len(code) != 2 {
+				t.Fatalf("code: %x", code)
+			}
+
+			if posts != tt.posts {
+				t.Fatalf("http requests: %d expected %d", posts, tt.posts)
+			}
+		})
+	}
+}
+
+func TestRetryStopsOnContextDone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	clt := NewClient(srv.URL, WithRetry(RetryConfig{MaxAttempts: 10, BaseDelay: time.Second}))
+	_, err := clt.GetCode(ctx, "0x0000000000000000000000000000000000000011", "0x1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded got: %v", err)
+	}
+}
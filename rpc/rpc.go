@@ -2,41 +2,93 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Gealber/evm-simulator/logging"
+	"github.com/Gealber/evm-simulator/metrics"
 )
 
+var logger = logging.Logger(logging.RPC)
+
+// ErrRPCFetch wraps the errors caused by the node or the transport while fetching
+// state, as opposed to errors of the execution.
+var ErrRPCFetch = errors.New("rpc fetch failed")
+
 type Client struct {
+	// Endpoint is the primary endpoint of the client
 	Endpoint string
+
+	retry RetryConfig
+	// endpoints the client fails over, the first one is Endpoint
+	endpoints  []string
+	current    atomic.Uint64
+	roundRobin bool
+	// cache of fetched state, nil when disabled
+	cache *Cache
+	// recorder gets the responses of the node when set
+	recorder *Fixture
+	// replay answers the requests instead of the node when set
+	replay *Fixture
+	// metrics of the requests and the cache, nil when disabled
+	metrics *metrics.Metrics
 }
 
-func NewClient(endpoint string) *Client {
-	return &Client{Endpoint: endpoint}
+func NewClient(endpoint string, opts ...func(*Client)) *Client {
+	c := &Client{
+		Endpoint:  endpoint,
+		retry:     DefaultRetryConfig,
+		endpoints: []string{endpoint},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
-func (c *Client) GetCode(address, blk string) ([]byte, error) {
+// GetCode returns the code of address at the given block.
+func (c *Client) GetCode(ctx context.Context, address, blk string) ([]byte, error) {
 	// try to convert block into number
 	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
 	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
 		blk = "latest"
 	}
 
+	code, ok := c.cache.code(address, blk)
+	c.observeCache("code", blk, ok)
+	if ok {
+		return code, nil
+	}
+
 	params := []interface{}{
 		address, blk,
 	}
 
-	rpcResp, err := rpcPost(c.Endpoint, "eth_getCode", params)
+	rpcResp, err := c.rpcPost(ctx, "eth_getCode", params)
 	if err != nil {
 		return nil, err
 	}
 
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
 	resultB, _ := rpcResp.Result.MarshalJSON()
 
 	var result string
@@ -45,24 +97,38 @@ func (c *Client) GetCode(address, blk string) ([]byte, error) {
 		return nil, err
 	}
 
-	return hexutil.MustDecode(result), nil
+	code = hexutil.MustDecode(result)
+	c.cache.addCode(address, blk, code)
+
+	return code, nil
 }
 
-func (c *Client) GetStorageAt(address, position, blk string) (common.Hash, error) {
+// GetStorageAt returns the storage of address at position in the given block.
+func (c *Client) GetStorageAt(ctx context.Context, address, position, blk string) (common.Hash, error) {
 	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
 	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
 		blk = "latest"
 	}
 
+	storage, ok := c.cache.storage(address, position, blk)
+	c.observeCache("storage", blk, ok)
+	if ok {
+		return storage, nil
+	}
+
 	params := []interface{}{
 		address, position, blk,
 	}
 
-	rpcResp, err := rpcPost(c.Endpoint, "eth_getStorageAt", params)
+	rpcResp, err := c.rpcPost(ctx, "eth_getStorageAt", params)
 	if err != nil {
 		return common.Hash{}, err
 	}
 
+	if rpcResp.Err != nil {
+		return common.Hash{}, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
 	resultB, _ := rpcResp.Result.MarshalJSON()
 
 	var result string
@@ -71,39 +137,126 @@ func (c *Client) GetStorageAt(address, position, blk string) (common.Hash, error
 		return common.Hash{}, err
 	}
 
-	return common.HexToHash(result), nil
+	storage = common.HexToHash(result)
+	c.cache.addStorage(address, position, blk, storage)
+
+	return storage, nil
 }
 
-func (c *Client) GetCodeAndStorageAt(address, position, blk string) ([]byte, common.Hash, error) {
-	// fetch code and storage
-	code, err := c.GetCode(address, blk)
-	if err != nil {
-		return nil, common.Hash{}, err
+// GetStorageBatch returns the storage of address at every position in the given
+// block, the positions not cached are fetched in a single batch request.
+func (c *Client) GetStorageBatch(ctx context.Context, address string, positions []string, blk string) ([]common.Hash, error) {
+	blk = blockParam(blk)
+
+	var (
+		values  = make([]common.Hash, len(positions))
+		elems   []BatchElem
+		missing []int
+	)
+
+	for i, position := range positions {
+		storage, ok := c.cache.storage(address, position, blk)
+		c.observeCache("storage", blk, ok)
+		if ok {
+			values[i] = storage
+			continue
+		}
+
+		elems = append(elems, StorageAtElem(address, position, blk, &values[i]))
+		missing = append(missing, i)
+	}
+
+	if err := c.BatchCall(ctx, elems); err != nil {
+		return nil, err
+	}
+
+	for j, elem := range elems {
+		if elem.Err != nil {
+			return nil, elem.Err
+		}
+
+		i := missing[j]
+		c.cache.addStorage(address, positions[i], blk, values[i])
 	}
 
-	storage, err := c.GetStorageAt(address, position, blk)
+	return values, nil
+}
+
+// GetCodeAndStorageAt returns both the code and the storage at position of address.
+func (c *Client) GetCodeAndStorageAt(ctx context.Context, address, position, blk string) ([]byte, common.Hash, error) {
+	cachedCode, codeOk := c.cache.code(address, blk)
+	cachedStorage, storageOk := c.cache.storage(address, position, blk)
+	// the lookups missed are observed by GetCode and GetStorageAt
+	switch {
+	case codeOk && storageOk:
+		c.observeCache("code", blk, true)
+		c.observeCache("storage", blk, true)
+		return cachedCode, cachedStorage, nil
+	case codeOk:
+		c.observeCache("code", blk, true)
+		storage, err := c.GetStorageAt(ctx, address, position, blk)
+		return cachedCode, storage, err
+	case storageOk:
+		c.observeCache("storage", blk, true)
+		code, err := c.GetCode(ctx, address, blk)
+		return code, cachedStorage, err
+	}
+	c.observeCache("code", blk, false)
+	c.observeCache("storage", blk, false)
+
+	// fetch code and storage in a single request
+	var (
+		code    hexutil.Bytes
+		storage common.Hash
+		elems   = []BatchElem{
+			CodeElem(address, blk, &code),
+			StorageAtElem(address, position, blk, &storage),
+		}
+	)
+
+	err := c.BatchCall(ctx, elems)
 	if err != nil {
 		return nil, common.Hash{}, err
 	}
 
+	for _, elem := range elems {
+		if elem.Err != nil {
+			return nil, common.Hash{}, elem.Err
+		}
+	}
+
+	c.cache.addCode(address, blk, code)
+	c.cache.addStorage(address, position, blk, storage)
+
 	return code, storage, nil
 }
 
-func (c *Client) GetBalance(address, blk string) (*big.Int, error) {
+// GetBalance returns the balance of address at the given block.
+func (c *Client) GetBalance(ctx context.Context, address, blk string) (*big.Int, error) {
 	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
 	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
 		blk = "latest"
 	}
 
+	balance, ok := c.cache.balance(address, blk)
+	c.observeCache("balance", blk, ok)
+	if ok {
+		return balance, nil
+	}
+
 	params := []interface{}{
 		address, blk,
 	}
 
-	rpcResp, err := rpcPost(c.Endpoint, "eth_getBalance", params)
+	rpcResp, err := c.rpcPost(ctx, "eth_getBalance", params)
 	if err != nil {
 		return nil, err
 	}
 
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
 	resultB, _ := rpcResp.Result.MarshalJSON()
 
 	var result string
@@ -112,10 +265,11 @@ func (c *Client) GetBalance(address, blk string) (*big.Int, error) {
 		return nil, err
 	}
 
-	balance, ok := new(big.Int).SetString(result[2:], 16)
+	balance, ok = new(big.Int).SetString(result[2:], 16)
 	if !ok {
 		return nil, fmt.Errorf("invalid balance received in response: %s", result)
 	}
+	c.cache.addBalance(address, blk, balance)
 
 	return balance, nil
 }
@@ -143,7 +297,179 @@ func (e *ErrResponse) Error() string {
 	return fmt.Sprintf(`{"code": "%d", "message": "%s"}`, e.Code, e.Message)
 }
 
-func rpcPost(rpcEndpoint, method string, params []interface{}) (*RPCResponse, error) {
+// Call executes a message call with data against the address to, without creating
+// a transaction on the chain.
+func (c *Client) Call(ctx context.Context, to, data, blk string) ([]byte, error) {
+	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
+		blk = "latest"
+	}
+
+	params := []interface{}{
+		map[string]string{
+			"to":   to,
+			"data": data,
+		},
+		blk,
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "eth_call", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, rpcResp.Err
+	}
+
+	var result string
+	err = json.Unmarshal(rpcResp.Result, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return hexutil.Decode(result)
+}
+
+// CreateAccessList asks the node for the access list of a message call from from to
+// to with data and value, through eth_createAccessList. The list is returned even
+// when the call reverts on the node.
+func (c *Client) CreateAccessList(ctx context.Context, from, to, data string, value *big.Int, blk string) (types.AccessList, error) {
+	msg := map[string]string{
+		"from": from,
+		"to":   to,
+		"data": data,
+	}
+	if value != nil {
+		msg["value"] = hexutil.EncodeBig(value)
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "eth_createAccessList", []interface{}{msg, blockParam(blk)})
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, rpcResp.Err
+	}
+
+	var result struct {
+		AccessList types.AccessList `json:"accessList"`
+	}
+	err = json.Unmarshal(rpcResp.Result, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.AccessList, nil
+}
+
+// GetTransactionCount returns the nonce of address at the given block.
+func (c *Client) GetTransactionCount(ctx context.Context, address, blk string) (uint64, error) {
+	blkNumber, ok := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	if !ok || blkNumber.Cmp(big.NewInt(0)) <= 0 {
+		blk = "latest"
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "eth_getTransactionCount", []interface{}{address, blk})
+	if err != nil {
+		return 0, err
+	}
+
+	if rpcResp.Err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var result hexutil.Uint64
+	err = json.Unmarshal(rpcResp.Result, &result)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(result), nil
+}
+
+// GetNonce returns the nonce of address at the given block, as GetTransactionCount.
+func (c *Client) GetNonce(ctx context.Context, address, blk string) (uint64, error) {
+	return c.GetTransactionCount(ctx, address, blk)
+}
+
+// ChainID returns the chain id of the node, as used for replay protection.
+func (c *Client) ChainID(ctx context.Context) (*big.Int, error) {
+	rpcResp, err := c.rpcPost(ctx, "eth_chainId", []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var result hexutil.Big
+	err = json.Unmarshal(rpcResp.Result, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.ToInt(), nil
+}
+
+// BlockNumber returns the number of the latest block.
+func (c *Client) BlockNumber(ctx context.Context) (*big.Int, error) {
+	rpcResp, err := c.rpcPost(ctx, "eth_blockNumber", []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var result hexutil.Big
+	err = json.Unmarshal(rpcResp.Result, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.ToInt(), nil
+}
+
+// GasPrice returns the current gas price suggested by the node.
+func (c *Client) GasPrice(ctx context.Context) (*big.Int, error) {
+	rpcResp, err := c.rpcPost(ctx, "eth_gasPrice", []interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, rpcResp.Err
+	}
+
+	var result hexutil.Big
+	err = json.Unmarshal(rpcResp.Result, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.ToInt(), nil
+}
+
+// Request sends the request of method with params to the node and returns its raw
+// result, errors of the node are returned as *ErrResponse.
+func (c *Client) Request(ctx context.Context, method string, params []interface{}) (json.RawMessage, error) {
+	rpcResp, err := c.rpcPost(ctx, method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, rpcResp.Err
+	}
+
+	return rpcResp.Result, nil
+}
+
+func (c *Client) rpcPost(ctx context.Context, method string, params []interface{}) (*RPCResponse, error) {
 	payload := RPCRequest{
 		ID:      1,
 		JSONRpc: "2.0",
@@ -151,25 +477,177 @@ func rpcPost(rpcEndpoint, method string, params []interface{}) (*RPCResponse, er
 		Params:  params,
 	}
 
-	data, err := json.Marshal(&payload)
+	audit := AuditFromContext(ctx)
+	if err := audit.reserve(1); err != nil {
+		return nil, err
+	}
+
+	ctx, span := otelTracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.method", method),
+	))
+
+	start := time.Now()
+	var result RPCResponse
+	err := c.withRetry(ctx, func(endpoint string) error {
+		b, err := c.post(ctx, endpoint, &payload)
+		if err != nil {
+			return err
+		}
+
+		result = RPCResponse{}
+		err = json.Unmarshal(b, &result)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrRPCFetch, err)
+		}
+
+		// rate limits are reported as errors so they can be retried
+		if result.Err != nil && isRateLimit(result.Err) {
+			return fmt.Errorf("%w: %w", ErrRPCFetch, result.Err)
+		}
+
+		return nil
+	})
+	// errors answered by the node are failed requests as well
+	rpcErr := err
+	if rpcErr == nil && result.Err != nil {
+		rpcErr = result.Err
+	}
+	c.metrics.ObserveRPC(method, start, rpcErr)
+	audit.log(method, params, result.Result, time.Since(start), rpcErr)
+	logger.DebugContext(ctx, "request", "method", method, "duration", time.Since(start), "error", rpcErr)
+	endSpan(span, rpcErr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// rpcPostBatch sends all the requests in a single JSON-RPC batch, the responses
+// are returned in the same order as the requests.
+func (c *Client) rpcPostBatch(ctx context.Context, requests []RPCRequest) ([]*RPCResponse, error) {
+	for i := range requests {
+		requests[i].ID = i
+		requests[i].JSONRpc = "2.0"
+	}
+
+	audit := AuditFromContext(ctx)
+	if err := audit.reserve(len(requests)); err != nil {
+		return nil, err
+	}
+
+	ctx, span := otelTracer.Start(ctx, "batch", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.Int("rpc.batch_size", len(requests)),
+	))
+
+	start := time.Now()
+	var responses []*RPCResponse
+	err := c.withRetry(ctx, func(endpoint string) error {
+		b, err := c.post(ctx, endpoint, requests)
+		if err != nil {
+			return err
+		}
+
+		responses = nil
+		err = json.Unmarshal(b, &responses)
+		if err != nil {
+			// a batch rejected as a whole is answered with a single error
+			var single RPCResponse
+			if json.Unmarshal(b, &single) == nil && single.Err != nil {
+				return fmt.Errorf("%w: %w", ErrRPCFetch, single.Err)
+			}
+
+			return fmt.Errorf("%w: %w", ErrRPCFetch, err)
+		}
+
+		return nil
+	})
+	if c.metrics != nil {
+		methods := make([]string, len(requests))
+		for i := range requests {
+			methods[i] = requests[i].Method
+		}
+		c.metrics.ObserveBatch(methods, start, err)
+	}
+	latency := time.Since(start)
+	logger.DebugContext(ctx, "batch request", "requests", len(requests), "duration", latency, "error", err)
+	endSpan(span, err)
+	if err != nil {
+		for i := range requests {
+			audit.log(requests[i].Method, requests[i].Params, nil, latency, err)
+		}
+		return nil, err
+	}
+
+	// responses of a batch can come in any order
+	result := make([]*RPCResponse, len(requests))
+	for _, resp := range responses {
+		if resp.ID < 0 || resp.ID >= len(requests) {
+			return nil, fmt.Errorf("unexpected response id %d in batch", resp.ID)
+		}
+		result[resp.ID] = resp
+	}
+
+	for i, resp := range result {
+		if resp == nil {
+			return nil, fmt.Errorf("missing response for %s in batch", requests[i].Method)
+		}
+	}
+
+	for i, resp := range result {
+		var respErr error
+		if resp.Err != nil {
+			respErr = resp.Err
+		}
+		audit.log(requests[i].Method, requests[i].Params, resp.Result, latency, respErr)
+	}
+
+	return result, nil
+}
+
+func (c *Client) post(ctx context.Context, endpoint string, payload interface{}) ([]byte, error) {
+	if c.replay != nil {
+		return c.replay.respond(payload)
+	}
+
+	data, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
 	body := bytes.NewBuffer(data)
 
-	resp, err := http.Post(rpcEndpoint, "application/json", body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// the context being done isn't a failure of the node
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, err)
+	}
 	defer resp.Body.Close()
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, err)
 	}
 
-	var result RPCResponse
-	err = json.Unmarshal(b, &result)
+	// overloaded nodes and gateways don't answer with a JSON-RPC response
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, &HTTPError{StatusCode: resp.StatusCode, Body: string(b)})
+	}
+
+	if c.recorder != nil {
+		c.recorder.record(payload, b)
+	}
 
-	return &result, err
+	return b, nil
 }
@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// StorageEntry is a slot of a StorageRangeResult, Key is nil when the node doesn't
+// know the slot hashing to it.
+type StorageEntry struct {
+	Key   *common.Hash `json:"key"`
+	Value common.Hash  `json:"value"`
+}
+
+// StorageRangeResult is a page of the storage of an account, by the hash of its slots,
+// as returned by debug_storageRangeAt. NextKey is the hash the next page starts at,
+// nil on the last page.
+type StorageRangeResult struct {
+	Storage map[common.Hash]StorageEntry `json:"storage"`
+	NextKey *common.Hash                 `json:"nextKey"`
+}
+
+// StorageRangeAt returns up to maxResult slots of address, starting at the slot hashing
+// to start, in the state before the transaction txIndex of the block is executed.
+func (c *Client) StorageRangeAt(ctx context.Context, blockHash common.Hash, txIndex int, address common.Address, start common.Hash, maxResult int) (*StorageRangeResult, error) {
+	params := []interface{}{
+		blockHash, txIndex, address, start, maxResult,
+	}
+
+	rpcResp, err := c.rpcPost(ctx, "debug_storageRangeAt", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if rpcResp.Err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	var result StorageRangeResult
+	err = json.Unmarshal(rpcResp.Result, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetFullStorage returns the whole storage of address at the given block, as read
+// slot by slot with GetStorageAt, paging through it with debug_storageRangeAt. It
+// returns false when the account has more than maxSlots slots, or when its storage
+// can't be listed: the state at the end of a block is the one before the first
+// transaction of the next block, so it's not available for the latest block, and the
+// node must know the slots hashing to the keys of the storage trie.
+func (c *Client) GetFullStorage(ctx context.Context, address, blk string, maxSlots int) (map[common.Hash]common.Hash, bool, error) {
+	blk = blockParam(blk)
+	if blk == "latest" || maxSlots <= 0 {
+		return nil, false, nil
+	}
+
+	if storage, ok := c.cache.fullStorage(address, blk, maxSlots); ok {
+		c.observeCache("full_storage", blk, true)
+		return storage, storage != nil, nil
+	}
+	c.observeCache("full_storage", blk, false)
+
+	blkNumber, _ := new(big.Int).SetString(strings.TrimLeft(blk, "0x"), 16)
+	next, err := c.GetBlockByNumber(ctx, hexutil.EncodeBig(blkNumber.Add(blkNumber, common.Big1)))
+	if errors.Is(err, ErrBlockNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var (
+		addr    = common.HexToAddress(address)
+		storage = make(map[common.Hash]common.Hash)
+		start   common.Hash
+	)
+	for {
+		// one more slot than allowed tells the storage is too large
+		page, err := c.StorageRangeAt(ctx, next.Hash, 0, addr, start, maxSlots+1-len(storage))
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, entry := range page.Storage {
+			if entry.Key == nil {
+				return nil, false, nil
+			}
+			storage[*entry.Key] = entry.Value
+		}
+
+		if len(storage) > maxSlots {
+			c.cache.addFullStorage(address, blk, nil, maxSlots)
+			return nil, false, nil
+		}
+
+		if page.NextKey == nil {
+			break
+		}
+		start = *page.NextKey
+	}
+
+	c.cache.addFullStorage(address, blk, storage, 0)
+	for slot, value := range storage {
+		c.cache.addStorage(address, slot.Hex(), blk, value)
+	}
+
+	return storage, true, nil
+}
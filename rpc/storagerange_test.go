@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestGetFullStorage(t *testing.T) {
+	var (
+		blockHash = common.HexToHash("0xb1")
+		storage   = map[common.Hash]common.Hash{
+			common.BigToHash(common.Big0): common.BigToHash(common.Big1),
+			common.BigToHash(common.Big1): common.BigToHash(common.Big2),
+			common.BigToHash(common.Big2): common.BigToHash(common.Big3),
+		}
+		// slots ordered by their hash, as in the storage trie
+		hashes = make([]common.Hash, 0, len(storage))
+		slots  = make(map[common.Hash]common.Hash)
+	)
+	for slot := range storage {
+		hash := crypto.Keccak256Hash(slot[:])
+		hashes = append(hashes, hash)
+		slots[hash] = slot
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	posts := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		posts[req.Method]++
+
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "eth_getBlockByNumber":
+			// the state at the end of 0x10 is read at the start of 0x11
+			if req.Params[0] != "0x11" {
+				resp["result"] = nil
+				break
+			}
+			resp["result"] = map[string]interface{}{"number": "0x11", "hash": blockHash}
+		case "debug_storageRangeAt":
+			if common.HexToHash(req.Params[0].(string)) != blockHash || req.Params[1].(float64) != 0 {
+				http.Error(w, "unexpected state", http.StatusBadRequest)
+				return
+			}
+
+			var (
+				start = common.HexToHash(req.Params[3].(string))
+				max   = int(req.Params[4].(float64))
+				page  = make(map[common.Hash]StorageEntry)
+				next  *common.Hash
+			)
+			for _, hash := range hashes {
+				if bytes.Compare(hash[:], start[:]) < 0 {
+					continue
+				}
+				if len(page) == max {
+					next = &hash
+					break
+				}
+				slot := slots[hash]
+				page[hash] = StorageEntry{Key: &slot, Value: storage[slot]}
+			}
+			resp["result"] = StorageRangeResult{Storage: page, NextKey: next}
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	var (
+		ctx  = context.Background()
+		clt  = NewClient(srv.URL, WithCache(NewCache(16)))
+		addr = "0x0000000000000000000000000000000000000011"
+	)
+
+	// one slot more than allowed is requested, so a single page tells it's too large
+	got, complete, err := clt.GetFullStorage(ctx, addr, "0x10", 1)
+	if err != nil || complete || got != nil {
+		t.Fatalf("storage over the limit: %v complete: %v err: %v", got, complete, err)
+	}
+
+	if posts["debug_storageRangeAt"] != 1 {
+		t.Fatalf("debug_storageRangeAt requests: %d expected 1", posts["debug_storageRangeAt"])
+	}
+
+	// pages of two slots at most
+	got, complete, err = clt.GetFullStorage(ctx, addr, "0x10", 2)
+	if err != nil || complete || got != nil {
+		t.Fatalf("storage over the limit: %v complete: %v err: %v", got, complete, err)
+	}
+
+	got, complete, err = clt.GetFullStorage(ctx, addr, "0x10", 10)
+	if err != nil || !complete {
+		t.Fatalf("complete: %v err: %v", complete, err)
+	}
+
+	if len(got) != len(storage) {
+		t.Fatalf("slots: %d expected %d", len(got), len(storage))
+	}
+	for slot, value := range storage {
+		if got[slot] != value {
+			t.Fatalf("slot %s: %s expected %s", slot.Hex(), got[slot].Hex(), value.Hex())
+		}
+	}
+
+	// the storage and its slots are cached
+	ranges := posts["debug_storageRangeAt"]
+	if _, complete, _ = clt.GetFullStorage(ctx, addr, "0x10", 10); !complete {
+		t.Fatal("cached storage expected complete")
+	}
+
+	if _, complete, _ = clt.GetFullStorage(ctx, addr, "0x10", 2); complete {
+		t.Fatal("cached storage expected over the limit")
+	}
+
+	value, err := clt.GetStorageAt(ctx, addr, common.BigToHash(common.Big2).Hex(), "0x10")
+	if err != nil || value != common.BigToHash(common.Big3) {
+		t.Fatalf("slot 2: %s err: %v", value.Hex(), err)
+	}
+
+	if posts["debug_storageRangeAt"] != ranges || posts["eth_getStorageAt"] != 0 {
+		t.Fatalf("requests after caching: %v", posts)
+	}
+
+	// the end of the latest block can't be listed
+	if _, complete, err = clt.GetFullStorage(ctx, addr, "latest", 10); err != nil || complete {
+		t.Fatalf("latest complete: %v err: %v", complete, err)
+	}
+
+	if _, complete, err = clt.GetFullStorage(ctx, addr, "0x11", 10); err != nil || complete {
+		t.Fatalf("head block complete: %v err: %v", complete, err)
+	}
+}
@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+// Subscription is a subscription to notifications of a node over WebSocket, its
+// connection is closed by Unsubscribe.
+type Subscription struct {
+	clt *gethrpc.Client
+	sub *gethrpc.ClientSubscription
+}
+
+// Err returns the channel receiving the error that ends the subscription, it's closed
+// by Unsubscribe.
+func (s *Subscription) Err() <-chan error {
+	return s.sub.Err()
+}
+
+// Unsubscribe ends the subscription and closes its connection.
+func (s *Subscription) Unsubscribe() {
+	s.sub.Unsubscribe()
+	s.clt.Close()
+}
+
+// SubscribeNewHeads subscribes, through the WebSocket endpoint of a node, to the
+// headers of the blocks added to the chain, sent on ch as they're announced.
+// Reorganizations announce the headers of the new chain, so a number may be sent
+// more than once.
+func SubscribeNewHeads(ctx context.Context, endpoint string, ch chan<- *BlockHeader) (*Subscription, error) {
+	return subscribe(ctx, endpoint, ch, "newHeads")
+}
+
+// SubscribePendingTransactions subscribes, through the WebSocket endpoint of a node,
+// to the hashes of the transactions added to its mempool, sent on ch as they arrive.
+func SubscribePendingTransactions(ctx context.Context, endpoint string, ch chan<- common.Hash) (*Subscription, error) {
+	return subscribe(ctx, endpoint, ch, "newPendingTransactions")
+}
+
+// subscribe sends eth_subscribe with args to the node at endpoint, its notifications
+// are sent on ch
+func subscribe(ctx context.Context, endpoint string, ch interface{}, args ...interface{}) (*Subscription, error) {
+	clt, err := gethrpc.DialContext(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := clt.EthSubscribe(ctx, ch, args...)
+	if err != nil {
+		clt.Close()
+		return nil, err
+	}
+
+	return &Subscription{clt: clt, sub: sub}, nil
+}
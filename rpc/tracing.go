@@ -0,0 +1,20 @@
+package rpc
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelTracer emits a span per request to the node, batches included, with the
+// global tracer provider
+var otelTracer = otel.Tracer("github.com/Gealber/evm-simulator/rpc")
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrTransactionNotFound is returned when the node doesn't know the requested transaction.
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// Transaction holds the fields of a transaction needed to simulate it again, as
+// returned by eth_getTransactionByHash. BlockNumber is nil for pending transactions.
+type Transaction struct {
+	Hash                 common.Hash       `json:"hash"`
+	BlockNumber          *hexutil.Big      `json:"blockNumber"`
+	TransactionIndex     *hexutil.Uint64   `json:"transactionIndex"`
+	Type                 hexutil.Uint64    `json:"type"`
+	From                 common.Address    `json:"from"`
+	To                   *common.Address   `json:"to"`
+	Input                hexutil.Bytes     `json:"input"`
+	Value                *hexutil.Big      `json:"value"`
+	Nonce                hexutil.Uint64    `json:"nonce"`
+	Gas                  hexutil.Uint64    `json:"gas"`
+	GasPrice             *hexutil.Big      `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	AccessList           *types.AccessList `json:"accessList"`
+}
+
+// Receipt holds the outcome of a transaction, as returned by eth_getTransactionReceipt.
+type Receipt struct {
+	TransactionHash   common.Hash     `json:"transactionHash"`
+	BlockNumber       *hexutil.Big    `json:"blockNumber"`
+	Status            hexutil.Uint64  `json:"status"`
+	GasUsed           hexutil.Uint64  `json:"gasUsed"`
+	EffectiveGasPrice *hexutil.Big    `json:"effectiveGasPrice"`
+	ContractAddress   *common.Address `json:"contractAddress"`
+}
+
+// GetTransactionByHash returns the transaction with the given hash.
+func (c *Client) GetTransactionByHash(ctx context.Context, hash common.Hash) (*Transaction, error) {
+	var tx *Transaction
+	err := c.getByHash(ctx, "eth_getTransactionByHash", hash, &tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, hash.Hex())
+	}
+
+	return tx, nil
+}
+
+// GetTransactionReceipt returns the receipt of the transaction with the given hash,
+// pending transactions don't have one.
+func (c *Client) GetTransactionReceipt(ctx context.Context, hash common.Hash) (*Receipt, error) {
+	var receipt *Receipt
+	err := c.getByHash(ctx, "eth_getTransactionReceipt", hash, &receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	if receipt == nil {
+		return nil, fmt.Errorf("%w: receipt of %s", ErrTransactionNotFound, hash.Hex())
+	}
+
+	return receipt, nil
+}
+
+// getByHash decodes in result the response of method for the given hash, unknown
+// hashes are answered with null.
+func (c *Client) getByHash(ctx context.Context, method string, hash common.Hash, result interface{}) error {
+	rpcResp, err := c.rpcPost(ctx, method, []interface{}{hash.Hex()})
+	if err != nil {
+		return err
+	}
+
+	if rpcResp.Err != nil {
+		return fmt.Errorf("%w: %w", ErrRPCFetch, rpcResp.Err)
+	}
+
+	return json.Unmarshal(rpcResp.Result, result)
+}
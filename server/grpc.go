@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/server/pb"
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+// GRPC serves the simulations of a Simulator as the gRPC service of simulator.proto,
+// register it with pb.RegisterSimulatorServer. As with Server, every request is
+// simulated on its own fresh state.
+//
+// Failed requests are answered with the gRPC status of the error, a revert has the
+// pb.Revert in the details of the status.
+type GRPC struct {
+	pb.UnimplementedSimulatorServer
+
+	sim *simulator.Simulator
+}
+
+// NewGRPC returns a gRPC service simulating with sim.
+func NewGRPC(sim *simulator.Simulator) *GRPC {
+	return &GRPC{sim: sim}
+}
+
+func (g *GRPC) Simulate(ctx context.Context, req *pb.Simulation) (*pb.SimulationResult, error) {
+	sim, err := simulationFromProto(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	result, err := g.sim.Simulate(ctx, sim, nil)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return resultToProto(result), nil
+}
+
+func (g *GRPC) SimulateBundle(ctx context.Context, req *pb.Bundle) (*pb.BundleResult, error) {
+	if len(req.Simulations) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty bundle")
+	}
+
+	sims := make([]simulator.Simulation, len(req.Simulations))
+	for i, m := range req.Simulations {
+		var err error
+		sims[i], err = simulationFromProto(m)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "simulation %d: %s", i, err)
+		}
+	}
+
+	results, err := g.sim.SimulateBundle(ctx, sims, nil)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &pb.BundleResult{Results: make([]*pb.SimulationResult, len(results))}
+	for i, result := range results {
+		resp.Results[i] = resultToProto(result)
+	}
+
+	return resp, nil
+}
+
+func (g *GRPC) TraceSimulation(req *pb.Simulation, stream pb.Simulator_TraceSimulationServer) error {
+	sim, err := simulationFromProto(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// the hooks run on the goroutine of the simulation, the execution goes on when
+	// the client is gone but its events are dropped
+	var sendErr error
+	send := func(event *pb.TraceEvent) {
+		if sendErr == nil {
+			sendErr = stream.Send(event)
+		}
+	}
+
+	sim.Tracer = &tracing.Hooks{
+		OnOpcode: func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			step := &pb.Step{
+				Pc:      pc,
+				Op:      vm.OpCode(op).String(),
+				Gas:     gas,
+				Cost:    cost,
+				Depth:   uint32(depth),
+				Address: scope.Address().Bytes(),
+			}
+			if err != nil {
+				step.Error = err.Error()
+			}
+			send(&pb.TraceEvent{Event: &pb.TraceEvent_Step{Step: step}})
+		},
+		OnEnter: func(depth int, typ byte, from, to common.Address, input []byte, gas uint64, value *big.Int) {
+			send(&pb.TraceEvent{Event: &pb.TraceEvent_Enter{Enter: &pb.CallEnter{
+				Depth: uint32(depth),
+				Type:  vm.OpCode(typ).String(),
+				From:  from.Bytes(),
+				To:    to.Bytes(),
+				Input: input,
+				Gas:   gas,
+				Value: bigToProto(value),
+			}}})
+		},
+		OnExit: func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+			exit := &pb.CallExit{
+				Depth:    uint32(depth),
+				Output:   output,
+				GasUsed:  gasUsed,
+				Reverted: reverted,
+			}
+			if err != nil {
+				exit.Error = err.Error()
+			}
+			send(&pb.TraceEvent{Event: &pb.TraceEvent_Exit{Exit: exit}})
+		},
+	}
+
+	result, err := g.sim.Simulate(stream.Context(), sim, nil)
+	if err != nil {
+		return grpcError(err)
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+
+	return stream.Send(&pb.TraceEvent{Event: &pb.TraceEvent_Result{Result: resultToProto(result)}})
+}
+
+// grpcError returns the status of err, as writeError the simulations rejected by
+// the simulator or the chain rules are the fault of the request
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, simulator.ErrSimulationTimeout), errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, rpc.ErrFetchBudgetExceeded), errors.Is(err, vm.ErrStepLimitExceeded):
+		code = codes.ResourceExhausted
+	case errors.Is(err, simulator.ErrBundleReverted),
+		errors.Is(err, simulator.ErrInvalidBundleNonces),
+		errors.Is(err, simulator.ErrInvalidNonce),
+		errors.Is(err, simulator.ErrInvalidStateOverride),
+		errors.Is(err, simulator.ErrInsufficientBalance),
+		errors.Is(err, core.ErrInsufficientFunds),
+		errors.Is(err, core.ErrTipAboveFeeCap),
+		errors.Is(err, core.ErrFeeCapTooLow):
+		code = codes.FailedPrecondition
+	}
+
+	var revertErr *simulator.RevertError
+	if !errors.As(err, &revertErr) {
+		return status.Error(code, err.Error())
+	}
+
+	st := status.New(codes.FailedPrecondition, err.Error())
+	if withRevert, detailsErr := st.WithDetails(revertToProto(revertErr.Info)); detailsErr == nil {
+		st = withRevert
+	}
+
+	return st.Err()
+}
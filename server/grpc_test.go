@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/server/pb"
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+// dialGRPC returns a client of the gRPC service simulating on the fork of a test
+// server
+func dialGRPC(t *testing.T) pb.SimulatorClient {
+	node := newTestServer(t)
+
+	sim, err := simulator.NewSimulator(rpc.NewClient(node.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterSimulatorServer(srv, NewGRPC(sim))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewSimulatorClient(conn)
+}
+
+func storeSimulation() *pb.Simulation {
+	req := storeRequest()
+	return &pb.Simulation{
+		From:     req.From.Bytes(),
+		To:       req.To.Bytes(),
+		GasLimit: uint64(req.Gas),
+		Code:     req.Code,
+		Input:    req.Input,
+	}
+}
+
+func TestGRPCSimulate(t *testing.T) {
+	clt := dialGRPC(t)
+
+	req := storeSimulation()
+	req.CollectStateDiff = true
+
+	result, err := clt.Simulate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Status != 1 || !bytes.Equal(result.ReturnData, word) {
+		t.Fatalf("status: %d return data: %x", result.Status, result.ReturnData)
+	}
+
+	var found bool
+	for _, diff := range result.StateDiff {
+		if !bytes.Equal(diff.Address, req.To) {
+			continue
+		}
+		found = len(diff.Storage) == 1 && bytes.Equal(diff.Storage[0].After, word)
+	}
+	if !found {
+		t.Fatalf("state diff: %v", result.StateDiff)
+	}
+
+	req.To = []byte{0x01}
+	if _, err := clt.Simulate(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument, got: %v", err)
+	}
+}
+
+func TestGRPCSimulateBundle(t *testing.T) {
+	clt := dialGRPC(t)
+
+	reverting := storeSimulation()
+	reverting.To = common.HexToAddress("0x0000000000000000000000000000000000000012").Bytes()
+	reverting.Code = revertCode
+
+	resp, err := clt.SimulateBundle(context.Background(), &pb.Bundle{Simulations: []*pb.Simulation{storeSimulation(), reverting}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Results) != 2 || resp.Results[0].Status != 1 || resp.Results[1].Status != 0 {
+		t.Fatalf("results: %v", resp.Results)
+	}
+
+	if revert := resp.Results[1].Revert; revert == nil || revert.Kind != "empty" {
+		t.Fatalf("revert: %v", revert)
+	}
+}
+
+func TestGRPCTraceSimulation(t *testing.T) {
+	clt := dialGRPC(t)
+
+	stream, err := clt.TraceSimulation(context.Background(), storeSimulation())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ops    []string
+		enters int
+		result *pb.SimulationResult
+	)
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch e := event.Event.(type) {
+		case *pb.TraceEvent_Step:
+			ops = append(ops, e.Step.Op)
+		case *pb.TraceEvent_Enter:
+			enters++
+		case *pb.TraceEvent_Result:
+			result = e.Result
+		}
+	}
+
+	if len(ops) != 11 || ops[0] != vm.PUSH0.String() || ops[len(ops)-1] != vm.RETURN.String() {
+		t.Fatalf("ops: %v", ops)
+	}
+
+	if enters != 1 {
+		t.Fatalf("enters: %d", enters)
+	}
+
+	if result == nil || result.Status != 1 {
+		t.Fatalf("result: %v", result)
+	}
+}
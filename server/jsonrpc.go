@@ -0,0 +1,492 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/simulator"
+)
+
+// error codes of JSON-RPC, and the one of geth for reverted executions
+const (
+	errCodeParse          = -32700
+	errCodeInvalidRequest = -32600
+	errCodeMethodNotFound = -32601
+	errCodeInvalidParams  = -32602
+	errCodeServer         = -32000
+	errCodeReverted       = 3
+)
+
+// forwardedMethods are the read only methods answered by the fork
+var forwardedMethods = map[string]struct{}{
+	"eth_chainId":                             {},
+	"eth_blockNumber":                         {},
+	"eth_gasPrice":                            {},
+	"eth_maxPriorityFeePerGas":                {},
+	"eth_blobBaseFee":                         {},
+	"eth_feeHistory":                          {},
+	"eth_getBalance":                          {},
+	"eth_getCode":                             {},
+	"eth_getStorageAt":                        {},
+	"eth_getTransactionCount":                 {},
+	"eth_getProof":                            {},
+	"eth_getBlockByNumber":                    {},
+	"eth_getBlockByHash":                      {},
+	"eth_getBlockTransactionCountByNumber":    {},
+	"eth_getBlockTransactionCountByHash":      {},
+	"eth_getTransactionByHash":                {},
+	"eth_getTransactionByBlockNumberAndIndex": {},
+	"eth_getTransactionByBlockHashAndIndex":   {},
+	"eth_getTransactionReceipt":               {},
+	"eth_getBlockReceipts":                    {},
+	"eth_getLogs":                             {},
+	"eth_syncing":                             {},
+	"net_version":                             {},
+	"web3_clientVersion":                      {},
+}
+
+// RPC answers JSON-RPC requests as an Ethereum node would, so the existing clients can
+// use the simulator. eth_call, eth_estimateGas, eth_createAccessList and debug_traceCall
+// are simulated on the fork, the read only methods of the node are forwarded to it.
+// Transactions can't be sent.
+type RPC struct {
+	sim *simulator.Simulator
+}
+
+// NewRPC returns a JSON-RPC handler simulating with sim.
+func NewRPC(sim *simulator.Simulator) *RPC {
+	return &RPC{sim: sim}
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// callArgs are the arguments of eth_call and the methods alike
+type callArgs struct {
+	From                 common.Address    `json:"from"`
+	To                   *common.Address   `json:"to"`
+	Gas                  *hexutil.Uint64   `json:"gas"`
+	GasPrice             *hexutil.Big      `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big      `json:"value"`
+	Nonce                *hexutil.Uint64   `json:"nonce"`
+	Data                 *hexutil.Bytes    `json:"data"`
+	Input                *hexutil.Bytes    `json:"input"`
+	AccessList           *types.AccessList `json:"accessList"`
+}
+
+// traceConfig is the configuration of debug_traceCall
+type traceConfig struct {
+	*logger.Config
+	Tracer         *string                            `json:"tracer"`
+	TracerConfig   json.RawMessage                    `json:"tracerConfig"`
+	StateOverrides map[common.Address]OverrideAccount `json:"stateOverrides"`
+}
+
+func (h *RPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(w, r)
+	if err != nil {
+		writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: errCodeParse, Message: err.Error()}})
+		return
+	}
+
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		var reqs []rpcRequest
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: errCodeParse, Message: err.Error()}})
+			return
+		}
+
+		resps := make([]rpcResponse, len(reqs))
+		for i := range reqs {
+			resps[i] = h.handle(r.Context(), &reqs[i])
+		}
+
+		writeJSON(w, http.StatusOK, resps)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: errCodeParse, Message: err.Error()}})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.handle(r.Context(), &req))
+}
+
+// handle answers req
+func (h *RPC) handle(ctx context.Context, req *rpcRequest) rpcResponse {
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{Code: errCodeInvalidRequest, Message: "invalid request"}
+		return resp
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch req.Method {
+	case "eth_call":
+		result, err = h.call(ctx, req.Params)
+	case "eth_estimateGas":
+		result, err = h.estimateGas(ctx, req.Params)
+	case "eth_createAccessList":
+		result, err = h.createAccessList(ctx, req.Params)
+	case "debug_traceCall":
+		result, err = h.traceCall(ctx, req.Params)
+	default:
+		result, err = h.forward(ctx, req.Method, req.Params)
+	}
+
+	if err != nil {
+		resp.Error = toRPCError(err)
+		return resp
+	}
+
+	resp.Result = result
+
+	return resp
+}
+
+func (h *RPC) call(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var (
+		args      callArgs
+		block     *string
+		overrides map[common.Address]OverrideAccount
+	)
+	if err := parseParams(params, 1, &args, &block, &overrides); err != nil {
+		return nil, err
+	}
+
+	sim, err := args.simulation(block, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := h.sim.Simulate(ctx, sim, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	return hexutil.Bytes(result.ReturnedData), nil
+}
+
+func (h *RPC) estimateGas(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var (
+		args      callArgs
+		block     *string
+		overrides map[common.Address]OverrideAccount
+	)
+	if err := parseParams(params, 1, &args, &block, &overrides); err != nil {
+		return nil, err
+	}
+
+	sim, err := args.simulation(block, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	gas, err := h.sim.EstimateGas(ctx, sim, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the gas of a transaction pays for its intrinsic gas as well
+	return hexutil.Uint64(gas + args.intrinsicGas()), nil
+}
+
+func (h *RPC) createAccessList(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var (
+		args  callArgs
+		block *string
+	)
+	if err := parseParams(params, 1, &args, &block); err != nil {
+		return nil, err
+	}
+
+	sim, err := args.simulation(block, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.sim.CreateAccessList(ctx, sim, nil)
+}
+
+func (h *RPC) traceCall(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var (
+		args   callArgs
+		block  *string
+		config traceConfig
+	)
+	if err := parseParams(params, 1, &args, &block, &config); err != nil {
+		return nil, err
+	}
+
+	sim, err := args.simulation(block, config.StateOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	var onlyTopCall bool
+	switch {
+	case config.Tracer == nil || *config.Tracer == "" || *config.Tracer == "structLogger":
+		sim.StructLogger = config.Config
+		if sim.StructLogger == nil {
+			sim.StructLogger = &logger.Config{}
+		}
+	case *config.Tracer == "callTracer":
+		sim.CollectCallTrace = true
+		if len(config.TracerConfig) > 0 {
+			var tracerConfig struct {
+				OnlyTopCall bool `json:"onlyTopCall"`
+			}
+			if err := json.Unmarshal(config.TracerConfig, &tracerConfig); err != nil {
+				return nil, &rpcError{Code: errCodeInvalidParams, Message: fmt.Sprintf("invalid tracer config: %s", err)}
+			}
+			onlyTopCall = tracerConfig.OnlyTopCall
+		}
+	default:
+		return nil, &rpcError{Code: errCodeInvalidParams, Message: fmt.Sprintf("tracer %q not supported", *config.Tracer)}
+	}
+
+	result, err := h.sim.Simulate(ctx, sim, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if sim.StructLogger != nil {
+		return result.StructLogs, nil
+	}
+
+	trace := result.CallTrace
+	if onlyTopCall && trace != nil {
+		top := *trace
+		top.Calls = nil
+		trace = &top
+	}
+
+	return trace, nil
+}
+
+// forward answers the read only methods with the fork
+func (h *RPC) forward(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+	if _, ok := forwardedMethods[method]; !ok {
+		return nil, &rpcError{Code: errCodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", method)}
+	}
+
+	var args []interface{}
+	if len(params) > 0 && string(params) != "null" {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(params, &raw); err != nil {
+			return nil, &rpcError{Code: errCodeInvalidParams, Message: err.Error()}
+		}
+		for _, param := range raw {
+			args = append(args, param)
+		}
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	return h.sim.RPCClt.Request(ctx, method, args)
+}
+
+// simulation returns the simulation of the call at block, latest when nil
+func (args *callArgs) simulation(block *string, overrides map[common.Address]OverrideAccount) (simulator.Simulation, error) {
+	if args.To == nil {
+		return simulator.Simulation{}, &rpcError{Code: errCodeInvalidParams, Message: "contract creations are not supported"}
+	}
+
+	blockNumber, err := parseBlock(block)
+	if err != nil {
+		return simulator.Simulation{}, err
+	}
+
+	sim := simulator.Simulation{
+		From:                 args.From,
+		To:                   *args.To,
+		BlockNumber:          blockNumber,
+		GasPrice:             toBig(args.GasPrice),
+		MaxFeePerGas:         toBig(args.MaxFeePerGas),
+		MaxPriorityFeePerGas: toBig(args.MaxPriorityFeePerGas),
+		Value:                toBig(args.Value),
+		Input:                args.input(),
+		StateOverrides:       stateOverrides(overrides),
+	}
+	if sim.Value == nil {
+		sim.Value = new(big.Int)
+	}
+
+	if args.Nonce != nil {
+		nonce := uint64(*args.Nonce)
+		sim.Nonce = &nonce
+	}
+
+	if args.AccessList != nil {
+		sim.TxType = types.AccessListTxType
+		sim.AccessList = *args.AccessList
+	}
+
+	// the gas of the simulation is the one available to the execution
+	if args.Gas != nil {
+		intrinsic := args.intrinsicGas()
+		if uint64(*args.Gas) < intrinsic {
+			return simulator.Simulation{}, fmt.Errorf("%w: have %d, want %d", core.ErrIntrinsicGas, *args.Gas, intrinsic)
+		}
+		sim.GasLimit = uint64(*args.Gas) - intrinsic
+	}
+
+	return sim, nil
+}
+
+// input returns the input of the call, sent as input or as data
+func (args *callArgs) input() []byte {
+	switch {
+	case args.Input != nil:
+		return *args.Input
+	case args.Data != nil:
+		return *args.Data
+	}
+
+	return nil
+}
+
+// intrinsicGas returns the gas charged to the call before its execution
+func (args *callArgs) intrinsicGas() uint64 {
+	var accessList types.AccessList
+	if args.AccessList != nil {
+		accessList = *args.AccessList
+	}
+
+	gas, err := core.IntrinsicGas(args.input(), accessList, false, true, true, true)
+	if err != nil {
+		return 0
+	}
+
+	return gas
+}
+
+// parseBlock parses a block number or tag, the latest block is zero for the simulator
+func parseBlock(block *string) (*big.Int, error) {
+	if block == nil {
+		return new(big.Int), nil
+	}
+
+	switch *block {
+	case "latest", "pending":
+		return new(big.Int), nil
+	}
+
+	number, err := hexutil.DecodeBig(*block)
+	if err != nil {
+		return nil, &rpcError{Code: errCodeInvalidParams, Message: fmt.Sprintf("invalid block %q", *block)}
+	}
+
+	return number, nil
+}
+
+// parseParams decodes the positional params into dst, the first required ones must
+// be present
+func parseParams(params json.RawMessage, required int, dst ...interface{}) error {
+	var raw []json.RawMessage
+	if len(params) > 0 && string(params) != "null" {
+		if err := json.Unmarshal(params, &raw); err != nil {
+			return &rpcError{Code: errCodeInvalidParams, Message: err.Error()}
+		}
+	}
+
+	if len(raw) < required {
+		return &rpcError{Code: errCodeInvalidParams, Message: fmt.Sprintf("missing value for required argument %d", len(raw))}
+	}
+	if len(raw) > len(dst) {
+		return &rpcError{Code: errCodeInvalidParams, Message: fmt.Sprintf("too many arguments, want at most %d", len(dst))}
+	}
+
+	for i, param := range raw {
+		if err := json.Unmarshal(param, dst[i]); err != nil {
+			return &rpcError{Code: errCodeInvalidParams, Message: fmt.Sprintf("invalid argument %d: %s", i, err)}
+		}
+	}
+
+	return nil
+}
+
+// toRPCError converts err to the error of a response, reverts carry their data as
+// geth does
+func toRPCError(err error) *rpcError {
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+
+	var nodeErr *rpc.ErrResponse
+	if errors.As(err, &nodeErr) {
+		return &rpcError{Code: int(nodeErr.Code), Message: nodeErr.Message}
+	}
+
+	var revertErr *simulator.RevertError
+	if errors.As(err, &revertErr) {
+		message := "execution reverted"
+		if revertErr.Info.Reason != "" {
+			message += ": " + revertErr.Info.Reason
+		}
+
+		return &rpcError{Code: errCodeReverted, Message: message, Data: hexutil.Bytes(revertErr.Info.Data)}
+	}
+
+	return &rpcError{Code: errCodeServer, Message: err.Error()}
+}
+
+// readBody reads the body of r, bounded by maxBodySize
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(http.MaxBytesReader(w, r.Body, maxBodySize)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
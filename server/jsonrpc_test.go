@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/vm"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/gethclient"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// dialRPC returns a client of the JSON-RPC facade of a test server, where the code
+// of the contract at storeRequest().To is overridden by the calls
+func dialRPC(t *testing.T) *gethrpc.Client {
+	srv := newTestServer(t)
+
+	clt, err := gethrpc.Dial(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(clt.Close)
+
+	return clt
+}
+
+// callWithCode calls the contract of storeRequest with code overridden
+func callWithCode(code []byte) (map[string]interface{}, map[common.Address]OverrideAccount) {
+	req := storeRequest()
+	call := map[string]interface{}{
+		"from":  req.From,
+		"to":    req.To,
+		"input": hexutil.Bytes(req.Input),
+	}
+
+	codeOverride := hexutil.Bytes(code)
+	return call, map[common.Address]OverrideAccount{req.To: {Code: &codeOverride}}
+}
+
+func TestRPCCall(t *testing.T) {
+	clt := dialRPC(t)
+
+	call, overrides := callWithCode(storeCode)
+
+	var result hexutil.Bytes
+	if err := clt.Call(&result, "eth_call", call, "latest", overrides); err != nil {
+		t.Fatal(err)
+	}
+
+	if common.BytesToHash(result) != common.BytesToHash(word) {
+		t.Fatalf("result: %s", result)
+	}
+
+	call, overrides = callWithCode(revertCode)
+	err := clt.Call(&result, "eth_call", call, "latest", overrides)
+
+	var dataErr gethrpc.DataError
+	if !errors.As(err, &dataErr) {
+		t.Fatalf("expected revert, got: %v", err)
+	}
+
+	if dataErr.ErrorData() != "0x" {
+		t.Fatalf("revert data: %v", dataErr.ErrorData())
+	}
+}
+
+func TestRPCEstimateGas(t *testing.T) {
+	clt := dialRPC(t)
+
+	to := common.HexToAddress("0x0000000000000000000000000000000000000011")
+
+	// the code isn't fetched from the fork when the simulation has it, so use an
+	// empty account: the call is a plain transfer
+	gas, err := ethclient.NewClient(clt).EstimateGas(context.Background(), ethereum.CallMsg{
+		From:  common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:    &to,
+		Value: new(big.Int),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gas != 21000 {
+		t.Fatalf("gas: %d expected 21000", gas)
+	}
+
+	call, overrides := callWithCode(storeCode)
+
+	var estimate hexutil.Uint64
+	if err := clt.Call(&estimate, "eth_estimateGas", call, "latest", overrides); err != nil {
+		t.Fatal(err)
+	}
+
+	// the calldata word has one non zero byte
+	if intrinsic := uint64(21000 + 31*4 + 16); uint64(estimate) <= intrinsic+22100 {
+		t.Fatalf("estimate: %d", estimate)
+	}
+}
+
+func TestRPCCreateAccessList(t *testing.T) {
+	clt := dialRPC(t)
+
+	to := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	accessList, gasUsed, vmErr, err := gethclient.New(clt).CreateAccessList(context.Background(), ethereum.CallMsg{
+		From: common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:   &to,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vmErr != "" {
+		t.Fatalf("execution error: %s", vmErr)
+	}
+
+	if accessList == nil || gasUsed == 0 {
+		t.Fatalf("access list: %v gas used: %d", accessList, gasUsed)
+	}
+}
+
+func TestRPCTraceCall(t *testing.T) {
+	clt := dialRPC(t)
+
+	call, overrides := callWithCode(storeCode)
+
+	var trace runtime.CallFrame
+	config := map[string]interface{}{"tracer": "callTracer", "stateOverrides": overrides}
+	if err := clt.Call(&trace, "debug_traceCall", call, "latest", config); err != nil {
+		t.Fatal(err)
+	}
+
+	if trace.Type != "CALL" || common.BytesToHash(trace.Output) != common.BytesToHash(word) {
+		t.Fatalf("trace: %+v", trace)
+	}
+
+	var structLogs struct {
+		Failed     bool `json:"failed"`
+		StructLogs []struct {
+			Op string `json:"op"`
+		} `json:"structLogs"`
+	}
+	if err := clt.Call(&structLogs, "debug_traceCall", call, "latest", map[string]interface{}{"stateOverrides": overrides}); err != nil {
+		t.Fatal(err)
+	}
+
+	if structLogs.Failed || len(structLogs.StructLogs) == 0 || structLogs.StructLogs[0].Op != vm.PUSH0.String() {
+		t.Fatalf("struct logs: %+v", structLogs)
+	}
+}
+
+func TestRPCMethods(t *testing.T) {
+	clt := dialRPC(t)
+
+	var balance hexutil.Big
+	if err := clt.Call(&balance, "eth_getBalance", common.Address{}, "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	var hash common.Hash
+	err := clt.Call(&hash, "eth_sendRawTransaction", hexutil.Bytes{0x01})
+
+	var rpcErr gethrpc.Error
+	if !errors.As(err, &rpcErr) || rpcErr.ErrorCode() != errCodeMethodNotFound {
+		t.Fatalf("expected method not found, got: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: simulator.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Simulator_Simulate_FullMethodName        = "/simulator.v1.Simulator/Simulate"
+	Simulator_SimulateBundle_FullMethodName  = "/simulator.v1.Simulator/SimulateBundle"
+	Simulator_TraceSimulation_FullMethodName = "/simulator.v1.Simulator/TraceSimulation"
+)
+
+// SimulatorClient is the client API for Simulator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SimulatorClient interface {
+	// Simulate simulates a transaction.
+	Simulate(ctx context.Context, in *Simulation, opts ...grpc.CallOption) (*SimulationResult, error)
+	// SimulateBundle simulates transactions in order on the same state.
+	SimulateBundle(ctx context.Context, in *Bundle, opts ...grpc.CallOption) (*BundleResult, error)
+	// TraceSimulation simulates a transaction streaming the opcodes it executes and
+	// the calls it makes as they happen, the result is the last event.
+	TraceSimulation(ctx context.Context, in *Simulation, opts ...grpc.CallOption) (Simulator_TraceSimulationClient, error)
+}
+
+type simulatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSimulatorClient(cc grpc.ClientConnInterface) SimulatorClient {
+	return &simulatorClient{cc}
+}
+
+func (c *simulatorClient) Simulate(ctx context.Context, in *Simulation, opts ...grpc.CallOption) (*SimulationResult, error) {
+	out := new(SimulationResult)
+	err := c.cc.Invoke(ctx, Simulator_Simulate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *simulatorClient) SimulateBundle(ctx context.Context, in *Bundle, opts ...grpc.CallOption) (*BundleResult, error) {
+	out := new(BundleResult)
+	err := c.cc.Invoke(ctx, Simulator_SimulateBundle_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *simulatorClient) TraceSimulation(ctx context.Context, in *Simulation, opts ...grpc.CallOption) (Simulator_TraceSimulationClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Simulator_ServiceDesc.Streams[0], Simulator_TraceSimulation_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &simulatorTraceSimulationClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Simulator_TraceSimulationClient interface {
+	Recv() (*TraceEvent, error)
+	grpc.ClientStream
+}
+
+type simulatorTraceSimulationClient struct {
+	grpc.ClientStream
+}
+
+func (x *simulatorTraceSimulationClient) Recv() (*TraceEvent, error) {
+	m := new(TraceEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SimulatorServer is the server API for Simulator service.
+// All implementations must embed UnimplementedSimulatorServer
+// for forward compatibility
+type SimulatorServer interface {
+	// Simulate simulates a transaction.
+	Simulate(context.Context, *Simulation) (*SimulationResult, error)
+	// SimulateBundle simulates transactions in order on the same state.
+	SimulateBundle(context.Context, *Bundle) (*BundleResult, error)
+	// TraceSimulation simulates a transaction streaming the opcodes it executes and
+	// the calls it makes as they happen, the result is the last event.
+	TraceSimulation(*Simulation, Simulator_TraceSimulationServer) error
+	mustEmbedUnimplementedSimulatorServer()
+}
+
+// UnimplementedSimulatorServer must be embedded to have forward compatible implementations.
+type UnimplementedSimulatorServer struct {
+}
+
+func (UnimplementedSimulatorServer) Simulate(context.Context, *Simulation) (*SimulationResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Simulate not implemented")
+}
+func (UnimplementedSimulatorServer) SimulateBundle(context.Context, *Bundle) (*BundleResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SimulateBundle not implemented")
+}
+func (UnimplementedSimulatorServer) TraceSimulation(*Simulation, Simulator_TraceSimulationServer) error {
+	return status.Errorf(codes.Unimplemented, "method TraceSimulation not implemented")
+}
+func (UnimplementedSimulatorServer) mustEmbedUnimplementedSimulatorServer() {}
+
+// UnsafeSimulatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SimulatorServer will
+// result in compilation errors.
+type UnsafeSimulatorServer interface {
+	mustEmbedUnimplementedSimulatorServer()
+}
+
+func RegisterSimulatorServer(s grpc.ServiceRegistrar, srv SimulatorServer) {
+	s.RegisterService(&Simulator_ServiceDesc, srv)
+}
+
+func _Simulator_Simulate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Simulation)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SimulatorServer).Simulate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Simulator_Simulate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SimulatorServer).Simulate(ctx, req.(*Simulation))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Simulator_SimulateBundle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Bundle)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SimulatorServer).SimulateBundle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Simulator_SimulateBundle_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SimulatorServer).SimulateBundle(ctx, req.(*Bundle))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Simulator_TraceSimulation_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Simulation)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SimulatorServer).TraceSimulation(m, &simulatorTraceSimulationServer{stream})
+}
+
+type Simulator_TraceSimulationServer interface {
+	Send(*TraceEvent) error
+	grpc.ServerStream
+}
+
+type simulatorTraceSimulationServer struct {
+	grpc.ServerStream
+}
+
+func (x *simulatorTraceSimulationServer) Send(m *TraceEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Simulator_ServiceDesc is the grpc.ServiceDesc for Simulator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Simulator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "simulator.v1.Simulator",
+	HandlerType: (*SimulatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Simulate",
+			Handler:    _Simulator_Simulate_Handler,
+		},
+		{
+			MethodName: "SimulateBundle",
+			Handler:    _Simulator_SimulateBundle_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "TraceSimulation",
+			Handler:       _Simulator_TraceSimulation_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "simulator.proto",
+}
@@ -0,0 +1,482 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/Gealber/evm-simulator/server/pb"
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// simulationFromProto converts a protobuf simulation to a simulator.Simulation.
+func simulationFromProto(m *pb.Simulation) (simulator.Simulation, error) {
+	var (
+		sim simulator.Simulation
+		err error
+	)
+
+	if sim.From, err = toAddress(m.From); err != nil {
+		return sim, fmt.Errorf("from: %w", err)
+	}
+	if sim.To, err = toAddress(m.To); err != nil {
+		return sim, fmt.Errorf("to: %w", err)
+	}
+	if m.TxType > 0xff {
+		return sim, fmt.Errorf("invalid transaction type %d", m.TxType)
+	}
+
+	sim.BlockNumber = new(big.Int).SetUint64(m.BlockNumber)
+	sim.GasLimit = m.GasLimit
+	sim.GasPrice = bigFromProto(m.GasPrice)
+	sim.MaxFeePerGas = bigFromProto(m.MaxFeePerGas)
+	sim.MaxPriorityFeePerGas = bigFromProto(m.MaxPriorityFeePerGas)
+	sim.Value = new(big.Int).SetBytes(m.Value)
+	sim.Input = m.Input
+	sim.Code = m.Code
+	sim.Difficulty = bigFromProto(m.Difficulty)
+	sim.Timestamp = m.Timestamp
+	sim.BaseFee = bigFromProto(m.BaseFee)
+	sim.BlobBaseFee = bigFromProto(m.BlobBaseFee)
+	sim.TxType = uint8(m.TxType)
+	sim.PrefetchAccessList = m.PrefetchAccessList
+	sim.SpeculativeFetch = m.SpeculativeFetch
+	sim.ReadOnly = m.ReadOnly
+	sim.AllowRevert = m.AllowRevert
+	sim.Nonce = m.Nonce
+	sim.MaxRetries = int(m.MaxRetries)
+	sim.ResolveProxies = m.ResolveProxies
+	sim.CollectCoverage = m.CollectCoverage
+	sim.CollectCallTrace = m.CollectCallTrace
+	sim.CollectGasProfile = m.CollectGasProfile
+	sim.CollectOpcodeHistogram = m.CollectOpcodeHistogram
+	sim.CollectStateDiff = m.CollectStateDiff
+
+	if sim.Coinbase, err = toOptionalAddress(m.Coinbase); err != nil {
+		return sim, fmt.Errorf("coinbase: %w", err)
+	}
+	if sim.Random, err = toOptionalHash(m.Random); err != nil {
+		return sim, fmt.Errorf("random: %w", err)
+	}
+
+	if o := m.BlockOverrides; o != nil {
+		overrides := &simulator.BlockOverrides{
+			Number:   bigFromProto(o.Number),
+			Time:     o.Time,
+			BaseFee:  bigFromProto(o.BaseFee),
+			GasLimit: o.GasLimit,
+		}
+		if overrides.PrevRandao, err = toOptionalHash(o.PrevRandao); err != nil {
+			return sim, fmt.Errorf("block overrides: prev randao: %w", err)
+		}
+		if overrides.Coinbase, err = toOptionalAddress(o.Coinbase); err != nil {
+			return sim, fmt.Errorf("block overrides: coinbase: %w", err)
+		}
+		sim.BlockOverrides = overrides
+	}
+
+	if sim.AccessList, err = accessListFromProto(m.AccessList); err != nil {
+		return sim, fmt.Errorf("access list: %w", err)
+	}
+
+	if len(m.Prefetch) > 0 {
+		prefetch, err := accessListFromProto(m.Prefetch)
+		if err != nil {
+			return sim, fmt.Errorf("prefetch: %w", err)
+		}
+		sim.Prefetch = make(map[common.Address][]common.Hash, len(prefetch))
+		for _, tuple := range prefetch {
+			sim.Prefetch[tuple.Address] = append(sim.Prefetch[tuple.Address], tuple.StorageKeys...)
+		}
+	}
+
+	for _, delegation := range m.SetCodeDelegations {
+		authority, err := toAddress(delegation.Authority)
+		if err != nil {
+			return sim, fmt.Errorf("code delegation authority: %w", err)
+		}
+		implementation, err := toAddress(delegation.ImplementationAddress)
+		if err != nil {
+			return sim, fmt.Errorf("code delegation implementation: %w", err)
+		}
+		sim.SetCodeDelegations = append(sim.SetCodeDelegations, simulator.CodeDelegation{
+			Authority:             authority,
+			ImplementationAddress: implementation,
+			Nonce:                 delegation.Nonce,
+		})
+	}
+
+	if len(m.StateOverrides) > 0 {
+		sim.StateOverrides = make(map[common.Address]simulator.OverrideAccount, len(m.StateOverrides))
+		for _, account := range m.StateOverrides {
+			addr, err := toAddress(account.Address)
+			if err != nil {
+				return sim, fmt.Errorf("state override: %w", err)
+			}
+			override := simulator.OverrideAccount{
+				Nonce:   account.Nonce,
+				Balance: bigFromProto(account.Balance),
+			}
+			if account.Code != nil {
+				// an empty code removes the one of the account
+				override.Code = append([]byte{}, account.Code...)
+			}
+			if override.State, err = slotsFromProto(account.State); err != nil {
+				return sim, fmt.Errorf("state override of %s: %w", addr.Hex(), err)
+			}
+			if override.StateDiff, err = slotsFromProto(account.StateDiff); err != nil {
+				return sim, fmt.Errorf("state override of %s: %w", addr.Hex(), err)
+			}
+			sim.StateOverrides[addr] = override
+		}
+	}
+
+	if len(m.Labels) > 0 {
+		sim.AddressBook = simulator.NewAddressBook()
+		for _, label := range m.Labels {
+			addr, err := toAddress(label.Address)
+			if err != nil {
+				return sim, fmt.Errorf("label: %w", err)
+			}
+			sim.AddressBook.SetLabel(addr, label.Label)
+		}
+	}
+
+	return sim, nil
+}
+
+// resultToProto converts result to its protobuf form.
+func resultToProto(result *simulator.SimulationResult) *pb.SimulationResult {
+	m := &pb.SimulationResult{
+		Status:            result.Status,
+		ReturnData:        result.ReturnedData,
+		GasUsed:           result.GasUsed,
+		GasLimit:          result.GasLimit,
+		EffectiveGasPrice: bigToProto(result.EffectiveGasPrice),
+		PriorityFees:      bigToProto(result.PriorityFees),
+		Nonce:             result.Nonce,
+		CallTrace:         callFrameToProto(result.CallTrace),
+	}
+
+	if result.CoinbaseDiff != nil {
+		m.CoinbaseDiff = new(big.Int).Abs(result.CoinbaseDiff).Bytes()
+		m.CoinbaseDiffNegative = result.CoinbaseDiff.Sign() < 0
+	}
+
+	if result.Record != nil {
+		m.AccessList = accessListToProto(result.Record.AccessList)
+	}
+
+	for _, log := range result.Events {
+		m.Logs = append(m.Logs, logToProto(log))
+	}
+
+	for _, contract := range result.CreatedContracts {
+		m.CreatedContracts = append(m.CreatedContracts, &pb.CreatedContract{
+			Address:      contract.Address.Bytes(),
+			Creator:      contract.Creator.Bytes(),
+			InitCodeHash: contract.InitCodeHash.Bytes(),
+			Code:         contract.Code,
+			Create2:      contract.Create2,
+		})
+	}
+
+	for _, log := range result.ConsoleLogs {
+		m.ConsoleLogs = append(m.ConsoleLogs, &pb.ConsoleLog{Address: log.Address.Bytes(), Message: log.Message})
+	}
+
+	if result.Revert != nil {
+		m.Revert = revertToProto(result.Revert)
+	}
+
+	for _, addr := range sortedAddresses(result.Proxies) {
+		proxy := result.Proxies[addr]
+		p := &pb.Proxy{
+			Address:        addr.Bytes(),
+			Kind:           proxy.Kind.String(),
+			Implementation: proxy.Implementation.Bytes(),
+		}
+		if proxy.Beacon != (common.Address{}) {
+			p.Beacon = proxy.Beacon.Bytes()
+		}
+		m.Proxies = append(m.Proxies, p)
+	}
+
+	for _, addr := range sortedAddresses(result.Labels) {
+		m.Labels = append(m.Labels, &pb.Label{Address: addr.Bytes(), Label: result.Labels[addr]})
+	}
+
+	for _, addr := range sortedAddresses(result.CodeCoverage) {
+		m.CodeCoverage = append(m.CodeCoverage, &pb.CodeCoverage{Address: addr.Bytes(), Bitmap: result.CodeCoverage[addr]})
+	}
+
+	for _, entry := range result.GasProfile {
+		m.GasProfile = append(m.GasProfile, &pb.GasProfileEntry{
+			Address:       entry.Address.Bytes(),
+			Selector:      entry.Selector[:],
+			Calls:         entry.Calls,
+			SelfGas:       entry.SelfGas,
+			CumulativeGas: entry.CumulativeGas,
+		})
+	}
+
+	for op, gas := range result.OpcodeHistogram {
+		m.OpcodeHistogram = append(m.OpcodeHistogram, &pb.OpcodeGas{
+			Op:        op.String(),
+			Count:     gas.Count,
+			Gas:       gas.Gas,
+			MemoryGas: gas.MemoryGas,
+		})
+	}
+	sort.Slice(m.OpcodeHistogram, func(i, j int) bool {
+		return m.OpcodeHistogram[i].Op < m.OpcodeHistogram[j].Op
+	})
+
+	for _, addr := range sortedAddresses(result.StateDiff) {
+		m.StateDiff = append(m.StateDiff, accountDiffToProto(addr, result.StateDiff[addr]))
+	}
+
+	for _, change := range result.AssetChanges {
+		m.AssetChanges = append(m.AssetChanges, &pb.AssetChange{
+			Address:   change.Address.Bytes(),
+			Standard:  change.Standard.String(),
+			Token:     change.Token.Bytes(),
+			TokenId:   bigToProto(change.TokenID),
+			Amount:    bigToProto(change.Amount),
+			Direction: change.Direction.String(),
+		})
+	}
+
+	for _, approval := range result.Approvals {
+		m.Approvals = append(m.Approvals, &pb.Approval{
+			Kind:       approval.Kind.String(),
+			Token:      approval.Token.Bytes(),
+			Contract:   approval.Contract.Bytes(),
+			Owner:      approval.Owner.Bytes(),
+			Spender:    approval.Spender.Bytes(),
+			Amount:     bigToProto(approval.Amount),
+			TokenId:    bigToProto(approval.TokenID),
+			Approved:   approval.Approved,
+			Expiration: approval.Expiration,
+			Unlimited:  approval.Unlimited,
+		})
+	}
+
+	return m
+}
+
+func revertToProto(info *simulator.RevertInfo) *pb.Revert {
+	return &pb.Revert{
+		Kind:      info.Kind.String(),
+		Data:      info.Data,
+		Selector:  info.Selector,
+		Reason:    info.Reason,
+		PanicCode: bigToProto(info.PanicCode),
+	}
+}
+
+func callFrameToProto(frame *runtime.CallFrame) *pb.CallFrame {
+	if frame == nil {
+		return nil
+	}
+
+	m := &pb.CallFrame{
+		Type:           frame.Type,
+		From:           frame.From.Bytes(),
+		To:             optionalAddressToProto(frame.To),
+		Gas:            uint64(frame.Gas),
+		GasUsed:        uint64(frame.GasUsed),
+		Input:          frame.Input,
+		Output:         frame.Output,
+		Value:          bigToProto(frame.Value.ToInt()),
+		Error:          frame.Error,
+		RevertReason:   frame.RevertReason,
+		Implementation: optionalAddressToProto(frame.Implementation),
+	}
+	for _, call := range frame.Calls {
+		m.Calls = append(m.Calls, callFrameToProto(call))
+	}
+
+	return m
+}
+
+func accountDiffToProto(addr common.Address, diff *simulator.AccountDiff) *pb.AccountDiff {
+	m := &pb.AccountDiff{Address: addr.Bytes()}
+	if diff.Balance != nil {
+		m.Balance = &pb.BalanceChange{
+			Before: bigToProto(diff.Balance.Before),
+			After:  bigToProto(diff.Balance.After),
+		}
+	}
+	if diff.Nonce != nil {
+		m.Nonce = &pb.NonceChange{Before: diff.Nonce.Before, After: diff.Nonce.After}
+	}
+	if diff.Code != nil {
+		m.Code = &pb.CodeChange{Before: diff.Code.Before, After: diff.Code.After}
+	}
+
+	slots := make([]common.Hash, 0, len(diff.Storage))
+	for slot := range diff.Storage {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Cmp(slots[j]) < 0 })
+	for _, slot := range slots {
+		change := diff.Storage[slot]
+		m.Storage = append(m.Storage, &pb.StorageChange{
+			Slot:   slot.Bytes(),
+			Before: change.Before.Bytes(),
+			After:  change.After.Bytes(),
+		})
+	}
+
+	return m
+}
+
+func logToProto(log *types.Log) *pb.Log {
+	m := &pb.Log{Address: log.Address.Bytes(), Data: log.Data, Index: uint32(log.Index)}
+	for _, topic := range log.Topics {
+		m.Topics = append(m.Topics, topic.Bytes())
+	}
+
+	return m
+}
+
+func accessListFromProto(tuples []*pb.AccessTuple) (types.AccessList, error) {
+	if len(tuples) == 0 {
+		return nil, nil
+	}
+
+	accessList := make(types.AccessList, 0, len(tuples))
+	for _, tuple := range tuples {
+		addr, err := toAddress(tuple.Address)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]common.Hash, len(tuple.StorageKeys))
+		for i, key := range tuple.StorageKeys {
+			if keys[i], err = toHash(key); err != nil {
+				return nil, err
+			}
+		}
+		accessList = append(accessList, types.AccessTuple{Address: addr, StorageKeys: keys})
+	}
+
+	return accessList, nil
+}
+
+func accessListToProto(accessList types.AccessList) []*pb.AccessTuple {
+	tuples := make([]*pb.AccessTuple, len(accessList))
+	for i, tuple := range accessList {
+		tuples[i] = &pb.AccessTuple{Address: tuple.Address.Bytes()}
+		for _, key := range tuple.StorageKeys {
+			tuples[i].StorageKeys = append(tuples[i].StorageKeys, key.Bytes())
+		}
+	}
+
+	return tuples
+}
+
+func slotsFromProto(slots []*pb.StorageSlot) (map[common.Hash]common.Hash, error) {
+	if len(slots) == 0 {
+		return nil, nil
+	}
+
+	storage := make(map[common.Hash]common.Hash, len(slots))
+	for _, slot := range slots {
+		key, err := toHash(slot.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := toHash(slot.Value)
+		if err != nil {
+			return nil, err
+		}
+		storage[key] = value
+	}
+
+	return storage, nil
+}
+
+// sortedAddresses returns the keys of m in order, for the results to be
+// deterministic
+func sortedAddresses[V any](m map[common.Address]V) []common.Address {
+	addrs := make([]common.Address, 0, len(m))
+	for addr := range m {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Cmp(addrs[j]) < 0 })
+
+	return addrs
+}
+
+func toAddress(b []byte) (common.Address, error) {
+	if len(b) != common.AddressLength {
+		return common.Address{}, fmt.Errorf("invalid address of %d bytes", len(b))
+	}
+
+	return common.BytesToAddress(b), nil
+}
+
+func toOptionalAddress(b []byte) (*common.Address, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	addr, err := toAddress(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &addr, nil
+}
+
+func toHash(b []byte) (common.Hash, error) {
+	if len(b) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid hash of %d bytes", len(b))
+	}
+
+	return common.BytesToHash(b), nil
+}
+
+func toOptionalHash(b []byte) (*common.Hash, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	hash, err := toHash(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hash, nil
+}
+
+func optionalAddressToProto(addr *common.Address) []byte {
+	if addr == nil {
+		return nil
+	}
+
+	return addr.Bytes()
+}
+
+// bigFromProto decodes a big endian integer, nil when empty
+func bigFromProto(b []byte) *big.Int {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return new(big.Int).SetBytes(b)
+}
+
+// bigToProto encodes n big endian, empty when nil
+func bigToProto(n *big.Int) []byte {
+	if n == nil {
+		return nil
+	}
+
+	return n.Bytes()
+}
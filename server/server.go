@@ -0,0 +1,224 @@
+// Package server exposes a Simulator over HTTP, with JSON requests and responses
+// mirroring Simulation and SimulationResult, to run it as a service.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Gealber/evm-simulator/logging"
+	"github.com/Gealber/evm-simulator/metrics"
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+// simulationIDHeader is the header with the simulation ID the lines logged by a
+// request have, one is generated for each simulation when not set
+const simulationIDHeader = "X-Simulation-Id"
+
+// maxBodySize bounds the size of the requests, 10MB
+const maxBodySize = 10 << 20
+
+// Server serves the simulations of a Simulator. Every request is simulated on its
+// own fresh state, fetched from the fork of the simulator as the execution reads it.
+//
+//	POST /simulate     SimulationRequest -> SimulationResponse
+//	POST /bundle       BundleRequest -> BundleResponse
+//	POST /access-list  SimulationRequest -> simulator.AccessListResult
+//	POST /estimate-gas SimulationRequest -> EstimateGasResponse
+//	POST /trace        SimulationRequest -> server-sent TraceStep, TraceEnter and TraceExit events
+//	GET  /metrics      Prometheus metrics, with WithMetrics
+//
+// Failed requests are answered with an ErrorResponse. The root path answers JSON-RPC
+// requests as a node would, see RPC.
+type Server struct {
+	sim *simulator.Simulator
+	mux *http.ServeMux
+}
+
+// New returns a server simulating with sim.
+func New(sim *simulator.Simulator, opts ...func(*Server)) *Server {
+	s := &Server{sim: sim, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /simulate", s.handleSimulate)
+	s.mux.HandleFunc("POST /bundle", s.handleBundle)
+	s.mux.HandleFunc("POST /access-list", s.handleAccessList)
+	s.mux.HandleFunc("POST /estimate-gas", s.handleEstimateGas)
+	s.mux.HandleFunc("POST /trace", s.handleTrace)
+	s.mux.Handle("POST /{$}", NewRPC(sim))
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithMetrics serves the metrics of gatherer at /metrics, usually the registry of
+// the metrics of the simulator.
+func WithMetrics(gatherer prometheus.Gatherer) func(*Server) {
+	return func(s *Server) {
+		s.mux.Handle("GET /metrics", metrics.Handler(gatherer))
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if id := r.Header.Get(simulationIDHeader); id != "" {
+		r = r.WithContext(logging.WithSimulationID(r.Context(), id))
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	sim, ok := decodeSimulation(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := s.sim.Simulate(r.Context(), sim, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newSimulationResponse(result))
+}
+
+func (s *Server) handleBundle(w http.ResponseWriter, r *http.Request) {
+	var req BundleRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	if len(req.Simulations) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "empty bundle"})
+		return
+	}
+
+	sims := make([]simulator.Simulation, len(req.Simulations))
+	for i := range req.Simulations {
+		var err error
+		sims[i], err = req.Simulations[i].Simulation()
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("simulation %d: %s", i, err)})
+			return
+		}
+	}
+
+	results, err := s.sim.SimulateBundle(r.Context(), sims, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := BundleResponse{Results: make([]*SimulationResponse, len(results))}
+	for i, result := range results {
+		resp.Results[i] = newSimulationResponse(result)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleAccessList(w http.ResponseWriter, r *http.Request) {
+	sim, ok := decodeSimulation(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := s.sim.CreateAccessList(r.Context(), sim, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleEstimateGas(w http.ResponseWriter, r *http.Request) {
+	sim, ok := decodeSimulation(w, r)
+	if !ok {
+		return
+	}
+
+	gas, err := s.sim.EstimateGas(r.Context(), sim, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, EstimateGasResponse{Gas: hexutil.Uint64(gas)})
+}
+
+// decodeSimulation decodes the SimulationRequest of the body of r, answering with
+// the error when it's invalid
+func decodeSimulation(w http.ResponseWriter, r *http.Request) (simulator.Simulation, bool) {
+	var req SimulationRequest
+	if !decodeBody(w, r, &req) {
+		return simulator.Simulation{}, false
+	}
+
+	sim, err := req.Simulation()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return sim, false
+	}
+
+	return sim, true
+}
+
+// decodeBody decodes the JSON body of r into v, answering with the error when it's
+// invalid
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("invalid request: %s", err)})
+		return false
+	}
+
+	return true
+}
+
+// writeError answers with err, the simulations rejected by the simulator or the
+// chain rules are the fault of the request
+func writeError(w http.ResponseWriter, err error) {
+	resp := ErrorResponse{Error: err.Error(), Failure: simulator.FailureOf(err).String()}
+
+	var revertErr *simulator.RevertError
+	if errors.As(err, &revertErr) {
+		resp.Revert = newRevert(revertErr.Info)
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, simulator.ErrSimulationTimeout):
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, rpc.ErrFetchBudgetExceeded), errors.Is(err, vm.ErrStepLimitExceeded):
+		status = http.StatusUnprocessableEntity
+	case revertErr != nil,
+		errors.Is(err, simulator.ErrBundleReverted),
+		errors.Is(err, simulator.ErrInvalidBundleNonces),
+		errors.Is(err, simulator.ErrInvalidNonce),
+		errors.Is(err, simulator.ErrInvalidStateOverride),
+		errors.Is(err, simulator.ErrInsufficientBalance),
+		errors.Is(err, core.ErrInsufficientFunds),
+		errors.Is(err, core.ErrTipAboveFeeCap),
+		errors.Is(err, core.ErrFeeCapTooLow):
+		status = http.StatusUnprocessableEntity
+	}
+
+	writeJSON(w, status, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/metrics"
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newTestServer returns a server simulating on a fork with empty accounts and storage
+func newTestServer(t *testing.T) *httptest.Server {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int    `json:"id"`
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := map[string]interface{}{"id": req.ID, "jsonrpc": "2.0"}
+		switch req.Method {
+		case "eth_getStorageAt":
+			resp["result"] = common.Hash{}
+		case "eth_getBalance", "eth_getTransactionCount":
+			resp["result"] = "0x0"
+		case "eth_getCode":
+			resp["result"] = "0x"
+		default:
+			resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(node.Close)
+
+	sim, err := simulator.NewSimulator(rpc.NewClient(node.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(New(sim))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func post(t *testing.T, srv *httptest.Server, path string, req interface{}, resp interface{}) int {
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpResp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	return httpResp.StatusCode
+}
+
+var (
+	// stores the word of the calldata in slot 0 and returns it
+	storeCode = hexutil.Bytes{
+		byte(vm.PUSH0), byte(vm.CALLDATALOAD),
+		byte(vm.PUSH0), byte(vm.SSTORE),
+		byte(vm.PUSH0), byte(vm.SLOAD),
+		byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+	revertCode = hexutil.Bytes{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.REVERT)}
+
+	word = hexutil.MustDecode("0x000000000000000000000000000000000000000000000000000000000000002a")
+)
+
+func storeRequest() SimulationRequest {
+	return SimulationRequest{
+		From:  common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:    common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		Gas:   300000,
+		Code:  storeCode,
+		Input: word,
+	}
+}
+
+func TestServerSimulate(t *testing.T) {
+	srv := newTestServer(t)
+
+	req := storeRequest()
+	req.CollectStateDiff = true
+
+	var resp SimulationResponse
+	if status := post(t, srv, "/simulate", req, &resp); status != http.StatusOK {
+		t.Fatalf("status: %d", status)
+	}
+
+	if resp.Status != 1 {
+		t.Fatalf("status: %d", resp.Status)
+	}
+
+	if !bytes.Equal(resp.ReturnData, word) {
+		t.Fatalf("return data: %s", resp.ReturnData)
+	}
+
+	diff, ok := resp.StateDiff[req.To]
+	if !ok {
+		t.Fatal("missing state diff of the contract")
+	}
+
+	if change := diff.Storage[common.Hash{}]; change.After != common.BytesToHash(word) {
+		t.Fatalf("slot 0 after: %s", change.After)
+	}
+}
+
+func TestServerBundle(t *testing.T) {
+	srv := newTestServer(t)
+
+	reverting := storeRequest()
+	reverting.To = common.HexToAddress("0x0000000000000000000000000000000000000012")
+	reverting.Code = revertCode
+
+	var resp BundleResponse
+	if status := post(t, srv, "/bundle", BundleRequest{Simulations: []SimulationRequest{storeRequest(), reverting}}, &resp); status != http.StatusOK {
+		t.Fatalf("status: %d", status)
+	}
+
+	if len(resp.Results) != 2 {
+		t.Fatalf("results: %d", len(resp.Results))
+	}
+
+	if resp.Results[0].Status != 1 || resp.Results[1].Status != 0 {
+		t.Fatalf("statuses: %d %d", resp.Results[0].Status, resp.Results[1].Status)
+	}
+
+	if resp.Results[1].Revert == nil || resp.Results[1].Revert.Kind != "empty" {
+		t.Fatalf("revert: %+v", resp.Results[1].Revert)
+	}
+}
+
+func TestServerAccessList(t *testing.T) {
+	srv := newTestServer(t)
+
+	var resp simulator.AccessListResult
+	if status := post(t, srv, "/access-list", storeRequest(), &resp); status != http.StatusOK {
+		t.Fatalf("status: %d", status)
+	}
+
+	if resp.Error != "" {
+		t.Fatalf("error: %s", resp.Error)
+	}
+
+	if resp.GasUsed == 0 {
+		t.Fatal("missing gas used")
+	}
+}
+
+func TestServerEstimateGas(t *testing.T) {
+	srv := newTestServer(t)
+
+	req := storeRequest()
+	req.Gas = 0
+
+	var resp EstimateGasResponse
+	if status := post(t, srv, "/estimate-gas", req, &resp); status != http.StatusOK {
+		t.Fatalf("status: %d", status)
+	}
+
+	// a cold SSTORE of a zero slot and a warm SLOAD
+	if resp.Gas < 22100+100 {
+		t.Fatalf("gas: %d", resp.Gas)
+	}
+
+	req.Code = revertCode
+
+	var errResp ErrorResponse
+	if status := post(t, srv, "/estimate-gas", req, &errResp); status != http.StatusUnprocessableEntity {
+		t.Fatalf("status: %d", status)
+	}
+
+	if errResp.Revert == nil {
+		t.Fatalf("missing revert: %s", errResp.Error)
+	}
+}
+
+func TestServerInvalidRequest(t *testing.T) {
+	srv := newTestServer(t)
+
+	httpResp, err := http.Post(srv.URL+"/simulate", "application/json", strings.NewReader(`{"form": "0x01"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status: %d", httpResp.StatusCode)
+	}
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(resp.Error, "form") {
+		t.Fatalf("error: %s", resp.Error)
+	}
+
+	var bundleResp ErrorResponse
+	if status := post(t, srv, "/bundle", BundleRequest{}, &bundleResp); status != http.StatusBadRequest {
+		t.Fatalf("status: %d", status)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	sim, err := simulator.NewSimulator(rpc.NewClient("http://localhost"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewRegistry()
+	metrics.New(reg)
+
+	srv := httptest.NewServer(New(sim, WithMetrics(reg)))
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "evm_simulator_opcodes_executed_total") {
+		t.Fatalf("status: %d body: %s", resp.StatusCode, body)
+	}
+}
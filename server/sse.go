@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+// sseWriter writes server-sent events, flushing every event for the client to
+// receive it as it happens. Once a write fails the client is gone and the events
+// are dropped.
+type sseWriter struct {
+	w   io.Writer
+	rc  *http.ResponseController
+	err error
+}
+
+func (s *sseWriter) send(event string, v interface{}) {
+	if s.err != nil {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	if _, s.err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); s.err != nil {
+		return
+	}
+	s.err = s.rc.Flush()
+}
+
+// handleTrace simulates the request streaming its execution as server-sent events,
+// for a debugger to render it progressively: a step event per opcode executed,
+// enter and exit events per call, and the result or error event last. With
+// ?level=call only the calls are streamed.
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	level := r.URL.Query().Get("level")
+	if level != "" && level != "opcode" && level != "call" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("invalid trace level %q", level)})
+		return
+	}
+
+	sim, ok := decodeSimulation(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	events := &sseWriter{w: w, rc: http.NewResponseController(w)}
+
+	// the hooks run on the goroutine of the simulation
+	sim.Tracer = &tracing.Hooks{
+		OnEnter: func(depth int, typ byte, from, to common.Address, input []byte, gas uint64, value *big.Int) {
+			events.send("enter", TraceEnter{
+				Depth: depth,
+				Type:  vm.OpCode(typ).String(),
+				From:  from,
+				To:    to,
+				Input: input,
+				Gas:   hexutil.Uint64(gas),
+				Value: (*hexutil.Big)(value),
+			})
+		},
+		OnExit: func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+			exit := TraceExit{
+				Depth:    depth,
+				Output:   output,
+				GasUsed:  hexutil.Uint64(gasUsed),
+				Reverted: reverted,
+			}
+			if err != nil {
+				exit.Error = err.Error()
+			}
+			events.send("exit", exit)
+		},
+	}
+	if level != "call" {
+		sim.Tracer.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			step := TraceStep{
+				PC:      pc,
+				Op:      vm.OpCode(op).String(),
+				Gas:     hexutil.Uint64(gas),
+				Cost:    hexutil.Uint64(cost),
+				Depth:   depth,
+				Address: scope.Address(),
+			}
+			if err != nil {
+				step.Error = err.Error()
+			}
+			events.send("step", step)
+		}
+	}
+
+	result, err := s.sim.Simulate(r.Context(), sim, nil)
+	if err != nil {
+		resp := ErrorResponse{Error: err.Error()}
+		var revertErr *simulator.RevertError
+		if errors.As(err, &revertErr) {
+			resp.Revert = newRevert(revertErr.Info)
+		}
+		events.send("error", resp)
+		return
+	}
+
+	events.send("result", newSimulationResponse(result))
+}
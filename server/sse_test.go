@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents posts req to the trace endpoint and returns the events streamed
+func readEvents(t *testing.T, url string, req interface{}) []sseEvent {
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status: %d content type: %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	var (
+		events []sseEvent
+		event  sseEvent
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events = append(events, event)
+			event = sseEvent{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return events
+}
+
+func TestServerTrace(t *testing.T) {
+	srv := newTestServer(t)
+
+	events := readEvents(t, srv.URL+"/trace", storeRequest())
+	if len(events) != 14 {
+		t.Fatalf("events: %d", len(events))
+	}
+
+	if events[0].name != "enter" || events[12].name != "exit" || events[13].name != "result" {
+		t.Fatalf("events: %v", events)
+	}
+
+	var step TraceStep
+	if err := json.Unmarshal([]byte(events[1].data), &step); err != nil {
+		t.Fatal(err)
+	}
+	if events[1].name != "step" || step.Op != vm.PUSH0.String() || step.Depth != 1 {
+		t.Fatalf("first step: %s %+v", events[1].name, step)
+	}
+
+	var result SimulationResponse
+	if err := json.Unmarshal([]byte(events[13].data), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != 1 {
+		t.Fatalf("status: %d", result.Status)
+	}
+
+	events = readEvents(t, srv.URL+"/trace?level=call", storeRequest())
+	if len(events) != 3 {
+		t.Fatalf("call level events: %v", events)
+	}
+
+	req := storeRequest()
+	req.Code = revertCode
+
+	events = readEvents(t, srv.URL+"/trace?level=call", req)
+	last := events[len(events)-1]
+
+	var reverted SimulationResponse
+	if err := json.Unmarshal([]byte(last.data), &reverted); err != nil {
+		t.Fatal(err)
+	}
+	if last.name != "result" || reverted.Status != 0 || reverted.Revert == nil {
+		t.Fatalf("last event: %v", last)
+	}
+}
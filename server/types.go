@@ -0,0 +1,455 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// SimulationRequest is a simulator.Simulation in JSON, quantities and data are hex
+// encoded as in the JSON-RPC API of Ethereum. The hooks, precompiles, cheatcodes and
+// tracers of Simulation have no JSON form and can't be requested.
+type SimulationRequest struct {
+	From        common.Address `json:"from"`
+	To          common.Address `json:"to"`
+	BlockNumber *hexutil.Big   `json:"blockNumber,omitempty"`
+	// Gas is the gas available to the execution, unbounded when not set
+	Gas                  hexutil.Uint64                     `json:"gas,omitempty"`
+	GasPrice             *hexutil.Big                       `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big                       `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big                       `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big                       `json:"value,omitempty"`
+	Input                hexutil.Bytes                      `json:"input,omitempty"`
+	Code                 hexutil.Bytes                      `json:"code,omitempty"`
+	Coinbase             *common.Address                    `json:"coinbase,omitempty"`
+	Difficulty           *hexutil.Big                       `json:"difficulty,omitempty"`
+	Timestamp            hexutil.Uint64                     `json:"timestamp,omitempty"`
+	BaseFee              *hexutil.Big                       `json:"baseFee,omitempty"`
+	Random               *common.Hash                       `json:"random,omitempty"`
+	BlobBaseFee          *hexutil.Big                       `json:"blobBaseFee,omitempty"`
+	BlockOverrides       *BlockOverrides                    `json:"blockOverrides,omitempty"`
+	TxType               hexutil.Uint64                     `json:"type,omitempty"`
+	AccessList           types.AccessList                   `json:"accessList,omitempty"`
+	Prefetch             map[common.Address][]common.Hash   `json:"prefetch,omitempty"`
+	PrefetchAccessList   bool                               `json:"prefetchAccessList,omitempty"`
+	SpeculativeFetch     bool                               `json:"speculativeFetch,omitempty"`
+	SetCodeDelegations   []CodeDelegation                   `json:"setCodeDelegations,omitempty"`
+	StateOverrides       map[common.Address]OverrideAccount `json:"stateOverrides,omitempty"`
+	ReadOnly             bool                               `json:"readOnly,omitempty"`
+	AllowRevert          bool                               `json:"allowRevert,omitempty"`
+	Nonce                *hexutil.Uint64                    `json:"nonce,omitempty"`
+	MaxRetries           int                                `json:"maxRetries,omitempty"`
+	ChainConfig          *params.ChainConfig                `json:"chainConfig,omitempty"`
+	Hardfork             string                             `json:"hardfork,omitempty"`
+
+	ResolveProxies         bool                                      `json:"resolveProxies,omitempty"`
+	CollectCoverage        bool                                      `json:"collectCoverage,omitempty"`
+	CollectCallTrace       bool                                      `json:"collectCallTrace,omitempty"`
+	CollectGasProfile      bool                                      `json:"collectGasProfile,omitempty"`
+	CollectOpcodeHistogram bool                                      `json:"collectOpcodeHistogram,omitempty"`
+	CollectAccessReport    bool                                      `json:"collectAccessReport,omitempty"`
+	CollectRefunds         bool                                      `json:"collectRefunds,omitempty"`
+	CollectPreimages       bool                                      `json:"collectPreimages,omitempty"`
+	CollectStateDiff       bool                                      `json:"collectStateDiff,omitempty"`
+	StorageLayouts         map[common.Address]*runtime.StorageLayout `json:"storageLayouts,omitempty"`
+	StructLogger           *logger.Config                            `json:"structLogger,omitempty"`
+	// Decode decodes the call trace and the logs with the known signatures and ABIs
+	Decode bool `json:"decode,omitempty"`
+	// ABIs are JSON ABIs of contracts, they enable Decode
+	ABIs map[common.Address]json.RawMessage `json:"abis,omitempty"`
+	// Labels name addresses on top of the well known contracts, the response has
+	// the labels of the addresses involved when set
+	Labels map[common.Address]string `json:"labels,omitempty"`
+}
+
+// BlockOverrides is a simulator.BlockOverrides in JSON.
+type BlockOverrides struct {
+	Number     *hexutil.Big    `json:"number,omitempty"`
+	Time       *hexutil.Uint64 `json:"time,omitempty"`
+	BaseFee    *hexutil.Big    `json:"baseFee,omitempty"`
+	PrevRandao *common.Hash    `json:"prevRandao,omitempty"`
+	Coinbase   *common.Address `json:"coinbase,omitempty"`
+	GasLimit   *hexutil.Uint64 `json:"gasLimit,omitempty"`
+}
+
+// CodeDelegation is a simulator.CodeDelegation in JSON.
+type CodeDelegation struct {
+	Authority             common.Address `json:"authority"`
+	ImplementationAddress common.Address `json:"implementationAddress"`
+	Nonce                 hexutil.Uint64 `json:"nonce"`
+}
+
+// OverrideAccount is a simulator.OverrideAccount in JSON, as the state override set
+// of eth_call.
+type OverrideAccount struct {
+	Nonce     *hexutil.Uint64             `json:"nonce,omitempty"`
+	Code      *hexutil.Bytes              `json:"code,omitempty"`
+	Balance   *hexutil.Big                `json:"balance,omitempty"`
+	State     map[common.Hash]common.Hash `json:"state,omitempty"`
+	StateDiff map[common.Hash]common.Hash `json:"stateDiff,omitempty"`
+}
+
+// Simulation converts the request to a simulator.Simulation.
+func (r *SimulationRequest) Simulation() (simulator.Simulation, error) {
+	sim := simulator.Simulation{
+		From:                   r.From,
+		To:                     r.To,
+		BlockNumber:            toBig(r.BlockNumber),
+		GasLimit:               uint64(r.Gas),
+		GasPrice:               toBig(r.GasPrice),
+		MaxFeePerGas:           toBig(r.MaxFeePerGas),
+		MaxPriorityFeePerGas:   toBig(r.MaxPriorityFeePerGas),
+		Value:                  toBig(r.Value),
+		Input:                  r.Input,
+		Code:                   r.Code,
+		Coinbase:               r.Coinbase,
+		Difficulty:             toBig(r.Difficulty),
+		Timestamp:              uint64(r.Timestamp),
+		BaseFee:                toBig(r.BaseFee),
+		Random:                 r.Random,
+		BlobBaseFee:            toBig(r.BlobBaseFee),
+		AccessList:             r.AccessList,
+		Prefetch:               r.Prefetch,
+		PrefetchAccessList:     r.PrefetchAccessList,
+		SpeculativeFetch:       r.SpeculativeFetch,
+		ReadOnly:               r.ReadOnly,
+		AllowRevert:            r.AllowRevert,
+		MaxRetries:             r.MaxRetries,
+		ChainConfig:            r.ChainConfig,
+		Hardfork:               r.Hardfork,
+		ResolveProxies:         r.ResolveProxies,
+		CollectCoverage:        r.CollectCoverage,
+		CollectCallTrace:       r.CollectCallTrace,
+		CollectGasProfile:      r.CollectGasProfile,
+		CollectOpcodeHistogram: r.CollectOpcodeHistogram,
+		CollectAccessReport:    r.CollectAccessReport,
+		CollectRefunds:         r.CollectRefunds,
+		CollectPreimages:       r.CollectPreimages,
+		CollectStateDiff:       r.CollectStateDiff,
+		StorageLayouts:         r.StorageLayouts,
+		StructLogger:           r.StructLogger,
+	}
+
+	// the simulator expects a block number and a value
+	if sim.BlockNumber == nil {
+		sim.BlockNumber = new(big.Int)
+	}
+	if sim.Value == nil {
+		sim.Value = new(big.Int)
+	}
+
+	if r.TxType > 0xff {
+		return sim, fmt.Errorf("invalid transaction type %d", r.TxType)
+	}
+	sim.TxType = uint8(r.TxType)
+
+	if r.Nonce != nil {
+		nonce := uint64(*r.Nonce)
+		sim.Nonce = &nonce
+	}
+
+	if o := r.BlockOverrides; o != nil {
+		sim.BlockOverrides = &simulator.BlockOverrides{
+			Number:     toBig(o.Number),
+			BaseFee:    toBig(o.BaseFee),
+			PrevRandao: o.PrevRandao,
+			Coinbase:   o.Coinbase,
+			Time:       (*uint64)(o.Time),
+			GasLimit:   (*uint64)(o.GasLimit),
+		}
+	}
+
+	for _, delegation := range r.SetCodeDelegations {
+		sim.SetCodeDelegations = append(sim.SetCodeDelegations, simulator.CodeDelegation{
+			Authority:             delegation.Authority,
+			ImplementationAddress: delegation.ImplementationAddress,
+			Nonce:                 uint64(delegation.Nonce),
+		})
+	}
+
+	sim.StateOverrides = stateOverrides(r.StateOverrides)
+
+	if r.Decode || len(r.ABIs) > 0 {
+		sim.Decoder = simulator.NewDecoder()
+		for addr, data := range r.ABIs {
+			if err := sim.Decoder.RegisterJSON(addr, data); err != nil {
+				return sim, fmt.Errorf("invalid ABI of %s: %w", addr.Hex(), err)
+			}
+		}
+	}
+
+	if len(r.Labels) > 0 {
+		sim.AddressBook = simulator.NewAddressBook()
+		for addr, label := range r.Labels {
+			sim.AddressBook.SetLabel(addr, label)
+		}
+	}
+
+	return sim, nil
+}
+
+// stateOverrides converts the overrides of a request, nil when there are none
+func stateOverrides(overrides map[common.Address]OverrideAccount) map[common.Address]simulator.OverrideAccount {
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	converted := make(map[common.Address]simulator.OverrideAccount, len(overrides))
+	for addr, account := range overrides {
+		override := simulator.OverrideAccount{
+			Nonce:     (*uint64)(account.Nonce),
+			Balance:   toBig(account.Balance),
+			State:     account.State,
+			StateDiff: account.StateDiff,
+		}
+		if account.Code != nil {
+			// an empty code removes the one of the account
+			override.Code = append([]byte{}, *account.Code...)
+		}
+		converted[addr] = override
+	}
+
+	return converted
+}
+
+// SimulationResponse is a simulator.SimulationResult in JSON.
+type SimulationResponse struct {
+	BlockNumber       *hexutil.Big   `json:"blockNumber,omitempty"`
+	Status            hexutil.Uint64 `json:"status"`
+	Failure           string         `json:"failure"`
+	ReturnData        hexutil.Bytes  `json:"returnData"`
+	GasUsed           hexutil.Uint64 `json:"gasUsed"`
+	GasLimit          hexutil.Uint64 `json:"gasLimit"`
+	EffectiveGasPrice *hexutil.Big   `json:"effectiveGasPrice,omitempty"`
+	PriorityFees      *hexutil.Big   `json:"priorityFees,omitempty"`
+	CoinbaseDiff      *hexutil.Big   `json:"coinbaseDiff,omitempty"`
+	Nonce             hexutil.Uint64 `json:"nonce"`
+	// AccessList are the accounts and slots accessed by the transaction
+	AccessList       types.AccessList                 `json:"accessList,omitempty"`
+	Logs             []*types.Log                     `json:"logs"`
+	CodeCoverage     map[common.Address]hexutil.Bytes `json:"codeCoverage,omitempty"`
+	CreatedContracts []runtime.CreatedContract        `json:"createdContracts,omitempty"`
+	ConsoleLogs      []runtime.ConsoleLog             `json:"consoleLogs,omitempty"`
+	Revert           *Revert                          `json:"revert,omitempty"`
+	CallTrace        *runtime.CallFrame               `json:"callTrace,omitempty"`
+	DecodedTrace     *simulator.DecodedFrame          `json:"decodedTrace,omitempty"`
+	DecodedLogs      []*simulator.DecodedLog          `json:"decodedLogs,omitempty"`
+	Proxies          map[common.Address]Proxy         `json:"proxies,omitempty"`
+	Labels           map[common.Address]string        `json:"labels,omitempty"`
+	GasProfile       []runtime.GasProfileEntry        `json:"gasProfile,omitempty"`
+	OpcodeHistogram  map[string]*runtime.OpcodeGas    `json:"opcodeHistogram,omitempty"`
+	AccessReport     *runtime.AccessReport            `json:"accessReport,omitempty"`
+	RefundReport     *runtime.RefundReport            `json:"refundReport,omitempty"`
+	Preimages        map[common.Hash]hexutil.Bytes    `json:"preimages,omitempty"`
+	StorageAccesses  []runtime.StorageAccess          `json:"storageAccesses,omitempty"`
+	StructLogs       json.RawMessage                  `json:"structLogs,omitempty"`
+	StateDiff        simulator.StateDiff              `json:"stateDiff,omitempty"`
+	AssetChanges     []simulator.AssetChange          `json:"assetChanges,omitempty"`
+	Approvals        []simulator.ApprovalChange       `json:"approvals,omitempty"`
+	// Fetches are the requests sent to the node to discover the state read
+	Fetches []Fetch `json:"fetches,omitempty"`
+}
+
+// Revert is a simulator.RevertInfo in JSON.
+type Revert struct {
+	Kind      string        `json:"kind"`
+	Data      hexutil.Bytes `json:"data"`
+	Selector  hexutil.Bytes `json:"selector,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	PanicCode *hexutil.Big  `json:"panicCode,omitempty"`
+}
+
+func newRevert(info *simulator.RevertInfo) *Revert {
+	return &Revert{
+		Kind:      info.Kind.String(),
+		Data:      info.Data,
+		Selector:  info.Selector,
+		Reason:    info.Reason,
+		PanicCode: (*hexutil.Big)(info.PanicCode),
+	}
+}
+
+// Proxy is a vm.Proxy in JSON.
+type Proxy struct {
+	Kind           string          `json:"kind"`
+	Implementation common.Address  `json:"implementation"`
+	Beacon         *common.Address `json:"beacon,omitempty"`
+}
+
+// Fetch is a rpc.Fetch in JSON, with its latency in milliseconds.
+type Fetch struct {
+	Method  string          `json:"method"`
+	Address *common.Address `json:"address,omitempty"`
+	Slot    *common.Hash    `json:"slot,omitempty"`
+	Block   string          `json:"block,omitempty"`
+	Bytes   int             `json:"bytes"`
+	Latency float64         `json:"latencyMs"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// newSimulationResponse converts result to its JSON form
+func newSimulationResponse(result *simulator.SimulationResult) *SimulationResponse {
+	resp := &SimulationResponse{
+		BlockNumber:       (*hexutil.Big)(result.BlockNumber),
+		Status:            hexutil.Uint64(result.Status),
+		Failure:           result.Failure.String(),
+		ReturnData:        result.ReturnedData,
+		GasUsed:           hexutil.Uint64(result.GasUsed),
+		GasLimit:          hexutil.Uint64(result.GasLimit),
+		EffectiveGasPrice: (*hexutil.Big)(result.EffectiveGasPrice),
+		PriorityFees:      (*hexutil.Big)(result.PriorityFees),
+		CoinbaseDiff:      (*hexutil.Big)(result.CoinbaseDiff),
+		Nonce:             hexutil.Uint64(result.Nonce),
+		Logs:              result.Events,
+		CreatedContracts:  result.CreatedContracts,
+		ConsoleLogs:       result.ConsoleLogs,
+		CallTrace:         result.CallTrace,
+		DecodedTrace:      result.DecodedTrace,
+		DecodedLogs:       result.DecodedEvents,
+		Labels:            result.Labels,
+		GasProfile:        result.GasProfile,
+		AccessReport:      result.AccessReport,
+		RefundReport:      result.RefundReport,
+		StorageAccesses:   result.StorageAccesses,
+		StructLogs:        result.StructLogs,
+		StateDiff:         result.StateDiff,
+		AssetChanges:      result.AssetChanges,
+		Approvals:         result.Approvals,
+	}
+
+	if resp.Logs == nil {
+		resp.Logs = []*types.Log{}
+	}
+
+	if result.Record != nil {
+		resp.AccessList = result.Record.AccessList
+	}
+
+	if result.CodeCoverage != nil {
+		resp.CodeCoverage = make(map[common.Address]hexutil.Bytes, len(result.CodeCoverage))
+		for addr, bitmap := range result.CodeCoverage {
+			resp.CodeCoverage[addr] = bitmap
+		}
+	}
+
+	if result.Revert != nil {
+		resp.Revert = newRevert(result.Revert)
+	}
+
+	if result.Proxies != nil {
+		resp.Proxies = make(map[common.Address]Proxy, len(result.Proxies))
+		for addr, proxy := range result.Proxies {
+			p := Proxy{Kind: proxy.Kind.String(), Implementation: proxy.Implementation}
+			if proxy.Beacon != (common.Address{}) {
+				beacon := proxy.Beacon
+				p.Beacon = &beacon
+			}
+			resp.Proxies[addr] = p
+		}
+	}
+
+	if result.OpcodeHistogram != nil {
+		resp.OpcodeHistogram = make(map[string]*runtime.OpcodeGas, len(result.OpcodeHistogram))
+		for op, gas := range result.OpcodeHistogram {
+			resp.OpcodeHistogram[op.String()] = gas
+		}
+	}
+
+	if result.Preimages != nil {
+		resp.Preimages = make(map[common.Hash]hexutil.Bytes, len(result.Preimages))
+		for hash, preimage := range result.Preimages {
+			resp.Preimages[hash] = preimage
+		}
+	}
+
+	for _, fetch := range result.Fetches {
+		f := Fetch{
+			Method:  fetch.Method,
+			Address: fetch.Address,
+			Slot:    fetch.Slot,
+			Block:   fetch.Block,
+			Bytes:   fetch.Bytes,
+			Latency: float64(fetch.Latency) / float64(time.Millisecond),
+		}
+		if fetch.Err != nil {
+			f.Error = fetch.Err.Error()
+		}
+		resp.Fetches = append(resp.Fetches, f)
+	}
+
+	return resp
+}
+
+// BundleRequest is a bundle of simulations run in order on the same state.
+type BundleRequest struct {
+	Simulations []SimulationRequest `json:"simulations"`
+}
+
+// BundleResponse has the results of the simulations of a bundle, in order.
+type BundleResponse struct {
+	Results []*SimulationResponse `json:"results"`
+}
+
+// EstimateGasResponse is the gas estimated for a simulation, as Simulator.EstimateGas
+// the gas available to the execution without the intrinsic gas.
+type EstimateGasResponse struct {
+	Gas hexutil.Uint64 `json:"gas"`
+}
+
+// ErrorResponse is the body of the responses of failed requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Failure is the category of the error, see simulator.FailureKind
+	Failure string `json:"failure"`
+	// Revert holds the decoded revert when the simulation reverted
+	Revert *Revert `json:"revert,omitempty"`
+}
+
+func toBig(n *hexutil.Big) *big.Int {
+	if n == nil {
+		return nil
+	}
+
+	return n.ToInt()
+}
+
+// TraceStep is an opcode executed, before its execution, streamed by /trace.
+type TraceStep struct {
+	PC      uint64         `json:"pc"`
+	Op      string         `json:"op"`
+	Gas     hexutil.Uint64 `json:"gas"`
+	Cost    hexutil.Uint64 `json:"cost"`
+	Depth   int            `json:"depth"`
+	Address common.Address `json:"address"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// TraceEnter is the start of a call or a creation, streamed by /trace.
+type TraceEnter struct {
+	Depth int            `json:"depth"`
+	Type  string         `json:"type"`
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Input hexutil.Bytes  `json:"input"`
+	Gas   hexutil.Uint64 `json:"gas"`
+	Value *hexutil.Big   `json:"value,omitempty"`
+}
+
+// TraceExit is the end of a call or a creation, streamed by /trace.
+type TraceExit struct {
+	Depth    int            `json:"depth"`
+	Output   hexutil.Bytes  `json:"output"`
+	GasUsed  hexutil.Uint64 `json:"gasUsed"`
+	Error    string         `json:"error,omitempty"`
+	Reverted bool           `json:"reverted"`
+}
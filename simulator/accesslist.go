@@ -0,0 +1,104 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"slices"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// AccessListResult is the response of CreateAccessList, in the format of eth_createAccessList.
+type AccessListResult struct {
+	AccessList types.AccessList `json:"accessList"`
+	// GasUsed by the transaction with AccessList attached
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	// Error is the reason of the failure when the transaction reverted
+	Error string `json:"error,omitempty"`
+	// Labels are the names in Simulation.AddressBook of the addresses of AccessList
+	Labels map[common.Address]string `json:"labels,omitempty"`
+}
+
+// CreateAccessList returns the EIP-2930 access list of the simulation, sorted and
+// deduplicated, together with the gas used by the transaction carrying it. The
+// transaction is executed once on a copy of stateDB to record the accounts and slots
+// it touches, and once more, as an access list transaction, to measure its gas. A nil
+// stateDB is a new remote state, as with Simulate.
+func (s *Simulator) CreateAccessList(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (*AccessListResult, error) {
+	simulation, err := s.prepareSimulation(ctx, simulation)
+	if err != nil {
+		return nil, err
+	}
+
+	if stateDB == nil {
+		stateDB, err = s.NewRemoteState(ctx, simulation.BlockNumber, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	runtime.BindRemoteState(ctx, stateDB, simulation.MaxRetries)
+
+	simulation, err = s.resolveNonce(ctx, simulation, stateDB)
+	if err != nil {
+		return nil, err
+	}
+
+	// the copy shares what's fetched from the fork, the second execution doesn't fetch it again
+	result, err := s.simulate(ctx, simulation, stateDB.Copy())
+	if err != nil {
+		return nil, err
+	}
+
+	simulation.TxType = types.AccessListTxType
+	simulation.AccessList = canonicalAccessList(result.Record.AccessList, simulation.From, simulation.To)
+
+	result, err = s.simulate(ctx, simulation, stateDB)
+	if err != nil {
+		return nil, err
+	}
+
+	accessListResult := &AccessListResult{
+		AccessList: simulation.AccessList,
+		GasUsed:    hexutil.Uint64(result.GasUsed),
+	}
+	if err := result.Err(); err != nil {
+		accessListResult.Error = err.Error()
+	}
+
+	if simulation.AddressBook != nil {
+		accessListResult.Labels = simulation.AddressBook.labelsOf(accessListAddresses(simulation.AccessList)...)
+	}
+
+	return accessListResult, nil
+}
+
+// canonicalAccessList merges the entries of the same address in accessList and adds
+// the given addresses, sorting the addresses and their storage keys.
+func canonicalAccessList(accessList types.AccessList, addrs ...common.Address) types.AccessList {
+	slots := make(map[common.Address][]common.Hash, len(accessList)+len(addrs))
+	for _, addr := range addrs {
+		if _, ok := slots[addr]; !ok {
+			slots[addr] = nil
+		}
+	}
+
+	for _, tuple := range accessList {
+		slots[tuple.Address] = append(slots[tuple.Address], tuple.StorageKeys...)
+	}
+
+	canonical := make(types.AccessList, 0, len(slots))
+	for addr, keys := range slots {
+		// addresses without slots have an empty list, as in eth_createAccessList
+		keys = append([]common.Hash{}, keys...)
+		slices.SortFunc(keys, func(a, b common.Hash) int { return bytes.Compare(a[:], b[:]) })
+		canonical = append(canonical, types.AccessTuple{Address: addr, StorageKeys: slices.Compact(keys)})
+	}
+
+	slices.SortFunc(canonical, func(a, b types.AccessTuple) int { return bytes.Compare(a.Address[:], b.Address[:]) })
+
+	return canonical
+}
@@ -0,0 +1,87 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestCreateAccessList(t *testing.T) {
+	// SLOAD(2) SLOAD(1) SLOAD(1) STOP
+	code := []byte{
+		byte(vm.PUSH1), 0x02, byte(vm.SLOAD),
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD),
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD),
+		byte(vm.STOP),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return common.Hash{}.Hex(), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	to := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	simulation := Simulation{
+		From:        from,
+		To:          to,
+		Code:        code,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	result, err := sim.CreateAccessList(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := types.AccessList{
+		{Address: from, StorageKeys: []common.Hash{}},
+		{Address: to, StorageKeys: []common.Hash{common.BigToHash(common.Big1), common.BigToHash(common.Big2)}},
+	}
+	if !reflect.DeepEqual(result.AccessList, expected) {
+		t.Fatalf("access list: %+v expected: %+v", result.AccessList, expected)
+	}
+
+	// intrinsic gas with the access list and three warm SLOADs
+	if result.GasUsed != 21000+2*2400+2*1900+3*3+3*100 {
+		t.Fatalf("gas used: %d", result.GasUsed)
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded["gasUsed"] != "0x74d5" || decoded["error"] != nil {
+		t.Fatalf("unexpected json: %s", raw)
+	}
+}
@@ -0,0 +1,149 @@
+package simulator
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// knownContracts are the well known contracts of mainnet every AddressBook starts with
+var knownContracts = map[common.Address]string{
+	common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"): "WETH",
+	common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"): "USDC",
+	common.HexToAddress("0xdAC17F958D2ee523a2206206994597C13D831ec7"): "USDT",
+	common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F"): "DAI",
+	common.HexToAddress("0x2260FAC5E5542a773Aa44fBCfeDf7C193bc2C599"): "WBTC",
+	common.HexToAddress("0xae7ab96520DE3A18E5e111B5EaAb095312D7fE84"): "stETH",
+	common.HexToAddress("0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f"): "UniswapV2Factory",
+	common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D"): "UniswapV2Router02",
+	common.HexToAddress("0x1F98431c8aD98523631AE4a59f267346ea31F984"): "UniswapV3Factory",
+	common.HexToAddress("0xE592427A0AEce92De3Edee1F18E0157C05861564"): "UniswapV3SwapRouter",
+	common.HexToAddress("0x68b3465833fb72A70ecDF485E0e4C7bD8665Fc45"): "UniswapV3SwapRouter02",
+	common.HexToAddress("0xC36442b4a4522E871399CD717aBDD847Ab11FE88"): "UniswapV3PositionManager",
+	common.HexToAddress("0x3fC91A3afd70395Cd496C647d5a6CC9D4B2b7FAD"): "UniswapUniversalRouter",
+	common.HexToAddress("0x000000000022D473030F116dDEE9F6B43aC78BA3"): "Permit2",
+	common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11"): "Multicall3",
+	common.HexToAddress("0x1111111254EEB25477B68fb85Ed929f73A960582"): "1inchRouterV5",
+	common.HexToAddress("0xDef1C0ded9bec7F1a1670819833240f027b25EfF"): "0xExchangeProxy",
+	common.HexToAddress("0x00000000000000ADc04C56Bf30aC9d3c0aAF14dC"): "Seaport1.5",
+	common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"): "EntryPointV06",
+	common.HexToAddress("0x0000000071727De22E5E9d8BAf0edAc6f37da032"): "EntryPointV07",
+	ourVm.CheatcodeAddress: "Cheatcodes",
+	runtime.ConsoleAddress: "console",
+}
+
+// AddressBook names addresses, the well known contracts of mainnet and the ones
+// labeled by the user. It's safe for concurrent use.
+type AddressBook struct {
+	mu     sync.RWMutex
+	labels map[common.Address]string
+}
+
+// NewAddressBook returns an address book knowing the well known contracts.
+func NewAddressBook() *AddressBook {
+	b := &AddressBook{labels: make(map[common.Address]string, len(knownContracts))}
+	for addr, label := range knownContracts {
+		b.labels[addr] = label
+	}
+
+	return b
+}
+
+// SetLabel names addr with label, replacing the name it had. An empty label removes it.
+func (b *AddressBook) SetLabel(addr common.Address, label string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if label == "" {
+		delete(b.labels, addr)
+		return
+	}
+
+	b.labels[addr] = label
+}
+
+// Label returns the name of addr, false when it has none.
+func (b *AddressBook) Label(addr common.Address) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	label, ok := b.labels[addr]
+	return label, ok
+}
+
+// Name returns the name of addr, its hex when it has none.
+func (b *AddressBook) Name(addr common.Address) string {
+	if label, ok := b.Label(addr); ok {
+		return label
+	}
+
+	return addr.Hex()
+}
+
+// labelsOf returns the names of the addrs that have one, nil when none has
+func (b *AddressBook) labelsOf(addrs ...common.Address) map[common.Address]string {
+	var labels map[common.Address]string
+	for _, addr := range addrs {
+		if label, ok := b.Label(addr); ok {
+			if labels == nil {
+				labels = make(map[common.Address]string)
+			}
+			labels[addr] = label
+		}
+	}
+
+	return labels
+}
+
+// resultAddresses returns the addresses appearing in the trace, access list, events,
+// asset changes and approvals of result
+func resultAddresses(result *SimulationResult) []common.Address {
+	var addrs []common.Address
+
+	var walk func(frame *runtime.CallFrame)
+	walk = func(frame *runtime.CallFrame) {
+		if frame == nil {
+			return
+		}
+
+		addrs = append(addrs, frame.From)
+		if frame.To != nil {
+			addrs = append(addrs, *frame.To)
+		}
+		for _, call := range frame.Calls {
+			walk(call)
+		}
+	}
+	walk(result.CallTrace)
+
+	if result.Record != nil {
+		addrs = append(addrs, accessListAddresses(result.Record.AccessList)...)
+	}
+
+	for _, log := range result.Events {
+		addrs = append(addrs, log.Address)
+	}
+
+	for _, change := range result.AssetChanges {
+		addrs = append(addrs, change.Address, change.Token)
+	}
+
+	for _, approval := range result.Approvals {
+		addrs = append(addrs, approval.Token, approval.Contract, approval.Owner, approval.Spender)
+	}
+
+	return addrs
+}
+
+func accessListAddresses(accessList types.AccessList) []common.Address {
+	addrs := make([]common.Address, len(accessList))
+	for i, tuple := range accessList {
+		addrs[i] = tuple.Address
+	}
+
+	return addrs
+}
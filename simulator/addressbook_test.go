@@ -0,0 +1,56 @@
+package simulator
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+func TestAddressBook(t *testing.T) {
+	var (
+		book  = NewAddressBook()
+		weth  = common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+		user  = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		other = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	)
+
+	if name := book.Name(weth); name != "WETH" {
+		t.Errorf("name of WETH = %s", name)
+	}
+
+	if name := book.Name(user); name != user.Hex() {
+		t.Errorf("name of unlabeled address = %s", name)
+	}
+
+	book.SetLabel(user, "alice")
+	if label, ok := book.Label(user); !ok || label != "alice" {
+		t.Errorf("label of user = %s, %v", label, ok)
+	}
+
+	result := &SimulationResult{
+		CallTrace: &runtime.CallFrame{
+			From:  user,
+			To:    &weth,
+			Calls: []*runtime.CallFrame{{From: weth, To: &other}},
+		},
+		Record: &runtime.RecordToInitiateState{AccessList: types.AccessList{{Address: weth}}},
+		Events: []*types.Log{{Address: weth}},
+		AssetChanges: []AssetChange{
+			{Address: user, Token: weth, Amount: big.NewInt(1)},
+		},
+	}
+
+	labels := book.labelsOf(resultAddresses(result)...)
+	if len(labels) != 2 || labels[weth] != "WETH" || labels[user] != "alice" {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+
+	book.SetLabel(user, "")
+	if _, ok := book.Label(user); ok {
+		t.Error("label not removed")
+	}
+}
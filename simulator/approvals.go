@@ -0,0 +1,150 @@
+package simulator
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ApprovalKind is the mechanism granting an allowance.
+type ApprovalKind int
+
+const (
+	// ApprovalERC20 is an ERC-20 Approval, also emitted by EIP-2612 permits
+	ApprovalERC20 ApprovalKind = iota
+	// ApprovalERC721 approves a single ERC-721 token
+	ApprovalERC721
+	// ApprovalForAll approves an operator over every ERC-721 or ERC-1155 token of the owner
+	ApprovalForAll
+	// ApprovalPermit2 is an allowance granted through the Permit2 contract
+	ApprovalPermit2
+)
+
+func (k ApprovalKind) String() string {
+	switch k {
+	case ApprovalERC20:
+		return "ERC20"
+	case ApprovalERC721:
+		return "ERC721"
+	case ApprovalForAll:
+		return "ApprovalForAll"
+	case ApprovalPermit2:
+		return "Permit2"
+	default:
+		return "unknown"
+	}
+}
+
+// ApprovalChange is an allowance modified by a simulation.
+type ApprovalChange struct {
+	Kind ApprovalKind
+	// Token is the approved token, for Permit2 approvals the contract emitting the
+	// event is in Contract
+	Token    common.Address
+	Contract common.Address
+	Owner    common.Address
+	Spender  common.Address
+	// Amount approved by ERC-20 and Permit2 approvals
+	Amount *big.Int
+	// TokenID approved by ERC-721 approvals
+	TokenID *big.Int
+	// Approved is false when ApprovalForAll revokes the operator
+	Approved bool
+	// Expiration of Permit2 approvals, as a unix timestamp
+	Expiration uint64
+	// Unlimited is set for approvals of the maximum amount and for operators
+	// approved over all the tokens
+	Unlimited bool
+}
+
+var (
+	approvalTopic       = crypto.Keccak256Hash([]byte("Approval(address,address,uint256)"))
+	approvalForAllTopic = crypto.Keccak256Hash([]byte("ApprovalForAll(address,address,bool)"))
+	// events of Permit2, the token is indexed between the owner and the spender
+	permit2ApprovalTopic = crypto.Keccak256Hash([]byte("Approval(address,address,address,uint160,uint48)"))
+	permit2PermitTopic   = crypto.Keccak256Hash([]byte("Permit(address,address,address,uint160,uint48,uint48)"))
+
+	maxUint160 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 160), big.NewInt(1))
+)
+
+// Approvals decodes the allowances granted or revoked by the events of a simulation.
+// Events not following the standards are ignored.
+func Approvals(logs []*types.Log) []ApprovalChange {
+	var approvals []ApprovalChange
+	for _, log := range logs {
+		if len(log.Topics) == 0 {
+			continue
+		}
+
+		switch log.Topics[0] {
+		case approvalTopic:
+			owner, spender := topicAddress(log, 1), topicAddress(log, 2)
+			// ERC-721 indexes the token id, ERC-20 has the amount as data
+			if len(log.Topics) == 4 && len(log.Data) == 0 {
+				approvals = append(approvals, ApprovalChange{
+					Kind:     ApprovalERC721,
+					Token:    log.Address,
+					Contract: log.Address,
+					Owner:    owner,
+					Spender:  spender,
+					TokenID:  log.Topics[3].Big(),
+					Approved: spender != (common.Address{}),
+				})
+			} else if len(log.Topics) == 3 && len(log.Data) == 32 {
+				amount := new(big.Int).SetBytes(log.Data)
+				approvals = append(approvals, ApprovalChange{
+					Kind:      ApprovalERC20,
+					Token:     log.Address,
+					Contract:  log.Address,
+					Owner:     owner,
+					Spender:   spender,
+					Amount:    amount,
+					Approved:  amount.Sign() > 0,
+					Unlimited: amount.Cmp(math.MaxBig256) == 0,
+				})
+			}
+		case approvalForAllTopic:
+			if len(log.Topics) != 3 || len(log.Data) != 32 {
+				continue
+			}
+
+			approved := new(big.Int).SetBytes(log.Data).Sign() != 0
+			approvals = append(approvals, ApprovalChange{
+				Kind:      ApprovalForAll,
+				Token:     log.Address,
+				Contract:  log.Address,
+				Owner:     topicAddress(log, 1),
+				Spender:   topicAddress(log, 2),
+				Approved:  approved,
+				Unlimited: approved,
+			})
+		case permit2ApprovalTopic, permit2PermitTopic:
+			// both start with the amount and the expiration, Permit is followed by the nonce
+			if len(log.Topics) != 4 || len(log.Data) < 64 {
+				continue
+			}
+
+			amount := new(big.Int).SetBytes(log.Data[:32])
+			approvals = append(approvals, ApprovalChange{
+				Kind:       ApprovalPermit2,
+				Token:      topicAddress(log, 2),
+				Contract:   log.Address,
+				Owner:      topicAddress(log, 1),
+				Spender:    topicAddress(log, 3),
+				Amount:     amount,
+				Approved:   amount.Sign() > 0,
+				Expiration: new(big.Int).SetBytes(log.Data[32:64]).Uint64(),
+				Unlimited:  amount.Cmp(maxUint160) == 0,
+			})
+		}
+	}
+
+	return approvals
+}
+
+func topicAddress(log *types.Log, i int) common.Address {
+	return common.BytesToAddress(log.Topics[i].Bytes())
+}
@@ -0,0 +1,63 @@
+package simulator
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestApprovals(t *testing.T) {
+	var (
+		owner   = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+		spender = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+		usdc    = common.HexToAddress("0x0000000000000000000000000000000000000020")
+		nft     = common.HexToAddress("0x0000000000000000000000000000000000000721")
+		permit2 = common.HexToAddress("0x000000000022d473030f116ddee9f6b43ac78ba3")
+	)
+
+	topic := func(addr common.Address) common.Hash { return common.BytesToHash(addr.Bytes()) }
+	word := func(n *big.Int) []byte { return common.BigToHash(n).Bytes() }
+
+	logs := []*types.Log{
+		{Address: usdc, Topics: []common.Hash{approvalTopic, topic(owner), topic(spender)}, Data: word(math.MaxBig256)},
+		{Address: usdc, Topics: []common.Hash{approvalTopic, topic(owner), topic(spender)}, Data: word(big.NewInt(0))},
+		{Address: nft, Topics: []common.Hash{approvalTopic, topic(owner), topic(spender), common.BigToHash(big.NewInt(7))}},
+		{Address: nft, Topics: []common.Hash{approvalForAllTopic, topic(owner), topic(spender)}, Data: word(big.NewInt(1))},
+		{Address: permit2, Topics: []common.Hash{permit2ApprovalTopic, topic(owner), topic(usdc), topic(spender)}, Data: append(word(maxUint160), word(big.NewInt(1700000000))...)},
+		// transfers aren't approvals
+		{Address: usdc, Topics: []common.Hash{transferTopic, topic(owner), topic(spender)}, Data: word(big.NewInt(1))},
+	}
+
+	approvals := Approvals(logs)
+	if len(approvals) != 5 {
+		t.Fatalf("approvals: %+v", approvals)
+	}
+
+	if a := approvals[0]; a.Kind != ApprovalERC20 || a.Token != usdc || a.Owner != owner || a.Spender != spender || !a.Unlimited || !a.Approved {
+		t.Fatalf("unexpected unlimited approval: %+v", a)
+	}
+
+	if a := approvals[1]; a.Kind != ApprovalERC20 || a.Approved || a.Unlimited || a.Amount.Sign() != 0 {
+		t.Fatalf("unexpected revoke: %+v", a)
+	}
+
+	if a := approvals[2]; a.Kind != ApprovalERC721 || a.Token != nft || a.TokenID.Int64() != 7 || !a.Approved || a.Unlimited {
+		t.Fatalf("unexpected nft approval: %+v", a)
+	}
+
+	if a := approvals[3]; a.Kind != ApprovalForAll || a.Spender != spender || !a.Approved || !a.Unlimited {
+		t.Fatalf("unexpected approval for all: %+v", a)
+	}
+
+	a := approvals[4]
+	if a.Kind != ApprovalPermit2 || a.Token != usdc || a.Contract != permit2 || a.Owner != owner || a.Spender != spender {
+		t.Fatalf("unexpected permit2 approval: %+v", a)
+	}
+
+	if !a.Unlimited || a.Expiration != 1700000000 {
+		t.Fatalf("unexpected permit2 allowance: %+v", a)
+	}
+}
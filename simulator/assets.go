@@ -0,0 +1,175 @@
+package simulator
+
+import (
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// AssetDirection tells whether an address receives or sends an asset.
+type AssetDirection int
+
+const (
+	AssetIn AssetDirection = iota
+	AssetOut
+)
+
+func (d AssetDirection) String() string {
+	if d == AssetOut {
+		return "out"
+	}
+
+	return "in"
+}
+
+// AssetChange is the net change of the balance of an asset held by an address.
+type AssetChange struct {
+	Address  common.Address
+	Standard TokenStandard
+	// Token is the contract of the asset, zero for the native currency
+	Token common.Address
+	// TokenID of ERC-721 and ERC-1155 assets
+	TokenID *big.Int
+	// Amount is the absolute value of the change, 1 for ERC-721 tokens
+	Amount    *big.Int
+	Direction AssetDirection
+}
+
+// topics of the ERC-1155 transfer events, the one of ERC-20 and ERC-721 is transferTopic
+var (
+	transferSingleTopic = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	transferBatchTopic  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+
+	uint256ArrayTy, _     = abi.NewType("uint256[]", "", nil)
+	transferBatchArgument = abi.Arguments{{Type: uint256ArrayTy}, {Type: uint256ArrayTy}}
+)
+
+// assetKey identifies the balance of an asset held by an address
+type assetKey struct {
+	address  common.Address
+	standard TokenStandard
+	token    common.Address
+	tokenID  string
+}
+
+// assetDeltas accumulates the balance changes of every address and asset, in the order
+// they are first seen.
+type assetDeltas struct {
+	keys   []assetKey
+	deltas map[assetKey]*big.Int
+	ids    map[assetKey]*big.Int
+}
+
+func (a *assetDeltas) add(addr common.Address, standard TokenStandard, token common.Address, id, amount *big.Int) {
+	// mints and burns only change the balance of one side
+	if addr == (common.Address{}) && standard != TokenStandardNative {
+		return
+	}
+
+	key := assetKey{address: addr, standard: standard, token: token}
+	if id != nil {
+		key.tokenID = id.String()
+	}
+
+	delta, ok := a.deltas[key]
+	if !ok {
+		delta = new(big.Int)
+		a.deltas[key] = delta
+		a.ids[key] = id
+		a.keys = append(a.keys, key)
+	}
+	delta.Add(delta, amount)
+}
+
+func (a *assetDeltas) transfer(from, to common.Address, standard TokenStandard, token common.Address, id, amount *big.Int) {
+	a.add(from, standard, token, id, new(big.Int).Neg(amount))
+	a.add(to, standard, token, id, amount)
+}
+
+// AssetChanges computes the net asset changes of every address from the ether
+// transfers of an execution and the ERC-20, ERC-721 and ERC-1155 transfer events
+// it emitted. Events not following the standards are ignored, as are assets whose
+// balance ends unchanged.
+func AssetChanges(transfers []runtime.ValueTransfer, logs []*types.Log) []AssetChange {
+	deltas := &assetDeltas{
+		deltas: make(map[assetKey]*big.Int),
+		ids:    make(map[assetKey]*big.Int),
+	}
+
+	for _, transfer := range transfers {
+		deltas.transfer(transfer.From, transfer.To, TokenStandardNative, common.Address{}, nil, transfer.Value)
+	}
+
+	for _, log := range logs {
+		if len(log.Topics) == 0 {
+			continue
+		}
+
+		switch log.Topics[0] {
+		case transferTopic:
+			// ERC-721 indexes the token id, ERC-20 has the amount as data
+			if len(log.Topics) == 4 && len(log.Data) == 0 {
+				from, to := topicAddress(log, 1), topicAddress(log, 2)
+				deltas.transfer(from, to, TokenStandardERC721, log.Address, log.Topics[3].Big(), big.NewInt(1))
+			} else if len(log.Topics) == 3 && len(log.Data) == 32 {
+				from, to := topicAddress(log, 1), topicAddress(log, 2)
+				deltas.transfer(from, to, TokenStandardERC20, log.Address, nil, new(big.Int).SetBytes(log.Data))
+			}
+		case transferSingleTopic:
+			if len(log.Topics) != 4 || len(log.Data) != 64 {
+				continue
+			}
+
+			from, to := topicAddress(log, 2), topicAddress(log, 3)
+			id, amount := new(big.Int).SetBytes(log.Data[:32]), new(big.Int).SetBytes(log.Data[32:])
+			deltas.transfer(from, to, TokenStandardERC1155, log.Address, id, amount)
+		case transferBatchTopic:
+			if len(log.Topics) != 4 {
+				continue
+			}
+
+			values, err := transferBatchArgument.Unpack(log.Data)
+			if err != nil {
+				continue
+			}
+
+			ids, amounts := values[0].([]*big.Int), values[1].([]*big.Int)
+			if len(ids) != len(amounts) {
+				continue
+			}
+
+			from, to := topicAddress(log, 2), topicAddress(log, 3)
+			for i := range ids {
+				deltas.transfer(from, to, TokenStandardERC1155, log.Address, ids[i], amounts[i])
+			}
+		}
+	}
+
+	var changes []AssetChange
+	for _, key := range deltas.keys {
+		delta := deltas.deltas[key]
+		if delta.Sign() == 0 {
+			continue
+		}
+
+		change := AssetChange{
+			Address:   key.address,
+			Standard:  key.standard,
+			Token:     key.token,
+			TokenID:   deltas.ids[key],
+			Amount:    new(big.Int).Abs(delta),
+			Direction: AssetIn,
+		}
+		if delta.Sign() < 0 {
+			change.Direction = AssetOut
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
@@ -0,0 +1,72 @@
+package simulator
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestAssetChanges(t *testing.T) {
+	var (
+		alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+		bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+		usdc  = common.HexToAddress("0x0000000000000000000000000000000000000020")
+		nft   = common.HexToAddress("0x0000000000000000000000000000000000000721")
+		items = common.HexToAddress("0x0000000000000000000000000000000000001155")
+	)
+
+	word := func(n int64) []byte { return common.BigToHash(big.NewInt(n)).Bytes() }
+	topic := func(addr common.Address) common.Hash { return common.BytesToHash(addr.Bytes()) }
+
+	batchData, err := transferBatchArgument.Pack([]*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(10), big.NewInt(20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := []*types.Log{
+		// alice sends 100 USDC to bob, who sends back 40
+		{Address: usdc, Topics: []common.Hash{transferTopic, topic(alice), topic(bob)}, Data: word(100)},
+		{Address: usdc, Topics: []common.Hash{transferTopic, topic(bob), topic(alice)}, Data: word(40)},
+		// nft 7 is minted to bob
+		{Address: nft, Topics: []common.Hash{transferTopic, {}, topic(bob), common.BigToHash(big.NewInt(7))}},
+		// bob sends 5 of item 1, then a batch of items 1 and 2 to alice
+		{Address: items, Topics: []common.Hash{transferSingleTopic, topic(bob), topic(bob), topic(alice)}, Data: append(word(1), word(5)...)},
+		{Address: items, Topics: []common.Hash{transferBatchTopic, topic(bob), topic(bob), topic(alice)}, Data: batchData},
+		// unrelated event
+		{Address: usdc, Topics: []common.Hash{{0x01}}},
+	}
+
+	transfers := []runtime.ValueTransfer{{From: alice, To: bob, Value: big.NewInt(3)}}
+
+	changes := AssetChanges(transfers, logs)
+
+	expected := []AssetChange{
+		{Address: alice, Standard: TokenStandardNative, Amount: big.NewInt(3), Direction: AssetOut},
+		{Address: bob, Standard: TokenStandardNative, Amount: big.NewInt(3), Direction: AssetIn},
+		{Address: alice, Standard: TokenStandardERC20, Token: usdc, Amount: big.NewInt(60), Direction: AssetOut},
+		{Address: bob, Standard: TokenStandardERC20, Token: usdc, Amount: big.NewInt(60), Direction: AssetIn},
+		{Address: bob, Standard: TokenStandardERC721, Token: nft, TokenID: big.NewInt(7), Amount: big.NewInt(1), Direction: AssetIn},
+		{Address: bob, Standard: TokenStandardERC1155, Token: items, TokenID: big.NewInt(1), Amount: big.NewInt(15), Direction: AssetOut},
+		{Address: alice, Standard: TokenStandardERC1155, Token: items, TokenID: big.NewInt(1), Amount: big.NewInt(15), Direction: AssetIn},
+		{Address: bob, Standard: TokenStandardERC1155, Token: items, TokenID: big.NewInt(2), Amount: big.NewInt(20), Direction: AssetOut},
+		{Address: alice, Standard: TokenStandardERC1155, Token: items, TokenID: big.NewInt(2), Amount: big.NewInt(20), Direction: AssetIn},
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("changes: %+v", changes)
+	}
+
+	for i, change := range changes {
+		want := expected[i]
+		sameID := (change.TokenID == nil && want.TokenID == nil) ||
+			(change.TokenID != nil && want.TokenID != nil && change.TokenID.Cmp(want.TokenID) == 0)
+
+		if change.Address != want.Address || change.Standard != want.Standard || change.Token != want.Token ||
+			!sameID || change.Amount.Cmp(want.Amount) != 0 || change.Direction != want.Direction {
+			t.Fatalf("change %d: %+v expected %+v", i, change, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+func TestSimulateFetchBudget(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	// loads slots 0, 1 and 2
+	code := []byte{
+		byte(vm.PUSH0), byte(vm.SLOAD),
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD),
+		byte(vm.PUSH1), 0x02, byte(vm.SLOAD),
+		byte(vm.STOP),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return common.Hash{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == contract {
+				return hexutil.Bytes(code), nil
+			}
+			return hexutil.Bytes{}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          contract,
+		Code:        code,
+		BlockNumber: big.NewInt(1),
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slots := make(map[common.Hash]bool)
+	for _, fetch := range result.Fetches {
+		if fetch.Method != "eth_getStorageAt" {
+			continue
+		}
+		if fetch.Address == nil || *fetch.Address != contract {
+			t.Errorf("storage fetched from %v, want %v", fetch.Address, contract)
+		}
+		if fetch.Block != "0x1" {
+			t.Errorf("storage fetched at block %q, want 0x1", fetch.Block)
+		}
+		if fetch.Bytes == 0 {
+			t.Error("storage fetched without bytes")
+		}
+		slots[*fetch.Slot] = true
+	}
+	for i := int64(0); i < 3; i++ {
+		if !slots[common.BigToHash(big.NewInt(i))] {
+			t.Errorf("slot %d missing in the fetches %+v", i, result.Fetches)
+		}
+	}
+
+	budget := len(result.Fetches) - 1
+	sim, err = NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil), WithFetchBudget(budget))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sim.Simulate(context.Background(), simulation, nil)
+	if !errors.Is(err, rpc.ErrFetchBudgetExceeded) {
+		t.Fatalf("simulation over budget failed with %v, want %v", err, rpc.ErrFetchBudgetExceeded)
+	}
+
+	_, err = sim.SimulateBundle(context.Background(), []Simulation{simulation}, nil)
+	if !errors.Is(err, rpc.ErrFetchBudgetExceeded) {
+		t.Fatalf("bundle over budget failed with %v, want %v", err, rpc.ErrFetchBudgetExceeded)
+	}
+
+	sim, err = NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil), WithFetchBudget(len(result.Fetches)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := sim.SimulateBundle(context.Background(), []Simulation{simulation}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the state is fetched by the bundle item, its nonces before it
+	storage := 0
+	for _, fetch := range results[0].Fetches {
+		if fetch.Method == "eth_getStorageAt" {
+			storage++
+		}
+	}
+	if storage != 3 {
+		t.Errorf("bundle item logged %d storage fetches, want 3", storage)
+	}
+}
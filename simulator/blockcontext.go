@@ -0,0 +1,182 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+)
+
+// BlockOverrides replace the block context of a simulation, as the block overrides
+// of eth_call. They take precedence over the block context fields of Simulation,
+// nil fields are left unchanged.
+type BlockOverrides struct {
+	// Number is exposed to NUMBER, the state is still fetched from BlockNumber
+	Number     *big.Int
+	Time       *uint64
+	BaseFee    *big.Int
+	PrevRandao *common.Hash
+	Coinbase   *common.Address
+	// GasLimit is exposed to GASLIMIT, it doesn't bound the gas of the transaction
+	GasLimit *uint64
+}
+
+// apply sets the overrides in the block context of cfg
+func (o *BlockOverrides) apply(cfg *runtime.Config) {
+	if o == nil {
+		return
+	}
+
+	if o.Number != nil {
+		cfg.BlockNumber = o.Number
+	}
+
+	if o.Time != nil {
+		cfg.Time = *o.Time
+	}
+
+	if o.BaseFee != nil {
+		cfg.BaseFee = o.BaseFee
+	}
+
+	if o.PrevRandao != nil {
+		cfg.Random = o.PrevRandao
+	}
+
+	if o.Coinbase != nil {
+		cfg.Coinbase = *o.Coinbase
+	}
+
+	if o.GasLimit != nil {
+		cfg.BlockGasLimit = *o.GasLimit
+	}
+}
+
+// pinLatestBlock pins the simulations without block number to the latest block, its
+// number fetched once so all of them read the state of the same block even if a new
+// one is mined meanwhile. They're left at the latest block when the fork doesn't
+// serve its number.
+func (s *Simulator) pinLatestBlock(ctx context.Context, simulations []Simulation) error {
+	latest, err := s.latestBlock(ctx, simulations)
+	if err != nil || latest == nil {
+		return err
+	}
+
+	for i := range simulations {
+		if simulations[i].BlockNumber == nil || simulations[i].BlockNumber.Sign() == 0 {
+			simulations[i].BlockNumber = new(big.Int).Set(latest)
+		}
+	}
+
+	return nil
+}
+
+// withBlockContext fills the block context of simulation not set by the caller with
+// the header of its block: coinbase, timestamp, base fee, gas limit, difficulty or
+// prevrandao and blob base fee. Simulations without block number are pinned to the latest block
+// when the chain was detected or their fees are filled, see WithNextBlockFees. When
+// the node can't serve the block the runtime defaults are kept.
+func (s *Simulator) withBlockContext(ctx context.Context, simulation Simulation) (Simulation, error) {
+	blk := "latest"
+	if simulation.BlockNumber != nil && simulation.BlockNumber.Sign() > 0 {
+		blk = "0x" + simulation.BlockNumber.Text(16)
+	} else if s.ChainConfig() == nil && simulation.ChainConfig == nil && !s.nextBlockFees {
+		// the default rules don't depend on the block, no need to know it
+		return simulation, nil
+	}
+
+	header, err := s.Cache.BlockHeader(ctx, s.RPCClt, blk)
+	if err != nil {
+		var rpcErr *rpc.ErrResponse
+		if errors.As(err, &rpcErr) || errors.Is(err, rpc.ErrBlockNotFound) {
+			return simulation, nil
+		}
+
+		return simulation, err
+	}
+
+	// the fork rules of the chain depend on the block, pin the latest one
+	if blk == "latest" && header.Number != nil {
+		simulation.BlockNumber = header.Number.ToInt()
+
+		if s.nextBlockFees {
+			fees, err := s.NextBlockFees(ctx, simulation.BlockNumber)
+			if err != nil {
+				return simulation, err
+			}
+			simulation = applyNextBlockFees(simulation, fees)
+		}
+	}
+
+	return applyBlockHeader(simulation, header), nil
+}
+
+// applyBlockHeader sets the block context fields of simulation still unset from header
+func applyBlockHeader(simulation Simulation, header *rpc.BlockHeader) Simulation {
+	if simulation.Coinbase == nil {
+		coinbase := header.Miner
+		simulation.Coinbase = &coinbase
+	}
+
+	if simulation.Timestamp == 0 {
+		simulation.Timestamp = uint64(header.Timestamp)
+	}
+
+	if simulation.BaseFee == nil && header.BaseFee != nil {
+		simulation.BaseFee = header.BaseFee.ToInt()
+	}
+
+	if simulation.BlockGasLimit == 0 {
+		simulation.BlockGasLimit = uint64(header.GasLimit)
+	}
+
+	if simulation.Difficulty == nil && header.Difficulty != nil {
+		simulation.Difficulty = header.Difficulty.ToInt()
+	}
+
+	// after the merge the mix hash holds the prevrandao
+	if simulation.Random == nil && simulation.Difficulty != nil && simulation.Difficulty.Sign() == 0 {
+		random := header.MixHash
+		simulation.Random = &random
+	}
+
+	if simulation.BlobBaseFee == nil && header.ExcessBlobGas != nil {
+		simulation.BlobBaseFee = eip4844.CalcBlobFee(uint64(*header.ExcessBlobGas))
+	}
+
+	return simulation
+}
+
+// blockHashFn returns a GetHashFn fetching the hashes of the blocks from the fork,
+// the headers are cached in SimulationCache. Blocks the fork can't serve have a
+// zero hash, as BLOCKHASH returns for unknown blocks.
+func (s *Simulator) blockHashFn(ctx context.Context) func(n uint64) common.Hash {
+	return func(n uint64) common.Hash {
+		blk := hexutil.EncodeUint64(n)
+		if n == 0 {
+			// block 0 stands for the latest one to the client
+			blk = rpc.EarliestBlock
+		}
+
+		if s.provider != nil {
+			hash, err := s.provider.GetBlockHash(ctx, blk)
+			if err != nil {
+				return common.Hash{}
+			}
+
+			return hash
+		}
+
+		header, err := s.Cache.BlockHeader(ctx, s.RPCClt, blk)
+		if err != nil {
+			return common.Hash{}
+		}
+
+		return header.Hash
+	}
+}
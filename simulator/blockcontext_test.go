@@ -0,0 +1,425 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestSimulateBlockContext(t *testing.T) {
+	// returns TIMESTAMP, BASEFEE, PREVRANDAO, COINBASE and GASLIMIT
+	code := []byte{
+		byte(vm.TIMESTAMP), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.BASEFEE), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PREVRANDAO), byte(vm.PUSH1), 0x40, byte(vm.MSTORE),
+		byte(vm.COINBASE), byte(vm.PUSH1), 0x60, byte(vm.MSTORE),
+		byte(vm.GASLIMIT), byte(vm.PUSH1), 0x80, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0xa0, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		miner     = common.HexToAddress("0x95222290dd7278aa3ddd389cc1e1d165cc4bafe5")
+		mixHash   = common.HexToHash("0x4ff2a9d2b6e8fd5ba6bd30c1a6b8fa7f56e2b8d3c1c6d4e3f2a1b0c9d8e7f6a5")
+		timestamp = uint64(1710338135)
+		baseFee   = big.NewInt(40_000_000_000)
+		gasLimit  = uint64(30_000_000)
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{
+				"number":        "0x12a05f2",
+				"miner":         miner,
+				"timestamp":     hexutil.Uint64(timestamp),
+				"baseFeePerGas": (*hexutil.Big)(baseFee),
+				"gasLimit":      hexutil.Uint64(gasLimit),
+				"difficulty":    "0x0",
+				"mixHash":       mixHash,
+				"excessBlobGas": "0x0",
+			}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(19_531_250),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := result.ReturnedData
+	if got := new(big.Int).SetBytes(ret[:32]).Uint64(); got != timestamp {
+		t.Fatalf("timestamp: %d expected: %d", got, timestamp)
+	}
+	if got := new(big.Int).SetBytes(ret[32:64]); got.Cmp(baseFee) != 0 {
+		t.Fatalf("base fee: %s expected: %s", got, baseFee)
+	}
+	if got := common.BytesToHash(ret[64:96]); got != mixHash {
+		t.Fatalf("prevrandao: %s expected: %s", got.Hex(), mixHash.Hex())
+	}
+	if got := common.BytesToAddress(ret[96:128]); got != miner {
+		t.Fatalf("coinbase: %s expected: %s", got.Hex(), miner.Hex())
+	}
+	if got := new(big.Int).SetBytes(ret[128:160]).Uint64(); got != gasLimit {
+		t.Fatalf("gas limit: %d expected: %d", got, gasLimit)
+	}
+
+	// fields set by the caller take precedence over the header
+	simulation.Timestamp = 42
+	result, err = sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := new(big.Int).SetBytes(result.ReturnedData[:32]).Uint64(); got != 42 {
+		t.Fatalf("timestamp: %d expected: 42", got)
+	}
+
+	// the header is fetched once per block
+	if calls := srv.Calls("eth_getBlockByNumber"); calls != 1 {
+		t.Fatalf("eth_getBlockByNumber called %d times expected 1", calls)
+	}
+}
+
+func TestSimulateBlockHash(t *testing.T) {
+	// BLOCKHASH(NUMBER - 1) PUSH0 MSTORE RETURN(0, 32)
+	code := []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.NUMBER), byte(vm.SUB), byte(vm.BLOCKHASH),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	parentHash := common.HexToHash("0x3c9d1f0e6b2a4c8d7e5f9a1b3c5d7e9f0a2b4c6d8e0f1a3b5c7d9e1f2a4b6c8d")
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getBlockByNumber":
+			var blk string
+			json.Unmarshal(params[0], &blk)
+
+			header := map[string]interface{}{"number": blk, "difficulty": "0x0"}
+			if blk == "0x63" {
+				header["hash"] = parentHash
+			}
+
+			return header, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(100),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := sim.Simulate(context.Background(), simulation, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := common.BytesToHash(result.ReturnedData); got != parentHash {
+			t.Fatalf("block hash: %s expected: %s", got.Hex(), parentHash.Hex())
+		}
+	}
+
+	// headers of the block and its parent, fetched once
+	if calls := srv.Calls("eth_getBlockByNumber"); calls != 2 {
+		t.Fatalf("eth_getBlockByNumber called %d times expected 2", calls)
+	}
+}
+
+func TestSimulateGenesisBlockHash(t *testing.T) {
+	// BLOCKHASH(0) PUSH0 MSTORE RETURN(0, 32)
+	code := []byte{
+		byte(vm.PUSH0), byte(vm.BLOCKHASH),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		genesisHash = common.HexToHash("0xd4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3")
+		latestHash  = common.HexToHash("0x0101010101010101010101010101010101010101010101010101010101010101")
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getBlockByNumber":
+			var blk string
+			json.Unmarshal(params[0], &blk)
+
+			switch blk {
+			case "earliest":
+				return map[string]interface{}{"number": "0x0", "hash": genesisHash, "difficulty": "0x0"}, nil
+			case "latest":
+				return map[string]interface{}{"number": "0x64", "hash": latestHash, "difficulty": "0x0"}, nil
+			}
+
+			return map[string]interface{}{"number": blk, "difficulty": "0x0"}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(100),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := common.BytesToHash(result.ReturnedData); got != genesisHash {
+		t.Fatalf("block hash: %s expected the genesis one: %s", got.Hex(), genesisHash.Hex())
+	}
+
+	// the latest header isn't cached as the one of block 0
+	header, err := sim.Cache.BlockHeader(context.Background(), sim.RPCClt, "0x0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Hash != latestHash {
+		t.Fatalf("latest hash: %s expected: %s", header.Hash.Hex(), latestHash.Hex())
+	}
+}
+
+func TestSimulationCacheHeadersBound(t *testing.T) {
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		var blk string
+		json.Unmarshal(params[0], &blk)
+
+		return map[string]interface{}{"number": blk}, nil
+	})
+	clt := rpc.NewClient(srv.URL)
+
+	cache := NewSimulationCache()
+	for n := uint64(1); n <= headersCacheSize+1; n++ {
+		if _, err := cache.BlockHeader(context.Background(), clt, hexutil.EncodeUint64(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if cache.headers.Len() != headersCacheSize {
+		t.Fatalf("headers cached: %d expected: %d", cache.headers.Len(), headersCacheSize)
+	}
+
+	// the least recently used header was evicted
+	if _, err := cache.BlockHeader(context.Background(), clt, "0x1"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := srv.Calls("eth_getBlockByNumber"); calls != headersCacheSize+2 {
+		t.Fatalf("eth_getBlockByNumber called %d times expected %d", calls, headersCacheSize+2)
+	}
+}
+
+func TestSimulateBlockOverrides(t *testing.T) {
+	// returns NUMBER, TIMESTAMP, BASEFEE, PREVRANDAO, COINBASE, GASLIMIT and SLOAD(1)
+	code := []byte{
+		byte(vm.NUMBER), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.TIMESTAMP), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.BASEFEE), byte(vm.PUSH1), 0x40, byte(vm.MSTORE),
+		byte(vm.PREVRANDAO), byte(vm.PUSH1), 0x60, byte(vm.MSTORE),
+		byte(vm.COINBASE), byte(vm.PUSH1), 0x80, byte(vm.MSTORE),
+		byte(vm.GASLIMIT), byte(vm.PUSH1), 0xa0, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.PUSH1), 0xc0, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0xe0, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		number     = big.NewInt(20_000_000)
+		timestamp  = uint64(1893456000)
+		baseFee    = big.NewInt(7)
+		prevRandao = common.HexToHash("0x01")
+		coinbase   = common.HexToAddress("0x000000000000000000000000000000000000c0de")
+		gasLimit   = uint64(36_000_000)
+		// blocks of the requests fetching state
+		stateBlocks []string
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getStorageAt":
+			var blk string
+			if err := json.Unmarshal(params[2], &blk); err != nil {
+				return nil, err
+			}
+			stateBlocks = append(stateBlocks, blk)
+
+			return common.BigToHash(big.NewInt(3)).Hex(), nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(19_531_250),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+		Timestamp:   1,
+		BlockOverrides: &BlockOverrides{
+			Number:     number,
+			Time:       &timestamp,
+			BaseFee:    baseFee,
+			PrevRandao: &prevRandao,
+			Coinbase:   &coinbase,
+			GasLimit:   &gasLimit,
+		},
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	word := func(i int) *big.Int { return new(big.Int).SetBytes(result.ReturnedData[i*32 : (i+1)*32]) }
+
+	if word(0).Cmp(number) != 0 || word(1).Uint64() != timestamp || word(2).Cmp(baseFee) != 0 {
+		t.Fatalf("number: %s timestamp: %s base fee: %s", word(0), word(1), word(2))
+	}
+
+	if common.BigToHash(word(3)) != prevRandao || common.BigToAddress(word(4)) != coinbase || word(5).Uint64() != gasLimit {
+		t.Fatalf("prevrandao: %s coinbase: %s gas limit: %s", word(3), word(4), word(5))
+	}
+
+	// the state is still read from the block of the simulation
+	if word(6).Int64() != 3 || len(stateBlocks) != 1 || stateBlocks[0] != "0x12a05f2" {
+		t.Fatalf("slot: %s fetched at: %v", word(6), stateBlocks)
+	}
+}
+
+func TestSimulateBundlePinsLatestBlock(t *testing.T) {
+	// returns NUMBER
+	code := []byte{
+		byte(vm.NUMBER), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var latest uint64 = 0x64
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_blockNumber":
+			// a block is mined after every request
+			latest++
+			return hexutil.Uint64(latest - 1), nil
+		case "eth_getCode", "eth_getBalance", "eth_getTransactionCount":
+			var blk string
+			if err := json.Unmarshal(params[1], &blk); err != nil {
+				return nil, err
+			}
+			if blk != "0x64" {
+				return nil, errors.New("unexpected block " + blk)
+			}
+
+			if method == "eth_getCode" {
+				return hexutil.Bytes(code), nil
+			}
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulations := make([]Simulation, 2)
+	for i := range simulations {
+		simulations[i] = Simulation{
+			From:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+			To:       common.HexToAddress("0x0000000000000000000000000000000000000011"),
+			GasLimit: 100000,
+			GasPrice: big.NewInt(0),
+			Value:    big.NewInt(0),
+		}
+	}
+
+	results, err := sim.SimulateBundle(context.Background(), simulations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, result := range results {
+		if result.BlockNumber == nil || result.BlockNumber.Uint64() != 0x64 {
+			t.Fatalf("result %d pinned to block %v expected 100", i, result.BlockNumber)
+		}
+		if got := new(big.Int).SetBytes(result.ReturnedData).Uint64(); got != 0x64 {
+			t.Fatalf("result %d NUMBER: %d expected 100", i, got)
+		}
+	}
+
+	if simulations[0].BlockNumber != nil {
+		t.Fatal("the simulations of the caller were modified")
+	}
+
+	// the bundle is pinned once, all its transactions read the same block
+	if latest != 0x65 {
+		t.Fatalf("eth_blockNumber requests: %d expected 1", latest-0x64)
+	}
+}
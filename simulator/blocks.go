@@ -0,0 +1,188 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+)
+
+// blockInterval is the seconds between the blocks of a sequence without timestamps,
+// the slot time of the beacon chain
+const blockInterval = 12
+
+// ErrInvalidBlocks is returned by SimulateBlocks when the numbers or timestamps of
+// the blocks don't increase.
+var ErrInvalidBlocks = errors.New("invalid block sequence")
+
+// BlockSpec is a block simulated by SimulateBlocks, on top of the previous one of the
+// sequence.
+type BlockSpec struct {
+	// Number of the block, the one following the previous block when nil. The number
+	// of the first block selects the state of the fork the sequence starts from, the
+	// one of its parent, the latest block when nil.
+	Number *big.Int
+	// Timestamp of the block, blockInterval seconds after the previous block when zero
+	Timestamp uint64
+	// BaseFee and Coinbase of the block, the ones of the block of the state when nil
+	BaseFee  *big.Int
+	Coinbase *common.Address
+
+	// Simulations are the transactions of the block, their block number and block
+	// context are replaced by the ones of the block
+	Simulations []Simulation
+}
+
+// BlockResult is the outcome of a block simulated by SimulateBlocks.
+type BlockResult struct {
+	Number    *big.Int
+	Timestamp uint64
+	GasUsed   uint64
+	Results   []*SimulationResult
+}
+
+// SimulateBlocks simulates a sequence of blocks, each with its own number, timestamp
+// and base fee, carrying the state from one to the next as SimulateBundle does for its
+// transactions. All of them read the state of the fork at the parent of the first
+// block, the blocks are simulated on top of it. The sequence fails as a whole, when
+// a transaction does or reverts against the RevertPolicy. A nil stateDB is a new
+// remote state, as with Simulate.
+func (s *Simulator) SimulateBlocks(ctx context.Context, blocks []BlockSpec, stateDB *state.StateDB) ([]*BlockResult, error) {
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+
+	parent, err := s.blocksParent(ctx, blocks[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		simulations []Simulation
+		results     = make([]*BlockResult, len(blocks))
+		previous    = parent
+	)
+	for i, block := range blocks {
+		result, err := nextBlock(i, block, previous)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+		previous = &rpc.BlockHeader{Number: (*hexutil.Big)(result.Number), Timestamp: hexutil.Uint64(result.Timestamp)}
+
+		for _, simulation := range block.Simulations {
+			simulations = append(simulations, blockSimulation(simulation, block, result, parent))
+		}
+	}
+
+	simResults, err := s.SimulateBundle(ctx, simulations, stateDB)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, block := range blocks {
+		results[i].Results = simResults[:len(block.Simulations)]
+		simResults = simResults[len(block.Simulations):]
+
+		for _, result := range results[i].Results {
+			results[i].GasUsed += result.GasUsed
+		}
+	}
+
+	return results, nil
+}
+
+// blocksParent returns the header of the block the sequence starting with first is
+// simulated on, only its number and timestamp when the fork can't serve it. The number
+// is nil when the fork doesn't serve the latest one either.
+func (s *Simulator) blocksParent(ctx context.Context, first BlockSpec) (*rpc.BlockHeader, error) {
+	number := new(big.Int)
+	if first.Number != nil {
+		if first.Number.Sign() <= 0 {
+			return nil, fmt.Errorf("%w: block 0: number %s", ErrInvalidBlocks, first.Number)
+		}
+		number.Sub(first.Number, common.Big1)
+	} else {
+		latest, err := s.latestBlockNumber(ctx)
+		if err != nil || latest == nil {
+			return &rpc.BlockHeader{}, err
+		}
+		number.Set(latest)
+	}
+
+	header, err := s.Cache.BlockHeader(ctx, s.RPCClt, hexutil.EncodeBig(number))
+	if err != nil {
+		var rpcErr *rpc.ErrResponse
+		if errors.As(err, &rpcErr) || errors.Is(err, rpc.ErrBlockNotFound) {
+			return &rpc.BlockHeader{Number: (*hexutil.Big)(number)}, nil
+		}
+
+		return nil, err
+	}
+
+	return &rpc.BlockHeader{Number: (*hexutil.Big)(number), Timestamp: header.Timestamp}, nil
+}
+
+// nextBlock returns the number and timestamp of block, the one at index i of a
+// sequence, following previous
+func nextBlock(i int, block BlockSpec, previous *rpc.BlockHeader) (*BlockResult, error) {
+	result := &BlockResult{Number: block.Number, Timestamp: block.Timestamp}
+
+	if previous.Number != nil {
+		next := new(big.Int).Add(previous.Number.ToInt(), common.Big1)
+		if result.Number == nil {
+			result.Number = next
+		} else if result.Number.Cmp(next) < 0 {
+			return nil, fmt.Errorf("%w: block %d: number %s not after %s", ErrInvalidBlocks, i, result.Number, previous.Number.ToInt())
+		}
+	}
+
+	if previous.Timestamp != 0 {
+		if result.Timestamp == 0 {
+			result.Timestamp = uint64(previous.Timestamp) + blockInterval
+		} else if result.Timestamp <= uint64(previous.Timestamp) {
+			return nil, fmt.Errorf("%w: block %d: timestamp %d not after %d", ErrInvalidBlocks, i, result.Timestamp, previous.Timestamp)
+		}
+	}
+
+	return result, nil
+}
+
+// blockSimulation returns simulation as a transaction of block, reading the state of
+// the fork at parent
+func blockSimulation(simulation Simulation, block BlockSpec, result *BlockResult, parent *rpc.BlockHeader) Simulation {
+	simulation.BlockNumber = nil
+	if parent.Number != nil {
+		simulation.BlockNumber = parent.Number.ToInt()
+	}
+
+	overrides := BlockOverrides{}
+	if simulation.BlockOverrides != nil {
+		overrides = *simulation.BlockOverrides
+	}
+	simulation.BlockOverrides = &overrides
+
+	if result.Number != nil {
+		overrides.Number = result.Number
+	}
+
+	if result.Timestamp != 0 {
+		timestamp := result.Timestamp
+		overrides.Time = &timestamp
+	}
+
+	if block.BaseFee != nil {
+		overrides.BaseFee = block.BaseFee
+	}
+
+	if block.Coinbase != nil {
+		overrides.Coinbase = block.Coinbase
+	}
+
+	return simulation
+}
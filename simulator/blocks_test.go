@@ -0,0 +1,135 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestSimulateBlocks(t *testing.T) {
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	// increments the slot 0 and returns it with NUMBER, TIMESTAMP and BASEFEE
+	code := hexutil.Bytes{
+		byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.PUSH1), 1, byte(vm.ADD),
+		byte(vm.DUP1), byte(vm.PUSH0), byte(vm.SSTORE), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.NUMBER), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.TIMESTAMP), byte(vm.PUSH1), 0x40, byte(vm.MSTORE),
+		byte(vm.BASEFEE), byte(vm.PUSH1), 0x60, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x80, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_blockNumber":
+			return "0x64", nil
+		case "eth_getBlockByNumber":
+			var blk string
+			if err := json.Unmarshal(params[0], &blk); err != nil {
+				return nil, err
+			}
+			if blk != "0x64" {
+				return nil, errors.New("unexpected block " + blk)
+			}
+
+			return map[string]interface{}{"number": blk, "timestamp": "0x3e8", "baseFeePerGas": "0x7"}, nil
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+
+			if addr == contract {
+				return code, nil
+			}
+			return hexutil.Bytes{}, nil
+		case "eth_getStorageAt":
+			return common.Hash{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := Simulation{
+		From:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:       contract,
+		GasLimit: 100000,
+		GasPrice: big.NewInt(0),
+		Value:    big.NewInt(0),
+	}
+
+	ctx := context.Background()
+	results, err := sim.SimulateBlocks(ctx, []BlockSpec{
+		{Simulations: []Simulation{call, call}},
+		{Number: big.NewInt(0x70), BaseFee: big.NewInt(9), Simulations: []Simulation{call}},
+		{Timestamp: 2000, Simulations: []Simulation{call}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		number, timestamp uint64
+		baseFee           uint64
+		counters          []uint64
+	}{
+		{number: 0x65, timestamp: 1012, baseFee: 7, counters: []uint64{1, 2}},
+		{number: 0x70, timestamp: 1024, baseFee: 9, counters: []uint64{3}},
+		{number: 0x71, timestamp: 2000, baseFee: 7, counters: []uint64{4}},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("%d blocks expected %d", len(results), len(expected))
+	}
+
+	for i, block := range results {
+		want := expected[i]
+		if block.Number.Uint64() != want.number || block.Timestamp != want.timestamp {
+			t.Fatalf("block %d: number %d timestamp %d expected %d %d", i, block.Number, block.Timestamp, want.number, want.timestamp)
+		}
+		if len(block.Results) != len(want.counters) {
+			t.Fatalf("block %d: %d results expected %d", i, len(block.Results), len(want.counters))
+		}
+
+		var gasUsed uint64
+		for j, result := range block.Results {
+			gasUsed += result.GasUsed
+
+			ret := result.ReturnedData
+			got := []uint64{
+				new(big.Int).SetBytes(ret[:32]).Uint64(),
+				new(big.Int).SetBytes(ret[32:64]).Uint64(),
+				new(big.Int).SetBytes(ret[64:96]).Uint64(),
+				new(big.Int).SetBytes(ret[96:128]).Uint64(),
+			}
+			if got[0] != want.counters[j] || got[1] != want.number || got[2] != want.timestamp || got[3] != want.baseFee {
+				t.Fatalf("block %d tx %d: counter, number, timestamp, base fee %v expected %d %d %d %d", i, j, got, want.counters[j], want.number, want.timestamp, want.baseFee)
+			}
+		}
+		if block.GasUsed != gasUsed {
+			t.Fatalf("block %d: gas used %d expected %d", i, block.GasUsed, gasUsed)
+		}
+	}
+
+	for _, blocks := range [][]BlockSpec{
+		{{Number: big.NewInt(0x70)}, {Number: big.NewInt(0x6f)}},
+		{{Timestamp: 1500}, {Timestamp: 1500}},
+		{{Timestamp: 900}},
+	} {
+		if _, err := sim.SimulateBlocks(ctx, blocks, nil); !errors.Is(err, ErrInvalidBlocks) {
+			t.Fatalf("%v expected for %+v, got %v", ErrInvalidBlocks, blocks, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ErrBundleReverted is returned when a transaction of a bundle reverts and the
+// RevertPolicy of the simulator doesn't allow it.
+var ErrBundleReverted = errors.New("bundle transaction reverted")
+
+// RevertPolicy decides what SimulateBundle does when a transaction of the bundle reverts.
+type RevertPolicy int
+
+const (
+	// RevertContinue keeps simulating the bundle, the failure of the transaction is
+	// recorded in its result
+	RevertContinue RevertPolicy = iota
+	// RevertAbort discards the whole bundle on the first revert, as an atomic bundle
+	// of a builder
+	RevertAbort
+	// RevertAllowListed only lets the simulations with AllowRevert revert, as the
+	// revertingTxHashes of Flashbots bundles
+	RevertAllowListed
+)
+
+// WithRevertPolicy sets how SimulateBundle handles reverted transactions, by default
+// it continues with RevertContinue.
+func WithRevertPolicy(policy RevertPolicy) func(*Simulator) {
+	return func(s *Simulator) {
+		s.revertPolicy = policy
+	}
+}
+
+// checkRevert returns ErrBundleReverted when the result of the simulation at index i
+// of a bundle reverted and policy doesn't allow it.
+func (policy RevertPolicy) checkRevert(i int, simulation Simulation, result *SimulationResult) error {
+	if result.Status != types.ReceiptStatusFailed {
+		return nil
+	}
+
+	if policy == RevertContinue || policy == RevertAllowListed && simulation.AllowRevert {
+		return nil
+	}
+
+	reason := "execution reverted"
+	if result.Revert != nil {
+		reason = result.Revert.String()
+	}
+
+	return fmt.Errorf("%w: transaction %d: %s", ErrBundleReverted, i, reason)
+}
+
+// BundleCheckpoint is the state of a bundle right after one of its transactions. The
+// bundle can be continued from it with ResumeBundle, with the state fetched from the
+// fork so far, without simulating again the transactions before it.
+type BundleCheckpoint struct {
+	// Index of the last transaction of the bundle applied on the state
+	Index int
+
+	root common.Hash
+	db   state.Database
+}
+
+// State returns a new state at the checkpoint, changes to it don't affect the checkpoint.
+func (c *BundleCheckpoint) State() (*state.StateDB, error) {
+	return state.New(c.root, c.db, nil)
+}
+
+// commitCheckpoint commits stateDB after the transaction at index of a bundle,
+// returning the state to continue the bundle with and the checkpoint of the commit.
+func commitCheckpoint(stateDB *state.StateDB, index int) (*state.StateDB, *BundleCheckpoint, error) {
+	root, err := stateDB.Commit(0, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("commit error: %s", err)
+	}
+
+	checkpoint := &BundleCheckpoint{Index: index, root: root, db: stateDB.Database()}
+	stateDB, err = checkpoint.State()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return stateDB, checkpoint, nil
+}
+
+// ResumeBundle simulates simulations after the transactions of the bundle up to
+// checkpoint, replacing the ones that followed it. The state before the checkpoint
+// isn't fetched again, the simulations run once on it like the transactions of
+// ReplayBlock. The results have their own checkpoints, so a bundle can be rewound
+// to any of its transactions.
+func (s *Simulator) ResumeBundle(ctx context.Context, checkpoint *BundleCheckpoint, simulations []Simulation) ([]*SimulationResult, error) {
+	stateDB, err := checkpoint.State()
+	if err != nil {
+		return nil, err
+	}
+
+	// don't modify the simulations of the caller
+	simulations = bundleTransactions(simulations)
+	err = s.pinLatestBlock(ctx, simulations)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range simulations {
+		simulations[i], err = s.prepareSimulation(ctx, simulations[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	runtime.BindRemoteState(ctx, stateDB, maxRetries(simulations))
+
+	err = s.resolveBundleNonces(ctx, simulations, stateDB)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SimulationResult, len(simulations))
+	for i := range simulations {
+		index := checkpoint.Index + 1 + i
+
+		simResult, err := s.simulate(ctx, simulations[i], stateDB)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.revertPolicy.checkRevert(index, simulations[i], simResult); err != nil {
+			return nil, err
+		}
+
+		stateDB, simResult.Checkpoint, err = commitCheckpoint(stateDB, index)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = simResult
+	}
+
+	return results, nil
+}
@@ -0,0 +1,315 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	ourVm "github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSimulateBundleRevertPolicy(t *testing.T) {
+	var (
+		from     = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		reverter = common.HexToAddress("0x0000000000000000000000000000000000000012")
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			switch addr {
+			case contract:
+				return hexutil.Bytes{byte(vm.STOP)}, nil
+			case reverter:
+				return hexutil.Bytes{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.REVERT)}, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	simulation := func(to common.Address, allowRevert bool) Simulation {
+		return Simulation{
+			From:        from,
+			To:          to,
+			BlockNumber: big.NewInt(1),
+			GasLimit:    100000,
+			GasPrice:    big.NewInt(0),
+			Value:       big.NewInt(0),
+			AllowRevert: allowRevert,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		policy      RevertPolicy
+		allowRevert bool
+		err         error
+	}{
+		{name: "continue", policy: RevertContinue},
+		{name: "abort", policy: RevertAbort, allowRevert: true, err: ErrBundleReverted},
+		{name: "not allowed", policy: RevertAllowListed, err: ErrBundleReverted},
+		{name: "allowed", policy: RevertAllowListed, allowRevert: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, err := NewSimulator(rpc.NewClient(srv.URL), WithRevertPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			simulations := []Simulation{
+				simulation(contract, false),
+				simulation(reverter, tt.allowRevert),
+				simulation(contract, false),
+			}
+
+			results, err := sim.SimulateBundle(context.Background(), simulations, nil)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v got: %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			statuses := []uint64{types.ReceiptStatusSuccessful, types.ReceiptStatusFailed, types.ReceiptStatusSuccessful}
+			for i, result := range results {
+				if result.Status != statuses[i] {
+					t.Fatalf("status of %d: %d expected %d", i, result.Status, statuses[i])
+				}
+			}
+
+			if results[1].Err() == nil {
+				t.Fatal("expected the failure of the reverted transaction")
+			}
+		})
+	}
+}
+
+func TestResumeBundle(t *testing.T) {
+	var (
+		from    = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		counter = common.HexToAddress("0x0000000000000000000000000000000000000011")
+	)
+
+	// increments slot 0 and returns its new value
+	code := hexutil.Bytes{
+		byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD),
+		byte(vm.DUP1), byte(vm.PUSH0), byte(vm.SSTORE),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == counter {
+				return code, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getStorageAt":
+			return common.BigToHash(big.NewInt(10)), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	increment := Simulation{
+		From:        from,
+		To:          counter,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    100000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	results, err := sim.SimulateBundle(context.Background(), []Simulation{increment, increment}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := new(big.Int).SetBytes(results[1].ReturnedData); value.Int64() != 12 {
+		t.Fatalf("counter: %s expected 12", value)
+	}
+
+	calls := srv.Calls("eth_getStorageAt")
+
+	// rewinding twice to the first transaction gives the same state
+	for range 2 {
+		resumed, err := sim.ResumeBundle(context.Background(), results[0].Checkpoint, []Simulation{increment})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if value := new(big.Int).SetBytes(resumed[0].ReturnedData); value.Int64() != 12 {
+			t.Fatalf("counter: %s expected 12", value)
+		}
+
+		if resumed[0].Checkpoint.Index != 1 || resumed[0].Nonce != 2 {
+			t.Fatalf("checkpoint index: %d nonce: %d", resumed[0].Checkpoint.Index, resumed[0].Nonce)
+		}
+	}
+
+	resumed, err := sim.ResumeBundle(context.Background(), results[1].Checkpoint, []Simulation{increment})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := new(big.Int).SetBytes(resumed[0].ReturnedData); value.Int64() != 13 {
+		t.Fatalf("counter: %s expected 13", value)
+	}
+
+	if c := srv.Calls("eth_getStorageAt"); c != calls {
+		t.Fatalf("eth_getStorageAt called %d times after the bundle", c-calls)
+	}
+}
+
+func TestSimulateBundleCheatcodes(t *testing.T) {
+	var (
+		from     = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		pranked  = common.HexToAddress("0x0000000000000000000000000000000000000002")
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+	)
+
+	// returns the timestamp and the caller
+	code := hexutil.Bytes{
+		byte(vm.TIMESTAMP), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.CALLER), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == contract {
+				return code, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cheatcodes := ourVm.NewCheatcodes()
+	simulation := func(to common.Address, input []byte) Simulation {
+		return Simulation{
+			From:        from,
+			To:          to,
+			Input:       input,
+			BlockNumber: big.NewInt(1),
+			GasLimit:    100000,
+			GasPrice:    big.NewInt(0),
+			Value:       big.NewInt(0),
+			Cheatcodes:  cheatcodes,
+		}
+	}
+
+	cheat := func(signature string, arg []byte) Simulation {
+		input := append(crypto.Keccak256([]byte(signature))[:4], common.LeftPadBytes(arg, 32)...)
+		return simulation(ourVm.CheatcodeAddress, input)
+	}
+
+	simulations := []Simulation{
+		cheat("warp(uint256)", big.NewInt(5000).Bytes()),
+		cheat("startPrank(address)", pranked.Bytes()),
+		simulation(contract, nil),
+	}
+
+	results, err := sim.SimulateBundle(context.Background(), simulations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, result := range results {
+		if result.Status != types.ReceiptStatusSuccessful {
+			t.Fatalf("transaction %d failed: %v", i, result.Err())
+		}
+	}
+
+	ret := results[2].ReturnedData
+	if timestamp := new(big.Int).SetBytes(ret[:32]); timestamp.Int64() != 5000 {
+		t.Errorf("timestamp %s expected 5000", timestamp)
+	}
+
+	if caller := common.BytesToAddress(ret[32:]); caller != pranked {
+		t.Errorf("caller %s expected %s", caller, pranked)
+	}
+}
+
+func TestSimulateBundleEmptyAccounts(t *testing.T) {
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			return hexutil.Bytes{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// transfers to an account without code, the accounts are only fetched once
+	transfer := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000022"),
+		BlockNumber: big.NewInt(1),
+		Value:       big.NewInt(0),
+	}
+
+	if _, err := sim.SimulateBundle(context.Background(), []Simulation{transfer, transfer, transfer}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := srv.Calls("eth_getCode"); calls != 2 {
+		t.Fatalf("code fetched %d times", calls)
+	}
+}
@@ -0,0 +1,107 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ErrUnknownHardfork is returned when the Hardfork of a simulation isn't a fork of
+// Ethereum known by the runtime.
+var ErrUnknownHardfork = errors.New("unknown hardfork")
+
+// prepareSimulation detects the chain of the fork, pins simulation to the latest block
+// when it has no block number and fills its block context, moved forward by the
+// AdvanceTime and AdvanceBlocks operations preceding it in a bundle
+func (s *Simulator) prepareSimulation(ctx context.Context, simulation Simulation) (Simulation, error) {
+	if simulation.Hardfork != "" {
+		if _, ok := runtime.ForkChainConfig(simulation.Hardfork); !ok {
+			return simulation, fmt.Errorf("%w: %s", ErrUnknownHardfork, simulation.Hardfork)
+		}
+	}
+
+	err := s.detectChainConfig(ctx)
+	if err != nil {
+		return simulation, err
+	}
+
+	simulations := []Simulation{simulation}
+	err = s.pinLatestBlock(ctx, simulations)
+	if err != nil {
+		return simulation, err
+	}
+
+	simulation, err = s.withBlockContext(ctx, simulations[0])
+	if err != nil {
+		return simulation, err
+	}
+
+	return simulation.travel.apply(simulation), nil
+}
+
+// detectChainConfig selects the chain configuration from the chain id of the fork,
+// only the first time it's called. Nodes not supporting eth_chainId keep the
+// runtime defaults.
+func (s *Simulator) detectChainConfig(ctx context.Context) error {
+	s.chain.mu.Lock()
+	defer s.chain.mu.Unlock()
+
+	if s.chain.detected {
+		return nil
+	}
+
+	chainID, err := s.RPCClt.ChainID(ctx)
+	if err != nil {
+		var rpcErr *rpc.ErrResponse
+		if errors.As(err, &rpcErr) {
+			s.chain.detected = true
+			return nil
+		}
+
+		return err
+	}
+
+	s.chain.config, _ = runtime.ChainConfigByID(chainID)
+	s.chain.detected = true
+
+	return nil
+}
+
+// ChainConfig returns the chain configuration used by the simulations, nil when
+// the runtime defaults are used.
+func (s *Simulator) ChainConfig() *params.ChainConfig {
+	s.chain.mu.Lock()
+	defer s.chain.mu.Unlock()
+
+	return s.chain.config
+}
+
+// simulationChainConfig returns the chain configuration simulation runs with, the one
+// of the simulator unless replaced by its ChainConfig or Hardfork. Nil uses the
+// runtime defaults.
+func (s *Simulator) simulationChainConfig(simulation Simulation) *params.ChainConfig {
+	chainConfig := s.ChainConfig()
+	if simulation.ChainConfig != nil {
+		chainConfig = simulation.ChainConfig
+	}
+
+	if simulation.Hardfork == "" {
+		return chainConfig
+	}
+
+	forkConfig, ok := runtime.ForkChainConfig(simulation.Hardfork)
+	if !ok {
+		return chainConfig
+	}
+
+	if chainConfig != nil && chainConfig.ChainID != nil {
+		forkConfig.ChainID = new(big.Int).Set(chainConfig.ChainID)
+	}
+
+	return forkConfig
+}
@@ -0,0 +1,175 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestDetectChainConfig(t *testing.T) {
+	// CHAINID PUSH0 MSTORE RETURN(0, 32)
+	code := []byte{byte(vm.CHAINID), byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN)}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(120_000_000),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	tests := []struct {
+		name    string
+		chainID string
+		expect  uint64
+	}{
+		{name: "known chain", chainID: "0xa", expect: 10},
+		{name: "unknown chain", chainID: "0x89", expect: 137},
+		{name: "unsupported method", expect: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+				switch method {
+				case "eth_getBalance", "eth_getTransactionCount":
+					return "0x0", nil
+				case "eth_chainId":
+					if tt.chainID == "" {
+						return nil, errors.New("the method eth_chainId does not exist")
+					}
+					return tt.chainID, nil
+				case "eth_getCode":
+					return hexutil.Bytes(code), nil
+				case "eth_getBlockByNumber":
+					return map[string]interface{}{
+						"number":        "0x7270e00",
+						"timestamp":     hexutil.Uint64(1716000000),
+						"baseFeePerGas": "0x1",
+						"difficulty":    "0x0",
+					}, nil
+				}
+
+				return nil, errors.New("unexpected method " + method)
+			})
+
+			sim, err := NewSimulator(rpc.NewClient(srv.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				result, err := sim.Simulate(context.Background(), simulation, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := new(big.Int).SetBytes(result.ReturnedData).Uint64(); got != tt.expect {
+					t.Fatalf("chain id: %d expected: %d", got, tt.expect)
+				}
+			}
+
+			if calls := srv.Calls("eth_chainId"); calls != 1 {
+				t.Fatalf("eth_chainId called %d times expected 1", calls)
+			}
+		})
+	}
+}
+
+func TestSimulationChainConfig(t *testing.T) {
+	var (
+		// CHAINID MSTORE(0) RETURN(0, 32), without PUSH0
+		chainID = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		// RETURN(0, 0) with PUSH0, introduced by Shanghai
+		push0 = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_chainId":
+			return "0xa", nil
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+
+			switch addr {
+			case chainID:
+				return hexutil.Bytes{byte(vm.CHAINID), byte(vm.PUSH1), 0, byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0, byte(vm.RETURN)}, nil
+			case push0:
+				return hexutil.Bytes{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.RETURN)}, nil
+			}
+			return hexutil.Bytes{}, nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{
+				"number":        "0x7270e00",
+				"timestamp":     hexutil.Uint64(1716000000),
+				"baseFeePerGas": "0x1",
+				"difficulty":    "0x0",
+			}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulate := func(to common.Address, chainConfig *params.ChainConfig, hardfork string) (*SimulationResult, error) {
+		return sim.Simulate(context.Background(), Simulation{
+			From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+			To:          to,
+			BlockNumber: big.NewInt(120_000_000),
+			GasLimit:    300000,
+			GasPrice:    big.NewInt(0),
+			Value:       big.NewInt(0),
+			ChainConfig: chainConfig,
+			Hardfork:    hardfork,
+		}, nil)
+	}
+
+	for _, test := range []struct {
+		name        string
+		chainConfig *params.ChainConfig
+		hardfork    string
+		chainID     uint64
+		push0       bool
+	}{
+		{name: "simulator", chainID: 10, push0: true},
+		{name: "hardfork", hardfork: "london", chainID: 10},
+		{name: "chain config", chainConfig: params.MainnetChainConfig, chainID: 1, push0: true},
+		{name: "chain config and hardfork", chainConfig: params.SepoliaChainConfig, hardfork: "berlin", chainID: 11155111},
+	} {
+		result, err := simulate(chainID, test.chainConfig, test.hardfork)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if got := new(big.Int).SetBytes(result.ReturnedData).Uint64(); got != test.chainID {
+			t.Fatalf("%s: chain id %d expected %d", test.name, got, test.chainID)
+		}
+
+		_, err = simulate(push0, test.chainConfig, test.hardfork)
+		var invalid *vm.ErrInvalidOpCode
+		if test.push0 && err != nil || !test.push0 && !errors.As(err, &invalid) {
+			t.Fatalf("%s: PUSH0 available %t, got %v", test.name, test.push0, err)
+		}
+	}
+
+	if _, err := simulate(chainID, nil, "paris"); !errors.Is(err, ErrUnknownHardfork) {
+		t.Fatalf("%v expected, got %v", ErrUnknownHardfork, err)
+	}
+}
@@ -0,0 +1,126 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+func TestSimulateConcurrently(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	slot := common.HexToHash("0x2a")
+	// copies slot 0 to slot 1 and returns it
+	code := []byte{
+		byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.DUP1), byte(vm.PUSH1), 0x01, byte(vm.SSTORE),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return slot, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == contract {
+				return hexutil.Bytes(code), nil
+			}
+			return hexutil.Bytes{}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:             common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:               contract,
+		Code:             code,
+		BlockNumber:      big.NewInt(1),
+		GasPrice:         big.NewInt(0),
+		Value:            big.NewInt(0),
+		CollectCallTrace: true,
+		CollectStateDiff: true,
+	}
+
+	first, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shared by all the simulations, which must only read it
+	record := first.Record
+	before, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			recordInitializer := record
+			if i%2 == 0 {
+				recordInitializer = nil
+			}
+
+			stateDB, err := sim.NewRemoteState(context.Background(), simulation.BlockNumber, recordInitializer)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			result, err := sim.Simulate(context.Background(), simulation, stateDB)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if common.BytesToHash(result.ReturnedData) != slot {
+				t.Errorf("simulation %d returned %x, want %x", i, result.ReturnedData, slot)
+			}
+
+			stateDB, err = sim.NewRemoteState(context.Background(), simulation.BlockNumber, recordInitializer)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			results, err := sim.SimulateBundle(context.Background(), []Simulation{simulation, simulation}, stateDB)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j, result := range results {
+				if common.BytesToHash(result.ReturnedData) != slot {
+					t.Errorf("bundle %d simulation %d returned %x, want %x", i, j, result.ReturnedData, slot)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	after, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Error("shared record written by the simulations")
+	}
+}
@@ -0,0 +1,339 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// knownFunctions and knownEvents are the signatures every Decoder starts with, the
+// ones of the token standards, WETH and Uniswap
+var (
+	knownFunctions = []string{
+		"transfer(address to,uint256 amount)",
+		"transferFrom(address from,address to,uint256 amount)",
+		"approve(address spender,uint256 amount)",
+		"balanceOf(address account)",
+		"allowance(address owner,address spender)",
+		"totalSupply()",
+		"decimals()",
+		"symbol()",
+		"name()",
+		"ownerOf(uint256 tokenId)",
+		"safeTransferFrom(address from,address to,uint256 tokenId)",
+		"safeTransferFrom(address from,address to,uint256 tokenId,bytes data)",
+		"safeTransferFrom(address from,address to,uint256 id,uint256 amount,bytes data)",
+		"setApprovalForAll(address operator,bool approved)",
+		"permit(address owner,address spender,uint256 value,uint256 deadline,uint8 v,bytes32 r,bytes32 s)",
+		"deposit()",
+		"withdraw(uint256 wad)",
+		"multicall(bytes[] data)",
+		"getReserves()",
+		"swap(uint256 amount0Out,uint256 amount1Out,address to,bytes data)",
+		"swapExactTokensForTokens(uint256 amountIn,uint256 amountOutMin,address[] path,address to,uint256 deadline)",
+		"swapTokensForExactTokens(uint256 amountOut,uint256 amountInMax,address[] path,address to,uint256 deadline)",
+		"swapExactETHForTokens(uint256 amountOutMin,address[] path,address to,uint256 deadline)",
+		"swapExactTokensForETH(uint256 amountIn,uint256 amountOutMin,address[] path,address to,uint256 deadline)",
+	}
+
+	knownEvents = []string{
+		"Transfer(address indexed from,address indexed to,uint256 value)",
+		"Transfer(address indexed from,address indexed to,uint256 indexed tokenId)",
+		"Approval(address indexed owner,address indexed spender,uint256 value)",
+		"Approval(address indexed owner,address indexed approved,uint256 indexed tokenId)",
+		"ApprovalForAll(address indexed owner,address indexed operator,bool approved)",
+		"TransferSingle(address indexed operator,address indexed from,address indexed to,uint256 id,uint256 value)",
+		"TransferBatch(address indexed operator,address indexed from,address indexed to,uint256[] ids,uint256[] values)",
+		"Deposit(address indexed dst,uint256 wad)",
+		"Withdrawal(address indexed src,uint256 wad)",
+		"Sync(uint112 reserve0,uint112 reserve1)",
+		"Swap(address indexed sender,uint256 amount0In,uint256 amount1In,uint256 amount0Out,uint256 amount1Out,address indexed to)",
+		"Swap(address indexed sender,address indexed recipient,int256 amount0,int256 amount1,uint160 sqrtPriceX96,uint128 liquidity,int24 tick)",
+	}
+)
+
+// DecodedArg is an argument of a call or an event.
+type DecodedArg struct {
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// DecodedCall is a call decoded with the ABI of the function called.
+type DecodedCall struct {
+	Name      string       `json:"name"`
+	Signature string       `json:"signature"`
+	Inputs    []DecodedArg `json:"inputs"`
+	// Outputs are only decoded for functions of registered ABIs, which declare them
+	Outputs []DecodedArg `json:"outputs,omitempty"`
+}
+
+// DecodedLog is a log decoded with the ABI of its event, indexed arguments of
+// dynamic types hold the hash of their value.
+type DecodedLog struct {
+	Address   common.Address `json:"address"`
+	Name      string         `json:"name"`
+	Signature string         `json:"signature"`
+	Inputs    []DecodedArg   `json:"inputs"`
+}
+
+// DecodedFrame is a frame of the call tree with its call decoded, Call is nil when
+// the function is unknown.
+type DecodedFrame struct {
+	*runtime.CallFrame
+	Call  *DecodedCall    `json:"decoded,omitempty"`
+	Calls []*DecodedFrame `json:"calls,omitempty"`
+}
+
+// Decoder decodes calls and logs with the ABIs registered for their contracts, and
+// by selector with the functions and events of every ABI and signature registered.
+// It starts with the signatures of the token standards, WETH and Uniswap. Decoding is
+// safe from several goroutines as long as nothing is registered meanwhile.
+type Decoder struct {
+	contracts map[common.Address]*abi.ABI
+	functions map[[4]byte]abi.Method
+	// events sharing a topic differ by the arguments indexed
+	events map[common.Hash][]abi.Event
+}
+
+// NewDecoder returns a decoder knowing the embedded signatures.
+func NewDecoder() *Decoder {
+	d := &Decoder{
+		contracts: make(map[common.Address]*abi.ABI),
+		functions: make(map[[4]byte]abi.Method),
+		events:    make(map[common.Hash][]abi.Event),
+	}
+
+	for _, signature := range knownFunctions {
+		if err := d.RegisterFunction(signature); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, signature := range knownEvents {
+		if err := d.RegisterEvent(signature); err != nil {
+			panic(err)
+		}
+	}
+
+	return d
+}
+
+// Register decodes the calls to addr and its logs with contractABI, its functions and
+// events are used for other contracts as well.
+func (d *Decoder) Register(addr common.Address, contractABI abi.ABI) {
+	d.contracts[addr] = &contractABI
+	for _, method := range contractABI.Methods {
+		d.functions[[4]byte(method.ID)] = method
+	}
+
+	for _, event := range contractABI.Events {
+		d.events[event.ID] = append(d.events[event.ID], event)
+	}
+}
+
+// RegisterJSON registers the JSON ABI of the contract at addr.
+func (d *Decoder) RegisterJSON(addr common.Address, data []byte) error {
+	contractABI, err := abi.JSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	d.Register(addr, contractABI)
+
+	return nil
+}
+
+// RegisterFunction registers a function by its signature, as
+// transfer(address to,uint256 amount). The names of the arguments are optional.
+func (d *Decoder) RegisterFunction(signature string) error {
+	name, args, err := parseSignature(signature)
+	if err != nil {
+		return err
+	}
+
+	method := abi.NewMethod(name, name, abi.Function, "", false, false, args, nil)
+	d.functions[[4]byte(method.ID)] = method
+
+	return nil
+}
+
+// RegisterEvent registers an event by its signature, as
+// Transfer(address indexed from,address indexed to,uint256 value).
+func (d *Decoder) RegisterEvent(signature string) error {
+	name, args, err := parseSignature(signature)
+	if err != nil {
+		return err
+	}
+
+	event := abi.NewEvent(name, name, false, args)
+	d.events[event.ID] = append(d.events[event.ID], event)
+
+	return nil
+}
+
+// DecodeCall decodes the call to to with input, and its output when the function
+// declares outputs. It returns nil when the function is unknown or the input
+// doesn't match it.
+func (d *Decoder) DecodeCall(to common.Address, input, output []byte) *DecodedCall {
+	if len(input) < 4 {
+		return nil
+	}
+
+	method, ok := d.function(to, [4]byte(input[:4]))
+	if !ok {
+		return nil
+	}
+
+	values, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return nil
+	}
+
+	call := &DecodedCall{Name: method.Name, Signature: method.Sig, Inputs: decodedArgs(method.Inputs, values)}
+	if len(method.Outputs) > 0 && len(output) > 0 {
+		if values, err := method.Outputs.Unpack(output); err == nil {
+			call.Outputs = decodedArgs(method.Outputs, values)
+		}
+	}
+
+	return call
+}
+
+// DecodeLog decodes log, it returns nil when its event is unknown.
+func (d *Decoder) DecodeLog(log *types.Log) *DecodedLog {
+	if len(log.Topics) == 0 {
+		return nil
+	}
+
+	for _, event := range d.eventCandidates(log.Address, log.Topics[0]) {
+		indexed := make(abi.Arguments, 0, len(event.Inputs))
+		for _, arg := range event.Inputs {
+			if arg.Indexed {
+				indexed = append(indexed, arg)
+			}
+		}
+		if len(indexed) != len(log.Topics)-1 {
+			continue
+		}
+
+		values := make(map[string]interface{})
+		if err := event.Inputs.UnpackIntoMap(values, log.Data); err != nil {
+			continue
+		}
+		if err := abi.ParseTopicsIntoMap(values, indexed, log.Topics[1:]); err != nil {
+			continue
+		}
+
+		decoded := &DecodedLog{Address: log.Address, Name: event.Name, Signature: event.Sig}
+		for _, arg := range event.Inputs {
+			decoded.Inputs = append(decoded.Inputs, DecodedArg{Name: arg.Name, Type: arg.Type.String(), Value: values[arg.Name]})
+		}
+
+		return decoded
+	}
+
+	return nil
+}
+
+// DecodeTrace decodes the calls of the call tree of root.
+func (d *Decoder) DecodeTrace(root *runtime.CallFrame) *DecodedFrame {
+	if root == nil {
+		return nil
+	}
+
+	frame := &DecodedFrame{CallFrame: root}
+	if root.To != nil && root.Type != "CREATE" && root.Type != "CREATE2" {
+		frame.Call = d.DecodeCall(*root.To, root.Input, root.Output)
+	}
+
+	for _, call := range root.Calls {
+		frame.Calls = append(frame.Calls, d.DecodeTrace(call))
+	}
+
+	return frame
+}
+
+// function returns the function of the ABI registered for addr with selector, or
+// the one registered by selector
+func (d *Decoder) function(addr common.Address, selector [4]byte) (abi.Method, bool) {
+	if contractABI, ok := d.contracts[addr]; ok {
+		if method, err := contractABI.MethodById(selector[:]); err == nil {
+			return *method, true
+		}
+	}
+
+	method, ok := d.functions[selector]
+	return method, ok
+}
+
+// eventCandidates returns the events with topic, the ones of the ABI registered for
+// addr first
+func (d *Decoder) eventCandidates(addr common.Address, topic common.Hash) []abi.Event {
+	var events []abi.Event
+	if contractABI, ok := d.contracts[addr]; ok {
+		if event, err := contractABI.EventByID(topic); err == nil {
+			events = append(events, *event)
+		}
+	}
+
+	return append(events, d.events[topic]...)
+}
+
+// decodedArgs pairs the arguments of a function with their values
+func decodedArgs(args abi.Arguments, values []interface{}) []DecodedArg {
+	decoded := make([]DecodedArg, len(args))
+	for i, arg := range args {
+		decoded[i] = DecodedArg{Name: arg.Name, Type: arg.Type.String(), Value: values[i]}
+	}
+
+	return decoded
+}
+
+// parseSignature parses a signature as name(type [indexed] [name],...), tuples
+// aren't supported
+func parseSignature(signature string) (string, abi.Arguments, error) {
+	open := strings.IndexByte(signature, '(')
+	if open <= 0 || !strings.HasSuffix(signature, ")") {
+		return "", nil, fmt.Errorf("invalid signature %q", signature)
+	}
+
+	var (
+		name   = signature[:open]
+		params = strings.TrimSpace(signature[open+1 : len(signature)-1])
+		args   abi.Arguments
+	)
+	if params == "" {
+		return name, args, nil
+	}
+
+	for i, param := range strings.Split(params, ",") {
+		fields := strings.Fields(param)
+		if len(fields) == 0 {
+			return "", nil, fmt.Errorf("invalid signature %q", signature)
+		}
+
+		typ, err := abi.NewType(fields[0], "", nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid signature %q: %w", signature, err)
+		}
+
+		arg := abi.Argument{Name: fmt.Sprintf("arg%d", i), Type: typ}
+		for _, field := range fields[1:] {
+			if field == "indexed" {
+				arg.Indexed = true
+			} else {
+				arg.Name = field
+			}
+		}
+		args = append(args, arg)
+	}
+
+	return name, args, nil
+}
@@ -0,0 +1,128 @@
+package simulator
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+func TestDecoderDecodeCall(t *testing.T) {
+	var (
+		d     = NewDecoder()
+		token = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		to    = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	)
+
+	input := append(crypto.Keccak256([]byte("transfer(address,uint256)"))[:4], common.LeftPadBytes(to.Bytes(), 32)...)
+	input = append(input, common.BigToHash(big.NewInt(42)).Bytes()...)
+
+	call := d.DecodeCall(token, input, nil)
+	if call == nil || call.Name != "transfer" || call.Signature != "transfer(address,uint256)" || len(call.Inputs) != 2 {
+		t.Fatalf("unexpected call: %+v", call)
+	}
+
+	if call.Inputs[0].Name != "to" || call.Inputs[0].Value != to {
+		t.Errorf("unexpected first input: %+v", call.Inputs[0])
+	}
+
+	if amount, ok := call.Inputs[1].Value.(*big.Int); !ok || amount.Int64() != 42 {
+		t.Errorf("unexpected second input: %+v", call.Inputs[1])
+	}
+
+	if d.DecodeCall(token, []byte{1, 2, 3, 4}, nil) != nil {
+		t.Error("unknown selector decoded")
+	}
+
+	// the ABI registered declares the output
+	err := d.RegisterJSON(token, []byte(`[{"type":"function","name":"transfer","inputs":[{"name":"recipient","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call = d.DecodeCall(token, input, common.LeftPadBytes([]byte{1}, 32))
+	if call == nil || call.Inputs[0].Name != "recipient" || len(call.Outputs) != 1 || call.Outputs[0].Value != true {
+		t.Fatalf("unexpected call with ABI: %+v", call)
+	}
+}
+
+func TestDecoderDecodeLog(t *testing.T) {
+	var (
+		d        = NewDecoder()
+		token    = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		from     = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+		to       = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+		transfer = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	)
+
+	// ERC-20 and ERC-721 transfers share the topic, the indexed arguments differ
+	erc20 := &types.Log{
+		Address: token,
+		Topics:  []common.Hash{transfer, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:    common.BigToHash(big.NewInt(7)).Bytes(),
+	}
+	erc721 := &types.Log{
+		Address: token,
+		Topics:  []common.Hash{transfer, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes()), common.BigToHash(big.NewInt(9))},
+	}
+
+	tests := []struct {
+		log   *types.Log
+		last  string
+		value int64
+	}{
+		{log: erc20, last: "value", value: 7},
+		{log: erc721, last: "tokenId", value: 9},
+	}
+
+	for _, tt := range tests {
+		decoded := d.DecodeLog(tt.log)
+		if decoded == nil || decoded.Name != "Transfer" || len(decoded.Inputs) != 3 {
+			t.Fatalf("unexpected log: %+v", decoded)
+		}
+
+		if decoded.Inputs[0].Value != from || decoded.Inputs[1].Value != to {
+			t.Errorf("unexpected addresses: %+v", decoded.Inputs)
+		}
+
+		last := decoded.Inputs[2]
+		if value, ok := last.Value.(*big.Int); last.Name != tt.last || !ok || value.Int64() != tt.value {
+			t.Errorf("unexpected last input: %+v", last)
+		}
+	}
+
+	if d.DecodeLog(&types.Log{Topics: []common.Hash{{1}}}) != nil {
+		t.Error("unknown event decoded")
+	}
+}
+
+func TestDecoderDecodeTrace(t *testing.T) {
+	var (
+		d     = NewDecoder()
+		token = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	)
+
+	root := &runtime.CallFrame{
+		Type:  "CALL",
+		To:    &token,
+		Input: []byte{1, 2, 3, 4},
+		Calls: []*runtime.CallFrame{{
+			Type:  "STATICCALL",
+			To:    &token,
+			Input: crypto.Keccak256([]byte("totalSupply()"))[:4],
+		}},
+	}
+
+	frame := d.DecodeTrace(root)
+	if frame.Call != nil || len(frame.Calls) != 1 {
+		t.Fatalf("unexpected root: %+v", frame)
+	}
+
+	if call := frame.Calls[0].Call; call == nil || call.Name != "totalSupply" {
+		t.Fatalf("unexpected subcall: %+v", call)
+	}
+}
@@ -0,0 +1,125 @@
+package simulator
+
+import (
+	"context"
+	"slices"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// estimateGasCap is the highest gas limit EstimateGas tries for simulations without one
+const estimateGasCap = 30_000_000
+
+// EstimateGas returns the lowest gas limit the simulation succeeds with, found with a
+// binary search between zero and Simulation.GasLimit, or 30M when it's not set. As
+// Simulation.GasLimit, it's the gas available to the execution, the intrinsic gas is
+// charged on top of it, so transfers to accounts without code need none. Every
+// attempt is simulated on a copy of stateDB, a new remote state when nil. It fails with the revert or the error of
+// the simulation when it doesn't succeed with the highest gas limit.
+func (s *Simulator) EstimateGas(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (uint64, error) {
+	stateDB, err := s.stateOrRemote(ctx, &simulation, stateDB)
+	if err != nil {
+		return 0, err
+	}
+	runtime.BindRemoteState(ctx, stateDB, simulation.MaxRetries)
+
+	hi := simulation.GasLimit
+	if hi == 0 {
+		hi = estimateGasCap
+	}
+
+	succeeds := func(gasLimit uint64) (bool, error) {
+		simulation.GasLimit = gasLimit
+		result, err := s.Simulate(ctx, simulation, stateDB.Copy())
+		switch {
+		case err != nil && isExecutionError(err):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+
+		return result.Status == types.ReceiptStatusSuccessful, nil
+	}
+
+	// a gas limit of zero is unbounded, executions using no gas can't be told apart
+	// by the search so transfers to accounts without code are answered up front
+	if len(simulation.Input) == 0 {
+		hasCode, err := s.hasCode(ctx, simulation, stateDB)
+		if err != nil {
+			return 0, err
+		}
+		if !hasCode {
+			return 0, nil
+		}
+	}
+
+	simulation.GasLimit = hi
+	result, err := s.Simulate(ctx, simulation, stateDB.Copy())
+	if err != nil {
+		return 0, err
+	}
+	if err := result.Err(); err != nil {
+		return 0, err
+	}
+
+	lo := uint64(0)
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		ok, err := succeeds(mid)
+		if err != nil {
+			return 0, err
+		}
+
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	return hi, nil
+}
+
+// hasCode reports whether the account called by simulation has code or is a precompile
+func (s *Simulator) hasCode(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (bool, error) {
+	if len(simulation.Code) > 0 || stateDB.GetCodeSize(simulation.To) > 0 {
+		return true, nil
+	}
+
+	if override, ok := simulation.StateOverrides[simulation.To]; ok && override.Code != nil {
+		return len(override.Code) > 0, nil
+	}
+
+	if _, ok := simulation.Precompiles[simulation.To]; ok || slices.Contains(vm.PrecompiledAddressesCancun, simulation.To) {
+		return true, nil
+	}
+
+	if runtime.IsRemoteState(stateDB) {
+		// the code of the fork was already read from the remote state
+		return false, stateDB.Error()
+	}
+
+	blk := "latest"
+	if simulation.BlockNumber != nil && simulation.BlockNumber.Sign() > 0 {
+		blk = "0x" + simulation.BlockNumber.Text(16)
+	}
+
+	code, err := s.stateProvider().GetCode(ctx, simulation.To.Hex(), blk)
+	if err != nil {
+		return false, err
+	}
+
+	return len(code) > 0, nil
+}
+
+// isExecutionError reports whether err is an error of the EVM aborting the execution,
+// as running out of gas, rather than a failure to simulate
+func isExecutionError(err error) bool {
+	return vm.VMErrorFromErr(err).(*vm.VMError).ErrorCode() != vm.VMErrorCodeUnknown ||
+		ourVm.VMErrorFromErr(err).(*ourVm.VMError).ErrorCode() != ourVm.VMErrorCodeUnknown
+}
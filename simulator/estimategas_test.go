@@ -0,0 +1,106 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEstimateGas(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		gasLimit uint64
+		expected uint64
+		reverts  bool
+	}{
+		{
+			name: "stores a slot",
+			code: []byte{
+				byte(vm.PUSH1), 0x01, byte(vm.PUSH0), byte(vm.SSTORE),
+				byte(vm.STOP),
+			},
+			// PUSH1, PUSH0 and a cold SSTORE of a zero slot
+			expected: 3 + 2 + 22100,
+		},
+		{
+			name: "stores a slot with a gas limit",
+			code: []byte{
+				byte(vm.PUSH1), 0x01, byte(vm.PUSH0), byte(vm.SSTORE),
+				byte(vm.STOP),
+			},
+			gasLimit: 100000,
+			expected: 3 + 2 + 22100,
+		},
+		{
+			name: "needs more than the gas limit",
+			code: []byte{
+				byte(vm.PUSH1), 0x01, byte(vm.PUSH0), byte(vm.SSTORE),
+				byte(vm.STOP),
+			},
+			gasLimit: 20000,
+			reverts:  true,
+		},
+		{
+			name:     "transfers to an account without code",
+			expected: 0,
+		},
+		{
+			name:    "reverts",
+			code:    []byte{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.REVERT)},
+			reverts: true,
+		},
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return common.Hash{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			simulation := Simulation{
+				From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+				To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+				Code:        tt.code,
+				BlockNumber: big.NewInt(1),
+				GasLimit:    tt.gasLimit,
+				Value:       big.NewInt(0),
+			}
+
+			gas, err := sim.EstimateGas(context.Background(), simulation, nil)
+			if tt.reverts {
+				if err == nil {
+					t.Fatalf("expected error, estimated %d", gas)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if gas != tt.expected {
+				t.Fatalf("gas: %d expected: %d", gas, tt.expected)
+			}
+		})
+	}
+}
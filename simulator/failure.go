@@ -0,0 +1,142 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+
+	corevm "github.com/ethereum/go-ethereum/core/vm"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// FailureKind is the category of the failure of a simulation.
+type FailureKind int
+
+const (
+	// FailureNone is a simulation that succeeded
+	FailureNone FailureKind = iota
+	// FailureRevert is a transaction that reverted, see SimulationResult.Revert
+	FailureRevert
+	// FailureOutOfGas is an execution that ran out of gas
+	FailureOutOfGas
+	// FailureInvalidOpcode is an execution that ran an undefined opcode
+	FailureInvalidOpcode
+	// FailureStackUnderflow is an opcode run with fewer items on the stack than it needs
+	FailureStackUnderflow
+	// FailureStackOverflow is an opcode pushing over the stack limit
+	FailureStackOverflow
+	// FailureExecution is an execution aborted by the EVM for any other reason, like
+	// an invalid jump or a write in a static call
+	FailureExecution
+	// FailureStateFetch is a failure fetching the state of the fork, or verifying it
+	FailureStateFetch
+	// FailureFetchBudget is a simulation sending more requests to the fork than
+	// allowed, see WithFetchBudget
+	FailureFetchBudget
+	// FailureStepLimit is an execution running more opcodes, or for longer, than
+	// allowed, see WithStepLimit
+	FailureStepLimit
+	// FailureDeadline is a simulation whose context or timeout expired
+	FailureDeadline
+	// FailureOther is any other failure, like a simulation rejected by the chain rules
+	FailureOther
+)
+
+func (k FailureKind) String() string {
+	switch k {
+	case FailureNone:
+		return "none"
+	case FailureRevert:
+		return "revert"
+	case FailureOutOfGas:
+		return "out of gas"
+	case FailureInvalidOpcode:
+		return "invalid opcode"
+	case FailureStackUnderflow:
+		return "stack underflow"
+	case FailureStackOverflow:
+		return "stack overflow"
+	case FailureExecution:
+		return "execution"
+	case FailureStateFetch:
+		return "state fetch"
+	case FailureFetchBudget:
+		return "fetch budget"
+	case FailureStepLimit:
+		return "step limit"
+	case FailureDeadline:
+		return "deadline"
+	default:
+		return "other"
+	}
+}
+
+// SimulationError is returned by Simulate and SimulateBundle when they fail, with the
+// category of the failure. It unwraps to the error it categorizes.
+type SimulationError struct {
+	Kind FailureKind
+	Err  error
+}
+
+func (e *SimulationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SimulationError) Unwrap() error {
+	return e.Err
+}
+
+// FailureOf returns the category of err, FailureNone when nil.
+func FailureOf(err error) FailureKind {
+	var simErr *SimulationError
+	switch {
+	case err == nil:
+		return FailureNone
+	case errors.As(err, &simErr):
+		return simErr.Kind
+	case errors.Is(err, corevm.ErrExecutionReverted):
+		return FailureRevert
+	case errors.Is(err, rpc.ErrFetchBudgetExceeded):
+		return FailureFetchBudget
+	case errors.Is(err, rpc.ErrRPCFetch), errors.Is(err, ourVm.ErrInvalidProof):
+		return FailureStateFetch
+	case errors.Is(err, ourVm.ErrStepLimitExceeded):
+		return FailureStepLimit
+	case errors.Is(err, context.DeadlineExceeded):
+		return FailureDeadline
+	}
+
+	// the interpreter aborts with the errors of both packages
+	code := corevm.VMErrorFromErr(err).(*corevm.VMError).ErrorCode()
+	if code == corevm.VMErrorCodeUnknown {
+		code = ourVm.VMErrorFromErr(err).(*ourVm.VMError).ErrorCode()
+	}
+
+	switch code {
+	case corevm.VMErrorCodeUnknown:
+		return FailureOther
+	case corevm.VMErrorCodeOutOfGas, corevm.VMErrorCodeCodeStoreOutOfGas, corevm.VMErrorCodeGasUintOverflow:
+		return FailureOutOfGas
+	case corevm.VMErrorCodeInvalidOpCode:
+		return FailureInvalidOpcode
+	case corevm.VMErrorCodeStackUnderflow:
+		return FailureStackUnderflow
+	case corevm.VMErrorCodeStackOverflow:
+		return FailureStackOverflow
+	case corevm.VMErrorCodeExecutionReverted:
+		return FailureRevert
+	default:
+		return FailureExecution
+	}
+}
+
+// simulationError returns err categorized as a *SimulationError, nil when nil
+func simulationError(err error) error {
+	var simErr *SimulationError
+	if err == nil || errors.As(err, &simErr) {
+		return err
+	}
+
+	return &SimulationError{Kind: FailureOf(err), Err: err}
+}
@@ -0,0 +1,101 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestFailureOf(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		kind FailureKind
+	}{
+		{nil, FailureNone},
+		{fmt.Errorf("%w: %w", ErrBundleReverted, &RevertError{Info: DecodeRevert(nil)}), FailureRevert},
+		{fmt.Errorf("%w: not found", rpc.ErrRPCFetch), FailureStateFetch},
+		{rpc.ErrFetchBudgetExceeded, FailureFetchBudget},
+		{ourVm.ErrStepLimitExceeded, FailureStepLimit},
+		{ErrSimulationTimeout, FailureDeadline},
+		{vm.ErrOutOfGas, FailureOutOfGas},
+		{vm.ErrInvalidJump, FailureExecution},
+		{ErrInsufficientBalance, FailureOther},
+		{&SimulationError{Kind: FailureOutOfGas, Err: errors.New("out of gas")}, FailureOutOfGas},
+	} {
+		if kind := FailureOf(test.err); kind != test.kind {
+			t.Errorf("%v: failure %s, want %s", test.err, kind, test.kind)
+		}
+	}
+}
+
+func TestSimulateFailures(t *testing.T) {
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return common.Hash{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes{}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		code []byte
+		kind FailureKind
+	}{
+		{"success", []byte{byte(vm.STOP)}, FailureNone},
+		{"revert", []byte{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.REVERT)}, FailureRevert},
+		{"out of gas", []byte{byte(vm.JUMPDEST), byte(vm.PUSH0), byte(vm.JUMP)}, FailureOutOfGas},
+		{"invalid opcode", []byte{byte(vm.INVALID)}, FailureInvalidOpcode},
+		{"stack underflow", []byte{byte(vm.ADD)}, FailureStackUnderflow},
+		// the account is fetched from the fork before the opcode runs
+		{"stack underflow fetching an account", []byte{byte(vm.BALANCE)}, FailureStackUnderflow},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			simulation := Simulation{
+				From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+				To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+				Code:        test.code,
+				BlockNumber: big.NewInt(1),
+				GasLimit:    100000,
+				GasPrice:    big.NewInt(0),
+				Value:       big.NewInt(0),
+			}
+
+			result, err := sim.Simulate(context.Background(), simulation, nil)
+			if err != nil {
+				var simErr *SimulationError
+				if !errors.As(err, &simErr) {
+					t.Fatalf("simulation failed with %T, want a *SimulationError", err)
+				}
+				if simErr.Kind != test.kind {
+					t.Fatalf("simulation failed with %s: %v, want %s", simErr.Kind, err, test.kind)
+				}
+				return
+			}
+
+			if result.Failure != test.kind {
+				t.Fatalf("simulation result failure %s, want %s", result.Failure, test.kind)
+			}
+		})
+	}
+}
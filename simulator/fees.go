@@ -0,0 +1,123 @@
+package simulator
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// feeHistoryBlocks is the number of blocks the priority fee is estimated from
+const feeHistoryBlocks = 10
+
+// NextBlockFees are the fees expected to be paid by a transaction included in the
+// block following Block.
+type NextBlockFees struct {
+	Block *big.Int
+	// BaseFee is the base fee of the next block, predicted by the node from Block
+	BaseFee *big.Int
+	// PriorityFee is the median, over the last blocks, of the priority fee paid at the
+	// percentile of their gas given to WithNextBlockFees
+	PriorityFee *big.Int
+	// GasPrice is the price suggested by the node for legacy transactions
+	GasPrice *big.Int
+}
+
+// WithNextBlockFees fills the fees of the simulations without block number, which are
+// simulated on the latest block to be included in the next one: BaseFee is set to
+// the base fee predicted for the next block, and the fees not provided are set as a
+// wallet would. Dynamic fee simulations, of type 2 or above or with one of their fee
+// fields set, pay the priority fee paid at percentile of the gas of the last blocks,
+// with a fee cap of twice the base fee plus it. The other ones pay the gas price
+// suggested by the node. The sender must afford the fees, see NextBlockFees.
+func WithNextBlockFees(percentile float64) func(*Simulator) {
+	return func(s *Simulator) {
+		s.nextBlockFees = true
+		s.feePercentile = percentile
+	}
+}
+
+// NextBlockFees returns the fees of the block following block, from its fee history
+// and the gas price suggested by the node. They're fetched once for every block.
+func (s *Simulator) NextBlockFees(ctx context.Context, block *big.Int) (*NextBlockFees, error) {
+	blk := "0x" + block.Text(16)
+	if fees, ok := s.Cache.nextBlockFees(blk); ok {
+		return fees, nil
+	}
+
+	history, err := s.RPCClt.FeeHistory(ctx, feeHistoryBlocks, blk, []float64{s.feePercentile})
+	if err != nil {
+		return nil, err
+	}
+
+	gasPrice, err := s.RPCClt.GasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fees := &NextBlockFees{
+		Block:       new(big.Int).Set(block),
+		BaseFee:     new(big.Int),
+		PriorityFee: medianReward(history.Reward, history.GasUsedRatio),
+		GasPrice:    gasPrice,
+	}
+	if n := len(history.BaseFee); n > 0 && history.BaseFee[n-1] != nil {
+		fees.BaseFee = history.BaseFee[n-1].ToInt()
+	}
+
+	s.Cache.addNextBlockFees(blk, fees)
+
+	return fees, nil
+}
+
+// medianReward returns the median of the rewards at the first percentile of the
+// blocks, empty blocks pay no priority fee and are skipped
+func medianReward(rewards [][]*hexutil.Big, gasUsedRatio []float64) *big.Int {
+	var fees []*big.Int
+	for i, reward := range rewards {
+		if len(reward) == 0 || reward[0] == nil || (i < len(gasUsedRatio) && gasUsedRatio[i] == 0) {
+			continue
+		}
+		fees = append(fees, reward[0].ToInt())
+	}
+
+	if len(fees) == 0 {
+		return new(big.Int)
+	}
+
+	sort.Slice(fees, func(i, j int) bool {
+		return fees[i].Cmp(fees[j]) < 0
+	})
+
+	return new(big.Int).Set(fees[len(fees)/2])
+}
+
+// applyNextBlockFees sets the base fee and the fees of simulation still unset from fees
+func applyNextBlockFees(simulation Simulation, fees *NextBlockFees) Simulation {
+	if simulation.BaseFee == nil {
+		simulation.BaseFee = new(big.Int).Set(fees.BaseFee)
+	}
+
+	dynamic := simulation.TxType >= types.DynamicFeeTxType ||
+		simulation.MaxFeePerGas != nil || simulation.MaxPriorityFeePerGas != nil
+	if !dynamic {
+		if simulation.GasPrice == nil {
+			simulation.GasPrice = new(big.Int).Set(fees.GasPrice)
+		}
+
+		return simulation
+	}
+
+	if simulation.MaxPriorityFeePerGas == nil {
+		simulation.MaxPriorityFeePerGas = new(big.Int).Set(fees.PriorityFee)
+	}
+
+	if simulation.MaxFeePerGas == nil {
+		feeCap := new(big.Int).Lsh(simulation.BaseFee, 1)
+		simulation.MaxFeePerGas = feeCap.Add(feeCap, simulation.MaxPriorityFeePerGas)
+	}
+
+	return simulation
+}
@@ -0,0 +1,118 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestSimulateNextBlockFees(t *testing.T) {
+	// returns BASEFEE and GASPRICE
+	code := []byte{
+		byte(vm.BASEFEE), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.GASPRICE), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		gwei        = big.NewInt(params.GWei)
+		nextBaseFee = new(big.Int).Mul(big.NewInt(2), gwei)
+		gasPrice    = new(big.Int).Mul(big.NewInt(5), gwei)
+		rewards     [][]*hexutil.Big
+	)
+	// the empty block is skipped, the median of the others is 2 gwei
+	for _, reward := range []int64{1, 3, 0, 2} {
+		rewards = append(rewards, []*hexutil.Big{(*hexutil.Big)(new(big.Int).Mul(big.NewInt(reward), gwei))})
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getBalance":
+			return (*hexutil.Big)(new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)), nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{
+				"number":        "0x10",
+				"baseFeePerGas": (*hexutil.Big)(gwei),
+			}, nil
+		case "eth_feeHistory":
+			var newest string
+			if err := json.Unmarshal(params[1], &newest); err != nil || newest != "0x10" {
+				return nil, errors.New("fee history of the pinned block expected")
+			}
+
+			return map[string]interface{}{
+				"oldestBlock":   "0xd",
+				"baseFeePerGas": []*hexutil.Big{(*hexutil.Big)(gwei), (*hexutil.Big)(gwei), (*hexutil.Big)(gwei), (*hexutil.Big)(gwei), (*hexutil.Big)(nextBaseFee)},
+				"gasUsedRatio":  []float64{0.5, 0.7, 0, 0.4},
+				"reward":        rewards,
+			}, nil
+		case "eth_gasPrice":
+			return (*hexutil.Big)(gasPrice), nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithNextBlockFees(50))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		txType   uint8
+		gasPrice *big.Int
+	}{
+		{txType: types.LegacyTxType, gasPrice: gasPrice},
+		// the next base fee plus the median priority fee
+		{txType: types.DynamicFeeTxType, gasPrice: new(big.Int).Mul(big.NewInt(4), gwei)},
+	} {
+		simulation := Simulation{
+			From:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+			To:       common.HexToAddress("0x0000000000000000000000000000000000000011"),
+			GasLimit: 100000,
+			TxType:   test.txType,
+			Value:    big.NewInt(0),
+		}
+
+		result, err := sim.Simulate(context.Background(), simulation, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ret := result.ReturnedData
+		if got := new(big.Int).SetBytes(ret[:32]); got.Cmp(nextBaseFee) != 0 {
+			t.Fatalf("tx type %d base fee: %s expected: %s", test.txType, got, nextBaseFee)
+		}
+		if got := new(big.Int).SetBytes(ret[32:64]); got.Cmp(test.gasPrice) != 0 {
+			t.Fatalf("tx type %d gas price: %s expected: %s", test.txType, got, test.gasPrice)
+		}
+	}
+
+	// the fees of the block are fetched once
+	var histories int
+	for _, method := range srv.calls {
+		if method == "eth_feeHistory" {
+			histories++
+		}
+	}
+	if histories != 1 {
+		t.Fatalf("eth_feeHistory requests: %d expected 1", histories)
+	}
+
+	if _, err := NewSimulator(rpc.NewClient(srv.URL), WithNextBlockFees(101)); err == nil {
+		t.Fatal("percentile out of range expected to fail")
+	}
+}
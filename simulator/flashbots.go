@@ -0,0 +1,205 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ErrInvalidBundle is returned for bundles that can't be included in any block.
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// FlashbotsBundle is a bundle in the format of the eth_sendBundle request of Flashbots.
+type FlashbotsBundle struct {
+	// Txs are the signed transactions, in their binary encoding
+	Txs []hexutil.Bytes `json:"txs"`
+	// BlockNumber is the block the bundle targets, it's simulated on top of the
+	// state of the previous one
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	// MinTimestamp is used as the timestamp of the block when set
+	MinTimestamp uint64 `json:"minTimestamp,omitempty"`
+	MaxTimestamp uint64 `json:"maxTimestamp,omitempty"`
+	// RevertingTxHashes are the transactions allowed to revert
+	RevertingTxHashes []common.Hash `json:"revertingTxHashes,omitempty"`
+}
+
+// CallBundleResult is the outcome of a transaction of a bundle, as in the response
+// of eth_callBundle. Amounts of wei are decimal strings.
+type CallBundleResult struct {
+	CoinbaseDiff      string          `json:"coinbaseDiff"`
+	EthSentToCoinbase string          `json:"ethSentToCoinbase"`
+	FromAddress       common.Address  `json:"fromAddress"`
+	GasFees           string          `json:"gasFees"`
+	GasPrice          string          `json:"gasPrice"`
+	GasUsed           uint64          `json:"gasUsed"`
+	ToAddress         *common.Address `json:"toAddress"`
+	TxHash            common.Hash     `json:"txHash"`
+	Value             hexutil.Bytes   `json:"value,omitempty"`
+	Error             string          `json:"error,omitempty"`
+	Revert            string          `json:"revert,omitempty"`
+}
+
+// CallBundleResponse is the result of simulating a bundle with the shape of the
+// response of eth_callBundle.
+type CallBundleResponse struct {
+	BundleGasPrice    string             `json:"bundleGasPrice"`
+	BundleHash        common.Hash        `json:"bundleHash"`
+	CoinbaseDiff      string             `json:"coinbaseDiff"`
+	EthSentToCoinbase string             `json:"ethSentToCoinbase"`
+	GasFees           string             `json:"gasFees"`
+	Results           []CallBundleResult `json:"results"`
+	StateBlockNumber  uint64             `json:"stateBlockNumber"`
+	TotalGasUsed      uint64             `json:"totalGasUsed"`
+}
+
+// SimulateFlashbotsBundle decodes the transactions of bundle and simulates them in order
+// as SimulateBundle does. A transaction reverting without being in RevertingTxHashes
+// fails the bundle with ErrBundleReverted, as the relay would reject it.
+func (s *Simulator) SimulateFlashbotsBundle(ctx context.Context, bundle FlashbotsBundle) (*CallBundleResponse, error) {
+	if len(bundle.Txs) == 0 || bundle.BlockNumber == 0 {
+		return nil, fmt.Errorf("%w: no transactions or block number", ErrInvalidBundle)
+	}
+
+	if bundle.MaxTimestamp != 0 && bundle.MinTimestamp > bundle.MaxTimestamp {
+		return nil, fmt.Errorf("%w: min timestamp %d after max timestamp %d", ErrInvalidBundle, bundle.MinTimestamp, bundle.MaxTimestamp)
+	}
+
+	allowedReverts := make(map[common.Hash]struct{}, len(bundle.RevertingTxHashes))
+	for _, hash := range bundle.RevertingTxHashes {
+		allowedReverts[hash] = struct{}{}
+	}
+
+	txs := make([]*types.Transaction, len(bundle.Txs))
+	simulations := make([]Simulation, len(bundle.Txs))
+	for i, raw := range bundle.Txs {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(raw); err != nil {
+			return nil, fmt.Errorf("%w: tx %d: %w", ErrInvalidBundle, i, err)
+		}
+
+		simulation, err := simulationFromSignedTx(tx, bundle)
+		if err != nil {
+			return nil, err
+		}
+		_, simulation.AllowRevert = allowedReverts[tx.Hash()]
+
+		txs[i], simulations[i] = tx, simulation
+	}
+
+	results, err := s.simulateBundle(ctx, simulations, nil, RevertAllowListed)
+	if err != nil {
+		return nil, err
+	}
+
+	return callBundleResponse(txs, simulations, results, uint64(bundle.BlockNumber)-1), nil
+}
+
+// simulationFromSignedTx returns the simulation of tx on top of the state of the block
+// preceding the one targeted by bundle.
+func simulationFromSignedTx(tx *types.Transaction, bundle FlashbotsBundle) (Simulation, error) {
+	if tx.To() == nil {
+		return Simulation{}, fmt.Errorf("%w: %s", ErrContractCreation, tx.Hash().Hex())
+	}
+
+	var signer types.Signer = types.HomesteadSigner{}
+	if tx.Protected() {
+		signer = types.LatestSignerForChainID(tx.ChainId())
+	}
+
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return Simulation{}, fmt.Errorf("%w: tx %s: %w", ErrInvalidBundle, tx.Hash().Hex(), err)
+	}
+
+	nonce := tx.Nonce()
+	number := uint64(bundle.BlockNumber)
+	simulation := Simulation{
+		From:           from,
+		To:             *tx.To(),
+		Input:          tx.Data(),
+		Value:          tx.Value(),
+		GasLimit:       tx.Gas(),
+		GasPrice:       tx.GasPrice(),
+		BlockNumber:    new(big.Int).SetUint64(number - 1),
+		Nonce:          &nonce,
+		BlockOverrides: &BlockOverrides{Number: new(big.Int).SetUint64(number)},
+	}
+
+	if bundle.MinTimestamp != 0 {
+		timestamp := bundle.MinTimestamp
+		simulation.BlockOverrides.Time = &timestamp
+	}
+
+	if tx.Type() == types.DynamicFeeTxType {
+		simulation.MaxFeePerGas = tx.GasFeeCap()
+		simulation.MaxPriorityFeePerGas = tx.GasTipCap()
+	}
+
+	// typed transactions only warm up their access list
+	if tx.Type() != types.LegacyTxType {
+		simulation.TxType = types.AccessListTxType
+		simulation.AccessList = append(types.AccessList{}, tx.AccessList()...)
+	}
+
+	return simulation, nil
+}
+
+func callBundleResponse(txs []*types.Transaction, simulations []Simulation, results []*SimulationResult, stateBlockNumber uint64) *CallBundleResponse {
+	var (
+		hashes   []byte
+		gasFees  = new(big.Int)
+		response = &CallBundleResponse{StateBlockNumber: stateBlockNumber}
+	)
+
+	for i, result := range results {
+		tx := txs[i]
+		fees := new(big.Int).Mul(result.EffectiveGasPrice, new(big.Int).SetUint64(result.GasUsed))
+		sent := new(big.Int).Sub(result.CoinbaseDiff, result.PriorityFees)
+
+		txResult := CallBundleResult{
+			CoinbaseDiff:      result.CoinbaseDiff.String(),
+			EthSentToCoinbase: sent.String(),
+			FromAddress:       simulations[i].From,
+			GasFees:           fees.String(),
+			GasPrice:          gasPrice(result.CoinbaseDiff, result.GasUsed).String(),
+			GasUsed:           result.GasUsed,
+			ToAddress:         tx.To(),
+			TxHash:            tx.Hash(),
+			Value:             result.ReturnedData,
+		}
+		if result.Revert != nil {
+			txResult.Value = nil
+			txResult.Error = result.Err().Error()
+			txResult.Revert = result.Revert.Reason
+		}
+
+		response.Results = append(response.Results, txResult)
+		hashes = append(hashes, tx.Hash().Bytes()...)
+		gasFees.Add(gasFees, fees)
+	}
+
+	profit := CoinbaseProfit(results)
+	response.BundleHash = crypto.Keccak256Hash(hashes)
+	response.BundleGasPrice = profit.EffectiveGasPrice.String()
+	response.CoinbaseDiff = profit.CoinbaseDiff.String()
+	response.EthSentToCoinbase = new(big.Int).Sub(profit.CoinbaseDiff, profit.PriorityFees).String()
+	response.GasFees = gasFees.String()
+	response.TotalGasUsed = profit.GasUsed
+
+	return response
+}
+
+// gasPrice is the payment to the coinbase per unit of gas used
+func gasPrice(coinbaseDiff *big.Int, gasUsed uint64) *big.Int {
+	if gasUsed == 0 {
+		return new(big.Int)
+	}
+
+	return new(big.Int).Div(coinbaseDiff, new(big.Int).SetUint64(gasUsed))
+}
@@ -0,0 +1,149 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSimulateFlashbotsBundle(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		reverter = common.HexToAddress("0x0000000000000000000000000000000000000012")
+		coinbase = common.HexToAddress("0x00000000000000000000000000000000000000cb")
+	)
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			switch addr {
+			case contract:
+				return hexutil.Bytes{byte(vm.STOP)}, nil
+			case reverter:
+				return hexutil.Bytes{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.REVERT)}, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getBalance":
+			return "0xde0b6b3a7640000", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{"number": "0x63", "difficulty": "0x0", "miner": coinbase, "baseFeePerGas": "0x5"}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	sign := func(nonce uint64, to common.Address, value int64) hexutil.Bytes {
+		tx, err := types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			To:       &to,
+			Value:    big.NewInt(value),
+			Gas:      100000,
+			GasPrice: big.NewInt(10),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := tx.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return raw
+	}
+
+	bundle := FlashbotsBundle{
+		Txs:         []hexutil.Bytes{sign(0, contract, 0), sign(1, coinbase, 1000)},
+		BlockNumber: 100,
+	}
+
+	response, err := sim.SimulateFlashbotsBundle(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Results) != 2 || response.StateBlockNumber != 99 {
+		t.Fatalf("results: %d state block number: %d", len(response.Results), response.StateBlockNumber)
+	}
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	var hashes []byte
+	for _, result := range response.Results {
+		if result.FromAddress != from || result.Error != "" {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		hashes = append(hashes, result.TxHash.Bytes()...)
+	}
+
+	if response.BundleHash != crypto.Keccak256Hash(hashes) {
+		t.Fatalf("bundle hash: %s", response.BundleHash.Hex())
+	}
+
+	if response.EthSentToCoinbase != "1000" || response.Results[1].EthSentToCoinbase != "1000" {
+		t.Fatalf("eth sent to coinbase: %s", response.EthSentToCoinbase)
+	}
+
+	fees := 10 * response.TotalGasUsed
+	if response.GasFees != new(big.Int).SetUint64(fees).String() {
+		t.Fatalf("gas fees: %s expected %d", response.GasFees, fees)
+	}
+
+	// the priority fee is 5 wei per gas over the base fee
+	diff := new(big.Int).SetUint64(5*response.TotalGasUsed + 1000)
+	if response.CoinbaseDiff != diff.String() {
+		t.Fatalf("coinbase diff: %s expected %s", response.CoinbaseDiff, diff)
+	}
+
+	// a reverting transaction fails the bundle unless allowed
+	bundle.Txs = append(bundle.Txs, sign(2, reverter, 0))
+	if _, err := sim.SimulateFlashbotsBundle(context.Background(), bundle); !errors.Is(err, ErrBundleReverted) {
+		t.Fatalf("expected ErrBundleReverted got: %v", err)
+	}
+
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(bundle.Txs[2]); err != nil {
+		t.Fatal(err)
+	}
+	bundle.RevertingTxHashes = []common.Hash{tx.Hash()}
+
+	response, err = sim.SimulateFlashbotsBundle(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Results[2].Error == "" {
+		t.Fatalf("expected reverted result: %+v", response.Results[2])
+	}
+
+	bundle.MinTimestamp, bundle.MaxTimestamp = 20, 10
+	if _, err := sim.SimulateFlashbotsBundle(context.Background(), bundle); !errors.Is(err, ErrInvalidBundle) {
+		t.Fatalf("expected ErrInvalidBundle got: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// forkCacheSize is the size of the cache of a Fork whose client has none
+const forkCacheSize = 100_000
+
+// ErrUnknownSnapshot is returned when reverting to a snapshot that doesn't exist, or
+// was discarded by reverting to an earlier one.
+var ErrUnknownSnapshot = errors.New("unknown snapshot")
+
+// Fork is the chain at a pinned block, with the changes of the transactions simulated
+// on it. The state fetched from the node is kept, in the fork and in the cache of its
+// client, so it's fetched once whatever the number of simulations. A Fork isn't safe
+// for concurrent use.
+type Fork struct {
+	sim         *Simulator
+	blockNumber *big.Int
+
+	stateDB *state.StateDB
+	// root of stateDB, committed after every simulation
+	root common.Hash
+	// txs is the number of transactions simulated on the fork
+	txs       int
+	snapshots []*BundleCheckpoint
+}
+
+// NewFork returns the fork of the chain served by clt at blockNumber, the latest block
+// when nil or zero. The client gets a cache when it has none, opts configure the
+// simulator of the fork.
+func NewFork(ctx context.Context, clt *rpc.Client, blockNumber *big.Int, opts ...func(*Simulator)) (*Fork, error) {
+	if clt.Cache() == nil {
+		clt = clt.Cached(rpc.NewCache(forkCacheSize))
+	}
+
+	sim, err := NewSimulator(clt, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sim.detectChainConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if blockNumber == nil || blockNumber.Sign() == 0 {
+		blockNumber, err = sim.latestBlockNumber(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if blockNumber == nil {
+			return nil, fmt.Errorf("%w: latest", rpc.ErrBlockNotFound)
+		}
+	}
+
+	stateDB, err := sim.NewRemoteState(ctx, blockNumber, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fork{
+		sim:         sim,
+		blockNumber: new(big.Int).Set(blockNumber),
+		stateDB:     stateDB,
+		root:        types.EmptyRootHash,
+	}, nil
+}
+
+// BlockNumber returns the block the fork is pinned to.
+func (f *Fork) BlockNumber() *big.Int {
+	return new(big.Int).Set(f.blockNumber)
+}
+
+// Simulator returns the simulator of the fork.
+func (f *Fork) Simulator() *Simulator {
+	return f.sim
+}
+
+// Simulate simulates the transaction on the fork and keeps its changes, the following
+// simulations see them. The block of simulation is replaced by the one of the fork.
+func (f *Fork) Simulate(ctx context.Context, simulation Simulation) (*SimulationResult, error) {
+	simulation, err := f.prepare(ctx, simulation, f.stateDB)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := f.sim.simulate(ctx, simulation, f.stateDB)
+	if err != nil {
+		// the state may hold part of the failed simulation
+		stateDB, resetErr := state.New(f.root, f.stateDB.Database(), nil)
+		if resetErr != nil {
+			return nil, errors.Join(err, resetErr)
+		}
+		f.stateDB = stateDB
+
+		return nil, err
+	}
+
+	stateDB, checkpoint, err := commitCheckpoint(f.stateDB, f.txs)
+	if err != nil {
+		return nil, err
+	}
+
+	f.stateDB, f.root = stateDB, checkpoint.root
+	f.txs++
+	result.Checkpoint = checkpoint
+
+	return result, nil
+}
+
+// Call executes simulation on the fork without keeping its changes, as eth_call does.
+// The returned data of a reverted call is returned along with a *RevertError.
+func (f *Fork) Call(ctx context.Context, simulation Simulation) ([]byte, error) {
+	result, err := f.preview(ctx, simulation)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.ReturnedData, result.Err()
+}
+
+// preview simulates the transaction on a copy of the state of the fork, its changes
+// aren't kept
+func (f *Fork) preview(ctx context.Context, simulation Simulation) (*SimulationResult, error) {
+	stateDB := f.stateDB.Copy()
+
+	simulation, err := f.prepare(ctx, simulation, stateDB)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.sim.simulate(ctx, simulation, stateDB)
+}
+
+// copy returns a fork at the same block and state as f, without its snapshots. The
+// simulations on either of them don't affect the other.
+func (f *Fork) copy() *Fork {
+	return &Fork{
+		sim:         f.sim,
+		blockNumber: f.BlockNumber(),
+		stateDB:     f.stateDB.Copy(),
+		root:        f.root,
+		txs:         f.txs,
+	}
+}
+
+// Snapshot records the current state of the fork and returns its id, to go back to it
+// with Revert.
+func (f *Fork) Snapshot() int {
+	f.snapshots = append(f.snapshots, &BundleCheckpoint{
+		Index: f.txs - 1,
+		root:  f.root,
+		db:    f.stateDB.Database(),
+	})
+
+	return len(f.snapshots) - 1
+}
+
+// Revert brings the fork back to the snapshot id, discarding it and the snapshots
+// taken after it.
+func (f *Fork) Revert(id int) error {
+	if id < 0 || id >= len(f.snapshots) {
+		return fmt.Errorf("%w: %d", ErrUnknownSnapshot, id)
+	}
+
+	snapshot := f.snapshots[id]
+	stateDB, err := snapshot.State()
+	if err != nil {
+		return err
+	}
+
+	f.stateDB, f.root = stateDB, snapshot.root
+	f.txs = snapshot.Index + 1
+	f.snapshots = f.snapshots[:id]
+
+	return nil
+}
+
+// prepare pins simulation to the block of the fork and fills its block context and nonce.
+func (f *Fork) prepare(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (Simulation, error) {
+	simulation.BlockNumber = f.BlockNumber()
+
+	simulation, err := f.sim.prepareSimulation(ctx, simulation)
+	if err != nil {
+		return simulation, err
+	}
+
+	runtime.BindRemoteState(ctx, stateDB, simulation.MaxRetries)
+
+	return f.sim.resolveNonce(ctx, simulation, stateDB)
+}
@@ -0,0 +1,139 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestFork(t *testing.T) {
+	counter := common.HexToAddress("0x0000000000000000000000000000000000000011")
+
+	// increments slot 0 and returns its new value
+	code := hexutil.Bytes{
+		byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD),
+		byte(vm.DUP1), byte(vm.PUSH0), byte(vm.SSTORE),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == counter {
+				return code, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getStorageAt":
+			var blk string
+			if err := json.Unmarshal(params[2], &blk); err != nil {
+				return nil, err
+			}
+			if blk != "0x64" {
+				return nil, errors.New("unexpected block " + blk)
+			}
+
+			return common.BigToHash(big.NewInt(10)), nil
+		case "eth_blockNumber":
+			return "0x64", nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{"number": "0x64", "difficulty": "0x0"}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	ctx := context.Background()
+	fork, err := NewFork(ctx, rpc.NewClient(srv.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fork.BlockNumber().Int64() != 100 {
+		t.Fatalf("block number: %s expected 100", fork.BlockNumber())
+	}
+
+	increment := Simulation{
+		From:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:       counter,
+		GasLimit: 100000,
+		GasPrice: big.NewInt(0),
+		Value:    big.NewInt(0),
+	}
+
+	value := func(data []byte) int64 {
+		return new(big.Int).SetBytes(data).Int64()
+	}
+
+	result, err := fork.Simulate(ctx, increment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value(result.ReturnedData) != 11 {
+		t.Fatalf("counter: %d expected 11", value(result.ReturnedData))
+	}
+
+	snapshot := fork.Snapshot()
+
+	// calls don't change the fork
+	for range 2 {
+		data, err := fork.Call(ctx, increment)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if value(data) != 12 {
+			t.Fatalf("counter: %d expected 12", value(data))
+		}
+	}
+
+	for _, expected := range []int64{12, 13} {
+		result, err = fork.Simulate(ctx, increment)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if value(result.ReturnedData) != expected {
+			t.Fatalf("counter: %d expected %d", value(result.ReturnedData), expected)
+		}
+	}
+
+	if result.Nonce != 3 {
+		t.Fatalf("nonce: %d expected 3", result.Nonce)
+	}
+
+	if err := fork.Revert(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = fork.Simulate(ctx, increment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value(result.ReturnedData) != 12 || result.Nonce != 2 {
+		t.Fatalf("counter: %d nonce: %d expected 12 and 2", value(result.ReturnedData), result.Nonce)
+	}
+
+	if calls := srv.Calls("eth_getStorageAt"); calls != 1 {
+		t.Fatalf("eth_getStorageAt called %d times expected 1", calls)
+	}
+
+	if err := fork.Revert(snapshot); !errors.Is(err, ErrUnknownSnapshot) {
+		t.Fatalf("expected ErrUnknownSnapshot got: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/core/state"
+)
+
+// ErrArchiveUnavailable is returned when the node can't serve the state of an old block.
+var ErrArchiveUnavailable = errors.New("node lacks archive data")
+
+// SimulateAt simulates sim on top of the state of blockNumber, using the block context
+// of the real block: coinbase, timestamp, base fee and difficulty. When stateDB is nil
+// a new remote state of the block is used, otherwise it must only contain state of it.
+func (s *Simulator) SimulateAt(ctx context.Context, sim Simulation, blockNumber *big.Int, stateDB *state.StateDB) (*SimulationResult, error) {
+	blk := "0x" + blockNumber.Text(16)
+
+	header, err := s.Cache.BlockHeader(ctx, s.RPCClt, blk)
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", blockNumber, err)
+	}
+
+	if stateDB == nil {
+		stateDB, err = s.NewRemoteState(ctx, blockNumber, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	runtime.BindRemoteState(ctx, stateDB, sim.MaxRetries)
+
+	// only archive nodes keep the state of old blocks, the target is read anyway so
+	// it's used to check the state is available
+	stateDB.GetCode(sim.To)
+	if err := stateDB.Error(); err != nil {
+		var rpcErr *rpc.ErrResponse
+		if errors.As(err, &rpcErr) {
+			return nil, fmt.Errorf("%w for block %s: %w", ErrArchiveUnavailable, blockNumber, err)
+		}
+
+		return nil, err
+	}
+
+	coinbase := header.Miner
+	sim.BlockNumber = blockNumber
+	sim.Coinbase = &coinbase
+	sim.Timestamp = uint64(header.Timestamp)
+	if header.BaseFee != nil {
+		sim.BaseFee = header.BaseFee.ToInt()
+	}
+	if header.Difficulty != nil {
+		sim.Difficulty = header.Difficulty.ToInt()
+	}
+
+	return s.Simulate(ctx, sim, stateDB)
+}
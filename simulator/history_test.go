@@ -0,0 +1,146 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestSimulateAt(t *testing.T) {
+	// returns COINBASE, TIMESTAMP, BASEFEE and NUMBER
+	code := []byte{
+		byte(vm.COINBASE), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.TIMESTAMP), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.BASEFEE), byte(vm.PUSH1), 0x40, byte(vm.MSTORE),
+		byte(vm.NUMBER), byte(vm.PUSH1), 0x60, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x80, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		blockNumber = big.NewInt(15_000_000)
+		blk         = "0xe4e1c0"
+		miner       = common.HexToAddress("0xea674fdde714fd979de3edf0f56aa9716b898ec8")
+		timestamp   = uint64(1655958001)
+		baseFee     = big.NewInt(23_000_000_000)
+	)
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(0),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	t.Run("uses the block context", func(t *testing.T) {
+		srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+			var block string
+			switch method {
+			case "eth_getBlockByNumber":
+				json.Unmarshal(params[0], &block)
+			case "eth_getCode", "eth_getBalance", "eth_getTransactionCount":
+				json.Unmarshal(params[1], &block)
+			default:
+				return nil, errors.New("unexpected method " + method)
+			}
+
+			if block != blk {
+				return nil, errors.New("unexpected block " + block)
+			}
+
+			switch method {
+			case "eth_getCode":
+				return hexutil.Bytes(code), nil
+			case "eth_getBalance", "eth_getTransactionCount":
+				return "0x0", nil
+			}
+
+			return map[string]interface{}{
+				"number":        blk,
+				"miner":         miner,
+				"timestamp":     hexutil.Uint64(timestamp),
+				"baseFeePerGas": (*hexutil.Big)(baseFee),
+				"difficulty":    "0x0",
+			}, nil
+		})
+
+		sim, err := NewSimulator(rpc.NewClient(srv.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := sim.SimulateAt(context.Background(), simulation, blockNumber, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.ReturnedData) != 0x80 {
+			t.Fatalf("returned data: %x", result.ReturnedData)
+		}
+
+		if got := common.BytesToAddress(result.ReturnedData[:0x20]); got != miner {
+			t.Fatalf("coinbase: %s expected: %s", got.Hex(), miner.Hex())
+		}
+
+		if got := new(big.Int).SetBytes(result.ReturnedData[0x20:0x40]); got.Uint64() != timestamp {
+			t.Fatalf("timestamp: %s expected: %d", got, timestamp)
+		}
+
+		if got := new(big.Int).SetBytes(result.ReturnedData[0x40:0x60]); got.Cmp(baseFee) != 0 {
+			t.Fatalf("base fee: %s expected: %s", got, baseFee)
+		}
+
+		if got := new(big.Int).SetBytes(result.ReturnedData[0x60:]); got.Cmp(blockNumber) != 0 {
+			t.Fatalf("block number: %s expected: %s", got, blockNumber)
+		}
+
+		// the contract and the sender, each fetched once
+		if calls := srv.Calls("eth_getCode"); calls != 2 {
+			t.Fatalf("eth_getCode called %d times expected 2", calls)
+		}
+	})
+
+	t.Run("node without archive data", func(t *testing.T) {
+		srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+			if method == "eth_getBlockByNumber" {
+				return map[string]interface{}{"number": blk, "timestamp": "0x0"}, nil
+			}
+
+			return nil, errors.New("missing trie node")
+		})
+
+		sim, err := NewSimulator(rpc.NewClient(srv.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = sim.SimulateAt(context.Background(), simulation, blockNumber, nil)
+		if !errors.Is(err, ErrArchiveUnavailable) {
+			t.Fatalf("expected ErrArchiveUnavailable got: %v", err)
+		}
+	})
+
+	t.Run("unknown block", func(t *testing.T) {
+		srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+			return nil, nil
+		})
+
+		sim, err := NewSimulator(rpc.NewClient(srv.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = sim.SimulateAt(context.Background(), simulation, blockNumber, nil)
+		if !errors.Is(err, rpc.ErrBlockNotFound) {
+			t.Fatalf("expected ErrBlockNotFound got: %v", err)
+		}
+	})
+}
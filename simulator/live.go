@@ -0,0 +1,253 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// maxRebaseBlocks bounds the blocks traced to carry the state of a fork to a later
+// block, beyond them it's fetched again
+const maxRebaseBlocks = 16
+
+// Rebase moves the fork to blockNumber, discarding the transactions simulated on it
+// and its snapshots. The state fetched so far, held by the cache of the client, is
+// kept except the accounts and slots modified by the blocks in between, found with
+// rpc.Client.GetBlockChanges. When they can't be traced, are too many, or blockNumber
+// isn't after the block of the fork, the whole state is fetched again.
+func (f *Fork) Rebase(ctx context.Context, blockNumber *big.Int) error {
+	return f.rebase(ctx, blockNumber, true)
+}
+
+// rebase moves the fork to blockNumber, carrying the state fetched that wasn't modified
+// when carry is set
+func (f *Fork) rebase(ctx context.Context, blockNumber *big.Int, carry bool) error {
+	if carry && blockNumber.Cmp(f.blockNumber) == 0 && f.txs == 0 {
+		return nil
+	}
+
+	blocks := new(big.Int).Sub(blockNumber, f.blockNumber)
+	if carry && blocks.Sign() > 0 && blocks.Cmp(big.NewInt(maxRebaseBlocks)) <= 0 {
+		changes, err := f.blockChanges(ctx, blockNumber)
+		if err != nil {
+			return err
+		}
+
+		if changes != nil {
+			carried := f.sim.RPCClt.Cache().Rebase(hexutil.EncodeBig(f.blockNumber), hexutil.EncodeBig(blockNumber), changes)
+			simLogger.DebugContext(ctx, "fork rebased", "from", f.blockNumber, "to", blockNumber, "carried", carried)
+		}
+	}
+
+	stateDB, err := f.sim.NewRemoteState(ctx, blockNumber, nil)
+	if err != nil {
+		return err
+	}
+
+	f.blockNumber = new(big.Int).Set(blockNumber)
+	f.stateDB, f.root = stateDB, types.EmptyRootHash
+	f.txs = 0
+	f.snapshots = nil
+
+	return nil
+}
+
+// blockChanges returns the changes of the blocks after the one of the fork up to
+// blockNumber, nil when the node can't trace them
+func (f *Fork) blockChanges(ctx context.Context, blockNumber *big.Int) (*rpc.BlockChanges, error) {
+	changes := rpc.NewBlockChanges()
+	for n := new(big.Int).Add(f.blockNumber, common.Big1); n.Cmp(blockNumber) <= 0; n.Add(n, common.Big1) {
+		blockChanges, err := f.sim.RPCClt.GetBlockChanges(ctx, hexutil.EncodeBig(n))
+		if err != nil {
+			var rpcErr *rpc.ErrResponse
+			if errors.As(err, &rpcErr) || errors.Is(err, rpc.ErrBlockNotFound) {
+				simLogger.DebugContext(ctx, "block changes unavailable", "block", n, "error", err)
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		changes.Merge(blockChanges)
+	}
+
+	return changes, nil
+}
+
+// LiveFork is a Fork following the head of the chain: it's rebased, see Fork.Rebase,
+// to every block announced by the newHeads subscription of the node. Simulations
+// always run on the latest block known, the ones of a block are discarded when the
+// next one arrives. It's safe for concurrent use.
+type LiveFork struct {
+	mu   sync.Mutex
+	fork *Fork
+	// hash of the block of the fork, zero when unknown
+	blockHash common.Hash
+
+	sub       *rpc.Subscription
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// NewLiveFork returns a fork of the chain served by clt at its latest block, rebased
+// to every new block announced by the WebSocket endpoint wsEndpoint of the same node
+// until Close is called. opts configure the simulator of the fork, as with NewFork.
+func NewLiveFork(ctx context.Context, clt *rpc.Client, wsEndpoint string, opts ...func(*Simulator)) (*LiveFork, error) {
+	heads := make(chan *rpc.BlockHeader, 16)
+	sub, err := rpc.SubscribeNewHeads(ctx, wsEndpoint, heads)
+	if err != nil {
+		return nil, err
+	}
+
+	// subscribed first, so no block is missed
+	fork, err := NewFork(ctx, clt, nil, opts...)
+	if err != nil {
+		sub.Unsubscribe()
+		return nil, err
+	}
+
+	followCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	l := &LiveFork{
+		fork:   fork,
+		sub:    sub,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go l.follow(followCtx, heads)
+
+	return l, nil
+}
+
+// follow rebases the fork to the heads received until ctx is done or the
+// subscription fails
+func (l *LiveFork) follow(ctx context.Context, heads <-chan *rpc.BlockHeader) {
+	defer close(l.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-l.sub.Err():
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+			return
+		case head := <-heads:
+			if head == nil || head.Number == nil {
+				continue
+			}
+
+			err := l.rebase(ctx, head)
+			if err != nil && ctx.Err() == nil {
+				// the fork stays at its block, the next head may succeed
+				simLogger.WarnContext(ctx, "rebasing live fork failed", "block", head.Number.ToInt(), "error", err)
+			}
+		}
+	}
+}
+
+// rebase moves the fork to head, the state fetched isn't carried over a reorganization
+func (l *LiveFork) rebase(ctx context.Context, head *rpc.BlockHeader) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	number := head.Number.ToInt()
+	switch {
+	case head.Hash == l.blockHash:
+		return nil
+	case l.blockHash == (common.Hash{}) && number.Cmp(l.fork.blockNumber) <= 0:
+		// the fork was pinned to its block without knowing its hash
+		if number.Cmp(l.fork.blockNumber) == 0 {
+			l.blockHash = head.Hash
+		}
+		return nil
+	}
+
+	// a head that isn't the child of the block of the fork replaces it, the state
+	// cached at both blocks may be the one of blocks no longer in the chain
+	carry := l.blockHash == (common.Hash{}) || head.ParentHash == l.blockHash
+	if !carry {
+		cache := l.fork.sim.RPCClt.Cache()
+		cache.Drop(hexutil.EncodeBig(l.fork.blockNumber))
+		cache.Drop(hexutil.EncodeBig(number))
+		simLogger.InfoContext(ctx, "chain reorganized", "from", l.fork.blockNumber, "to", number)
+	}
+
+	err := l.fork.rebase(ctx, number, carry)
+	if err != nil {
+		return err
+	}
+	l.blockHash = head.Hash
+
+	return nil
+}
+
+// BlockNumber returns the block the fork is at.
+func (l *LiveFork) BlockNumber() *big.Int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.fork.BlockNumber()
+}
+
+// Simulate simulates the transaction on the latest block and keeps its changes until
+// the next block, as Fork.Simulate.
+func (l *LiveFork) Simulate(ctx context.Context, simulation Simulation) (*SimulationResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.fork.Simulate(ctx, simulation)
+}
+
+// Call executes simulation on the latest block without keeping its changes, as
+// Fork.Call.
+func (l *LiveFork) Call(ctx context.Context, simulation Simulation) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.fork.Call(ctx, simulation)
+}
+
+// Preview simulates the transaction on the latest block without keeping its changes,
+// it runs on a copy of the fork so it doesn't hold back the other simulations nor
+// the rebase to the next block.
+func (l *LiveFork) Preview(ctx context.Context, simulation Simulation) (*SimulationResult, error) {
+	l.mu.Lock()
+	fork := l.fork.copy()
+	l.mu.Unlock()
+
+	return fork.preview(ctx, simulation)
+}
+
+// Done returns a channel closed once the fork stops following the chain, because of
+// Close or of the subscription failing, see Err.
+func (l *LiveFork) Done() <-chan struct{} {
+	return l.done
+}
+
+// Err returns the error that ended the subscription, nil while it's active or when
+// ended by Close.
+func (l *LiveFork) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+// Close stops following the chain, the fork stays at its last block. It can be called
+// more than once.
+func (l *LiveFork) Close() {
+	l.closeOnce.Do(func() {
+		l.cancel()
+		l.sub.Unsubscribe()
+	})
+	<-l.done
+}
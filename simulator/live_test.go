@@ -0,0 +1,224 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+)
+
+// subscriptionService serves the eth_subscribe subscriptions of a node, notifying the
+// values sent on its channels
+type subscriptionService struct {
+	heads   chan *rpc.BlockHeader
+	pending chan common.Hash
+}
+
+func (s *subscriptionService) NewHeads(ctx context.Context) (*gethrpc.Subscription, error) {
+	return notify(ctx, s.heads)
+}
+
+func (s *subscriptionService) NewPendingTransactions(ctx context.Context) (*gethrpc.Subscription, error) {
+	return notify(ctx, s.pending)
+}
+
+func notify[T any](ctx context.Context, values <-chan T) (*gethrpc.Subscription, error) {
+	notifier, ok := gethrpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, gethrpc.ErrNotificationsUnsupported
+	}
+
+	sub := notifier.CreateSubscription()
+	go func() {
+		for {
+			select {
+			case value := <-values:
+				notifier.Notify(sub.ID, value)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+// newSubscriptionServer returns the WebSocket endpoint of a node serving service
+func newSubscriptionServer(t *testing.T, service *subscriptionService) string {
+	srv := gethrpc.NewServer()
+	if err := srv.RegisterName("eth", service); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+
+	ws := httptest.NewServer(srv.WebsocketHandler([]string{"*"}))
+	t.Cleanup(ws.Close)
+
+	return "ws://" + strings.TrimPrefix(ws.URL, "http://")
+}
+
+func TestLiveFork(t *testing.T) {
+	var (
+		contractA = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		contractB = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+		// returns the slot 0
+		code = hexutil.Bytes{
+			byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.PUSH0), byte(vm.MSTORE),
+			byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+		}
+
+		mu sync.Mutex
+		// requests of the state by account and block
+		fetched = make(map[string]int)
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_blockNumber":
+			return "0x64", nil
+		case "eth_getCode", "eth_getStorageAt", "eth_getBalance", "eth_getTransactionCount":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+
+			var blk string
+			if err := json.Unmarshal(params[len(params)-1], &blk); err != nil {
+				return nil, err
+			}
+
+			mu.Lock()
+			fetched[fmt.Sprintf("%s %s@%s", method, addr.Hex(), blk)]++
+			mu.Unlock()
+
+			switch method {
+			case "eth_getCode":
+				if addr == contractA || addr == contractB {
+					return code, nil
+				}
+				return hexutil.Bytes{}, nil
+			case "eth_getStorageAt":
+				// B is modified by every block after 0x64
+				if addr == contractB && blk != "0x64" {
+					return common.BigToHash(common.Big2), nil
+				}
+				return common.BigToHash(common.Big1), nil
+			}
+
+			return "0x0", nil
+		case "eth_getBlockByNumber":
+			var blk string
+			if err := json.Unmarshal(params[0], &blk); err != nil {
+				return nil, err
+			}
+
+			return map[string]interface{}{"number": blk, "transactions": []interface{}{}, "withdrawals": []interface{}{}}, nil
+		case "debug_traceBlockByNumber":
+			return []interface{}{
+				map[string]interface{}{"result": map[string]interface{}{
+					"pre":  map[common.Address]interface{}{contractB: map[string]interface{}{"storage": map[common.Hash]common.Hash{{}: common.BigToHash(common.Big1)}}},
+					"post": map[common.Address]interface{}{contractB: map[string]interface{}{"storage": map[common.Hash]common.Hash{{}: common.BigToHash(common.Big2)}}},
+				}},
+			}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	service := &subscriptionService{heads: make(chan *rpc.BlockHeader)}
+	ctx := context.Background()
+	fork, err := NewLiveFork(ctx, rpc.NewClient(srv.URL), newSubscriptionServer(t, service))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fork.Close()
+
+	call := func(to common.Address) uint64 {
+		ret, err := fork.Call(ctx, Simulation{
+			From:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+			To:       to,
+			GasLimit: 100000,
+			GasPrice: big.NewInt(0),
+			Value:    big.NewInt(0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return new(big.Int).SetBytes(ret).Uint64()
+	}
+
+	fetches := func(method string, addr common.Address, blk string) int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return fetched[fmt.Sprintf("%s %s@%s", method, addr.Hex(), blk)]
+	}
+
+	waitBlock := func(number uint64) {
+		deadline := time.Now().Add(5 * time.Second)
+		for fork.BlockNumber().Uint64() != number {
+			if time.Now().After(deadline) {
+				t.Fatalf("fork at block %d expected %d", fork.BlockNumber(), number)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if a, b := call(contractA), call(contractB); a != 1 || b != 1 {
+		t.Fatalf("slots at 0x64: %d %d expected 1 1", a, b)
+	}
+
+	hashes := []common.Hash{common.HexToHash("0x64"), common.HexToHash("0x65"), common.HexToHash("0x65b"), common.HexToHash("0x66")}
+	service.heads <- &rpc.BlockHeader{Number: (*hexutil.Big)(big.NewInt(0x64)), Hash: hashes[0]}
+	service.heads <- &rpc.BlockHeader{Number: (*hexutil.Big)(big.NewInt(0x65)), Hash: hashes[1], ParentHash: hashes[0]}
+	waitBlock(0x65)
+
+	if a, b := call(contractA), call(contractB); a != 1 || b != 2 {
+		t.Fatalf("slots at 0x65: %d %d expected 1 2", a, b)
+	}
+
+	// only the slot modified by the block is fetched again
+	for _, method := range []string{"eth_getCode", "eth_getStorageAt"} {
+		if n := fetches(method, contractA, "0x65"); n != 0 {
+			t.Fatalf("%s of A at 0x65: %d requests expected none", method, n)
+		}
+	}
+	if n := fetches("eth_getStorageAt", contractB, "0x65"); n != 1 {
+		t.Fatalf("storage of B at 0x65: %d requests expected 1", n)
+	}
+
+	// a reorganization replaces 0x65, the state cached for it isn't carried
+	service.heads <- &rpc.BlockHeader{Number: (*hexutil.Big)(big.NewInt(0x65)), Hash: hashes[2], ParentHash: common.HexToHash("0x64b")}
+	service.heads <- &rpc.BlockHeader{Number: (*hexutil.Big)(big.NewInt(0x66)), Hash: hashes[3], ParentHash: hashes[2]}
+	waitBlock(0x66)
+
+	if a := call(contractA); a != 1 {
+		t.Fatalf("slot of A at 0x66: %d expected 1", a)
+	}
+	if n := fetches("eth_getStorageAt", contractA, "0x66"); n != 1 {
+		t.Fatalf("storage of A at 0x66: %d requests expected 1", n)
+	}
+
+	fork.Close()
+	select {
+	case <-fork.Done():
+	default:
+		t.Fatal("fork still following the chain after Close")
+	}
+	if err := fork.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,108 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	goruntime "runtime"
+	"sync"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+// manyCacheSize is the size of the cache used by SimulateMany when the client has none
+const manyCacheSize = 100_000
+
+// SimulationOutcome is the result of one of the simulations of SimulateMany.
+type SimulationOutcome struct {
+	Result *SimulationResult
+	Err    error
+}
+
+// SimulateMany runs independent simulations concurrently, with at most concurrency of
+// them at once, GOMAXPROCS when not positive. Every simulation runs on its own fresh
+// state as with Simulate, the state fetched from the fork is shared between them
+// through the cache of the client, or a temporary one when the client has none.
+// Simulations at the latest block are pinned to the block that is the latest when
+// SimulateMany starts, so all of them see the same state and can use the cache.
+//
+// The outcomes are in the order of simulations, a failing simulation doesn't stop
+// the others.
+func (s *Simulator) SimulateMany(ctx context.Context, simulations []Simulation, concurrency int) ([]SimulationOutcome, error) {
+	if concurrency <= 0 {
+		concurrency = goruntime.GOMAXPROCS(0)
+	}
+
+	sim := s
+	if s.RPCClt.Cache() == nil {
+		sim = s.withClient(s.RPCClt.Cached(rpc.NewCache(manyCacheSize)))
+	}
+
+	latest, err := sim.latestBlock(ctx, simulations)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, concurrency)
+		outcomes = make([]SimulationOutcome, len(simulations))
+	)
+
+	for i := range simulations {
+		simulation := simulations[i]
+		if simulation.BlockNumber == nil || simulation.BlockNumber.Sign() == 0 {
+			simulation.BlockNumber = new(big.Int)
+			if latest != nil {
+				simulation.BlockNumber.Set(latest)
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			outcomes[i].Result, outcomes[i].Err = sim.Simulate(ctx, simulation, nil)
+		}()
+	}
+	wg.Wait()
+
+	return outcomes, nil
+}
+
+// latestBlock returns the number of the latest block when any of simulations is at
+// the latest block, nil otherwise or when the fork doesn't serve it.
+func (s *Simulator) latestBlock(ctx context.Context, simulations []Simulation) (*big.Int, error) {
+	for _, simulation := range simulations {
+		if simulation.BlockNumber == nil || simulation.BlockNumber.Sign() == 0 {
+			return s.latestBlockNumber(ctx)
+		}
+	}
+
+	return nil, nil
+}
+
+// latestBlockNumber returns the number of the latest block, nil when the fork doesn't
+// serve it.
+func (s *Simulator) latestBlockNumber(ctx context.Context) (*big.Int, error) {
+	number, err := s.RPCClt.BlockNumber(ctx)
+	if err != nil {
+		var rpcErr *rpc.ErrResponse
+		if errors.As(err, &rpcErr) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return number, nil
+}
+
+// withClient returns a simulator with the configuration of s fetching the state with clt.
+func (s *Simulator) withClient(clt *rpc.Client) *Simulator {
+	c := *s
+	c.RPCClt = clt
+
+	return &c
+}
@@ -0,0 +1,164 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	ourVm "github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestSimulateMany(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+
+	// returns the calldata
+	code := hexutil.Bytes{
+		byte(vm.CALLDATASIZE), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.CALLDATACOPY),
+		byte(vm.CALLDATASIZE), byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+
+			var blk string
+			if err := json.Unmarshal(params[1], &blk); err != nil {
+				return nil, err
+			}
+			if blk != "0x64" {
+				return nil, errors.New("unexpected block " + blk)
+			}
+
+			if addr == contract {
+				return code, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_blockNumber":
+			return "0x64", nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{"number": "0x64", "difficulty": "0x0"}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulations := make([]Simulation, 8)
+	for i := range simulations {
+		simulations[i] = Simulation{
+			From:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+			To:       contract,
+			GasLimit: 100000,
+			GasPrice: big.NewInt(0),
+			Value:    big.NewInt(0),
+			Input:    []byte{byte(i)},
+		}
+	}
+	// can't pay for its value
+	simulations[3].Value = big.NewInt(1)
+
+	for _, concurrency := range []int{1, 4} {
+		outcomes, err := sim.SimulateMany(context.Background(), simulations, concurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, outcome := range outcomes {
+			if i == 3 {
+				if !errors.Is(outcome.Err, ErrInsufficientBalance) {
+					t.Fatalf("expected ErrInsufficientBalance got: %v", outcome.Err)
+				}
+				continue
+			}
+
+			if outcome.Err != nil {
+				t.Fatal(outcome.Err)
+			}
+
+			if len(outcome.Result.ReturnedData) != 1 || outcome.Result.ReturnedData[0] != byte(i) {
+				t.Fatalf("returned data of %d: %x", i, outcome.Result.ReturnedData)
+			}
+		}
+
+		// the code of the contract and of the sender is fetched once, by the first
+		// simulation
+		if concurrency == 1 {
+			if calls := srv.Calls("eth_getCode"); calls != 2 {
+				t.Fatalf("eth_getCode called %d times expected 2", calls)
+			}
+		}
+	}
+}
+
+func TestSimulateManyVerifiedState(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	slot := common.BigToHash(big.NewInt(7))
+	// returns the value of slot 7
+	code := []byte{
+		byte(vm.PUSH1), 0x07, byte(vm.SLOAD),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	node := newProofNode(t, contract, code, map[common.Hash]*big.Int{slot: big.NewInt(42)})
+	srv := node.serve(t)
+
+	simulations := []Simulation{{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          contract,
+		BlockNumber: big.NewInt(1),
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}}
+
+	for _, test := range []struct {
+		name      string
+		stateRoot common.Hash
+		err       error
+	}{
+		{name: "state root of the block", stateRoot: node.header.Root},
+		{name: "other state root", stateRoot: common.HexToHash("0x01"), err: ourVm.ErrInvalidProof},
+	} {
+		// without cache SimulateMany uses a copy of the simulator with a cached client
+		sim, err := NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil), WithVerifiedState(test.stateRoot))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outcomes, err := sim.SimulateMany(context.Background(), simulations, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outcome := outcomes[0]
+		if test.err != nil {
+			if !errors.Is(outcome.Err, test.err) {
+				t.Fatalf("%s: %v expected, got %v", test.name, test.err, outcome.Err)
+			}
+			continue
+		}
+
+		if outcome.Err != nil {
+			t.Fatalf("%s: %s", test.name, outcome.Err)
+		}
+		if v := new(big.Int).SetBytes(outcome.Result.ReturnedData); v.Cmp(big.NewInt(42)) != 0 {
+			t.Fatalf("%s: slot %s expected 42", test.name, v)
+		}
+	}
+}
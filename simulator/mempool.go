@@ -0,0 +1,250 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	defaultMempoolWorkers = 4
+	// mempoolQueueSize bounds the pending transactions waiting to be simulated, the
+	// ones announced while it's full are dropped
+	mempoolQueueSize = 1024
+)
+
+// PendingSimulation is the outcome of a pending transaction simulated by a
+// MempoolStream.
+type PendingSimulation struct {
+	Hash        common.Hash
+	Transaction *rpc.Transaction
+	Result      *SimulationResult
+	// Err is set when the transaction couldn't be simulated, contract creations
+	// fail with ErrContractCreation
+	Err error
+}
+
+// MempoolStream simulates the transactions entering the mempool of a node on the
+// latest block of a LiveFork, without keeping their changes, and sends their outcome
+// on Results. Transactions announced faster than they're simulated, or than the
+// results are received, are dropped.
+type MempoolStream struct {
+	fork    *LiveFork
+	workers int
+	filter  func(*rpc.Transaction) bool
+
+	sub       *rpc.Subscription
+	results   chan PendingSimulation
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// WithMempoolWorkers sets the number of pending transactions simulated concurrently.
+func WithMempoolWorkers(n int) func(*MempoolStream) {
+	return func(m *MempoolStream) {
+		if n > 0 {
+			m.workers = n
+		}
+	}
+}
+
+// WithMempoolFilter only simulates the pending transactions for which filter returns
+// true, e.g. the ones sent to a given contract.
+func WithMempoolFilter(filter func(*rpc.Transaction) bool) func(*MempoolStream) {
+	return func(m *MempoolStream) {
+		m.filter = filter
+	}
+}
+
+// NewMempoolStream subscribes to the transactions entering the mempool through the
+// WebSocket endpoint wsEndpoint of the node followed by fork, and simulates them until
+// Close is called.
+func NewMempoolStream(ctx context.Context, fork *LiveFork, wsEndpoint string, opts ...func(*MempoolStream)) (*MempoolStream, error) {
+	m := &MempoolStream{
+		fork:    fork,
+		workers: defaultMempoolWorkers,
+		results: make(chan PendingSimulation),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	hashes := make(chan common.Hash, mempoolQueueSize)
+	sub, err := rpc.SubscribePendingTransactions(ctx, wsEndpoint, hashes)
+	if err != nil {
+		return nil, err
+	}
+	m.sub = sub
+
+	streamCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	m.cancel = cancel
+	go m.run(streamCtx, hashes)
+
+	return m, nil
+}
+
+// run dispatches the hashes received to the workers until ctx is done or the
+// subscription fails, then closes the results
+func (m *MempoolStream) run(ctx context.Context, hashes <-chan common.Hash) {
+	defer close(m.done)
+
+	queue := make(chan common.Hash, mempoolQueueSize)
+	var wg sync.WaitGroup
+	for i := 0; i < m.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.work(ctx, queue)
+		}()
+	}
+
+	defer func() {
+		close(queue)
+		wg.Wait()
+		close(m.results)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-m.sub.Err():
+			m.mu.Lock()
+			m.err = err
+			m.mu.Unlock()
+			m.cancel()
+			return
+		case hash := <-hashes:
+			select {
+			case queue <- hash:
+			default:
+				simLogger.DebugContext(ctx, "pending transaction dropped", "hash", hash)
+			}
+		}
+	}
+}
+
+// work simulates the transactions of queue until it's closed
+func (m *MempoolStream) work(ctx context.Context, queue <-chan common.Hash) {
+	for hash := range queue {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		pending, ok := m.simulate(ctx, hash)
+		if !ok {
+			continue
+		}
+
+		select {
+		case m.results <- pending:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// simulate fetches the transaction and simulates it on the fork, it reports false when
+// the transaction is no longer pending or is filtered out
+func (m *MempoolStream) simulate(ctx context.Context, hash common.Hash) (PendingSimulation, bool) {
+	pending := PendingSimulation{Hash: hash}
+
+	tx, err := m.fork.fork.sim.RPCClt.GetTransactionByHash(ctx, hash)
+	if err != nil {
+		if errors.Is(err, rpc.ErrTransactionNotFound) {
+			// mined or replaced since it was announced
+			return pending, false
+		}
+
+		pending.Err = err
+		return pending, ctx.Err() == nil
+	}
+
+	if tx.BlockNumber != nil || (m.filter != nil && !m.filter(tx)) {
+		return pending, false
+	}
+	pending.Transaction = tx
+
+	if tx.To == nil {
+		pending.Err = fmt.Errorf("%w: %s", ErrContractCreation, hash.Hex())
+		return pending, true
+	}
+
+	pending.Result, pending.Err = m.fork.Preview(ctx, simulationFromPendingTx(tx))
+
+	return pending, ctx.Err() == nil
+}
+
+// simulationFromPendingTx returns the simulation of the pending transaction tx, its
+// nonce must be the next one of the sender on the fork
+func simulationFromPendingTx(tx *rpc.Transaction) Simulation {
+	nonce := uint64(tx.Nonce)
+	simulation := Simulation{
+		From:     tx.From,
+		To:       *tx.To,
+		Input:    tx.Input,
+		Value:    new(big.Int),
+		GasLimit: uint64(tx.Gas),
+		GasPrice: new(big.Int),
+		Nonce:    &nonce,
+	}
+
+	if tx.Value != nil {
+		simulation.Value = tx.Value.ToInt()
+	}
+
+	if tx.GasPrice != nil {
+		simulation.GasPrice = tx.GasPrice.ToInt()
+	}
+
+	if tx.MaxFeePerGas != nil && tx.MaxPriorityFeePerGas != nil {
+		simulation.MaxFeePerGas = tx.MaxFeePerGas.ToInt()
+		simulation.MaxPriorityFeePerGas = tx.MaxPriorityFeePerGas.ToInt()
+	}
+
+	// typed transactions only warm up their access list
+	if uint64(tx.Type) != types.LegacyTxType {
+		simulation.TxType = types.AccessListTxType
+		simulation.AccessList = types.AccessList{}
+		if tx.AccessList != nil {
+			simulation.AccessList = *tx.AccessList
+		}
+	}
+
+	return simulation
+}
+
+// Results returns the channel receiving the outcome of the pending transactions, it's
+// closed once the stream stops.
+func (m *MempoolStream) Results() <-chan PendingSimulation {
+	return m.results
+}
+
+// Err returns the error that ended the subscription, nil while it's active or when
+// ended by Close.
+func (m *MempoolStream) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+// Close stops simulating the pending transactions, the ones being simulated are
+// discarded. It can be called more than once.
+func (m *MempoolStream) Close() {
+	m.closeOnce.Do(func() {
+		m.cancel()
+		m.sub.Unsubscribe()
+	})
+	<-m.done
+}
@@ -0,0 +1,141 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestMempoolStream(t *testing.T) {
+	var (
+		sender   = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		contract = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		ignored  = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+		// returns the slot 0
+		code = hexutil.Bytes{
+			byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.PUSH0), byte(vm.MSTORE),
+			byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+		}
+
+		call     = common.HexToHash("0x01")
+		creation = common.HexToHash("0x02")
+		filtered = common.HexToHash("0x03")
+		mined    = common.HexToHash("0x04")
+		unknown  = common.HexToHash("0x05")
+	)
+
+	txs := map[common.Hash]map[string]interface{}{
+		call:     {"hash": call, "type": "0x2", "from": sender, "to": contract, "gas": "0x186a0", "maxFeePerGas": "0x0", "maxPriorityFeePerGas": "0x0", "accessList": []interface{}{}},
+		creation: {"hash": creation, "type": "0x0", "from": sender, "to": nil, "gas": "0x186a0", "gasPrice": "0x0"},
+		filtered: {"hash": filtered, "type": "0x0", "from": sender, "to": ignored, "gas": "0x186a0", "gasPrice": "0x0"},
+		mined:    {"hash": mined, "blockNumber": "0x64", "type": "0x0", "from": sender, "to": contract, "gas": "0x186a0", "gasPrice": "0x0"},
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_blockNumber":
+			return "0x64", nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{"number": "0x64", "baseFeePerGas": "0x0"}, nil
+		case "eth_getTransactionByHash":
+			var hash common.Hash
+			if err := json.Unmarshal(params[0], &hash); err != nil {
+				return nil, err
+			}
+
+			tx, ok := txs[hash]
+			if !ok {
+				return nil, nil
+			}
+			return tx, nil
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+
+			if addr == contract {
+				return code, nil
+			}
+			return hexutil.Bytes{}, nil
+		case "eth_getStorageAt":
+			return common.BigToHash(big.NewInt(7)), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	service := &subscriptionService{pending: make(chan common.Hash)}
+	endpoint := newSubscriptionServer(t, service)
+
+	ctx := context.Background()
+	fork, err := NewLiveFork(ctx, rpc.NewClient(srv.URL), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fork.Close()
+
+	stream, err := NewMempoolStream(ctx, fork, endpoint, WithMempoolWorkers(1), WithMempoolFilter(func(tx *rpc.Transaction) bool {
+		return tx.To == nil || *tx.To != ignored
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	for _, hash := range []common.Hash{unknown, mined, filtered, creation, call} {
+		service.pending <- hash
+	}
+
+	results := make(map[common.Hash]PendingSimulation)
+	timeout := time.After(5 * time.Second)
+	for len(results) < 2 {
+		select {
+		case pending := <-stream.Results():
+			if _, ok := results[pending.Hash]; ok {
+				t.Fatalf("%s received twice", pending.Hash.Hex())
+			}
+			results[pending.Hash] = pending
+		case <-timeout:
+			t.Fatalf("%d results received expected 2", len(results))
+		}
+	}
+
+	if pending, ok := results[creation]; !ok || !errors.Is(pending.Err, ErrContractCreation) {
+		t.Fatalf("contract creation expected to fail with %v: %+v", ErrContractCreation, pending)
+	}
+
+	pending, ok := results[call]
+	if !ok {
+		t.Fatalf("no result for %s", call.Hex())
+	}
+	if pending.Err != nil {
+		t.Fatal(pending.Err)
+	}
+	if got := new(big.Int).SetBytes(pending.Result.ReturnedData).Uint64(); got != 7 {
+		t.Fatalf("returned slot: %d expected 7", got)
+	}
+
+	// nothing is kept on the fork
+	if nonce := fork.fork.stateDB.GetNonce(sender); nonce != 0 {
+		t.Fatalf("sender nonce on the fork: %d expected 0", nonce)
+	}
+
+	stream.Close()
+	if _, ok := <-stream.Results(); ok {
+		t.Fatal("results still open after Close")
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
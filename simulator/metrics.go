@@ -0,0 +1,48 @@
+package simulator
+
+import (
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/Gealber/evm-simulator/metrics"
+)
+
+// WithMetrics records the simulations run, their duration and the opcodes they
+// execute in m. The requests to the fork are recorded by the RPC client, see
+// rpc.WithMetrics.
+func WithMetrics(m *metrics.Metrics) func(*Simulator) {
+	return func(s *Simulator) {
+		s.metrics = m
+	}
+}
+
+// observeSimulation records a simulation with its result or its error
+func (s *Simulator) observeSimulation(result *SimulationResult, err error) {
+	switch {
+	case err != nil:
+		s.metrics.ObserveSimulation(metrics.StatusError)
+	case result.Status == types.ReceiptStatusFailed:
+		s.metrics.ObserveSimulation(metrics.StatusReverted)
+	default:
+		s.metrics.ObserveSimulation(metrics.StatusSuccess)
+	}
+}
+
+// countOpcodes returns hooks counting the opcodes executed in n, calling the ones
+// of tracer as well.
+func countOpcodes(tracer *tracing.Hooks, n *uint64) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	onOpcode := hooks.OnOpcode
+	hooks.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+		*n++
+		if onOpcode != nil {
+			onOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+	}
+
+	return hooks
+}
@@ -0,0 +1,130 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/Gealber/evm-simulator/metrics"
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+func TestMetrics(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	// loads slot 0
+	code := []byte{byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.STOP)}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return common.Hash{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == contract {
+				return hexutil.Bytes(code), nil
+			}
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	m := metrics.New(prometheus.NewRegistry())
+	clt := rpc.NewClient(srv.URL, rpc.WithCache(rpc.NewCache(100)), rpc.WithMetrics(m))
+
+	sim, err := NewSimulator(clt, WithChainConfig(nil), WithMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          contract,
+		Code:        code,
+		BlockNumber: big.NewInt(1),
+		Value:       big.NewInt(0),
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := sim.Simulate(context.Background(), simulation, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	simulation.To = common.HexToAddress("0x0000000000000000000000000000000000000012")
+	simulation.Code = []byte{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.REVERT)}
+	if _, err := sim.Simulate(context.Background(), simulation, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.ToFloat64(m.Simulations.WithLabelValues(metrics.StatusSuccess)); n != 2 {
+		t.Fatalf("successful simulations: %v", n)
+	}
+
+	if n := testutil.ToFloat64(m.Simulations.WithLabelValues(metrics.StatusReverted)); n != 1 {
+		t.Fatalf("reverted simulations: %v", n)
+	}
+
+	// 3 opcodes by each of the simulations
+	if n := testutil.ToFloat64(m.OpcodesExecuted); n != 9 {
+		t.Fatalf("opcodes executed: %v", n)
+	}
+
+	// the slot is fetched once, the second simulation finds it in the cache
+	if n := testutil.ToFloat64(m.RPCRequests.WithLabelValues("eth_getStorageAt")); n != 1 {
+		t.Fatalf("storage requests: %v", n)
+	}
+
+	if n := testutil.ToFloat64(m.CacheLookups.WithLabelValues("storage", "hit")); n == 0 {
+		t.Fatal("missing cache hits")
+	}
+}
+
+func TestSimulateManyMetrics(t *testing.T) {
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	// without cache, SimulateMany runs a copy of the simulator with one
+	m := metrics.New(prometheus.NewRegistry())
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil), WithMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulations := make([]Simulation, 3)
+	for i := range simulations {
+		simulations[i] = Simulation{
+			From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+			To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+			BlockNumber: big.NewInt(1),
+			Value:       big.NewInt(0),
+		}
+	}
+
+	if _, err := sim.SimulateMany(context.Background(), simulations, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.ToFloat64(m.Simulations.WithLabelValues(metrics.StatusSuccess)); n != 3 {
+		t.Fatalf("successful simulations: %v expected 3", n)
+	}
+}
@@ -0,0 +1,212 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+)
+
+var (
+	// ErrInvalidBundleNonces is returned by SimulateBundle when the nonces of the bundle
+	// don't follow the nonces on chain.
+	ErrInvalidBundleNonces = errors.New("invalid bundle nonces")
+	// ErrInvalidNonce is returned by Simulate when the nonce of the simulation isn't
+	// the nonce of the sender on chain.
+	ErrInvalidNonce = errors.New("invalid nonce")
+)
+
+// NonceError reports a transaction of a bundle whose nonce isn't the expected one.
+type NonceError struct {
+	TxIndex  int
+	Address  common.Address
+	Expected uint64
+	Got      uint64
+}
+
+func (e NonceError) Error() string {
+	return fmt.Sprintf("tx %d from %s: nonce %d expected %d", e.TxIndex, e.Address.Hex(), e.Got, e.Expected)
+}
+
+// ValidateBundleNonces checks that the nonces of each sender are sequential in the
+// order of the bundle, starting from the lowest nonce of the sender. Simulations
+// without Nonce are skipped.
+func ValidateBundleNonces(simulations []Simulation) []NonceError {
+	start := make(map[common.Address]uint64)
+	for _, sim := range simulations {
+		if sim.Nonce == nil {
+			continue
+		}
+
+		if lowest, ok := start[sim.From]; !ok || *sim.Nonce < lowest {
+			start[sim.From] = *sim.Nonce
+		}
+	}
+
+	return checkBundleNonces(simulations, start)
+}
+
+// validateBundleNonces checks the nonces of the bundle starting from the nonce of
+// each sender on chain.
+func (s *Simulator) validateBundleNonces(ctx context.Context, simulations []Simulation) error {
+	start := make(map[common.Address]uint64)
+	for _, sim := range simulations {
+		if _, ok := start[sim.From]; ok || sim.Nonce == nil {
+			continue
+		}
+
+		blk := ""
+		if sim.BlockNumber.Cmp(big.NewInt(0)) > 0 {
+			blk = "0x" + sim.BlockNumber.Text(16)
+		}
+
+		nonce, err := s.stateProvider().GetNonce(ctx, sim.From.Hex(), blk)
+		if err != nil {
+			return err
+		}
+		start[sim.From] = nonce
+	}
+
+	nonceErrs := checkBundleNonces(simulations, start)
+	if len(nonceErrs) == 0 {
+		return nil
+	}
+
+	errs := make([]error, len(nonceErrs))
+	for i := range nonceErrs {
+		errs[i] = nonceErrs[i]
+	}
+
+	return fmt.Errorf("%w: %w", ErrInvalidBundleNonces, errors.Join(errs...))
+}
+
+func checkBundleNonces(simulations []Simulation, start map[common.Address]uint64) []NonceError {
+	var (
+		nonceErrs []NonceError
+		next      = make(map[common.Address]uint64, len(start))
+	)
+
+	for addr, nonce := range start {
+		next[addr] = nonce
+	}
+
+	for i, sim := range simulations {
+		if sim.Nonce == nil {
+			continue
+		}
+
+		expected := next[sim.From]
+		if *sim.Nonce != expected {
+			nonceErrs = append(nonceErrs, NonceError{
+				TxIndex:  i,
+				Address:  sim.From,
+				Expected: expected,
+				Got:      *sim.Nonce,
+			})
+		}
+		next[sim.From] = expected + 1
+	}
+
+	return nonceErrs
+}
+
+// resolveNonce sets the Nonce of simulation to the nonce of the sender when it's
+// not provided, checking it against the fork when ValidateNonces is set. The
+// nonce in stateDB is preferred, as it may come from previous simulations.
+func (s *Simulator) resolveNonce(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (Simulation, error) {
+	if simulation.Nonce != nil && !s.ValidateNonces {
+		return simulation, nil
+	}
+
+	current, err := s.senderNonce(ctx, simulation, stateDB)
+	if err != nil {
+		return simulation, err
+	}
+
+	if simulation.Nonce == nil {
+		simulation.Nonce = &current
+		return simulation, nil
+	}
+
+	if *simulation.Nonce != current {
+		return simulation, fmt.Errorf("%w: %w", ErrInvalidNonce, NonceError{
+			Address:  simulation.From,
+			Expected: current,
+			Got:      *simulation.Nonce,
+		})
+	}
+
+	return simulation, nil
+}
+
+// resolveBundleNonces sets the nonce of the simulations of a bundle not providing
+// one, following the previous transactions of the same sender.
+func (s *Simulator) resolveBundleNonces(ctx context.Context, simulations []Simulation, stateDB *state.StateDB) error {
+	next := make(map[common.Address]uint64)
+	for i := range simulations {
+		sim := &simulations[i]
+		if sim.Nonce == nil {
+			nonce, ok := next[sim.From]
+			if !ok {
+				var err error
+				nonce, err = s.senderNonce(ctx, *sim, stateDB)
+				if err != nil {
+					return err
+				}
+			}
+			sim.Nonce = &nonce
+		}
+
+		next[sim.From] = *sim.Nonce + 1
+	}
+
+	return nil
+}
+
+// senderNonce returns the nonce of the sender of simulation from its state override,
+// from stateDB, or from the fork when it's unknown. Nodes not serving nonces leave
+// the one in stateDB.
+func (s *Simulator) senderNonce(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (uint64, error) {
+	if override, ok := simulation.StateOverrides[simulation.From]; ok && override.Nonce != nil {
+		return *override.Nonce, nil
+	}
+
+	nonce := stateDB.GetNonce(simulation.From)
+	if runtime.IsRemoteState(stateDB) {
+		// the remote state already holds the nonce of the fork
+		return nonce, stateDB.Error()
+	}
+	if nonce > 0 {
+		return nonce, nil
+	}
+
+	blk := ""
+	if simulation.BlockNumber.Cmp(big.NewInt(0)) > 0 {
+		blk = "0x" + simulation.BlockNumber.Text(16)
+	}
+
+	fetched, err := s.stateProvider().GetNonce(ctx, simulation.From.Hex(), blk)
+	if err != nil {
+		var rpcErr *rpc.ErrResponse
+		if errors.As(err, &rpcErr) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return fetched, nil
+}
+
+// incrementNonce sets the nonce of the sender to the one following the transaction,
+// as done before executing it on chain
+func incrementNonce(stateDB *state.StateDB, simulation Simulation) {
+	if simulation.Nonce != nil {
+		stateDB.SetNonce(simulation.From, *simulation.Nonce+1)
+	}
+}
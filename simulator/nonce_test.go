@@ -0,0 +1,170 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func nonce(n uint64) *uint64 {
+	return &n
+}
+
+func TestValidateBundleNonces(t *testing.T) {
+	var (
+		alice = common.HexToAddress("0x000000000000000000000000000000000000a11c")
+		bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+	)
+
+	tests := []struct {
+		name        string
+		simulations []Simulation
+		expected    []NonceError
+	}{
+		{
+			name: "sequential",
+			simulations: []Simulation{
+				{From: alice, Nonce: nonce(3)},
+				{From: bob, Nonce: nonce(7)},
+				{From: alice, Nonce: nonce(4)},
+				{From: alice},
+			},
+		},
+		{
+			name: "out of order",
+			simulations: []Simulation{
+				{From: alice, Nonce: nonce(5)},
+				{From: alice, Nonce: nonce(3)},
+			},
+			expected: []NonceError{
+				{TxIndex: 0, Address: alice, Expected: 3, Got: 5},
+				{TxIndex: 1, Address: alice, Expected: 4, Got: 3},
+			},
+		},
+		{
+			name: "gap",
+			simulations: []Simulation{
+				{From: bob, Nonce: nonce(1)},
+				{From: alice, Nonce: nonce(1)},
+				{From: bob, Nonce: nonce(3)},
+			},
+			expected: []NonceError{
+				{TxIndex: 2, Address: bob, Expected: 2, Got: 3},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateBundleNonces(tt.simulations); !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("nonce errors: %+v expected: %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSimulateBundleValidateNonces(t *testing.T) {
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getTransactionCount":
+			return "0x5", nil
+		case "eth_getBalance":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.ValidateNonces = true
+
+	simulations := []Simulation{
+		{
+			From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+			To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+			BlockNumber: big.NewInt(1),
+			Value:       big.NewInt(0),
+			Nonce:       nonce(6),
+		},
+	}
+
+	_, err = sim.SimulateBundle(context.Background(), simulations, nil)
+	if !errors.Is(err, ErrInvalidBundleNonces) {
+		t.Fatalf("expected ErrInvalidBundleNonces got: %v", err)
+	}
+
+	var nonceErr NonceError
+	if !errors.As(err, &nonceErr) || nonceErr.Expected != 5 || nonceErr.Got != 6 {
+		t.Fatalf("unexpected nonce error: %v", err)
+	}
+}
+
+func TestSimulateSenderNonce(t *testing.T) {
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance":
+			return "0x0", nil
+		case "eth_getTransactionCount":
+			return "0x5", nil
+		case "eth_getCode":
+			return hexutil.Bytes{byte(vm.STOP)}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(1),
+		GasLimit:    100000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Nonce != 6 {
+		t.Fatalf("nonce after simulation: %d expected 6", result.Nonce)
+	}
+
+	// the nonces of a bundle follow each other
+	results, err := sim.SimulateBundle(context.Background(), []Simulation{simulation, simulation}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].Nonce != 6 || results[1].Nonce != 7 {
+		t.Fatalf("nonces after bundle: %d, %d expected 6, 7", results[0].Nonce, results[1].Nonce)
+	}
+
+	sim.ValidateNonces = true
+	simulation.Nonce = nonce(3)
+	_, err = sim.Simulate(context.Background(), simulation, nil)
+
+	var nonceErr NonceError
+	if !errors.Is(err, ErrInvalidNonce) || !errors.As(err, &nonceErr) || nonceErr.Expected != 5 {
+		t.Fatalf("expected ErrInvalidNonce got: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/holiman/uint256"
+)
+
+// ErrInvalidStateOverride is returned when an account overrides both its State and its StateDiff.
+var ErrInvalidStateOverride = errors.New("invalid state override")
+
+// OverrideAccount replaces fields of an account before the simulation, as the state
+// override set of eth_call. Nil fields are left unchanged.
+type OverrideAccount struct {
+	Nonce *uint64
+	// Code replaces the code of the account, an empty non nil slice removes it
+	Code    []byte
+	Balance *big.Int
+	// State replaces the whole storage of the account, slots not listed read as zero
+	State map[common.Hash]common.Hash
+	// StateDiff replaces the listed slots, leaving the others untouched
+	StateDiff map[common.Hash]common.Hash
+}
+
+// applyStateOverrides sets the overrides in stateDB. The slots of an account whose
+// whole State is overridden read as zero when they aren't listed, the ones fetched
+// from the fork included.
+func applyStateOverrides(overrides map[common.Address]OverrideAccount, stateDB *state.StateDB) error {
+	for addr, override := range overrides {
+		if override.State != nil && override.StateDiff != nil {
+			return fmt.Errorf("%w: account %s has both State and StateDiff", ErrInvalidStateOverride, addr.Hex())
+		}
+
+		if override.Nonce != nil {
+			stateDB.SetNonce(addr, *override.Nonce)
+		}
+
+		if override.Code != nil {
+			stateDB.SetCode(addr, override.Code)
+		}
+
+		if override.Balance != nil {
+			stateDB.SetBalance(addr, uint256.MustFromBig(override.Balance), tracing.BalanceChangeUnspecified)
+		}
+
+		if override.State != nil {
+			// the slots already loaded aren't wiped by SetStorage
+			for _, slot := range runtime.FetchedSlots(stateDB, addr) {
+				if _, ok := override.State[slot]; !ok {
+					stateDB.SetState(addr, slot, common.Hash{})
+				}
+			}
+
+			stateDB.SetStorage(addr, override.State)
+		}
+
+		for slot, value := range override.StateDiff {
+			stateDB.SetState(addr, slot, value)
+		}
+	}
+
+	return nil
+}
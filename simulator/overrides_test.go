@@ -0,0 +1,162 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestSimulateStateOverrides(t *testing.T) {
+	// returns SLOAD(1), SLOAD(2) and SELFBALANCE
+	code := []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x02, byte(vm.SLOAD), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.SELFBALANCE), byte(vm.PUSH1), 0x40, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x60, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	forkValue := common.BigToHash(big.NewInt(0x99))
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return forkValue.Hex(), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		from  = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		to    = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		slot1 = common.BigToHash(big.NewInt(1))
+		five  = common.BigToHash(big.NewInt(5))
+	)
+
+	simulation := func(override OverrideAccount) Simulation {
+		override.Code = code
+		override.Balance = big.NewInt(7)
+
+		return Simulation{
+			From:        from,
+			To:          to,
+			BlockNumber: big.NewInt(1),
+			GasLimit:    300000,
+			GasPrice:    big.NewInt(0),
+			Value:       big.NewInt(0),
+			StateOverrides: map[common.Address]OverrideAccount{
+				to:   override,
+				from: {Nonce: nonce(9)},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		override OverrideAccount
+		slot2    common.Hash
+	}{
+		{
+			name:     "state replaces the storage",
+			override: OverrideAccount{State: map[common.Hash]common.Hash{slot1: five}},
+			slot2:    common.Hash{},
+		},
+		{
+			name:     "state diff keeps the storage",
+			override: OverrideAccount{StateDiff: map[common.Hash]common.Hash{slot1: five}},
+			slot2:    forkValue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := sim.Simulate(context.Background(), simulation(tt.override), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ret := result.ReturnedData
+			if len(ret) != 96 {
+				t.Fatalf("returned data: %x", ret)
+			}
+
+			if common.BytesToHash(ret[:32]) != five || common.BytesToHash(ret[32:64]) != tt.slot2 {
+				t.Fatalf("unexpected storage: %x", ret[:64])
+			}
+
+			if balance := new(big.Int).SetBytes(ret[64:]); balance.Int64() != 7 {
+				t.Fatalf("balance: %s", balance)
+			}
+
+			if result.Nonce != 10 {
+				t.Fatalf("sender nonce: %d expected 10", result.Nonce)
+			}
+		})
+	}
+
+	t.Run("state and state diff", func(t *testing.T) {
+		override := OverrideAccount{
+			State:     map[common.Hash]common.Hash{slot1: five},
+			StateDiff: map[common.Hash]common.Hash{slot1: five},
+		}
+
+		_, err := sim.Simulate(context.Background(), simulation(override), nil)
+		if !errors.Is(err, ErrInvalidStateOverride) {
+			t.Fatalf("expected ErrInvalidStateOverride got: %v", err)
+		}
+	})
+}
+
+func TestSimulateOverriddenSenderBalance(t *testing.T) {
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance":
+			// 1 ether, enough for any of the simulations
+			return "0xde0b6b3a7640000", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	simulation := Simulation{
+		From:        from,
+		To:          common.HexToAddress("0x00000000000000000000000000000000000000bb"),
+		Code:        []byte{byte(vm.STOP)},
+		BlockNumber: big.NewInt(1),
+		GasLimit:    100000,
+		GasPrice:    big.NewInt(1),
+		Value:       big.NewInt(0),
+		// less than the 121000 wei of the gas
+		StateOverrides: map[common.Address]OverrideAccount{from: {Balance: big.NewInt(1000)}},
+	}
+
+	_, err = sim.Simulate(context.Background(), simulation, nil)
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance got: %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package simulator
+
+import (
+	"context"
+	"math/big"
+	goruntime "runtime"
+	"sync"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/holiman/uint256"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// WithBundleWorkers sets the number of simulations SimulateBundleParallel runs at the
+// same time, by default GOMAXPROCS.
+func WithBundleWorkers(n int) func(*Simulator) {
+	return func(s *Simulator) {
+		s.bundleWorkers = n
+	}
+}
+
+// accessSet holds the accounts and storage slots accessed by a transaction.
+type accessSet struct {
+	// accounts whose balance, nonce or code were accessed
+	accounts map[common.Address]struct{}
+	slots    map[common.Address]map[common.Hash]struct{}
+}
+
+func newAccessSet() *accessSet {
+	return &accessSet{
+		accounts: make(map[common.Address]struct{}),
+		slots:    make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+func (a *accessSet) addAccount(addr common.Address) {
+	a.accounts[addr] = struct{}{}
+}
+
+func (a *accessSet) addSlot(addr common.Address, slot common.Hash) {
+	if a.slots[addr] == nil {
+		a.slots[addr] = make(map[common.Hash]struct{})
+	}
+	a.slots[addr][slot] = struct{}{}
+}
+
+// merge adds the accesses of other to a.
+func (a *accessSet) merge(other *accessSet) {
+	for addr := range other.accounts {
+		a.addAccount(addr)
+	}
+
+	for addr, slots := range other.slots {
+		for slot := range slots {
+			a.addSlot(addr, slot)
+		}
+	}
+}
+
+// overlaps reports whether a, holding writes, intersects the accesses of other. The
+// writes to an account conflict with any access to its storage as well.
+func (a *accessSet) overlaps(other *accessSet) bool {
+	for addr := range other.accounts {
+		if _, ok := a.accounts[addr]; ok {
+			return true
+		}
+	}
+
+	for addr, slots := range other.slots {
+		if _, ok := a.accounts[addr]; ok {
+			return true
+		}
+
+		for slot := range slots {
+			if _, ok := a.slots[addr][slot]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// accessTracer collects the accounts and slots read and written by a transaction.
+// Writes of reverted frames are kept, which only makes conflicts more likely.
+type accessTracer struct {
+	reads  *accessSet
+	writes *accessSet
+}
+
+func newAccessTracer() *accessTracer {
+	return &accessTracer{reads: newAccessSet(), writes: newAccessSet()}
+}
+
+// hooks returns the hooks of the tracer, calling the ones of tracer as well.
+func (t *accessTracer) hooks(tracer *tracing.Hooks) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	onEnter, onOpcode := hooks.OnEnter, hooks.OnOpcode
+	hooks.OnEnter = func(depth int, typ byte, from, to common.Address, input []byte, gas uint64, value *big.Int) {
+		t.onEnter(typ, from, to, value)
+		if onEnter != nil {
+			onEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+	hooks.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+		t.onOpcode(op, scope)
+		if onOpcode != nil {
+			onOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+	}
+
+	return hooks
+}
+
+func (t *accessTracer) onEnter(typ byte, from, to common.Address, value *big.Int) {
+	t.reads.addAccount(from)
+	t.reads.addAccount(to)
+
+	switch ourVm.OpCode(typ) {
+	case ourVm.CREATE, ourVm.CREATE2:
+		t.writes.addAccount(from)
+		t.writes.addAccount(to)
+	case ourVm.SELFDESTRUCT:
+		t.writes.addAccount(from)
+		t.writes.addAccount(to)
+	case ourVm.CALL:
+		if value != nil && value.Sign() > 0 {
+			t.writes.addAccount(from)
+			t.writes.addAccount(to)
+		}
+	}
+}
+
+func (t *accessTracer) onOpcode(opcode byte, scope tracing.OpContext) {
+	stack := scope.StackData()
+	if len(stack) == 0 {
+		if ourVm.OpCode(opcode) == ourVm.SELFBALANCE {
+			t.reads.addAccount(scope.Address())
+		}
+		return
+	}
+	top := stack[len(stack)-1]
+
+	switch ourVm.OpCode(opcode) {
+	case ourVm.SLOAD:
+		t.reads.addSlot(scope.Address(), common.Hash(top.Bytes32()))
+	case ourVm.SSTORE:
+		t.reads.addSlot(scope.Address(), common.Hash(top.Bytes32()))
+		t.writes.addSlot(scope.Address(), common.Hash(top.Bytes32()))
+	case ourVm.BALANCE, ourVm.EXTCODESIZE, ourVm.EXTCODECOPY, ourVm.EXTCODEHASH:
+		t.reads.addAccount(common.Address(top.Bytes20()))
+	case ourVm.SELFBALANCE:
+		t.reads.addAccount(scope.Address())
+	}
+}
+
+// speculation is the outcome of simulating a transaction of a bundle on its own.
+type speculation struct {
+	// sequential simulations aren't run on their own
+	sequential bool
+	result     *SimulationResult
+	err        error
+	state      *state.StateDB
+	tracer     *accessTracer
+}
+
+// SimulateBundleParallel simulates a bundle as SimulateBundle does, running the
+// transactions that don't depend on the previous ones in parallel. Every transaction
+// is first simulated on a copy of stateDB, recording the accounts and slots it reads
+// and writes. Then, in the order of the bundle, the transactions not touching the
+// writes of the previous ones are merged into stateDB, the others are simulated again
+// on it. The copies share the state fetched from the fork. Transactions run once, and
+// the ones with StateOverrides are always simulated in order. A nil stateDB is a new
+// remote state, as with Simulate.
+func (s *Simulator) SimulateBundleParallel(ctx context.Context, simulations []Simulation, stateDB *state.StateDB) ([]*SimulationResult, error) {
+	// don't modify the simulations of the caller
+	simulations = bundleTransactions(simulations)
+	err := s.pinLatestBlock(ctx, simulations)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.ValidateNonces {
+		err := s.validateBundleNonces(ctx, simulations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range simulations {
+		simulations[i], err = s.prepareSimulation(ctx, simulations[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stateDB, err = s.bundleState(ctx, simulations, stateDB)
+	if err != nil {
+		return nil, err
+	}
+	runtime.BindRemoteState(ctx, stateDB, maxRetries(simulations))
+
+	err = s.resolveBundleNonces(ctx, simulations, stateDB)
+	if err != nil {
+		return nil, err
+	}
+
+	base := stateDB.Copy()
+	speculations := s.speculate(ctx, simulations, base)
+
+	written := newAccessSet()
+	results := make([]*SimulationResult, len(simulations))
+	for i, spec := range speculations {
+		simulation := simulations[i]
+
+		if spec.sequential || spec.err != nil || written.overlaps(spec.tracer.reads) || written.overlaps(spec.tracer.writes) {
+			spec.tracer = newAccessTracer()
+			simulation.Tracer = spec.tracer.hooks(simulations[i].Tracer)
+
+			spec.result, err = s.simulate(ctx, simulation, stateDB)
+			if err != nil {
+				return nil, err
+			}
+			spec.tracer.writes.merge(s.implicitWrites(simulation, spec.result))
+		} else {
+			spec.tracer.writes.merge(s.implicitWrites(simulation, spec.result))
+			mergeSpeculation(stateDB, base, spec)
+		}
+
+		if err := s.revertPolicy.checkRevert(i, simulation, spec.result); err != nil {
+			return nil, err
+		}
+
+		written.merge(spec.tracer.writes)
+		results[i] = spec.result
+	}
+
+	return results, nil
+}
+
+// speculate simulates every simulation on its own copy of base, with at most
+// bundleWorkers at the same time.
+func (s *Simulator) speculate(ctx context.Context, simulations []Simulation, base *state.StateDB) []*speculation {
+	workers := s.bundleWorkers
+	if workers <= 0 {
+		workers = goruntime.GOMAXPROCS(0)
+	}
+
+	var (
+		wg           sync.WaitGroup
+		sem          = make(chan struct{}, workers)
+		speculations = make([]*speculation, len(simulations))
+	)
+
+	for i := range simulations {
+		spec := &speculation{tracer: newAccessTracer(), state: base.Copy()}
+		speculations[i] = spec
+
+		if len(simulations[i].StateOverrides) > 0 {
+			spec.sequential = true
+			continue
+		}
+
+		simulation := simulations[i]
+		simulation.Tracer = spec.tracer.hooks(simulation.Tracer)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			spec.result, spec.err = s.simulate(ctx, simulation, spec.state)
+		}()
+	}
+	wg.Wait()
+
+	return speculations
+}
+
+// implicitWrites are the accounts modified by a transaction outside of the EVM: the
+// sender paying the gas, the authorities of its delegations and the coinbase receiving
+// the fees.
+func (s *Simulator) implicitWrites(simulation Simulation, result *SimulationResult) *accessSet {
+	writes := newAccessSet()
+	writes.addAccount(simulation.From)
+	for _, delegation := range simulation.SetCodeDelegations {
+		writes.addAccount(delegation.Authority)
+	}
+
+	if result.PriorityFees != nil && result.PriorityFees.Sign() > 0 {
+		writes.addAccount(s.ConfigFromSimulation(simulation).Coinbase)
+	}
+
+	return writes
+}
+
+// mergeSpeculation applies to stateDB the changes of a transaction simulated on base,
+// it must not have touched the writes of the previous transactions of the bundle. The
+// balances are changed by their delta, the values fetched from the fork are shared by
+// both states.
+func mergeSpeculation(stateDB, base *state.StateDB, spec *speculation) {
+	for addr, slots := range spec.tracer.writes.slots {
+		for slot := range slots {
+			stateDB.SetState(addr, slot, spec.state.GetState(addr, slot))
+		}
+	}
+
+	for addr := range spec.tracer.writes.accounts {
+		after := spec.state.GetBalance(addr)
+		if before := base.GetBalance(addr); after.Cmp(before) > 0 {
+			stateDB.AddBalance(addr, new(uint256.Int).Sub(after, before), tracing.BalanceChangeUnspecified)
+		} else if after.Cmp(before) < 0 {
+			stateDB.SubBalance(addr, new(uint256.Int).Sub(before, after), tracing.BalanceChangeUnspecified)
+		}
+
+		if nonce := spec.state.GetNonce(addr); nonce != base.GetNonce(addr) {
+			stateDB.SetNonce(addr, nonce)
+		}
+
+		if spec.state.GetCodeHash(addr) != base.GetCodeHash(addr) {
+			stateDB.SetCode(addr, spec.state.GetCode(addr))
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestSimulateBundleParallel(t *testing.T) {
+	var (
+		alice    = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		bob      = common.HexToAddress("0x0000000000000000000000000000000000000002")
+		counterA = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		counterB = common.HexToAddress("0x0000000000000000000000000000000000000012")
+	)
+
+	// increments slot 0 and returns its new value
+	code := hexutil.Bytes{
+		byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD),
+		byte(vm.DUP1), byte(vm.PUSH0), byte(vm.SSTORE),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == counterA || addr == counterB {
+				return code, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getStorageAt":
+			return common.BigToHash(big.NewInt(10)), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithBundleWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// executions counts the executions of each transaction
+	executions := make([]atomic.Int32, 4)
+	increment := func(i int, from, counter common.Address) Simulation {
+		return Simulation{
+			From:        from,
+			To:          counter,
+			BlockNumber: big.NewInt(1),
+			GasLimit:    100000,
+			GasPrice:    big.NewInt(0),
+			Value:       big.NewInt(0),
+			Tracer: &tracing.Hooks{
+				OnEnter: func(depth int, _ byte, _, _ common.Address, _ []byte, _ uint64, _ *big.Int) {
+					if depth == 0 {
+						executions[i].Add(1)
+					}
+				},
+			},
+		}
+	}
+
+	simulations := []Simulation{
+		increment(0, alice, counterA),
+		increment(1, bob, counterB),
+		// depends on the first one
+		increment(2, bob, counterA),
+		// depends on the second one through the nonce of bob
+		increment(3, bob, counterB),
+	}
+
+	stateDB, err := sim.NewRemoteState(context.Background(), big.NewInt(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := sim.SimulateBundleParallel(context.Background(), simulations, stateDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []int64{11, 11, 12, 12} {
+		if value := new(big.Int).SetBytes(results[i].ReturnedData); value.Int64() != expected {
+			t.Fatalf("counter of %d: %s expected %d", i, value, expected)
+		}
+	}
+
+	for i, expected := range []int32{1, 1, 2, 2} {
+		if n := executions[i].Load(); n != expected {
+			t.Fatalf("transaction %d executed %d times expected %d", i, n, expected)
+		}
+	}
+
+	if value := stateDB.GetState(counterA, common.Hash{}).Big(); value.Int64() != 12 {
+		t.Fatalf("counter A: %s expected 12", value)
+	}
+
+	if value := stateDB.GetState(counterB, common.Hash{}).Big(); value.Int64() != 12 {
+		t.Fatalf("counter B: %s expected 12", value)
+	}
+
+	if nonce := stateDB.GetNonce(bob); nonce != 3 {
+		t.Fatalf("nonce of bob: %d expected 3", nonce)
+	}
+}
@@ -0,0 +1,60 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"slices"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// prefetchAccessList adds to the Prefetch of simulation the accounts and slots reported
+// by eth_createAccessList on the fork, together with the sender and the receiver.
+// Nodes not supporting the method leave the state to be fetched lazily.
+func (s *Simulator) prefetchAccessList(ctx context.Context, simulation Simulation) (Simulation, error) {
+	blk := ""
+	if simulation.BlockNumber.Cmp(big.NewInt(0)) > 0 {
+		blk = "0x" + simulation.BlockNumber.Text(16)
+	}
+
+	accessList, err := s.RPCClt.CreateAccessList(ctx, simulation.From.Hex(), simulation.To.Hex(), hexutil.Encode(simulation.Input), simulation.Value, blk)
+	if err != nil {
+		var rpcErr *rpc.ErrResponse
+		if errors.As(err, &rpcErr) {
+			return simulation, nil
+		}
+
+		return simulation, err
+	}
+
+	// don't modify the map of the caller
+	prefetch := make(map[common.Address][]common.Hash, len(simulation.Prefetch)+len(accessList)+2)
+	for addr, slots := range simulation.Prefetch {
+		prefetch[addr] = slices.Clone(slots)
+	}
+
+	for _, addr := range []common.Address{simulation.From, simulation.To} {
+		if _, ok := prefetch[addr]; !ok {
+			prefetch[addr] = nil
+		}
+	}
+
+	for _, tuple := range accessList {
+		for _, slot := range tuple.StorageKeys {
+			if !slices.Contains(prefetch[tuple.Address], slot) {
+				prefetch[tuple.Address] = append(prefetch[tuple.Address], slot)
+			}
+		}
+
+		if _, ok := prefetch[tuple.Address]; !ok {
+			prefetch[tuple.Address] = nil
+		}
+	}
+
+	simulation.Prefetch = prefetch
+
+	return simulation, nil
+}
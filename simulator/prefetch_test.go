@@ -0,0 +1,164 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestPrefetchAccessList(t *testing.T) {
+	var (
+		from  = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		to    = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		token = common.HexToAddress("0x0000000000000000000000000000000000000022")
+		slot1 = common.BigToHash(common.Big1)
+		slot2 = common.BigToHash(common.Big2)
+	)
+
+	simulation := Simulation{
+		From:        from,
+		To:          to,
+		BlockNumber: big.NewInt(1),
+		Value:       big.NewInt(0),
+		Prefetch:    map[common.Address][]common.Hash{token: {slot1}},
+	}
+
+	t.Run("merges the access list", func(t *testing.T) {
+		srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+			if method != "eth_createAccessList" {
+				return nil, errors.New("unexpected method " + method)
+			}
+
+			return map[string]interface{}{
+				"accessList": types.AccessList{
+					{Address: token, StorageKeys: []common.Hash{slot1, slot2}},
+				},
+				"gasUsed": "0x5208",
+			}, nil
+		})
+
+		sim, err := NewSimulator(rpc.NewClient(srv.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := sim.prefetchAccessList(context.Background(), simulation)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Prefetch) != 3 {
+			t.Fatalf("prefetch: %v", result.Prefetch)
+		}
+
+		if slots := result.Prefetch[token]; len(slots) != 2 || slots[0] != slot1 || slots[1] != slot2 {
+			t.Fatalf("token slots: %v", slots)
+		}
+
+		if _, ok := result.Prefetch[from]; !ok {
+			t.Fatal("sender not prefetched")
+		}
+
+		// the prefetch of the caller is left untouched
+		if len(simulation.Prefetch[token]) != 1 {
+			t.Fatalf("caller prefetch modified: %v", simulation.Prefetch)
+		}
+	})
+
+	t.Run("unsupported method", func(t *testing.T) {
+		srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+			return nil, errors.New("the method eth_createAccessList does not exist")
+		})
+
+		sim, err := NewSimulator(rpc.NewClient(srv.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := sim.prefetchAccessList(context.Background(), simulation)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Prefetch) != 1 {
+			t.Fatalf("prefetch: %v", result.Prefetch)
+		}
+	})
+}
+
+func TestSimulatePrefetchOnce(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		slot1    = common.BigToHash(common.Big1)
+		// returns SLOAD(1)
+		code = []byte{
+			byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.PUSH0), byte(vm.MSTORE),
+			byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+		}
+	)
+
+	node := newProofNode(t, contract, code, map[common.Hash]*big.Int{slot1: big.NewInt(42)}).serve(t)
+
+	// forwards the requests to the node, counting the batches
+	var batches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			batches.Add(1)
+		}
+
+		resp, err := http.Post(node.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(srv.Close)
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x00000000000000000000000000000000000000bb"),
+		To:          contract,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    100000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+		Prefetch:    map[common.Address][]common.Hash{contract: {slot1}},
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := new(big.Int).SetBytes(result.ReturnedData); value.Int64() != 42 {
+		t.Fatalf("slot 1: %s expected 42", value)
+	}
+
+	// only the recording execution prefetches
+	if got := batches.Load(); got != 1 {
+		t.Fatalf("batch requests: %d expected 1", got)
+	}
+}
@@ -0,0 +1,67 @@
+package simulator
+
+import (
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// BundleProfit is the payment of a bundle to the coinbase, what a builder gets for
+// including it.
+type BundleProfit struct {
+	// CoinbaseDiff is the change of balance of the coinbase, the priority fees plus
+	// the ether sent to it directly
+	CoinbaseDiff *big.Int
+	// PriorityFees are the fees paid to the coinbase for the gas used
+	PriorityFees *big.Int
+	GasUsed      uint64
+	// EffectiveGasPrice is CoinbaseDiff per unit of gas used, the price the bundle
+	// competes with in the block
+	EffectiveGasPrice *big.Int
+}
+
+// CoinbaseProfit adds up the payments to the coinbase of the results of SimulateBundle.
+func CoinbaseProfit(results []*SimulationResult) *BundleProfit {
+	profit := &BundleProfit{
+		CoinbaseDiff:      new(big.Int),
+		PriorityFees:      new(big.Int),
+		EffectiveGasPrice: new(big.Int),
+	}
+
+	for _, result := range results {
+		if result.CoinbaseDiff != nil {
+			profit.CoinbaseDiff.Add(profit.CoinbaseDiff, result.CoinbaseDiff)
+		}
+		if result.PriorityFees != nil {
+			profit.PriorityFees.Add(profit.PriorityFees, result.PriorityFees)
+		}
+		profit.GasUsed += result.GasUsed
+	}
+
+	if profit.GasUsed > 0 {
+		profit.EffectiveGasPrice.Div(profit.CoinbaseDiff, new(big.Int).SetUint64(profit.GasUsed))
+	}
+
+	return profit
+}
+
+// coinbaseDiff is the change of balance of coinbase made by the execution, computed
+// from its transfers so the balance fetched from the fork isn't counted.
+func coinbaseDiff(coinbase common.Address, result *runtime.ExecutionResult) *big.Int {
+	diff := new(big.Int)
+	if result.PriorityFees != nil {
+		diff.Set(result.PriorityFees)
+	}
+
+	for _, transfer := range result.ValueTransfers {
+		if transfer.To == coinbase {
+			diff.Add(diff, transfer.Value)
+		}
+		if transfer.From == coinbase {
+			diff.Sub(diff, transfer.Value)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,95 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestCoinbaseProfit(t *testing.T) {
+	var (
+		from     = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		coinbase = common.HexToAddress("0x00000000000000000000000000000000000000cb")
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == contract {
+				return hexutil.Bytes{byte(vm.STOP)}, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getBalance":
+			return "0xde0b6b3a7640000", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulations := []Simulation{
+		// pays a priority fee of 5 wei per gas
+		{
+			From:        from,
+			To:          contract,
+			BlockNumber: big.NewInt(1),
+			GasLimit:    100000,
+			GasPrice:    big.NewInt(15),
+			BaseFee:     big.NewInt(10),
+			Value:       big.NewInt(0),
+			Coinbase:    &coinbase,
+		},
+		// pays the coinbase directly
+		{
+			From:        from,
+			To:          coinbase,
+			BlockNumber: big.NewInt(1),
+			GasLimit:    100000,
+			GasPrice:    big.NewInt(10),
+			BaseFee:     big.NewInt(10),
+			Value:       big.NewInt(1000),
+			Coinbase:    &coinbase,
+		},
+	}
+
+	results, err := sim.SimulateBundle(context.Background(), simulations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profit := CoinbaseProfit(results)
+
+	fees := new(big.Int).Mul(big.NewInt(5), new(big.Int).SetUint64(results[0].GasUsed))
+	if profit.PriorityFees.Cmp(fees) != 0 {
+		t.Fatalf("priority fees: %s expected %s", profit.PriorityFees, fees)
+	}
+
+	diff := new(big.Int).Add(fees, big.NewInt(1000))
+	if profit.CoinbaseDiff.Cmp(diff) != 0 || results[1].CoinbaseDiff.Int64() != 1000 {
+		t.Fatalf("coinbase diff: %s expected %s", profit.CoinbaseDiff, diff)
+	}
+
+	gasUsed := results[0].GasUsed + results[1].GasUsed
+	if price := new(big.Int).Div(diff, new(big.Int).SetUint64(gasUsed)); profit.GasUsed != gasUsed || profit.EffectiveGasPrice.Cmp(price) != 0 {
+		t.Fatalf("gas used: %d effective gas price: %s", profit.GasUsed, profit.EffectiveGasPrice)
+	}
+}
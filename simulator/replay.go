@@ -0,0 +1,257 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+var (
+	// ErrTransactionPending is returned when replaying a transaction not included in a block yet.
+	ErrTransactionPending = errors.New("transaction pending")
+	// ErrContractCreation is returned when replaying a transaction deploying a contract,
+	// simulations always call an existing address.
+	ErrContractCreation = errors.New("contract creations can't be simulated")
+)
+
+// TxReplay is the result of simulating again a transaction included on chain.
+type TxReplay struct {
+	*SimulationResult
+	Transaction *rpc.Transaction
+	Receipt     *rpc.Receipt
+	// GasDifference is the gas used by the simulation minus the gas used on chain
+	GasDifference int64
+	// StatusMatches is set when the simulation and the receipt agree on the transaction
+	// succeeding or reverting
+	StatusMatches bool
+}
+
+// SimulateTxHash replays the transaction with the given hash on top of the state of
+// the block preceding its own, with the block context of its block, and compares the
+// outcome with its receipt. Transactions preceding it in its block aren't replayed,
+// the outcome may differ when it depends on them. Transactions halting, e.g. out of
+// gas, fail with a *SimulationError, as Simulate.
+func (s *Simulator) SimulateTxHash(ctx context.Context, hash common.Hash) (*TxReplay, error) {
+	tx, err := s.RPCClt.GetTransactionByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.BlockNumber == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionPending, hash.Hex())
+	}
+
+	if tx.To == nil {
+		return nil, fmt.Errorf("%w: %s", ErrContractCreation, hash.Hex())
+	}
+
+	receipt, err := s.RPCClt.GetTransactionReceipt(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	number := tx.BlockNumber.ToInt()
+	header, err := s.Cache.BlockHeader(ctx, s.RPCClt, "0x"+number.Text(16))
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", number, err)
+	}
+
+	err = s.detectChainConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	simulation, err := s.simulationFromTx(tx, receipt, header)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.Simulate(ctx, simulation, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxReplay{
+		SimulationResult: result,
+		Transaction:      tx,
+		Receipt:          receipt,
+		GasDifference:    int64(result.GasUsed) - int64(receipt.GasUsed),
+		StatusMatches:    result.Status == uint64(receipt.Status),
+	}, nil
+}
+
+// simulationFromTx returns the simulation of tx on top of the state of the block
+// preceding header, with the block context of header. Its gas limit is the one of tx
+// less its intrinsic gas.
+func (s *Simulator) simulationFromTx(tx *rpc.Transaction, receipt *rpc.Receipt, header *rpc.BlockHeader) (Simulation, error) {
+	number := header.Number.ToInt()
+	nonce := uint64(tx.Nonce)
+
+	// the access list of the transaction, even empty, is the one warming up the state
+	accessList := types.AccessList{}
+	if tx.AccessList != nil {
+		accessList = *tx.AccessList
+	}
+
+	simulation := Simulation{
+		From:        tx.From,
+		To:          *tx.To,
+		Input:       tx.Input,
+		Value:       new(big.Int),
+		GasPrice:    new(big.Int),
+		BlockNumber: new(big.Int).Sub(number, big.NewInt(1)),
+		Nonce:       &nonce,
+		TxType:      types.AccessListTxType,
+		AccessList:  accessList,
+	}
+
+	if tx.Value != nil {
+		simulation.Value = tx.Value.ToInt()
+	}
+
+	if receipt != nil && receipt.EffectiveGasPrice != nil {
+		simulation.GasPrice = receipt.EffectiveGasPrice.ToInt()
+	} else if tx.GasPrice != nil {
+		simulation.GasPrice = tx.GasPrice.ToInt()
+	}
+
+	// the block context is the one of the block of the transaction, while the state
+	// is the one of its parent
+	simulation.BlockOverrides = &BlockOverrides{Number: number}
+	simulation = applyBlockHeader(simulation, header)
+
+	var err error
+	simulation.GasLimit, err = s.executionGas(tx, simulation)
+	if err != nil {
+		return Simulation{}, err
+	}
+
+	return simulation, nil
+}
+
+// executionGas returns the gas tx had for its execution, its gas limit less the
+// intrinsic gas charged by the execution of simulation. It fails when the gas limit
+// doesn't cover the intrinsic gas, as a zero gas limit isn't a limit for simulations.
+// A transaction left without gas, as a plain transfer, is simulated without limit.
+func (s *Simulator) executionGas(tx *rpc.Transaction, simulation Simulation) (uint64, error) {
+	cfg := s.ConfigFromSimulation(simulation)
+	runtime.SetDefaults(cfg)
+
+	intrinsicGas, err := runtime.IntrinsicGas(tx.Input, simulation.AccessList, cfg.Rules())
+	if err != nil {
+		return 0, fmt.Errorf("transaction %s: %w", tx.Hash.Hex(), err)
+	}
+
+	if intrinsicGas > uint64(tx.Gas) {
+		return 0, fmt.Errorf("%w: transaction %s: have %d, want %d", core.ErrIntrinsicGas, tx.Hash.Hex(), uint64(tx.Gas), intrinsicGas)
+	}
+
+	return uint64(tx.Gas) - intrinsicGas, nil
+}
+
+// ReplayedTx is the outcome of a transaction replayed by ReplayBlock.
+type ReplayedTx struct {
+	Transaction *rpc.Transaction
+	Result      *SimulationResult
+	// Err is set when the transaction couldn't be simulated
+	Err error
+}
+
+// BlockReplay is the result of ReplayBlock.
+type BlockReplay struct {
+	Header       *rpc.BlockHeader
+	Transactions []ReplayedTx
+	// StateDB holds the changes made by the block, including the priority fees paid
+	// to the coinbase and the withdrawals
+	StateDB *state.StateDB
+}
+
+// ReplayBlock executes the transactions of a block in order on top of the state of
+// its parent, with the block context of the block. The priority fee of every
+// transaction is paid to the coinbase and the withdrawals are credited at the end.
+// Transactions failing to simulate don't stop the replay, unless the state can't be
+// fetched from the fork, but their effects are missing for the next ones. Blocks
+// deploying contracts fail with ErrContractCreation, the transactions following a
+// deployment could depend on it.
+func (s *Simulator) ReplayBlock(ctx context.Context, number *big.Int) (*BlockReplay, error) {
+	blk := "0x" + number.Text(16)
+	block, err := s.RPCClt.GetBlockWithTransactions(ctx, blk)
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %w", number, err)
+	}
+
+	// the transactions read the state of the parent block
+	stateDB, err := s.NewRemoteState(ctx, new(big.Int).Sub(number, common.Big1), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.detectChainConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	replay := &BlockReplay{Header: &block.BlockHeader}
+
+	for _, tx := range block.Transactions {
+		if tx.To == nil {
+			return nil, fmt.Errorf("%w: %s", ErrContractCreation, tx.Hash.Hex())
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		replayed := ReplayedTx{Transaction: tx}
+		simulation, err := s.simulationFromTx(tx, nil, &block.BlockHeader)
+		if err != nil {
+			return nil, err
+		}
+
+		simulation, err = s.prepareSimulation(ctx, simulation)
+		if err != nil {
+			return nil, err
+		}
+
+		// the access list of the transaction is used, a single execution is exact
+		result, err := s.simulate(ctx, simulation, stateDB)
+		if errors.Is(err, rpc.ErrRPCFetch) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		replayed.Result, replayed.Err = result, err
+		replay.Transactions = append(replay.Transactions, replayed)
+		if err != nil {
+			continue
+		}
+
+		// commit so the next transaction sees the changes as its original state
+		root, err := stateDB.Commit(0, false)
+		if err != nil {
+			return nil, fmt.Errorf("commit error: %s", err)
+		}
+
+		stateDB, err = state.New(root, stateDB.Database(), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, withdrawal := range block.Withdrawals {
+		// withdrawals are denominated in gwei
+		amount := new(uint256.Int).Mul(uint256.NewInt(withdrawal.Amount), uint256.NewInt(params.GWei))
+		stateDB.AddBalance(withdrawal.Address, amount, tracing.BalanceIncreaseWithdrawal)
+	}
+	replay.StateDB = stateDB
+
+	return replay, nil
+}
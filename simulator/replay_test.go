@@ -0,0 +1,323 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestSimulateTxHash(t *testing.T) {
+	// returns NUMBER
+	code := []byte{
+		byte(vm.NUMBER), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		hash     = common.HexToHash("0xabc1")
+		pending  = common.HexToHash("0xabc2")
+		creation = common.HexToHash("0xabc3")
+		to       = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		// blocks the code was fetched from
+		codeBlocks []string
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getTransactionByHash":
+			var h common.Hash
+			if err := json.Unmarshal(params[0], &h); err != nil {
+				return nil, err
+			}
+
+			tx := map[string]interface{}{
+				"hash":     h,
+				"type":     "0x2",
+				"from":     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+				"to":       to,
+				"input":    "0x",
+				"value":    "0x0",
+				"nonce":    "0x5",
+				"gas":      "0x7a120",
+				"gasPrice": "0x0",
+			}
+
+			switch h {
+			case hash:
+				tx["blockNumber"] = "0x64"
+			case creation:
+				tx["blockNumber"] = "0x64"
+				tx["to"] = nil
+			}
+
+			return tx, nil
+		case "eth_getTransactionReceipt":
+			return map[string]interface{}{
+				"transactionHash":   hash,
+				"blockNumber":       "0x64",
+				"status":            "0x1",
+				"gasUsed":           hexutil.Uint64(21000 + 15),
+				"effectiveGasPrice": "0x0",
+			}, nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{
+				"number":     "0x64",
+				"miner":      common.HexToAddress("0xc0"),
+				"timestamp":  "0x65f1c0d7",
+				"gasLimit":   "0x1c9c380",
+				"difficulty": "0x0",
+			}, nil
+		case "eth_getCode":
+			var blk string
+			if err := json.Unmarshal(params[1], &blk); err != nil {
+				return nil, err
+			}
+			codeBlocks = append(codeBlocks, blk)
+
+			return hexutil.Bytes(code), nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := sim.SimulateTxHash(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if number := new(big.Int).SetBytes(replay.ReturnedData); number.Int64() != 100 {
+		t.Fatalf("number: %s expected 100", number)
+	}
+
+	// the state is the one of the parent block
+	if len(codeBlocks) == 0 {
+		t.Fatal("code not fetched")
+	}
+	for _, blk := range codeBlocks {
+		if blk != "0x63" {
+			t.Fatalf("code fetched at: %v", codeBlocks)
+		}
+	}
+
+	if replay.GasDifference != 0 || !replay.StatusMatches || replay.Nonce != 6 {
+		t.Fatalf("gas used: %d difference: %d status matches: %t nonce: %d", replay.GasUsed, replay.GasDifference, replay.StatusMatches, replay.Nonce)
+	}
+
+	if _, err := sim.SimulateTxHash(context.Background(), pending); !errors.Is(err, ErrTransactionPending) {
+		t.Fatalf("expected ErrTransactionPending got: %v", err)
+	}
+
+	if _, err := sim.SimulateTxHash(context.Background(), creation); !errors.Is(err, ErrContractCreation) {
+		t.Fatalf("expected ErrContractCreation got: %v", err)
+	}
+}
+
+func TestSimulateTxHashOutOfGas(t *testing.T) {
+	// returns NUMBER, 15 gas
+	code := []byte{
+		byte(vm.NUMBER), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		hash = common.HexToHash("0xabc1")
+		// 10 gas left for the execution after the intrinsic gas
+		gas = hexutil.Uint64(21000 + 10)
+	)
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getTransactionByHash":
+			return map[string]interface{}{
+				"hash":        hash,
+				"blockNumber": "0x64",
+				"type":        "0x2",
+				"from":        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+				"to":          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+				"input":       "0x",
+				"value":       "0x0",
+				"nonce":       "0x5",
+				"gas":         gas,
+				"gasPrice":    "0x0",
+			}, nil
+		case "eth_getTransactionReceipt":
+			return map[string]interface{}{
+				"transactionHash":   hash,
+				"blockNumber":       "0x64",
+				"status":            "0x0",
+				"gasUsed":           hexutil.Uint64(21000 + 10),
+				"effectiveGasPrice": "0x0",
+			}, nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{
+				"number":     "0x64",
+				"miner":      common.HexToAddress("0xc0"),
+				"timestamp":  "0x65f1c0d7",
+				"gasLimit":   "0x1c9c380",
+				"difficulty": "0x0",
+			}, nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with the gas limit of the transaction as execution gas it would succeed
+	_, err = sim.SimulateTxHash(context.Background(), hash)
+	if kind := FailureOf(err); kind != FailureOutOfGas {
+		t.Fatalf("replay failed with %s: %v, want %s", kind, err, FailureOutOfGas)
+	}
+
+	// a gas limit below the intrinsic gas leaves no gas to execute with, not unlimited
+	gas = 21000 - 1
+	_, err = sim.SimulateTxHash(context.Background(), hash)
+	if !errors.Is(err, core.ErrIntrinsicGas) {
+		t.Fatalf("replay failed with %v, want %v", err, core.ErrIntrinsicGas)
+	}
+}
+
+func TestReplayBlock(t *testing.T) {
+	// increments slot 0 and returns the new value
+	code := []byte{
+		byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD),
+		byte(vm.DUP1), byte(vm.PUSH0), byte(vm.SSTORE),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		miner     = common.HexToAddress("0x00000000000000000000000000000000000000c0")
+		recipient = common.HexToAddress("0x00000000000000000000000000000000000000d0")
+		to        = common.HexToAddress("0x0000000000000000000000000000000000000011")
+	)
+
+	tx := func(hash string, nonce uint64, to *common.Address) map[string]interface{} {
+		return map[string]interface{}{
+			"hash":             common.HexToHash(hash),
+			"blockNumber":      "0x64",
+			"transactionIndex": hexutil.Uint64(nonce),
+			"type":             "0x0",
+			"from":             common.HexToAddress("0x0000000000000000000000000000000000000001"),
+			"to":               to,
+			"input":            "0x",
+			"value":            "0x0",
+			"nonce":            hexutil.Uint64(nonce),
+			"gas":              "0x7a120",
+			"gasPrice":         "0xa",
+		}
+	}
+
+	transactions := []interface{}{tx("0xa1", 0, &to), tx("0xa2", 1, &to)}
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{
+				"number":        "0x64",
+				"miner":         miner,
+				"timestamp":     "0x65f1c0d7",
+				"gasLimit":      "0x1c9c380",
+				"difficulty":    "0x0",
+				"baseFeePerGas": "0x7",
+				"transactions":  transactions,
+				"withdrawals": []interface{}{
+					map[string]interface{}{"index": "0x0", "validatorIndex": "0x1", "address": recipient, "amount": "0x2"},
+				},
+			}, nil
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == to {
+				return hexutil.Bytes(code), nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getBalance":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == common.HexToAddress("0x0000000000000000000000000000000000000001") {
+				return "0xde0b6b3a7640000", nil
+			}
+
+			return "0x0", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getStorageAt":
+			return common.Hash{}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := sim.ReplayBlock(context.Background(), big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replay.Transactions) != 2 {
+		t.Fatalf("transactions: %d expected 2", len(replay.Transactions))
+	}
+
+	var gasUsed uint64
+	for i, replayed := range replay.Transactions {
+		if replayed.Err != nil {
+			t.Fatalf("tx %d: %v", i, replayed.Err)
+		}
+
+		// the second call sees the storage written by the first one
+		if value := new(big.Int).SetBytes(replayed.Result.ReturnedData); value.Int64() != int64(i+1) {
+			t.Fatalf("tx %d returned: %s expected %d", i, value, i+1)
+		}
+		gasUsed += replayed.Result.GasUsed
+	}
+
+	if value := replay.StateDB.GetState(to, common.Hash{}); value.Big().Int64() != 2 {
+		t.Fatalf("slot 0: %s expected 2", value.Big())
+	}
+
+	// a tip of 3 wei per gas
+	if balance := replay.StateDB.GetBalance(miner); balance.Uint64() != gasUsed*3 {
+		t.Fatalf("coinbase balance: %s expected %d", balance, gasUsed*3)
+	}
+
+	// withdrawals are in gwei
+	if balance := replay.StateDB.GetBalance(recipient); balance.Uint64() != 2*params.GWei {
+		t.Fatalf("withdrawal balance: %s expected %d", balance, uint64(2*params.GWei))
+	}
+
+	// the calls following a deployment could depend on it
+	transactions = []interface{}{tx("0xa1", 0, &to), tx("0xa2", 1, nil), tx("0xa3", 2, &to)}
+	if _, err := sim.ReplayBlock(context.Background(), big.NewInt(100)); !errors.Is(err, ErrContractCreation) {
+		t.Fatalf("expected ErrContractCreation got: %v", err)
+	}
+}
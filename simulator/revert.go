@@ -0,0 +1,125 @@
+package simulator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	corevm "github.com/ethereum/go-ethereum/core/vm"
+)
+
+// RevertKind is the shape of the data returned by a revert.
+type RevertKind int
+
+const (
+	// RevertKindEmpty is a revert without data, like require without message
+	RevertKindEmpty RevertKind = iota
+	// RevertKindError is an Error(string) revert
+	RevertKindError
+	// RevertKindPanic is a Panic(uint256) revert raised by the compiler checks
+	RevertKindPanic
+	// RevertKindCustom is a custom error, or data without selector
+	RevertKindCustom
+)
+
+func (k RevertKind) String() string {
+	switch k {
+	case RevertKindEmpty:
+		return "empty"
+	case RevertKindError:
+		return "error"
+	case RevertKindPanic:
+		return "panic"
+	default:
+		return "custom"
+	}
+}
+
+var (
+	// Error(string) and Panic(uint256) selectors
+	errorSelector = hexutil.MustDecode("0x08c379a0")
+	panicSelector = hexutil.MustDecode("0x4e487b71")
+)
+
+// RevertInfo is the decoded data of a revert.
+type RevertInfo struct {
+	Kind RevertKind
+	// Data is the raw data returned by the revert
+	Data []byte
+	// Selector of the error, empty when the data is shorter than a selector
+	Selector []byte
+	// Reason is the message of Error(string), or the meaning of the panic code
+	Reason string
+	// PanicCode is the code of a Panic(uint256)
+	PanicCode *big.Int
+}
+
+// DecodeRevert decodes the data returned by a revert as Error(string), Panic(uint256)
+// or a custom error. Data not matching the ABI of Error or Panic is reported as custom.
+func DecodeRevert(data []byte) *RevertInfo {
+	info := &RevertInfo{Kind: RevertKindCustom, Data: data}
+	if len(data) == 0 {
+		info.Kind = RevertKindEmpty
+		return info
+	}
+
+	if len(data) < 4 {
+		return info
+	}
+	info.Selector = data[:4]
+
+	reason, err := abi.UnpackRevert(data)
+	switch {
+	case err != nil:
+	case bytes.Equal(info.Selector, errorSelector):
+		info.Kind = RevertKindError
+		info.Reason = reason
+	case bytes.Equal(info.Selector, panicSelector):
+		info.Kind = RevertKindPanic
+		info.Reason = reason
+		info.PanicCode = new(big.Int).SetBytes(data[4:36])
+	}
+
+	return info
+}
+
+func (r *RevertInfo) String() string {
+	switch r.Kind {
+	case RevertKindEmpty:
+		return "reverted without data"
+	case RevertKindError:
+		return r.Reason
+	case RevertKindPanic:
+		return fmt.Sprintf("panic 0x%x: %s", r.PanicCode, r.Reason)
+	default:
+		return "custom error " + hexutil.Encode(r.Data)
+	}
+}
+
+// RevertError is returned by SimulationResult.Err for reverted simulations, it matches
+// vm.ErrExecutionReverted with errors.Is.
+type RevertError struct {
+	Info *RevertInfo
+}
+
+func (e *RevertError) Error() string {
+	return fmt.Sprintf("%s: %s", corevm.ErrExecutionReverted, e.Info)
+}
+
+func (e *RevertError) Unwrap() error {
+	return corevm.ErrExecutionReverted
+}
+
+// revertInfo returns the decoded revert of err, nil when err isn't a revert
+func revertInfo(err error) *RevertInfo {
+	var revertErr *runtime.RevertError
+	if !errors.As(err, &revertErr) {
+		return nil
+	}
+
+	return DecodeRevert(revertErr.Data)
+}
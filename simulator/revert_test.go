@@ -0,0 +1,170 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	corevm "github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Error("nope")
+var errorNope = hexutil.MustDecode("0x08c379a0" +
+	"0000000000000000000000000000000000000000000000000000000000000020" +
+	"0000000000000000000000000000000000000000000000000000000000000004" +
+	"6e6f706500000000000000000000000000000000000000000000000000000000")
+
+func TestDecodeRevert(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		kind   RevertKind
+		reason string
+	}{
+		{name: "empty", kind: RevertKindEmpty},
+		{name: "error", data: errorNope, kind: RevertKindError, reason: "nope"},
+		{
+			name:   "panic",
+			data:   hexutil.MustDecode("0x4e487b710000000000000000000000000000000000000000000000000000000000000011"),
+			kind:   RevertKindPanic,
+			reason: "arithmetic underflow or overflow",
+		},
+		{name: "custom", data: hexutil.MustDecode("0xfb8f41b2"), kind: RevertKindCustom},
+		{name: "malformed error", data: hexutil.MustDecode("0x08c379a0"), kind: RevertKindCustom},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := DecodeRevert(tt.data)
+			if info.Kind != tt.kind || info.Reason != tt.reason {
+				t.Fatalf("kind: %s reason: %q expected kind: %s reason: %q", info.Kind, info.Reason, tt.kind, tt.reason)
+			}
+
+			if tt.kind == RevertKindPanic && info.PanicCode.Int64() != 0x11 {
+				t.Fatalf("panic code: %s", info.PanicCode)
+			}
+		})
+	}
+}
+
+func TestSimulateRevertReason(t *testing.T) {
+	// CODECOPY the revert data appended to the code and revert with it
+	code := []byte{
+		byte(vm.PUSH1), byte(len(errorNope)), byte(vm.PUSH1), 0x0c, byte(vm.PUSH0), byte(vm.CODECOPY),
+		byte(vm.PUSH1), byte(len(errorNope)), byte(vm.PUSH0), byte(vm.REVERT),
+		byte(vm.INVALID), byte(vm.INVALID),
+	}
+	code = append(code, errorNope...)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Status != types.ReceiptStatusFailed || !bytes.Equal(result.ReturnedData, errorNope) || result.GasUsed <= 21000 {
+		t.Fatalf("status: %d returned data: %x gas used: %d", result.Status, result.ReturnedData, result.GasUsed)
+	}
+
+	if result.Revert == nil || result.Revert.Kind != RevertKindError || result.Revert.Reason != "nope" {
+		t.Fatalf("unexpected revert: %+v", result.Revert)
+	}
+
+	var revertErr *RevertError
+	if err := result.Err(); !errors.Is(err, corevm.ErrExecutionReverted) || !errors.As(err, &revertErr) {
+		t.Fatalf("expected revert error got: %v", err)
+	}
+}
+
+func TestSimulateRevertAfterCall(t *testing.T) {
+	callee := common.HexToAddress("0x0000000000000000000000000000000000000022")
+
+	// calls callee and reverts with the data it returned
+	code := []byte{
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH20),
+	}
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.REVERT))
+
+	// returns 42
+	calleeCode := []byte{
+		byte(vm.PUSH1), 0x2a, byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == callee {
+				return hexutil.Bytes(calleeCode), nil
+			}
+
+			return hexutil.Bytes(code), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the code of callee, fetched during the first execution, is lost by the revert
+	// but still used by the second one
+	if result.Status != types.ReceiptStatusFailed || new(big.Int).SetBytes(result.ReturnedData).Int64() != 42 {
+		t.Fatalf("status: %d returned data: %x", result.Status, result.ReturnedData)
+	}
+}
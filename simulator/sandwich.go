@@ -0,0 +1,210 @@
+package simulator
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SandwichSearcher is the sender used for the frontrun and backrun transactions
+// of a sandwich estimation.
+var SandwichSearcher = common.HexToAddress("0x000000000000000000000000000000000000a11e")
+
+// swap(address,address,uint256) selector, the interface expected from the pool
+var swapSelector = crypto.Keccak256([]byte("swap(address,address,uint256)"))[:4]
+
+// maximum number of doublings looking for an upper bound of the frontrun amount
+const maxSandwichDoublings = 128
+
+type SandwichProfitEstimate struct {
+	OptimalFrontrunAmount *big.Int
+	GrossProfit           *big.Int
+	GasCost               *big.Int
+	NetProfit             *big.Int
+}
+
+// EstimateSandwichProfit looks for the frontrun amount maximising the profit of
+// sandwiching victimSim on pool. The pool is expected to expose
+// swap(address tokenIn, address tokenOut, uint256 amountIn) returning the amount out.
+// Each candidate is simulated as a bundle of buy, victim and sell transactions on
+// a copy of stateDB, the gross profit is denominated in tokenIn and the gas cost
+// uses the gas price of the victim. A zero estimate is returned when no amount is profitable.
+func EstimateSandwichProfit(
+	ctx context.Context,
+	s *Simulator,
+	victimSim Simulation,
+	pool common.Address,
+	tokenIn, tokenOut common.Address,
+	stateDB *state.StateDB,
+) (*SandwichProfitEstimate, error) {
+	estimates := make(map[string]*SandwichProfitEstimate)
+	estimate := func(amount *big.Int) (*SandwichProfitEstimate, error) {
+		if e, ok := estimates[amount.String()]; ok {
+			return e, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		e, err := s.simulateSandwich(ctx, victimSim, pool, tokenIn, tokenOut, amount, stateDB.Copy())
+		if err != nil {
+			return nil, err
+		}
+		estimates[amount.String()] = e
+
+		return e, nil
+	}
+
+	// find an upper bound doubling the amount while the profit keeps growing
+	hi := big.NewInt(1)
+	for i := 0; i < maxSandwichDoublings; i++ {
+		current, err := estimate(hi)
+		if err != nil {
+			return nil, err
+		}
+
+		next, err := estimate(new(big.Int).Lsh(hi, 1))
+		if err != nil {
+			return nil, err
+		}
+
+		if next.NetProfit.Cmp(current.NetProfit) < 0 {
+			break
+		}
+		hi.Lsh(hi, 1)
+	}
+
+	// binary search over the slope of the profit in [hi/2, 2*hi]
+	lo := new(big.Int).Rsh(hi, 1)
+	hi = new(big.Int).Lsh(hi, 1)
+	for new(big.Int).Sub(hi, lo).Cmp(common.Big1) > 0 {
+		mid := new(big.Int).Add(lo, hi)
+		mid.Rsh(mid, 1)
+
+		current, err := estimate(mid)
+		if err != nil {
+			return nil, err
+		}
+
+		next, err := estimate(new(big.Int).Add(mid, common.Big1))
+		if err != nil {
+			return nil, err
+		}
+
+		if next.NetProfit.Cmp(current.NetProfit) > 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	best, err := estimate(hi)
+	if err != nil {
+		return nil, err
+	}
+
+	if lo.Sign() > 0 {
+		candidate, err := estimate(lo)
+		if err != nil {
+			return nil, err
+		}
+
+		if candidate.NetProfit.Cmp(best.NetProfit) > 0 {
+			best = candidate
+		}
+	}
+
+	if best.NetProfit.Sign() <= 0 {
+		return &SandwichProfitEstimate{
+			OptimalFrontrunAmount: new(big.Int),
+			GrossProfit:           new(big.Int),
+			GasCost:               new(big.Int),
+			NetProfit:             new(big.Int),
+		}, nil
+	}
+
+	return best, nil
+}
+
+// simulateSandwich simulates the bundle buy, victim, sell for the given frontrun amount
+func (s *Simulator) simulateSandwich(
+	ctx context.Context,
+	victimSim Simulation,
+	pool common.Address,
+	tokenIn, tokenOut common.Address,
+	amount *big.Int,
+	stateDB *state.StateDB,
+) (*SandwichProfitEstimate, error) {
+	buy := sandwichLeg(victimSim, pool, tokenIn, tokenOut, amount)
+
+	// the sell amount depends on the result of the buy, so the bundle is simulated
+	// first to discover it and then with the real sell amount
+	results, err := s.SimulateBundle(ctx, []Simulation{buy, victimSim}, stateDB.Copy())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		bought   = new(big.Int)
+		gasUsed  = new(big.Int).SetUint64(results[0].GasUsed)
+		received = new(big.Int)
+	)
+
+	// the returned data of a reverted swap is the revert data
+	if results[0].Status == types.ReceiptStatusSuccessful {
+		bought.SetBytes(results[0].ReturnedData)
+	}
+
+	// with nothing bought there's nothing to sell back
+	if bought.Sign() > 0 {
+		sell := sandwichLeg(victimSim, pool, tokenOut, tokenIn, bought)
+		results, err = s.SimulateBundle(ctx, []Simulation{buy, victimSim, sell}, stateDB)
+		if err != nil {
+			return nil, err
+		}
+
+		gasUsed.SetUint64(results[0].GasUsed + results[2].GasUsed)
+		if results[2].Status == types.ReceiptStatusSuccessful {
+			received.SetBytes(results[2].ReturnedData)
+		}
+	}
+
+	gasPrice := new(big.Int)
+	if victimSim.GasPrice != nil {
+		gasPrice.Set(victimSim.GasPrice)
+	}
+
+	gasCost := new(big.Int).Mul(gasUsed, gasPrice)
+	grossProfit := new(big.Int).Sub(received, amount)
+
+	return &SandwichProfitEstimate{
+		OptimalFrontrunAmount: new(big.Int).Set(amount),
+		GrossProfit:           grossProfit,
+		GasCost:               gasCost,
+		NetProfit:             new(big.Int).Sub(grossProfit, gasCost),
+	}, nil
+}
+
+// sandwichLeg builds the swap of amount from tokenIn to tokenOut sent by the searcher
+func sandwichLeg(victimSim Simulation, pool, tokenIn, tokenOut common.Address, amount *big.Int) Simulation {
+	input := make([]byte, 0, 4+3*32)
+	input = append(input, swapSelector...)
+	input = append(input, common.LeftPadBytes(tokenIn.Bytes(), 32)...)
+	input = append(input, common.LeftPadBytes(tokenOut.Bytes(), 32)...)
+	input = append(input, common.LeftPadBytes(amount.Bytes(), 32)...)
+
+	return Simulation{
+		From:        SandwichSearcher,
+		To:          pool,
+		BlockNumber: victimSim.BlockNumber,
+		GasLimit:    victimSim.GasLimit,
+		GasPrice:    victimSim.GasPrice,
+		Value:       big.NewInt(0),
+		Input:       input,
+	}
+}
@@ -0,0 +1,100 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// constant product pool: swap(tokenIn, tokenOut, amountIn) with the reserve of
+// each token stored in the slot with the token address
+var poolCode = []byte{
+	byte(vm.PUSH1), 0x44, byte(vm.CALLDATALOAD), // amountIn
+	byte(vm.PUSH1), 0x04, byte(vm.CALLDATALOAD), byte(vm.SLOAD), // reserveIn
+	byte(vm.PUSH1), 0x24, byte(vm.CALLDATALOAD), byte(vm.SLOAD), // reserveOut
+	// out = amountIn * reserveOut / (reserveIn + amountIn)
+	byte(vm.DUP3), byte(vm.DUP2), byte(vm.MUL),
+	byte(vm.DUP4), byte(vm.DUP4), byte(vm.ADD),
+	byte(vm.SWAP1), byte(vm.DIV),
+	// reserveOut -= out
+	byte(vm.DUP1), byte(vm.DUP3), byte(vm.SUB),
+	byte(vm.PUSH1), 0x24, byte(vm.CALLDATALOAD), byte(vm.SSTORE),
+	// reserveIn += amountIn
+	byte(vm.DUP4), byte(vm.DUP4), byte(vm.ADD),
+	byte(vm.PUSH1), 0x04, byte(vm.CALLDATALOAD), byte(vm.SSTORE),
+	// return out
+	byte(vm.PUSH0), byte(vm.MSTORE),
+	byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+}
+
+func TestEstimateSandwichProfit(t *testing.T) {
+	var (
+		pool     = common.HexToAddress("0x0000000000000000000000000000000000000b00")
+		tokenIn  = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+		tokenOut = common.HexToAddress("0x00000000000000000000000000000000000000a2")
+		reserve  = hexutil.EncodeBig(big.NewInt(1_000_000_000))
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Encode(poolCode), nil
+		case "eth_getStorageAt":
+			return common.HexToHash(reserve).Hex(), nil
+		default:
+			return nil, errors.New("unexpected method " + method)
+		}
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	victim := sandwichLeg(Simulation{
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+	}, pool, tokenIn, tokenOut, big.NewInt(100_000_000))
+	victim.From = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+
+	stateDB, err := sim.NewRemoteState(context.Background(), victim.BlockNumber, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimate, err := EstimateSandwichProfit(context.Background(), sim, victim, pool, tokenIn, tokenOut, stateDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if estimate.OptimalFrontrunAmount.Sign() <= 0 {
+		t.Fatal("expected a non zero frontrun amount")
+	}
+
+	if estimate.NetProfit.Sign() <= 0 {
+		t.Fatalf("net profit: %s expected positive", estimate.NetProfit)
+	}
+
+	// the estimate must be better than its neighbours
+	for _, delta := range []int64{-1_000_000, 1_000_000} {
+		amount := new(big.Int).Add(estimate.OptimalFrontrunAmount, big.NewInt(delta))
+		neighbour, err := sim.simulateSandwich(context.Background(), victim, pool, tokenIn, tokenOut, amount, stateDB.Copy())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if neighbour.NetProfit.Cmp(estimate.NetProfit) > 0 {
+			t.Fatalf("frontrun %s more profitable than optimal %s", amount, estimate.OptimalFrontrunAmount)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// transferTopic is the topic of the ERC-20 and ERC-721 Transfer(address,address,uint256) event
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// GasPriceOutcome is the behaviour of a simulation at a given gas price.
+type GasPriceOutcome struct {
+	GasPrice     *big.Int
+	ReturnedData []byte
+	Reverted     bool
+	GasUsed      uint64
+	// Transfers are the Transfer events emitted by the simulation
+	Transfers []*types.Log
+}
+
+// GasSensitivity reports how the behaviour of a simulation changes with the gas price.
+type GasSensitivity struct {
+	MarketGasPrice *big.Int
+	// Outcomes at zero, market and twice the market gas price
+	Outcomes []GasPriceOutcome
+	// GasPriceDependent is true when the return value, the revert status or the
+	// token transfers differ between the outcomes
+	GasPriceDependent bool
+}
+
+// GasSensitivityReport simulates sim at zero, the current market and twice the market gas
+// price, each run on a copy of stateDB, a new remote state when nil, and compares the outcomes. Contracts using
+// GASPRICE or GAS in their logic usually behave differently between the runs. EIP-1559
+// simulations run with both their fee cap and priority fee at the gas price, so they
+// pay it as legacy ones do.
+func (s *Simulator) GasSensitivityReport(ctx context.Context, sim Simulation, stateDB *state.StateDB) (*GasSensitivity, error) {
+	market, err := s.RPCClt.GasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stateDB, err = s.stateOrRemote(ctx, &sim, stateDB)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamic := sim.MaxFeePerGas != nil || sim.MaxPriorityFeePerGas != nil
+	report := &GasSensitivity{MarketGasPrice: market}
+	for _, gasPrice := range []*big.Int{new(big.Int), market, new(big.Int).Lsh(market, 1)} {
+		sim.GasPrice = gasPrice
+		if dynamic {
+			sim.MaxFeePerGas, sim.MaxPriorityFeePerGas = gasPrice, gasPrice
+		}
+
+		outcome := GasPriceOutcome{GasPrice: gasPrice}
+		result, err := s.Simulate(ctx, sim, stateDB.Copy())
+		switch {
+		case err != nil:
+			return nil, err
+		case result.Status == types.ReceiptStatusFailed:
+			outcome.Reverted = true
+		default:
+			outcome.ReturnedData = result.ReturnedData
+			outcome.GasUsed = result.GasUsed
+			outcome.Transfers = transferLogs(result.Events)
+		}
+
+		report.Outcomes = append(report.Outcomes, outcome)
+	}
+
+	for _, outcome := range report.Outcomes[1:] {
+		if !sameBehaviour(report.Outcomes[0], outcome) {
+			report.GasPriceDependent = true
+			break
+		}
+	}
+
+	return report, nil
+}
+
+func transferLogs(logs []*types.Log) []*types.Log {
+	var transfers []*types.Log
+	for _, l := range logs {
+		if len(l.Topics) > 0 && l.Topics[0] == transferTopic {
+			transfers = append(transfers, l)
+		}
+	}
+
+	return transfers
+}
+
+// sameBehaviour compares the observable behaviour of two outcomes, the gas used is left out
+func sameBehaviour(a, b GasPriceOutcome) bool {
+	if a.Reverted != b.Reverted || !bytes.Equal(a.ReturnedData, b.ReturnedData) || len(a.Transfers) != len(b.Transfers) {
+		return false
+	}
+
+	for i := range a.Transfers {
+		x, y := a.Transfers[i], b.Transfers[i]
+		if x.Address != y.Address || len(x.Topics) != len(y.Topics) || !bytes.Equal(x.Data, y.Data) {
+			return false
+		}
+
+		for j := range x.Topics {
+			if x.Topics[j] != y.Topics[j] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
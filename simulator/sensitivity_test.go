@@ -0,0 +1,165 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestGasSensitivityReport(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      []byte
+		dependent bool
+	}{
+		{
+			name: "returns gas price",
+			code: []byte{
+				byte(vm.GASPRICE),
+				byte(vm.PUSH0), byte(vm.MSTORE),
+				byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+			},
+			dependent: true,
+		},
+		{
+			name: "reverts with non zero gas price",
+			code: []byte{
+				byte(vm.GASPRICE), byte(vm.PUSH1), 0x06, byte(vm.JUMPI),
+				byte(vm.STOP), byte(vm.STOP),
+				byte(vm.JUMPDEST), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.REVERT),
+			},
+			dependent: true,
+		},
+		{
+			name: "returns constant",
+			code: []byte{
+				byte(vm.PUSH1), 0x2a,
+				byte(vm.PUSH0), byte(vm.MSTORE),
+				byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+			},
+		},
+	}
+
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_gasPrice":
+			return "0x3b9aca00", nil
+		case "eth_getBalance":
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == sender {
+				// the sender pays the gas fees
+				return "0xde0b6b3a7640000", nil
+			}
+			return "0x0", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			simulation := Simulation{
+				From:        sender,
+				To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+				Code:        tt.code,
+				BlockNumber: big.NewInt(1),
+				GasLimit:    300000,
+				Value:       big.NewInt(0),
+			}
+
+			report, err := sim.GasSensitivityReport(context.Background(), simulation, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.MarketGasPrice.Cmp(big.NewInt(1e9)) != 0 {
+				t.Fatalf("market gas price: %s", report.MarketGasPrice)
+			}
+
+			if len(report.Outcomes) != 3 {
+				t.Fatalf("outcomes: %d expected 3", len(report.Outcomes))
+			}
+
+			if report.GasPriceDependent != tt.dependent {
+				t.Fatalf("gas price dependent: %t expected: %t", report.GasPriceDependent, tt.dependent)
+			}
+		})
+	}
+}
+
+func TestGasSensitivityReportDynamicFees(t *testing.T) {
+	sender := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_gasPrice":
+			return "0x3b9aca00", nil
+		case "eth_getBalance":
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == sender {
+				// the sender pays the gas fees
+				return "0xde0b6b3a7640000", nil
+			}
+			return "0x0", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// returns GASPRICE
+	simulation := Simulation{
+		From: sender,
+		To:   common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		Code: []byte{
+			byte(vm.GASPRICE),
+			byte(vm.PUSH0), byte(vm.MSTORE),
+			byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+		},
+		BlockNumber:          big.NewInt(1),
+		GasLimit:             300000,
+		Value:                big.NewInt(0),
+		MaxFeePerGas:         big.NewInt(3e9),
+		MaxPriorityFeePerGas: big.NewInt(1e9),
+	}
+
+	report, err := sim.GasSensitivityReport(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, outcome := range report.Outcomes {
+		if price := new(big.Int).SetBytes(outcome.ReturnedData); price.Cmp(outcome.GasPrice) != 0 {
+			t.Fatalf("gas price %s run at %s", outcome.GasPrice, price)
+		}
+	}
+
+	if !report.GasPriceDependent {
+		t.Fatal("EIP-1559 simulation returning GASPRICE expected gas price dependent")
+	}
+}
@@ -1,224 +1,804 @@
 package simulator
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
-	"strings"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/Gealber/evm-simulator/logging"
+	"github.com/Gealber/evm-simulator/metrics"
 	"github.com/Gealber/evm-simulator/rpc"
 	"github.com/Gealber/evm-simulator/vm/runtime"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/tracing"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	ourVm "github.com/Gealber/evm-simulator/vm"
 )
 
+var simLogger = logging.Logger(logging.Simulator)
+
+var (
+	ErrInsufficientBalance = errors.New("insuficient balance to proceed with simulation")
+	ErrSimulationTimeout   = fmt.Errorf("simulation timeout: %w", context.DeadlineExceeded)
+)
+
 type Simulation struct {
 	From        common.Address
 	To          common.Address
 	BlockNumber *big.Int
 	GasLimit    uint64
 	GasPrice    *big.Int
-	Value       *big.Int
-	Input       []byte
-	Code        []byte
+	// MaxFeePerGas and MaxPriorityFeePerGas make the simulation an EIP-1559 one, paying
+	// min(MaxFeePerGas, BaseFee + MaxPriorityFeePerGas) per gas instead of GasPrice.
+	// The sender is charged for the gas used, the base fee is burnt and the priority
+	// fee is credited to the coinbase. Simulations without prices don't pay fees.
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+	Value                *big.Int
+	Input                []byte
+	Code                 []byte
+	// Coinbase and Difficulty are exposed to the COINBASE and DIFFICULTY opcodes,
+	// zero values are used when not provided
+	Coinbase   *common.Address
+	Difficulty *big.Int
+	// Timestamp and BaseFee are exposed to the TIMESTAMP and BASEFEE opcodes,
+	// the runtime defaults are used when not provided
+	Timestamp uint64
+	BaseFee   *big.Int
+	// BlockGasLimit is exposed to the GASLIMIT opcode, GasLimit is used when zero
+	BlockGasLimit uint64
+	// Random and BlobBaseFee are exposed to the PREVRANDAO and BLOBBASEFEE opcodes.
+	// Block context left unset is filled from the header of BlockNumber when the
+	// fork can serve it.
+	Random      *common.Hash
+	BlobBaseFee *big.Int
+	// BlockOverrides replace the block context above, including the block number
+	BlockOverrides *BlockOverrides
+	// TxType is the EIP-2718 transaction type, for type 1 (EIP-2930) transactions
+	// AccessList is used to warm up the addresses and slots before execution
+	TxType     uint8
+	AccessList types.AccessList
+	// Prefetch lists accounts and slots to load from the fork in a single request
+	Prefetch map[common.Address][]common.Hash
+	// PrefetchAccessList asks the fork for the access list of the transaction with
+	// eth_createAccessList and adds its accounts and slots to Prefetch
+	PrefetchAccessList bool
+	// SpeculativeFetch fetches, along with every slot missing from the state, the
+	// slots the code is about to read and the ones read by previous simulations of the
+	// same function, saving round trips to the fork
+	SpeculativeFetch bool
+	// SetCodeDelegations are EIP-7702 authorizations applied before execution,
+	// providing them enables EIP-7702 on the simulation
+	SetCodeDelegations []CodeDelegation
+	// StateOverrides replace the balance, nonce, code or storage of accounts before
+	// the execution, as the state override set of eth_call
+	StateOverrides map[common.Address]OverrideAccount
+	// ReadOnly fails the simulation on any state modification, as a static call would
+	ReadOnly bool
+	// ChainConfig replaces the chain configuration of the simulator for this
+	// simulation only, e.g. to run it with the rules of an L2
+	ChainConfig *params.ChainConfig
+	// Hardfork runs the simulation with the instruction set and rules of a fork of
+	// Ethereum, every fork up to it active as with runtime.AtFork, e.g. "london" for
+	// a pre-Shanghai execution. The chain id is kept from the chain configuration.
+	Hardfork string
+	// Precompiles overrides the precompiles of the chain rules, to mock them or add
+	// the ones of an L2. A nil contract removes the precompile at its address.
+	Precompiles map[common.Address]ourVm.PrecompiledContract
+	// OpcodeHooks instruments or alters opcodes of the simulation
+	OpcodeHooks *ourVm.OpcodeHooks
+	// Cheatcodes let the simulation, or transactions sent to ourVm.CheatcodeAddress,
+	// warp the block context, deal balances, set storage and prank senders. Share them
+	// between the simulations of a bundle to carry what they set to the next ones.
+	Cheatcodes *ourVm.Cheatcodes
+	// AllowRevert lets the simulation revert in a bundle simulated with RevertAllowListed
+	AllowRevert bool
+	// Nonce of the transaction, the nonce of the sender on the fork is used when
+	// not provided. It's only checked against the fork when ValidateNonces is set.
+	Nonce *uint64
+	// ResolveProxies fills SimulationResult.Proxies, fetching the implementation of
+	// the proxies called up front
+	ResolveProxies bool
+	// CollectCoverage fills SimulationResult.CodeCoverage
+	CollectCoverage bool
+	// CollectCallTrace fills SimulationResult.CallTrace
+	CollectCallTrace bool
+	// CollectGasProfile fills SimulationResult.GasProfile
+	CollectGasProfile bool
+	// CollectOpcodeHistogram fills SimulationResult.OpcodeHistogram
+	CollectOpcodeHistogram bool
+	// CollectAccessReport fills SimulationResult.AccessReport
+	CollectAccessReport bool
+	// CollectRefunds fills SimulationResult.RefundReport
+	CollectRefunds bool
+	// CollectPreimages fills SimulationResult.Preimages and SimulationResult.StorageAccesses
+	CollectPreimages bool
+	// StorageLayouts decode the slots of SimulationResult.StorageAccesses and of
+	// SimulationResult.StateDiff into variables, by the address whose storage they
+	// describe. Decoding the state diff collects the preimages.
+	StorageLayouts map[common.Address]*runtime.StorageLayout
+	// Decoder fills SimulationResult.DecodedEvents, and SimulationResult.DecodedTrace
+	// with CollectCallTrace
+	Decoder *Decoder
+	// AddressBook fills SimulationResult.Labels
+	AddressBook *AddressBook
+	// CollectStateDiff fills SimulationResult.StateDiff
+	CollectStateDiff bool
+	// StructLogger enables the opcode level trace of the simulation, streamed as JSON
+	// lines to StructLogWriter when set, otherwise returned in SimulationResult.StructLogs
+	StructLogger    *logger.Config
+	StructLogWriter io.Writer
+	// Tracer receives the events of the EVM
+	Tracer *tracing.Hooks
+	// MaxRetries is the number of times a request fetching state from the fork is
+	// retried when it fails, with exponential backoff. Execution errors are never retried
+	MaxRetries int
+
+	// travel is set on the operations of AdvanceTime and AdvanceBlocks, and on the
+	// transactions of a bundle following them
+	travel *timeTravel
 }
 
+// CodeDelegation sets the code of Authority to the EIP-7702 designator
+// pointing to ImplementationAddress.
+type CodeDelegation struct {
+	Authority             common.Address
+	ImplementationAddress common.Address
+	Nonce                 uint64
+}
+
+// Simulator simulates transactions on a fork of the chain of RPCClt. It's safe for
+// concurrent use as long as concurrent simulations don't share their state. The
+// Tracer and Cheatcodes of a Simulation are used by its runs though, and mustn't be
+// shared between concurrent simulations.
 type Simulator struct {
 	RPCClt *rpc.Client
+	Cache  *SimulationCache
+	// ValidateNonces makes Simulate and SimulateBundle check the nonces of the
+	// simulations against the nonces on chain before simulating
+	ValidateNonces bool
+
+	// revertPolicy decides whether SimulateBundle goes on after a revert
+	revertPolicy RevertPolicy
+	// bundleWorkers bounds the simulations run at once by SimulateBundleParallel
+	bundleWorkers int
+	// provider fetches the state of the fork instead of RPCClt when set
+	provider ourVm.StateProvider
+	// verifyState verifies the state fetched against the state root, see WithVerifiedState
+	verifyState bool
+	stateRoot   common.Hash
+	// simulationTimeout bounds the wall-clock time of each simulation, zero means no limit
+	simulationTimeout time.Duration
+	// fetchBudget bounds the requests sent to the fork by each simulation, zero means
+	// no limit
+	fetchBudget int
+	// maxSteps and stepDeadline bound the opcodes run by each execution and its
+	// wall-clock time, zero means no limit
+	maxSteps     uint64
+	stepDeadline time.Duration
+	// fullStorageSlots is the number of slots up to which the whole storage of an
+	// account is prefetched, zero disables it
+	fullStorageSlots int
+	// slotHistory keeps the slots read by the functions simulated for the speculative
+	// fetch, see Simulation.SpeculativeFetch
+	slotHistory *ourVm.SlotHistory
+	// nextBlockFees fills the fees of the simulations of the next block, the priority
+	// fee paid at feePercentile of the gas of the last blocks, see WithNextBlockFees
+	nextBlockFees bool
+	feePercentile float64
+	// metrics of the simulations, nil when disabled
+	metrics *metrics.Metrics
+	// chain of the fork, shared with the copies of the simulator
+	chain *chainState
+}
+
+// chainState is the chain configuration of the fork, detected from its chain id
+// unless provided with WithChainConfig, nil uses the runtime defaults
+type chainState struct {
+	mu       sync.Mutex
+	config   *params.ChainConfig
+	detected bool
 }
 
 type SimulationResult struct {
+	// BlockNumber is the block the state was read at, simulations without block
+	// number are pinned to the latest block when they start
+	BlockNumber *big.Int
+	// Status is types.ReceiptStatusFailed when the transaction reverted, as in its receipt
+	Status uint64
+	// Failure is FailureRevert when the transaction reverted, FailureNone otherwise.
+	// Simulations failing for any other reason return a *SimulationError instead
+	Failure FailureKind
+	// ReturnedData holds the revert data when the transaction reverted
 	ReturnedData []byte
 	GasUsed      uint64
 	GasLimit     uint64
-	Record       *runtime.RecordToInitiateState
+	// EffectiveGasPrice is the price paid for every unit of gas used, as in the receipt
+	EffectiveGasPrice *big.Int
+	// PriorityFees are the fees paid to the coinbase for the gas used
+	PriorityFees *big.Int
+	// CoinbaseDiff is the change of balance of the coinbase, the priority fees plus
+	// the ether sent to it by the transaction
+	CoinbaseDiff *big.Int
+	// Record holds the state fetched from the fork so far, to seed NewRemoteState
+	// with, and the access list of the transaction
+	Record *runtime.RecordToInitiateState
+	// Events are the logs emitted during the simulation
+	Events []*types.Log
+	// CodeCoverage has a bit-vector of executed pcs per contract, see runtime.CoveragePercent
+	CodeCoverage map[common.Address][]byte
+	// Nonce of the sender after the simulation
+	Nonce uint64
+	// CreatedContracts are the contracts deployed by the transaction
+	CreatedContracts []runtime.CreatedContract
+	// ConsoleLogs are the lines logged with console.log by the contracts, the ones of
+	// reverted calls included
+	ConsoleLogs []runtime.ConsoleLog
+	// Revert holds the decoded revert data when the simulation reverted
+	Revert *RevertInfo
+	// CallTrace is the call tree of the transaction, in the JSON shape of the callTracer
+	// of go-ethereum
+	CallTrace *runtime.CallFrame
+	// DecodedTrace is CallTrace with the calls decoded by Simulation.Decoder
+	DecodedTrace *DecodedFrame
+	// DecodedEvents are Events decoded by Simulation.Decoder, nil for the unknown ones
+	DecodedEvents []*DecodedLog
+	// Proxies are the proxies called with their implementation
+	Proxies map[common.Address]ourVm.Proxy
+	// Labels are the names in Simulation.AddressBook of the addresses of the call
+	// trace, the access list, the events, the asset changes and the approvals
+	Labels map[common.Address]string
+	// GasProfile is the gas used by every contract and function called, the highest
+	// self gas first
+	GasProfile []runtime.GasProfileEntry
+	// OpcodeHistogram has the executions and gas of every opcode executed
+	OpcodeHistogram map[ourVm.OpCode]*runtime.OpcodeGas
+	// AccessReport has the cold and warm accesses and the gas the access list saved
+	AccessReport *runtime.AccessReport
+	// RefundReport has the refund of every storage slot and the cap applied to it
+	RefundReport *runtime.RefundReport
+	// Preimages are the inputs of the keccak256 of one and two words computed
+	Preimages map[common.Hash][]byte
+	// StorageAccesses are the slots loaded or stored, decoded with Preimages
+	StorageAccesses []runtime.StorageAccess
+	// StructLogs is the opcode level trace in the JSON shape of debug_traceTransaction
+	StructLogs json.RawMessage
+	// StateDiff holds the balances, nonces, codes and storage slots modified by the
+	// transaction, with their values before and after it
+	StateDiff StateDiff
+	// AssetChanges are the net changes of ether and tokens of every address involved
+	AssetChanges []AssetChange
+	// Approvals are the allowances granted or revoked by the transaction
+	Approvals []ApprovalChange
+	// Checkpoint is the state of the bundle right after the transaction, only set by
+	// SimulateBundle and ResumeBundle
+	Checkpoint *BundleCheckpoint
+	// Fetches are the requests sent to the fork to discover the state read, only set
+	// by Simulate and SimulateBundle
+	Fetches []rpc.Fetch
+}
+
+// Err returns a *RevertError when the simulation reverted, nil otherwise.
+func (r *SimulationResult) Err() error {
+	if r.Revert == nil {
+		return nil
+	}
+
+	return &RevertError{Info: r.Revert}
+}
+
+func NewSimulator(rpcClt *rpc.Client, opts ...func(*Simulator)) (*Simulator, error) {
+	s := &Simulator{
+		RPCClt:      rpcClt,
+		Cache:       NewSimulationCache(),
+		slotHistory: ourVm.NewSlotHistory(),
+		chain:       &chainState{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.verifyState {
+		provider, ok := s.stateProvider().(ourVm.StorageProofProvider)
+		if !ok {
+			return nil, errors.New("the state provider can't fetch proofs to verify the state")
+		}
+		s.provider = ourVm.NewVerifyingProvider(provider, s.stateRoot)
+	}
+
+	if s.nextBlockFees && (s.feePercentile < 0 || s.feePercentile > 100) {
+		return nil, fmt.Errorf("fee percentile %v out of the range [0, 100]", s.feePercentile)
+	}
+
+	return s, nil
+}
+
+// WithSimulationTimeout limits the wall-clock time a single call to Simulate can take,
+// once exceeded Simulate returns ErrSimulationTimeout.
+func WithSimulationTimeout(d time.Duration) func(*Simulator) {
+	return func(s *Simulator) {
+		s.simulationTimeout = d
+	}
+}
+
+// WithFetchBudget limits the requests a call to Simulate or SimulateBundle can send to
+// the fork to discover the state it reads, once exceeded it fails with
+// rpc.ErrFetchBudgetExceeded. The state answered by the cache of the client is free.
+func WithFetchBudget(n int) func(*Simulator) {
+	return func(s *Simulator) {
+		s.fetchBudget = n
+	}
+}
+
+// WithStepLimit aborts every execution of a simulation running more than maxSteps
+// opcodes, or for longer than deadline, with ourVm.ErrStepLimitExceeded, whatever its
+// gas limit. A zero maxSteps or deadline doesn't limit it.
+func WithStepLimit(maxSteps uint64, deadline time.Duration) func(*Simulator) {
+	return func(s *Simulator) {
+		s.maxSteps = maxSteps
+		s.stepDeadline = deadline
+	}
+}
+
+// WithFullStorage makes the simulations fetch, on the first slot they read of an
+// account, the whole storage of the account when it has at most maxSlots slots. It's
+// far cheaper than discovering many slots of a small contract one at a time, see
+// rpc.Client.GetFullStorage for the accounts whose storage can't be listed, those are
+// fetched a slot at a time. The state provider must implement
+// ourVm.FullStorageProvider, as the RPC client does, otherwise it has no effect.
+func WithFullStorage(maxSlots int) func(*Simulator) {
+	return func(s *Simulator) {
+		s.fullStorageSlots = maxSlots
+	}
+}
+
+// WithVerifiedState makes the simulations fetch the accounts and slots they read with
+// their Merkle proofs, verifying them against the state root of the block before
+// using them, see ourVm.VerifyingProvider. When stateRoot isn't zero the state root
+// must be it, otherwise the one of the header answered by the node is trusted. The
+// state provider must fetch proofs, as the RPC client does. State that can't be
+// verified fails the simulation with ourVm.ErrInvalidProof.
+func WithVerifiedState(stateRoot common.Hash) func(*Simulator) {
+	return func(s *Simulator) {
+		s.verifyState = true
+		s.stateRoot = stateRoot
+	}
+}
+
+// WithChainConfig sets the chain configuration used by the simulations instead of
+// detecting it from the chain id of the fork.
+func WithChainConfig(chainConfig *params.ChainConfig) func(*Simulator) {
+	return func(s *Simulator) {
+		s.chain.config = chainConfig
+		s.chain.detected = true
+	}
+}
+
+// WithStateProvider makes the simulations fetch the state of the fork, code, balances,
+// nonces, storage and block hashes, from provider instead of the RPC client. The client
+// is still used for blocks, transactions and the chain id.
+func WithStateProvider(provider ourVm.StateProvider) func(*Simulator) {
+	return func(s *Simulator) {
+		s.provider = provider
+	}
+}
+
+// stateProvider returns the provider the state of the fork is fetched with
+func (s *Simulator) stateProvider() ourVm.StateProvider {
+	if s.provider != nil {
+		return s.provider
+	}
+
+	return s.RPCClt
+}
+
+// NewRemoteState returns a state fetching what simulations read from the fork at
+// blockNumber, latest when nil or zero. The state of record, as fetched by previous
+// simulations, isn't fetched again, record may be nil.
+func (s *Simulator) NewRemoteState(ctx context.Context, blockNumber *big.Int, record *runtime.RecordToInitiateState) (*state.StateDB, error) {
+	if blockNumber != nil && blockNumber.Sign() == 0 {
+		blockNumber = nil
+	}
+
+	return runtime.NewRemoteStateDB(ctx, s.stateProvider(), blockNumber, record)
 }
 
-func NewSimulator(rpcClt *rpc.Client) (*Simulator, error) {
-	return &Simulator{RPCClt: rpcClt}, nil
+// stateOrRemote returns stateDB, or when nil a new remote state at the block of
+// simulation, pinning it to the latest block when it has none
+func (s *Simulator) stateOrRemote(ctx context.Context, simulation *Simulation, stateDB *state.StateDB) (*state.StateDB, error) {
+	if stateDB != nil {
+		return stateDB, nil
+	}
+
+	simulations := []Simulation{*simulation}
+	err := s.pinLatestBlock(ctx, simulations)
+	if err != nil {
+		return nil, err
+	}
+	*simulation = simulations[0]
+
+	return s.NewRemoteState(ctx, simulation.BlockNumber, nil)
 }
 
 // Simulate perform the simulation of a transaction
-// does not return a propper gas computation, for that use EstimateGas
-func (s *Simulator) Simulate(simulation Simulation, stateDB *state.StateDB, recordInitializer *runtime.RecordToInitiateState) (*SimulationResult, error) {
-	cfg := s.ConfigFromSimulation(simulation)
+// does not return a propper gas computation, for that use EstimateGas.
+// The transaction is executed once on stateDB, fetching what it reads from the fork
+// when it's a remote state. A nil stateDB is a new state of NewRemoteState at the
+// block of the simulation.
+// It fails with a *SimulationError, see FailureOf.
+func (s *Simulator) Simulate(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (*SimulationResult, error) {
+	ctx = withSimulationID(ctx)
+	ctx, audit, fetched := s.withAudit(ctx)
+	simLogger.DebugContext(ctx, "simulating", "from", simulation.From, "to", simulation.To, "block", simulation.BlockNumber)
+	start := time.Now()
+
+	ctx, span := otelTracer.Start(ctx, "Simulate", trace.WithAttributes(simulationAttributes(simulation)...))
+
+	if s.metrics != nil {
+		var opcodes uint64
+		simulation.Tracer = countOpcodes(simulation.Tracer, &opcodes)
+
+		defer func() {
+			s.metrics.ObserveOpcodes(opcodes)
+			s.metrics.ObserveDuration("single", start)
+		}()
+	}
 
-	var (
-		blk     = ""
-		err     error
-		code    = simulation.Code
-		balance = big.NewInt(0)
-	)
+	result, err := s.simulateWithTimeout(ctx, simulation, stateDB)
+	s.observeSimulation(result, err)
+	endSimulationSpan(span, result, err)
 
-	if simulation.BlockNumber.Cmp(big.NewInt(0)) > 0 {
-		blk = "0x" + simulation.BlockNumber.Text(16)
-	} else {
-		// fetch latest block number
+	if err != nil {
+		simLogger.InfoContext(ctx, "simulation failed", "duration", time.Since(start), "error", err)
+		return nil, simulationError(err)
 	}
+	result.Fetches = audit.Fetches(fetched)
+	simLogger.DebugContext(ctx, "simulated", "status", result.Status, "gasUsed", result.GasUsed, "fetches", len(result.Fetches), "duration", time.Since(start))
 
-	if len(code) == 0 && stateDB.GetCodeSize(simulation.To) == 0 {
-		// fetch code of address
-		code, err = s.RPCClt.GetCode(simulation.To.Hex(), blk)
-		if err != nil {
-			return nil, err
-		}
-	} else if len(code) == 0 {
-		code = stateDB.GetCode(simulation.To)
+	return result, nil
+}
+
+// withSimulationID returns ctx with a new simulation ID, unless it has one already:
+// the simulations run by another one are logged under its ID
+func withSimulationID(ctx context.Context) context.Context {
+	if logging.SimulationID(ctx) != "" {
+		return ctx
+	}
+
+	return logging.WithSimulationID(ctx, logging.NewSimulationID())
+}
+
+// withAudit returns ctx with a new audit of the requests to the fork bounded by the
+// fetch budget, unless it has one already: the simulations run by another one share
+// its audit and budget. The requests logged from the returned index on are the ones
+// of the caller.
+func (s *Simulator) withAudit(ctx context.Context) (context.Context, *rpc.Audit, int) {
+	if audit := rpc.AuditFromContext(ctx); audit != nil {
+		return ctx, audit, audit.Len()
+	}
+
+	audit := rpc.NewAudit(s.fetchBudget)
+	return rpc.WithAudit(ctx, audit), audit, 0
+}
+
+func (s *Simulator) simulateWithTimeout(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (*SimulationResult, error) {
+	if s.simulationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.simulationTimeout)
+		defer cancel()
+	}
+
+	result, err := s.prepareAndSimulate(ctx, simulation, stateDB)
+	if err != nil && s.simulationTimeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+		return nil, ErrSimulationTimeout
 	}
 
-	if simulation.Value.Cmp(big.NewInt(0)) > 0 && stateDB.GetBalance(simulation.From).Cmp(common.U2560) <= 0 {
-		balance, err = s.RPCClt.GetBalance(simulation.From.Hex(), blk)
+	return result, err
+}
+
+// prepareAndSimulate fills the block context and nonce of simulation and simulates
+// it on stateDB, a new remote state when nil
+func (s *Simulator) prepareAndSimulate(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (*SimulationResult, error) {
+	simulation, err := s.prepareSimulation(ctx, simulation)
+	if err != nil {
+		return nil, err
+	}
+
+	if stateDB == nil {
+		stateDB, err = s.NewRemoteState(ctx, simulation.BlockNumber, nil)
 		if err != nil {
 			return nil, err
 		}
+	}
+	runtime.BindRemoteState(ctx, stateDB, simulation.MaxRetries)
 
-		if balance.Cmp(simulation.Value) <= 0 {
-			return nil, errors.New("insuficient balance to proceed with simulation")
-		}
+	simulation, err = s.resolveNonce(ctx, simulation, stateDB)
+	if err != nil {
+		return nil, err
 	}
 
-	var recordToInit *ourVm.RecordToInitiateState
-	if recordInitializer != nil {
-		recordToInit = &ourVm.RecordToInitiateState{
-			AddressCodeSet:    recordInitializer.AddressCodeSet,
-			AddressBalanceSet: recordInitializer.AddressBalanceSet,
-			AddressStorageSet: recordInitializer.AddressStorageSet,
-			// AccessList:        recordInitializer.AccessList,
+	if simulation.PrefetchAccessList {
+		simulation, err = s.prefetchAccessList(ctx, simulation)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// first execution to generate proper access lists
-	result, err := runtime.Execute(simulation.To, balance, code, simulation.Input, cfg, stateDB, recordToInit)
+	return s.simulate(ctx, simulation, stateDB)
+}
+
+// simulate executes the prepared simulation once on stateDB
+func (s *Simulator) simulate(ctx context.Context, simulation Simulation, stateDB *state.StateDB) (*SimulationResult, error) {
+	cfg := s.ConfigFromSimulation(simulation)
+	cfg.GetHashFn = s.blockHashFn(ctx)
+	runtime.BindRemoteState(ctx, stateDB, simulation.MaxRetries)
+
+	err := applyStateOverrides(simulation.StateOverrides, stateDB)
 	if err != nil {
 		return nil, err
 	}
 
-	stateDB, err = InitIdealState(stateDB, result.Record)
+	incrementNonce(stateDB, simulation)
+	applyCodeDelegations(simulation.SetCodeDelegations, stateDB)
+
+	code := simulation.Code
+	if len(code) == 0 {
+		code = stateDB.GetCode(simulation.To)
+	}
+
+	balance, err := ensureSufficientBalance(stateDB, simulation.From, maxCost(simulation), simulation.StateOverrides)
 	if err != nil {
 		return nil, err
 	}
 
-	recordToInit = &ourVm.RecordToInitiateState{
-		AddressCodeSet:    result.Record.AddressCodeSet,
-		AddressBalanceSet: result.Record.AddressBalanceSet,
-		AddressStorageSet: result.Record.AddressStorageSet,
-		AccessList:        result.Record.AccessList,
-	}
+	tracker := trackStateDiff(stateDB, simulation, balance)
+	defer tracker.stop()
 
-	result, err = runtime.Execute(simulation.To, balance, code, simulation.Input, cfg, stateDB, recordToInit)
+	execCtx, span := otelTracer.Start(ctx, "execute")
+	result, err := runtime.Execute(execCtx, simulation.To, balance, code, simulation.Input, cfg, stateDB)
+	endSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
 
-	return &SimulationResult{
-		ReturnedData: result.Ret,
-		GasUsed:      result.GasUsed,
-		Record:       result.Record,
-	}, nil
-}
+	simResult := newSimulationResult(result, stateDB, simulation, cfg.Coinbase)
+	simResult.StateDiff = tracker.diff()
+	simResult.StateDiff.decodeStorage(simulation.StorageLayouts, result.Preimages)
 
-func (s *Simulator) unoptimalSimulation(simulation Simulation, stateDB *state.StateDB, recordInitializer *runtime.RecordToInitiateState) (*SimulationResult, error) {
-	cfg := s.ConfigFromSimulation(simulation)
+	return simResult, nil
+}
 
-	var (
-		blk  = ""
-		err  error
-		code = simulation.Code
-	)
+func newSimulationResult(result *runtime.ExecutionResult, stateDB *state.StateDB, simulation Simulation, coinbase common.Address) *SimulationResult {
+	simResult := &SimulationResult{
+		BlockNumber:       simulation.BlockNumber,
+		Status:            types.ReceiptStatusSuccessful,
+		ReturnedData:      result.Ret,
+		GasUsed:           result.GasUsed,
+		EffectiveGasPrice: result.EffectiveGasPrice,
+		PriorityFees:      result.PriorityFees,
+		CoinbaseDiff:      coinbaseDiff(coinbase, result),
+		Record:            result.Record,
+		Events:            result.Logs,
+		CodeCoverage:      result.CodeCoverage,
+		Nonce:             stateDB.GetNonce(simulation.From),
+		CreatedContracts:  result.CreatedContracts,
+		ConsoleLogs:       result.ConsoleLogs,
+		CallTrace:         result.CallTrace,
+		GasProfile:        result.GasProfile,
+		OpcodeHistogram:   result.OpcodeHistogram,
+		AccessReport:      result.AccessReport,
+		RefundReport:      result.RefundReport,
+		Preimages:         result.Preimages,
+		StorageAccesses:   result.StorageAccesses,
+		Proxies:           result.Proxies,
+		StructLogs:        result.StructLogs,
+		AssetChanges:      AssetChanges(result.ValueTransfers, result.Logs),
+		Approvals:         Approvals(result.Logs),
+	}
 
-	if simulation.BlockNumber.Cmp(big.NewInt(0)) > 0 {
-		blk = "0x" + simulation.BlockNumber.Text(16)
-	} else {
-		// fetch latest block number
+	if info := revertInfo(result.Err); info != nil {
+		simResult.Status = types.ReceiptStatusFailed
+		simResult.Revert = info
+		simResult.Failure = FailureRevert
 	}
 
-	if len(code) == 0 && stateDB.GetCodeSize(simulation.To) == 0 {
-		// fetch code of address
-		code, err = s.RPCClt.GetCode(simulation.To.Hex(), blk)
-		if err != nil {
-			return nil, err
+	if simulation.Decoder != nil {
+		simResult.DecodedTrace = simulation.Decoder.DecodeTrace(result.CallTrace)
+		for _, log := range result.Logs {
+			simResult.DecodedEvents = append(simResult.DecodedEvents, simulation.Decoder.DecodeLog(log))
 		}
-	} else if len(code) == 0 {
-		code = stateDB.GetCode(simulation.To)
 	}
 
-	balance := stateDB.GetBalance(simulation.From).ToBig()
-	if simulation.Value.Cmp(big.NewInt(0)) > 0 && balance.Cmp(big.NewInt(0)) <= 0 {
-		balance, err = s.RPCClt.GetBalance(simulation.From.Hex(), blk)
+	if simulation.AddressBook != nil {
+		simResult.Labels = simulation.AddressBook.labelsOf(resultAddresses(simResult)...)
+	}
+
+	return simResult
+}
+
+// GenerateAccessList simulates sim, returning the access list recorded on it
+// together with the record of the state fetched. The access list can be attached to
+// the transaction, as an EIP-2930 one, to reduce its gas cost. A nil stateDB is a
+// new remote state, as with Simulate.
+func (s *Simulator) GenerateAccessList(ctx context.Context, sim Simulation, stateDB *state.StateDB) (types.AccessList, *runtime.RecordToInitiateState, error) {
+	sim, err := s.prepareSimulation(ctx, sim)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stateDB == nil {
+		stateDB, err = s.NewRemoteState(ctx, sim.BlockNumber, nil)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
+	runtime.BindRemoteState(ctx, stateDB, sim.MaxRetries)
+
+	sim, err = s.resolveNonce(ctx, sim, stateDB)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if balance.Cmp(simulation.Value) < 0 {
-		return nil, errors.New("insuficient balance to proceed with simulation")
+	result, err := s.simulate(ctx, sim, stateDB)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	var recordToInit *ourVm.RecordToInitiateState
-	if recordInitializer != nil {
-		recordToInit = &ourVm.RecordToInitiateState{
-			AddressCodeSet:    recordInitializer.AddressCodeSet,
-			AddressBalanceSet: recordInitializer.AddressBalanceSet,
-			AddressStorageSet: recordInitializer.AddressStorageSet,
-			AccessList:        recordInitializer.AccessList,
+	return result.Record.AccessList, result.Record, nil
+}
+
+// applyCodeDelegations sets the code of each authority to the delegation designator
+// and its nonce to the one following the authorization, as done when processing
+// a set code transaction.
+func applyCodeDelegations(delegations []CodeDelegation, stateDB *state.StateDB) {
+	for _, delegation := range delegations {
+		if !stateDB.Exist(delegation.Authority) {
+			stateDB.CreateAccount(delegation.Authority)
 		}
+		stateDB.SetCode(delegation.Authority, ourVm.AddressToDelegation(delegation.ImplementationAddress))
+		stateDB.SetNonce(delegation.Authority, delegation.Nonce+1)
 	}
+}
 
-	// first execution to generate proper access lists
-	result, err := runtime.Execute(simulation.To, balance, code, simulation.Input, cfg, stateDB, recordToInit)
-	if err != nil {
+// ensureSufficientBalance returns the balance the sender should be simulated with,
+// its balance in stateDB, failing when it doesn't cover value, usually the maxCost of
+// the simulation. A balance of the sender in overrides is the one simulated with.
+func ensureSufficientBalance(stateDB *state.StateDB, from common.Address, value *big.Int, overrides map[common.Address]OverrideAccount) (*big.Int, error) {
+	if override, ok := overrides[from]; ok && override.Balance != nil {
+		if value != nil && override.Balance.Cmp(value) < 0 {
+			return nil, ErrInsufficientBalance
+		}
+
+		return new(big.Int).Set(override.Balance), nil
+	}
+
+	balance := stateDB.GetBalance(from).ToBig()
+	if err := stateDB.Error(); err != nil {
 		return nil, err
 	}
 
-	return &SimulationResult{
-		ReturnedData: result.Ret,
-		GasUsed:      result.GasUsed,
-		Record:       result.Record,
-	}, nil
+	if value != nil && balance.Cmp(value) < 0 {
+		return nil, ErrInsufficientBalance
+	}
+
+	return balance, nil
 }
 
-// SimulateBundle simulate a bundle of transactions using always the same state
-func (s *Simulator) SimulateBundle(simulations []Simulation, stateDB *state.StateDB, recordInitializer *runtime.RecordToInitiateState) ([]*SimulationResult, error) {
-	recordAccessLists := make([]types.AccessList, len(simulations))
-	result := make([]*SimulationResult, len(simulations))
+// maxCost is the balance the sender needs for the simulation, its value plus the gas
+// limit and the intrinsic gas of the cheapest transaction at the fee cap. Simulations
+// without gas limit get the gas they can afford, so only the cost of the cheapest
+// transaction is required.
+func maxCost(simulation Simulation) *big.Int {
+	cost := new(big.Int)
+	if simulation.Value != nil {
+		cost.Set(simulation.Value)
+	}
+
+	feeCap := simulation.GasPrice
+	if simulation.MaxFeePerGas != nil {
+		feeCap = simulation.MaxFeePerGas
+	}
+
+	if feeCap == nil || feeCap.Sign() == 0 {
+		return cost
+	}
+
+	gas := new(big.Int).SetUint64(simulation.GasLimit)
+	gas.Add(gas, big.NewInt(int64(params.TxGas)))
+
+	return cost.Add(cost, gas.Mul(gas, feeCap))
+}
+
+// SimulateBundle simulate a bundle of transactions using always the same state,
+// committed after every transaction. A nil stateDB is a new remote state, as with
+// Simulate. Reverted transactions are handled following the RevertPolicy of the
+// simulator. It fails with a *SimulationError, as Simulate.
+func (s *Simulator) SimulateBundle(ctx context.Context, simulations []Simulation, stateDB *state.StateDB) ([]*SimulationResult, error) {
+	ctx = withSimulationID(ctx)
+	ctx, _, _ = s.withAudit(ctx)
+	simLogger.DebugContext(ctx, "simulating bundle", "simulations", len(simulations))
+	start := time.Now()
+
+	ctx, span := otelTracer.Start(ctx, "SimulateBundle", trace.WithAttributes(attribute.Int("bundle.size", len(simulations))))
+
+	if s.metrics != nil {
+		defer s.metrics.ObserveDuration("bundle", start)
+	}
+
+	results, err := s.simulateBundle(ctx, simulations, stateDB, s.revertPolicy)
+	endSpan(span, err)
+	if err != nil {
+		s.observeSimulation(nil, err)
+		simLogger.InfoContext(ctx, "bundle simulation failed", "duration", time.Since(start), "error", err)
+		return nil, simulationError(err)
+	}
+
+	for _, result := range results {
+		s.observeSimulation(result, nil)
+	}
+	simLogger.DebugContext(ctx, "simulated bundle", "duration", time.Since(start))
+
+	return results, nil
+}
+
+func (s *Simulator) simulateBundle(ctx context.Context, simulations []Simulation, stateDB *state.StateDB, policy RevertPolicy) ([]*SimulationResult, error) {
+	// don't modify the simulations of the caller
+	simulations = bundleTransactions(simulations)
+	err := s.pinLatestBlock(ctx, simulations)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.ValidateNonces {
+		err := s.validateBundleNonces(ctx, simulations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for i := range simulations {
-		simResult, err := s.unoptimalSimulation(simulations[i], stateDB, recordInitializer)
+		simulations[i], err = s.prepareSimulation(ctx, simulations[i])
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		recordAccessLists[i] = simResult.Record.AccessList
-		recordInitializer = simResult.Record
-		recordInitializer.AccessList = nil
+	stateDB, err = s.bundleState(ctx, simulations, stateDB)
+	if err != nil {
+		return nil, err
 	}
+	runtime.BindRemoteState(ctx, stateDB, maxRetries(simulations))
 
-	// optimizing simulation gas computation
-	stateDB, err := InitIdealState(stateDB, recordInitializer)
+	err = s.resolveBundleNonces(ctx, simulations, stateDB)
 	if err != nil {
 		return nil, err
 	}
 
+	result := make([]*SimulationResult, len(simulations))
 	for i := range simulations {
-		recordInitializer.AccessList = recordAccessLists[i]
-		simResult, err := s.unoptimalSimulation(simulations[i], stateDB, recordInitializer)
+		simResult, err := s.simulateBundleItem(ctx, i, simulations[i], stateDB)
 		if err != nil {
 			return nil, err
 		}
 
-		recordInitializer = simResult.Record
-		result[i] = simResult
-		// commit state
-		root, err := stateDB.Commit(0, false)
-		if err != nil {
-			return nil, fmt.Errorf("commit error: %s", err)
+		if err := policy.checkRevert(i, simulations[i], simResult); err != nil {
+			return nil, err
 		}
 
-		stateDB, err = state.New(root, stateDB.Database(), nil)
+		result[i] = simResult
+		stateDB, simResult.Checkpoint, err = commitCheckpoint(stateDB, i)
 		if err != nil {
 			return nil, err
 		}
@@ -227,6 +807,52 @@ func (s *Simulator) SimulateBundle(simulations []Simulation, stateDB *state.Stat
 	return result, nil
 }
 
+// bundleState returns stateDB, or a new remote state at the block of the first of
+// the prepared simulations when nil
+func (s *Simulator) bundleState(ctx context.Context, simulations []Simulation, stateDB *state.StateDB) (*state.StateDB, error) {
+	if stateDB != nil {
+		return stateDB, nil
+	}
+
+	var blockNumber *big.Int
+	if len(simulations) > 0 {
+		blockNumber = simulations[0].BlockNumber
+	}
+
+	return s.NewRemoteState(ctx, blockNumber, nil)
+}
+
+// maxRetries is the highest MaxRetries of simulations
+func maxRetries(simulations []Simulation) int {
+	retries := 0
+	for _, simulation := range simulations {
+		retries = max(retries, simulation.MaxRetries)
+	}
+
+	return retries
+}
+
+// copyCheatcodes returns simulations with copies of their cheatcodes, the simulations
+// sharing them keep sharing the copy
+func copyCheatcodes(simulations []Simulation) []Simulation {
+	simulations = slices.Clone(simulations)
+	copies := make(map[*ourVm.Cheatcodes]*ourVm.Cheatcodes)
+	for i, simulation := range simulations {
+		if simulation.Cheatcodes == nil {
+			continue
+		}
+
+		cpy, ok := copies[simulation.Cheatcodes]
+		if !ok {
+			cpy = simulation.Cheatcodes.Copy()
+			copies[simulation.Cheatcodes] = cpy
+		}
+		simulations[i].Cheatcodes = cpy
+	}
+
+	return simulations
+}
+
 func runtimeCfgFromSimulation(simulation Simulation) *runtime.Config {
 	cfg := &runtime.Config{
 		Debug:       true,
@@ -241,83 +867,92 @@ func runtimeCfgFromSimulation(simulation Simulation) *runtime.Config {
 	return cfg
 }
 
-func InitIdealState(originState *state.StateDB, record *runtime.RecordToInitiateState) (*state.StateDB, error) {
-	db := state.NewDatabase(rawdb.NewMemoryDatabase())
-	tmp, err := state.New(types.EmptyRootHash, db, nil)
-	if err != nil {
-		return nil, err
+func (s *Simulator) ConfigFromSimulation(simulation Simulation) *runtime.Config {
+	cfg := &runtime.Config{
+		Debug:                  true,
+		Origin:                 simulation.From,
+		BlockNumber:            simulation.BlockNumber,
+		GasLimit:               simulation.GasLimit,
+		GasPrice:               simulation.GasPrice,
+		GasFeeCap:              simulation.MaxFeePerGas,
+		GasTipCap:              simulation.MaxPriorityFeePerGas,
+		Value:                  simulation.Value,
+		RPCEndpoint:            s.RPCClt.Endpoint,
+		RPCClient:              s.RPCClt,
+		StateProvider:          s.provider,
+		ChainConfig:            s.simulationChainConfig(simulation),
+		Prefetch:               simulation.Prefetch,
+		ReadOnly:               simulation.ReadOnly,
+		Precompiles:            simulation.Precompiles,
+		OpcodeHooks:            simulation.OpcodeHooks,
+		Cheatcodes:             simulation.Cheatcodes,
+		ResolveProxies:         simulation.ResolveProxies,
+		SpeculativeFetch:       simulation.SpeculativeFetch,
+		SlotHistory:            s.slotHistory,
+		MaxSteps:               s.maxSteps,
+		StepDeadline:           s.stepDeadline,
+		FullStorageSlots:       s.fullStorageSlots,
+		CollectCoverage:        simulation.CollectCoverage,
+		CollectCallTrace:       simulation.CollectCallTrace,
+		CollectGasProfile:      simulation.CollectGasProfile,
+		CollectOpcodeHistogram: simulation.CollectOpcodeHistogram,
+		CollectAccessReport:    simulation.CollectAccessReport,
+		CollectRefunds:         simulation.CollectRefunds,
+		CollectPreimages:       simulation.CollectPreimages || simulation.CollectStateDiff && len(simulation.StorageLayouts) > 0,
+		StorageLayouts:         simulation.StorageLayouts,
+		StructLogger:           simulation.StructLogger,
+		StructLogWriter:        simulation.StructLogWriter,
+		FetchRetries:           simulation.MaxRetries,
 	}
 
-	// create the accounts and set their code
-	for acc := range record.AddressCodeSet {
-		tmp.CreateAccount(acc)
-		code := originState.GetCode(acc)
-		tmp.SetCode(acc, code)
-	}
+	cfg.EVMConfig.Tracer = simulation.Tracer
 
-	// set balances of accounts that need it
-	for acc := range record.AddressBalanceSet {
-		balance := originState.GetBalance(acc)
-		tmp.SetBalance(acc, balance, tracing.BalanceChangeUnspecified)
+	if simulation.Coinbase != nil {
+		cfg.Coinbase = *simulation.Coinbase
 	}
 
-	// set storages of accounts that need it
-	for key, value := range record.AddressStorageSet {
-		split := strings.Split(key, ":")
-		acc := common.HexToAddress(split[0])
-		slot := common.HexToHash(split[1])
+	if simulation.Difficulty != nil {
+		cfg.Difficulty = simulation.Difficulty
+	}
 
-		tmp.SetState(acc, slot, value)
+	if simulation.Timestamp != 0 {
+		cfg.Time = simulation.Timestamp
 	}
 
-	root, err := tmp.Commit(0, false)
-	if err != nil {
-		return nil, fmt.Errorf("commit error: %s", err)
+	if simulation.BaseFee != nil {
+		cfg.BaseFee = simulation.BaseFee
 	}
 
-	return state.New(root, db, nil)
-}
+	if simulation.BlockGasLimit != 0 {
+		cfg.BlockGasLimit = simulation.BlockGasLimit
+	}
 
-func (s *Simulator) ConfigFromSimulation(simulation Simulation) *runtime.Config {
-	return &runtime.Config{
-		Debug:       true,
-		Origin:      simulation.From,
-		BlockNumber: simulation.BlockNumber,
-		GasLimit:    simulation.GasLimit,
-		GasPrice:    simulation.GasPrice,
-		Value:       simulation.Value,
-		RPCEndpoint: s.RPCClt.Endpoint,
+	if simulation.Random != nil {
+		cfg.Random = simulation.Random
 	}
-}
 
-func combineRecordInitializers(records []*runtime.RecordToInitiateState) *runtime.RecordToInitiateState {
-	record := &runtime.RecordToInitiateState{
-		AddressCodeSet:    make(map[common.Address]struct{}),
-		AddressBalanceSet: make(map[common.Address]struct{}),
-		AddressStorageSet: make(map[string]common.Hash),
+	if simulation.BlobBaseFee != nil {
+		cfg.BlobBaseFee = simulation.BlobBaseFee
 	}
 
-	for _, r := range records {
-		if r != nil {
-			// combine address code set
-			for k, v := range r.AddressCodeSet {
-				record.AddressCodeSet[k] = v
-			}
+	simulation.BlockOverrides.apply(cfg)
+
+	// before the merge DIFFICULTY isn't PREVRANDAO
+	if simulation.Hardfork != "" && !cfg.ChainConfig.TerminalTotalDifficultyPassed {
+		cfg.Random = nil
+	}
 
-			// combine address balance set
-			for k, v := range r.AddressBalanceSet {
-				record.AddressBalanceSet[k] = v
-			}
+	if len(simulation.SetCodeDelegations) > 0 {
+		cfg.EVMConfig.ExtraEips = append(cfg.EVMConfig.ExtraEips, 7702)
+	}
 
-			// combine address storage set
-			for k, v := range r.AddressStorageSet {
-				if _, ok := record.AddressStorageSet[k]; !ok {
-					// adding only first occurrence
-					record.AddressStorageSet[k] = v
-				}
-			}
+	if simulation.TxType == types.AccessListTxType {
+		cfg.AccessList = simulation.AccessList
+		// without access list nothing is warmed up in advance
+		if cfg.AccessList == nil {
+			cfg.AccessList = types.AccessList{}
 		}
 	}
 
-	return record
+	return cfg
 }
@@ -1,19 +1,116 @@
 package simulator
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"log"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Gealber/evm-simulator/rpc"
 	"github.com/Gealber/evm-simulator/vm"
+	"github.com/Gealber/evm-simulator/vm/runtime"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
 	"github.com/ethereum/go-ethereum/core/types"
+	corevm "github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
 )
 
+// mockRPC is a minimal JSON-RPC node answering with the given handler,
+// it keeps track of the methods requested to it.
+type mockRPC struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func newMockRPC(t *testing.T, handler func(method string, params []json.RawMessage) (interface{}, error)) *mockRPC {
+	m := &mockRPC{}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int               `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		m.mu.Lock()
+		m.calls = append(m.calls, req.Method)
+		m.mu.Unlock()
+
+		resp := map[string]interface{}{"id": req.ID, "jsonrpc": "2.0"}
+		result, err := handler(req.Method, req.Params)
+		if err != nil {
+			resp["error"] = map[string]interface{}{"code": -32000, "message": err.Error()}
+		} else {
+			resp["result"] = result
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func (m *mockRPC) Calls(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, c := range m.calls {
+		if c == method {
+			count++
+		}
+	}
+
+	return count
+}
+
+var record = flag.Bool("record", false, "record the fixtures of testdata from the node")
+
+// fixtureClient returns a client replaying the fixture of testdata, or recording it
+// from endpoint with -record.
+func fixtureClient(t *testing.T, name, endpoint string) *rpc.Client {
+	path := filepath.Join("testdata", name+".json")
+	if !*record {
+		fixture, err := rpc.LoadFixture(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rpc.NewReplayClient(fixture)
+	}
+
+	fixture := rpc.NewFixture()
+	t.Cleanup(func() {
+		if err := fixture.Save(path); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return rpc.NewClient(endpoint, rpc.WithRecorder(fixture))
+}
+
 func TestSimulate(t *testing.T) {
 	code := []byte{
 		byte(vm.PUSH0), byte(vm.CALLDATALOAD),
@@ -26,8 +123,9 @@ func TestSimulate(t *testing.T) {
 	rpcEndpoint := "https://eth.llamarpc.com"
 	blkNumber := big.NewInt(1)
 
-	rpcClt := rpc.NewClient(rpcEndpoint)
-	sim, err := NewSimulator(rpcClt)
+	rpcClt := fixtureClient(t, t.Name(), rpcEndpoint)
+	// PUSH0 isn't enabled at block 1 of mainnet, use the runtime defaults
+	sim, err := NewSimulator(rpcClt, WithChainConfig(nil))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -51,7 +149,7 @@ func TestSimulate(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	result, err := sim.Simulate(simulation, stateDB, nil)
+	result, err := sim.Simulate(context.Background(), simulation, stateDB)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -94,8 +192,9 @@ func TestSimulateBundle(t *testing.T) {
 	rpcEndpoint := "https://eth.llamarpc.com"
 	blkNumber := big.NewInt(1)
 
-	rpcClt := rpc.NewClient(rpcEndpoint)
-	sim, err := NewSimulator(rpcClt)
+	rpcClt := fixtureClient(t, t.Name(), rpcEndpoint)
+	// PUSH0 isn't enabled at block 1 of mainnet, use the runtime defaults
+	sim, err := NewSimulator(rpcClt, WithChainConfig(nil))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -140,7 +239,7 @@ func TestSimulateBundle(t *testing.T) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	result, err := sim.SimulateBundle(simulations, stateDB, nil)
+	result, err := sim.SimulateBundle(context.Background(), simulations, stateDB)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -176,3 +275,893 @@ func TestSimulateBundle(t *testing.T) {
 		}
 	}
 }
+
+func TestEnsureSufficientBalance(t *testing.T) {
+	from := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	forkBalance := "0x64" // 100 wei
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance":
+			return forkBalance, nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes{}, nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateDB, err := sim.NewRemoteState(context.Background(), big.NewInt(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the balance of the fork covers the value
+	balance, err := ensureSufficientBalance(stateDB, from, big.NewInt(80), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("balance: %s expected: 100", balance)
+	}
+
+	// the fork can't cover the value
+	_, err = ensureSufficientBalance(stateDB, from, big.NewInt(101), nil)
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance got: %v", err)
+	}
+
+	// the balance in the state is the one simulated with
+	stateDB.SetBalance(from, uint256.NewInt(50), tracing.BalanceChangeUnspecified)
+	_, err = ensureSufficientBalance(stateDB, from, big.NewInt(80), nil)
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance got: %v", err)
+	}
+
+	// an overridden balance is never replaced by the one of the fork
+	overrides := map[common.Address]OverrideAccount{from: {Balance: big.NewInt(20)}}
+	_, err = ensureSufficientBalance(stateDB, from, big.NewInt(80), overrides)
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance got: %v", err)
+	}
+	if srv.Calls("eth_getBalance") != 1 {
+		t.Fatalf("balance fetched %d times, want once", srv.Calls("eth_getBalance"))
+	}
+}
+
+func TestSimulateCoinbase(t *testing.T) {
+	code := []byte{
+		byte(vm.COINBASE),
+		byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), byte(0x20), byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := common.HexToAddress("0x00000000000000000000000000000000000000cb")
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		Code:        code,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+		Coinbase:    &coinbase,
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := common.BytesToAddress(result.ReturnedData); got != coinbase {
+		t.Fatalf("coinbase: %s expected: %s", got.Hex(), coinbase.Hex())
+	}
+}
+
+func TestSimulateDynamicFees(t *testing.T) {
+	// returns the balance of the sender
+	code := []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.BALANCE),
+		byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), byte(0x20), byte(vm.PUSH0), byte(vm.RETURN),
+	}
+	from := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance":
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == from {
+				return "0xde0b6b3a7640000", nil
+			}
+			return "0x0", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase := common.HexToAddress("0x00000000000000000000000000000000000000cb")
+	simulation := Simulation{
+		From:                 from,
+		To:                   common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		Code:                 code,
+		BlockNumber:          big.NewInt(1),
+		GasLimit:             300000,
+		Value:                big.NewInt(0),
+		Coinbase:             &coinbase,
+		BaseFee:              big.NewInt(1e9),
+		MaxFeePerGas:         big.NewInt(3e9),
+		MaxPriorityFeePerGas: big.NewInt(1e9),
+		CollectStateDiff:     true,
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.EffectiveGasPrice.Cmp(big.NewInt(2e9)) != 0 {
+		t.Fatalf("effective gas price: %s expected 2 gwei", result.EffectiveGasPrice)
+	}
+
+	// the whole gas limit and the intrinsic gas are bought upfront at the effective price
+	upfront := new(big.Int).Sub(big.NewInt(1e18), big.NewInt(int64(300000+params.TxGas)*2e9))
+	if got := new(big.Int).SetBytes(result.ReturnedData); got.Cmp(upfront) != 0 {
+		t.Fatalf("balance during execution: %s expected %s", got, upfront)
+	}
+
+	// the sender pays the gas used, the coinbase only gets the priority fee
+	paid := new(big.Int).Mul(big.NewInt(2e9), new(big.Int).SetUint64(result.GasUsed))
+	diff := result.StateDiff[simulation.From].Balance
+	if spent := new(big.Int).Sub(diff.Before, diff.After); spent.Cmp(paid) != 0 {
+		t.Fatalf("sender spent: %s expected %s", spent, paid)
+	}
+
+	tips := new(big.Int).Mul(big.NewInt(1e9), new(big.Int).SetUint64(result.GasUsed))
+	if after := result.StateDiff[coinbase].Balance.After; after.Cmp(tips) != 0 {
+		t.Fatalf("coinbase balance: %s expected %s", after, tips)
+	}
+
+	// the sender can't pay the gas limit at the fee cap
+	simulation.GasLimit = 1e9
+	if _, err := sim.Simulate(context.Background(), simulation, nil); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected ErrInsufficientBalance got: %v", err)
+	}
+}
+
+func TestSimulateTimeout(t *testing.T) {
+	// infinite loop: JUMPDEST PUSH0 JUMP
+	code := []byte{byte(vm.JUMPDEST), byte(vm.PUSH0), byte(vm.JUMP)}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithSimulationTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		Code:        code,
+		BlockNumber: big.NewInt(1),
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	start := time.Now()
+	_, err = sim.Simulate(context.Background(), simulation, nil)
+	if !errors.Is(err, ErrSimulationTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrSimulationTimeout got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("simulation took %s to time out", elapsed)
+	}
+}
+
+func TestSimulateSetCodeDelegation(t *testing.T) {
+	// returns the address executing the code
+	implCode := []byte{
+		byte(vm.ADDRESS),
+		byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), byte(0x20), byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	var (
+		authority = common.HexToAddress("0x000000000000000000000000000000000000a0a0")
+		impl      = common.HexToAddress("0x000000000000000000000000000000000000b0b0")
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+
+			if addr == impl {
+				return hexutil.Bytes(implCode), nil
+			}
+
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          authority,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+		SetCodeDelegations: []CodeDelegation{
+			{Authority: authority, ImplementationAddress: impl, Nonce: 3},
+		},
+	}
+
+	stateDB, err := sim.NewRemoteState(context.Background(), simulation.BlockNumber, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, stateDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := common.BytesToAddress(result.ReturnedData); got != authority {
+		t.Fatalf("executing address: %s expected: %s", got.Hex(), authority.Hex())
+	}
+
+	if got := stateDB.GetCode(authority); !bytes.Equal(got, vm.AddressToDelegation(impl)) {
+		t.Fatalf("authority code: %x", got)
+	}
+
+	if nonce := stateDB.GetNonce(authority); nonce != 4 {
+		t.Fatalf("authority nonce: %d expected: 4", nonce)
+	}
+
+	// the sender, the authority and the implementation, each fetched once
+	if calls := srv.Calls("eth_getCode"); calls != 3 {
+		t.Fatalf("eth_getCode called %d times expected 3", calls)
+	}
+}
+
+func TestSimulateReadOnly(t *testing.T) {
+	// stores 1 in slot 0
+	code := []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH0), byte(vm.SSTORE), byte(vm.STOP)}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return common.Hash{}.Hex(), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		Code:        code,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	_, err = sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation.ReadOnly = true
+	_, err = sim.Simulate(context.Background(), simulation, nil)
+	if !errors.Is(err, corevm.ErrWriteProtection) {
+		t.Fatalf("expected write protection error got: %v", err)
+	}
+}
+
+func TestSimulateRetries(t *testing.T) {
+	returnCode := []byte{byte(vm.PUSH1), 0x2a, byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN)}
+	revertCode := []byte{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.REVERT)}
+
+	tests := []struct {
+		name     string
+		code     []byte
+		failures int
+		retries  int
+		calls    int
+		err      error
+		reverted bool
+	}{
+		// the code of the sender is fetched first, then the one of the contract
+		{name: "succeeds on third attempt", code: returnCode, failures: 2, retries: 2, calls: 4},
+		{name: "runs out of retries", code: returnCode, failures: 2, retries: 1, calls: 2, err: rpc.ErrRPCFetch},
+		{name: "reverts are not retried", code: revertCode, retries: 3, calls: 2, reverted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failures int
+			srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+				switch method {
+				case "eth_getBalance", "eth_getTransactionCount":
+					return "0x0", nil
+				case "eth_getCode":
+				default:
+					return nil, errors.New("unexpected method " + method)
+				}
+
+				if failures < tt.failures {
+					failures++
+					return nil, errors.New("rate limited")
+				}
+
+				return hexutil.Bytes(tt.code), nil
+			})
+
+			sim, err := NewSimulator(rpc.NewClient(srv.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			simulation := Simulation{
+				From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+				To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+				BlockNumber: big.NewInt(1),
+				GasLimit:    300000,
+				GasPrice:    big.NewInt(0),
+				Value:       big.NewInt(0),
+				MaxRetries:  tt.retries,
+			}
+
+			result, err := sim.Simulate(context.Background(), simulation, nil)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v got: %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if tt.reverted {
+				if result.Status != types.ReceiptStatusFailed {
+					t.Fatalf("status: %d expected failed", result.Status)
+				}
+			} else if new(big.Int).SetBytes(result.ReturnedData).Int64() != 0x2a {
+				t.Fatalf("returned data: %x", result.ReturnedData)
+			}
+
+			if calls := srv.Calls("eth_getCode"); calls != tt.calls {
+				t.Fatalf("eth_getCode called %d times expected %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestGenerateAccessList(t *testing.T) {
+	// SLOAD(1) STOP
+	code := []byte{byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.STOP)}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return common.Hash{}.Hex(), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          to,
+		Code:        code,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	accessList, record, err := sim.GenerateAccessList(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := types.AccessList{{Address: to, StorageKeys: []common.Hash{common.BigToHash(common.Big1)}}}
+	if !reflect.DeepEqual(accessList, expected) {
+		t.Fatalf("access list: %+v expected: %+v", accessList, expected)
+	}
+
+	if _, ok := record.Get(to.Hex() + ":" + common.BigToHash(common.Big1).Hex()); !ok {
+		t.Fatal("slot missing from the record")
+	}
+
+	// only one pass was run
+	if calls := srv.Calls("eth_getStorageAt"); calls != 1 {
+		t.Fatalf("eth_getStorageAt called %d times expected 1", calls)
+	}
+}
+
+func TestSimulateBalanceOpcodes(t *testing.T) {
+	holder := common.HexToAddress("0x0000000000000000000000000000000000000022")
+
+	// BALANCE(holder) PUSH0 MSTORE SELFBALANCE PUSH1 0x20 MSTORE RETURN(0, 64)
+	code := []byte{byte(vm.PUSH20)}
+	code = append(code, holder.Bytes()...)
+	code = append(code,
+		byte(vm.BALANCE), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.SELFBALANCE), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH0), byte(vm.RETURN),
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getBalance":
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == holder {
+				return "0x7", nil
+			}
+			return "0x9", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := new(big.Int).SetBytes(result.ReturnedData[:32]).Int64(); got != 7 {
+		t.Fatalf("balance: %d expected 7", got)
+	}
+
+	if got := new(big.Int).SetBytes(result.ReturnedData[32:]).Int64(); got != 9 {
+		t.Fatalf("self balance: %d expected 9", got)
+	}
+
+	// the balances of the sender, the contract and the holder, each fetched once
+	if calls := srv.Calls("eth_getBalance"); calls != 3 {
+		t.Fatalf("eth_getBalance called %d times expected 3", calls)
+	}
+}
+
+func TestSimulateEvents(t *testing.T) {
+	topic := common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+	// LOG1(0, 32, topic) with 42 as data, LOG0 of nothing, then STOP
+	code := []byte{byte(vm.PUSH1), 0x2a, byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH32)}
+	code = append(code, topic.Bytes()...)
+	code = append(code,
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.LOG1),
+		byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.LOG0),
+		byte(vm.STOP),
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber: big.NewInt(7),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Events) != 2 {
+		t.Fatalf("events: %d expected 2", len(result.Events))
+	}
+
+	first := result.Events[0]
+	if first.Address != simulation.To || len(first.Topics) != 1 || first.Topics[0] != topic {
+		t.Fatalf("unexpected event: %+v", first)
+	}
+
+	if new(big.Int).SetBytes(first.Data).Int64() != 42 || first.BlockNumber != 7 {
+		t.Fatalf("data: %x block: %d", first.Data, first.BlockNumber)
+	}
+
+	if len(result.Events[1].Topics) != 0 {
+		t.Fatalf("unexpected topics: %v", result.Events[1].Topics)
+	}
+}
+
+func TestSimulateRemoteState(t *testing.T) {
+	var (
+		from    = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		counter = common.HexToAddress("0x0000000000000000000000000000000000000011")
+	)
+
+	// returns slot 0 without calldata, zeroes it otherwise
+	code := hexutil.Bytes{
+		byte(corevm.CALLDATASIZE), byte(corevm.PUSH1), 0x0c, byte(corevm.JUMPI),
+		byte(corevm.PUSH0), byte(corevm.SLOAD), byte(corevm.PUSH0), byte(corevm.MSTORE),
+		byte(corevm.PUSH1), 0x20, byte(corevm.PUSH0), byte(corevm.RETURN),
+		byte(corevm.JUMPDEST), byte(corevm.PUSH0), byte(corevm.PUSH0), byte(corevm.SSTORE), byte(corevm.STOP),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == counter {
+				return code, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getStorageAt":
+			return common.BigToHash(big.NewInt(10)), nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var executions atomic.Int32
+	simulation := func(input []byte) Simulation {
+		return Simulation{
+			From:        from,
+			To:          counter,
+			Input:       input,
+			BlockNumber: big.NewInt(1),
+			GasLimit:    100000,
+			GasPrice:    big.NewInt(0),
+			Value:       big.NewInt(0),
+			Tracer: &tracing.Hooks{
+				OnEnter: func(depth int, _ byte, _, _ common.Address, _ []byte, _ uint64, _ *big.Int) {
+					if depth == 0 {
+						executions.Add(1)
+					}
+				},
+			},
+		}
+	}
+
+	stateDB, err := sim.NewRemoteState(context.Background(), big.NewInt(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation(nil), stateDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := new(big.Int).SetBytes(result.ReturnedData); value.Int64() != 10 {
+		t.Fatalf("slot: %s expected 10", value)
+	}
+
+	if n := executions.Load(); n != 1 {
+		t.Fatalf("executed %d times expected once", n)
+	}
+
+	// the slot zeroed by the second transaction isn't fetched again by the third
+	results, err := sim.SimulateBundle(context.Background(), []Simulation{simulation(nil), simulation([]byte{1}), simulation(nil)}, stateDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []int64{10, 0, 0} {
+		if value := new(big.Int).SetBytes(results[i].ReturnedData); value.Int64() != expected {
+			t.Fatalf("slot after %d: %s expected %d", i, value, expected)
+		}
+	}
+
+	if n := executions.Load(); n != 4 {
+		t.Fatalf("executed %d times expected 4", n)
+	}
+
+	if n := srv.Calls("eth_getStorageAt"); n != 1 {
+		t.Fatalf("storage fetched %d times expected once", n)
+	}
+}
+
+// mapProvider is a StateProvider serving the code and storage of its maps, the
+// accounts have no balance nor nonce.
+type mapProvider struct {
+	code    map[common.Address][]byte
+	storage map[common.Address]common.Hash
+}
+
+func (p *mapProvider) GetCode(_ context.Context, address, _ string) ([]byte, error) {
+	return p.code[common.HexToAddress(address)], nil
+}
+
+func (p *mapProvider) GetStorageAt(_ context.Context, address, _, _ string) (common.Hash, error) {
+	return p.storage[common.HexToAddress(address)], nil
+}
+
+func (p *mapProvider) GetBalance(context.Context, string, string) (*big.Int, error) {
+	return new(big.Int), nil
+}
+
+func (p *mapProvider) GetNonce(context.Context, string, string) (uint64, error) {
+	return 0, nil
+}
+
+func (p *mapProvider) GetBlockHash(context.Context, string) (common.Hash, error) {
+	return common.Hash{}, nil
+}
+
+func TestSimulateStateProvider(t *testing.T) {
+	var (
+		from     = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+	)
+
+	provider := &mapProvider{
+		// returns slot 0
+		code: map[common.Address][]byte{contract: {
+			byte(corevm.PUSH0), byte(corevm.SLOAD), byte(corevm.PUSH0), byte(corevm.MSTORE),
+			byte(corevm.PUSH1), 0x20, byte(corevm.PUSH0), byte(corevm.RETURN),
+		}},
+		storage: map[common.Address]common.Hash{contract: common.BigToHash(big.NewInt(42))},
+	}
+
+	// the node serves no state
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return "0x", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithStateProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteState, err := sim.NewRemoteState(context.Background(), big.NewInt(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, stateDB := range map[string]*state.StateDB{"new state": nil, "remote state": remoteState} {
+		t.Run(name, func(t *testing.T) {
+			result, err := sim.Simulate(context.Background(), Simulation{
+				From:        from,
+				To:          contract,
+				BlockNumber: big.NewInt(1),
+				GasLimit:    100000,
+				GasPrice:    big.NewInt(0),
+				Value:       big.NewInt(0),
+			}, stateDB)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if value := new(big.Int).SetBytes(result.ReturnedData); value.Int64() != 42 {
+				t.Fatalf("slot: %s expected 42", value)
+			}
+		})
+	}
+
+	for _, method := range []string{"eth_getCode", "eth_getStorageAt", "eth_getBalance", "eth_getTransactionCount"} {
+		if n := srv.Calls(method); n != 0 {
+			t.Fatalf("%s requested %d times to the node", method, n)
+		}
+	}
+}
+
+func TestSimulateLoadedRecord(t *testing.T) {
+	var (
+		from     = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+	)
+
+	// returns slot 0 plus the balance of the sender
+	code := hexutil.Bytes{
+		byte(corevm.PUSH0), byte(corevm.SLOAD), byte(corevm.CALLER), byte(corevm.BALANCE), byte(corevm.ADD),
+		byte(corevm.PUSH0), byte(corevm.MSTORE), byte(corevm.PUSH1), 0x20, byte(corevm.PUSH0), byte(corevm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+			if addr == contract {
+				return code, nil
+			}
+
+			return hexutil.Bytes{}, nil
+		case "eth_getStorageAt":
+			return common.BigToHash(big.NewInt(10)), nil
+		case "eth_getBalance":
+			return "0x5", nil
+		case "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	nonce := uint64(0)
+	simulation := Simulation{
+		From:        from,
+		To:          contract,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    100000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+		Nonce:       &nonce,
+	}
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(result.Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a simulator without access to the state warmed up from the record
+	offline := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err = NewSimulator(rpc.NewClient(offline.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := new(runtime.RecordToInitiateState)
+	if err := json.Unmarshal(b, record); err != nil {
+		t.Fatal(err)
+	}
+
+	stateDB, err := sim.NewRemoteState(context.Background(), simulation.BlockNumber, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := sim.Simulate(context.Background(), simulation, stateDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(loaded.ReturnedData, result.ReturnedData) || loaded.GasUsed != result.GasUsed {
+		t.Fatalf("loaded record returned %x using %d gas, expected %x using %d", loaded.ReturnedData, loaded.GasUsed, result.ReturnedData, result.GasUsed)
+	}
+
+	if value := new(big.Int).SetBytes(loaded.ReturnedData); value.Int64() != 15 {
+		t.Fatalf("returned %s expected 15", value)
+	}
+
+	for _, method := range []string{"eth_getCode", "eth_getStorageAt", "eth_getBalance", "eth_getTransactionCount"} {
+		if n := offline.Calls(method); n != 0 {
+			t.Fatalf("%s requested %d times", method, n)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package simulator
+
+import (
+	"bytes"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// StateDiff holds the accounts modified by a simulation.
+type StateDiff map[common.Address]*AccountDiff
+
+// AccountDiff holds the changes of an account, unchanged fields are nil.
+type AccountDiff struct {
+	Balance *BalanceChange
+	Nonce   *NonceChange
+	Code    *CodeChange
+	Storage map[common.Hash]StorageChange
+	// Variables are the variables of the slots changed, by name, decoded with the
+	// storage layout of the account when the simulation has it
+	Variables []VariableChange
+}
+
+type BalanceChange struct {
+	Before *big.Int
+	After  *big.Int
+}
+
+type NonceChange struct {
+	Before uint64
+	After  uint64
+}
+
+type CodeChange struct {
+	Before []byte
+	After  []byte
+}
+
+type StorageChange struct {
+	Before common.Hash
+	After  common.Hash
+}
+
+// VariableChange is a variable of a storage slot with its values before and after.
+type VariableChange struct {
+	runtime.StorageVariable
+	Slot   common.Hash
+	Before string
+	After  string
+}
+
+// stateDiffTracker records the value of every account field and slot before its
+// first modification, changes reverted during the execution are dropped when
+// comparing with the final state.
+type stateDiffTracker struct {
+	stateDB  *state.StateDB
+	balances map[common.Address]*big.Int
+	nonces   map[common.Address]uint64
+	codes    map[common.Address][]byte
+	storage  map[common.Address]map[common.Hash]common.Hash
+}
+
+// trackStateDiff starts tracking the changes to stateDB when the simulation collects
+// its state diff, it returns nil otherwise. The nonces of the sender and of the
+// authorities of SetCodeDelegations, already set by the simulation, are tracked from
+// the nonces of the transaction. The balance of the sender is tracked from balance,
+// the one it's simulated with, when not zero.
+func trackStateDiff(stateDB *state.StateDB, simulation Simulation, balance *big.Int) *stateDiffTracker {
+	if !simulation.CollectStateDiff {
+		return nil
+	}
+
+	t := &stateDiffTracker{
+		stateDB:  stateDB,
+		balances: make(map[common.Address]*big.Int),
+		nonces:   make(map[common.Address]uint64),
+		codes:    make(map[common.Address][]byte),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash),
+	}
+
+	if simulation.Nonce != nil {
+		t.nonces[simulation.From] = *simulation.Nonce
+	}
+	for _, delegation := range simulation.SetCodeDelegations {
+		t.nonces[delegation.Authority] = delegation.Nonce
+	}
+	if balance.Sign() > 0 {
+		t.balances[simulation.From] = new(big.Int).Set(balance)
+	}
+
+	stateDB.SetLogger(&tracing.Hooks{
+		OnBalanceChange: func(addr common.Address, prev, _ *big.Int, _ tracing.BalanceChangeReason) {
+			if _, ok := t.balances[addr]; !ok {
+				t.balances[addr] = new(big.Int).Set(prev)
+			}
+		},
+		OnNonceChange: func(addr common.Address, prev, _ uint64) {
+			if _, ok := t.nonces[addr]; !ok {
+				t.nonces[addr] = prev
+			}
+		},
+		OnCodeChange: func(addr common.Address, _ common.Hash, prevCode []byte, _ common.Hash, _ []byte) {
+			if _, ok := t.codes[addr]; !ok {
+				t.codes[addr] = common.CopyBytes(prevCode)
+			}
+		},
+		OnStorageChange: func(addr common.Address, slot common.Hash, prev, _ common.Hash) {
+			slots, ok := t.storage[addr]
+			if !ok {
+				slots = make(map[common.Hash]common.Hash)
+				t.storage[addr] = slots
+			}
+			if _, ok := slots[slot]; !ok {
+				slots[slot] = prev
+			}
+		},
+	})
+
+	return t
+}
+
+// stop removes the hooks from the state.
+func (t *stateDiffTracker) stop() {
+	if t != nil {
+		t.stateDB.SetLogger(nil)
+	}
+}
+
+// diff stops tracking and compares the recorded values with the current state.
+func (t *stateDiffTracker) diff() StateDiff {
+	if t == nil {
+		return nil
+	}
+	t.stop()
+
+	diff := make(StateDiff)
+	account := func(addr common.Address) *AccountDiff {
+		if diff[addr] == nil {
+			diff[addr] = &AccountDiff{}
+		}
+		return diff[addr]
+	}
+
+	for addr, before := range t.balances {
+		if after := t.stateDB.GetBalance(addr).ToBig(); after.Cmp(before) != 0 {
+			account(addr).Balance = &BalanceChange{Before: before, After: after}
+		}
+	}
+
+	for addr, before := range t.nonces {
+		if after := t.stateDB.GetNonce(addr); after != before {
+			account(addr).Nonce = &NonceChange{Before: before, After: after}
+		}
+	}
+
+	for addr, before := range t.codes {
+		if after := t.stateDB.GetCode(addr); !bytes.Equal(after, before) {
+			account(addr).Code = &CodeChange{Before: before, After: common.CopyBytes(after)}
+		}
+	}
+
+	for addr, slots := range t.storage {
+		for slot, before := range slots {
+			after := t.stateDB.GetState(addr, slot)
+			if after == before {
+				continue
+			}
+
+			acc := account(addr)
+			if acc.Storage == nil {
+				acc.Storage = make(map[common.Hash]StorageChange)
+			}
+			acc.Storage[slot] = StorageChange{Before: before, After: after}
+		}
+	}
+
+	return diff
+}
+
+// decodeStorage decodes the slots changed of the accounts with a storage layout, the
+// slots are derived with preimages
+func (d StateDiff) decodeStorage(layouts map[common.Address]*runtime.StorageLayout, preimages map[common.Hash][]byte) {
+	for addr, acc := range d {
+		layout, ok := layouts[addr]
+		if !ok {
+			continue
+		}
+
+		for slot, change := range acc.Storage {
+			for _, variable := range layout.Decode(runtime.DecodeSlot(preimages, slot)) {
+				before, after := variable.Format(change.Before), variable.Format(change.After)
+				if before != after {
+					acc.Variables = append(acc.Variables, VariableChange{StorageVariable: variable, Slot: slot, Before: before, After: after})
+				}
+			}
+		}
+
+		slices.SortFunc(acc.Variables, func(a, b VariableChange) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestSimulateStateDiff(t *testing.T) {
+	// SLOAD(1), SSTORE(1, 42), SSTORE(2, 7), SSTORE(2, 0), STOP
+	code := []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0x01, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x07, byte(vm.PUSH1), 0x02, byte(vm.SSTORE),
+		byte(vm.PUSH0), byte(vm.PUSH1), 0x02, byte(vm.SSTORE),
+		byte(vm.STOP),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getStorageAt":
+			var slot string
+			if err := json.Unmarshal(params[1], &slot); err != nil {
+				return nil, err
+			}
+
+			if common.HexToHash(slot) == common.BigToHash(big.NewInt(1)) {
+				return common.BigToHash(big.NewInt(5)).Hex(), nil
+			}
+
+			return common.Hash{}.Hex(), nil
+		default:
+			return nil, errors.New("unexpected method " + method)
+		}
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:             common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:               common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber:      big.NewInt(7),
+		GasLimit:         300000,
+		GasPrice:         big.NewInt(0),
+		Value:            big.NewInt(0),
+		Nonce:            nonce(3),
+		CollectStateDiff: true,
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.StateDiff) != 2 {
+		t.Fatalf("state diff: %+v", result.StateDiff)
+	}
+
+	sender := result.StateDiff[simulation.From]
+	if sender == nil || sender.Nonce == nil || *sender.Nonce != (NonceChange{Before: 3, After: 4}) {
+		t.Fatalf("unexpected sender diff: %+v", sender)
+	}
+
+	contract := result.StateDiff[simulation.To]
+	if contract == nil || contract.Balance != nil || contract.Nonce != nil || contract.Code != nil {
+		t.Fatalf("unexpected contract diff: %+v", contract)
+	}
+
+	// slot 2 is restored to its value before the transaction
+	expected := StorageChange{Before: common.BigToHash(big.NewInt(5)), After: common.BigToHash(big.NewInt(42))}
+	if len(contract.Storage) != 1 || contract.Storage[common.BigToHash(big.NewInt(1))] != expected {
+		t.Fatalf("unexpected storage diff: %+v", contract.Storage)
+	}
+}
+
+func TestSimulateStateDiffStorageLayout(t *testing.T) {
+	// balances[msg.sender] = 42
+	code := []byte{
+		byte(vm.CALLER), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x2a,
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH0), byte(vm.KECCAK256),
+		byte(vm.SSTORE),
+		byte(vm.STOP),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		case "eth_getStorageAt":
+			return common.Hash{}.Hex(), nil
+		default:
+			return nil, errors.New("unexpected method " + method)
+		}
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := runtime.ParseStorageLayout([]byte(`{
+		"storage": [{"label": "balances", "offset": 0, "slot": "1", "type": "t_mapping(t_address,t_uint256)"}],
+		"types": {
+			"t_address": {"encoding": "inplace", "label": "address", "numberOfBytes": "20"},
+			"t_uint256": {"encoding": "inplace", "label": "uint256", "numberOfBytes": "32"},
+			"t_mapping(t_address,t_uint256)": {"encoding": "mapping", "key": "t_address", "label": "mapping(address => uint256)", "numberOfBytes": "32", "value": "t_uint256"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:             common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:               common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		BlockNumber:      big.NewInt(7),
+		GasLimit:         300000,
+		GasPrice:         big.NewInt(0),
+		Value:            big.NewInt(0),
+		CollectStateDiff: true,
+		StorageLayouts:   map[common.Address]*runtime.StorageLayout{common.HexToAddress("0x0000000000000000000000000000000000000011"): layout},
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contract := result.StateDiff[simulation.To]
+	if contract == nil || len(contract.Variables) != 1 {
+		t.Fatalf("unexpected contract diff: %+v", contract)
+	}
+
+	variable := contract.Variables[0]
+	if variable.Name != "balances["+simulation.From.Hex()+"]" || variable.Before != "0" || variable.After != "42" {
+		t.Fatalf("unexpected variable change: %+v", variable)
+	}
+
+	if len(result.StorageAccesses) != 1 || len(result.StorageAccesses[0].Variables) != 1 || result.StorageAccesses[0].Variables[0].Value != "42" {
+		t.Fatalf("unexpected storage accesses: %+v", result.StorageAccesses)
+	}
+}
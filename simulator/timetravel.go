@@ -0,0 +1,92 @@
+package simulator
+
+import (
+	"math/big"
+)
+
+// timeTravel moves the block context of a simulation forward
+type timeTravel struct {
+	seconds uint64
+	blocks  uint64
+	// operation is set on the simulations made by AdvanceTime and AdvanceBlocks
+	operation bool
+}
+
+// AdvanceTime returns an operation moving the timestamp of the next transactions of a
+// bundle seconds forward, as if they were included in a later block. It's placed in
+// the bundle between transactions, and has no result. The block number is unchanged,
+// see AdvanceBlocks.
+func AdvanceTime(seconds uint64) Simulation {
+	return Simulation{travel: &timeTravel{seconds: seconds, operation: true}}
+}
+
+// AdvanceBlocks returns an operation moving the block number of the next transactions
+// of a bundle n blocks forward, as AdvanceTime does with their timestamp. The state
+// is still the one of the fork at the block of the bundle, and the timestamp is
+// unchanged.
+func AdvanceBlocks(n uint64) Simulation {
+	return Simulation{travel: &timeTravel{blocks: n, operation: true}}
+}
+
+// bundleTransactions returns a copy of the simulations of a bundle without the
+// AdvanceTime and AdvanceBlocks operations, the transactions following them carry the
+// time travelled so far. The results of the bundle are the ones of its transactions.
+func bundleTransactions(simulations []Simulation) []Simulation {
+	var (
+		transactions = make([]Simulation, 0, len(simulations))
+		travelled    timeTravel
+	)
+	for _, simulation := range simulations {
+		if simulation.travel != nil && simulation.travel.operation {
+			travelled.seconds += simulation.travel.seconds
+			travelled.blocks += simulation.travel.blocks
+			continue
+		}
+
+		simulation.travel = nil
+		if travelled != (timeTravel{}) {
+			travel := travelled
+			simulation.travel = &travel
+		}
+		transactions = append(transactions, simulation)
+	}
+
+	return transactions
+}
+
+// apply moves the block context of simulation, already filled by withBlockContext,
+// forward through the block overrides
+func (t *timeTravel) apply(simulation Simulation) Simulation {
+	if t == nil || t.operation {
+		return simulation
+	}
+
+	overrides := BlockOverrides{}
+	if simulation.BlockOverrides != nil {
+		overrides = *simulation.BlockOverrides
+	}
+
+	if t.seconds > 0 {
+		timestamp := simulation.Timestamp
+		if overrides.Time != nil {
+			timestamp = *overrides.Time
+		}
+		timestamp += t.seconds
+		overrides.Time = &timestamp
+	}
+
+	if t.blocks > 0 {
+		number := overrides.Number
+		if number == nil {
+			number = simulation.BlockNumber
+		}
+		if number == nil {
+			number = new(big.Int)
+		}
+		overrides.Number = new(big.Int).Add(number, new(big.Int).SetUint64(t.blocks))
+	}
+
+	simulation.BlockOverrides = &overrides
+
+	return simulation
+}
@@ -0,0 +1,94 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestBundleTimeTravel(t *testing.T) {
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	// returns NUMBER and TIMESTAMP
+	code := hexutil.Bytes{
+		byte(vm.NUMBER), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.TIMESTAMP), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_blockNumber":
+			return "0x64", nil
+		case "eth_getBlockByNumber":
+			return map[string]interface{}{"number": "0x64", "timestamp": "0x3e8"}, nil
+		case "eth_getCode":
+			var addr common.Address
+			if err := json.Unmarshal(params[0], &addr); err != nil {
+				return nil, err
+			}
+
+			if addr == contract {
+				return code, nil
+			}
+			return hexutil.Bytes{}, nil
+		case "eth_getStorageAt":
+			return common.Hash{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := Simulation{
+		From:     common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:       contract,
+		GasLimit: 100000,
+		GasPrice: big.NewInt(0),
+		Value:    big.NewInt(0),
+	}
+	bundle := []Simulation{call, AdvanceTime(3600), call, AdvanceBlocks(10), AdvanceTime(60), call}
+	expected := [][2]uint64{{0x64, 1000}, {0x64, 4600}, {0x6e, 4660}}
+
+	ctx := context.Background()
+	for name, simulate := range map[string]func() ([]*SimulationResult, error){
+		"sequential": func() ([]*SimulationResult, error) { return sim.SimulateBundle(ctx, bundle, nil) },
+		"parallel": func() ([]*SimulationResult, error) {
+			return sim.SimulateBundleParallel(ctx, bundle, nil)
+		},
+	} {
+		results, err := simulate()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if len(results) != len(expected) {
+			t.Fatalf("%s: %d results expected %d", name, len(results), len(expected))
+		}
+
+		for i, result := range results {
+			ret := result.ReturnedData
+			number, timestamp := new(big.Int).SetBytes(ret[:32]).Uint64(), new(big.Int).SetBytes(ret[32:64]).Uint64()
+			if number != expected[i][0] || timestamp != expected[i][1] {
+				t.Fatalf("%s: transaction %d number %d timestamp %d expected %d %d", name, i, number, timestamp, expected[i][0], expected[i][1])
+			}
+		}
+	}
+
+	// the bundle of the caller isn't modified
+	if bundle[2].BlockOverrides != nil {
+		t.Fatal("bundle modified")
+	}
+}
@@ -0,0 +1,176 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/lru"
+)
+
+// TokenStandard is the token interface implemented by a contract.
+type TokenStandard int
+
+const (
+	TokenStandardUnknown TokenStandard = iota
+	TokenStandardERC20
+	TokenStandardERC721
+	TokenStandardERC1155
+	// TokenStandardNative is the native currency of the chain, ether on mainnet
+	TokenStandardNative
+)
+
+func (t TokenStandard) String() string {
+	switch t {
+	case TokenStandardERC20:
+		return "ERC20"
+	case TokenStandardERC721:
+		return "ERC721"
+	case TokenStandardERC1155:
+		return "ERC1155"
+	case TokenStandardNative:
+		return "native"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	// supportsInterface(bytes4) selector from EIP-165
+	supportsInterfaceSelector = hexutil.MustDecode("0x01ffc9a7")
+
+	erc721InterfaceID = hexutil.MustDecode("0x80ac58cd")
+	erc20InterfaceID  = hexutil.MustDecode("0x36372b07")
+)
+
+// InferTokenStandard asks the token through EIP-165 supportsInterface which standard
+// it implements, ERC-721 is checked first. Tokens not implementing EIP-165 are
+// reported as TokenStandardUnknown.
+func InferTokenStandard(ctx context.Context, clt *rpc.Client, token common.Address, blk string) (TokenStandard, error) {
+	supported, err := supportsInterface(ctx, clt, token, erc721InterfaceID, blk)
+	if err != nil {
+		return TokenStandardUnknown, err
+	}
+
+	if supported {
+		return TokenStandardERC721, nil
+	}
+
+	supported, err = supportsInterface(ctx, clt, token, erc20InterfaceID, blk)
+	if err != nil {
+		return TokenStandardUnknown, err
+	}
+
+	if supported {
+		return TokenStandardERC20, nil
+	}
+
+	return TokenStandardUnknown, nil
+}
+
+func supportsInterface(ctx context.Context, clt *rpc.Client, token common.Address, interfaceID []byte, blk string) (bool, error) {
+	// the interface id is left aligned in the 32 bytes word
+	input := make([]byte, 4+32)
+	copy(input, supportsInterfaceSelector)
+	copy(input[4:], interfaceID)
+
+	ret, err := clt.Call(ctx, token.Hex(), hexutil.Encode(input), blk)
+	if err != nil {
+		// contracts without supportsInterface revert the call
+		var rpcErr *rpc.ErrResponse
+		if errors.As(err, &rpcErr) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if len(ret) < 32 {
+		return false, nil
+	}
+
+	return new(big.Int).SetBytes(ret[:32]).Cmp(big.NewInt(1)) == 0, nil
+}
+
+// headersCacheSize bounds the headers kept by SimulationCache, enough for the 256
+// blocks reachable by BLOCKHASH
+const headersCacheSize = 1024
+
+// SimulationCache holds data that can be reused between simulations,
+// it's safe for concurrent use.
+type SimulationCache struct {
+	mu             sync.RWMutex
+	tokenStandards map[common.Address]TokenStandard
+	// headers by block, the least recently used are evicted
+	headers *lru.Cache[string, *rpc.BlockHeader]
+	fees    map[string]*NextBlockFees
+}
+
+func NewSimulationCache() *SimulationCache {
+	return &SimulationCache{
+		tokenStandards: make(map[common.Address]TokenStandard),
+		headers:        lru.NewCache[string, *rpc.BlockHeader](headersCacheSize),
+		fees:           make(map[string]*NextBlockFees),
+	}
+}
+
+// TokenStandard returns the standard implemented by token, only querying the fork
+// the first time the token is seen.
+func (c *SimulationCache) TokenStandard(ctx context.Context, clt *rpc.Client, token common.Address, blk string) (TokenStandard, error) {
+	c.mu.RLock()
+	standard, ok := c.tokenStandards[token]
+	c.mu.RUnlock()
+	if ok {
+		return standard, nil
+	}
+
+	standard, err := InferTokenStandard(ctx, clt, token, blk)
+	if err != nil {
+		return TokenStandardUnknown, err
+	}
+
+	c.mu.Lock()
+	c.tokenStandards[token] = standard
+	c.mu.Unlock()
+
+	return standard, nil
+}
+
+// BlockHeader returns the header of the block blk, only querying the fork the first
+// time the block is seen. The latest block is never cached.
+func (c *SimulationCache) BlockHeader(ctx context.Context, clt *rpc.Client, blk string) (*rpc.BlockHeader, error) {
+	header, ok := c.headers.Get(blk)
+	if ok {
+		return header, nil
+	}
+
+	header, err := clt.GetBlockByNumber(ctx, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	if blk != "" && blk != "latest" {
+		c.headers.Add(blk, header)
+	}
+
+	return header, nil
+}
+
+func (c *SimulationCache) nextBlockFees(blk string) (*NextBlockFees, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	fees, ok := c.fees[blk]
+	return fees, ok
+}
+
+func (c *SimulationCache) addNextBlockFees(blk string, fees *NextBlockFees) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fees[blk] = fees
+}
@@ -0,0 +1,89 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestInferTokenStandard(t *testing.T) {
+	var (
+		nft      = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+		fungible = common.HexToAddress("0x00000000000000000000000000000000000000a2")
+		legacy   = common.HexToAddress("0x00000000000000000000000000000000000000a3")
+		yes      = "0x0000000000000000000000000000000000000000000000000000000000000001"
+		no       = "0x0000000000000000000000000000000000000000000000000000000000000000"
+	)
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		if method != "eth_call" {
+			return nil, errors.New("unexpected method " + method)
+		}
+
+		var msg struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal(params[0], &msg); err != nil {
+			return nil, err
+		}
+
+		isERC721 := strings.HasPrefix(msg.Data, "0x01ffc9a780ac58cd")
+		isERC20 := strings.HasPrefix(msg.Data, "0x01ffc9a736372b07")
+		switch common.HexToAddress(msg.To) {
+		case nft:
+			if isERC721 {
+				return yes, nil
+			}
+			return no, nil
+		case fungible:
+			if isERC20 {
+				return yes, nil
+			}
+			return no, nil
+		default:
+			return nil, errors.New("execution reverted")
+		}
+	})
+
+	clt := rpc.NewClient(srv.URL)
+	cases := map[common.Address]TokenStandard{
+		nft:      TokenStandardERC721,
+		fungible: TokenStandardERC20,
+		legacy:   TokenStandardUnknown,
+	}
+
+	for token, expected := range cases {
+		standard, err := InferTokenStandard(context.Background(), clt, token, "0x1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if standard != expected {
+			t.Fatalf("token: %s standard: %s expected: %s", token.Hex(), standard, expected)
+		}
+	}
+
+	// the cache only queries the fork once per token
+	cache := NewSimulationCache()
+	calls := srv.Calls("eth_call")
+	for i := 0; i < 2; i++ {
+		standard, err := cache.TokenStandard(context.Background(), clt, fungible, "0x1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if standard != TokenStandardERC20 {
+			t.Fatalf("standard: %s expected: %s", standard, TokenStandardERC20)
+		}
+	}
+
+	if srv.Calls("eth_call")-calls != 2 {
+		t.Fatalf("eth_call requests: %d expected: 2", srv.Calls("eth_call")-calls)
+	}
+}
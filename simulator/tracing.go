@@ -0,0 +1,71 @@
+package simulator
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+// otelTracer emits the spans of the simulations with the global tracer provider,
+// a no-op until one is set with otel.SetTracerProvider. Simulate and SimulateBundle
+// have a span each, with a child per bundle item and per execution of the EVM.
+// The requests to the fork made meanwhile are children of the execution.
+var otelTracer = otel.Tracer("github.com/Gealber/evm-simulator/simulator")
+
+func simulationAttributes(simulation Simulation) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("simulation.from", simulation.From.Hex()),
+		attribute.String("simulation.to", simulation.To.Hex()),
+	}
+	if simulation.BlockNumber != nil {
+		attrs = append(attrs, attribute.String("simulation.block", simulation.BlockNumber.String()))
+	}
+
+	return attrs
+}
+
+// simulateBundleItem runs the simulation at index i of a bundle in a span of its own
+func (s *Simulator) simulateBundleItem(ctx context.Context, i int, simulation Simulation, stateDB *state.StateDB) (*SimulationResult, error) {
+	attrs := append(simulationAttributes(simulation), attribute.Int("bundle.index", i))
+	ctx, span := otelTracer.Start(ctx, "SimulateBundle.item", trace.WithAttributes(attrs...))
+
+	audit := rpc.AuditFromContext(ctx)
+	fetched := audit.Len()
+
+	result, err := s.simulate(ctx, simulation, stateDB)
+	endSimulationSpan(span, result, err)
+	if err == nil {
+		result.Fetches = audit.Fetches(fetched)
+	}
+
+	return result, err
+}
+
+// endSimulationSpan ends the span of a simulation with its result or its error,
+// reverts aren't errors of the span
+func endSimulationSpan(span trace.Span, result *SimulationResult, err error) {
+	if err == nil {
+		span.SetAttributes(
+			attribute.Bool("simulation.reverted", result.Status == types.ReceiptStatusFailed),
+			attribute.Int64("simulation.gas_used", int64(result.GasUsed)),
+		)
+	}
+
+	endSpan(span, err)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
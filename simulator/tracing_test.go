@@ -0,0 +1,132 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+// the tracers of the package are bound to the first global provider set, the
+// recorder is shared by the runs of the tests
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+func spanRecorder() *tracetest.SpanRecorder {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+
+	return recorder
+}
+
+// tracedSpans returns the spans ended in the trace of the last span named root
+func tracedSpans(recorder *tracetest.SpanRecorder, root string) []sdktrace.ReadOnlySpan {
+	ended := recorder.Ended()
+
+	var traceID trace.TraceID
+	for _, span := range ended {
+		if span.Name() == root {
+			traceID = span.SpanContext().TraceID()
+		}
+	}
+
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range ended {
+		if span.SpanContext().TraceID() == traceID {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	recorder := spanRecorder()
+
+	// loads slot 0
+	code := []byte{byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.STOP)}
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getStorageAt":
+			return common.Hash{}, nil
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			return hexutil.Bytes(code), nil
+		}
+
+		return nil, errors.New("unexpected method " + method)
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          common.HexToAddress("0x0000000000000000000000000000000000000011"),
+		Code:        code,
+		BlockNumber: big.NewInt(1),
+		Value:       big.NewInt(0),
+	}
+
+	if _, err := sim.Simulate(context.Background(), simulation, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range tracedSpans(recorder, "Simulate") {
+		spans[span.Name()] = span
+	}
+
+	if _, ok := spans["Simulate"]; !ok {
+		t.Fatalf("missing simulation span, spans: %v", spans)
+	}
+
+	// the slot is fetched by the execution
+	for name, parent := range map[string]string{"execute": "Simulate", "eth_getStorageAt": "execute"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("missing %s span", name)
+		}
+		if span.Parent().SpanID() != spans[parent].SpanContext().SpanID() {
+			t.Fatalf("parent of %s isn't %s", name, parent)
+		}
+	}
+
+	second := simulation
+	second.To = common.HexToAddress("0x0000000000000000000000000000000000000012")
+	if _, err := sim.SimulateBundle(context.Background(), []Simulation{simulation, second}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var items int
+	for _, span := range tracedSpans(recorder, "SimulateBundle") {
+		if span.Name() == "SimulateBundle.item" {
+			items++
+		}
+	}
+
+	// a single execution per item
+	if items != 2 {
+		t.Fatalf("bundle item spans: %d", items)
+	}
+}
@@ -0,0 +1,33 @@
+package simulator
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// upgradedTopic is the topic of the EIP-1967 Upgraded(address) event
+var upgradedTopic = crypto.Keccak256Hash([]byte("Upgraded(address)"))
+
+// UpgradeEvent is emitted by a proxy when its implementation changes.
+type UpgradeEvent struct {
+	Proxy             common.Address
+	NewImplementation common.Address
+}
+
+// ParseUpgradeEvents returns the Upgraded(address) events found in logs.
+func ParseUpgradeEvents(logs []*types.Log) []UpgradeEvent {
+	var events []UpgradeEvent
+	for _, l := range logs {
+		if len(l.Topics) != 2 || l.Topics[0] != upgradedTopic {
+			continue
+		}
+
+		events = append(events, UpgradeEvent{
+			Proxy:             l.Address,
+			NewImplementation: common.BytesToAddress(l.Topics[1].Bytes()),
+		})
+	}
+
+	return events
+}
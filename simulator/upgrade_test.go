@@ -0,0 +1,95 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestSimulateBundleUpgrade(t *testing.T) {
+	var (
+		proxy = common.HexToAddress("0x0000000000000000000000000000000000000b01")
+		impl  = common.HexToAddress("0x0000000000000000000000000000000000000b02")
+		// returns 42
+		implCode = []byte{
+			byte(vm.PUSH1), 42, byte(vm.PUSH0), byte(vm.MSTORE),
+			byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+		}
+	)
+
+	// with 32 bytes of calldata upgrades to the given implementation, otherwise
+	// delegates the call to the current implementation
+	proxyCode := []byte{
+		byte(vm.PUSH0), byte(vm.SLOAD),
+		byte(vm.CALLDATASIZE), byte(vm.PUSH1), 0x20, byte(vm.EQ), byte(vm.PUSH1), 0x16, byte(vm.JUMPI),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.DUP5), byte(vm.GAS), byte(vm.DELEGATECALL),
+		byte(vm.POP), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH0), byte(vm.CALLDATALOAD), byte(vm.DUP1), byte(vm.PUSH0), byte(vm.SSTORE),
+		byte(vm.PUSH32),
+	}
+	proxyCode = append(proxyCode, upgradedTopic.Bytes()...)
+	proxyCode = append(proxyCode, byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.LOG2), byte(vm.STOP))
+
+	srv := newMockRPC(t, func(method string, params []json.RawMessage) (interface{}, error) {
+		switch method {
+		case "eth_getBalance", "eth_getTransactionCount":
+			return "0x0", nil
+		case "eth_getCode":
+			var addr common.Address
+			json.Unmarshal(params[0], &addr)
+			if addr == impl {
+				return hexutil.Encode(implCode), nil
+			}
+			return "0x", nil
+		case "eth_getStorageAt":
+			return common.Hash{}.Hex(), nil
+		default:
+			return nil, errors.New("unexpected method " + method)
+		}
+	})
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          proxy,
+		Code:        proxyCode,
+		BlockNumber: big.NewInt(1),
+		GasLimit:    300000,
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+	upgrade, call := base, base
+	upgrade.Input = common.LeftPadBytes(impl.Bytes(), 32)
+
+	results, err := sim.SimulateBundle(context.Background(), []Simulation{upgrade, call}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := ParseUpgradeEvents(results[0].Events)
+	if len(events) != 1 || events[0].Proxy != proxy || events[0].NewImplementation != impl {
+		t.Fatalf("unexpected upgrade events: %+v", events)
+	}
+
+	if val := new(big.Int).SetBytes(results[1].ReturnedData); val.Cmp(big.NewInt(42)) != 0 {
+		t.Fatalf("value: %s expected: 42", val)
+	}
+
+	// the sender, the proxy and the implementation are fetched once, the state is
+	// shared by the transactions of the bundle
+	if n := srv.Calls("eth_getCode"); n != 3 {
+		t.Fatalf("eth_getCode requests: %d expected: 3", n)
+	}
+}
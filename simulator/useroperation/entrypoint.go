@@ -0,0 +1,126 @@
+package useroperation
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// EntryPointV06 is the address of the v0.6 EntryPoint, the same on every chain.
+var EntryPointV06 = common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+
+const entryPointJSON = `[
+	{"type":"function","name":"simulateValidation","inputs":[{"name":"userOp","type":"tuple","components":[
+		{"name":"sender","type":"address"},{"name":"nonce","type":"uint256"},{"name":"initCode","type":"bytes"},
+		{"name":"callData","type":"bytes"},{"name":"callGasLimit","type":"uint256"},{"name":"verificationGasLimit","type":"uint256"},
+		{"name":"preVerificationGas","type":"uint256"},{"name":"maxFeePerGas","type":"uint256"},{"name":"maxPriorityFeePerGas","type":"uint256"},
+		{"name":"paymasterAndData","type":"bytes"},{"name":"signature","type":"bytes"}]}],"outputs":[]},
+	{"type":"function","name":"simulateHandleOp","inputs":[{"name":"op","type":"tuple","components":[
+		{"name":"sender","type":"address"},{"name":"nonce","type":"uint256"},{"name":"initCode","type":"bytes"},
+		{"name":"callData","type":"bytes"},{"name":"callGasLimit","type":"uint256"},{"name":"verificationGasLimit","type":"uint256"},
+		{"name":"preVerificationGas","type":"uint256"},{"name":"maxFeePerGas","type":"uint256"},{"name":"maxPriorityFeePerGas","type":"uint256"},
+		{"name":"paymasterAndData","type":"bytes"},{"name":"signature","type":"bytes"}]},
+		{"name":"target","type":"address"},{"name":"targetCallData","type":"bytes"}],"outputs":[]},
+	{"type":"function","name":"balanceOf","inputs":[{"name":"account","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"error","name":"FailedOp","inputs":[{"name":"opIndex","type":"uint256"},{"name":"reason","type":"string"}]},
+	{"type":"error","name":"ValidationResult","inputs":[
+		{"name":"returnInfo","type":"tuple","components":[
+			{"name":"preOpGas","type":"uint256"},{"name":"prefund","type":"uint256"},{"name":"sigFailed","type":"bool"},
+			{"name":"validAfter","type":"uint48"},{"name":"validUntil","type":"uint48"},{"name":"paymasterContext","type":"bytes"}]},
+		{"name":"senderInfo","type":"tuple","components":[{"name":"stake","type":"uint256"},{"name":"unstakeDelaySec","type":"uint256"}]},
+		{"name":"factoryInfo","type":"tuple","components":[{"name":"stake","type":"uint256"},{"name":"unstakeDelaySec","type":"uint256"}]},
+		{"name":"paymasterInfo","type":"tuple","components":[{"name":"stake","type":"uint256"},{"name":"unstakeDelaySec","type":"uint256"}]}]},
+	{"type":"error","name":"ValidationResultWithAggregation","inputs":[
+		{"name":"returnInfo","type":"tuple","components":[
+			{"name":"preOpGas","type":"uint256"},{"name":"prefund","type":"uint256"},{"name":"sigFailed","type":"bool"},
+			{"name":"validAfter","type":"uint48"},{"name":"validUntil","type":"uint48"},{"name":"paymasterContext","type":"bytes"}]},
+		{"name":"senderInfo","type":"tuple","components":[{"name":"stake","type":"uint256"},{"name":"unstakeDelaySec","type":"uint256"}]},
+		{"name":"factoryInfo","type":"tuple","components":[{"name":"stake","type":"uint256"},{"name":"unstakeDelaySec","type":"uint256"}]},
+		{"name":"paymasterInfo","type":"tuple","components":[{"name":"stake","type":"uint256"},{"name":"unstakeDelaySec","type":"uint256"}]},
+		{"name":"aggregatorInfo","type":"tuple","components":[
+			{"name":"aggregator","type":"address"},
+			{"name":"stakeInfo","type":"tuple","components":[{"name":"stake","type":"uint256"},{"name":"unstakeDelaySec","type":"uint256"}]}]}]},
+	{"type":"error","name":"ExecutionResult","inputs":[
+		{"name":"preOpGas","type":"uint256"},{"name":"paid","type":"uint256"},{"name":"validAfter","type":"uint48"},
+		{"name":"validUntil","type":"uint48"},{"name":"targetSuccess","type":"bool"},{"name":"targetResult","type":"bytes"}]}
+]`
+
+var entryPointABI = mustParseABI(entryPointJSON)
+
+func mustParseABI(s string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+
+	return parsed
+}
+
+// StakeInfo is the stake of an entity in the EntryPoint.
+type StakeInfo struct {
+	Stake           *big.Int
+	UnstakeDelaySec *big.Int
+}
+
+// Staked reports whether the entity has a stake locked in the EntryPoint.
+func (s StakeInfo) Staked() bool {
+	return s.Stake != nil && s.Stake.Sign() > 0 && s.UnstakeDelaySec != nil && s.UnstakeDelaySec.Sign() > 0
+}
+
+type returnInfo struct {
+	PreOpGas         *big.Int
+	Prefund          *big.Int
+	SigFailed        bool
+	ValidAfter       *big.Int
+	ValidUntil       *big.Int
+	PaymasterContext []byte
+}
+
+type aggregatorStakeInfo struct {
+	Aggregator common.Address
+	StakeInfo  StakeInfo
+}
+
+// validationResult holds the arguments of ValidationResult, and of
+// ValidationResultWithAggregation when AggregatorInfo is set.
+type validationResult struct {
+	ReturnInfo     returnInfo
+	SenderInfo     StakeInfo
+	FactoryInfo    StakeInfo
+	PaymasterInfo  StakeInfo
+	AggregatorInfo aggregatorStakeInfo
+}
+
+type executionResult struct {
+	PreOpGas      *big.Int
+	Paid          *big.Int
+	ValidAfter    *big.Int
+	ValidUntil    *big.Int
+	TargetSuccess bool
+	TargetResult  []byte
+}
+
+type failedOp struct {
+	OpIndex *big.Int
+	Reason  string
+}
+
+// unpackError decodes data into out when it's the revert of the error name, it
+// reports false when the selector doesn't match.
+func unpackError(name string, data []byte, out interface{}) (bool, error) {
+	abiErr := entryPointABI.Errors[name]
+	if len(data) < 4 || !bytes.Equal(data[:4], abiErr.ID[:4]) {
+		return false, nil
+	}
+
+	values, err := abiErr.Inputs.Unpack(data[4:])
+	if err != nil {
+		return true, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return true, abiErr.Inputs.Copy(out, values)
+}
@@ -0,0 +1,268 @@
+package useroperation
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// Entity is the role of a contract during the validation of a user operation.
+type Entity string
+
+const (
+	EntityAccount   Entity = "account"
+	EntityFactory   Entity = "factory"
+	EntityPaymaster Entity = "paymaster"
+)
+
+// ViolationKind is the ERC-7562 rule broken by a violation.
+type ViolationKind int
+
+const (
+	// ViolationOpcode is the use of a banned opcode
+	ViolationOpcode ViolationKind = iota
+	// ViolationStorage is an access to storage not associated with the sender,
+	// nor owned by a staked entity
+	ViolationStorage
+)
+
+func (k ViolationKind) String() string {
+	if k == ViolationStorage {
+		return "storage"
+	}
+
+	return "opcode"
+}
+
+// Violation is a breach of the ERC-7562 validation rules.
+type Violation struct {
+	Kind   ViolationKind
+	Entity Entity
+	// Contract is the contract executing the opcode
+	Contract common.Address
+	Opcode   string
+	// Slot accessed, only for storage violations
+	Slot common.Hash
+}
+
+// bannedOpcodes can't be used during validation, their result differs between the
+// simulation and the inclusion of the operation (OP-011). GAS is allowed right
+// before a call (OP-012), CREATE2 once by the factory (OP-031).
+var bannedOpcodes = map[ourVm.OpCode]struct{}{
+	ourVm.GASPRICE:     {},
+	ourVm.GASLIMIT:     {},
+	ourVm.DIFFICULTY:   {},
+	ourVm.TIMESTAMP:    {},
+	ourVm.BASEFEE:      {},
+	ourVm.BLOCKHASH:    {},
+	ourVm.NUMBER:       {},
+	ourVm.SELFBALANCE:  {},
+	ourVm.BALANCE:      {},
+	ourVm.ORIGIN:       {},
+	ourVm.CREATE:       {},
+	ourVm.COINBASE:     {},
+	ourVm.SELFDESTRUCT: {},
+	ourVm.BLOBHASH:     {},
+	ourVm.BLOBBASEFEE:  {},
+	ourVm.INVALID:      {},
+}
+
+// associatedSlotRange is the largest offset from keccak256(address||x) of a slot
+// still associated with the address, as for the members of a struct in a mapping.
+const associatedSlotRange = 128
+
+type storageAccess struct {
+	entity   Entity
+	contract common.Address
+	opcode   ourVm.OpCode
+	slot     common.Hash
+}
+
+type pendingKeccak struct {
+	depth    int
+	preimage []byte
+}
+
+// rulesTracer checks the ERC-7562 rules on the frames of each entity called by the
+// EntryPoint. Storage accesses are kept aside, whether they are allowed depends on
+// the stakes of the entities, only known once the validation is over.
+type rulesTracer struct {
+	entryPoint common.Address
+	entities   map[common.Address]Entity
+	// frames holds the entity of every call frame, empty for the EntryPoint
+	frames []Entity
+
+	violations []Violation
+	storage    []storageAccess
+	creates    int
+	// pendingGas is set after GAS, it's a violation unless a call follows
+	pendingGas *Violation
+	// keccak preimages by their hash, used to find the slots associated with an address
+	keccak        map[common.Hash][]byte
+	pendingKeccak *pendingKeccak
+}
+
+func newRulesTracer(entryPoint common.Address, entities map[common.Address]Entity) *rulesTracer {
+	return &rulesTracer{
+		entryPoint: entryPoint,
+		entities:   entities,
+		keccak:     make(map[common.Hash][]byte),
+	}
+}
+
+func (t *rulesTracer) hooks() *tracing.Hooks {
+	return &tracing.Hooks{
+		OnEnter:  t.onEnter,
+		OnExit:   t.onExit,
+		OnOpcode: t.onOpcode,
+	}
+}
+
+func (t *rulesTracer) onEnter(_ int, _ byte, _ common.Address, to common.Address, _ []byte, _ uint64, _ *big.Int) {
+	entity := Entity("")
+	switch {
+	case len(t.frames) == 0:
+	case t.frames[len(t.frames)-1] != "":
+		// called by an entity, the rules of the entity apply
+		entity = t.frames[len(t.frames)-1]
+	default:
+		entity = t.entities[to]
+	}
+	t.frames = append(t.frames, entity)
+}
+
+func (t *rulesTracer) onExit(_ int, _ []byte, _ uint64, _ error, _ bool) {
+	if len(t.frames) > 0 {
+		t.frames = t.frames[:len(t.frames)-1]
+	}
+}
+
+func (t *rulesTracer) onOpcode(_ uint64, opcode byte, _, _ uint64, scope tracing.OpContext, _ []byte, depth int, _ error) {
+	op := ourVm.OpCode(opcode)
+	stack := scope.StackData()
+
+	if t.pendingKeccak != nil && t.pendingKeccak.depth == depth && len(stack) > 0 {
+		t.keccak[common.Hash(stack[len(stack)-1].Bytes32())] = t.pendingKeccak.preimage
+	}
+	t.pendingKeccak = nil
+
+	if t.pendingGas != nil {
+		if op != ourVm.CALL && op != ourVm.CALLCODE && op != ourVm.DELEGATECALL && op != ourVm.STATICCALL {
+			t.violations = append(t.violations, *t.pendingGas)
+		}
+		t.pendingGas = nil
+	}
+
+	if len(t.frames) == 0 || t.frames[len(t.frames)-1] == "" {
+		return
+	}
+	entity := t.frames[len(t.frames)-1]
+
+	violation := Violation{Kind: ViolationOpcode, Entity: entity, Contract: scope.Address(), Opcode: op.String()}
+	switch op {
+	case ourVm.GAS:
+		t.pendingGas = &violation
+	case ourVm.CREATE2:
+		t.creates++
+		if entity != EntityFactory || t.creates > 1 {
+			t.violations = append(t.violations, violation)
+		}
+	case ourVm.KECCAK256:
+		if len(stack) < 2 {
+			return
+		}
+		offset, size := stack[len(stack)-1], stack[len(stack)-2]
+		memory := scope.MemoryData()
+		if offset.IsUint64() && size.IsUint64() && offset.Uint64()+size.Uint64() <= uint64(len(memory)) {
+			start := offset.Uint64()
+			t.pendingKeccak = &pendingKeccak{depth: depth, preimage: common.CopyBytes(memory[start : start+size.Uint64()])}
+		}
+	case ourVm.SLOAD, ourVm.SSTORE, ourVm.TLOAD, ourVm.TSTORE:
+		if len(stack) < 1 || scope.Address() == t.entryPoint {
+			return
+		}
+		t.storage = append(t.storage, storageAccess{
+			entity:   entity,
+			contract: scope.Address(),
+			opcode:   op,
+			slot:     common.Hash(stack[len(stack)-1].Bytes32()),
+		})
+	default:
+		if _, ok := bannedOpcodes[op]; ok {
+			t.violations = append(t.violations, violation)
+		}
+	}
+}
+
+// result returns the violations found, sender is the account of the operation and
+// staked the addresses of the staked entities.
+func (t *rulesTracer) result(sender common.Address, staked map[common.Address]bool) []Violation {
+	violations := t.violations
+	if t.pendingGas != nil {
+		violations = append(violations, *t.pendingGas)
+	}
+
+	for _, access := range t.storage {
+		if t.storageAllowed(access, sender, staked) {
+			continue
+		}
+
+		violations = append(violations, Violation{
+			Kind:     ViolationStorage,
+			Entity:   access.entity,
+			Contract: access.contract,
+			Opcode:   access.opcode.String(),
+			Slot:     access.slot,
+		})
+	}
+
+	return violations
+}
+
+// storageAllowed applies the storage rules: the storage of the sender and the slots
+// associated with it can always be accessed, staked entities can also access their
+// own storage and the slots associated with them.
+func (t *rulesTracer) storageAllowed(access storageAccess, sender common.Address, staked map[common.Address]bool) bool {
+	if access.contract == sender || t.associated(access.slot, sender) {
+		return true
+	}
+
+	for addr, entity := range t.entities {
+		if entity != access.entity || !staked[addr] {
+			continue
+		}
+
+		if access.contract == addr || t.associated(access.slot, addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// associated reports whether slot is the address itself or keccak256(address||x)+n,
+// with n up to associatedSlotRange.
+func (t *rulesTracer) associated(slot common.Hash, addr common.Address) bool {
+	padded := common.BytesToHash(addr.Bytes())
+	if slot == padded {
+		return true
+	}
+
+	value := slot.Big()
+	for hash, preimage := range t.keccak {
+		if !bytes.HasPrefix(preimage, padded.Bytes()) {
+			continue
+		}
+
+		n := new(big.Int).Sub(value, hash.Big())
+		if n.Sign() >= 0 && n.Cmp(big.NewInt(associatedSlotRange)) <= 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,319 @@
+// Package useroperation simulates ERC-4337 user operations through the EntryPoint of
+// the fork, as a bundler does before accepting them.
+package useroperation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// simulationGasLimit is the gas given to each call to the EntryPoint
+const simulationGasLimit = 30_000_000
+
+var (
+	// ErrFailedOp is returned when the EntryPoint rejects the operation with FailedOp.
+	ErrFailedOp = errors.New("user operation failed")
+	// ErrUnexpectedResult is returned when the EntryPoint doesn't revert with the
+	// result of the simulation, as when there's no EntryPoint at the address.
+	ErrUnexpectedResult = errors.New("unexpected entry point result")
+)
+
+// UserOperation is the v0.6 ERC-4337 user operation.
+type UserOperation struct {
+	Sender               common.Address
+	Nonce                *big.Int
+	InitCode             []byte
+	CallData             []byte
+	CallGasLimit         *big.Int
+	VerificationGasLimit *big.Int
+	PreVerificationGas   *big.Int
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+	PaymasterAndData     []byte
+	Signature            []byte
+}
+
+// Factory returns the factory deploying the sender, the zero address without InitCode.
+func (op UserOperation) Factory() common.Address {
+	if len(op.InitCode) < common.AddressLength {
+		return common.Address{}
+	}
+
+	return common.BytesToAddress(op.InitCode[:common.AddressLength])
+}
+
+// Paymaster returns the paymaster of the operation, the zero address without one.
+func (op UserOperation) Paymaster() common.Address {
+	if len(op.PaymasterAndData) < common.AddressLength {
+		return common.Address{}
+	}
+
+	return common.BytesToAddress(op.PaymasterAndData[:common.AddressLength])
+}
+
+// withDefaults returns a copy of op with the missing numbers set to zero, as the
+// ABI encoding requires.
+func (op UserOperation) withDefaults() UserOperation {
+	for _, n := range []**big.Int{
+		&op.Nonce, &op.CallGasLimit, &op.VerificationGasLimit, &op.PreVerificationGas,
+		&op.MaxFeePerGas, &op.MaxPriorityFeePerGas,
+	} {
+		if *n == nil {
+			*n = new(big.Int)
+		}
+	}
+
+	return op
+}
+
+// Result is the outcome of simulating a user operation.
+type Result struct {
+	// PreOpGas is the gas used by the validation plus the PreVerificationGas
+	PreOpGas uint64
+	// ValidationGas is the gas used by the validation of the account, the factory
+	// and the paymaster
+	ValidationGas uint64
+	// CallGas is the gas used by the call of the account with CallData
+	CallGas uint64
+	// Prefund is the amount required from the account, or the paymaster, to pay for the operation
+	Prefund    *big.Int
+	SigFailed  bool
+	ValidAfter uint64
+	ValidUntil uint64
+	// SenderInfo, FactoryInfo and PaymasterInfo are the stakes of the entities
+	SenderInfo    StakeInfo
+	FactoryInfo   StakeInfo
+	PaymasterInfo StakeInfo
+	// Aggregator is the signature aggregator of the account, if any
+	Aggregator common.Address
+	// PaymasterDeposit is the deposit of the paymaster in the EntryPoint before the
+	// operation, nil without paymaster
+	PaymasterDeposit *big.Int
+	// ExecutionSuccess reports whether the call of the account succeeded, its
+	// returned data is in ExecutionResult
+	ExecutionSuccess bool
+	ExecutionResult  []byte
+	// Violations are the breaches of the ERC-7562 rules during the validation,
+	// bundlers drop operations violating them
+	Violations []Violation
+}
+
+// Violated reports whether the validation broke any of the ERC-7562 rules.
+func (r *Result) Violated() bool {
+	return len(r.Violations) > 0
+}
+
+// Simulator simulates user operations with a simulator.Simulator.
+type Simulator struct {
+	sim        *simulator.Simulator
+	entryPoint common.Address
+}
+
+// NewSimulator returns a Simulator using the v0.6 EntryPoint, unless changed with WithEntryPoint.
+func NewSimulator(sim *simulator.Simulator, opts ...func(*Simulator)) *Simulator {
+	s := &Simulator{sim: sim, entryPoint: EntryPointV06}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithEntryPoint sets the address of the EntryPoint, it must follow the v0.6 interface.
+func WithEntryPoint(entryPoint common.Address) func(*Simulator) {
+	return func(s *Simulator) {
+		s.entryPoint = entryPoint
+	}
+}
+
+// Simulate runs simulateValidation and simulateHandleOp of the EntryPoint on the state
+// of blockNumber. The validation is traced to find the opcodes and storage accesses
+// forbidden by ERC-7562. An operation rejected by the EntryPoint fails with ErrFailedOp.
+func (s *Simulator) Simulate(ctx context.Context, op UserOperation, blockNumber *big.Int) (*Result, error) {
+	op = op.withDefaults()
+
+	entities := map[common.Address]Entity{op.Sender: EntityAccount}
+	if factory := op.Factory(); factory != (common.Address{}) {
+		entities[factory] = EntityFactory
+	}
+	paymaster := op.Paymaster()
+	if paymaster != (common.Address{}) {
+		entities[paymaster] = EntityPaymaster
+	}
+
+	input, err := entryPointABI.Pack("simulateValidation", op)
+	if err != nil {
+		return nil, err
+	}
+
+	tracer := newRulesTracer(s.entryPoint, entities)
+	validation, err := s.call(ctx, input, blockNumber, func(sim *simulator.Simulation) {
+		sim.Tracer = tracer.hooks()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var validationRes validationResult
+	ok, err := unpackRevert(validation, &validationRes, "ValidationResult", "ValidationResultWithAggregation")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: simulateValidation returned %x", ErrUnexpectedResult, validation.ReturnedData)
+	}
+
+	info := validationRes.ReturnInfo
+	result := &Result{
+		PreOpGas:      info.PreOpGas.Uint64(),
+		Prefund:       info.Prefund,
+		SigFailed:     info.SigFailed,
+		ValidAfter:    info.ValidAfter.Uint64(),
+		ValidUntil:    info.ValidUntil.Uint64(),
+		SenderInfo:    validationRes.SenderInfo,
+		FactoryInfo:   validationRes.FactoryInfo,
+		PaymasterInfo: validationRes.PaymasterInfo,
+		Aggregator:    validationRes.AggregatorInfo.Aggregator,
+	}
+	if result.PreOpGas > op.PreVerificationGas.Uint64() {
+		result.ValidationGas = result.PreOpGas - op.PreVerificationGas.Uint64()
+	}
+
+	staked := map[common.Address]bool{
+		op.Sender:    result.SenderInfo.Staked(),
+		op.Factory(): result.FactoryInfo.Staked(),
+		paymaster:    result.PaymasterInfo.Staked(),
+	}
+	result.Violations = tracer.result(op.Sender, staked)
+
+	if paymaster != (common.Address{}) {
+		result.PaymasterDeposit, err = s.balanceOf(ctx, paymaster, blockNumber)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	input, err = entryPointABI.Pack("simulateHandleOp", op, common.Address{}, []byte{})
+	if err != nil {
+		return nil, err
+	}
+
+	execution, err := s.call(ctx, input, blockNumber, func(sim *simulator.Simulation) {
+		sim.CollectCallTrace = true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var executionRes executionResult
+	ok, err = unpackRevert(execution, &executionRes, "ExecutionResult")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: simulateHandleOp returned %x", ErrUnexpectedResult, execution.ReturnedData)
+	}
+
+	if frame := accountCall(execution.CallTrace, s.entryPoint, op.Sender, op.CallData); frame != nil {
+		result.CallGas = uint64(frame.GasUsed)
+		result.ExecutionSuccess = frame.Error == ""
+		result.ExecutionResult = frame.Output
+	}
+
+	return result, nil
+}
+
+// call simulates a call to the EntryPoint with input on a fresh state.
+func (s *Simulator) call(ctx context.Context, input []byte, blockNumber *big.Int, opts ...func(*simulator.Simulation)) (*simulator.SimulationResult, error) {
+	sim := simulator.Simulation{
+		To:          s.entryPoint,
+		BlockNumber: blockNumber,
+		GasLimit:    simulationGasLimit,
+		Value:       new(big.Int),
+		Input:       input,
+	}
+	for _, opt := range opts {
+		opt(&sim)
+	}
+
+	return s.sim.Simulate(ctx, sim, nil)
+}
+
+// balanceOf returns the deposit of account in the EntryPoint.
+func (s *Simulator) balanceOf(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	input, err := entryPointABI.Pack("balanceOf", account)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.call(ctx, input, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("balanceOf: %w", err)
+	}
+
+	values, err := entryPointABI.Unpack("balanceOf", result.ReturnedData)
+	if err != nil {
+		return nil, err
+	}
+
+	return values[0].(*big.Int), nil
+}
+
+// unpackRevert decodes the revert of result into out with the first matching error
+// of names. A FailedOp revert is returned as ErrFailedOp.
+func unpackRevert(result *simulator.SimulationResult, out interface{}, names ...string) (bool, error) {
+	if result.Status != types.ReceiptStatusFailed {
+		return false, nil
+	}
+
+	var failed failedOp
+	ok, err := unpackError("FailedOp", result.ReturnedData, &failed)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return false, fmt.Errorf("%w: %s", ErrFailedOp, failed.Reason)
+	}
+
+	for _, name := range names {
+		ok, err := unpackError(name, result.ReturnedData, out)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+// accountCall finds the last call from the EntryPoint to the account with callData,
+// the execution of the operation.
+func accountCall(frame *runtime.CallFrame, entryPoint, sender common.Address, callData []byte) *runtime.CallFrame {
+	if frame == nil {
+		return nil
+	}
+
+	var found *runtime.CallFrame
+	if frame.From == entryPoint && frame.To != nil && *frame.To == sender && bytes.Equal(frame.Input, callData) {
+		found = frame
+	}
+
+	for _, call := range frame.Calls {
+		if f := accountCall(call, entryPoint, sender, callData); f != nil {
+			found = f
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,243 @@
+package useroperation
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	"github.com/Gealber/evm-simulator/simulator"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+var (
+	sender    = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+	paymaster = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+	other     = common.HexToAddress("0x000000000000000000000000000000000000cccc")
+)
+
+// newMockRPC serves the code of contracts, any storage, balance and nonce read as
+// zero.
+func newMockRPC(t *testing.T, codes map[common.Address][]byte) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int               `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := map[string]interface{}{"id": req.ID, "jsonrpc": "2.0"}
+		switch req.Method {
+		case "eth_getCode":
+			var addr common.Address
+			json.Unmarshal(req.Params[0], &addr)
+			resp["result"] = hexutil.Bytes(codes[addr])
+		case "eth_getStorageAt":
+			resp["result"] = common.Hash{}
+		case "eth_getBalance", "eth_getTransactionCount":
+			resp["result"] = "0x0"
+		default:
+			resp["error"] = map[string]interface{}{"code": -32000, "message": "unexpected method " + req.Method}
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// call pushes the arguments of a CALL without data to addr, forwarding all the gas.
+func call(addr common.Address) []byte {
+	code := []byte{byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH20)}
+	code = append(code, addr.Bytes()...)
+
+	return append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP))
+}
+
+// revertWith reverts with data, appended at offset of the code.
+func revertWith(offset, size int) []byte {
+	return []byte{
+		byte(vm.PUSH2), byte(size >> 8), byte(size), byte(vm.PUSH2), byte(offset >> 8), byte(offset), byte(vm.PUSH0), byte(vm.CODECOPY),
+		byte(vm.PUSH2), byte(size >> 8), byte(size), byte(vm.PUSH0), byte(vm.REVERT),
+	}
+}
+
+// entryPointStub reverts simulateValidation with validation after calling the sender
+// and the paymaster, simulateHandleOp with execution after calling the sender, and
+// returns 7 for any other call.
+func entryPointStub(validation, execution []byte) []byte {
+	selector := func(name string) []byte { return entryPointABI.Methods[name].ID }
+
+	// dispatcher, the jump destinations are patched below
+	code := []byte{byte(vm.PUSH0), byte(vm.CALLDATALOAD), byte(vm.PUSH1), 0xe0, byte(vm.SHR)}
+	code = append(code, byte(vm.DUP1), byte(vm.PUSH4))
+	code = append(code, selector("simulateValidation")...)
+	validationJump := len(code) + 2
+	code = append(code, byte(vm.EQ), byte(vm.PUSH2), 0, 0, byte(vm.JUMPI))
+	code = append(code, byte(vm.DUP1), byte(vm.PUSH4))
+	code = append(code, selector("simulateHandleOp")...)
+	executionJump := len(code) + 2
+	code = append(code, byte(vm.EQ), byte(vm.PUSH2), 0, 0, byte(vm.JUMPI))
+	code = append(code, byte(vm.PUSH1), 0x07, byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN))
+
+	binary.BigEndian.PutUint16(code[validationJump:], uint16(len(code)))
+	code = append(code, byte(vm.JUMPDEST))
+	code = append(code, call(sender)...)
+	code = append(code, call(paymaster)...)
+	validationRevert := len(code)
+	code = append(code, revertWith(0, 0)...)
+
+	binary.BigEndian.PutUint16(code[executionJump:], uint16(len(code)))
+	code = append(code, byte(vm.JUMPDEST))
+	code = append(code, call(sender)...)
+	executionRevert := len(code)
+	code = append(code, revertWith(0, 0)...)
+
+	copy(code[validationRevert:], revertWith(len(code), len(validation)))
+	code = append(code, validation...)
+	copy(code[executionRevert:], revertWith(len(code), len(execution)))
+
+	return append(code, execution...)
+}
+
+func packError(t *testing.T, name string, args ...interface{}) []byte {
+	abiErr := entryPointABI.Errors[name]
+	data, err := abiErr.Inputs.Pack(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(abiErr.ID[:4], data...)
+}
+
+func TestSimulate(t *testing.T) {
+	validation := packError(t, "ValidationResult",
+		returnInfo{
+			PreOpGas:   big.NewInt(50000),
+			Prefund:    big.NewInt(1e15),
+			ValidAfter: big.NewInt(0),
+			ValidUntil: big.NewInt(1700000000),
+		},
+		StakeInfo{Stake: new(big.Int), UnstakeDelaySec: new(big.Int)},
+		StakeInfo{Stake: new(big.Int), UnstakeDelaySec: new(big.Int)},
+		StakeInfo{Stake: big.NewInt(1e18), UnstakeDelaySec: big.NewInt(86400)},
+	)
+	execution := packError(t, "ExecutionResult", big.NewInt(50000), new(big.Int), new(big.Int), new(big.Int), false, []byte{})
+
+	senderCode := []byte{
+		// banned
+		byte(vm.TIMESTAMP), byte(vm.POP),
+		// own storage
+		byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.POP),
+		// GAS right before a call is allowed
+		byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.PUSH20),
+	}
+	senderCode = append(senderCode, other.Bytes()...)
+	senderCode = append(senderCode, byte(vm.GAS), byte(vm.STATICCALL), byte(vm.POP), byte(vm.STOP))
+
+	otherCode := []byte{
+		// slot 5 isn't associated with the sender
+		byte(vm.PUSH1), 0x05, byte(vm.SLOAD), byte(vm.POP),
+		// keccak256(sender || 0) is
+		byte(vm.PUSH20),
+	}
+	otherCode = append(otherCode, sender.Bytes()...)
+	otherCode = append(otherCode,
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH0), byte(vm.PUSH1), 0x20, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x40, byte(vm.PUSH0), byte(vm.KECCAK256), byte(vm.PUSH1), 0x01, byte(vm.ADD), byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.STOP),
+	)
+
+	// the paymaster is staked, it can use its own storage
+	paymasterCode := []byte{byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.POP), byte(vm.STOP)}
+
+	srv := newMockRPC(t, map[common.Address][]byte{
+		EntryPointV06: entryPointStub(validation, execution),
+		sender:        senderCode,
+		paymaster:     paymasterCode,
+		other:         otherCode,
+	})
+
+	sim, err := simulator.NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := UserOperation{
+		Sender:             sender,
+		PreVerificationGas: big.NewInt(21000),
+		PaymasterAndData:   paymaster.Bytes(),
+	}
+
+	result, err := NewSimulator(sim).Simulate(context.Background(), op, big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.PreOpGas != 50000 || result.ValidationGas != 29000 || result.ValidUntil != 1700000000 {
+		t.Fatalf("pre op gas: %d validation gas: %d valid until: %d", result.PreOpGas, result.ValidationGas, result.ValidUntil)
+	}
+
+	if !result.PaymasterInfo.Staked() || result.SenderInfo.Staked() {
+		t.Fatalf("paymaster info: %+v sender info: %+v", result.PaymasterInfo, result.SenderInfo)
+	}
+
+	if result.PaymasterDeposit == nil || result.PaymasterDeposit.Int64() != 7 {
+		t.Fatalf("paymaster deposit: %v expected 7", result.PaymasterDeposit)
+	}
+
+	if result.CallGas == 0 || !result.ExecutionSuccess {
+		t.Fatalf("call gas: %d success: %t", result.CallGas, result.ExecutionSuccess)
+	}
+
+	expected := []Violation{
+		{Kind: ViolationOpcode, Entity: EntityAccount, Contract: sender, Opcode: "TIMESTAMP"},
+		{Kind: ViolationStorage, Entity: EntityAccount, Contract: other, Opcode: "SLOAD", Slot: common.BigToHash(big.NewInt(5))},
+	}
+
+	if len(result.Violations) != len(expected) {
+		t.Fatalf("violations: %+v", result.Violations)
+	}
+
+	for i, violation := range result.Violations {
+		if violation != expected[i] {
+			t.Fatalf("violation %d: %+v expected %+v", i, violation, expected[i])
+		}
+	}
+}
+
+func TestSimulateFailedOp(t *testing.T) {
+	failed := packError(t, "FailedOp", new(big.Int), "AA21 didn't pay prefund")
+
+	srv := newMockRPC(t, map[common.Address][]byte{
+		EntryPointV06: entryPointStub(failed, failed),
+	})
+
+	sim, err := simulator.NewSimulator(rpc.NewClient(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewSimulator(sim).Simulate(context.Background(), UserOperation{Sender: sender}, big.NewInt(100))
+	if !errors.Is(err, ErrFailedOp) {
+		t.Fatalf("expected ErrFailedOp got: %v", err)
+	}
+
+	// no entry point deployed
+	_, err = NewSimulator(sim, WithEntryPoint(other)).Simulate(context.Background(), UserOperation{Sender: sender}, big.NewInt(100))
+	if !errors.Is(err, ErrUnexpectedResult) {
+		t.Fatalf("expected ErrUnexpectedResult got: %v", err)
+	}
+}
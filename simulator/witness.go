@@ -0,0 +1,310 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	ourVm "github.com/Gealber/evm-simulator/vm"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// ErrNotInWitness is returned by the state provider of a Witness for the state it
+// doesn't hold.
+var ErrNotInWitness = errors.New("state not in witness")
+
+// Witness holds the state a simulation read from the fork with its proofs, and the
+// header of the block, so the simulation can be verified and run again without the
+// node, see Witness.Simulator. Block hashes read by BLOCKHASH, but the one of the
+// block, aren't part of it.
+type Witness struct {
+	Header *types.Header `json:"header"`
+	// ChainID selects the chain configuration, nil for the runtime defaults
+	ChainID *hexutil.Big `json:"chainId,omitempty"`
+	// Accounts are the proofs of the accounts read, with the proofs of their slots read
+	Accounts []*rpc.AccountResult `json:"accounts"`
+	// Codes are the codes of the accounts, by hash
+	Codes map[common.Hash]hexutil.Bytes `json:"codes"`
+}
+
+// Witness fetches the witness of the state read by the simulation that returned
+// result, with the proofs of the accounts and slots it recorded, the sender and the
+// recipient. The state provider must fetch proofs, as the RPC client does.
+func (s *Simulator) Witness(ctx context.Context, simulation Simulation, result *SimulationResult) (*Witness, error) {
+	provider, ok := s.stateProvider().(ourVm.ProofProvider)
+	if !ok {
+		return nil, errors.New("the state provider can't fetch proofs for the witness")
+	}
+
+	blk := ""
+	if simulation.BlockNumber != nil && simulation.BlockNumber.Sign() > 0 {
+		blk = "0x" + simulation.BlockNumber.Text(16)
+	}
+
+	header, err := s.RPCClt.GetHeader(ctx, blk)
+	if err != nil {
+		return nil, err
+	}
+	// the proofs are of the same block, even when it's the latest one
+	blk = "0x" + header.Number.Text(16)
+
+	batch, err := provider.GetProofBatch(ctx, witnessState(simulation, result), blk)
+	if err != nil {
+		return nil, err
+	}
+
+	witness := &Witness{
+		Header:   header,
+		Accounts: batch.Accounts,
+		Codes:    make(map[common.Hash]hexutil.Bytes),
+	}
+	if chainConfig := s.simulationChainConfig(simulation); chainConfig != nil {
+		witness.ChainID = (*hexutil.Big)(chainConfig.ChainID)
+	}
+	for _, account := range batch.Accounts {
+		if len(account.Code) > 0 {
+			witness.Codes[crypto.Keccak256Hash(account.Code)] = account.Code
+		}
+	}
+
+	// a witness that doesn't verify is useless to anyone else
+	if err := witness.Verify(); err != nil {
+		return nil, err
+	}
+
+	return witness, nil
+}
+
+// witnessState returns the accounts and slots the simulation read from the fork
+func witnessState(simulation Simulation, result *SimulationResult) map[common.Address][]common.Hash {
+	state := map[common.Address][]common.Hash{
+		simulation.From: nil,
+		simulation.To:   nil,
+	}
+
+	record := result.Record
+	if record == nil {
+		return state
+	}
+
+	for addr := range record.AddressCodeSet {
+		state[addr] = nil
+	}
+	for addr := range record.AddressBalanceSet {
+		state[addr] = nil
+	}
+	record.RangeStorage(func(key string, _ common.Hash) bool {
+		addr, slot, ok := strings.Cut(key, ":")
+		if ok {
+			state[common.HexToAddress(addr)] = append(state[common.HexToAddress(addr)], common.HexToHash(slot))
+		}
+		return true
+	})
+	for _, tuple := range record.AccessList {
+		state[tuple.Address] = append(state[tuple.Address], tuple.StorageKeys...)
+	}
+
+	// the proofs are requested in a stable order, without duplicates
+	for addr, slots := range state {
+		sort.Slice(slots, func(i, j int) bool {
+			return bytes.Compare(slots[i][:], slots[j][:]) < 0
+		})
+		state[addr] = compactSlots(slots)
+	}
+
+	return state
+}
+
+func compactSlots(slots []common.Hash) []common.Hash {
+	out := slots[:0]
+	for i, slot := range slots {
+		if i == 0 || slot != slots[i-1] {
+			out = append(out, slot)
+		}
+	}
+
+	return out
+}
+
+// Verify checks the accounts, codes and slots of the witness against the state root
+// of its header. The header itself must be checked against a trusted hash of the
+// block, computed with Header.Hash.
+func (w *Witness) Verify() error {
+	if w.Header == nil {
+		return errors.New("witness without header")
+	}
+
+	for _, account := range w.Accounts {
+		if err := w.withCode(account).Verify(w.Header.Root); err != nil {
+			return fmt.Errorf("%w: %w", ourVm.ErrInvalidProof, err)
+		}
+	}
+
+	return nil
+}
+
+// withCode returns account with its code, the one of the witness
+func (w *Witness) withCode(account *rpc.AccountResult) *rpc.AccountResult {
+	cpy := *account
+	cpy.Code = w.Codes[account.CodeHash]
+
+	return &cpy
+}
+
+// Simulator verifies the witness and returns a simulator running the simulations at
+// its block on the state it holds, without any node: the state missing from the
+// witness fails with ErrNotInWitness, and every other request to the node fails. The
+// options are applied after the chain configuration and the state provider of the
+// witness.
+func (w *Witness) Simulator(opts ...func(*Simulator)) (*Simulator, error) {
+	provider, err := w.StateProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	var chainConfig *params.ChainConfig
+	if w.ChainID != nil {
+		chainConfig, _ = runtime.ChainConfigByID(w.ChainID.ToInt())
+	}
+
+	opts = append([]func(*Simulator){WithChainConfig(chainConfig), WithStateProvider(provider)}, opts...)
+	s, err := NewSimulator(rpc.NewReplayClient(rpc.NewFixture()), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the block context is the one of the header
+	s.Cache.headers.Add("0x"+w.Header.Number.Text(16), &rpc.BlockHeader{
+		Number:        (*hexutil.Big)(w.Header.Number),
+		Hash:          w.Header.Hash(),
+		ParentHash:    w.Header.ParentHash,
+		StateRoot:     w.Header.Root,
+		Miner:         w.Header.Coinbase,
+		Timestamp:     hexutil.Uint64(w.Header.Time),
+		GasLimit:      hexutil.Uint64(w.Header.GasLimit),
+		BaseFee:       (*hexutil.Big)(w.Header.BaseFee),
+		Difficulty:    (*hexutil.Big)(w.Header.Difficulty),
+		MixHash:       w.Header.MixDigest,
+		ExcessBlobGas: (*hexutil.Uint64)(w.Header.ExcessBlobGas),
+	})
+
+	return s, nil
+}
+
+// StateProvider verifies the witness and returns a provider answering the state it
+// holds. The state missing from the witness fails with ErrNotInWitness.
+func (w *Witness) StateProvider() (ourVm.StateProvider, error) {
+	if err := w.Verify(); err != nil {
+		return nil, err
+	}
+
+	provider := &witnessProvider{
+		number:   w.Header.Number,
+		hash:     w.Header.Hash(),
+		accounts: make(map[common.Address]*rpc.AccountResult, len(w.Accounts)),
+	}
+	for _, account := range w.Accounts {
+		provider.accounts[account.Address] = w.withCode(account)
+	}
+
+	return provider, nil
+}
+
+// witnessProvider answers the state of a verified witness
+type witnessProvider struct {
+	number   *big.Int
+	hash     common.Hash
+	accounts map[common.Address]*rpc.AccountResult
+}
+
+func (p *witnessProvider) account(address, blk string) (*rpc.AccountResult, error) {
+	number, ok := new(big.Int).SetString(strings.TrimPrefix(blk, "0x"), 16)
+	if ok && number.Sign() > 0 && number.Cmp(p.number) != 0 {
+		return nil, fmt.Errorf("%w: block %s", ErrNotInWitness, blk)
+	}
+
+	account, ok := p.accounts[common.HexToAddress(address)]
+	if !ok {
+		return nil, fmt.Errorf("%w: account %s", ErrNotInWitness, address)
+	}
+
+	return account, nil
+}
+
+func (p *witnessProvider) GetCode(ctx context.Context, address, blk string) ([]byte, error) {
+	account, err := p.account(address, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.Code, nil
+}
+
+func (p *witnessProvider) GetBalance(ctx context.Context, address, blk string) (*big.Int, error) {
+	account, err := p.account(address, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Balance == nil {
+		return new(big.Int), nil
+	}
+
+	return new(big.Int).Set(account.Balance.ToInt()), nil
+}
+
+func (p *witnessProvider) GetNonce(ctx context.Context, address, blk string) (uint64, error) {
+	account, err := p.account(address, blk)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(account.Nonce), nil
+}
+
+func (p *witnessProvider) GetStorageAt(ctx context.Context, address, position, blk string) (common.Hash, error) {
+	account, err := p.account(address, blk)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	slot := common.HexToHash(position)
+	for _, storage := range account.StorageProof {
+		if common.HexToHash(storage.Key) != slot {
+			continue
+		}
+
+		if storage.Value == nil {
+			return common.Hash{}, nil
+		}
+
+		return common.BigToHash(storage.Value.ToInt()), nil
+	}
+
+	// every slot of an account without storage is empty
+	if account.StorageHash == types.EmptyRootHash || account.StorageHash == (common.Hash{}) {
+		return common.Hash{}, nil
+	}
+
+	return common.Hash{}, fmt.Errorf("%w: slot %s of %s", ErrNotInWitness, slot.Hex(), address)
+}
+
+func (p *witnessProvider) GetBlockHash(ctx context.Context, blk string) (common.Hash, error) {
+	number, ok := new(big.Int).SetString(strings.TrimPrefix(blk, "0x"), 16)
+	if !ok || number.Cmp(p.number) != 0 {
+		return common.Hash{}, fmt.Errorf("%w: hash of block %s", ErrNotInWitness, blk)
+	}
+
+	return p.hash, nil
+}
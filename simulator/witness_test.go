@@ -0,0 +1,255 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+// proofList collects the nodes of a merkle proof
+type proofList []string
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, hexutil.Encode(value))
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error {
+	return nil
+}
+
+// proofNode answers the state of a single contract, with its proofs, at block 1
+type proofNode struct {
+	contract    common.Address
+	code        []byte
+	slots       map[common.Hash]*big.Int
+	storageTrie *trie.Trie
+	accountTrie *trie.Trie
+	header      *types.Header
+}
+
+func newProofNode(t *testing.T, contract common.Address, code []byte, slots map[common.Hash]*big.Int) *proofNode {
+	db := triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil)
+
+	storageTrie := trie.NewEmpty(db)
+	for slot, value := range slots {
+		enc, _ := rlp.EncodeToBytes(value.Bytes())
+		storageTrie.MustUpdate(crypto.Keccak256(slot.Bytes()), enc)
+	}
+
+	account, _ := rlp.EncodeToBytes(&types.StateAccount{
+		Balance:  uint256.NewInt(0),
+		Root:     storageTrie.Hash(),
+		CodeHash: crypto.Keccak256(code),
+	})
+	accountTrie := trie.NewEmpty(db)
+	accountTrie.MustUpdate(crypto.Keccak256(contract.Bytes()), account)
+
+	return &proofNode{
+		contract:    contract,
+		code:        code,
+		slots:       slots,
+		storageTrie: storageTrie,
+		accountTrie: accountTrie,
+		header: &types.Header{
+			Number:     big.NewInt(1),
+			Root:       accountTrie.Hash(),
+			Difficulty: new(big.Int),
+			GasLimit:   30_000_000,
+		},
+	}
+}
+
+func (n *proofNode) respond(t *testing.T, method string, params []json.RawMessage) interface{} {
+	var addr common.Address
+	if len(params) > 0 {
+		json.Unmarshal(params[0], &addr)
+	}
+
+	switch method {
+	case "eth_getBlockByNumber":
+		return n.header
+	case "eth_getCode":
+		if addr == n.contract {
+			return hexutil.Bytes(n.code)
+		}
+		return hexutil.Bytes{}
+	case "eth_getBalance", "eth_getTransactionCount":
+		return "0x0"
+	case "eth_getStorageAt":
+		var slot common.Hash
+		json.Unmarshal(params[1], &slot)
+		if value, ok := n.slots[slot]; ok && addr == n.contract {
+			return common.BigToHash(value)
+		}
+		return common.Hash{}
+	case "eth_getProof":
+		var accountProof proofList
+		if err := n.accountTrie.Prove(crypto.Keccak256(addr.Bytes()), &accountProof); err != nil {
+			t.Error(err)
+		}
+
+		result := map[string]interface{}{
+			"address":      addr,
+			"accountProof": accountProof,
+			"balance":      "0x0",
+			"nonce":        "0x0",
+			"storageProof": []interface{}{},
+		}
+		if addr != n.contract {
+			return result
+		}
+
+		var keys []common.Hash
+		json.Unmarshal(params[1], &keys)
+		storageProofs := make([]map[string]interface{}, len(keys))
+		for i, key := range keys {
+			var proof proofList
+			if err := n.storageTrie.Prove(crypto.Keccak256(key.Bytes()), &proof); err != nil {
+				t.Error(err)
+			}
+
+			value := n.slots[key]
+			if value == nil {
+				value = new(big.Int)
+			}
+			storageProofs[i] = map[string]interface{}{"key": key, "value": hexutil.EncodeBig(value), "proof": proof}
+		}
+
+		result["codeHash"] = crypto.Keccak256Hash(n.code)
+		result["storageHash"] = n.storageTrie.Hash()
+		result["storageProof"] = storageProofs
+
+		return result
+	}
+
+	t.Errorf("unexpected method %s", method)
+	return nil
+}
+
+// serve answers single and batch requests
+func (n *proofNode) serve(t *testing.T) *httptest.Server {
+	type request struct {
+		ID     int               `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var req request
+		if json.Unmarshal(raw, &req) == nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "jsonrpc": "2.0", "result": n.respond(t, req.Method, req.Params)})
+			return
+		}
+
+		var batch []request
+		if err := json.Unmarshal(raw, &batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		responses := make([]map[string]interface{}, len(batch))
+		for i, req := range batch {
+			responses[i] = map[string]interface{}{"id": req.ID, "jsonrpc": "2.0", "result": n.respond(t, req.Method, req.Params)}
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestWitness(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	slot := common.BigToHash(big.NewInt(7))
+	// returns the value of slot 7
+	code := []byte{
+		byte(vm.PUSH1), 0x07, byte(vm.SLOAD),
+		byte(vm.PUSH0), byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+
+	node := newProofNode(t, contract, code, map[common.Hash]*big.Int{slot: big.NewInt(42)})
+	srv := node.serve(t)
+
+	simulation := Simulation{
+		From:        common.HexToAddress("0x0000000000000000000000000000000000000001"),
+		To:          contract,
+		BlockNumber: big.NewInt(1),
+		GasPrice:    big.NewInt(0),
+		Value:       big.NewInt(0),
+	}
+
+	sim, err := NewSimulator(rpc.NewClient(srv.URL), WithChainConfig(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := sim.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	witness, err := sim.Witness(context.Background(), simulation, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if witness.Header.Hash() != node.header.Hash() {
+		t.Fatalf("witness of block %s, want %s", witness.Header.Hash(), node.header.Hash())
+	}
+
+	// the witness is enough to simulate again, without the node
+	b, err := json.Marshal(witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	var decoded Witness
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	offline, err := decoded.Simulator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := offline.Simulate(context.Background(), simulation, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if common.BytesToHash(replayed.ReturnedData) != common.BigToHash(big.NewInt(42)) {
+		t.Fatalf("simulation from the witness returned %x", replayed.ReturnedData)
+	}
+	if replayed.GasUsed != result.GasUsed {
+		t.Fatalf("simulation from the witness used %d gas, want %d", replayed.GasUsed, result.GasUsed)
+	}
+
+	// tampering with the state breaks the witness
+	decoded.Accounts[0].Balance = (*hexutil.Big)(big.NewInt(1))
+	if err := decoded.Verify(); err == nil {
+		t.Fatal("tampered witness verified")
+	}
+}
@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// accessListBuilder collects the storage slots accessed by an execution, indexed by
+// address so adding a slot doesn't depend on the size of the access list. The
+// types.AccessList is only built when asked for, with the addresses and their slots
+// in the order they were first accessed.
+type accessListBuilder struct {
+	addresses []common.Address
+	slots     map[common.Address]*slotSet
+}
+
+// slotSet holds the slots of an address, keys in insertion order
+type slotSet struct {
+	keys []common.Hash
+	set  map[common.Hash]struct{}
+}
+
+func newAccessListBuilder() *accessListBuilder {
+	return &accessListBuilder{slots: make(map[common.Address]*slotSet)}
+}
+
+// add adds slot of addr, once.
+func (b *accessListBuilder) add(addr common.Address, slot common.Hash) {
+	slots, ok := b.slots[addr]
+	if !ok {
+		slots = &slotSet{set: make(map[common.Hash]struct{})}
+		b.slots[addr] = slots
+		b.addresses = append(b.addresses, addr)
+	}
+
+	if _, ok := slots.set[slot]; ok {
+		return
+	}
+	slots.set[slot] = struct{}{}
+	slots.keys = append(slots.keys, slot)
+}
+
+// list returns the access list of the slots added, nil when none was.
+func (b *accessListBuilder) list() types.AccessList {
+	if len(b.addresses) == 0 {
+		return nil
+	}
+
+	list := make(types.AccessList, len(b.addresses))
+	for i, addr := range b.addresses {
+		keys := b.slots[addr].keys
+		list[i] = types.AccessTuple{
+			Address:     addr,
+			StorageKeys: append([]common.Hash(nil), keys...),
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,337 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// CheatcodeAddress is where the cheatcodes are called, the HEVM address of Foundry.
+var CheatcodeAddress = common.HexToAddress("0x7109709ECfa91a80626fF3989D68f67F5b1DD12D")
+
+// errorSelector is the selector of Error(string), the revert reason of failed cheatcodes
+var errorSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+// Cheatcodes let the contracts of an execution, or transactions sent straight to
+// CheatcodeAddress, manipulate the block context, the state and msg.sender, with the
+// interface of Foundry's Vm:
+//
+//	warp(uint256)                    sets block.timestamp
+//	roll(uint256)                    sets block.number
+//	fee(uint256)                     sets block.basefee
+//	coinbase(address)                sets block.coinbase
+//	deal(address,uint256)            sets the balance of an account
+//	store(address,bytes32,bytes32)   sets a storage slot
+//	load(address,bytes32)            returns a storage slot
+//	etch(address,bytes)              sets the code of an account
+//	setNonce(address,uint64)         sets the nonce of an account
+//	getNonce(address)                returns the nonce of an account
+//	prank(address[,address])         sets msg.sender, and tx.origin, of the next call
+//	startPrank(address[,address])    sets them until stopPrank
+//	stopPrank()
+//
+// A prank applies to the calls and creations made by the caller of the cheatcode, from
+// the same depth. The block context set by the cheatcodes persists in every execution
+// using them, as the pranks do, so they can be shared by the transactions of a bundle.
+// Changing the block number doesn't change the fork the state is fetched from, nor the
+// chain rules. Calls to cheatcodes use no gas and the failing ones revert with a reason.
+// They're not safe for concurrent use.
+type Cheatcodes struct {
+	time        *uint64
+	blockNumber *big.Int
+	baseFee     *big.Int
+	coinbase    *common.Address
+	prank       *prank
+}
+
+// prank replaces the sender of the calls made by caller at depth
+type prank struct {
+	caller common.Address
+	depth  int
+	sender common.Address
+	// origin replaces tx.origin during the calls when set
+	origin *common.Address
+	// single pranks only apply to the next call
+	single bool
+}
+
+// NewCheatcodes returns cheatcodes leaving the block context as it's configured.
+func NewCheatcodes() *Cheatcodes {
+	return new(Cheatcodes)
+}
+
+// Copy returns a copy of the cheatcodes, with the block context set and the prank
+// in progress, nil cheatcodes are copied as nil.
+func (c *Cheatcodes) Copy() *Cheatcodes {
+	if c == nil {
+		return nil
+	}
+
+	cpy := *c
+	if c.blockNumber != nil {
+		cpy.blockNumber = new(big.Int).Set(c.blockNumber)
+	}
+
+	if c.baseFee != nil {
+		cpy.baseFee = new(big.Int).Set(c.baseFee)
+	}
+
+	if c.prank != nil {
+		p := *c.prank
+		cpy.prank = &p
+	}
+
+	return &cpy
+}
+
+// SetCheatcodes enables the cheatcodes on the evm and applies the block context they
+// set, it must be called before executing.
+func (evm *EVM) SetCheatcodes(c *Cheatcodes) {
+	evm.cheatcodes = c
+	evm.applyCheatContext()
+}
+
+// applyCheatContext sets the block context of the cheatcodes on evm
+func (evm *EVM) applyCheatContext() {
+	c := evm.cheatcodes
+	if c.time != nil {
+		evm.Context.Time = *c.time
+	}
+
+	if c.blockNumber != nil {
+		evm.Context.BlockNumber = new(big.Int).Set(c.blockNumber)
+	}
+
+	if c.baseFee != nil {
+		evm.Context.BaseFee = new(big.Int).Set(c.baseFee)
+	}
+
+	if c.coinbase != nil {
+		evm.Context.Coinbase = *c.coinbase
+	}
+}
+
+// isCheatcode reports whether calls to addr are handled by the cheatcodes
+func (evm *EVM) isCheatcode(addr common.Address) bool {
+	return evm.cheatcodes != nil && addr == CheatcodeAddress
+}
+
+// prankCaller returns the sender of a call to addr made by caller, and the function
+// undoing the prank once the call is done
+func (evm *EVM) prankCaller(caller ContractRef, addr common.Address) (ContractRef, func()) {
+	if evm.cheatcodes == nil || addr == CheatcodeAddress {
+		return caller, func() {}
+	}
+
+	p := evm.cheatcodes.prank
+	if p == nil || p.caller != caller.Address() || p.depth != evm.depth {
+		return caller, func() {}
+	}
+
+	if p.single {
+		evm.cheatcodes.prank = nil
+	}
+
+	if p.origin == nil {
+		return AccountRef(p.sender), func() {}
+	}
+
+	origin := evm.Origin
+	evm.Origin = *p.origin
+
+	return AccountRef(p.sender), func() { evm.Origin = origin }
+}
+
+// cheatcode is a function of the cheatcodes
+type cheatcode struct {
+	signature string
+	inputs    abi.Arguments
+	outputs   abi.Arguments
+	// write cheatcodes fail in static calls
+	write bool
+	run   func(evm *EVM, caller common.Address, args []interface{}) ([]interface{}, error)
+}
+
+// cheatcodes by selector
+var cheatcodes = make(map[[4]byte]*cheatcode)
+
+func init() {
+	register := func(name string, inputs, outputs []string, write bool, run func(*EVM, common.Address, []interface{}) ([]interface{}, error)) {
+		c := &cheatcode{
+			signature: name + "(" + strings.Join(inputs, ",") + ")",
+			inputs:    abiArguments(inputs),
+			outputs:   abiArguments(outputs),
+			write:     write,
+			run:       run,
+		}
+		cheatcodes[[4]byte(crypto.Keccak256([]byte(c.signature))[:4])] = c
+	}
+
+	register("warp", []string{"uint256"}, nil, true, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		time := args[0].(*big.Int)
+		if !time.IsUint64() {
+			return nil, errors.New("timestamp overflows uint64")
+		}
+
+		t := time.Uint64()
+		evm.cheatcodes.time = &t
+		evm.applyCheatContext()
+
+		return nil, nil
+	})
+
+	register("roll", []string{"uint256"}, nil, true, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		evm.cheatcodes.blockNumber = new(big.Int).Set(args[0].(*big.Int))
+		evm.applyCheatContext()
+
+		return nil, nil
+	})
+
+	register("fee", []string{"uint256"}, nil, true, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		evm.cheatcodes.baseFee = new(big.Int).Set(args[0].(*big.Int))
+		evm.applyCheatContext()
+
+		return nil, nil
+	})
+
+	register("coinbase", []string{"address"}, nil, true, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		coinbase := args[0].(common.Address)
+		evm.cheatcodes.coinbase = &coinbase
+		evm.applyCheatContext()
+
+		return nil, nil
+	})
+
+	register("deal", []string{"address", "uint256"}, nil, true, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		addr := args[0].(common.Address)
+		balance, overflow := uint256.FromBig(args[1].(*big.Int))
+		if overflow {
+			return nil, errors.New("balance overflows uint256")
+		}
+
+		evm.StateDB.SetBalance(addr, balance, tracing.BalanceChangeUnspecified)
+
+		return nil, nil
+	})
+
+	register("store", []string{"address", "bytes32", "bytes32"}, nil, true, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		evm.StateDB.SetState(args[0].(common.Address), common.Hash(args[1].([32]byte)), args[2].([32]byte))
+
+		return nil, nil
+	})
+
+	register("load", []string{"address", "bytes32"}, []string{"bytes32"}, false, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		return []interface{}{[32]byte(evm.StateDB.GetState(args[0].(common.Address), common.Hash(args[1].([32]byte))))}, nil
+	})
+
+	register("etch", []string{"address", "bytes"}, nil, true, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		addr := args[0].(common.Address)
+		if !evm.StateDB.Exist(addr) {
+			evm.StateDB.CreateAccount(addr)
+		}
+
+		evm.StateDB.SetCode(addr, args[1].([]byte))
+
+		return nil, nil
+	})
+
+	register("setNonce", []string{"address", "uint64"}, nil, true, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		evm.StateDB.SetNonce(args[0].(common.Address), args[1].(uint64))
+		return nil, nil
+	})
+
+	register("getNonce", []string{"address"}, []string{"uint64"}, false, func(evm *EVM, _ common.Address, args []interface{}) ([]interface{}, error) {
+		return []interface{}{evm.StateDB.GetNonce(args[0].(common.Address))}, nil
+	})
+
+	for _, single := range []bool{true, false} {
+		name := "startPrank"
+		if single {
+			name = "prank"
+		}
+
+		single := single
+		startPrank := func(evm *EVM, caller common.Address, args []interface{}) ([]interface{}, error) {
+			p := &prank{caller: caller, depth: evm.depth, sender: args[0].(common.Address), single: single}
+			if len(args) > 1 {
+				origin := args[1].(common.Address)
+				p.origin = &origin
+			}
+
+			evm.cheatcodes.prank = p
+
+			return nil, nil
+		}
+
+		register(name, []string{"address"}, nil, true, startPrank)
+		register(name, []string{"address", "address"}, nil, true, startPrank)
+	}
+
+	register("stopPrank", nil, nil, true, func(evm *EVM, _ common.Address, _ []interface{}) ([]interface{}, error) {
+		evm.cheatcodes.prank = nil
+		return nil, nil
+	})
+}
+
+// abiArguments returns the arguments of the given types
+func abiArguments(types []string) abi.Arguments {
+	args := make(abi.Arguments, len(types))
+	for i, typ := range types {
+		t, err := abi.NewType(typ, "", nil)
+		if err != nil {
+			panic(err)
+		}
+		args[i] = abi.Argument{Type: t}
+	}
+
+	return args
+}
+
+// callCheatcode runs the cheatcode called by caller with input, failures revert with
+// their reason. The gas is left untouched.
+func (evm *EVM) callCheatcode(caller ContractRef, input []byte, gas uint64, static bool) ([]byte, uint64, error) {
+	if len(input) < 4 {
+		return cheatcodeRevert(errors.New("missing selector")), gas, vm.ErrExecutionReverted
+	}
+
+	c, ok := cheatcodes[[4]byte(input[:4])]
+	if !ok {
+		return cheatcodeRevert(fmt.Errorf("unknown selector %x", input[:4])), gas, vm.ErrExecutionReverted
+	}
+
+	if c.write && static {
+		return cheatcodeRevert(fmt.Errorf("%s: %w", c.signature, vm.ErrWriteProtection)), gas, vm.ErrExecutionReverted
+	}
+
+	// the input is in the memory of the caller, which is reused once it returns
+	args, err := c.inputs.Unpack(common.CopyBytes(input[4:]))
+	if err != nil {
+		return cheatcodeRevert(fmt.Errorf("%s: %w", c.signature, err)), gas, vm.ErrExecutionReverted
+	}
+
+	values, err := c.run(evm, caller.Address(), args)
+	if err != nil {
+		return cheatcodeRevert(fmt.Errorf("%s: %w", c.signature, err)), gas, vm.ErrExecutionReverted
+	}
+
+	ret, err := c.outputs.Pack(values...)
+	if err != nil {
+		return nil, gas, err
+	}
+
+	return ret, gas, nil
+}
+
+// cheatcodeRevert returns err encoded as an Error(string) revert reason
+func cheatcodeRevert(err error) []byte {
+	reason, _ := abiArguments([]string{"string"}).Pack(err.Error())
+	return append(append([]byte{}, errorSelector...), reason...)
+}
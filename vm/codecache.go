@@ -0,0 +1,27 @@
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+)
+
+// analysisCacheSize is the number of JUMPDEST analyses kept by analysisCache
+const analysisCacheSize = 4096
+
+// analysisCache holds the JUMPDEST analysis of the code run by the simulations by
+// code hash. The same router and token contracts are run by most simulations, at
+// every block explored, so their code is analysed once. It's safe for concurrent use.
+var analysisCache = lru.NewCache[common.Hash, bitvec](analysisCacheSize)
+
+// codeAnalysis returns the JUMPDEST analysis of code, whose hash is hash. The
+// analysis is shared and must not be modified.
+func codeAnalysis(hash common.Hash, code []byte) bitvec {
+	if analysis, ok := analysisCache.Get(hash); ok {
+		return analysis
+	}
+
+	analysis := codeBitmap(code)
+	analysisCache.Add(hash, analysis)
+
+	return analysis
+}
@@ -0,0 +1,329 @@
+// Package debugger executes code opcode by opcode, pausing on breakpoints so the
+// stack, memory and storage can be inspected between steps.
+package debugger
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/holiman/uint256"
+
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// ErrFinished is returned when stepping a session whose execution is over.
+var ErrFinished = errors.New("execution finished")
+
+// Step is the state of the execution paused before an opcode executes.
+type Step struct {
+	PC uint64
+	Op vm.OpCode
+	// Gas left before the opcode and its cost
+	Gas  uint64
+	Cost uint64
+	// Depth of the call, 1 for the code executed
+	Depth   int
+	Address common.Address
+	Caller  common.Address
+	// Stack has the top of the stack last
+	Stack  []uint256.Int
+	Memory []byte
+	// Breakpoints are the ids of the breakpoints hit by the step
+	Breakpoints []int
+}
+
+// Breakpoint pauses the execution before the steps it matches.
+type Breakpoint func(step *Step) bool
+
+// AtPC breaks before the opcode at pc of the code of addr.
+func AtPC(addr common.Address, pc uint64) Breakpoint {
+	return func(step *Step) bool {
+		return step.Address == addr && step.PC == pc
+	}
+}
+
+// AtOpcode breaks before every op.
+func AtOpcode(op vm.OpCode) Breakpoint {
+	return func(step *Step) bool {
+		return step.Op == op
+	}
+}
+
+// AtSlot breaks before the SLOAD and SSTORE of slot of addr.
+func AtSlot(addr common.Address, slot common.Hash) Breakpoint {
+	return func(step *Step) bool {
+		if step.Address != addr || step.Op != vm.SLOAD && step.Op != vm.SSTORE || len(step.Stack) == 0 {
+			return false
+		}
+
+		return common.Hash(step.Stack[len(step.Stack)-1].Bytes32()) == slot
+	}
+}
+
+// Session is an execution controlled by the debugger, it starts paused. The methods
+// stepping it block until it pauses again, and must not be called concurrently
+// except for Pause and the breakpoint ones.
+type Session struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	start  func()
+
+	stateDB *state.StateDB
+	// paused receives the steps the execution pauses on, resume what to do next
+	paused chan *Step
+	resume chan bool
+	// finished is closed once the execution is over, with its result
+	finished chan struct{}
+	result   *runtime.ExecutionResult
+	err      error
+
+	started bool
+	// current is the step the execution is paused on
+	current *Step
+	// stepping pauses on the next opcode, only set by the execution once started
+	stepping bool
+	pause    atomic.Bool
+
+	mu          sync.Mutex
+	breakpoints map[int]Breakpoint
+	// addresses breaking when a call enters them, by breakpoint id
+	entries map[int]common.Address
+	nextID  int
+}
+
+// NewSession returns a session executing code at address as runtime.Execute does,
+// the execution doesn't start until the first Step or Continue. The tracer of cfg
+// keeps being called.
+func NewSession(
+	ctx context.Context,
+	address common.Address,
+	originBalance *big.Int,
+	code, input []byte,
+	cfg *runtime.Config,
+	stateDB *state.StateDB,
+) *Session {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Session{
+		ctx:         ctx,
+		cancel:      cancel,
+		stateDB:     stateDB,
+		paused:      make(chan *Step),
+		resume:      make(chan bool),
+		finished:    make(chan struct{}),
+		breakpoints: make(map[int]Breakpoint),
+		entries:     make(map[int]common.Address),
+	}
+
+	if cfg == nil {
+		cfg = new(runtime.Config)
+	}
+	cfgCopy := *cfg
+	cfgCopy.EVMConfig.Tracer = s.hooks(cfg.EVMConfig.Tracer)
+
+	s.start = func() {
+		go func() {
+			defer close(s.finished)
+			s.result, s.err = runtime.Execute(ctx, address, originBalance, code, input, &cfgCopy, stateDB)
+		}()
+	}
+
+	return s
+}
+
+// AddBreakpoint adds b to the session, returning its id.
+func (s *Session) AddBreakpoint(b Breakpoint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	s.breakpoints[s.nextID] = b
+
+	return s.nextID
+}
+
+// AddEntryBreakpoint breaks on the first opcode of every call executing the code
+// of addr, returning the id of the breakpoint.
+func (s *Session) AddEntryBreakpoint(addr common.Address) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	s.entries[s.nextID] = addr
+
+	return s.nextID
+}
+
+// RemoveBreakpoint removes the breakpoint with id.
+func (s *Session) RemoveBreakpoint(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.breakpoints, id)
+	delete(s.entries, id)
+}
+
+// Step executes the opcode the session is paused on, the first call starts the
+// execution, and returns the next step. ErrFinished is returned once the execution
+// is over.
+func (s *Session) Step() (*Step, error) {
+	return s.run(true)
+}
+
+// Continue executes until a breakpoint is hit, Pause is called or the execution
+// is over, when ErrFinished is returned.
+func (s *Session) Continue() (*Step, error) {
+	return s.run(false)
+}
+
+// Pause pauses a session running Continue on the next opcode, it's safe to call from
+// any goroutine.
+func (s *Session) Pause() {
+	s.pause.Store(true)
+}
+
+// Current returns the step the session is paused on, nil when it isn't.
+func (s *Session) Current() *Step {
+	return s.current
+}
+
+// Storage returns the value of slot of addr, it must only be called while paused.
+// Slots of the fork read as zero until the execution loads them, unless the state
+// fetches them by itself as the remote one.
+func (s *Session) Storage(addr common.Address, slot common.Hash) common.Hash {
+	return s.stateDB.GetState(addr, slot)
+}
+
+// Result waits for the execution to be over and returns its result.
+func (s *Session) Result() (*runtime.ExecutionResult, error) {
+	for {
+		if _, err := s.Continue(); errors.Is(err, ErrFinished) {
+			return s.result, s.err
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Close aborts the execution, a session must be closed or run until it finishes.
+func (s *Session) Close() {
+	s.cancel()
+	if s.started {
+		<-s.finished
+	}
+}
+
+func (s *Session) run(stepping bool) (*Step, error) {
+	select {
+	case <-s.finished:
+		return nil, ErrFinished
+	default:
+	}
+
+	if !s.started {
+		s.started = true
+		s.stepping = stepping
+		s.start()
+	} else if s.current != nil {
+		s.current = nil
+		select {
+		case s.resume <- stepping:
+		case <-s.finished:
+			return nil, ErrFinished
+		}
+	}
+
+	select {
+	case step := <-s.paused:
+		s.current = step
+		return step, nil
+	case <-s.finished:
+		return nil, ErrFinished
+	}
+}
+
+// hooks returns the hooks pausing the execution, the ones of tracer keep being called
+func (s *Session) hooks(tracer *tracing.Hooks) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	// set when a call entered a contract, until its first opcode
+	var entered bool
+
+	hooks.OnEnter = func(depth int, typ byte, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+		entered = true
+		if tracer != nil && tracer.OnEnter != nil {
+			tracer.OnEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+
+	hooks.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+		if tracer != nil && tracer.OnOpcode != nil {
+			tracer.OnOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+
+		// failed opcodes are reported once they're over
+		if err != nil {
+			return
+		}
+
+		step := &Step{
+			PC:      pc,
+			Op:      vm.OpCode(op),
+			Gas:     gas,
+			Cost:    cost,
+			Depth:   depth,
+			Address: scope.Address(),
+			Caller:  scope.Caller(),
+			Stack:   append([]uint256.Int(nil), scope.StackData()...),
+			Memory:  append([]byte(nil), scope.MemoryData()...),
+		}
+
+		step.Breakpoints = s.hit(step, entered)
+		entered = false
+
+		if !s.stepping && len(step.Breakpoints) == 0 && !s.pause.Swap(false) {
+			return
+		}
+
+		select {
+		case s.paused <- step:
+		case <-s.ctx.Done():
+			return
+		}
+
+		select {
+		case s.stepping = <-s.resume:
+		case <-s.ctx.Done():
+		}
+	}
+
+	return hooks
+}
+
+// hit returns the ids of the breakpoints matching step, in ascending order
+func (s *Session) hit(step *Step, entered bool) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []int
+	for id := 1; id <= s.nextID; id++ {
+		if b, ok := s.breakpoints[id]; ok && b(step) {
+			ids = append(ids, id)
+		}
+
+		if addr, ok := s.entries[id]; ok && entered && addr == step.Address {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,153 @@
+package debugger
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/Gealber/evm-simulator/vm"
+	"github.com/Gealber/evm-simulator/vm/runtime"
+)
+
+// emptyProvider serves a fork where every account is empty
+type emptyProvider struct{}
+
+func (emptyProvider) GetCode(context.Context, string, string) ([]byte, error) { return nil, nil }
+
+func (emptyProvider) GetStorageAt(context.Context, string, string, string) (common.Hash, error) {
+	return common.Hash{}, nil
+}
+
+func (emptyProvider) GetBalance(context.Context, string, string) (*big.Int, error) {
+	return new(big.Int), nil
+}
+
+func (emptyProvider) GetNonce(context.Context, string, string) (uint64, error) { return 0, nil }
+
+func (emptyProvider) GetBlockHash(context.Context, string) (common.Hash, error) {
+	return common.Hash{}, nil
+}
+
+var (
+	contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+	// loads slot 1, stores 0x2a in it, loads it back and returns it
+	code = []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0x01, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.PUSH0), byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH0), byte(vm.RETURN),
+	}
+)
+
+func newSession(t *testing.T) *Session {
+	t.Helper()
+
+	stateDB, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSession(context.Background(), contract, big.NewInt(0), code, nil, &runtime.Config{StateProvider: emptyProvider{}}, stateDB)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestSessionStep(t *testing.T) {
+	s := newSession(t)
+
+	pcs := []uint64{0, 2, 3, 4, 6, 8, 9, 11, 12, 13, 14, 16, 17}
+	for _, pc := range pcs {
+		step, err := s.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if step.PC != pc || step.Address != contract || step.Depth != 1 {
+			t.Fatalf("paused at %s pc %d depth %d, expected pc %d", step.Address, step.PC, step.Depth, pc)
+		}
+
+		// the value is on the stack before SSTORE and MSTORE
+		if step.Op == vm.SSTORE && step.Stack[0].Uint64() != 0x2a {
+			t.Fatalf("stack before SSTORE: %v", step.Stack)
+		}
+
+		if step.Op == vm.RETURN && new(big.Int).SetBytes(step.Memory).Int64() != 0x2a {
+			t.Fatalf("memory before RETURN: %x", step.Memory)
+		}
+	}
+
+	if _, err := s.Step(); !errors.Is(err, ErrFinished) {
+		t.Fatalf("expected ErrFinished, got %v", err)
+	}
+
+	result, err := s.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if new(big.Int).SetBytes(result.Ret).Int64() != 0x2a {
+		t.Fatalf("returned %x", result.Ret)
+	}
+}
+
+func TestSessionBreakpoints(t *testing.T) {
+	var (
+		s    = newSession(t)
+		slot = common.BigToHash(common.Big1)
+	)
+
+	slotID := s.AddBreakpoint(AtSlot(contract, slot))
+	pcID := s.AddBreakpoint(AtPC(contract, 16))
+	entryID := s.AddEntryBreakpoint(contract)
+
+	tests := []struct {
+		pc  uint64
+		ids []int
+	}{
+		{pc: 0, ids: []int{entryID}},
+		{pc: 2, ids: []int{slotID}},
+		{pc: 8, ids: []int{slotID}},
+		{pc: 11, ids: []int{slotID}},
+		{pc: 16, ids: []int{pcID}},
+	}
+
+	for _, tt := range tests {
+		step, err := s.Continue()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if step.PC != tt.pc || len(step.Breakpoints) != len(tt.ids) || step.Breakpoints[0] != tt.ids[0] {
+			t.Fatalf("paused at pc %d on %v, expected pc %d on %v", step.PC, step.Breakpoints, tt.pc, tt.ids)
+		}
+
+		// the store is done once paused on the second load
+		if step.PC == 11 && s.Storage(contract, slot) != common.BigToHash(big.NewInt(0x2a)) {
+			t.Fatalf("slot before SLOAD: %s", s.Storage(contract, slot).Hex())
+		}
+	}
+
+	s.RemoveBreakpoint(slotID)
+	if _, err := s.Continue(); !errors.Is(err, ErrFinished) {
+		t.Fatalf("expected ErrFinished, got %v", err)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	s := newSession(t)
+	if _, err := s.Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	if _, err := s.Step(); !errors.Is(err, ErrFinished) {
+		t.Fatalf("expected ErrFinished, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package vm
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DelegationPrefix is the prefix of the EIP-7702 delegation designator,
+// the code of an authority is set to DelegationPrefix || implementation.
+var DelegationPrefix = []byte{0xef, 0x01, 0x00}
+
+// AddressToDelegation returns the 23 bytes designator delegating to addr.
+func AddressToDelegation(addr common.Address) []byte {
+	return append(slices.Clone(DelegationPrefix), addr.Bytes()...)
+}
+
+// ParseDelegation returns the address the code delegates to, if code is a
+// delegation designator.
+func ParseDelegation(code []byte) (common.Address, bool) {
+	if len(code) != len(DelegationPrefix)+common.AddressLength || !bytes.HasPrefix(code, DelegationPrefix) {
+		return common.Address{}, false
+	}
+
+	return common.BytesToAddress(code[len(DelegationPrefix):]), true
+}
+
+// isEIP7702 reports whether set code delegations are followed on calls.
+func (evm *EVM) isEIP7702() bool {
+	return evm.chainRules.IsPrague || slices.Contains(evm.Config.ExtraEips, 7702)
+}
+
+// resolveCode returns the code and code hash executed when calling addr,
+// with EIP-7702 active the code of the implementation is used for delegated accounts.
+func (evm *EVM) resolveCode(addr common.Address) ([]byte, common.Hash) {
+	code := evm.StateDB.GetCode(addr)
+	if evm.isEIP7702() {
+		if target, ok := ParseDelegation(code); ok {
+			return evm.StateDB.GetCode(target), evm.StateDB.GetCodeHash(target)
+		}
+	}
+
+	return code, evm.StateDB.GetCodeHash(addr)
+}
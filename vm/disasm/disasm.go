@@ -0,0 +1,70 @@
+package disasm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+// StorageAnalysis summarises the storage accesses found in a contract bytecode.
+type StorageAnalysis struct {
+	// StaticSLOADSlots are the slots pushed as constants right before an SLOAD
+	StaticSLOADSlots []common.Hash
+	// DynamicSLOADCount is the number of SLOAD whose slot is computed at runtime
+	DynamicSLOADCount int
+	// StaticSSTORESlots are the slots pushed as constants right before an SSTORE
+	StaticSSTORESlots []common.Hash
+	// DynamicSSTORECount is the number of SSTORE whose slot is computed at runtime
+	DynamicSSTORECount int
+}
+
+// AnalyzeStorageSlots walks the bytecode looking for SLOAD and SSTORE, a slot is
+// considered static when the opcode is immediately preceded by a PUSH, PUSH0 included.
+// Each static slot is reported once, in order of appearance. The analysis doesn't
+// follow jumps, so it's an estimate of the accesses and not an exact count.
+func AnalyzeStorageSlots(code []byte) *StorageAnalysis {
+	var (
+		analysis  = &StorageAnalysis{}
+		seenLoad  = make(map[common.Hash]struct{})
+		seenStore = make(map[common.Hash]struct{})
+		// constant pushed by the previous instruction, nil if it wasn't a PUSH
+		pushed *common.Hash
+	)
+
+	for pc := 0; pc < len(code); pc++ {
+		op := vm.OpCode(code[pc])
+
+		switch {
+		case op == vm.PUSH0:
+			pushed = &common.Hash{}
+			continue
+		case op.IsPush():
+			// push data truncated by the end of the code is padded with zeros
+			data := make([]byte, int(op-vm.PUSH1)+1)
+			copy(data, code[pc+1:min(pc+1+len(data), len(code))])
+
+			slot := common.BytesToHash(data)
+			pushed = &slot
+			pc += len(data)
+			continue
+		case op == vm.SLOAD:
+			if pushed == nil {
+				analysis.DynamicSLOADCount++
+			} else if _, ok := seenLoad[*pushed]; !ok {
+				seenLoad[*pushed] = struct{}{}
+				analysis.StaticSLOADSlots = append(analysis.StaticSLOADSlots, *pushed)
+			}
+		case op == vm.SSTORE:
+			if pushed == nil {
+				analysis.DynamicSSTORECount++
+			} else if _, ok := seenStore[*pushed]; !ok {
+				seenStore[*pushed] = struct{}{}
+				analysis.StaticSSTORESlots = append(analysis.StaticSSTORESlots, *pushed)
+			}
+		}
+
+		pushed = nil
+	}
+
+	return analysis
+}
@@ -0,0 +1,52 @@
+package disasm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/Gealber/evm-simulator/vm"
+)
+
+func TestAnalyzeStorageSlots(t *testing.T) {
+	slot := common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+
+	code := []byte{
+		// static SLOAD of slot 1, twice
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD),
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD),
+		// static SLOAD of a PUSH32 slot
+		byte(vm.PUSH32),
+	}
+	code = append(code, slot.Bytes()...)
+	code = append(code,
+		byte(vm.SLOAD),
+		// dynamic SLOAD of keccak(0, 0)
+		byte(vm.PUSH0), byte(vm.PUSH0), byte(vm.KECCAK256), byte(vm.SLOAD),
+		// static SSTORE in slot 0 of the value on the stack
+		byte(vm.PUSH0), byte(vm.SSTORE),
+		// dynamic SSTORE in slot CALLDATALOAD(0)
+		byte(vm.PUSH1), 0x2a, byte(vm.PUSH0), byte(vm.CALLDATALOAD), byte(vm.SSTORE),
+		// SLOAD inside push data must be ignored
+		byte(vm.PUSH2), byte(vm.SLOAD), byte(vm.SSTORE),
+	)
+
+	expected := &StorageAnalysis{
+		StaticSLOADSlots:   []common.Hash{common.BigToHash(common.Big1), slot},
+		DynamicSLOADCount:  1,
+		StaticSSTORESlots:  []common.Hash{{}},
+		DynamicSSTORECount: 1,
+	}
+
+	if got := AnalyzeStorageSlots(code); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("analysis: %+v expected: %+v", got, expected)
+	}
+}
+
+func TestAnalyzeStorageSlotsTruncatedPush(t *testing.T) {
+	analysis := AnalyzeStorageSlots([]byte{byte(vm.SLOAD), byte(vm.PUSH32), 0x01})
+	if analysis.DynamicSLOADCount != 1 || len(analysis.StaticSLOADSlots) != 0 {
+		t.Fatalf("unexpected analysis: %+v", analysis)
+	}
+}
@@ -41,6 +41,7 @@ var activators = map[int]func(*JumpTable){
 	1344: enable1344,
 	1153: enable1153,
 	4762: enable4762,
+	7702: enable7702,
 }
 
 // EnableEIP enables the given EIP on the config.
@@ -59,6 +60,38 @@ func ValidEip(eipNum int) bool {
 	_, ok := activators[eipNum]
 	return ok
 }
+
+var deactivators = map[int]func(*JumpTable){
+	5656: disable5656,
+	3855: disable3855,
+	3529: disable3529,
+	3198: disable3198,
+	2929: disable2929,
+	1153: disable1153,
+}
+
+// DisableEIP reverts the given EIP on the jump table, restoring the behaviour
+// of the fork previous to its activation.
+// This operation writes in-place, and callers need to ensure that the globally
+// defined jump tables are not polluted.
+func DisableEIP(eipNum int, jt *JumpTable) error {
+	disablerFn, ok := deactivators[eipNum]
+	if !ok {
+		return fmt.Errorf("eip %d can't be disabled", eipNum)
+	}
+	disablerFn(jt)
+	return nil
+}
+
+func ValidDisableEip(eipNum int) bool {
+	_, ok := deactivators[eipNum]
+	return ok
+}
+
+// undefinedOperation is the entry of opcodes not defined in the jump table.
+func undefinedOperation() *operation {
+	return &operation{execute: opUndefined, maxStack: maxStack(0, 0)}
+}
 func ActivateableEips() []string {
 	var nums []string
 	for k := range activators {
@@ -157,6 +190,43 @@ func enable2929(jt *JumpTable) {
 	jt[SELFDESTRUCT].dynamicGas = gasSelfdestructEIP2929
 }
 
+// disable2929 restores the Istanbul state access costs, with SSTORE metered
+// as in EIP-2200.
+func disable2929(jt *JumpTable) {
+	jt[SSTORE].dynamicGas = gasSStoreEIP2200
+
+	jt[SLOAD].constantGas = params.SloadGasEIP2200
+	jt[SLOAD].dynamicGas = nil
+
+	jt[EXTCODECOPY].constantGas = params.ExtcodeCopyBaseEIP150
+	jt[EXTCODECOPY].dynamicGas = gasExtCodeCopy
+
+	jt[EXTCODESIZE].constantGas = params.ExtcodeSizeGasEIP150
+	jt[EXTCODESIZE].dynamicGas = nil
+
+	jt[EXTCODEHASH].constantGas = params.ExtcodeHashGasEIP1884
+	jt[EXTCODEHASH].dynamicGas = nil
+
+	jt[BALANCE].constantGas = params.BalanceGasEIP1884
+	jt[BALANCE].dynamicGas = nil
+
+	jt[CALL].constantGas = params.CallGasEIP150
+	jt[CALL].dynamicGas = gasCall
+
+	jt[CALLCODE].constantGas = params.CallGasEIP150
+	jt[CALLCODE].dynamicGas = gasCallCode
+
+	jt[STATICCALL].constantGas = params.CallGasEIP150
+	jt[STATICCALL].dynamicGas = gasStaticCall
+
+	jt[DELEGATECALL].constantGas = params.CallGasEIP150
+	jt[DELEGATECALL].dynamicGas = gasDelegateCall
+
+	// the EIP-150 cost is part of the dynamic cost before EIP-2929
+	jt[SELFDESTRUCT].constantGas = 0
+	jt[SELFDESTRUCT].dynamicGas = gasSelfdestruct
+}
+
 // enable3529 enabled "EIP-3529: Reduction in refunds":
 // - Removes refunds for selfdestructs
 // - Reduces refunds for SSTORE
@@ -166,6 +236,12 @@ func enable3529(jt *JumpTable) {
 	jt[SELFDESTRUCT].dynamicGas = gasSelfdestructEIP3529
 }
 
+// disable3529 restores the Berlin refunds for SSTORE and SELFDESTRUCT.
+func disable3529(jt *JumpTable) {
+	jt[SSTORE].dynamicGas = gasSStoreEIP2929
+	jt[SELFDESTRUCT].dynamicGas = gasSelfdestructEIP2929
+}
+
 // enable3198 applies EIP-3198 (BASEFEE Opcode)
 // - Adds an opcode that returns the current block's base fee.
 func enable3198(jt *JumpTable) {
@@ -534,3 +610,28 @@ func enable4762(jt *JumpTable) {
 		}
 	}
 }
+
+// enable7702 leaves the jump table untouched, set code delegations are
+// resolved by the EVM when loading the code of the callee, see resolveCode.
+func enable7702(jt *JumpTable) {}
+
+// disable3198 removes the BASEFEE opcode.
+func disable3198(jt *JumpTable) {
+	jt[BASEFEE] = undefinedOperation()
+}
+
+// disable3855 removes the PUSH0 opcode.
+func disable3855(jt *JumpTable) {
+	jt[PUSH0] = undefinedOperation()
+}
+
+// disable1153 removes the transient storage opcodes TLOAD and TSTORE.
+func disable1153(jt *JumpTable) {
+	jt[TLOAD] = undefinedOperation()
+	jt[TSTORE] = undefinedOperation()
+}
+
+// disable5656 removes the MCOPY opcode.
+func disable5656(jt *JumpTable) {
+	jt[MCOPY] = undefinedOperation()
+}
@@ -18,7 +18,9 @@ package vm
 
 import (
 	"errors"
+	"maps"
 	"math/big"
+	"slices"
 	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -42,25 +44,66 @@ type (
 )
 
 func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
-	var precompiles map[common.Address]PrecompiledContract
+	precompiles := evm.precompiles
+	if precompiles == nil {
+		precompiles = evm.rulesPrecompiles()
+	}
+	p, ok := precompiles[addr]
+	return p, ok
+}
+
+// rulesPrecompiles returns the precompiles enabled by the chain rules
+func (evm *EVM) rulesPrecompiles() map[common.Address]PrecompiledContract {
 	switch {
 	case evm.chainRules.IsVerkle:
-		precompiles = PrecompiledContractsVerkle
+		return PrecompiledContractsVerkle
 	case evm.chainRules.IsPrague:
-		precompiles = PrecompiledContractsPrague
+		return PrecompiledContractsPrague
 	case evm.chainRules.IsCancun:
-		precompiles = PrecompiledContractsCancun
+		return PrecompiledContractsCancun
 	case evm.chainRules.IsBerlin:
-		precompiles = PrecompiledContractsBerlin
+		return PrecompiledContractsBerlin
 	case evm.chainRules.IsIstanbul:
-		precompiles = PrecompiledContractsIstanbul
+		return PrecompiledContractsIstanbul
 	case evm.chainRules.IsByzantium:
-		precompiles = PrecompiledContractsByzantium
+		return PrecompiledContractsByzantium
 	default:
-		precompiles = PrecompiledContractsHomestead
+		return PrecompiledContractsHomestead
 	}
-	p, ok := precompiles[addr]
-	return p, ok
+}
+
+// SetPrecompiles overrides the precompiles enabled by the chain rules, a contract
+// of overrides replaces the precompile at its address or adds one, a nil contract
+// removes it. It must be called before running any code.
+func (evm *EVM) SetPrecompiles(overrides map[common.Address]PrecompiledContract) {
+	precompiles := maps.Clone(evm.rulesPrecompiles())
+	for addr, p := range overrides {
+		if p == nil {
+			delete(precompiles, addr)
+			continue
+		}
+		precompiles[addr] = p
+	}
+
+	evm.precompiles = precompiles
+}
+
+// ActivePrecompiles returns the addresses of the precompiles of the evm, the ones of
+// the chain rules unless overridden with SetPrecompiles.
+func (evm *EVM) ActivePrecompiles() []common.Address {
+	if evm.precompiles == nil {
+		return ActivePrecompiles(evm.chainRules)
+	}
+
+	addrs := make([]common.Address, 0, len(evm.precompiles))
+	for addr := range evm.precompiles {
+		addrs = append(addrs, addr)
+	}
+	slices.SortFunc(addrs, func(a, b common.Address) int {
+		return a.Cmp(b)
+	})
+
+	return addrs
 }
 
 // BlockContext provides the EVM with auxiliary information. Once provided
@@ -126,6 +169,10 @@ type EVM struct {
 	interpreter *EVMInterpreter
 	// abort is used to abort the EVM calling operations
 	abort atomic.Bool
+	// precompiles replace the ones of the chain rules when set, see SetPrecompiles
+	precompiles map[common.Address]PrecompiledContract
+	// cheatcodes handle the calls to CheatcodeAddress when set, see SetCheatcodes
+	cheatcodes *Cheatcodes
 	// callGasTemp holds the gas available for the current call. This is needed because the
 	// available gas is calculated in gasCall* according to the 63/64 rule and later
 	// applied in opCall*.
@@ -137,11 +184,10 @@ type EVM struct {
 func NewEVM(
 	blockCtx BlockContext,
 	txCtx TxContext,
-	record *RecordToInitiateState,
 	statedb *state.StateDB,
 	chainConfig *params.ChainConfig,
 	config vm.Config,
-	rpcEndpoint string,
+	provider StateProvider,
 ) *EVM {
 	// If basefee tracking is disabled (eth_call, eth_estimateGas, etc), and no
 	// gas prices were specified, lower the basefee to 0 to avoid breaking EVM
@@ -162,7 +208,7 @@ func NewEVM(
 		chainConfig: chainConfig,
 		chainRules:  chainConfig.Rules(blockCtx.BlockNumber, blockCtx.Random != nil, blockCtx.Time),
 	}
-	evm.interpreter = NewEVMInterpreter(evm, record, rpcEndpoint)
+	evm.interpreter = NewEVMInterpreter(evm, provider)
 	return evm
 }
 
@@ -197,6 +243,8 @@ func (evm *EVM) Interpreter() *EVMInterpreter {
 // the necessary steps to create accounts and reverses the state in case of an
 // execution error or failed value transfer.
 func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas uint64, value *uint256.Int) (ret []byte, leftOverGas uint64, err error) {
+	caller, unprank := evm.prankCaller(caller, addr)
+	defer unprank()
 	// Capture the tracer start/end events in debug mode
 	if evm.Config.Tracer != nil {
 		evm.captureBegin(evm.depth, CALL, caller.Address(), addr, input, gas, value.ToBig())
@@ -204,6 +252,9 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 			evm.captureEnd(evm.depth, startGas, leftOverGas, ret, err)
 		}(gas)
 	}
+	if evm.isCheatcode(addr) {
+		return evm.callCheatcode(caller, input, gas, evm.interpreter.readOnly)
+	}
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
@@ -239,7 +290,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	} else {
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
-		code := evm.StateDB.GetCode(addr)
+		code, codeHash := evm.resolveCode(addr)
 		if len(code) == 0 {
 			ret, err = nil, nil // gas is unchanged
 		} else {
@@ -247,7 +298,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 			// If the account has no code, we can abort here
 			// The depth-check is already done, and precompiles handled above
 			contract := NewContract(caller, AccountRef(addrCopy), value, gas)
-			contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), code)
+			contract.SetCallCode(&addrCopy, codeHash, code)
 			ret, err = evm.interpreter.Run(contract, input, false)
 			gas = contract.Gas
 		}
@@ -307,7 +358,8 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		contract := NewContract(caller, AccountRef(caller.Address()), value, gas)
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		code, codeHash := evm.resolveCode(addrCopy)
+		contract.SetCallCode(&addrCopy, codeHash, code)
 		ret, err = evm.interpreter.Run(contract, input, false)
 		gas = contract.Gas
 	}
@@ -354,7 +406,8 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 		addrCopy := addr
 		// Initialise a new contract and make initialise the delegate values
 		contract := NewContract(caller, AccountRef(caller.Address()), nil, gas).AsDelegate()
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		code, codeHash := evm.resolveCode(addrCopy)
+		contract.SetCallCode(&addrCopy, codeHash, code)
 		ret, err = evm.interpreter.Run(contract, input, false)
 		gas = contract.Gas
 	}
@@ -375,6 +428,8 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 // Opcodes that attempt to perform such modifications will result in exceptions
 // instead of performing the modifications.
 func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte, gas uint64) (ret []byte, leftOverGas uint64, err error) {
+	caller, unprank := evm.prankCaller(caller, addr)
+	defer unprank()
 	// Invoke tracer hooks that signal entering/exiting a call frame
 	if evm.Config.Tracer != nil {
 		evm.captureBegin(evm.depth, STATICCALL, caller.Address(), addr, input, gas, nil)
@@ -382,6 +437,9 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 			evm.captureEnd(evm.depth, startGas, leftOverGas, ret, err)
 		}(gas)
 	}
+	if evm.isCheatcode(addr) {
+		return evm.callCheatcode(caller, input, gas, true)
+	}
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
@@ -409,7 +467,8 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		contract := NewContract(caller, AccountRef(addrCopy), new(uint256.Int), gas)
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		code, codeHash := evm.resolveCode(addrCopy)
+		contract.SetCallCode(&addrCopy, codeHash, code)
 		// When an error was returned by the EVM or when setting the creation code
 		// above we revert to the snapshot and consume any gas remaining. Additionally
 		// when we're in Homestead this also counts for code storage gas errors.
@@ -569,6 +628,8 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 
 // Create creates a new contract using code as deployment code.
 func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *uint256.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
+	caller, unprank := evm.prankCaller(caller, common.Address{})
+	defer unprank()
 	contractAddr = crypto.CreateAddress(caller.Address(), evm.StateDB.GetNonce(caller.Address()))
 	return evm.create(caller, &codeAndHash{code: code}, gas, value, contractAddr, CREATE)
 }
@@ -578,6 +639,8 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *uint2
 // The different between Create2 with Create is Create2 uses keccak256(0xff ++ msg.sender ++ salt ++ keccak256(init_code))[12:]
 // instead of the usual sender-and-nonce-hash as the address where the contract is initialized at.
 func (evm *EVM) Create2(caller ContractRef, code []byte, gas uint64, endowment *uint256.Int, salt *uint256.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
+	caller, unprank := evm.prankCaller(caller, common.Address{})
+	defer unprank()
 	codeAndHash := &codeAndHash{code: code}
 	contractAddr = crypto.CreateAddress2(caller.Address(), salt.Bytes32(), codeAndHash.Hash().Bytes())
 	return evm.create(caller, codeAndHash, gas, endowment, contractAddr, CREATE2)
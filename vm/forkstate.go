@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ForkState is a state fetching from the fork the accounts and slots it's missing the
+// first time they're read. The interpreter adds to it the state it fetches ahead, so
+// it isn't fetched a slot at a time.
+type ForkState interface {
+	// Block is the block the state is fetched from, hex encoded, empty for the latest
+	Block() string
+	// StorageFetched reports whether slot of addr is known without fetching it
+	StorageFetched(addr common.Address, slot common.Hash) bool
+	// AddStorage adds slots of addr fetched from the fork, keeping the ones known
+	// already. When complete, slots is the whole storage of addr, the others are empty.
+	AddStorage(addr common.Address, slots map[common.Hash]common.Hash, complete bool)
+	// AddAccount adds an account fetched from the fork, unless it's known already
+	AddAccount(addr common.Address, balance *big.Int, nonce uint64, code []byte)
+}
+
+// fetchAhead fetches, before the SLOAD of scope at pc reads a slot missing from the
+// fork state, the slots likely read next along with it. Failures are ignored, the
+// state fetches then the slot alone.
+func (in *EVMInterpreter) fetchAhead(scope *ScopeContext, pc uint64) {
+	addr, slot := scope.Address(), common.Hash(scope.Stack.peek().Bytes32())
+	if in.forkState.StorageFetched(addr, slot) || in.loadFullStorage(addr) {
+		return
+	}
+
+	if in.slotHistory != nil {
+		in.slotHistory.remember(scope.Contract, slot)
+		in.speculate(scope.Contract, pc, slot)
+	}
+}
@@ -0,0 +1,63 @@
+package vm
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+// FullStorageProvider is a StateProvider listing the whole storage of an account, it's
+// needed to prefetch the storage of small contracts.
+type FullStorageProvider interface {
+	StateProvider
+	GetFullStorage(ctx context.Context, address, blk string, maxSlots int) (map[common.Hash]common.Hash, bool, error)
+}
+
+var _ FullStorageProvider = (*rpc.Client)(nil)
+
+// SetFullStorage makes the interpreter fetch, on the first slot of an account missing
+// from the fork state, the whole storage of the account when it has at most maxSlots
+// slots, in a few requests instead of one per slot read. The other slots of such an
+// account are read as empty without fetching them. Larger accounts, and the ones
+// whose storage the provider can't list, are fetched a slot at a time. It needs a
+// FullStorageProvider and a fork state, and must be called before Run, a zero
+// maxSlots disables it.
+func (in *EVMInterpreter) SetFullStorage(maxSlots int) {
+	in.fullStorage = maxSlots
+	if in.fullStorageLoaded == nil {
+		in.fullStorageLoaded = make(map[common.Address]bool)
+	}
+}
+
+// loadFullStorage adds the whole storage of addr to the fork state, returning false
+// when it isn't prefetched
+func (in *EVMInterpreter) loadFullStorage(addr common.Address) bool {
+	if in.fullStorage <= 0 {
+		return false
+	}
+
+	if loaded, ok := in.fullStorageLoaded[addr]; ok {
+		return loaded
+	}
+
+	provider, ok := in.provider.(FullStorageProvider)
+	if !ok {
+		return false
+	}
+
+	storage, complete, err := provider.GetFullStorage(in.ctx, addr.Hex(), in.forkState.Block(), in.fullStorage)
+	if err != nil {
+		// the slots are fetched one at a time, failing there if the fork is unreachable
+		logger.DebugContext(in.ctx, "full storage fetch failed", "address", addr, "error", err)
+		complete = false
+	}
+
+	in.fullStorageLoaded[addr] = complete
+	if complete {
+		in.forkState.AddStorage(addr, storage, true)
+	}
+
+	return complete
+}
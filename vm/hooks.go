@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// OpcodeHook is called around the execution of an opcode with its pc and the scope
+// of the call executing it. Returning an error fails the call, as the opcode would.
+type OpcodeHook func(pc uint64, scope *ScopeContext) error
+
+// OpcodeFunc executes an opcode instead of its implementation. It gets the operands
+// of the opcode, the top of the stack first, and returns the values pushed, the
+// last one ending at the top. It must return as many values as the opcode pushes.
+type OpcodeFunc func(pc uint64, scope *ScopeContext, operands []uint256.Int) ([]uint256.Int, error)
+
+// OpcodeHooks instruments or alters opcodes of a single execution, they're installed
+// on a copy of its jump table. The gas of the opcodes is charged as usual.
+type OpcodeHooks struct {
+	// Before is called before the opcode executes, once its gas was charged
+	Before map[OpCode]OpcodeHook
+	// After is called once the opcode executed without error
+	After map[OpCode]OpcodeHook
+	// Override replaces the execution of opcodes only using the stack. Opcodes
+	// not defined by the jump table take and push nothing.
+	Override map[OpCode]OpcodeFunc
+}
+
+// SetOpcodeHooks installs hooks on a copy of the jump table of the interpreter, it
+// must be called before Run.
+func (in *EVMInterpreter) SetOpcodeHooks(hooks OpcodeHooks) {
+	table := copyJumpTable(in.table)
+
+	for op, f := range hooks.Override {
+		operation := table[op]
+		pops := operation.minStack
+		pushes := pops + int(params.StackLimit) - operation.maxStack
+		operation.execute = overrideExecution(op, f, pops, pushes)
+	}
+
+	for op, hook := range hooks.Before {
+		execute := table[op].execute
+		table[op].execute = func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+			if err := hook(*pc, scope); err != nil {
+				return nil, err
+			}
+
+			return execute(pc, interpreter, scope)
+		}
+	}
+
+	for op, hook := range hooks.After {
+		execute := table[op].execute
+		table[op].execute = func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+			// jumps move the pc
+			opPc := *pc
+			ret, err := execute(pc, interpreter, scope)
+			if err != nil {
+				return ret, err
+			}
+
+			return ret, hook(opPc, scope)
+		}
+	}
+
+	in.table = table
+}
+
+// overrideExecution returns the execution of op by f, taking pops operands
+func overrideExecution(op OpCode, f OpcodeFunc, pops, pushes int) executionFunc {
+	return func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+		operands := make([]uint256.Int, pops)
+		for i := range operands {
+			operands[i] = scope.Stack.pop()
+		}
+
+		values, err := f(*pc, scope, operands)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(values) != pushes {
+			return nil, fmt.Errorf("override of %s pushed %d values, expected %d", op, len(values), pushes)
+		}
+
+		for i := range values {
+			scope.Stack.push(&values[i])
+		}
+
+		return nil, nil
+	}
+}
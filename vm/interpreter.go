@@ -17,20 +17,24 @@
 package vm
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"slices"
+	"sync"
 
-	"github.com/Gealber/evm-simulator/rpc"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/core/tracing"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/holiman/uint256"
+
+	"github.com/Gealber/evm-simulator/logging"
 )
 
+var logger = logging.Logger(logging.Interpreter)
+
 // ScopeContext contains the things that are per-call, such as stack and memory,
 // but not transients like pc and gas
 type ScopeContext struct {
@@ -39,6 +43,27 @@ type ScopeContext struct {
 	Contract *Contract
 }
 
+var scopePool = sync.Pool{
+	New: func() interface{} {
+		return new(ScopeContext)
+	},
+}
+
+func newScope(mem *Memory, stack *Stack, contract *Contract) *ScopeContext {
+	scope := scopePool.Get().(*ScopeContext)
+	scope.Memory, scope.Stack, scope.Contract = mem, stack, contract
+
+	return scope
+}
+
+// returnScope returns scope, its memory and its stack to their pools
+func returnScope(scope *ScopeContext) {
+	returnStack(scope.Stack)
+	scope.Memory.Free()
+	*scope = ScopeContext{}
+	scopePool.Put(scope)
+}
+
 // MemoryData returns the underlying memory slice. Callers must not modify the contents
 // of the returned data.
 func (ctx *ScopeContext) MemoryData() []byte {
@@ -80,9 +105,10 @@ func (ctx *ScopeContext) CallInput() []byte {
 
 // EVMInterpreter represents an EVM interpreter
 type EVMInterpreter struct {
-	rpcClt *rpc.Client
-	evm    *EVM
-	table  *JumpTable
+	// provider fetches the state of the fork
+	provider StateProvider
+	evm      *EVM
+	table    *JumpTable
 
 	hasher    crypto.KeccakState // Keccak256 hasher instance shared across opcodes
 	hasherBuf common.Hash        // Keccak256 hasher result array shared across opcodes
@@ -90,30 +116,35 @@ type EVMInterpreter struct {
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
 
-	// map to track when a address code was set, to avoid fetching again from fork
-	// TODO: this is ugly think in to refactor
-	addressCodeSet    map[common.Address]struct{}
-	addressBalanceSet map[common.Address]struct{}
-	// key should be address:key
-	addressStorageSet        map[string]common.Hash
-	addressSlotAccessListSet map[string]struct{}
-	// access list
-	accessList types.AccessList
-}
-
-type RecordToInitiateState struct {
-	// map to track when a address code was set, to avoid fetching again from fork
-	AddressCodeSet    map[common.Address]struct{}
-	AddressBalanceSet map[common.Address]struct{}
-	// key should be address:key
-	AddressStorageSet map[string]common.Hash
-	// access list
-	AccessList types.AccessList
+	// slots accessed by the execution
+	accessList *accessListBuilder
+	// ctx bounds the requests made to the fork
+	ctx context.Context
+	// called after every successful SSTORE
+	storageChangeCallback func(addr common.Address, slot, oldVal, newVal common.Hash)
+	// called on every EIP-2929 access of an account or slot
+	accessCallback func(addr common.Address, slot *common.Hash, cold bool)
+	// forkState is the state fetching what it's missing from the fork, nil when the
+	// state is local and nothing is fetched
+	forkState ForkState
+	// resolveProxies detects the proxies called, resolving their implementation
+	resolveProxies bool
+	// proxies detected, by address, and the accounts checked for being one
+	proxies        map[common.Address]Proxy
+	proxiesChecked map[common.Address]struct{}
+	// slotHistory, when set, fetches the slots likely read next along with a missing
+	// one, remembering the slots read by every function
+	slotHistory *SlotHistory
+	// fullStorage is the number of slots up to which the whole storage of an account
+	// is fetched at once, fullStorageLoaded tells for the accounts tried if it was
+	fullStorage       int
+	fullStorageLoaded map[common.Address]bool
+	// stepLimit bounds the opcodes run, nil when unbounded
+	stepLimit *stepLimit
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
-func NewEVMInterpreter(evm *EVM, record *RecordToInitiateState, rpcEndpoint string) *EVMInterpreter {
-	rpcClt := rpc.NewClient(rpcEndpoint)
+func NewEVMInterpreter(evm *EVM, provider StateProvider) *EVMInterpreter {
 	// If jump table was not initialised we set the default one.
 	var table *JumpTable
 	switch {
@@ -153,54 +184,93 @@ func NewEVMInterpreter(evm *EVM, record *RecordToInitiateState, rpcEndpoint stri
 	for _, eip := range evm.Config.ExtraEips {
 		if err := EnableEIP(eip, table); err != nil {
 			// Disable it, so caller can check if it's activated or not
-			log.Error("EIP activation failed", "eip", eip, "error", err)
+			logger.Error("EIP activation failed", "eip", eip, "error", err)
 		} else {
 			extraEips = append(extraEips, eip)
 		}
 	}
 	evm.Config.ExtraEips = extraEips
 	interpreter := &EVMInterpreter{
-		rpcClt: rpcClt,
-		evm:    evm,
-		table:  table,
+		provider: provider,
+		evm:      evm,
+		table:    table,
+		ctx:      context.Background(),
+	}
+
+	interpreter.accessList = newAccessListBuilder()
+
+	return interpreter
+}
+
+// OverrideEIPs enables and disables the given EIPs on a copy of the jump table
+// in use, it must be called before Run. EIPs are enabled in ascending order of their
+// number and disabled in descending order, the order the ones changing the same
+// opcodes were activated in, so the table doesn't depend on the order of the lists.
+func (in *EVMInterpreter) OverrideEIPs(enable, disable []int) error {
+	enable = slices.Clone(enable)
+	slices.Sort(enable)
+
+	disable = slices.Clone(disable)
+	slices.Sort(disable)
+	slices.Reverse(disable)
+
+	table := copyJumpTable(in.table)
+	for _, eip := range enable {
+		if err := EnableEIP(eip, table); err != nil {
+			return err
+		}
 	}
 
-	if record != nil {
-		interpreter.addressCodeSet = record.AddressCodeSet
-		interpreter.addressBalanceSet = record.AddressBalanceSet
-		interpreter.addressStorageSet = record.AddressStorageSet
-	} else {
-		interpreter.addressCodeSet = make(map[common.Address]struct{})
-		interpreter.addressBalanceSet = make(map[common.Address]struct{})
-		interpreter.addressStorageSet = make(map[string]common.Hash)
+	for _, eip := range disable {
+		if err := DisableEIP(eip, table); err != nil {
+			return err
+		}
 	}
 
-	interpreter.addressSlotAccessListSet = make(map[string]struct{})
+	in.table = table
 
-	return interpreter
+	return nil
 }
 
-func (in *EVMInterpreter) MarkAddressCode(addr common.Address) {
-	in.addressCodeSet[addr] = struct{}{}
+// SetContext sets the context used by the requests made to the fork during Run.
+func (in *EVMInterpreter) SetContext(ctx context.Context) {
+	in.ctx = ctx
 }
 
-func (in *EVMInterpreter) MarkAddressBalance(addr common.Address) {
-	in.addressBalanceSet[addr] = struct{}{}
+// SetReadOnly forces the read-only mode of the interpreter, it must be called
+// before Run. While set, state modifying opcodes fail with ErrWriteProtection.
+func (in *EVMInterpreter) SetReadOnly(readOnly bool) {
+	in.readOnly = readOnly
 }
 
-func (in *EVMInterpreter) AccessList() types.AccessList {
-	return in.accessList
+// SetStorageChangeCallback installs f to be called after every SSTORE executed
+// by the interpreter, with the value of the slot before and after the store.
+func (in *EVMInterpreter) SetStorageChangeCallback(f func(addr common.Address, slot, oldVal, newVal common.Hash)) {
+	in.storageChangeCallback = f
 }
 
-func (in *EVMInterpreter) GetRecordToInitState() *RecordToInitiateState {
-	return &RecordToInitiateState{
-		AddressCodeSet:    in.addressCodeSet,
-		AddressBalanceSet: in.addressBalanceSet,
-		AddressStorageSet: in.addressStorageSet,
-		AccessList:        in.accessList,
+// SetAccessCallback installs f to be called on every account and slot access charged
+// by EIP-2929, before the access list is updated. slot is nil for accounts.
+func (in *EVMInterpreter) SetAccessCallback(f func(addr common.Address, slot *common.Hash, cold bool)) {
+	in.accessCallback = f
+}
+
+func (in *EVMInterpreter) notifyAccess(addr common.Address, slot *common.Hash, cold bool) {
+	if in.accessCallback != nil {
+		in.accessCallback(addr, slot, cold)
 	}
 }
 
+// SetForkState makes the interpreter fetch ahead through state the slots likely read
+// next, see SetSpeculativeFetch and SetFullStorage. It must be called before Run.
+func (in *EVMInterpreter) SetForkState(state ForkState) {
+	in.forkState = state
+}
+
+func (in *EVMInterpreter) AccessList() types.AccessList {
+	return in.accessList.list()
+}
+
 // Run loops and evaluates the contract's code with the given input data and returns
 // the return byte-slice and an error if one occurred.
 //
@@ -232,11 +302,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		op          OpCode        // current opcode
 		mem         = NewMemory() // bound memory
 		stack       = newstack()  // local stack
-		callContext = &ScopeContext{
-			Memory:   mem,
-			Stack:    stack,
-			Contract: contract,
-		}
+		callContext = newScope(mem, stack, contract)
 		// For optimisation reason we're using uint64 as the program counter.
 		// It's theoretically possible to go above 2^64. The YP defines the PC
 		// to be uint256. Practically much less so feasible.
@@ -253,7 +319,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	// so that it gets executed _after_: the OnOpcode needs the stacks before
 	// they are returned to the pools
 	defer func() {
-		returnStack(stack)
+		returnScope(callContext)
 	}()
 	contract.Input = input
 
@@ -291,25 +357,20 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		// enough stack items available to perform the operation.
 		op = contract.GetOp(pc)
 
-		switch {
-		case readStorage(op):
-			// register address code if needed
-			err = in.registerAddressStorage(op, callContext, "0x"+in.evm.Context.BlockNumber.Text(16))
-			if err != nil {
-				return nil, err
-			}
-		case isCall(op):
-			err = in.registerAddressCodeForCalls(op, callContext, "0x"+in.evm.Context.BlockNumber.Text(16))
-			if err != nil {
-				return nil, err
-			}
-		case isExtCode(op):
-			err = in.registerAddressCodeForExt(op, callContext, "0x"+in.evm.Context.BlockNumber.Text(16))
-			if err != nil {
+		if in.stepLimit != nil {
+			if err = in.stepLimit.step(); err != nil {
 				return nil, err
 			}
 		}
 
+		if in.forkState != nil && op == SLOAD && stack.len() >= 1 {
+			in.fetchAhead(callContext, pc)
+		}
+
+		if in.resolveProxies && isCall(op) && stack.len() >= 2 {
+			in.ResolveProxy(common.Address(stack.Back(1).Bytes20()))
+		}
+
 		if interactWithStorage(op) {
 			in.appendToAccessList(op, callContext)
 		}
@@ -379,11 +440,27 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			}
 		}
 
+		// keep the slot and its value before the store for the callback
+		var (
+			storeSlot   common.Hash
+			storeOldVal common.Hash
+			notifyStore = op == SSTORE && in.storageChangeCallback != nil
+		)
+		if notifyStore {
+			storeSlot = common.Hash(stack.peek().Bytes32())
+			storeOldVal = in.evm.StateDB.GetState(contract.Address(), storeSlot)
+		}
+
 		// execute the operation
 		res, err = operation.execute(&pc, in, callContext)
 		if err != nil {
 			break
 		}
+
+		if notifyStore {
+			newVal := in.evm.StateDB.GetState(contract.Address(), storeSlot)
+			in.storageChangeCallback(contract.Address(), storeSlot, storeOldVal, newVal)
+		}
 		pc++
 	}
 
@@ -394,10 +471,6 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	return res, err
 }
 
-func readStorage(op OpCode) bool {
-	return op == SLOAD
-}
-
 func interactWithStorage(op OpCode) bool {
 	return op == SLOAD || op == SSTORE
 }
@@ -406,182 +479,10 @@ func isCall(op OpCode) bool {
 	return op == CALL || op == CALLCODE || op == DELEGATECALL || op == STATICCALL
 }
 
-func isExtCode(op OpCode) bool {
-	return op == EXTCODECOPY || op == EXTCODEHASH || op == EXTCODESIZE
-}
-
-// registerAddressCodeForCalls in case the opcode will be
-// CALL, CALLCODE, DELEGATECALL, or STATICCALL
-// we will try to fetch the address code
-// that will be requested this opcodes on the current blocknumber
-// and register that code in the evm state.
-// TODO: use cache to avoid double requesting Http
-func (in *EVMInterpreter) registerAddressCodeForCalls(op OpCode, scope *ScopeContext, blk string) error {
-	if len(scope.StackData()) < 3 {
-		return errors.New("insufficient elements in stack")
-	}
-
-	// copy data in stack
-	stackTmp := make([]uint256.Int, len(scope.StackData()))
-	copy(stackTmp, scope.StackData())
-	// extracting element in position len(stackTmp) - 2, which is the address to which our contract
-	// will interact, the element 0 is not needed
-	addr := common.Address(stackTmp[len(stackTmp)-2].Bytes20())
-
-	// if the address code was set once, there's no need to refetch it
-	if _, ok := in.addressCodeSet[addr]; ok {
-		return nil
-	}
-
-	// fetch code and storage of address, and register in evm state
-	// retrieving the latest
-	code, err := in.rpcClt.GetCode(addr.Hex(), blk)
-	if err != nil {
-		return err
-	}
-
-	// check if address exists in state
-	if !in.evm.StateDB.Exist(addr) {
-		// create address
-		in.evm.StateDB.CreateAccount(addr)
-	}
-
-	in.evm.StateDB.SetCode(addr, code)
-	in.addressCodeSet[addr] = struct{}{}
-
-	// set balance in case we will need it
-	if op == CALL || op == CALLCODE {
-		value := stackTmp[len(stackTmp)-3]
-		// currentBalance of account
-		currrentStateBalance := in.evm.StateDB.GetBalance(addr)
-		_, balanceSetOnce := in.addressBalanceSet[addr]
-		if value.Cmp(currrentStateBalance) > 0 && !balanceSetOnce {
-			// current balance in account
-			balanceBig, err := in.rpcClt.GetBalance(addr.Hex(), blk)
-			if err != nil {
-				return err
-			}
-			// wanted balance fetched from rpc
-			balance := uint256.MustFromBig(balanceBig)
-
-			if balance.Cmp(&value) >= 0 {
-				diff := new(uint256.Int).Sub(balance, currrentStateBalance)
-				// add the remaining balance, between wanted and current
-				in.evm.StateDB.AddBalance(addr, diff, tracing.BalanceChangeUnspecified)
-				in.addressBalanceSet[addr] = struct{}{}
-			}
-		}
-	}
-
-	return nil
-}
-
-// registerAddressStorage in case the opcode will be
-//
-// we will try to fetch the address storage
-// that will be requested this opcodes on the current blocknumber
-// and register that storage in the evm state.
-// TODO: use cache to avoid double requesting Http
-func (in *EVMInterpreter) registerAddressStorage(op OpCode, scope *ScopeContext, blk string) error {
-	if len(scope.StackData()) < 1 {
-		return errors.New("insufficient elements in stack")
-	}
-
-	// copy data in stack
-	loc := scope.Stack.peek()
-	hash := common.Hash(loc.Bytes32())
-
-	// if the address storage was set once, there's no need to refetch it
-	key := scope.Address().Hex() + ":" + hash.Hex()
-	if _, ok := in.addressStorageSet[key]; ok {
-		return nil
-	}
-
-	// retrieve storage of value in contract in position hash
-	storage, err := in.rpcClt.GetStorageAt(scope.Address().Hex(), hash.Hex(), blk)
-	if err != nil {
-		return err
-	}
-
-	in.evm.StateDB.SetState(scope.Address(), hash, storage)
-	in.addressStorageSet[key] = storage
-
-	return nil
-}
-
-// registerAddressCodeForExt in case the opcode will be
-//
-//	op == EXTCODECOPY || op == EXTCODEHASH || op == EXTCODESIZE
-//
-// we will try to fetch the address code
-// that will be requested this opcodes on the current blocknumber
-// and register that code in the evm state.
-// TODO: use cache to avoid double requesting Http
-func (in *EVMInterpreter) registerAddressCodeForExt(op OpCode, scope *ScopeContext, blk string) error {
-	if len(scope.StackData()) < 1 {
-		return errors.New("insufficient elements in stack")
-	}
-
-	// copy data in stack
-	stackTmp := make([]uint256.Int, len(scope.StackData()))
-	copy(stackTmp, scope.StackData())
-	// extracting element in position len(stackTmp) - 1, which is the address to which our contract
-	// will interact, the element 0 is not needed
-	addr := common.Address(stackTmp[len(stackTmp)-1].Bytes20())
-
-	// if the address code was set once, there's no need to refetch it
-	if _, ok := in.addressCodeSet[addr]; ok {
-		return nil
-	}
-
-	// fetch code and storage of address, and register in evm state
-	// retrieving the latest
-	code, err := in.rpcClt.GetCode(addr.Hex(), blk)
-	if err != nil {
-		return err
-	}
-
-	// check if address exists in state
-	if !in.evm.StateDB.Exist(addr) {
-		// create address
-		in.evm.StateDB.CreateAccount(addr)
-	}
-
-	in.evm.StateDB.SetCode(addr, code)
-	in.addressCodeSet[addr] = struct{}{}
-
-	return nil
-}
-
 // appendToAccessList will fetch the slots in storage involved in SLOAD or SSTORE op
 // and append it to the access list without duplicating addresses
 func (in *EVMInterpreter) appendToAccessList(op OpCode, scope *ScopeContext) {
 	// copy data in stack
 	loc := scope.Stack.peek()
-	slot := common.Hash(loc.Bytes32())
-	key := scope.Address().Hex() + ":" + slot.Hex()
-
-	if _, ok := in.addressSlotAccessListSet[key]; ok {
-		return
-	}
-
-	addressInitialized := false
-	for i, l := range in.accessList {
-		if l.Address.Cmp(scope.Address()) == 0 {
-			addressInitialized = true
-			in.accessList[i].StorageKeys = append(in.accessList[i].StorageKeys, slot)
-			break
-		}
-	}
-
-	if !addressInitialized {
-		in.accessList = append(in.accessList, types.AccessTuple{
-			Address: scope.Address(),
-			StorageKeys: []common.Hash{
-				slot,
-			},
-		})
-	}
-
-	in.addressSlotAccessListSet[key] = struct{}{}
+	in.accessList.add(scope.Address(), common.Hash(loc.Bytes32()))
 }
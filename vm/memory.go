@@ -17,18 +17,41 @@
 package vm
 
 import (
+	"sync"
+
 	"github.com/holiman/uint256"
 )
 
+// maxPooledMemory is the largest memory returned to memoryPool, so a single
+// execution expanding its memory doesn't keep a large buffer alive
+const maxPooledMemory = 16 << 10
+
+var memoryPool = sync.Pool{
+	New: func() interface{} {
+		return &Memory{}
+	},
+}
+
 // Memory implements a simple memory model for the ethereum virtual machine.
 type Memory struct {
 	store       []byte
 	lastGasCost uint64
 }
 
-// NewMemory returns a new memory model.
+// NewMemory returns a new memory model, taken from a pool shared by the simulations.
 func NewMemory() *Memory {
-	return &Memory{}
+	return memoryPool.Get().(*Memory)
+}
+
+// Free returns the memory to the pool, it must not be used afterwards.
+func (m *Memory) Free() {
+	if cap(m.store) > maxPooledMemory {
+		return
+	}
+
+	m.store = m.store[:0]
+	m.lastGasCost = 0
+	memoryPool.Put(m)
 }
 
 // Set sets offset + size to value
@@ -0,0 +1,46 @@
+package vm
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PrefetchWithProof fetches in a single request the accounts and storage slots in addrs,
+// verifies them against the state root of the block and adds them to the fork state of
+// the interpreter, so Run doesn't need to fetch them one at a time.
+// State already known by the fork state is not overwritten. Nothing is prefetched
+// without fork state or when the provider of the interpreter isn't a ProofProvider.
+func PrefetchWithProof(ctx context.Context, interp *EVMInterpreter, addrs map[common.Address][]common.Hash) error {
+	provider, ok := interp.provider.(ProofProvider)
+	if len(addrs) == 0 || !ok || interp.forkState == nil {
+		return nil
+	}
+
+	batch, err := provider.GetProofBatch(ctx, addrs, interp.forkState.Block())
+	if err != nil {
+		return err
+	}
+
+	for _, account := range batch.Accounts {
+		if err := account.Verify(batch.StateRoot); err != nil {
+			return err
+		}
+	}
+
+	for _, account := range batch.Accounts {
+		interp.forkState.AddAccount(account.Address, account.Balance.ToInt(), uint64(account.Nonce), account.Code)
+
+		storage := make(map[common.Hash]common.Hash, len(account.StorageProof))
+		for _, proof := range account.StorageProof {
+			var value common.Hash
+			if proof.Value != nil {
+				value = common.BigToHash(proof.Value.ToInt())
+			}
+			storage[common.HexToHash(proof.Key)] = value
+		}
+		interp.forkState.AddStorage(account.Address, storage, false)
+	}
+
+	return nil
+}
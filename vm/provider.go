@@ -0,0 +1,30 @@
+package vm
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+// StateProvider is the source of the state of the fork, fetched by the interpreter
+// when the execution reads it. Addresses, positions and blocks are hex encoded, an
+// empty or zero block is the latest one. *rpc.Client is the provider of a node.
+type StateProvider interface {
+	GetCode(ctx context.Context, address, blk string) ([]byte, error)
+	GetStorageAt(ctx context.Context, address, position, blk string) (common.Hash, error)
+	GetBalance(ctx context.Context, address, blk string) (*big.Int, error)
+	GetNonce(ctx context.Context, address, blk string) (uint64, error)
+	GetBlockHash(ctx context.Context, blk string) (common.Hash, error)
+}
+
+// ProofProvider is a StateProvider fetching accounts and slots with their proofs, it's
+// needed to prefetch state. Providers not implementing it fetch the state lazily.
+type ProofProvider interface {
+	StateProvider
+	GetProofBatch(ctx context.Context, addrs map[common.Address][]common.Hash, blk string) (*rpc.ProofBatch, error)
+}
+
+var _ ProofProvider = (*rpc.Client)(nil)
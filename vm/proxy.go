@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ProxyKind is the standard a proxy follows.
+type ProxyKind int
+
+const (
+	// ProxyEIP1967 keeps its implementation in the EIP-1967 implementation slot,
+	// transparent and UUPS proxies among others
+	ProxyEIP1967 ProxyKind = iota
+	// ProxyEIP1822 keeps its implementation in the PROXIABLE slot of EIP-1822
+	ProxyEIP1822
+	// ProxyBeacon reads its implementation from the beacon in the EIP-1967 beacon slot
+	ProxyBeacon
+	// ProxyMinimal is an EIP-1167 minimal proxy, the implementation is in its code
+	ProxyMinimal
+)
+
+func (k ProxyKind) String() string {
+	switch k {
+	case ProxyEIP1967:
+		return "EIP-1967"
+	case ProxyEIP1822:
+		return "EIP-1822"
+	case ProxyBeacon:
+		return "beacon"
+	case ProxyMinimal:
+		return "EIP-1167"
+	default:
+		return "unknown"
+	}
+}
+
+// Proxy is a proxy detected during an execution.
+type Proxy struct {
+	Kind           ProxyKind
+	Implementation common.Address
+	// Beacon of beacon proxies
+	Beacon common.Address
+}
+
+var (
+	// ProxyImplementationSlot is the EIP-1967 implementation slot,
+	// keccak256("eip1967.proxy.implementation") - 1
+	ProxyImplementationSlot = common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+	// ProxyBeaconSlot is the EIP-1967 beacon slot, keccak256("eip1967.proxy.beacon") - 1
+	ProxyBeaconSlot = common.HexToHash("0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeaee59ff6cb3582b35133d50")
+	// ProxiableSlot is the EIP-1822 implementation slot, keccak256("PROXIABLE")
+	ProxiableSlot = common.HexToHash("0xc5f16f0fcc639fa48a6947836d9850f504798523bf8c9a3a87d5876cf622bcf7")
+
+	// slot of the implementation in the UpgradeableBeacon of OpenZeppelin, after
+	// the owner
+	beaconImplementationSlot = common.BigToHash(common.Big1)
+
+	// code of EIP-1167 minimal proxies around the implementation address
+	minimalProxyPrefix = common.FromHex("0x363d3d373d3d3d363d73")
+	minimalProxySuffix = common.FromHex("0x5af43d82803e903d91602b57fd5bf3")
+)
+
+// SetResolveProxies makes the interpreter detect the proxies called and read their
+// implementation up front, it must be called before Run. Proxies are detected by
+// the slots of their standard found in their code.
+func (in *EVMInterpreter) SetResolveProxies(resolve bool) {
+	in.resolveProxies = resolve
+	if in.proxies == nil {
+		in.proxies = make(map[common.Address]Proxy)
+		in.proxiesChecked = make(map[common.Address]struct{})
+	}
+}
+
+// Proxies returns the proxies detected, by address.
+func (in *EVMInterpreter) Proxies() map[common.Address]Proxy {
+	return in.proxies
+}
+
+// ResolveProxy checks whether the code of addr is the one of a proxy, registering
+// its implementation and reading its code, so a fork state fetches it. It does
+// nothing unless SetResolveProxies was called, or when addr was already checked.
+func (in *EVMInterpreter) ResolveProxy(addr common.Address) {
+	if !in.resolveProxies {
+		return
+	}
+
+	if _, ok := in.proxiesChecked[addr]; ok {
+		return
+	}
+	in.proxiesChecked[addr] = struct{}{}
+
+	code := in.evm.StateDB.GetCode(addr)
+	if len(code) == len(minimalProxyPrefix)+common.AddressLength+len(minimalProxySuffix) &&
+		bytes.HasPrefix(code, minimalProxyPrefix) && bytes.HasSuffix(code, minimalProxySuffix) {
+		implementation := common.BytesToAddress(code[len(minimalProxyPrefix) : len(minimalProxyPrefix)+common.AddressLength])
+		in.registerProxy(addr, Proxy{Kind: ProxyMinimal, Implementation: implementation})
+		return
+	}
+
+	for _, standard := range []struct {
+		kind ProxyKind
+		slot common.Hash
+	}{
+		{kind: ProxyEIP1967, slot: ProxyImplementationSlot},
+		{kind: ProxyBeacon, slot: ProxyBeaconSlot},
+		{kind: ProxyEIP1822, slot: ProxiableSlot},
+	} {
+		if !bytes.Contains(code, standard.slot.Bytes()) {
+			continue
+		}
+
+		// the slot of another standard may be in the code as well
+		target := in.readSlot(addr, standard.slot)
+		if target == (common.Address{}) {
+			continue
+		}
+
+		proxy := Proxy{Kind: standard.kind, Implementation: target}
+		if standard.kind == ProxyBeacon {
+			proxy.Beacon = target
+			proxy.Implementation = in.readSlot(target, beaconImplementationSlot)
+			if proxy.Implementation == (common.Address{}) {
+				continue
+			}
+		}
+
+		in.registerProxy(addr, proxy)
+		return
+	}
+}
+
+// registerProxy records proxy, reading the code of its implementation up front
+func (in *EVMInterpreter) registerProxy(addr common.Address, proxy Proxy) {
+	in.evm.StateDB.GetCode(proxy.Implementation)
+	in.proxies[addr] = proxy
+}
+
+// readSlot returns the address held in slot of addr
+func (in *EVMInterpreter) readSlot(addr common.Address, slot common.Hash) common.Address {
+	return common.BytesToAddress(in.evm.StateDB.GetState(addr, slot).Bytes())
+}
@@ -0,0 +1,125 @@
+package runtime
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// AccessReport splits the EIP-2929 accesses of an execution in cold and warm ones,
+// and accounts for the gas the access list of the transaction saved.
+type AccessReport struct {
+	// accesses to accounts and storage slots charged as cold or warm, an entry
+	// accessed again after the call warming it reverted is cold again
+	ColdAccounts uint64
+	WarmAccounts uint64
+	ColdSlots    uint64
+	WarmSlots    uint64
+	// AccessListGas is the intrinsic gas paid for the access list
+	AccessListGas uint64
+	// AccessListSaved is the gas of the cold accesses turned warm by the access list,
+	// the accounts warm regardless, as the origin or the precompiles, are left out
+	AccessListSaved uint64
+	// UnusedAccessList has the entries of the access list never accessed, an address
+	// is kept with its unused keys, and without keys when neither it nor any of its
+	// keys were accessed
+	UnusedAccessList types.AccessList
+}
+
+// NetSaved is the gas saved by the access list minus the gas paid for it, negative
+// when the access list made the transaction more expensive.
+func (r *AccessReport) NetSaved() int64 {
+	return int64(r.AccessListSaved) - int64(r.AccessListGas)
+}
+
+type accessKey struct {
+	address common.Address
+	slot    common.Hash
+	isSlot  bool
+}
+
+// accessCollector records the accesses reported by the interpreter
+type accessCollector struct {
+	report AccessReport
+	// first access of every account and slot, true when it was warm
+	first map[accessKey]bool
+}
+
+func newAccessCollector() *accessCollector {
+	return &accessCollector{first: make(map[accessKey]bool)}
+}
+
+func (c *accessCollector) access(addr common.Address, slot *common.Hash, cold bool) {
+	key := accessKey{address: addr}
+	if slot != nil {
+		key.slot, key.isSlot = *slot, true
+	}
+
+	switch {
+	case slot == nil && cold:
+		c.report.ColdAccounts++
+	case slot == nil:
+		c.report.WarmAccounts++
+	case cold:
+		c.report.ColdSlots++
+	default:
+		c.report.WarmSlots++
+	}
+
+	if _, ok := c.first[key]; !ok {
+		c.first[key] = !cold
+	}
+}
+
+// finish returns the report of the execution with accessList, prewarmed are the
+// accounts warm without it
+func (c *accessCollector) finish(accessList types.AccessList, prewarmed []common.Address) *AccessReport {
+	report := c.report
+	report.AccessListGas = uint64(len(accessList))*params.TxAccessListAddressGas +
+		uint64(accessList.StorageKeys())*params.TxAccessListStorageKeyGas
+
+	warm := make(map[common.Address]struct{}, len(prewarmed))
+	for _, addr := range prewarmed {
+		warm[addr] = struct{}{}
+	}
+
+	// an entry listed twice only saves gas once
+	counted := make(map[accessKey]struct{})
+	saved := func(key accessKey, gas uint64) bool {
+		firstWarm, accessed := c.first[key]
+		if _, ok := counted[key]; accessed && firstWarm && !ok {
+			counted[key] = struct{}{}
+			report.AccessListSaved += gas
+		}
+
+		return accessed
+	}
+
+	for _, tuple := range accessList {
+		var (
+			unused   = types.AccessTuple{Address: tuple.Address}
+			key      = accessKey{address: tuple.Address}
+			accessed bool
+		)
+
+		if _, ok := warm[tuple.Address]; ok {
+			_, accessed = c.first[key]
+		} else {
+			accessed = saved(key, params.ColdAccountAccessCostEIP2929-params.WarmStorageReadCostEIP2929)
+		}
+
+		for _, slot := range tuple.StorageKeys {
+			if saved(accessKey{address: tuple.Address, slot: slot, isSlot: true}, params.ColdSloadCostEIP2929-params.WarmStorageReadCostEIP2929) {
+				accessed = true
+			} else {
+				unused.StorageKeys = append(unused.StorageKeys, slot)
+			}
+		}
+
+		if len(unused.StorageKeys) > 0 || !accessed {
+			report.UnusedAccessList = append(report.UnusedAccessList, unused)
+		}
+	}
+
+	return &report
+}
@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestAccessReport(t *testing.T) {
+	var (
+		address = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		listed  = common.HexToAddress("0x0000000000000000000000000000000000000012")
+		cold    = common.HexToAddress("0x0000000000000000000000000000000000000013")
+		unused  = common.HexToAddress("0x0000000000000000000000000000000000000014")
+	)
+
+	// loads slot 1 twice and slot 2, then the balances of listed and cold
+	code := []byte{
+		byte(ourVm.PUSH1), 0x01, byte(ourVm.SLOAD), byte(ourVm.POP),
+		byte(ourVm.PUSH1), 0x01, byte(ourVm.SLOAD), byte(ourVm.POP),
+		byte(ourVm.PUSH1), 0x02, byte(ourVm.SLOAD), byte(ourVm.POP),
+		byte(ourVm.PUSH20),
+	}
+	code = append(code, listed.Bytes()...)
+	code = append(code, byte(ourVm.BALANCE), byte(ourVm.POP), byte(ourVm.PUSH20))
+	code = append(code, cold.Bytes()...)
+	code = append(code, byte(ourVm.BALANCE), byte(ourVm.POP), byte(ourVm.STOP))
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessList := types.AccessList{
+		{Address: listed},
+		{Address: address, StorageKeys: []common.Hash{common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(3))}},
+		{Address: unused},
+	}
+
+	cfg := &Config{StateProvider: emptyProvider{}, AccessList: accessList, CollectAccessReport: true}
+	result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := result.AccessReport
+	if report == nil {
+		t.Fatal("no access report")
+	}
+
+	counts := [4]uint64{report.ColdAccounts, report.WarmAccounts, report.ColdSlots, report.WarmSlots}
+	if counts != [4]uint64{1, 1, 1, 2} {
+		t.Errorf("cold accounts, warm accounts, cold slots, warm slots = %v, want [1 1 1 2]", counts)
+	}
+
+	// 3 addresses and 2 keys
+	if report.AccessListGas != 3*2400+2*1900 {
+		t.Errorf("access list gas = %d, want %d", report.AccessListGas, 3*2400+2*1900)
+	}
+
+	// a cold account access and a cold load avoided
+	if report.AccessListSaved != 2500+2000 {
+		t.Errorf("access list saved = %d, want %d", report.AccessListSaved, 2500+2000)
+	}
+
+	if report.NetSaved() != 4500-11000 {
+		t.Errorf("net saved = %d, want %d", report.NetSaved(), 4500-11000)
+	}
+
+	wantUnused := types.AccessList{
+		{Address: address, StorageKeys: []common.Hash{common.BigToHash(big.NewInt(3))}},
+		{Address: unused},
+	}
+	if !reflect.DeepEqual(report.UnusedAccessList, wantUnused) {
+		t.Errorf("unused access list = %v, want %v", report.UnusedAccessList, wantUnused)
+	}
+}
@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// CallFrame is a call of the trace tree, it marshals to the JSON of the
+// callTracer of go-ethereum.
+type CallFrame struct {
+	Type         string          `json:"type"`
+	From         common.Address  `json:"from"`
+	Gas          hexutil.Uint64  `json:"gas"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	To           *common.Address `json:"to,omitempty"`
+	Input        hexutil.Bytes   `json:"input"`
+	Output       hexutil.Bytes   `json:"output,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	RevertReason string          `json:"revertReason,omitempty"`
+	Calls        []*CallFrame    `json:"calls,omitempty"`
+	Value        *hexutil.Big    `json:"value,omitempty"`
+	// Implementation of the proxy called, set with Config.ResolveProxies
+	Implementation *common.Address `json:"implementation,omitempty"`
+}
+
+// callTraceHooks returns hooks building the call tree of the execution in root,
+// the hooks in tracer keep being called.
+func callTraceHooks(tracer *tracing.Hooks, root **CallFrame) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	// frames being executed, the last one is the current call
+	var stack []*CallFrame
+
+	hooks.OnEnter = func(depth int, typ byte, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+		toCopy := to
+		frame := &CallFrame{
+			Type:  ourVm.OpCode(typ).String(),
+			From:  from,
+			To:    &toCopy,
+			Input: common.CopyBytes(input),
+			Gas:   hexutil.Uint64(gas),
+		}
+		if value != nil {
+			frame.Value = (*hexutil.Big)(new(big.Int).Set(value))
+		}
+
+		if len(stack) == 0 {
+			*root = frame
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Calls = append(parent.Calls, frame)
+		}
+		stack = append(stack, frame)
+
+		if tracer != nil && tracer.OnEnter != nil {
+			tracer.OnEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+
+	hooks.OnExit = func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+		if len(stack) > 0 {
+			frame := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			frame.GasUsed = hexutil.Uint64(gasUsed)
+			frame.Output = common.CopyBytes(output)
+			if err != nil {
+				frame.Error = err.Error()
+				if errors.Is(err, vm.ErrExecutionReverted) {
+					if reason, unpackErr := abi.UnpackRevert(output); unpackErr == nil {
+						frame.RevertReason = reason
+					}
+				}
+			}
+		}
+
+		if tracer != nil && tracer.OnExit != nil {
+			tracer.OnExit(depth, output, gasUsed, err, reverted)
+		}
+	}
+
+	return hooks
+}
+
+// annotateProxies sets the implementation of the frames of the tree of root calling
+// one of proxies
+func annotateProxies(root *CallFrame, proxies map[common.Address]ourVm.Proxy) {
+	if root == nil || len(proxies) == 0 {
+		return
+	}
+
+	if root.To != nil {
+		if proxy, ok := proxies[*root.To]; ok {
+			implementation := proxy.Implementation
+			root.Implementation = &implementation
+		}
+	}
+
+	for _, call := range root.Calls {
+		annotateProxies(call, proxies)
+	}
+}
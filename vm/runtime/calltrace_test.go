@@ -0,0 +1,82 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestCallTrace(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x000000000000000000000000000000000000cafe")
+		// reverts with empty data
+		initCode = []byte{byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.REVERT)}
+	)
+
+	// CREATE(0, 29, 3) with initCode
+	code := []byte{
+		byte(ourVm.PUSH3), initCode[0], initCode[1], initCode[2], byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x03, byte(ourVm.PUSH1), 0x1d, byte(ourVm.PUSH0), byte(ourVm.CREATE),
+		byte(ourVm.STOP),
+	}
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := []byte{0x01, 0x02}
+	result, err := Execute(context.Background(), contract, big.NewInt(0), code, input, &Config{CollectCallTrace: true}, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := result.CallTrace
+	if root == nil || root.Type != "CALL" || *root.To != contract || string(root.Input) != string(input) {
+		t.Fatalf("unexpected root: %+v", root)
+	}
+
+	if root.GasUsed == 0 || root.Error != "" {
+		t.Fatalf("unexpected root execution: %+v", root)
+	}
+
+	if len(root.Calls) != 1 {
+		t.Fatalf("calls: %+v", root.Calls)
+	}
+
+	create := root.Calls[0]
+	if create.Type != "CREATE" || create.From != contract || *create.To != crypto.CreateAddress(contract, 0) {
+		t.Fatalf("unexpected create: %+v", create)
+	}
+
+	if create.Error != "execution reverted" {
+		t.Fatalf("create error: %q", create.Error)
+	}
+
+	raw, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded["input"] != "0x0102" || decoded["gas"] != root.Gas.String() {
+		t.Fatalf("unexpected json: %s", raw)
+	}
+
+	if _, ok := decoded["calls"].([]interface{}); !ok {
+		t.Fatalf("missing calls: %s", raw)
+	}
+}
@@ -0,0 +1,194 @@
+package runtime
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ConsoleAddress is the address console.log of Hardhat and Foundry calls.
+var ConsoleAddress = common.HexToAddress("0x000000000000000000636F6e736F6c652e6c6f67")
+
+// ConsoleLog is a line logged with console.log during an execution.
+type ConsoleLog struct {
+	// Address is the contract calling console.log
+	Address common.Address
+	Message string
+}
+
+// consoleFunctions are the arguments of the console.log functions by selector
+var consoleFunctions = make(map[[4]byte]abi.Arguments)
+
+func init() {
+	register := func(name string, types ...string) {
+		args := make(abi.Arguments, len(types))
+		for i, typ := range types {
+			t, err := abi.NewType(typ, "", nil)
+			if err != nil {
+				panic(err)
+			}
+			args[i] = abi.Argument{Type: t}
+		}
+
+		// Hardhat's console.sol computes the selectors with the uint and int aliases
+		for _, signature := range []string{
+			name + "(" + strings.Join(types, ",") + ")",
+			name + "(" + strings.NewReplacer("uint256", "uint", "int256", "int").Replace(strings.Join(types, ",")) + ")",
+		} {
+			consoleFunctions[[4]byte(crypto.Keccak256([]byte(signature))[:4])] = args
+		}
+	}
+
+	single := map[string]string{
+		"logUint": "uint256", "logInt": "int256", "logString": "string",
+		"logBool": "bool", "logAddress": "address", "logBytes": "bytes",
+	}
+	for name, typ := range single {
+		register(name, typ)
+		register("log", typ)
+	}
+
+	for n := 1; n <= 32; n++ {
+		typ := fmt.Sprintf("bytes%d", n)
+		register(fmt.Sprintf("logBytes%d", n), typ)
+		register("log", typ)
+	}
+
+	register("log")
+
+	// log with up to 4 arguments of these types
+	types := []string{"uint256", "int256", "string", "bool", "address", "bytes"}
+	var combine func(prefix []string)
+	combine = func(prefix []string) {
+		if len(prefix) >= 2 {
+			register("log", prefix...)
+		}
+
+		if len(prefix) == 4 {
+			return
+		}
+
+		for _, typ := range types {
+			combine(append(prefix[:len(prefix):len(prefix)], typ))
+		}
+	}
+	combine(nil)
+}
+
+// consoleHooks returns hooks appending to logs the lines logged with console.log, the
+// ones of reverted calls included. The hooks in tracer keep being called.
+func consoleHooks(tracer *tracing.Hooks, logs *[]ConsoleLog) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	hooks.OnEnter = func(depth int, typ byte, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+		if to == ConsoleAddress {
+			if message, ok := decodeConsoleLog(input); ok {
+				*logs = append(*logs, ConsoleLog{Address: from, Message: message})
+			}
+		}
+
+		if tracer != nil && tracer.OnEnter != nil {
+			tracer.OnEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+
+	return hooks
+}
+
+// decodeConsoleLog returns the line logged by the console.log call with input
+func decodeConsoleLog(input []byte) (string, bool) {
+	if len(input) < 4 {
+		return "", false
+	}
+
+	args, ok := consoleFunctions[[4]byte(input[:4])]
+	if !ok {
+		return "", false
+	}
+
+	values, err := args.Unpack(input[4:])
+	if err != nil {
+		return "", false
+	}
+
+	return formatConsoleLog(values), true
+}
+
+// formatConsoleLog formats values as console.log does, a first string argument is a
+// format string with %s, %d, %i, %x and %o specifiers. The values left are appended
+// separated by spaces.
+func formatConsoleLog(values []interface{}) string {
+	var (
+		sb   strings.Builder
+		rest = values
+	)
+
+	if format, ok := firstString(values); ok {
+		rest = values[1:]
+		for i := 0; i < len(format); i++ {
+			if format[i] != '%' || i+1 == len(format) {
+				sb.WriteByte(format[i])
+				continue
+			}
+
+			switch spec := format[i+1]; {
+			case spec == '%':
+				sb.WriteByte('%')
+				i++
+			case strings.IndexByte("sdixo", spec) >= 0 && len(rest) > 0:
+				sb.WriteString(formatConsoleValue(rest[0], spec))
+				rest = rest[1:]
+				i++
+			default:
+				sb.WriteByte('%')
+			}
+		}
+	}
+
+	for _, value := range rest {
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(formatConsoleValue(value, 's'))
+	}
+
+	return sb.String()
+}
+
+func firstString(values []interface{}) (string, bool) {
+	if len(values) == 0 {
+		return "", false
+	}
+
+	s, ok := values[0].(string)
+	return s, ok
+}
+
+// formatConsoleValue formats value for the specifier spec
+func formatConsoleValue(value interface{}, spec byte) string {
+	switch v := value.(type) {
+	case *big.Int:
+		if spec == 'x' {
+			return hexutil.EncodeBig(v)
+		}
+		return v.String()
+	case common.Address:
+		return v.Hex()
+	case []byte:
+		return hexutil.Encode(v)
+	case bool, string:
+		return fmt.Sprint(v)
+	}
+
+	// fixed size byte arrays
+	return fmt.Sprintf("0x%x", value)
+}
@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// consoleInput returns the calldata of the console.log function with signature
+func consoleInput(t *testing.T, signature string, types []string, values ...interface{}) []byte {
+	t.Helper()
+
+	args := make(abi.Arguments, len(types))
+	for i, typ := range types {
+		ty, err := abi.NewType(typ, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args[i] = abi.Argument{Type: ty}
+	}
+
+	packed, err := args.Pack(values...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(crypto.Keccak256([]byte(signature))[:4], packed...)
+}
+
+func TestDecodeConsoleLog(t *testing.T) {
+	addr := common.HexToAddress("0x000000000000000000000000000000000000cafe")
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{
+			name:  "string",
+			input: consoleInput(t, "log(string)", []string{"string"}, "hello"),
+			want:  "hello",
+		},
+		{
+			name:  "hardhat uint alias",
+			input: consoleInput(t, "log(uint)", []string{"uint256"}, big.NewInt(42)),
+			want:  "42",
+		},
+		{
+			name:  "logInt",
+			input: consoleInput(t, "logInt(int256)", []string{"int256"}, big.NewInt(-7)),
+			want:  "-7",
+		},
+		{
+			name:  "format string",
+			input: consoleInput(t, "log(string,uint256,address)", []string{"string", "uint256", "address"}, "balance %d of %s", big.NewInt(5), addr),
+			want:  "balance 5 of " + addr.Hex(),
+		},
+		{
+			name:  "values left appended",
+			input: consoleInput(t, "log(string,bool,uint256)", []string{"string", "bool", "uint256"}, "100%% done %s", true, big.NewInt(255)),
+			want:  "100% done true 255",
+		},
+		{
+			name:  "hex specifier",
+			input: consoleInput(t, "log(string,uint256)", []string{"string", "uint256"}, "value %x", big.NewInt(255)),
+			want:  "value 0xff",
+		},
+		{
+			name:  "without format string",
+			input: consoleInput(t, "log(bool,address)", []string{"bool", "address"}, false, addr),
+			want:  "false " + addr.Hex(),
+		},
+		{
+			name:  "fixed bytes",
+			input: consoleInput(t, "logBytes2(bytes2)", []string{"bytes2"}, [2]byte{0xab, 0xcd}),
+			want:  "0xabcd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeConsoleLog(tt.input)
+			if !ok {
+				t.Fatal("input not decoded")
+			}
+
+			if got != tt.want {
+				t.Fatalf("logged %q expected %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := decodeConsoleLog([]byte{0x01, 0x02, 0x03, 0x04}); ok {
+		t.Fatal("unknown selector decoded")
+	}
+}
+
+func TestExecuteConsoleLogs(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		input    = consoleInput(t, "log(string,uint256)", []string{"string", "uint256"}, "value", big.NewInt(3))
+	)
+
+	// STATICCALLs console.log with its input
+	code := append([]byte{
+		byte(ourVm.CALLDATASIZE), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.CALLDATACOPY),
+		byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.CALLDATASIZE), byte(ourVm.PUSH0),
+		byte(ourVm.PUSH20)}, ConsoleAddress.Bytes()...)
+	code = append(code, byte(ourVm.GAS), byte(ourVm.STATICCALL), byte(ourVm.POP), byte(ourVm.STOP))
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Execute(context.Background(), contract, big.NewInt(0), code, input, &Config{StateProvider: emptyProvider{}}, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.ConsoleLogs) != 1 {
+		t.Fatalf("captured %d lines expected 1", len(result.ConsoleLogs))
+	}
+
+	if log := result.ConsoleLogs[0]; log.Address != contract || log.Message != "value 3" {
+		t.Fatalf("captured %+v", log)
+	}
+}
@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// coverageHooks returns hooks recording in coverage the executed pcs of every contract,
+// one bit per byte of code, the hooks in tracer keep being called.
+func coverageHooks(tracer *tracing.Hooks, coverage map[common.Address][]byte) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	hooks.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+		addr := scope.Address()
+		size := uint64(0)
+		// the executed code is the one of the code address, which differs on delegate calls
+		if sc, ok := scope.(*ourVm.ScopeContext); ok {
+			if sc.Contract.CodeAddr != nil {
+				addr = *sc.Contract.CodeAddr
+			}
+			size = uint64(len(sc.Contract.Code))
+		}
+
+		bits := coverage[addr]
+		if need := max(size, pc+1); uint64(len(bits))*8 < need {
+			grown := make([]byte, (need+7)/8)
+			copy(grown, bits)
+			bits = grown
+			coverage[addr] = bits
+		}
+		bits[pc/8] |= 1 << (pc % 8)
+
+		if tracer != nil && tracer.OnOpcode != nil {
+			tracer.OnOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+	}
+
+	return hooks
+}
+
+// CoveragePercent returns the fraction, between 0 and 1, of the reachable instructions
+// of code executed according to coverage. PUSH operands aren't instructions, and bytes
+// after STOP, RETURN, REVERT, INVALID, SELFDESTRUCT or JUMP are unreachable until the
+// next valid jump destination. When jumpdests is nil every JUMPDEST is considered valid.
+func CoveragePercent(coverage []byte, code []byte, jumpdests map[uint64]bool) float64 {
+	var (
+		reachable = true
+		total     int
+		executed  int
+	)
+
+	for pc := uint64(0); pc < uint64(len(code)); pc++ {
+		op := ourVm.OpCode(code[pc])
+		if op == ourVm.JUMPDEST && (jumpdests == nil || jumpdests[pc]) {
+			reachable = true
+		}
+
+		if reachable {
+			total++
+			if pc/8 < uint64(len(coverage)) && coverage[pc/8]&(1<<(pc%8)) != 0 {
+				executed++
+			}
+		}
+
+		switch op {
+		case ourVm.STOP, ourVm.RETURN, ourVm.REVERT, ourVm.INVALID, ourVm.SELFDESTRUCT, ourVm.JUMP:
+			reachable = false
+		}
+
+		if op >= ourVm.PUSH1 && op <= ourVm.PUSH32 {
+			pc += uint64(op - ourVm.PUSH0)
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(executed) / float64(total)
+}
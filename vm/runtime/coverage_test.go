@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestCodeCoverage(t *testing.T) {
+	address := common.HexToAddress("0x000000000000000000000000000000000000cafe")
+
+	tests := []struct {
+		name     string
+		code     []byte
+		expected float64
+	}{
+		{
+			name: "branch free",
+			code: []byte{
+				byte(ourVm.PUSH1), 0x01, byte(ourVm.PUSH1), 0x02, byte(ourVm.ADD),
+				byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+				byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN),
+				// metadata after RETURN is unreachable
+				byte(ourVm.INVALID), 0xa2, 0x64, 0x69, 0x70, 0x66, 0x73,
+			},
+			expected: 1,
+		},
+		{
+			name: "branch not taken",
+			code: []byte{
+				byte(ourVm.PUSH0), byte(ourVm.PUSH1), 0x05, byte(ourVm.JUMPI),
+				byte(ourVm.STOP),
+				byte(ourVm.JUMPDEST), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.REVERT),
+			},
+			expected: 4.0 / 8.0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &Config{CollectCoverage: true}
+			result, err := Execute(context.Background(), address, big.NewInt(0), tt.code, nil, cfg, statedb)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			coverage, ok := result.CodeCoverage[address]
+			if !ok {
+				t.Fatal("missing coverage of the executed contract")
+			}
+
+			if got := CoveragePercent(coverage, tt.code, nil); got != tt.expected {
+				t.Fatalf("coverage: %f expected: %f", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package runtime
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// CreatedContract is a contract deployed with CREATE or CREATE2 during an execution.
+type CreatedContract struct {
+	Address      common.Address
+	Creator      common.Address
+	InitCodeHash common.Hash
+	// Code is the runtime code at the end of the execution
+	Code []byte
+	// Create2 is set when the contract was deployed with CREATE2
+	Create2 bool
+}
+
+// creationHooks returns hooks appending to creations the contracts deployed, creations
+// reverted afterwards, by themselves or by a parent call, are removed. The hooks in
+// tracer keep being called.
+func creationHooks(tracer *tracing.Hooks, creations *[]CreatedContract) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	// number of creations when each frame was entered
+	var frames []int
+
+	hooks.OnEnter = func(depth int, typ byte, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+		frames = append(frames, len(*creations))
+		if op := ourVm.OpCode(typ); op == ourVm.CREATE || op == ourVm.CREATE2 {
+			*creations = append(*creations, CreatedContract{
+				Address:      to,
+				Creator:      from,
+				InitCodeHash: crypto.Keccak256Hash(input),
+				Create2:      op == ourVm.CREATE2,
+			})
+		}
+
+		if tracer != nil && tracer.OnEnter != nil {
+			tracer.OnEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+
+	hooks.OnExit = func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+		if len(frames) > 0 {
+			start := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			if reverted {
+				*creations = (*creations)[:start]
+			}
+		}
+
+		if tracer != nil && tracer.OnExit != nil {
+			tracer.OnExit(depth, output, gasUsed, err, reverted)
+		}
+	}
+
+	return hooks
+}
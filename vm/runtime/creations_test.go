@@ -0,0 +1,71 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestCreatedContracts(t *testing.T) {
+	var (
+		factory = common.HexToAddress("0x000000000000000000000000000000000000cafe")
+		// returns PUSH1 0x2a as runtime code
+		initCode = []byte{
+			byte(ourVm.PUSH2), 0x60, 0x2a, byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+			byte(ourVm.PUSH1), 0x02, byte(ourVm.PUSH1), 0x1e, byte(ourVm.RETURN),
+		}
+		revertingInitCode = []byte{byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.REVERT)}
+	)
+
+	// CREATE(0, 22, 10) with initCode, then CREATE2(0, 61, 3, 0) with revertingInitCode
+	code := []byte{byte(ourVm.PUSH10)}
+	code = append(code, initCode...)
+	code = append(code,
+		byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x0a, byte(ourVm.PUSH1), 0x16, byte(ourVm.PUSH0), byte(ourVm.CREATE),
+		byte(ourVm.PUSH3),
+	)
+	code = append(code, revertingInitCode...)
+	code = append(code,
+		byte(ourVm.PUSH1), 0x20, byte(ourVm.MSTORE),
+		byte(ourVm.PUSH0), byte(ourVm.PUSH1), 0x03, byte(ourVm.PUSH1), 0x3d, byte(ourVm.PUSH0), byte(ourVm.CREATE2),
+		byte(ourVm.STOP),
+	)
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Execute(context.Background(), factory, big.NewInt(0), code, nil, &Config{}, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reverted CREATE2 isn't reported
+	if len(result.CreatedContracts) != 1 {
+		t.Fatalf("created contracts: %+v", result.CreatedContracts)
+	}
+
+	created := result.CreatedContracts[0]
+	if created.Address != crypto.CreateAddress(factory, 0) || created.Creator != factory || created.Create2 {
+		t.Fatalf("unexpected creation: %+v", created)
+	}
+
+	if created.InitCodeHash != crypto.Keccak256Hash(initCode) {
+		t.Fatalf("init code hash: %s", created.InitCodeHash.Hex())
+	}
+
+	if !bytes.Equal(created.Code, []byte{0x60, 0x2a}) {
+		t.Fatalf("runtime code: %x", created.Code)
+	}
+}
@@ -17,6 +17,7 @@
 package runtime
 
 import (
+	"github.com/Gealber/evm-simulator/rpc"
 	"github.com/Gealber/evm-simulator/vm"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
@@ -24,13 +25,18 @@ import (
 	"github.com/holiman/uint256"
 )
 
-func NewEnv(cfg *Config, stateDB *state.StateDB, record *vm.RecordToInitiateState) *vm.EVM {
+func NewEnv(cfg *Config, stateDB *state.StateDB) *vm.EVM {
 	txContext := vm.TxContext{
 		Origin:     cfg.Origin,
 		GasPrice:   cfg.GasPrice,
 		BlobHashes: cfg.BlobHashes,
 		BlobFeeCap: cfg.BlobFeeCap,
 	}
+	gasLimit := cfg.BlockGasLimit
+	if gasLimit == 0 {
+		gasLimit = cfg.GasLimit
+	}
+
 	blockContext := vm.BlockContext{
 		CanTransfer: CanTransfer,
 		Transfer:    Transfer,
@@ -39,13 +45,26 @@ func NewEnv(cfg *Config, stateDB *state.StateDB, record *vm.RecordToInitiateStat
 		BlockNumber: cfg.BlockNumber,
 		Time:        cfg.Time,
 		Difficulty:  cfg.Difficulty,
-		GasLimit:    cfg.GasLimit,
+		GasLimit:    gasLimit,
 		BaseFee:     cfg.BaseFee,
 		BlobBaseFee: cfg.BlobBaseFee,
 		Random:      cfg.Random,
 	}
 
-	return vm.NewEVM(blockContext, txContext, record, stateDB, cfg.ChainConfig, cfg.EVMConfig, cfg.RPCEndpoint)
+	return vm.NewEVM(blockContext, txContext, stateDB, cfg.ChainConfig, cfg.EVMConfig, cfg.stateProvider())
+}
+
+// stateProvider returns the provider the state of the fork is fetched with
+func (cfg *Config) stateProvider() vm.StateProvider {
+	if cfg.StateProvider != nil {
+		return cfg.StateProvider
+	}
+
+	if cfg.RPCClient != nil {
+		return cfg.RPCClient
+	}
+
+	return rpc.NewClient(cfg.RPCEndpoint)
 }
 
 // CanTransfer checks whether there are enough funds in the address' account to make a transfer.
@@ -0,0 +1,113 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/holiman/uint256"
+)
+
+// gasFees holds the fee parameters of an execution once resolved against the base fee.
+type gasFees struct {
+	feeCap *big.Int
+	// price is the effective gas price paid by the sender for every unit of gas
+	price *big.Int
+	// tip is the part of price credited to the coinbase, the rest is burnt
+	tip *big.Int
+}
+
+// newGasFees resolves the effective gas price following EIP-1559. Legacy transactions
+// pay GasPrice, otherwise min(GasFeeCap, BaseFee + GasTipCap) is paid. As in eth_call,
+// no fees are charged when all the prices are zero and nil is returned.
+func newGasFees(cfg *Config, london bool) (*gasFees, error) {
+	feeCap, tipCap := cfg.GasPrice, cfg.GasPrice
+	if cfg.GasFeeCap != nil {
+		feeCap, tipCap = cfg.GasFeeCap, cfg.GasTipCap
+		if tipCap == nil {
+			tipCap = new(big.Int)
+		}
+	}
+
+	if feeCap.Sign() == 0 && tipCap.Sign() == 0 {
+		return nil, nil
+	}
+
+	if tipCap.Cmp(feeCap) > 0 {
+		return nil, fmt.Errorf("%w: tip %s, fee cap %s", core.ErrTipAboveFeeCap, tipCap, feeCap)
+	}
+
+	if !london {
+		return &gasFees{feeCap: feeCap, price: feeCap, tip: feeCap}, nil
+	}
+
+	if feeCap.Cmp(cfg.BaseFee) < 0 {
+		return nil, fmt.Errorf("%w: fee cap %s, base fee %s", core.ErrFeeCapTooLow, feeCap, cfg.BaseFee)
+	}
+
+	price := new(big.Int).Add(cfg.BaseFee, tipCap)
+	if price.Cmp(feeCap) > 0 {
+		price.Set(feeCap)
+	}
+
+	return &gasFees{
+		feeCap: feeCap,
+		price:  price,
+		tip:    new(big.Int).Sub(price, cfg.BaseFee),
+	}, nil
+}
+
+// buyGas deducts the cost of gasLimit plus the intrinsic gas from the sender, after
+// checking it can pay both at the fee cap plus value. An unbounded gas limit is capped
+// to the gas the sender can afford, as eth_estimateGas does, and the gas limit of the
+// execution bought is returned.
+func (f *gasFees) buyGas(stateDB *state.StateDB, from common.Address, gasLimit, intrinsicGas uint64, value *big.Int) (uint64, error) {
+	balance := stateDB.GetBalance(from).ToBig()
+	if balance.Cmp(value) < 0 {
+		return 0, fmt.Errorf("%w: address %s have %s want %s", core.ErrInsufficientFunds, from.Hex(), balance, value)
+	}
+
+	allowance := new(big.Int).Sub(balance, value)
+	allowance.Div(allowance, f.feeCap)
+	if gasLimit == math.MaxUint64 && allowance.IsUint64() {
+		gasLimit = allowance.Uint64() - min(intrinsicGas, allowance.Uint64())
+	}
+	// the gas bought fits in a uint64
+	gasLimit = min(gasLimit, math.MaxUint64-intrinsicGas)
+
+	maxCost := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit+intrinsicGas), f.feeCap)
+	maxCost.Add(maxCost, value)
+	if balance.Cmp(maxCost) < 0 {
+		return 0, fmt.Errorf("%w: address %s have %s want %s", core.ErrInsufficientFunds, from.Hex(), balance, maxCost)
+	}
+
+	stateDB.SubBalance(from, f.cost(gasLimit+intrinsicGas, f.price), tracing.BalanceDecreaseGasBuy)
+
+	return gasLimit, nil
+}
+
+// settle returns to the sender the gas bought, execution and intrinsic gas, and not
+// used, and pays the tip of the used gas to the coinbase, the amount paid is returned.
+func (f *gasFees) settle(stateDB *state.StateDB, from, coinbase common.Address, gasBought, gasUsed uint64) *big.Int {
+	// the intrinsic gas of the access list recorded may exceed the one bought
+	gasUsed = min(gasUsed, gasBought)
+
+	if remaining := gasBought - gasUsed; remaining > 0 {
+		stateDB.AddBalance(from, f.cost(remaining, f.price), tracing.BalanceIncreaseGasReturn)
+	}
+
+	tip := f.cost(gasUsed, f.tip)
+	if !tip.IsZero() {
+		stateDB.AddBalance(coinbase, tip, tracing.BalanceIncreaseRewardTransactionFee)
+	}
+
+	return tip.ToBig()
+}
+
+func (f *gasFees) cost(gas uint64, price *big.Int) *uint256.Int {
+	return uint256.MustFromBig(new(big.Int).Mul(new(big.Int).SetUint64(gas), price))
+}
@@ -0,0 +1,137 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestExecuteFees(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x000000000000000000000000000000000000cafe")
+		origin   = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		coinbase = common.HexToAddress("0x00000000000000000000000000000000000000c0")
+		balance  = big.NewInt(1e18)
+	)
+
+	// returns GASPRICE
+	code := []byte{
+		byte(ourVm.GASPRICE), byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN),
+	}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		price    int64
+		tip      int64
+		expected error
+	}{
+		{
+			name:  "dynamic fee",
+			cfg:   Config{GasLimit: 100000, BaseFee: big.NewInt(10), GasFeeCap: big.NewInt(15), GasTipCap: big.NewInt(3)},
+			price: 13,
+			tip:   3,
+		},
+		{
+			name:  "tip capped by fee cap",
+			cfg:   Config{GasLimit: 100000, BaseFee: big.NewInt(10), GasFeeCap: big.NewInt(12), GasTipCap: big.NewInt(5)},
+			price: 12,
+			tip:   2,
+		},
+		{
+			name:  "legacy",
+			cfg:   Config{GasLimit: 100000, BaseFee: big.NewInt(10), GasPrice: big.NewInt(14)},
+			price: 14,
+			tip:   4,
+		},
+		{
+			name:  "unbounded gas limit",
+			cfg:   Config{BaseFee: big.NewInt(10), GasPrice: big.NewInt(14)},
+			price: 14,
+			tip:   4,
+		},
+		{
+			// the intrinsic gas is bought along with the execution gas
+			name:  "gas limit near the execution gas",
+			cfg:   Config{GasLimit: 20, BaseFee: big.NewInt(10), GasFeeCap: big.NewInt(15), GasTipCap: big.NewInt(3)},
+			price: 13,
+			tip:   3,
+		},
+		{
+			name: "no fees",
+			cfg:  Config{GasLimit: 100000, BaseFee: big.NewInt(10)},
+		},
+		{
+			name:     "fee cap below base fee",
+			cfg:      Config{GasLimit: 100000, BaseFee: big.NewInt(10), GasFeeCap: big.NewInt(9)},
+			expected: core.ErrFeeCapTooLow,
+		},
+		{
+			name:     "tip above fee cap",
+			cfg:      Config{GasLimit: 100000, BaseFee: big.NewInt(10), GasFeeCap: big.NewInt(11), GasTipCap: big.NewInt(12)},
+			expected: core.ErrTipAboveFeeCap,
+		},
+		{
+			name:     "insufficient funds",
+			cfg:      Config{GasLimit: 2e17, BaseFee: big.NewInt(10), GasPrice: big.NewInt(10)},
+			expected: core.ErrInsufficientFunds,
+		},
+		{
+			name:     "insufficient funds for the intrinsic gas",
+			cfg:      Config{GasLimit: 1e17 - 1000, BaseFee: big.NewInt(10), GasPrice: big.NewInt(10)},
+			expected: core.ErrInsufficientFunds,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := tt.cfg
+			cfg.Origin = origin
+			cfg.Coinbase = coinbase
+
+			result, err := Execute(context.Background(), contract, balance, code, nil, &cfg, statedb)
+			if tt.expected != nil {
+				if !errors.Is(err, tt.expected) {
+					t.Fatalf("expected %v got: %v", tt.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if price := new(big.Int).SetBytes(result.Ret); price.Int64() != tt.price || result.EffectiveGasPrice.Int64() != tt.price {
+				t.Fatalf("gas price: %s effective: %s expected %d", price, result.EffectiveGasPrice, tt.price)
+			}
+
+			if result.GasUsed <= result.IntrinsicGas {
+				t.Fatalf("gas used %d without the execution gas, intrinsic %d", result.GasUsed, result.IntrinsicGas)
+			}
+
+			paid := new(big.Int).Mul(big.NewInt(tt.price), new(big.Int).SetUint64(result.GasUsed))
+			if left := statedb.GetBalance(origin).ToBig(); new(big.Int).Sub(balance, left).Cmp(paid) != 0 {
+				t.Fatalf("sender balance: %s expected %s paid", left, paid)
+			}
+
+			tips := new(big.Int).Mul(big.NewInt(tt.tip), new(big.Int).SetUint64(result.GasUsed))
+			if got := statedb.GetBalance(coinbase).ToBig(); got.Cmp(tips) != 0 {
+				t.Fatalf("coinbase balance: %s expected %s", got, tips)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package runtime
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/params"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// ForkOverride enables or disables EIPs on the jump table used by the execution,
+// without modifying the chain configuration.
+type ForkOverride struct {
+	DisableEIPs []int
+	EnableEIPs  []int
+}
+
+// ApplyForkOverride validates the EIPs in o and registers them to be applied
+// on the jump table of the next execution.
+func (cfg *Config) ApplyForkOverride(o ForkOverride) error {
+	for _, eip := range o.EnableEIPs {
+		if !ourVm.ValidEip(eip) {
+			return fmt.Errorf("undefined eip %d", eip)
+		}
+	}
+
+	for _, eip := range o.DisableEIPs {
+		if !ourVm.ValidDisableEip(eip) {
+			return fmt.Errorf("eip %d can't be disabled", eip)
+		}
+	}
+
+	cfg.ForkOverride.EnableEIPs = append(cfg.ForkOverride.EnableEIPs, o.EnableEIPs...)
+	cfg.ForkOverride.DisableEIPs = append(cfg.ForkOverride.DisableEIPs, o.DisableEIPs...)
+
+	return nil
+}
+
+// forks in activation order, AtFork activates every fork up to the chosen one
+var forks = []string{
+	"homestead",
+	"tangerinewhistle",
+	"spuriousdragon",
+	"byzantium",
+	"constantinople",
+	"petersburg",
+	"istanbul",
+	"berlin",
+	"london",
+	"merge",
+	"shanghai",
+	"cancun",
+}
+
+// AtFork sets a chain configuration with every fork up to, and including, fork
+// activated at genesis. Unknown forks leave the configuration untouched.
+func AtFork(fork string) func(*Config) {
+	return func(cfg *Config) {
+		chainConfig := forkChainConfig(fork)
+		if chainConfig != nil {
+			cfg.ChainConfig = chainConfig
+		}
+	}
+}
+
+// ForkChainConfig returns the chain configuration set by AtFork for fork, false when
+// the fork is unknown. The configuration is new on every call.
+func ForkChainConfig(fork string) (*params.ChainConfig, bool) {
+	chainConfig := forkChainConfig(fork)
+	return chainConfig, chainConfig != nil
+}
+
+func forkChainConfig(fork string) *params.ChainConfig {
+	target := -1
+	for i, f := range forks {
+		if f == fork {
+			target = i
+			break
+		}
+	}
+
+	if target < 0 {
+		return nil
+	}
+
+	active := func(f string) bool {
+		for i := 0; i <= target; i++ {
+			if forks[i] == f {
+				return true
+			}
+		}
+		return false
+	}
+
+	block := func(f string) *big.Int {
+		if active(f) {
+			return new(big.Int)
+		}
+		return nil
+	}
+
+	timestamp := func(f string) *uint64 {
+		if active(f) {
+			return new(uint64)
+		}
+		return nil
+	}
+
+	chainConfig := &params.ChainConfig{
+		ChainID:             big.NewInt(1),
+		HomesteadBlock:      block("homestead"),
+		EIP150Block:         block("tangerinewhistle"),
+		EIP155Block:         block("spuriousdragon"),
+		EIP158Block:         block("spuriousdragon"),
+		ByzantiumBlock:      block("byzantium"),
+		ConstantinopleBlock: block("constantinople"),
+		PetersburgBlock:     block("petersburg"),
+		IstanbulBlock:       block("istanbul"),
+		MuirGlacierBlock:    block("istanbul"),
+		BerlinBlock:         block("berlin"),
+		LondonBlock:         block("london"),
+		ShanghaiTime:        timestamp("shanghai"),
+		CancunTime:          timestamp("cancun"),
+	}
+
+	if active("merge") {
+		chainConfig.TerminalTotalDifficulty = big.NewInt(0)
+		chainConfig.TerminalTotalDifficultyPassed = true
+	}
+
+	return chainConfig
+}
+
+// OP Stack hardfork activation times shared by OP Mainnet and Base
+const (
+	canyonTime   = 1704992401 // Shanghai
+	ecotoneTime  = 1710374401 // Cancun
+	isthmusTime  = 1746806401 // Prague, EIP-2537 precompiles
+	bedrockBlock = 105235063
+)
+
+// OptimismChainConfig returns the chain configuration of OP Mainnet.
+func OptimismChainConfig() *params.ChainConfig {
+	shanghai, cancun, prague := uint64(canyonTime), uint64(ecotoneTime), uint64(isthmusTime)
+
+	return &params.ChainConfig{
+		ChainID:                       big.NewInt(10),
+		HomesteadBlock:                big.NewInt(0),
+		EIP150Block:                   big.NewInt(0),
+		EIP155Block:                   big.NewInt(0),
+		EIP158Block:                   big.NewInt(0),
+		ByzantiumBlock:                big.NewInt(0),
+		ConstantinopleBlock:           big.NewInt(0),
+		PetersburgBlock:               big.NewInt(0),
+		IstanbulBlock:                 big.NewInt(0),
+		MuirGlacierBlock:              big.NewInt(0),
+		BerlinBlock:                   big.NewInt(3950000),
+		LondonBlock:                   big.NewInt(bedrockBlock),
+		ArrowGlacierBlock:             big.NewInt(bedrockBlock),
+		GrayGlacierBlock:              big.NewInt(bedrockBlock),
+		MergeNetsplitBlock:            big.NewInt(bedrockBlock),
+		TerminalTotalDifficulty:       big.NewInt(0),
+		TerminalTotalDifficultyPassed: true,
+		ShanghaiTime:                  &shanghai,
+		CancunTime:                    &cancun,
+		PragueTime:                    &prague,
+	}
+}
+
+// BaseChainConfig returns the chain configuration of Base mainnet.
+func BaseChainConfig() *params.ChainConfig {
+	shanghai, cancun, prague := uint64(canyonTime), uint64(ecotoneTime), uint64(isthmusTime)
+
+	return &params.ChainConfig{
+		ChainID:                       big.NewInt(8453),
+		HomesteadBlock:                big.NewInt(0),
+		EIP150Block:                   big.NewInt(0),
+		EIP155Block:                   big.NewInt(0),
+		EIP158Block:                   big.NewInt(0),
+		ByzantiumBlock:                big.NewInt(0),
+		ConstantinopleBlock:           big.NewInt(0),
+		PetersburgBlock:               big.NewInt(0),
+		IstanbulBlock:                 big.NewInt(0),
+		MuirGlacierBlock:              big.NewInt(0),
+		BerlinBlock:                   big.NewInt(0),
+		LondonBlock:                   big.NewInt(0),
+		ArrowGlacierBlock:             big.NewInt(0),
+		GrayGlacierBlock:              big.NewInt(0),
+		MergeNetsplitBlock:            big.NewInt(0),
+		TerminalTotalDifficulty:       big.NewInt(0),
+		TerminalTotalDifficultyPassed: true,
+		ShanghaiTime:                  &shanghai,
+		CancunTime:                    &cancun,
+		PragueTime:                    &prague,
+	}
+}
+
+// Polygon PoS activates its hardforks by block, the times are the approximate ones
+// of the blocks activating Agra, Napoli and Bhilai
+const (
+	polygonBerlinBlock = 14750000
+	polygonLondonBlock = 23850000
+	agraTime           = 1701388800 // Shanghai, block 50523000
+	napoliTime         = 1710936000 // Cancun without blobs, block 54876000
+	bhilaiTime         = 1751328000 // Prague, block 73440256
+)
+
+// PolygonChainConfig returns the chain configuration of Polygon PoS mainnet. It's
+// marked as merged, go-ethereum only applies the rules of Shanghai and later after
+// the merge, so DIFFICULTY reads the prevrandao instead of the difficulty.
+func PolygonChainConfig() *params.ChainConfig {
+	shanghai, cancun, prague := uint64(agraTime), uint64(napoliTime), uint64(bhilaiTime)
+
+	return &params.ChainConfig{
+		ChainID:                       big.NewInt(137),
+		HomesteadBlock:                big.NewInt(0),
+		EIP150Block:                   big.NewInt(0),
+		EIP155Block:                   big.NewInt(0),
+		EIP158Block:                   big.NewInt(0),
+		ByzantiumBlock:                big.NewInt(0),
+		ConstantinopleBlock:           big.NewInt(0),
+		PetersburgBlock:               big.NewInt(0),
+		IstanbulBlock:                 big.NewInt(3395000),
+		MuirGlacierBlock:              big.NewInt(3395000),
+		BerlinBlock:                   big.NewInt(polygonBerlinBlock),
+		LondonBlock:                   big.NewInt(polygonLondonBlock),
+		TerminalTotalDifficulty:       big.NewInt(0),
+		TerminalTotalDifficultyPassed: true,
+		ShanghaiTime:                  &shanghai,
+		CancunTime:                    &cancun,
+		PragueTime:                    &prague,
+	}
+}
+
+// BNB Smart Chain hardfork activation blocks and times
+const (
+	bscBerlinBlock = 31302048   // Berlin and London
+	keplerTime     = 1705996800 // Shanghai
+	haberTime      = 1718863500 // Cancun
+	pascalTime     = 1742436600 // Prague
+)
+
+// BSCChainConfig returns the chain configuration of BNB Smart Chain mainnet, marked
+// as merged as PolygonChainConfig.
+func BSCChainConfig() *params.ChainConfig {
+	shanghai, cancun, prague := uint64(keplerTime), uint64(haberTime), uint64(pascalTime)
+
+	return &params.ChainConfig{
+		ChainID:                       big.NewInt(56),
+		HomesteadBlock:                big.NewInt(0),
+		EIP150Block:                   big.NewInt(0),
+		EIP155Block:                   big.NewInt(0),
+		EIP158Block:                   big.NewInt(0),
+		ByzantiumBlock:                big.NewInt(0),
+		ConstantinopleBlock:           big.NewInt(0),
+		PetersburgBlock:               big.NewInt(0),
+		IstanbulBlock:                 big.NewInt(0),
+		MuirGlacierBlock:              big.NewInt(0),
+		BerlinBlock:                   big.NewInt(bscBerlinBlock),
+		LondonBlock:                   big.NewInt(bscBerlinBlock),
+		TerminalTotalDifficulty:       big.NewInt(0),
+		TerminalTotalDifficultyPassed: true,
+		ShanghaiTime:                  &shanghai,
+		CancunTime:                    &cancun,
+		PragueTime:                    &prague,
+	}
+}
+
+// ChainConfigByID returns the chain configuration of the chain with the given id,
+// reporting whether the chain is known. Unknown chains get the default configuration,
+// with every fork active at genesis, and their chain id.
+func ChainConfigByID(chainID *big.Int) (*params.ChainConfig, bool) {
+	var chainConfig *params.ChainConfig
+	switch chainID.Uint64() {
+	case params.MainnetChainConfig.ChainID.Uint64():
+		chainConfig = params.MainnetChainConfig
+	case params.SepoliaChainConfig.ChainID.Uint64():
+		chainConfig = params.SepoliaChainConfig
+	case params.HoleskyChainConfig.ChainID.Uint64():
+		chainConfig = params.HoleskyChainConfig
+	case 10:
+		return OptimismChainConfig(), true
+	case 8453:
+		return BaseChainConfig(), true
+	case 137:
+		return PolygonChainConfig(), true
+	case 56:
+		return BSCChainConfig(), true
+	}
+
+	if chainConfig != nil && chainID.IsUint64() {
+		// the presets of go-ethereum are shared, don't hand them out
+		copied := *chainConfig
+		return &copied, true
+	}
+
+	cfg := new(Config)
+	SetDefaults(cfg)
+	cfg.ChainConfig.ChainID = new(big.Int).Set(chainID)
+
+	return cfg.ChainConfig, false
+}
@@ -0,0 +1,180 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// sloadCost executes a single SLOAD of slot zero returning the gas charged for it
+func sloadCost(t *testing.T, cfg *Config) (uint64, *ExecutionResult) {
+	code := []byte{byte(ourVm.PUSH1), 0x00, byte(ourVm.SLOAD), byte(ourVm.STOP)}
+	return opcodeCost(t, cfg, code, ourVm.SLOAD)
+}
+
+// opcodeCost executes code on an empty state returning the gas charged for the last
+// execution of target
+func opcodeCost(t *testing.T, cfg *Config, code []byte, target ourVm.OpCode) (uint64, *ExecutionResult) {
+	var (
+		address = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		cost    uint64
+	)
+
+	cfg.EVMConfig.Tracer = &tracing.Hooks{
+		OnOpcode: func(pc uint64, op byte, gas, opCost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			if ourVm.OpCode(op) == target {
+				cost = opCost
+			}
+		},
+	}
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cost, result
+}
+
+func TestApplyForkOverride(t *testing.T) {
+	if cost, _ := sloadCost(t, &Config{}); cost != 2100 {
+		t.Fatalf("cold SLOAD cost: %d expected: 2100", cost)
+	}
+
+	cfg := &Config{}
+	err := cfg.ApplyForkOverride(ForkOverride{DisableEIPs: []int{2929}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cost, _ := sloadCost(t, cfg); cost != 800 {
+		t.Fatalf("SLOAD cost without EIP-2929: %d expected: 800", cost)
+	}
+
+	if err := cfg.ApplyForkOverride(ForkOverride{DisableEIPs: []int{1}}); err == nil {
+		t.Fatal("expected error disabling unknown eip")
+	}
+}
+
+func TestApplyForkOverrideOrder(t *testing.T) {
+	// stores 1 in the empty slot zero
+	code := []byte{byte(ourVm.PUSH1), 0x01, byte(ourVm.PUSH1), 0x00, byte(ourVm.SSTORE), byte(ourVm.STOP)}
+
+	// without EIP-2929 and EIP-3529 the store costs what it did in Istanbul, whatever
+	// the order they're disabled in
+	for _, eips := range [][]int{{2929, 3529}, {3529, 2929}} {
+		cfg := &Config{}
+		if err := cfg.ApplyForkOverride(ForkOverride{DisableEIPs: eips}); err != nil {
+			t.Fatal(err)
+		}
+
+		if cost, _ := opcodeCost(t, cfg, code, ourVm.SSTORE); cost != params.SstoreSetGasEIP2200 {
+			t.Fatalf("disabled: %v SSTORE cost: %d expected: %d", eips, cost, params.SstoreSetGasEIP2200)
+		}
+	}
+}
+
+func TestAtFork(t *testing.T) {
+	cases := map[string]uint64{
+		"istanbul": 800,
+		"berlin":   2100,
+		"london":   2100,
+	}
+
+	for fork, expected := range cases {
+		cfg := &Config{}
+		AtFork(fork)(cfg)
+
+		if cost, _ := sloadCost(t, cfg); cost != expected {
+			t.Fatalf("fork: %s SLOAD cost: %d expected: %d", fork, cost, expected)
+		}
+	}
+}
+
+func TestCallIsthmusPrecompile(t *testing.T) {
+	var (
+		address = common.HexToAddress("0x000000000000000000000000000000000000cafe")
+		// returns CALL(gas, 0x0b, 0, 0, 0, 0, 0), the BLS12-381 G1ADD precompile fails
+		// on empty input while an account without code succeeds
+		code = []byte{
+			byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0),
+			byte(ourVm.PUSH1), 0x0b, byte(ourVm.GAS), byte(ourVm.CALL),
+			byte(ourVm.PUSH0), byte(ourVm.MSTORE), byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN),
+		}
+	)
+
+	tests := []struct {
+		name    string
+		cfg     *Config
+		success bool
+	}{
+		{name: "optimism after isthmus", cfg: &Config{ChainConfig: OptimismChainConfig(), Time: isthmusTime}},
+		{name: "base after isthmus", cfg: &Config{ChainConfig: BaseChainConfig(), Time: isthmusTime}},
+		{name: "base before isthmus", cfg: &Config{ChainConfig: BaseChainConfig(), Time: isthmusTime - 1}, success: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.cfg.BlockNumber = big.NewInt(bedrockBlock + 1)
+			result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, tt.cfg, statedb)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if success := new(big.Int).SetBytes(result.Ret).Sign() == 1; success != tt.success {
+				t.Fatalf("call succeeded: %t expected: %t", success, tt.success)
+			}
+		})
+	}
+}
+
+func TestChainConfigByID(t *testing.T) {
+	tests := []struct {
+		chainID uint64
+		// a block after London at which Cancun is active
+		block  uint64
+		cancun uint64
+	}{
+		{chainID: 137, block: polygonLondonBlock, cancun: napoliTime},
+		{chainID: 56, block: bscBerlinBlock, cancun: haberTime},
+	}
+
+	for _, tt := range tests {
+		chainConfig, known := ChainConfigByID(new(big.Int).SetUint64(tt.chainID))
+		if !known || chainConfig.ChainID.Uint64() != tt.chainID {
+			t.Fatalf("chain %d: known: %t chain id: %s", tt.chainID, known, chainConfig.ChainID)
+		}
+
+		number := new(big.Int).SetUint64(tt.block)
+		if chainConfig.IsLondon(new(big.Int).Sub(number, common.Big1)) || !chainConfig.IsLondon(number) {
+			t.Fatalf("chain %d: london not activated at block %d", tt.chainID, tt.block)
+		}
+
+		if rules := chainConfig.Rules(number, true, tt.cancun-1); rules.IsCancun || !rules.IsShanghai {
+			t.Fatalf("chain %d: shanghai: %t cancun: %t before cancun", tt.chainID, rules.IsShanghai, rules.IsCancun)
+		}
+
+		if rules := chainConfig.Rules(number, true, tt.cancun); !rules.IsCancun {
+			t.Fatalf("chain %d: cancun not activated at %d", tt.chainID, tt.cancun)
+		}
+	}
+}
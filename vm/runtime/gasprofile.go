@@ -0,0 +1,123 @@
+package runtime
+
+import (
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// GasProfileEntry is the gas used by the calls to a function of a contract.
+type GasProfileEntry struct {
+	// Address of the code executed, the implementation for delegate calls
+	Address common.Address
+	// Selector is the first 4 bytes of the input, zero for creations and calls
+	// with shorter input
+	Selector [4]byte
+	Calls    uint64
+	// SelfGas is the gas used by the calls without the one of their subcalls
+	SelfGas uint64
+	// CumulativeGas is the gas used by the calls including their subcalls, recursive
+	// calls are only counted in the outermost one
+	CumulativeGas uint64
+}
+
+type gasProfileKey struct {
+	address  common.Address
+	selector [4]byte
+}
+
+// profileFrame is a call being executed
+type profileFrame struct {
+	key gasProfileKey
+	// gas used by the subcalls
+	childGas uint64
+	// skip frames aren't calls, as the ones of SELFDESTRUCT
+	skip bool
+}
+
+// gasProfileHooks returns hooks aggregating in profile the gas used by every contract
+// and function called, the hooks in tracer keep being called.
+func gasProfileHooks(tracer *tracing.Hooks, profile *[]GasProfileEntry) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	var (
+		stack   []profileFrame
+		entries = make(map[gasProfileKey]int)
+		// number of frames of every key being executed
+		active = make(map[gasProfileKey]int)
+	)
+
+	hooks.OnEnter = func(depth int, typ byte, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+		frame := profileFrame{key: gasProfileKey{address: to}, skip: ourVm.OpCode(typ) == ourVm.SELFDESTRUCT}
+		if op := ourVm.OpCode(typ); op != ourVm.CREATE && op != ourVm.CREATE2 && len(input) >= 4 {
+			frame.key.selector = [4]byte(input[:4])
+		}
+
+		if !frame.skip {
+			active[frame.key]++
+		}
+		stack = append(stack, frame)
+
+		if tracer != nil && tracer.OnEnter != nil {
+			tracer.OnEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+
+	hooks.OnExit = func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+		if len(stack) > 0 {
+			frame := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if !frame.skip {
+				i, ok := entries[frame.key]
+				if !ok {
+					i = len(*profile)
+					entries[frame.key] = i
+					*profile = append(*profile, GasProfileEntry{Address: frame.key.address, Selector: frame.key.selector})
+				}
+
+				entry := &(*profile)[i]
+				entry.Calls++
+				if gasUsed > frame.childGas {
+					entry.SelfGas += gasUsed - frame.childGas
+				}
+
+				active[frame.key]--
+				if active[frame.key] == 0 {
+					entry.CumulativeGas += gasUsed
+				}
+
+				if len(stack) > 0 {
+					stack[len(stack)-1].childGas += gasUsed
+				}
+			}
+		}
+
+		if tracer != nil && tracer.OnExit != nil {
+			tracer.OnExit(depth, output, gasUsed, err, reverted)
+		}
+	}
+
+	return hooks
+}
+
+// sortGasProfile sorts profile by self gas, the most expensive first
+func sortGasProfile(profile []GasProfileEntry) {
+	slices.SortStableFunc(profile, func(a, b GasProfileEntry) int {
+		switch {
+		case a.SelfGas > b.SelfGas:
+			return -1
+		case a.SelfGas < b.SelfGas:
+			return 1
+		}
+
+		return 0
+	})
+}
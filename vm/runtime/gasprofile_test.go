@@ -0,0 +1,75 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestGasProfile(t *testing.T) {
+	var (
+		caller   = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		callee   = common.HexToAddress("0x0000000000000000000000000000000000000012")
+		selector = [4]byte{0xde, 0xad, 0xbe, 0xef}
+		input    = []byte{0x01, 0x02, 0x03, 0x04, 0x05}
+		// stores 1 in slot 0
+		calleeCode = []byte{byte(ourVm.PUSH1), 0x01, byte(ourVm.PUSH0), byte(ourVm.SSTORE), byte(ourVm.STOP)}
+	)
+
+	// calls callee twice with selector as input
+	code := []byte{byte(ourVm.PUSH4)}
+	code = append(code, selector[:]...)
+	code = append(code, byte(ourVm.PUSH1), 0xe0, byte(ourVm.SHL), byte(ourVm.PUSH0), byte(ourVm.MSTORE))
+	for range 2 {
+		code = append(code, byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH1), 0x04, byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH20))
+		code = append(code, callee.Bytes()...)
+		code = append(code, byte(ourVm.GAS), byte(ourVm.CALL), byte(ourVm.POP))
+	}
+	code = append(code, byte(ourVm.STOP))
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetCode(callee, calleeCode)
+
+	cfg := &Config{StateProvider: emptyProvider{}, CollectGasProfile: true}
+	result, err := Execute(context.Background(), caller, big.NewInt(0), code, input, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.GasProfile) != 2 {
+		t.Fatalf("profiled %d functions expected 2: %+v", len(result.GasProfile), result.GasProfile)
+	}
+
+	// the cold SSTORE makes the callee the most expensive
+	calleeEntry, callerEntry := result.GasProfile[0], result.GasProfile[1]
+	if calleeEntry.Address != callee || calleeEntry.Selector != selector || calleeEntry.Calls != 2 {
+		t.Fatalf("callee entry: %+v", calleeEntry)
+	}
+
+	if callerEntry.Address != caller || callerEntry.Selector != [4]byte(input) || callerEntry.Calls != 1 {
+		t.Fatalf("caller entry: %+v", callerEntry)
+	}
+
+	if calleeEntry.SelfGas != calleeEntry.CumulativeGas {
+		t.Fatalf("callee self gas %d cumulative %d", calleeEntry.SelfGas, calleeEntry.CumulativeGas)
+	}
+
+	if callerEntry.CumulativeGas != callerEntry.SelfGas+calleeEntry.CumulativeGas {
+		t.Fatalf("caller cumulative gas %d expected %d", callerEntry.CumulativeGas, callerEntry.SelfGas+calleeEntry.CumulativeGas)
+	}
+
+	// the gas of the call itself is the one of the top entry
+	if callerEntry.CumulativeGas != result.GasUsed-result.IntrinsicGas {
+		t.Fatalf("caller cumulative gas %d, gas used %d", callerEntry.CumulativeGas, result.GasUsed-result.IntrinsicGas)
+	}
+}
@@ -0,0 +1,129 @@
+package runtime
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/params"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// OpcodeGas is the number of executions of an opcode and the gas they used.
+type OpcodeGas struct {
+	Count uint64
+	// Gas used by the executions, the gas forwarded by calls is left to the opcodes
+	// of the callee
+	Gas uint64
+	// MemoryGas is the part of Gas paid for memory expansion
+	MemoryGas uint64
+}
+
+// histogramFrame is the last opcode executed by a call and the memory size before it
+type histogramFrame struct {
+	op     ourVm.OpCode
+	memory uint64
+}
+
+// opcodeHistogramHooks returns hooks adding to histogram the executions of every
+// opcode, the hooks in tracer keep being called.
+func opcodeHistogramHooks(tracer *tracing.Hooks, histogram map[ourVm.OpCode]*OpcodeGas) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	var (
+		// frames by depth, the memory expanded by an opcode is known on the next one
+		frames []histogramFrame
+		// call opcode whose forwarded gas is still counted
+		pendingCall *OpcodeGas
+	)
+
+	stats := func(op ourVm.OpCode) *OpcodeGas {
+		s, ok := histogram[op]
+		if !ok {
+			s = new(OpcodeGas)
+			histogram[op] = s
+		}
+		return s
+	}
+
+	hooks.OnEnter = func(depth int, typ byte, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+		if pendingCall != nil {
+			pendingCall.Gas -= min(gas, pendingCall.Gas)
+			pendingCall = nil
+		}
+
+		if tracer != nil && tracer.OnEnter != nil {
+			tracer.OnEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+
+	hooks.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+		var (
+			opcode = ourVm.OpCode(op)
+			s      = stats(opcode)
+			memory = uint64(len(scope.MemoryData()))
+		)
+
+		s.Count++
+		s.Gas += cost
+		pendingCall = nil
+		switch opcode {
+		case ourVm.CALL, ourVm.CALLCODE, ourVm.DELEGATECALL, ourVm.STATICCALL:
+			pendingCall = s
+		case ourVm.RETURN, ourVm.REVERT:
+			// the call is over before the next opcode
+			s.MemoryGas += memoryGas(returnMemorySize(scope, memory)) - memoryGas(memory)
+		}
+
+		// frames of the calls returned are done
+		if len(frames) > depth {
+			frames = frames[:depth]
+		}
+
+		if len(frames) == depth {
+			prev := frames[depth-1]
+			if memory > prev.memory {
+				stats(prev.op).MemoryGas += memoryGas(memory) - memoryGas(prev.memory)
+			}
+			frames[depth-1] = histogramFrame{op: opcode, memory: memory}
+		} else {
+			frames = append(frames, histogramFrame{op: opcode, memory: memory})
+		}
+
+		if tracer != nil && tracer.OnOpcode != nil {
+			tracer.OnOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+	}
+
+	return hooks
+}
+
+// returnMemorySize returns the memory size once RETURN or REVERT expand it
+func returnMemorySize(scope tracing.OpContext, memory uint64) uint64 {
+	stack := scope.StackData()
+	if len(stack) < 2 {
+		return memory
+	}
+
+	offset, size := stack[len(stack)-1], stack[len(stack)-2]
+	if size.IsZero() || !offset.IsUint64() || !size.IsUint64() {
+		return memory
+	}
+
+	end := offset.Uint64() + size.Uint64()
+	if end < offset.Uint64() {
+		return memory
+	}
+
+	return max(memory, (end+31)/32*32)
+}
+
+// memoryGas returns the gas paid for size bytes of memory
+func memoryGas(size uint64) uint64 {
+	words := (size + 31) / 32
+	return words*params.MemoryGas + words*words/params.QuadCoeffDiv
+}
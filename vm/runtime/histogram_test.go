@@ -0,0 +1,81 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestOpcodeHistogram(t *testing.T) {
+	var (
+		caller = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		callee = common.HexToAddress("0x0000000000000000000000000000000000000012")
+		// returns 64 bytes of memory, expanding it
+		calleeCode = []byte{byte(ourVm.PUSH1), 0x40, byte(ourVm.PUSH0), byte(ourVm.RETURN)}
+	)
+
+	// stores a word then calls callee twice
+	code := []byte{byte(ourVm.PUSH1), 0x2a, byte(ourVm.PUSH0), byte(ourVm.MSTORE)}
+	for range 2 {
+		code = append(code, byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH20))
+		code = append(code, callee.Bytes()...)
+		code = append(code, byte(ourVm.GAS), byte(ourVm.CALL), byte(ourVm.POP))
+	}
+	code = append(code, byte(ourVm.STOP))
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetCode(callee, calleeCode)
+
+	cfg := &Config{StateProvider: emptyProvider{}, CollectOpcodeHistogram: true}
+	result, err := Execute(context.Background(), caller, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	histogram := result.OpcodeHistogram
+	tests := []struct {
+		op        ourVm.OpCode
+		count     uint64
+		gas       uint64
+		memoryGas uint64
+	}{
+		// 3 plus a word of memory
+		{op: ourVm.MSTORE, count: 1, gas: 6, memoryGas: 3},
+		// cold then warm access, the forwarded gas isn't counted
+		{op: ourVm.CALL, count: 2, gas: 2600 + 100, memoryGas: 0},
+		// 2 words of memory in every call
+		{op: ourVm.RETURN, count: 2, gas: 12, memoryGas: 12},
+		{op: ourVm.PUSH0, count: 13, gas: 26},
+	}
+
+	for _, tt := range tests {
+		got, ok := histogram[tt.op]
+		if !ok {
+			t.Fatalf("%s not in histogram", tt.op)
+		}
+
+		if got.Count != tt.count || got.Gas != tt.gas || got.MemoryGas != tt.memoryGas {
+			t.Errorf("%s: %+v expected count %d gas %d memory gas %d", tt.op, *got, tt.count, tt.gas, tt.memoryGas)
+		}
+	}
+
+	// the opcodes account for all the gas of the execution
+	var total uint64
+	for _, stats := range histogram {
+		total += stats.Gas
+	}
+
+	if total != result.GasUsed-result.IntrinsicGas {
+		t.Fatalf("histogram gas %d, execution gas %d", total, result.GasUsed-result.IntrinsicGas)
+	}
+}
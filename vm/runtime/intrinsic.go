@@ -0,0 +1,70 @@
+package runtime
+
+import (
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// IntrinsicGas returns the gas charged before the execution of a call with input and
+// accessList, under the rules of the block it's executed in.
+func IntrinsicGas(input []byte, accessList types.AccessList, rules params.Rules) (uint64, error) {
+	return core.IntrinsicGas(input, accessList, false, rules.IsHomestead, rules.IsIstanbul, rules.IsShanghai)
+}
+
+// IntrinsicGasBreakdown splits the intrinsic gas of a transaction in its components,
+// each field is the gas charged for it.
+type IntrinsicGasBreakdown struct {
+	BaseTxCost uint64
+	// calldata cost following EIP-2028
+	DataZeroBytes    uint64
+	DataNonZeroBytes uint64
+	// access list cost following EIP-2930
+	AccessListAddresses uint64
+	AccessListSlots     uint64
+	// InitCodeCost is the EIP-3860 cost of the init code words of a contract creation
+	InitCodeCost uint64
+}
+
+// Total is the sum of all the components.
+func (b IntrinsicGasBreakdown) Total() uint64 {
+	return b.BaseTxCost + b.DataZeroBytes + b.DataNonZeroBytes + b.AccessListAddresses + b.AccessListSlots + b.InitCodeCost
+}
+
+// intrinsicGasBreakdown follows the same schedule as core.IntrinsicGas, keeping
+// each component apart.
+func intrinsicGasBreakdown(data []byte, accessList types.AccessList, isContractCreation, isHomestead, isEIP2028, isEIP3860 bool) IntrinsicGasBreakdown {
+	var b IntrinsicGasBreakdown
+
+	b.BaseTxCost = params.TxGas
+	if isContractCreation && isHomestead {
+		b.BaseTxCost = params.TxGasContractCreation
+	}
+
+	nonZeroGas := params.TxDataNonZeroGasFrontier
+	if isEIP2028 {
+		nonZeroGas = params.TxDataNonZeroGasEIP2028
+	}
+
+	for _, c := range data {
+		if c == 0 {
+			b.DataZeroBytes += params.TxDataZeroGas
+		} else {
+			b.DataNonZeroBytes += nonZeroGas
+		}
+	}
+
+	if isContractCreation && isEIP3860 {
+		b.InitCodeCost = toWordSize(uint64(len(data))) * params.InitCodeWordGas
+	}
+
+	b.AccessListAddresses = uint64(len(accessList)) * params.TxAccessListAddressGas
+	b.AccessListSlots = uint64(accessList.StorageKeys()) * params.TxAccessListStorageKeyGas
+
+	return b
+}
+
+// toWordSize returns the ceiled word size required for init code payment calculation.
+func toWordSize(size uint64) uint64 {
+	return (size + 31) / 32
+}
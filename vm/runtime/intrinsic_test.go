@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestIntrinsicGasBreakdown(t *testing.T) {
+	var (
+		data       = []byte{0x00, 0x00, 0x01, 0x02, 0x03}
+		accessList = types.AccessList{
+			{Address: common.HexToAddress("0x01"), StorageKeys: []common.Hash{{}, {0x01}}},
+			{Address: common.HexToAddress("0x02")},
+		}
+	)
+
+	tests := []struct {
+		name               string
+		isContractCreation bool
+		isIstanbul         bool
+		expected           IntrinsicGasBreakdown
+	}{
+		{
+			name:       "call",
+			isIstanbul: true,
+			expected: IntrinsicGasBreakdown{
+				BaseTxCost:          21000,
+				DataZeroBytes:       2 * 4,
+				DataNonZeroBytes:    3 * 16,
+				AccessListAddresses: 2 * 2400,
+				AccessListSlots:     2 * 1900,
+			},
+		},
+		{
+			name: "call before istanbul",
+			expected: IntrinsicGasBreakdown{
+				BaseTxCost:          21000,
+				DataZeroBytes:       2 * 4,
+				DataNonZeroBytes:    3 * 68,
+				AccessListAddresses: 2 * 2400,
+				AccessListSlots:     2 * 1900,
+			},
+		},
+		{
+			name:               "contract creation",
+			isContractCreation: true,
+			isIstanbul:         true,
+			expected: IntrinsicGasBreakdown{
+				BaseTxCost:          53000,
+				DataZeroBytes:       2 * 4,
+				DataNonZeroBytes:    3 * 16,
+				AccessListAddresses: 2 * 2400,
+				AccessListSlots:     2 * 1900,
+				InitCodeCost:        2,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := intrinsicGasBreakdown(data, accessList, tt.isContractCreation, true, tt.isIstanbul, true)
+			if got != tt.expected {
+				t.Fatalf("breakdown: %+v expected: %+v", got, tt.expected)
+			}
+
+			total, err := core.IntrinsicGas(data, accessList, tt.isContractCreation, true, tt.isIstanbul, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Total() != total {
+				t.Fatalf("total: %d expected: %d", got.Total(), total)
+			}
+		})
+	}
+}
+
+func TestExecuteIntrinsicGasAtBlock(t *testing.T) {
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	random := common.Hash{0x01}
+	cfg := &Config{
+		ChainConfig: params.MainnetChainConfig,
+		BlockNumber: big.NewInt(20_000_000),
+		Time:        1_717_000_000,
+		Random:      &random,
+	}
+
+	// 4 non-zero bytes and 2 zero bytes, charged 16 and 4 gas each since Istanbul
+	input := []byte{0x01, 0x02, 0x00, 0x03, 0x00, 0x04}
+	contract := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	result, err := Execute(context.Background(), contract, big.NewInt(0), []byte{byte(ourVm.STOP)}, input, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := params.TxGas + 4*params.TxDataNonZeroGasEIP2028 + 2*params.TxDataZeroGas
+	if result.IntrinsicGas != expected {
+		t.Fatalf("intrinsic gas: %d expected: %d", result.IntrinsicGas, expected)
+	}
+	if result.IntrinsicBreakdown.Total() != expected {
+		t.Fatalf("intrinsic breakdown: %d expected: %d", result.IntrinsicBreakdown.Total(), expected)
+	}
+}
@@ -0,0 +1,200 @@
+package runtime
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// maxSlotOffset is the highest offset from a hash a slot is decoded as, for array
+// elements and fields of structs
+const maxSlotOffset = 1 << 32
+
+// SlotStep is a level of the path to a storage slot, applied to the slot of the
+// level before.
+type SlotStep struct {
+	// Mapping is set for the keccak256 of Key and the slot, the slot of Key in a
+	// mapping. Otherwise it's the keccak256 of the slot, the data of a dynamic array.
+	Mapping bool
+	Key     common.Hash
+	// Offset is added to the slot, the index of an array element or the field of a
+	// struct, counted in slots
+	Offset uint64
+}
+
+// SlotPath is the way a storage slot is derived from a slot declared by the contract.
+type SlotPath struct {
+	// Base is the slot declared, the one the path starts from
+	Base  common.Hash
+	Steps []SlotStep
+}
+
+// String formats the path as the slot declared followed by the keys of the mappings
+// and the indexes of the arrays, as 3[0xabc][2]. Offsets following a mapping are
+// appended as +1.
+func (p SlotPath) String() string {
+	var sb strings.Builder
+	sb.WriteString(p.Base.Big().String())
+	for _, step := range p.Steps {
+		if step.Mapping {
+			sb.WriteString("[" + hexutil.EncodeBig(step.Key.Big()) + "]")
+			if step.Offset > 0 {
+				sb.WriteString("+" + new(big.Int).SetUint64(step.Offset).String())
+			}
+			continue
+		}
+
+		sb.WriteString("[" + new(big.Int).SetUint64(step.Offset).String() + "]")
+	}
+
+	return sb.String()
+}
+
+// StorageAccess is a storage slot accessed by the execution, decoded with the keccak256
+// preimages computed.
+type StorageAccess struct {
+	Address common.Address
+	Slot    common.Hash
+	Path    SlotPath
+	// Written is set when the slot was stored, otherwise it was only loaded
+	Written bool
+	// Value is the value of the slot once the execution is over
+	Value common.Hash
+	// Variables are the variables of the slot with their value, decoded with the
+	// storage layout of Address when it's known
+	Variables []DecodedVariable
+}
+
+// preimageCollector records the keccak256 preimages and the storage slots accessed
+type preimageCollector struct {
+	preimages map[common.Hash][]byte
+	accesses  []StorageAccess
+	// index of every slot accessed in accesses
+	indexes map[slotKey]int
+}
+
+func newPreimageCollector() *preimageCollector {
+	return &preimageCollector{
+		preimages: make(map[common.Hash][]byte),
+		indexes:   make(map[slotKey]int),
+	}
+}
+
+// hooks returns hooks recording the preimages hashed by KECCAK256 and the slots of
+// SLOAD and SSTORE, the hooks in tracer keep being called.
+func (c *preimageCollector) hooks(tracer *tracing.Hooks) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	hooks.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+		stack := scope.StackData()
+		switch opcode := ourVm.OpCode(op); {
+		case err != nil:
+			// the opcode failed before executing
+		case opcode == ourVm.KECCAK256 && len(stack) >= 2:
+			// slots are derived from one or two words
+			offset, size := stack[len(stack)-1], stack[len(stack)-2]
+			if offset.IsUint64() && size.IsUint64() && (size.Uint64() == 32 || size.Uint64() == 64) {
+				// the memory isn't expanded until the opcode executes
+				preimage := make([]byte, size.Uint64())
+				if memory := scope.MemoryData(); offset.Uint64() < uint64(len(memory)) {
+					copy(preimage, memory[offset.Uint64():])
+				}
+				c.preimages[crypto.Keccak256Hash(preimage)] = preimage
+			}
+		case (opcode == ourVm.SLOAD || opcode == ourVm.SSTORE) && len(stack) >= 1:
+			key := slotKey{address: scope.Address(), slot: stack[len(stack)-1].Bytes32()}
+			i, ok := c.indexes[key]
+			if !ok {
+				i = len(c.accesses)
+				c.indexes[key] = i
+				c.accesses = append(c.accesses, StorageAccess{Address: key.address, Slot: key.slot})
+			}
+			c.accesses[i].Written = c.accesses[i].Written || opcode == ourVm.SSTORE
+		}
+
+		if tracer != nil && tracer.OnOpcode != nil {
+			tracer.OnOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+	}
+
+	return hooks
+}
+
+// finish decodes the slots accessed, once all the preimages are known, with the
+// storage layouts of their contracts
+func (c *preimageCollector) finish(state interface {
+	GetState(common.Address, common.Hash) common.Hash
+}, layouts map[common.Address]*StorageLayout) []StorageAccess {
+	for i := range c.accesses {
+		access := &c.accesses[i]
+		access.Path = DecodeSlot(c.preimages, access.Slot)
+		access.Value = state.GetState(access.Address, access.Slot)
+
+		layout, ok := layouts[access.Address]
+		if !ok {
+			continue
+		}
+
+		for _, variable := range layout.Decode(access.Path) {
+			access.Variables = append(access.Variables, DecodedVariable{StorageVariable: variable, Value: variable.Format(access.Value)})
+		}
+	}
+
+	return c.accesses
+}
+
+// DecodeSlot returns the path deriving slot from the keccak256 preimages, as the
+// ones in ExecutionResult.Preimages. A slot not derived from them is its own base.
+func DecodeSlot(preimages map[common.Hash][]byte, slot common.Hash) SlotPath {
+	hash, offset, ok := closestPreimage(preimages, slot)
+	if !ok {
+		return SlotPath{Base: slot}
+	}
+
+	preimage := preimages[hash]
+	if len(preimage) == 64 {
+		path := DecodeSlot(preimages, common.BytesToHash(preimage[32:]))
+		path.Steps = append(path.Steps, SlotStep{Mapping: true, Key: common.BytesToHash(preimage[:32]), Offset: offset})
+		return path
+	}
+
+	path := DecodeSlot(preimages, common.BytesToHash(preimage))
+	path.Steps = append(path.Steps, SlotStep{Offset: offset})
+	return path
+}
+
+// closestPreimage returns the hash of preimages slot is the closest over, and the
+// offset between them
+func closestPreimage(preimages map[common.Hash][]byte, slot common.Hash) (common.Hash, uint64, bool) {
+	if _, ok := preimages[slot]; ok {
+		return slot, 0, true
+	}
+
+	var (
+		s       = slot.Big()
+		closest common.Hash
+		offset  uint64
+		found   bool
+	)
+	for hash := range preimages {
+		diff := new(big.Int).Sub(s, hash.Big())
+		if diff.Sign() < 0 || !diff.IsUint64() || diff.Uint64() >= maxSlotOffset {
+			continue
+		}
+
+		if !found || diff.Uint64() < offset {
+			closest, offset, found = hash, diff.Uint64(), true
+		}
+	}
+
+	return closest, offset, found
+}
@@ -0,0 +1,98 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestStorageAccesses(t *testing.T) {
+	address := common.HexToAddress("0x0000000000000000000000000000000000000011")
+
+	code := []byte{
+		// loads 3[0xabc]
+		byte(ourVm.PUSH2), 0x0a, 0xbc, byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x03, byte(ourVm.PUSH1), 0x20, byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x40, byte(ourVm.PUSH0), byte(ourVm.KECCAK256), byte(ourVm.SLOAD), byte(ourVm.POP),
+		// stores 1 at 5[2]
+		byte(ourVm.PUSH1), 0x01,
+		byte(ourVm.PUSH1), 0x05, byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.KECCAK256), byte(ourVm.PUSH1), 0x02, byte(ourVm.ADD),
+		byte(ourVm.SSTORE),
+		// loads 1[0xabc][0xdef]
+		byte(ourVm.PUSH2), 0x0a, 0xbc, byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x01, byte(ourVm.PUSH1), 0x20, byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x40, byte(ourVm.PUSH0), byte(ourVm.KECCAK256), byte(ourVm.PUSH1), 0x20, byte(ourVm.MSTORE),
+		byte(ourVm.PUSH2), 0x0d, 0xef, byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+		byte(ourVm.PUSH1), 0x40, byte(ourVm.PUSH0), byte(ourVm.KECCAK256), byte(ourVm.SLOAD), byte(ourVm.POP),
+		// loads 7
+		byte(ourVm.PUSH1), 0x07, byte(ourVm.SLOAD), byte(ourVm.POP),
+		byte(ourVm.STOP),
+	}
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		StateProvider:    emptyProvider{},
+		CollectPreimages: true,
+	}
+	result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	word := func(n int64) []byte {
+		return common.BigToHash(big.NewInt(n)).Bytes()
+	}
+	var (
+		balance = crypto.Keccak256Hash(word(0xabc), word(3))
+		array   = new(big.Int).Add(crypto.Keccak256Hash(word(5)).Big(), big.NewInt(2))
+		inner   = crypto.Keccak256Hash(word(0xabc), word(1))
+		nested  = crypto.Keccak256Hash(word(0xdef), inner.Bytes())
+	)
+
+	tests := []struct {
+		slot    common.Hash
+		path    string
+		written bool
+	}{
+		{slot: balance, path: "3[0xabc]"},
+		{slot: common.BigToHash(array), path: "5[2]", written: true},
+		{slot: nested, path: "1[0xabc][0xdef]"},
+		{slot: common.BigToHash(big.NewInt(7)), path: "7"},
+	}
+
+	if len(result.StorageAccesses) != len(tests) {
+		t.Fatalf("storage accesses = %+v, want %d", result.StorageAccesses, len(tests))
+	}
+
+	for i, tt := range tests {
+		access := result.StorageAccesses[i]
+		if access.Address != address || access.Slot != tt.slot {
+			t.Errorf("access %d is of %s %s, want %s %s", i, access.Address, access.Slot, address, tt.slot)
+		}
+
+		if got := access.Path.String(); got != tt.path {
+			t.Errorf("access %d path = %s, want %s", i, got, tt.path)
+		}
+
+		if access.Written != tt.written {
+			t.Errorf("access %d written = %v, want %v", i, access.Written, tt.written)
+		}
+	}
+
+	if len(result.Preimages) != 4 {
+		t.Errorf("preimages = %d, want 4", len(result.Preimages))
+	}
+}
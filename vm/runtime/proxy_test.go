@@ -0,0 +1,115 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// forkProvider serves the code and storage of a fork, the accounts missing are empty
+type forkProvider struct {
+	emptyProvider
+	code    map[common.Address][]byte
+	storage map[common.Address]map[common.Hash]common.Hash
+}
+
+func (p forkProvider) GetCode(_ context.Context, address, _ string) ([]byte, error) {
+	return p.code[common.HexToAddress(address)], nil
+}
+
+func (p forkProvider) GetStorageAt(_ context.Context, address, position, _ string) (common.Hash, error) {
+	return p.storage[common.HexToAddress(address)][common.HexToHash(position)], nil
+}
+
+func TestExecuteResolveProxies(t *testing.T) {
+	var (
+		address     = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		transparent = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+		minimal     = common.HexToAddress("0x00000000000000000000000000000000000000a2")
+		beaconProxy = common.HexToAddress("0x00000000000000000000000000000000000000a3")
+		beacon      = common.HexToAddress("0x00000000000000000000000000000000000000b3")
+		implA       = common.HexToAddress("0x00000000000000000000000000000000000000c1")
+		implB       = common.HexToAddress("0x00000000000000000000000000000000000000c2")
+		implC       = common.HexToAddress("0x00000000000000000000000000000000000000c3")
+	)
+
+	// code holding slot, as proxies reading it do
+	withSlot := func(slot common.Hash) []byte {
+		return append(append([]byte{byte(ourVm.PUSH32)}, slot.Bytes()...), byte(ourVm.POP), byte(ourVm.STOP))
+	}
+
+	minimalCode := common.FromHex("0x363d3d373d3d3d363d73")
+	minimalCode = append(minimalCode, implB.Bytes()...)
+	minimalCode = append(minimalCode, common.FromHex("0x5af43d82803e903d91602b57fd5bf3")...)
+
+	provider := forkProvider{
+		code: map[common.Address][]byte{
+			transparent: withSlot(ourVm.ProxyImplementationSlot),
+			minimal:     minimalCode,
+			beaconProxy: withSlot(ourVm.ProxyBeaconSlot),
+			beacon:      {byte(ourVm.STOP)},
+			implA:       {byte(ourVm.STOP)},
+			implB:       {byte(ourVm.STOP)},
+			implC:       {byte(ourVm.STOP)},
+		},
+		storage: map[common.Address]map[common.Hash]common.Hash{
+			transparent: {ourVm.ProxyImplementationSlot: common.BytesToHash(implA.Bytes())},
+			beaconProxy: {ourVm.ProxyBeaconSlot: common.BytesToHash(beacon.Bytes())},
+			beacon:      {common.BigToHash(big.NewInt(1)): common.BytesToHash(implC.Bytes())},
+		},
+	}
+
+	var code []byte
+	for _, addr := range []common.Address{transparent, minimal, beaconProxy} {
+		code = append(code, byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH20))
+		code = append(code, addr.Bytes()...)
+		code = append(code, byte(ourVm.GAS), byte(ourVm.CALL), byte(ourVm.POP))
+	}
+	code = append(code, byte(ourVm.STOP))
+
+	statedb, err := NewRemoteStateDB(context.Background(), provider, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{ResolveProxies: true, CollectCallTrace: true}
+	result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[common.Address]ourVm.Proxy{
+		transparent: {Kind: ourVm.ProxyEIP1967, Implementation: implA},
+		minimal:     {Kind: ourVm.ProxyMinimal, Implementation: implB},
+		beaconProxy: {Kind: ourVm.ProxyBeacon, Implementation: implC, Beacon: beacon},
+	}
+	if len(result.Proxies) != len(want) {
+		t.Fatalf("proxies = %+v, want %+v", result.Proxies, want)
+	}
+	for addr, proxy := range want {
+		if result.Proxies[addr] != proxy {
+			t.Errorf("proxy %s = %+v, want %+v", addr, result.Proxies[addr], proxy)
+		}
+	}
+
+	// the implementations never called are fetched up front
+	for _, impl := range []common.Address{implA, implC} {
+		if _, ok := result.Record.AddressCodeSet[impl]; !ok {
+			t.Errorf("code of %s not fetched", impl)
+		}
+	}
+
+	calls := result.CallTrace.Calls
+	if len(calls) != 3 {
+		t.Fatalf("calls = %+v", calls)
+	}
+	for i, impl := range []common.Address{implA, implB, implC} {
+		if calls[i].Implementation == nil || *calls[i].Implementation != impl {
+			t.Errorf("implementation of call %d = %v, want %s", i, calls[i].Implementation, impl)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// recordJSON is the JSON schema of RecordToInitiateState. Every account of the
+// record is a key of code and balance, with empty code or a null balance when it has
+// none, nonces are only listed when not zero. Storage is keyed by account, then slot.
+type recordJSON struct {
+	Code       map[common.Address]hexutil.Bytes               `json:"code"`
+	Balance    map[common.Address]*hexutil.Big                `json:"balance"`
+	Nonce      map[common.Address]hexutil.Uint64              `json:"nonce,omitempty"`
+	Storage    map[common.Address]map[common.Hash]common.Hash `json:"storage"`
+	AccessList types.AccessList                               `json:"accessList,omitempty"`
+}
+
+// MarshalJSON encodes the record with a stable schema, keys are sorted so the same
+// record always gives the same JSON.
+func (r *RecordToInitiateState) MarshalJSON() ([]byte, error) {
+	enc := recordJSON{
+		Code:       make(map[common.Address]hexutil.Bytes, len(r.AddressCodeSet)),
+		Balance:    make(map[common.Address]*hexutil.Big, len(r.AddressBalanceSet)),
+		Nonce:      make(map[common.Address]hexutil.Uint64, len(r.Nonce)),
+		Storage:    make(map[common.Address]map[common.Hash]common.Hash),
+		AccessList: r.AccessList,
+	}
+
+	for addr := range r.AddressCodeSet {
+		enc.Code[addr] = hexutil.Bytes{}
+	}
+
+	for addr, code := range r.Code {
+		enc.Code[addr] = code
+	}
+
+	for addr := range r.AddressBalanceSet {
+		enc.Balance[addr] = (*hexutil.Big)(r.Balance[addr])
+	}
+
+	for addr, nonce := range r.Nonce {
+		enc.Nonce[addr] = hexutil.Uint64(nonce)
+	}
+
+	r.RangeStorage(func(key string, val common.Hash) bool {
+		addr, slot, ok := strings.Cut(key, ":")
+		if !ok {
+			return true
+		}
+
+		storage := enc.Storage[common.HexToAddress(addr)]
+		if storage == nil {
+			storage = make(map[common.Hash]common.Hash)
+			enc.Storage[common.HexToAddress(addr)] = storage
+		}
+		storage[common.HexToHash(slot)] = val
+
+		return true
+	})
+
+	return json.Marshal(enc)
+}
+
+// UnmarshalJSON decodes a record encoded by MarshalJSON.
+func (r *RecordToInitiateState) UnmarshalJSON(b []byte) error {
+	var dec recordJSON
+	err := json.Unmarshal(b, &dec)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.AddressCodeSet = make(map[common.Address]struct{}, len(dec.Code))
+	r.Code = make(map[common.Address][]byte)
+	for addr, code := range dec.Code {
+		r.AddressCodeSet[addr] = struct{}{}
+		if len(code) > 0 {
+			r.Code[addr] = code
+		}
+	}
+
+	r.AddressBalanceSet = make(map[common.Address]struct{}, len(dec.Balance))
+	r.Balance = nil
+	for addr, balance := range dec.Balance {
+		r.AddressBalanceSet[addr] = struct{}{}
+		if balance != nil {
+			if r.Balance == nil {
+				r.Balance = make(map[common.Address]*big.Int)
+			}
+			r.Balance[addr] = balance.ToInt()
+		}
+	}
+
+	r.Nonce = make(map[common.Address]uint64, len(dec.Nonce))
+	for addr, nonce := range dec.Nonce {
+		r.Nonce[addr] = uint64(nonce)
+	}
+
+	r.storage = make(map[string]common.Hash)
+	for addr, storage := range dec.Storage {
+		for slot, val := range storage {
+			r.storage[addr.Hex()+":"+slot.Hex()] = val
+		}
+	}
+
+	r.AccessList = dec.AccessList
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestRecordToInitiateStateJSON(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		eoa      = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		slot     = common.HexToHash("0x01")
+	)
+
+	record := &RecordToInitiateState{
+		AddressCodeSet:    map[common.Address]struct{}{contract: {}, eoa: {}},
+		AddressBalanceSet: map[common.Address]struct{}{contract: {}, eoa: {}},
+		Code:              map[common.Address][]byte{contract: {0x60, 0x01}},
+		Balance:           map[common.Address]*big.Int{contract: new(big.Int), eoa: big.NewInt(7)},
+		Nonce:             map[common.Address]uint64{eoa: 3},
+		AccessList:        types.AccessList{{Address: contract, StorageKeys: []common.Hash{slot}}},
+	}
+	record.Set(contract.Hex()+":"+slot.Hex(), common.HexToHash("0x2a"))
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded RecordToInitiateState
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := decoded.AddressCodeSet[contract]; !ok || !bytes.Equal(decoded.Code[contract], []byte{0x60, 0x01}) {
+		t.Fatalf("code of contract not decoded: %x", decoded.Code[contract])
+	}
+
+	if _, ok := decoded.AddressBalanceSet[eoa]; !ok || decoded.Balance[eoa].Cmp(big.NewInt(7)) != 0 {
+		t.Fatalf("balance of eoa not decoded: %v", decoded.Balance[eoa])
+	}
+
+	if decoded.Nonce[eoa] != 3 {
+		t.Fatalf("nonce of eoa not decoded: %d", decoded.Nonce[eoa])
+	}
+
+	if val, ok := decoded.Get(contract.Hex() + ":" + slot.Hex()); !ok || val != common.HexToHash("0x2a") {
+		t.Fatalf("storage not decoded: %s", val.Hex())
+	}
+
+	if len(decoded.AccessList) != 1 || decoded.AccessList[0].Address != contract {
+		t.Fatalf("access list not decoded: %v", decoded.AccessList)
+	}
+
+	// the encoding is stable
+	again, err := json.Marshal(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(b, again) {
+		t.Fatalf("encoding changed:\n%s\n%s", b, again)
+	}
+}
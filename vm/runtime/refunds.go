@@ -0,0 +1,149 @@
+package runtime
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/params"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// SlotRefund is the refund generated by the SSTOREs to a storage slot.
+type SlotRefund struct {
+	Address common.Address
+	Slot    common.Hash
+	// Stores is the number of SSTOREs changing the refund of the slot
+	Stores uint64
+	// Refund is the net refund of the slot, negative when a store took back more
+	// than the slot generated, as restoring a cleared slot does
+	Refund int64
+}
+
+// RefundReport breaks the refund of an execution down by storage slot. The refunds
+// of reverted calls are left out, as they're reverted as well.
+type RefundReport struct {
+	// Slots are the slots whose refund changed, in the order they were first stored
+	Slots []SlotRefund
+	// Selfdestructs is the refund of the SELFDESTRUCTs before London
+	Selfdestructs uint64
+	// Total is the refund accumulated, the same as ExecutionResult.Refund
+	Total uint64
+	// Cap is the most gas that can be refunded, a fifth of the gas used since
+	// EIP-3529 and a half before
+	Cap uint64
+	// Applied is the refund once capped
+	Applied uint64
+}
+
+type slotKey struct {
+	address common.Address
+	slot    common.Hash
+}
+
+// refundChange is a change of the refund made by an opcode
+type refundChange struct {
+	address common.Address
+	slot    common.Hash
+	op      ourVm.OpCode
+	delta   int64
+	depth   int
+}
+
+// refundCollector records the refund changes made by every opcode, reading the
+// refund counter of the state
+type refundCollector struct {
+	state   interface{ GetRefund() uint64 }
+	changes []refundChange
+	// refund the last time the counter was read
+	last uint64
+}
+
+// hooks returns hooks recording the refund changes, the hooks in tracer keep being
+// called.
+func (c *refundCollector) hooks(tracer *tracing.Hooks) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	hooks.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+		// the gas of the opcode, and so its refund, is charged before the hook
+		refund := c.state.GetRefund()
+		if refund != c.last {
+			change := refundChange{
+				address: scope.Address(),
+				op:      ourVm.OpCode(op),
+				delta:   int64(refund) - int64(c.last),
+				depth:   depth,
+			}
+			if stack := scope.StackData(); change.op == ourVm.SSTORE && len(stack) > 0 {
+				change.slot = stack[len(stack)-1].Bytes32()
+			}
+			c.changes = append(c.changes, change)
+			c.last = refund
+		}
+
+		if tracer != nil && tracer.OnOpcode != nil {
+			tracer.OnOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+	}
+
+	hooks.OnExit = func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+		// the opcodes of a reverted call run one level deeper than its exit
+		if reverted {
+			i := len(c.changes)
+			for i > 0 && c.changes[i-1].depth > depth {
+				i--
+			}
+			c.changes = c.changes[:i]
+		}
+		c.last = c.state.GetRefund()
+
+		if tracer != nil && tracer.OnExit != nil {
+			tracer.OnExit(depth, output, gasUsed, err, reverted)
+		}
+	}
+
+	return hooks
+}
+
+// refundCap returns the most refunded of an execution using gasUsed before the
+// refund, a fifth of it since London and half of it before
+func refundCap(gasUsed uint64, isLondon bool) uint64 {
+	if isLondon {
+		return gasUsed / params.RefundQuotientEIP3529
+	}
+
+	return gasUsed / params.RefundQuotient
+}
+
+// finish returns the report of an execution using gasUsed before the refund
+func (c *refundCollector) finish(gasUsed uint64, isLondon bool) *RefundReport {
+	report := &RefundReport{Total: c.state.GetRefund()}
+
+	slots := make(map[slotKey]int)
+	for _, change := range c.changes {
+		if change.op != ourVm.SSTORE {
+			if change.delta > 0 {
+				report.Selfdestructs += uint64(change.delta)
+			}
+			continue
+		}
+
+		key := slotKey{address: change.address, slot: change.slot}
+		i, ok := slots[key]
+		if !ok {
+			i = len(report.Slots)
+			slots[key] = i
+			report.Slots = append(report.Slots, SlotRefund{Address: change.address, Slot: change.slot})
+		}
+
+		report.Slots[i].Stores++
+		report.Slots[i].Refund += change.delta
+	}
+
+	report.Cap = refundCap(gasUsed, isLondon)
+	report.Applied = min(report.Total, report.Cap)
+
+	return report
+}
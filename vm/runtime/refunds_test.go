@@ -0,0 +1,130 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestRefundReport(t *testing.T) {
+	var (
+		address = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		callee  = common.HexToAddress("0x0000000000000000000000000000000000000012")
+		slot1   = common.BigToHash(big.NewInt(1))
+		slot2   = common.BigToHash(big.NewInt(2))
+		// clears its slot 1 and reverts
+		calleeCode = []byte{
+			byte(ourVm.PUSH0), byte(ourVm.PUSH1), 0x01, byte(ourVm.SSTORE),
+			byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.REVERT),
+		}
+	)
+
+	// clears slot 1, clears and restores slot 2, then calls callee
+	code := []byte{
+		byte(ourVm.PUSH0), byte(ourVm.PUSH1), 0x01, byte(ourVm.SSTORE),
+		byte(ourVm.PUSH0), byte(ourVm.PUSH1), 0x02, byte(ourVm.SSTORE),
+		byte(ourVm.PUSH1), 0x07, byte(ourVm.PUSH1), 0x02, byte(ourVm.SSTORE),
+		byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH20),
+	}
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(ourVm.GAS), byte(ourVm.CALL), byte(ourVm.POP), byte(ourVm.STOP))
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetCode(address, code)
+	statedb.SetCode(callee, calleeCode)
+	statedb.SetState(address, slot1, common.BigToHash(big.NewInt(5)))
+	statedb.SetState(address, slot2, common.BigToHash(big.NewInt(7)))
+	statedb.SetState(callee, slot1, common.BigToHash(big.NewInt(3)))
+	statedb.Finalise(true)
+
+	cfg := &Config{
+		StateProvider:  emptyProvider{},
+		CollectRefunds: true,
+	}
+	result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := result.RefundReport
+	if report == nil {
+		t.Fatal("no refund report")
+	}
+
+	want := []SlotRefund{
+		// cleared
+		{Address: address, Slot: slot1, Stores: 1, Refund: 4800},
+		// the clearing refund is taken back and the reset one given
+		{Address: address, Slot: slot2, Stores: 2, Refund: 2800},
+	}
+	if len(report.Slots) != len(want) {
+		t.Fatalf("slots = %+v, want %+v", report.Slots, want)
+	}
+	for i := range want {
+		if report.Slots[i] != want[i] {
+			t.Errorf("slot %d = %+v, want %+v", i, report.Slots[i], want[i])
+		}
+	}
+
+	if report.Total != 7600 || report.Total != result.Refund {
+		t.Errorf("total = %d, want 7600 and the refund %d", report.Total, result.Refund)
+	}
+
+	if report.Cap != (result.GasUsed+report.Applied)/5 {
+		t.Errorf("cap = %d, want %d", report.Cap, (result.GasUsed+report.Applied)/5)
+	}
+
+	if report.Applied != min(report.Total, report.Cap) {
+		t.Errorf("applied = %d, want %d", report.Applied, min(report.Total, report.Cap))
+	}
+}
+
+func TestRefundOverCap(t *testing.T) {
+	address := common.HexToAddress("0x0000000000000000000000000000000000000011")
+
+	// clears slots 1, 2 and 3, refunding more than a fifth of the gas used
+	var code []byte
+	for slot := byte(1); slot <= 3; slot++ {
+		code = append(code, byte(ourVm.PUSH0), byte(ourVm.PUSH1), slot, byte(ourVm.SSTORE))
+	}
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetCode(address, code)
+	for slot := int64(1); slot <= 3; slot++ {
+		statedb.SetState(address, common.BigToHash(big.NewInt(slot)), common.BigToHash(big.NewInt(5)))
+	}
+	statedb.Finalise(true)
+
+	cfg := &Config{
+		StateProvider:  emptyProvider{},
+		CollectRefunds: true,
+	}
+	result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Refund != 3*4800 {
+		t.Fatalf("refund = %d, want %d", result.Refund, 3*4800)
+	}
+
+	// only a fifth of the gas used before the refund is given back
+	report := result.RefundReport
+	gasBeforeRefund := result.GasUsed + report.Applied
+	if report.Applied != gasBeforeRefund/5 || report.Applied >= report.Total {
+		t.Errorf("applied = %d of %d, want the cap %d", report.Applied, report.Total, gasBeforeRefund/5)
+	}
+}
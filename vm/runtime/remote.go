@@ -0,0 +1,416 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// fetchRetryDelay is the wait before the first retry of a fetch, doubled on each retry
+var fetchRetryDelay = 100 * time.Millisecond
+
+// remoteDatabase is a state database whose tries fall back to the fork for the
+// accounts and slots they don't hold. Writes land in the in-memory tries, so roots,
+// commits and copies of the state work as with any other database.
+type remoteDatabase struct {
+	state.Database
+
+	clt ourVm.StateProvider
+	blk string
+
+	mu sync.Mutex
+	// ctx bounds the requests to the fork, retries is the number of times a failed
+	// one is retried. Both are set by the execution using the state
+	ctx     context.Context
+	retries int
+	// accounts fetched from the fork, nil for the ones that don't exist, with their code
+	accounts map[common.Address]*types.StateAccount
+	code     map[common.Address][]byte
+	// slots fetched from the fork, only the written ones are kept in the tries
+	slots map[common.Address]map[common.Hash]common.Hash
+	// complete holds the accounts whose whole storage is in slots
+	complete map[common.Address]struct{}
+}
+
+// remoteTrie is a trie of a remoteDatabase
+type remoteTrie struct {
+	state.Trie
+	db *remoteDatabase
+}
+
+var _ ourVm.ForkState = (*remoteDatabase)(nil)
+
+// NewRemoteStateDB returns a state that fetches the code, balance, nonce and storage of
+// accounts from clt at blockNumber the first time they are read, latest when nil.
+// The accounts and slots of record, as fetched by a previous execution, are not
+// fetched again, record may be nil. Executions on the state are bound to their
+// context, failures fetching are returned by Execute.
+//
+// Slots written to zero are kept in the tries so they aren't fetched again. Once
+// committed, the slots of an account whose whole storage was overridden, and the
+// ones of a destroyed account, that weren't written read again as the ones of the fork.
+func NewRemoteStateDB(ctx context.Context, clt ourVm.StateProvider, blockNumber *big.Int, record *RecordToInitiateState) (*state.StateDB, error) {
+	blk := ""
+	if blockNumber != nil {
+		blk = "0x" + blockNumber.Text(16)
+	}
+
+	db := &remoteDatabase{
+		Database: state.NewDatabase(rawdb.NewMemoryDatabase()),
+		ctx:      ctx,
+		clt:      clt,
+		blk:      blk,
+		accounts: make(map[common.Address]*types.StateAccount),
+		code:     make(map[common.Address][]byte),
+		slots:    make(map[common.Address]map[common.Hash]common.Hash),
+		complete: make(map[common.Address]struct{}),
+	}
+
+	if record != nil {
+		db.seed(record)
+	}
+
+	return state.New(types.EmptyRootHash, db, nil)
+}
+
+// IsRemoteState reports whether stateDB was created with NewRemoteStateDB
+func IsRemoteState(stateDB *state.StateDB) bool {
+	_, ok := stateDB.Database().(*remoteDatabase)
+	return ok
+}
+
+// FetchedSlots returns the slots of addr fetched from the fork by stateDB so far, none
+// when it wasn't created with NewRemoteStateDB
+func FetchedSlots(stateDB *state.StateDB, addr common.Address) []common.Hash {
+	db, ok := stateDB.Database().(*remoteDatabase)
+	if !ok {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	slots := make([]common.Hash, 0, len(db.slots[addr]))
+	for slot := range db.slots[addr] {
+		slots = append(slots, slot)
+	}
+
+	return slots
+}
+
+func (db *remoteDatabase) OpenTrie(root common.Hash) (state.Trie, error) {
+	tr, err := db.Database.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteTrie{Trie: tr, db: db}, nil
+}
+
+func (db *remoteDatabase) OpenStorageTrie(stateRoot common.Hash, address common.Address, root common.Hash, self state.Trie) (state.Trie, error) {
+	if tr, ok := self.(*remoteTrie); ok {
+		self = tr.Trie
+	}
+
+	tr, err := db.Database.OpenStorageTrie(stateRoot, address, root, self)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteTrie{Trie: tr, db: db}, nil
+}
+
+func (db *remoteDatabase) CopyTrie(t state.Trie) state.Trie {
+	tr := t.(*remoteTrie)
+	return &remoteTrie{Trie: db.Database.CopyTrie(tr.Trie), db: db}
+}
+
+// BindRemoteState makes the requests of stateDB to the fork use ctx, retrying the
+// failed ones retries times. Execute binds the state it runs on, the state read before
+// it must be bound by the caller. It does nothing on any other state.
+func BindRemoteState(ctx context.Context, stateDB *state.StateDB, retries int) {
+	if db, ok := stateDB.Database().(*remoteDatabase); ok {
+		db.bind(ctx, retries)
+	}
+}
+
+// bind makes the requests to the fork use ctx, retrying the failed ones retries times
+func (db *remoteDatabase) bind(ctx context.Context, retries int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.ctx, db.retries = ctx, retries
+}
+
+// fetch calls f with the context of the database, retrying it with exponential
+// backoff while it fails fetching from the fork
+func (db *remoteDatabase) fetch(f func(ctx context.Context) error) error {
+	db.mu.Lock()
+	ctx, retries := db.ctx, db.retries
+	db.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		err := f(ctx)
+		if err == nil || !errors.Is(err, rpc.ErrRPCFetch) || attempt == retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(fetchRetryDelay << attempt):
+		}
+	}
+}
+
+// account returns the account of the fork, its code is written to the database
+func (db *remoteDatabase) account(addr common.Address) (*types.StateAccount, error) {
+	db.mu.Lock()
+	acc, ok := db.accounts[addr]
+	db.mu.Unlock()
+	if ok {
+		return acc, nil
+	}
+
+	var (
+		balance *big.Int
+		nonce   uint64
+		code    []byte
+	)
+	err := db.fetch(func(ctx context.Context) (err error) {
+		if balance, err = db.clt.GetBalance(ctx, addr.Hex(), db.blk); err != nil {
+			return err
+		}
+
+		if nonce, err = db.clt.GetNonce(ctx, addr.Hex(), db.blk); err != nil {
+			return err
+		}
+
+		code, err = db.clt.GetCode(ctx, addr.Hex(), db.blk)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db.AddAccount(addr, balance, nonce, code)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.accounts[addr], nil
+}
+
+// storage returns the slot of the fork, accounts that don't exist there have no storage
+func (db *remoteDatabase) storage(addr common.Address, key common.Hash) (common.Hash, error) {
+	db.mu.Lock()
+	value, fetched := db.slot(addr, key)
+	db.mu.Unlock()
+	if fetched {
+		return value, nil
+	}
+
+	err := db.fetch(func(ctx context.Context) (err error) {
+		value, err = db.clt.GetStorageAt(ctx, addr.Hex(), key.Hex(), db.blk)
+		return err
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	db.AddStorage(addr, map[common.Hash]common.Hash{key: value}, false)
+
+	return value, nil
+}
+
+// slot returns the slot of addr when it's known without fetching it, the lock must
+// be held
+func (db *remoteDatabase) slot(addr common.Address, key common.Hash) (common.Hash, bool) {
+	if acc, ok := db.accounts[addr]; ok && acc == nil {
+		return common.Hash{}, true
+	}
+
+	if value, ok := db.slots[addr][key]; ok {
+		return value, true
+	}
+
+	_, complete := db.complete[addr]
+	return common.Hash{}, complete
+}
+
+func (db *remoteDatabase) Block() string {
+	return db.blk
+}
+
+func (db *remoteDatabase) StorageFetched(addr common.Address, slot common.Hash) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, fetched := db.slot(addr, slot)
+	return fetched
+}
+
+func (db *remoteDatabase) AddStorage(addr common.Address, slots map[common.Hash]common.Hash, complete bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.slots[addr] == nil {
+		db.slots[addr] = make(map[common.Hash]common.Hash, len(slots))
+	}
+
+	for slot, value := range slots {
+		if _, ok := db.slots[addr][slot]; !ok {
+			db.slots[addr][slot] = value
+		}
+	}
+
+	if complete {
+		db.complete[addr] = struct{}{}
+	}
+}
+
+func (db *remoteDatabase) AddAccount(addr common.Address, balance *big.Int, nonce uint64, code []byte) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.accounts[addr]; ok {
+		return
+	}
+
+	if balance == nil {
+		balance = new(big.Int)
+	}
+
+	if balance.Sign() == 0 && nonce == 0 && len(code) == 0 {
+		db.accounts[addr] = nil
+		return
+	}
+
+	acc := &types.StateAccount{
+		Nonce:    nonce,
+		Balance:  uint256.MustFromBig(balance),
+		Root:     types.EmptyRootHash,
+		CodeHash: types.EmptyCodeHash.Bytes(),
+	}
+
+	if len(code) != 0 {
+		codeHash := crypto.Keccak256Hash(code)
+		rawdb.WriteCode(db.DiskDB(), codeHash, code)
+		acc.CodeHash = codeHash.Bytes()
+		db.code[addr] = code
+	}
+
+	db.accounts[addr] = acc
+}
+
+// seed adds the accounts and slots of record, as if they were fetched
+func (db *remoteDatabase) seed(record *RecordToInitiateState) {
+	accounts := make(map[common.Address]struct{}, len(record.AddressCodeSet))
+	for addr := range record.AddressCodeSet {
+		accounts[addr] = struct{}{}
+	}
+	for addr := range record.AddressBalanceSet {
+		accounts[addr] = struct{}{}
+	}
+
+	for addr := range accounts {
+		db.AddAccount(addr, record.Balance[addr], record.Nonce[addr], record.Code[addr])
+	}
+
+	record.RangeStorage(func(key string, value common.Hash) bool {
+		addr, slot, ok := strings.Cut(key, ":")
+		if ok {
+			db.AddStorage(common.HexToAddress(addr), map[common.Hash]common.Hash{common.HexToHash(slot): value}, false)
+		}
+
+		return true
+	})
+}
+
+// record returns the accounts and slots fetched so far with accessList
+func (db *remoteDatabase) record(accessList types.AccessList) *RecordToInitiateState {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	record := &RecordToInitiateState{
+		AddressCodeSet:    make(map[common.Address]struct{}, len(db.accounts)),
+		AddressBalanceSet: make(map[common.Address]struct{}, len(db.accounts)),
+		Code:              make(map[common.Address][]byte, len(db.code)),
+		Balance:           make(map[common.Address]*big.Int, len(db.accounts)),
+		Nonce:             make(map[common.Address]uint64),
+		AccessList:        accessList,
+		storage:           make(map[string]common.Hash),
+	}
+
+	for addr, acc := range db.accounts {
+		record.AddressCodeSet[addr] = struct{}{}
+		record.AddressBalanceSet[addr] = struct{}{}
+		record.Balance[addr] = new(big.Int)
+		if acc == nil {
+			continue
+		}
+
+		record.Balance[addr] = acc.Balance.ToBig()
+		if acc.Nonce != 0 {
+			record.Nonce[addr] = acc.Nonce
+		}
+		if code, ok := db.code[addr]; ok {
+			record.Code[addr] = code
+		}
+	}
+
+	for addr, slots := range db.slots {
+		for slot, value := range slots {
+			record.storage[addr.Hex()+":"+slot.Hex()] = value
+		}
+	}
+
+	return record
+}
+
+func (t *remoteTrie) GetAccount(address common.Address) (*types.StateAccount, error) {
+	acc, err := t.Trie.GetAccount(address)
+	if err != nil || acc != nil {
+		return acc, err
+	}
+
+	acc, err = t.db.account(address)
+	if err != nil || acc == nil {
+		return nil, err
+	}
+	// the state object keeps the account, it must not modify the cached one
+	return acc.Copy(), nil
+}
+
+func (t *remoteTrie) GetStorage(addr common.Address, key []byte) ([]byte, error) {
+	value, err := t.Trie.GetStorage(addr, key)
+	// slots in the trie are never nil, not even the ones deleted
+	if err != nil || value != nil {
+		return value, err
+	}
+
+	slot, err := t.db.storage(addr, common.BytesToHash(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return common.TrimLeftZeroes(slot[:]), nil
+}
+
+// DeleteStorage keeps the slot as an empty value, so it isn't fetched again
+func (t *remoteTrie) DeleteStorage(addr common.Address, key []byte) error {
+	return t.Trie.UpdateStorage(addr, key, nil)
+}
@@ -0,0 +1,140 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+func TestRemoteStateDB(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		empty    = common.HexToAddress("0x0000000000000000000000000000000000000012")
+		code     = []byte{byte(vm.PUSH0), byte(vm.SLOAD), byte(vm.STOP)}
+		slot     = common.Hash{}
+
+		mu    sync.Mutex
+		calls = make(map[string]int)
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     int               `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		calls[req.Method]++
+		mu.Unlock()
+
+		var addr common.Address
+		json.Unmarshal(req.Params[0], &addr)
+
+		var result interface{}
+		switch req.Method {
+		case "eth_getBalance":
+			result = "0x0"
+			if addr == contract {
+				result = "0x64"
+			}
+		case "eth_getTransactionCount":
+			result = "0x1"
+			if addr == empty {
+				result = "0x0"
+			}
+		case "eth_getCode":
+			result = hexutil.Bytes{}
+			if addr == contract {
+				result = hexutil.Bytes(code)
+			}
+		case "eth_getStorageAt":
+			result = common.BigToHash(common.Big3).Hex()
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "jsonrpc": "2.0", "result": result})
+	}))
+	defer srv.Close()
+
+	stateDB, err := NewRemoteStateDB(context.Background(), rpc.NewClient(srv.URL), common.Big1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsRemoteState(stateDB) {
+		t.Fatal("expected a remote state")
+	}
+
+	if got := stateDB.GetBalance(contract); got.Uint64() != 100 {
+		t.Errorf("expected balance 100, got %s", got)
+	}
+
+	if got := stateDB.GetNonce(contract); got != 1 {
+		t.Errorf("expected nonce 1, got %d", got)
+	}
+
+	if got := stateDB.GetCode(contract); string(got) != string(code) {
+		t.Errorf("expected code %x, got %x", code, got)
+	}
+
+	if got := stateDB.GetState(contract, slot); got != common.BigToHash(common.Big3) {
+		t.Errorf("expected slot 3, got %s", got.Hex())
+	}
+
+	if stateDB.Exist(empty) {
+		t.Error("expected empty account not to exist")
+	}
+
+	// the storage of accounts missing in the fork isn't fetched
+	if got := stateDB.GetState(empty, slot); got != (common.Hash{}) {
+		t.Errorf("expected empty slot, got %s", got.Hex())
+	}
+
+	stateDB.SetState(contract, slot, common.Hash{})
+	stateDB.AddBalance(contract, uint256.NewInt(1), tracing.BalanceChangeUnspecified)
+	root, err := stateDB.Commit(0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateDB, err = state.New(root, stateDB.Database(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := stateDB.GetState(contract, slot); got != (common.Hash{}) {
+		t.Errorf("expected slot zeroed, got %s", got.Hex())
+	}
+
+	if got := stateDB.GetBalance(contract); got.Uint64() != 101 {
+		t.Errorf("expected balance 101, got %s", got)
+	}
+
+	if err := stateDB.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for method, want := range map[string]int{"eth_getBalance": 2, "eth_getCode": 2, "eth_getStorageAt": 1} {
+		if calls[method] != want {
+			t.Errorf("expected %d calls to %s, got %d", want, method, calls[method])
+		}
+	}
+}
@@ -17,20 +17,26 @@
 package runtime
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"math"
 	"math/big"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/tracing"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/holiman/uint256"
 
+	"github.com/Gealber/evm-simulator/rpc"
 	ourVm "github.com/Gealber/evm-simulator/vm"
 )
 
@@ -45,6 +51,12 @@ type Config struct {
 	Time        uint64
 	GasLimit    uint64
 	GasPrice    *big.Int
+	// GasFeeCap and GasTipCap are the EIP-1559 fee parameters, when GasFeeCap is set
+	// the gas price is derived from them and BaseFee instead of using GasPrice. The
+	// sender pays the gas used at that price and the tip goes to Coinbase, nothing is
+	// charged when all the prices are zero.
+	GasFeeCap   *big.Int
+	GasTipCap   *big.Int
 	Value       *big.Int
 	Debug       bool
 	EVMConfig   vm.Config
@@ -53,17 +65,201 @@ type Config struct {
 	BlobHashes  []common.Hash
 	BlobFeeCap  *big.Int
 	Random      *common.Hash
-	RPCEndpoint string
-	ErrorRatio  float64
+	// BlockGasLimit is exposed to the GASLIMIT opcode, GasLimit is used when zero
+	BlockGasLimit uint64
+	RPCEndpoint   string
+	// RPCClient is used to fetch the state of the fork, when nil a client for
+	// RPCEndpoint is created
+	RPCClient *rpc.Client
+	// StateProvider is used instead of RPCClient to fetch ahead the state of the fork,
+	// see SpeculativeFetch, FullStorageSlots and Prefetch. The state itself is fetched
+	// with the provider of NewRemoteStateDB.
+	StateProvider ourVm.StateProvider
+	// FetchRetries is the number of times the requests of a remote state failing with
+	// rpc.ErrRPCFetch are retried, with exponential backoff
+	FetchRetries int
+	ErrorRatio   float64
+	// ForkOverride is applied on top of the jump table selected by ChainConfig
+	ForkOverride ForkOverride
+	// AccessList of an EIP-2930 transaction, nothing is warmed up in advance when nil
+	AccessList types.AccessList
+	// Prefetch lists accounts and slots fetched, with proofs, in a single request
+	// before the execution starts
+	Prefetch map[common.Address][]common.Hash
+	// ReadOnly executes the whole call as a static call, any state modification
+	// fails with ErrWriteProtection
+	ReadOnly bool
+	// StorageChangeCallback, when set, is called after every SSTORE with the
+	// address, the slot and its value before and after the store
+	StorageChangeCallback func(addr common.Address, slot, oldVal, newVal common.Hash)
+	// Precompiles overrides the precompiles of the chain rules, a contract replaces the
+	// precompile at its address or adds one, a nil contract removes it
+	Precompiles map[common.Address]ourVm.PrecompiledContract
+	// OpcodeHooks instruments or alters opcodes of the execution
+	OpcodeHooks *ourVm.OpcodeHooks
+	// Cheatcodes are handled at ourVm.CheatcodeAddress when set, the block context
+	// they set is applied over the one above
+	Cheatcodes *ourVm.Cheatcodes
+	// ResolveProxies detects the proxies called, fetching their implementation up front,
+	// in ExecutionResult.Proxies
+	ResolveProxies bool
+	// SpeculativeFetch fetches, along with a slot missing from the state, the slots
+	// likely read next in a single request, see ourVm.EVMInterpreter.SetSpeculativeFetch
+	SpeculativeFetch bool
+	// SlotHistory keeps the slots read by the functions across executions for
+	// SpeculativeFetch, a fresh one is used by every execution when nil
+	SlotHistory *ourVm.SlotHistory
+	// FullStorageSlots fetches at once the whole storage of the accounts having at most
+	// as many slots, see ourVm.EVMInterpreter.SetFullStorage, zero disables it
+	FullStorageSlots int
+	// MaxSteps aborts the execution with ourVm.ErrStepLimitExceeded once it runs more
+	// opcodes, those of every call included, zero doesn't limit them
+	MaxSteps uint64
+	// StepDeadline aborts the execution with ourVm.ErrStepLimitExceeded once it runs
+	// for longer, zero doesn't limit it
+	StepDeadline time.Duration
+	// CollectCoverage records the pcs executed of every contract in ExecutionResult.CodeCoverage
+	CollectCoverage bool
+	// CollectCallTrace builds the call tree of the execution in ExecutionResult.CallTrace
+	CollectCallTrace bool
+	// CollectGasProfile aggregates the gas used by every contract and function called
+	// in ExecutionResult.GasProfile
+	CollectGasProfile bool
+	// CollectOpcodeHistogram counts the executions and gas of every opcode in
+	// ExecutionResult.OpcodeHistogram
+	CollectOpcodeHistogram bool
+	// CollectAccessReport splits the accesses of the execution in cold and warm ones in
+	// ExecutionResult.AccessReport
+	CollectAccessReport bool
+	// CollectRefunds breaks the refund down by storage slot in ExecutionResult.RefundReport
+	CollectRefunds bool
+	// CollectPreimages records the keccak256 preimages in ExecutionResult.Preimages and
+	// decodes with them the slots accessed in ExecutionResult.StorageAccesses
+	CollectPreimages bool
+	// StorageLayouts decode the variables of the slots of ExecutionResult.StorageAccesses,
+	// by the address whose storage they describe, the proxy for delegate calls
+	StorageLayouts map[common.Address]*StorageLayout
+	// StructLogger enables opcode level tracing with the given options. The logs are
+	// streamed as JSON lines to StructLogWriter when set, otherwise they're returned in
+	// ExecutionResult.StructLogs with the shape of debug_traceTransaction.
+	StructLogger    *logger.Config
+	StructLogWriter io.Writer
 
 	GetHashFn func(n uint64) common.Hash
 }
 
+// RecordToInitiateState is the state a remote state fetched from the fork, to
+// initiate another one with it, see NewRemoteStateDB.
 type RecordToInitiateState struct {
+	// AddressCodeSet and AddressBalanceSet are the accounts fetched
 	AddressCodeSet    map[common.Address]struct{}
 	AddressBalanceSet map[common.Address]struct{}
-	AddressStorageSet map[string]common.Hash
-	AccessList        types.AccessList
+	// Code, Balance and Nonce of the accounts, missing when empty
+	Code       map[common.Address][]byte
+	Balance    map[common.Address]*big.Int
+	Nonce      map[common.Address]uint64
+	AccessList types.AccessList
+
+	// storage holds the slots recorded by address:slot key, only accessed with Get,
+	// Set and RangeStorage as the record may be shared between goroutines
+	storage map[string]common.Hash
+	mu      sync.RWMutex
+}
+
+// Get returns the storage value recorded under key, with the form address:slot.
+func (r *RecordToInitiateState) Get(key string) (common.Hash, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	val, ok := r.storage[key]
+	return val, ok
+}
+
+// Set records the storage value under key, with the form address:slot.
+func (r *RecordToInitiateState) Set(key string, val common.Hash) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.storage == nil {
+		r.storage = make(map[string]common.Hash)
+	}
+	r.storage[key] = val
+}
+
+// RangeStorage calls f for every recorded storage value while holding the read lock,
+// iteration stops when f returns false.
+func (r *RecordToInitiateState) RangeStorage(f func(key string, val common.Hash) bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for key, val := range r.storage {
+		if !f(key, val) {
+			return
+		}
+	}
+}
+
+// Copy returns a deep copy of the record, nil records are copied as nil.
+func (r *RecordToInitiateState) Copy() *RecordToInitiateState {
+	if r == nil {
+		return nil
+	}
+
+	cpy := &RecordToInitiateState{
+		AddressCodeSet:    make(map[common.Address]struct{}, len(r.AddressCodeSet)),
+		AddressBalanceSet: make(map[common.Address]struct{}, len(r.AddressBalanceSet)),
+		Code:              make(map[common.Address][]byte, len(r.Code)),
+		storage:           make(map[string]common.Hash),
+		AccessList:        r.AccessList,
+	}
+
+	for addr, code := range r.Code {
+		cpy.Code[addr] = code
+	}
+
+	if r.Balance != nil {
+		cpy.Balance = make(map[common.Address]*big.Int, len(r.Balance))
+		for addr, balance := range r.Balance {
+			cpy.Balance[addr] = new(big.Int).Set(balance)
+		}
+	}
+
+	if r.Nonce != nil {
+		cpy.Nonce = make(map[common.Address]uint64, len(r.Nonce))
+		for addr, nonce := range r.Nonce {
+			cpy.Nonce[addr] = nonce
+		}
+	}
+
+	for addr := range r.AddressCodeSet {
+		cpy.AddressCodeSet[addr] = struct{}{}
+	}
+
+	for addr := range r.AddressBalanceSet {
+		cpy.AddressBalanceSet[addr] = struct{}{}
+	}
+
+	r.RangeStorage(func(key string, val common.Hash) bool {
+		cpy.storage[key] = val
+		return true
+	})
+
+	return cpy
+}
+
+// RevertError is set in ExecutionResult.Err when the execution reverts, it
+// matches vm.ErrExecutionReverted with errors.Is.
+type RevertError struct {
+	// Data is the return data of the revert
+	Data []byte
+}
+
+func (e *RevertError) Error() string {
+	return vm.ErrExecutionReverted.Error()
+}
+
+func (e *RevertError) Unwrap() error {
+	return vm.ErrExecutionReverted
 }
 
 // sets defaults on the config
@@ -123,29 +319,68 @@ func SetDefaults(cfg *Config) {
 		cfg.BlobBaseFee = big.NewInt(params.BlobTxMinBlobGasprice)
 	}
 	// Merge indicators
-	if t := cfg.ChainConfig.ShanghaiTime; cfg.ChainConfig.TerminalTotalDifficultyPassed || (t != nil && *t == 0) {
+	if t := cfg.ChainConfig.ShanghaiTime; cfg.Random == nil && (cfg.ChainConfig.TerminalTotalDifficultyPassed || (t != nil && *t == 0)) {
 		cfg.Random = &(common.Hash{})
 	}
+}
 
-	// // set EVM tracer in case is not present
-	// if cfg.EVMConfig.Tracer == nil {
-	// 	logCfg := &logger.Config{
-	// 		Debug: true,
-	// 		// DisableStorage: true,
-	// 		// DisableStack: true,
-	// 		EnableReturnData: true,
-	// 		EnableMemory:     true,
-	// 	}
-	// 	cfg.EVMConfig.Tracer = logger.NewJSONLogger(logCfg, os.Stdout)
-	// }
+// Rules returns the rules of the chain at the block of cfg, once its defaults are set.
+func (cfg *Config) Rules() params.Rules {
+	return cfg.ChainConfig.Rules(cfg.BlockNumber, cfg.Random != nil, cfg.Time)
 }
 
 type ExecutionResult struct {
-	Ret          []byte
-	GasUsed      uint64
+	Ret     []byte
+	GasUsed uint64
+	// Refund is the refund accumulated, GasUsed only deducts it up to the cap of the fork
 	Refund       uint64
 	IntrinsicGas uint64
-	Record       *RecordToInitiateState
+	// EffectiveGasPrice is the price paid for every unit of gas used, as in the receipt
+	EffectiveGasPrice *big.Int
+	// PriorityFees are the fees credited to the coinbase, the ones over the base fee
+	PriorityFees *big.Int
+	// IntrinsicBreakdown splits IntrinsicGas in its components
+	IntrinsicBreakdown IntrinsicGasBreakdown
+	Record             *RecordToInitiateState
+	Logs               []*types.Log
+	// CodeCoverage holds for every executed contract a bit-vector with one bit per
+	// byte of code, set when the pc was executed. Only filled with CollectCoverage.
+	CodeCoverage map[common.Address][]byte
+	// CreatedContracts are the contracts deployed by the execution, in creation order
+	CreatedContracts []CreatedContract
+	// ValueTransfers are the transfers of ether made by the execution, including the
+	// value of the call itself
+	ValueTransfers []ValueTransfer
+	// ConsoleLogs are the lines logged with console.log, in the order they were logged
+	ConsoleLogs []ConsoleLog
+	// CallTrace is the root of the call tree, only filled with CollectCallTrace
+	CallTrace *CallFrame
+	// GasProfile is the gas used by contract and function, the highest self gas first.
+	// It leaves out the intrinsic gas and refunds, only filled with CollectGasProfile.
+	GasProfile []GasProfileEntry
+	// OpcodeHistogram has the executions and gas of every opcode executed, only filled
+	// with CollectOpcodeHistogram
+	OpcodeHistogram map[ourVm.OpCode]*OpcodeGas
+	// AccessReport has the cold and warm accesses and the gas the access list saved,
+	// only filled with CollectAccessReport
+	AccessReport *AccessReport
+	// RefundReport has the refund of every storage slot and the cap applied to it, only
+	// filled with CollectRefunds
+	RefundReport *RefundReport
+	// Preimages are the inputs of the keccak256 of one and two words computed, by hash.
+	// Only filled with CollectPreimages.
+	Preimages map[common.Hash][]byte
+	// StorageAccesses are the slots loaded or stored with the path deriving them, in
+	// the order first accessed. Only filled with CollectPreimages.
+	StorageAccesses []StorageAccess
+	// Proxies are the proxies called with their implementation, only filled with
+	// ResolveProxies. The frames of CallTrace calling them have their implementation.
+	Proxies map[common.Address]ourVm.Proxy
+	// StructLogs is the opcode level trace in the JSON shape of debug_traceTransaction,
+	// only filled with StructLogger and no StructLogWriter
+	StructLogs json.RawMessage
+	// Err is a *RevertError when the execution reverted, Ret holds then the revert data
+	Err error
 }
 
 // Execute executes the code using the input as call data during the execution.
@@ -153,15 +388,17 @@ type ExecutionResult struct {
 //
 // Execute sets up an in-memory, temporary, environment for the execution of
 // the given code. It makes sure that it's restored to its original state afterwards.
-// In order to get an appropiate gas estimation, this should be run twice
-// one for generating the access lists, take a look to Simulate from simulator package
+// On a state of NewRemoteStateDB what the execution reads is fetched from the fork,
+// bound to ctx, nothing is fetched on any other state.
+// The execution is aborted once ctx is done, returning the context error.
+// Reverts aren't returned as errors but in ExecutionResult.Err, as a receipt would.
 func Execute(
+	ctx context.Context,
 	address common.Address,
 	originBalance *big.Int,
 	code, input []byte,
 	cfg *Config,
 	state *state.StateDB,
-	recordToInit *ourVm.RecordToInitiateState,
 ) (*ExecutionResult, error) {
 	if cfg == nil {
 		cfg = new(Config)
@@ -171,12 +408,150 @@ func Execute(
 	if state == nil {
 		return nil, errors.New("state db missing please provide one in the config file")
 	}
+	// the tracer is wrapped on a copy, so cfg can be reused between executions
+	var (
+		cfgCopy   = *cfg
+		creations []CreatedContract
+		transfers []ValueTransfer
+		console   []ConsoleLog
+	)
+	cfgCopy.EVMConfig.Tracer = creationHooks(cfg.EVMConfig.Tracer, &creations)
+	cfgCopy.EVMConfig.Tracer = valueTransferHooks(cfgCopy.EVMConfig.Tracer, &transfers)
+	cfgCopy.EVMConfig.Tracer = consoleHooks(cfgCopy.EVMConfig.Tracer, &console)
+	cfg = &cfgCopy
+
+	fees, err := newGasFees(cfg, cfg.ChainConfig.IsLondon(cfg.BlockNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	// GASPRICE returns the effective gas price
+	cfg.GasPrice = new(big.Int)
+	if fees != nil {
+		cfg.GasPrice = fees.price
+	}
+
+	var coverage map[common.Address][]byte
+	if cfg.CollectCoverage {
+		coverage = make(map[common.Address][]byte)
+		cfg.EVMConfig.Tracer = coverageHooks(cfg.EVMConfig.Tracer, coverage)
+	}
+
+	var callTrace *CallFrame
+	if cfg.CollectCallTrace {
+		cfg.EVMConfig.Tracer = callTraceHooks(cfg.EVMConfig.Tracer, &callTrace)
+	}
+
+	var gasProfile []GasProfileEntry
+	if cfg.CollectGasProfile {
+		cfg.EVMConfig.Tracer = gasProfileHooks(cfg.EVMConfig.Tracer, &gasProfile)
+	}
+
+	var histogram map[ourVm.OpCode]*OpcodeGas
+	if cfg.CollectOpcodeHistogram {
+		histogram = make(map[ourVm.OpCode]*OpcodeGas)
+		cfg.EVMConfig.Tracer = opcodeHistogramHooks(cfg.EVMConfig.Tracer, histogram)
+	}
+
+	var refunds *refundCollector
+	if cfg.CollectRefunds {
+		refunds = &refundCollector{state: state, last: state.GetRefund()}
+		cfg.EVMConfig.Tracer = refunds.hooks(cfg.EVMConfig.Tracer)
+	}
+
+	var preimages *preimageCollector
+	if cfg.CollectPreimages {
+		preimages = newPreimageCollector()
+		cfg.EVMConfig.Tracer = preimages.hooks(cfg.EVMConfig.Tracer)
+	}
+
+	var structLogger *logger.StructLogger
+	if cfg.StructLogger != nil {
+		if cfg.StructLogWriter != nil {
+			cfg.EVMConfig.Tracer = chainHooks(cfg.EVMConfig.Tracer, logger.NewJSONLogger(cfg.StructLogger, cfg.StructLogWriter))
+		} else {
+			structLogger = logger.NewStructLogger(cfg.StructLogger)
+			cfg.EVMConfig.Tracer = chainHooks(cfg.EVMConfig.Tracer, structLogger.Hooks())
+		}
+	}
+
 	var (
-		vmenv  = NewEnv(cfg, state, recordToInit)
+		vmenv  = NewEnv(cfg, state)
 		sender = vm.AccountRef(cfg.Origin)
-		rules  = cfg.ChainConfig.Rules(vmenv.Context.BlockNumber, vmenv.Context.Random != nil, vmenv.Context.Time)
+		rules  = cfg.Rules()
 	)
 
+	remote, isRemote := state.Database().(*remoteDatabase)
+	if isRemote {
+		remote.bind(ctx, cfg.FetchRetries)
+		vmenv.Interpreter().SetForkState(remote)
+	}
+
+	if len(cfg.ForkOverride.EnableEIPs) > 0 || len(cfg.ForkOverride.DisableEIPs) > 0 {
+		err := vmenv.Interpreter().OverrideEIPs(cfg.ForkOverride.EnableEIPs, cfg.ForkOverride.DisableEIPs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vmenv.Interpreter().SetContext(ctx)
+
+	if cfg.ReadOnly {
+		vmenv.Interpreter().SetReadOnly(true)
+	}
+
+	if cfg.StorageChangeCallback != nil {
+		vmenv.Interpreter().SetStorageChangeCallback(cfg.StorageChangeCallback)
+	}
+
+	if len(cfg.Precompiles) > 0 {
+		vmenv.SetPrecompiles(cfg.Precompiles)
+	}
+
+	if cfg.OpcodeHooks != nil {
+		vmenv.Interpreter().SetOpcodeHooks(*cfg.OpcodeHooks)
+	}
+
+	if cfg.Cheatcodes != nil {
+		vmenv.SetCheatcodes(cfg.Cheatcodes)
+	}
+
+	if cfg.ResolveProxies {
+		vmenv.Interpreter().SetResolveProxies(true)
+	}
+
+	if cfg.SpeculativeFetch {
+		history := cfg.SlotHistory
+		if history == nil {
+			history = ourVm.NewSlotHistory()
+		}
+		vmenv.Interpreter().SetSpeculativeFetch(history)
+	}
+
+	if cfg.FullStorageSlots > 0 {
+		vmenv.Interpreter().SetFullStorage(cfg.FullStorageSlots)
+	}
+
+	if cfg.MaxSteps > 0 || cfg.StepDeadline > 0 {
+		var deadline time.Time
+		if cfg.StepDeadline > 0 {
+			deadline = time.Now().Add(cfg.StepDeadline)
+		}
+		vmenv.Interpreter().SetStepLimit(cfg.MaxSteps, deadline)
+	}
+
+	var accesses *accessCollector
+	if cfg.CollectAccessReport {
+		accesses = newAccessCollector()
+		vmenv.Interpreter().SetAccessCallback(accesses.access)
+	}
+
+	// the state prefetched isn't fetched again by the reads below
+	err = ourVm.PrefetchWithProof(ctx, vmenv.Interpreter(), cfg.Prefetch)
+	if err != nil {
+		return nil, err
+	}
+
 	if cfg.EVMConfig.Tracer != nil && cfg.EVMConfig.Tracer.OnTxStart != nil {
 		cfg.EVMConfig.Tracer.OnTxStart(vmenv.GetVMContext(), types.NewTx(&types.LegacyTx{To: &address, Data: input, Value: cfg.Value, Gas: cfg.GasLimit}), cfg.Origin)
 	}
@@ -186,7 +561,8 @@ func Execute(
 		return nil, err
 	}
 
-	if originAcc == nil {
+	// accounts modified but not committed yet aren't in the trie
+	if originAcc == nil && !state.Exist(cfg.Origin) {
 		// register origin account in case is not
 		state.CreateAccount(cfg.Origin)
 	}
@@ -196,25 +572,52 @@ func Execute(
 		balance := uint256.MustFromBig(originBalance)
 		state.SetBalance(cfg.Origin, balance, tracing.BalanceChangeUnspecified)
 		state.SetBalance(sender.Address(), balance, tracing.BalanceChangeUnspecified)
-		vmenv.Interpreter().MarkAddressBalance(cfg.Origin)
 	}
 
 	// Execute the preparatory steps for state transition which includes:
 	// - prepare accessList(post-berlin)
 	// - reset transient storage(eip 1153)
-	var accessList types.AccessList
-	if recordToInit != nil {
-		accessList = recordToInit.AccessList
+	accessList := cfg.AccessList
+
+	// gasBought is the execution gas plus the intrinsic gas paid up front
+	var gasBought uint64
+
+	if fees != nil {
+		intrinsicGas, err := IntrinsicGas(input, accessList, rules)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.GasLimit, err = fees.buyGas(state, cfg.Origin, cfg.GasLimit, intrinsicGas, cfg.Value)
+		if err != nil {
+			return nil, err
+		}
+		gasBought = cfg.GasLimit + intrinsicGas
 	}
 
-	state.Prepare(rules, cfg.Origin, cfg.Coinbase, &address, vm.ActivePrecompiles(rules), accessList)
+	state.Prepare(rules, cfg.Origin, cfg.Coinbase, &address, vmenv.ActivePrecompiles(), accessList)
 	if !state.Exist(address) {
 		state.CreateAccount(address)
 		// set the receiver's (the executing contract) code for execution.
 		state.SetCode(address, code)
-		vmenv.Interpreter().MarkAddressCode(address)
 	}
 
+	vmenv.Interpreter().ResolveProxy(address)
+
+	// abort the evm once the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vmenv.Cancel()
+		case <-done:
+		}
+	}()
+
+	// logs emitted by previous executions on the same state are left out of the result
+	logsBefore := len(state.Logs())
+
 	// Call the code with the given configuration.
 	ret, leftOverGas, err := vmenv.Call(
 		sender,
@@ -223,31 +626,111 @@ func Execute(
 		cfg.GasLimit,
 		uint256.MustFromBig(cfg.Value),
 	)
-	if err != nil {
+	if vmenv.Cancelled() {
+		return nil, ctx.Err()
+	}
+	if err := vmenv.Interpreter().StepLimitErr(); err != nil {
+		return nil, err
+	}
+	// a remote state reads missing accounts as empty when it fails fetching them
+	if isRemote && state.Error() != nil {
+		return nil, state.Error()
+	}
+	var execErr error
+	if errors.Is(err, vm.ErrExecutionReverted) {
+		execErr = &RevertError{Data: ret}
+	} else if err != nil {
 		return nil, err
 	}
 
-	inRecord := vmenv.Interpreter().GetRecordToInitState()
-	intrinsicGas, err := core.IntrinsicGas(input, inRecord.AccessList, false, cfg.ChainConfig.IsHomestead(new(big.Int)), cfg.ChainConfig.IsIstanbul(new(big.Int)), cfg.ChainConfig.IsShanghai(new(big.Int), 0))
+	intrinsicGas, err := IntrinsicGas(input, accessList, rules)
 	if err != nil {
 		return nil, err
 	}
 
 	refund := vmenv.StateDB.GetRefund()
-	gasUsed := cfg.GasLimit - leftOverGas + intrinsicGas - refund
+	gasUsed := cfg.GasLimit - leftOverGas + intrinsicGas
+	gasUsed -= min(refund, refundCap(gasUsed, rules.IsLondon))
+
+	var (
+		preimageSet     map[common.Hash][]byte
+		storageAccesses []StorageAccess
+	)
+	if preimages != nil {
+		preimageSet, storageAccesses = preimages.preimages, preimages.finish(state, cfg.StorageLayouts)
+	}
+
+	var refundReport *RefundReport
+	if refunds != nil {
+		refundReport = refunds.finish(cfg.GasLimit-leftOverGas+intrinsicGas, rules.IsLondon)
+	}
+
+	priorityFees := new(big.Int)
+	if fees != nil {
+		priorityFees = fees.settle(state, cfg.Origin, cfg.Coinbase, gasBought, gasUsed)
+	}
+
+	var structLogs json.RawMessage
+	if structLogger != nil {
+		structLogger.OnTxEnd(&types.Receipt{GasUsed: gasUsed}, nil)
+		if structLogs, err = structLogger.GetResult(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range creations {
+		creations[i].Code = state.GetCode(creations[i].Address)
+	}
+
+	sortGasProfile(gasProfile)
+
+	proxies := vmenv.Interpreter().Proxies()
+	annotateProxies(callTrace, proxies)
+
+	var accessReport *AccessReport
+	if accesses != nil {
+		prewarmed := append([]common.Address{cfg.Origin, address}, vmenv.ActivePrecompiles()...)
+		if rules.IsShanghai {
+			prewarmed = append(prewarmed, cfg.Coinbase)
+		}
+		accessReport = accesses.finish(accessList, prewarmed)
+	}
+
+	// the record has the slots accessed, for the transaction to be sent with them
+	record := &RecordToInitiateState{AccessList: vmenv.Interpreter().AccessList()}
+	if isRemote {
+		record = remote.record(record.AccessList)
+	}
 
-	record := &RecordToInitiateState{
-		AddressCodeSet:    inRecord.AddressCodeSet,
-		AddressBalanceSet: inRecord.AddressBalanceSet,
-		AddressStorageSet: inRecord.AddressStorageSet,
-		AccessList:        inRecord.AccessList,
+	// the state only tags the logs with the transaction
+	logs := state.Logs()[logsBefore:]
+	for _, log := range logs {
+		log.BlockNumber = cfg.BlockNumber.Uint64()
 	}
 
 	return &ExecutionResult{
-		Ret:          ret,
-		GasUsed:      gasUsed,
-		Refund:       refund,
-		IntrinsicGas: intrinsicGas,
-		Record:       record,
+		Ret:                ret,
+		GasUsed:            gasUsed,
+		Refund:             refund,
+		IntrinsicGas:       intrinsicGas,
+		EffectiveGasPrice:  cfg.GasPrice,
+		PriorityFees:       priorityFees,
+		IntrinsicBreakdown: intrinsicGasBreakdown(input, accessList, false, rules.IsHomestead, rules.IsIstanbul, rules.IsShanghai),
+		Record:             record,
+		Logs:               logs,
+		CodeCoverage:       coverage,
+		CreatedContracts:   creations,
+		ValueTransfers:     transfers,
+		ConsoleLogs:        console,
+		CallTrace:          callTrace,
+		GasProfile:         gasProfile,
+		OpcodeHistogram:    histogram,
+		AccessReport:       accessReport,
+		RefundReport:       refundReport,
+		Preimages:          preimageSet,
+		StorageAccesses:    storageAccesses,
+		Proxies:            proxies,
+		StructLogs:         structLogs,
+		Err:                execErr,
 	}, nil
 }
@@ -0,0 +1,884 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+
+	"github.com/Gealber/evm-simulator/rpc"
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestRecordToInitiateStateConcurrentAccess(t *testing.T) {
+	var (
+		record = &RecordToInitiateState{}
+		addr   = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		wg     sync.WaitGroup
+	)
+
+	for i := 0; i < 100; i++ {
+		slot := common.BigToHash(common.Big1)
+		if i%2 == 0 {
+			slot = common.BigToHash(common.Big2)
+		}
+		key := addr.Hex() + ":" + slot.Hex()
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			record.Set(key, slot)
+		}()
+
+		go func() {
+			defer wg.Done()
+			if val, ok := record.Get(key); ok && val != slot {
+				t.Errorf("value: %s expected: %s", val.Hex(), slot.Hex())
+			}
+		}()
+	}
+	wg.Wait()
+
+	count := 0
+	record.RangeStorage(func(string, common.Hash) bool {
+		count++
+		return true
+	})
+	if count != 2 {
+		t.Fatalf("recorded slots: %d expected: 2", count)
+	}
+}
+
+func TestExecuteWithAccessList(t *testing.T) {
+	address := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	cfg := &Config{
+		AccessList: types.AccessList{
+			{Address: address, StorageKeys: []common.Hash{{}}},
+		},
+	}
+
+	cost, result := sloadCost(t, cfg)
+	if cost != 100 {
+		t.Fatalf("warm SLOAD cost: %d expected: 100", cost)
+	}
+
+	// base cost plus one address and one slot in the access list
+	if result.IntrinsicGas != 21000+2400+1900 {
+		t.Fatalf("intrinsic gas: %d expected: %d", result.IntrinsicGas, 21000+2400+1900)
+	}
+}
+
+// proofList collects the nodes of a merkle proof
+type proofList []string
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, hexutil.Encode(value))
+	return nil
+}
+
+func (l *proofList) Delete(key []byte) error {
+	return nil
+}
+
+func TestExecuteWithPrefetch(t *testing.T) {
+	var (
+		address = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		slots   = make([]common.Hash, 20)
+		code    = []byte{byte(ourVm.PUSH0)}
+		db      = triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil)
+	)
+
+	// sum the value of the 20 slots and return it
+	storageTrie := trie.NewEmpty(db)
+	for i := range slots {
+		slots[i] = common.BigToHash(big.NewInt(int64(i)))
+		value, _ := rlp.EncodeToBytes(big.NewInt(int64(i + 1)).Bytes())
+		storageTrie.MustUpdate(crypto.Keccak256(slots[i].Bytes()), value)
+
+		code = append(code, byte(ourVm.PUSH1), byte(i), byte(ourVm.SLOAD), byte(ourVm.ADD))
+	}
+	code = append(code, byte(ourVm.PUSH0), byte(ourVm.MSTORE), byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN))
+
+	account, _ := rlp.EncodeToBytes(&types.StateAccount{
+		Nonce:    1,
+		Balance:  uint256.NewInt(0),
+		Root:     storageTrie.Hash(),
+		CodeHash: crypto.Keccak256(code),
+	})
+	accountTrie := trie.NewEmpty(db)
+	accountTrie.MustUpdate(crypto.Keccak256(address.Bytes()), account)
+
+	var accountProof proofList
+	if err := accountTrie.Prove(crypto.Keccak256(address.Bytes()), &accountProof); err != nil {
+		t.Fatal(err)
+	}
+
+	storageProofs := make([]map[string]interface{}, len(slots))
+	for i, slot := range slots {
+		var proof proofList
+		if err := storageTrie.Prove(crypto.Keccak256(slot.Bytes()), &proof); err != nil {
+			t.Fatal(err)
+		}
+
+		storageProofs[i] = map[string]interface{}{
+			"key":   slot.Hex(),
+			"value": hexutil.EncodeBig(big.NewInt(int64(i + 1))),
+			"proof": proof,
+		}
+	}
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		var batch []struct {
+			ID     int    `json:"id"`
+			Method string `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		responses := make([]map[string]interface{}, len(batch))
+		for i, req := range batch {
+			var result interface{}
+			switch req.Method {
+			case "eth_getBlockByNumber":
+				result = map[string]interface{}{"stateRoot": accountTrie.Hash().Hex()}
+			case "eth_getProof":
+				result = map[string]interface{}{
+					"address":      address.Hex(),
+					"accountProof": accountProof,
+					"balance":      "0x0",
+					"codeHash":     crypto.Keccak256Hash(code).Hex(),
+					"nonce":        "0x1",
+					"storageHash":  storageTrie.Hash().Hex(),
+					"storageProof": storageProofs,
+				}
+			case "eth_getCode":
+				result = hexutil.Encode(code)
+			}
+			responses[i] = map[string]interface{}{"id": req.ID, "jsonrpc": "2.0", "result": result}
+		}
+
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer srv.Close()
+
+	clt := rpc.NewClient(srv.URL)
+	statedb, err := NewRemoteStateDB(context.Background(), clt, big.NewInt(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the contract calls itself, so the account prefetched is the only one read
+	cfg := &Config{
+		BlockNumber: big.NewInt(1),
+		Origin:      address,
+		RPCClient:   clt,
+		Prefetch:    map[common.Address][]common.Hash{address: slots},
+	}
+
+	result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum := new(big.Int).SetBytes(result.Ret); sum.Cmp(big.NewInt(210)) != 0 {
+		t.Fatalf("sum: %s expected: 210", sum)
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("rpc requests: %d expected: 1", n)
+	}
+}
+
+func TestExecuteStorageChangeCallback(t *testing.T) {
+	type change struct {
+		addr                 common.Address
+		slot, oldVal, newVal common.Hash
+	}
+
+	var (
+		address = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		slot0   = common.Hash{}
+		slot1   = common.BigToHash(common.Big1)
+		// SSTORE(0, 0x2a) SSTORE(1, 0x2b)
+		code = []byte{
+			byte(ourVm.PUSH1), 0x2a, byte(ourVm.PUSH0), byte(ourVm.SSTORE),
+			byte(ourVm.PUSH1), 0x2b, byte(ourVm.PUSH1), 0x01, byte(ourVm.SSTORE),
+			byte(ourVm.STOP),
+		}
+		changes []change
+	)
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.CreateAccount(address)
+	statedb.SetCode(address, code)
+	statedb.SetState(address, slot1, common.BigToHash(big.NewInt(5)))
+
+	cfg := &Config{
+		StorageChangeCallback: func(addr common.Address, slot, oldVal, newVal common.Hash) {
+			changes = append(changes, change{addr, slot, oldVal, newVal})
+		},
+	}
+
+	_, err = Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []change{
+		{address, slot0, common.Hash{}, common.BigToHash(big.NewInt(0x2a))},
+		{address, slot1, common.BigToHash(big.NewInt(5)), common.BigToHash(big.NewInt(0x2b))},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("callback called %d times expected %d", len(changes), len(expected))
+	}
+
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("change %d: %+v expected: %+v", i, changes[i], expected[i])
+		}
+	}
+}
+
+// constantPrecompile returns out for any input
+type constantPrecompile struct {
+	out []byte
+}
+
+func (p constantPrecompile) RequiredGas([]byte) uint64 { return 3450 }
+
+func (p constantPrecompile) Run([]byte) ([]byte, error) { return p.out, nil }
+
+// emptyProvider serves a fork where every account is empty
+type emptyProvider struct{}
+
+func (emptyProvider) GetCode(context.Context, string, string) ([]byte, error) { return nil, nil }
+
+func (emptyProvider) GetStorageAt(context.Context, string, string, string) (common.Hash, error) {
+	return common.Hash{}, nil
+}
+
+func (emptyProvider) GetBalance(context.Context, string, string) (*big.Int, error) {
+	return new(big.Int), nil
+}
+
+func (emptyProvider) GetNonce(context.Context, string, string) (uint64, error) { return 0, nil }
+
+func (emptyProvider) GetBlockHash(context.Context, string) (common.Hash, error) {
+	return common.Hash{}, nil
+}
+
+func TestExecutePrecompiles(t *testing.T) {
+	var (
+		contract  = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		p256      = common.HexToAddress("0x0000000000000000000000000000000000000100")
+		identity  = common.HexToAddress("0x0000000000000000000000000000000000000004")
+		ecrecover = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		one       = common.BigToHash(common.Big1).Bytes()
+		digest    = sha256.Sum256(common.BigToHash(big.NewInt(0x2a)).Bytes())
+	)
+
+	// returns the output of STATICCALL(gas, addr, 0, 32, 0, 32), the input is the
+	// word 0x2a
+	staticCall := func(addr common.Address) []byte {
+		return []byte{
+			byte(ourVm.PUSH1), 0x2a, byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+			byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0),
+			byte(ourVm.PUSH2), addr[18], addr[19], byte(ourVm.GAS), byte(ourVm.STATICCALL), byte(ourVm.POP),
+			byte(ourVm.RETURNDATASIZE), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.RETURNDATACOPY),
+			byte(ourVm.RETURNDATASIZE), byte(ourVm.PUSH0), byte(ourVm.RETURN),
+		}
+	}
+
+	cfg := &Config{
+		StateProvider: emptyProvider{},
+		Precompiles: map[common.Address]ourVm.PrecompiledContract{
+			p256:      constantPrecompile{out: one},
+			identity:  constantPrecompile{out: []byte{0x01, 0x02}},
+			ecrecover: nil,
+		},
+	}
+
+	tests := []struct {
+		name string
+		addr common.Address
+		ret  []byte
+	}{
+		{name: "added", addr: p256, ret: one},
+		{name: "replaced", addr: identity, ret: []byte{0x01, 0x02}},
+		{name: "removed", addr: ecrecover, ret: []byte{}},
+		// the rest of the precompiles of the rules are kept
+		{name: "kept", addr: common.HexToAddress("0x0000000000000000000000000000000000000002"), ret: digest[:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := Execute(context.Background(), contract, big.NewInt(0), staticCall(tt.addr), nil, cfg, statedb)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(result.Ret) != string(tt.ret) {
+				t.Fatalf("returned %x expected %x", result.Ret, tt.ret)
+			}
+		})
+	}
+
+	// precompiles are warm from the start of the execution
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Execute(context.Background(), contract, big.NewInt(0), []byte{byte(ourVm.STOP)}, nil, cfg, statedb); err != nil {
+		t.Fatal(err)
+	}
+
+	if !statedb.AddressInAccessList(p256) || statedb.AddressInAccessList(ecrecover) {
+		t.Fatal("access list doesn't match the precompiles")
+	}
+}
+
+func TestExecuteOpcodeHooks(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		// returns the word TIMESTAMP
+		code = []byte{
+			byte(ourVm.TIMESTAMP), byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+			byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN),
+		}
+		before, after []uint64
+	)
+
+	forced := func(uint64, *ourVm.ScopeContext, []uint256.Int) ([]uint256.Int, error) {
+		return []uint256.Int{*uint256.NewInt(42)}, nil
+	}
+
+	cfg := &Config{
+		StateProvider: emptyProvider{},
+		OpcodeHooks: &ourVm.OpcodeHooks{
+			Before: map[ourVm.OpCode]ourVm.OpcodeHook{
+				ourVm.TIMESTAMP: func(pc uint64, _ *ourVm.ScopeContext) error {
+					before = append(before, pc)
+					return nil
+				},
+			},
+			After: map[ourVm.OpCode]ourVm.OpcodeHook{
+				ourVm.MSTORE: func(pc uint64, scope *ourVm.ScopeContext) error {
+					after = append(after, pc)
+					if scope.Memory.Len() != 32 {
+						t.Errorf("memory of %d bytes after MSTORE", scope.Memory.Len())
+					}
+					return nil
+				},
+			},
+			Override: map[ourVm.OpCode]ourVm.OpcodeFunc{ourVm.TIMESTAMP: forced},
+		},
+	}
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Execute(context.Background(), contract, big.NewInt(0), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := new(big.Int).SetBytes(result.Ret); got.Int64() != 42 {
+		t.Fatalf("returned timestamp %s expected 42", got)
+	}
+
+	if len(before) != 1 || before[0] != 0 || len(after) != 1 || after[0] != 2 {
+		t.Fatalf("hooks called at %v before and %v after", before, after)
+	}
+
+	// overrides pushing the wrong number of values fail the call
+	cfg.OpcodeHooks = &ourVm.OpcodeHooks{
+		Override: map[ourVm.OpCode]ourVm.OpcodeFunc{
+			ourVm.TIMESTAMP: func(uint64, *ourVm.ScopeContext, []uint256.Int) ([]uint256.Int, error) {
+				return nil, nil
+			},
+		},
+	}
+
+	if _, err := Execute(context.Background(), contract, big.NewInt(0), code, nil, cfg, statedb); err == nil {
+		t.Fatal("expected the call to fail")
+	}
+}
+
+func TestExecuteCheatcodes(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		contract = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		pranked  = common.HexToAddress("0x0000000000000000000000000000000000000022")
+		slot     = common.BigToHash(common.Big1)
+		value    = common.BigToHash(big.NewInt(0x2a))
+		cheat    = ourVm.CheatcodeAddress
+		// forwards its input to the cheatcodes and returns what they return
+		forward = append(append([]byte{
+			byte(ourVm.CALLDATASIZE), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.CALLDATACOPY),
+			byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.CALLDATASIZE), byte(ourVm.PUSH0), byte(ourVm.PUSH0),
+			byte(ourVm.PUSH20)}, cheat.Bytes()...),
+			byte(ourVm.GAS), byte(ourVm.CALL), byte(ourVm.POP),
+			byte(ourVm.RETURNDATASIZE), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.RETURNDATACOPY),
+			byte(ourVm.RETURNDATASIZE), byte(ourVm.PUSH0), byte(ourVm.RETURN),
+		)
+		// returns the word op pushes
+		word = func(op ourVm.OpCode) []byte {
+			return []byte{byte(op), byte(ourVm.PUSH0), byte(ourVm.MSTORE), byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN)}
+		}
+		calldata = func(signature string, args ...[]byte) []byte {
+			data := crypto.Keccak256([]byte(signature))[:4]
+			for _, arg := range args {
+				data = append(data, common.LeftPadBytes(arg, 32)...)
+			}
+			return data
+		}
+	)
+
+	cheatcodes := ourVm.NewCheatcodes()
+	cfg := &Config{StateProvider: emptyProvider{}, Cheatcodes: cheatcodes}
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shared by the executions so the slots stored aren't fetched again
+	execute := func(addr common.Address, code, input []byte) *ExecutionResult {
+		t.Helper()
+		// every execution deploys code at addr
+		statedb.SetCode(addr, code)
+		result, err := Execute(ctx, addr, big.NewInt(0), code, input, cfg, statedb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// called by a contract
+	execute(contract, forward, calldata("store(address,bytes32,bytes32)", contract.Bytes(), slot.Bytes(), value.Bytes()))
+	result := execute(contract, forward, calldata("load(address,bytes32)", contract.Bytes(), slot.Bytes()))
+	if common.BytesToHash(result.Ret) != value {
+		t.Fatalf("loaded %x expected %s", result.Ret, value.Hex())
+	}
+
+	// called straight by the transaction, the block context carries to the next one
+	execute(cheat, nil, calldata("warp(uint256)", big.NewInt(1000).Bytes()))
+	execute(cheat, nil, calldata("roll(uint256)", big.NewInt(77).Bytes()))
+	execute(cheat, nil, calldata("deal(address,uint256)", pranked.Bytes(), big.NewInt(5).Bytes()))
+
+	if got := new(big.Int).SetBytes(execute(contract, word(ourVm.TIMESTAMP), nil).Ret); got.Int64() != 1000 {
+		t.Errorf("timestamp %s expected 1000", got)
+	}
+
+	if got := new(big.Int).SetBytes(execute(contract, word(ourVm.NUMBER), nil).Ret); got.Int64() != 77 {
+		t.Errorf("block number %s expected 77", got)
+	}
+
+	if got := statedb.GetBalance(pranked); got.Uint64() != 5 {
+		t.Errorf("balance %s expected 5", got)
+	}
+
+	// the next call of the sender is made by pranked
+	execute(cheat, nil, calldata("prank(address)", pranked.Bytes()))
+	if got := common.BytesToAddress(execute(contract, word(ourVm.CALLER), nil).Ret); got != pranked {
+		t.Errorf("caller %s expected %s", got, pranked)
+	}
+
+	if got := common.BytesToAddress(execute(contract, word(ourVm.CALLER), nil).Ret); got == pranked {
+		t.Error("prank applied to a second call")
+	}
+
+	// unknown cheatcodes revert
+	result = execute(cheat, nil, calldata("unknown()"))
+	if _, ok := result.Err.(*RevertError); !ok {
+		t.Fatalf("expected a revert, got %v", result.Err)
+	}
+
+	// cheatcodes writing fail in static calls
+	cfg.ReadOnly = true
+	result = execute(cheat, nil, calldata("warp(uint256)", big.NewInt(1).Bytes()))
+	if _, ok := result.Err.(*RevertError); !ok {
+		t.Fatalf("expected a revert, got %v", result.Err)
+	}
+}
+
+func TestExecutePooledMemory(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+
+	// returns the word v, or reverts with it
+	code := func(v byte, op ourVm.OpCode) []byte {
+		return []byte{
+			byte(ourVm.PUSH1), v, byte(ourVm.PUSH0), byte(ourVm.MSTORE),
+			byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(op),
+		}
+	}
+
+	cfg := &Config{StateProvider: emptyProvider{}}
+	execute := func(v byte, op ourVm.OpCode) []byte {
+		statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := Execute(context.Background(), contract, big.NewInt(0), code(v, op), nil, cfg, statedb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return result.Ret
+	}
+
+	// the memory of an execution is reused by the next ones, what they return must
+	// not point into it
+	returned, reverted := execute(0x01, ourVm.RETURN), execute(0x02, ourVm.REVERT)
+	for i := 0; i < 16; i++ {
+		execute(0xff, ourVm.RETURN)
+	}
+
+	if want := common.BigToHash(big.NewInt(1)); common.BytesToHash(returned) != want {
+		t.Fatalf("returned %x expected %x", returned, want)
+	}
+	if want := common.BigToHash(big.NewInt(2)); common.BytesToHash(reverted) != want {
+		t.Fatalf("reverted %x expected %x", reverted, want)
+	}
+}
+
+// batchProvider answers slot n with n+1, counting the requests
+type batchProvider struct {
+	forkProvider
+
+	mu      sync.Mutex
+	singles int
+	batches [][]string
+}
+
+func (p *batchProvider) GetStorageAt(_ context.Context, _, position, _ string) (common.Hash, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.singles++
+	return common.BigToHash(new(big.Int).Add(common.HexToHash(position).Big(), common.Big1)), nil
+}
+
+func (p *batchProvider) GetStorageBatch(_ context.Context, _ string, positions []string, _ string) ([]common.Hash, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.batches = append(p.batches, positions)
+	values := make([]common.Hash, len(positions))
+	for i, position := range positions {
+		values[i] = common.BigToHash(new(big.Int).Add(common.HexToHash(position).Big(), common.Big1))
+	}
+
+	return values, nil
+}
+
+func TestExecuteSpeculativeFetch(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+
+	// returns the sum of the slots 0, 1, 2 and 6, the last one computed
+	code := []byte{
+		byte(ourVm.PUSH0), byte(ourVm.SLOAD),
+		byte(ourVm.PUSH1), 0x01, byte(ourVm.SLOAD), byte(ourVm.ADD),
+		byte(ourVm.PUSH1), 0x02, byte(ourVm.SLOAD), byte(ourVm.ADD),
+		byte(ourVm.PUSH1), 0x05, byte(ourVm.PUSH1), 0x01, byte(ourVm.ADD), byte(ourVm.SLOAD), byte(ourVm.ADD),
+		byte(ourVm.PUSH0), byte(ourVm.MSTORE), byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN),
+	}
+
+	selector := []byte{0x01, 0x02, 0x03, 0x04}
+	history := ourVm.NewSlotHistory()
+
+	execute := func(provider *batchProvider) {
+		provider.code = map[common.Address][]byte{contract: code}
+		statedb, err := NewRemoteStateDB(context.Background(), provider, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := &Config{StateProvider: provider, SpeculativeFetch: true, SlotHistory: history}
+		result, err := Execute(context.Background(), contract, big.NewInt(0), code, selector, cfg, statedb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := common.BigToHash(big.NewInt(1 + 2 + 3 + 7)); common.BytesToHash(result.Ret) != want {
+			t.Fatalf("returned %x expected %x", result.Ret, want)
+		}
+	}
+
+	// the constant slots are fetched with the first one, the computed one alone
+	first := &batchProvider{}
+	execute(first)
+	if len(first.batches) != 1 || len(first.batches[0]) != 3 || first.singles != 1 {
+		t.Fatalf("batches: %v single requests: %d", first.batches, first.singles)
+	}
+
+	// the next run of the function fetches the computed one too
+	second := &batchProvider{}
+	execute(second)
+	if len(second.batches) != 1 || len(second.batches[0]) != 4 || second.singles != 0 {
+		t.Fatalf("batches: %v single requests: %d", second.batches, second.singles)
+	}
+
+	// another history doesn't know the function
+	history = ourVm.NewSlotHistory()
+	third := &batchProvider{}
+	execute(third)
+	if len(third.batches) != 1 || len(third.batches[0]) != 3 || third.singles != 1 {
+		t.Fatalf("batches: %v single requests: %d", third.batches, third.singles)
+	}
+}
+
+// fullStorageProvider holds the storage of a single account, counting the requests
+type fullStorageProvider struct {
+	forkProvider
+
+	storage map[common.Hash]common.Hash
+	singles int
+	ranges  int
+}
+
+func (p *fullStorageProvider) GetStorageAt(_ context.Context, _, position, _ string) (common.Hash, error) {
+	p.singles++
+	return p.storage[common.HexToHash(position)], nil
+}
+
+func (p *fullStorageProvider) GetFullStorage(_ context.Context, _, _ string, maxSlots int) (map[common.Hash]common.Hash, bool, error) {
+	p.ranges++
+	if len(p.storage) > maxSlots {
+		return nil, false, nil
+	}
+
+	return p.storage, true, nil
+}
+
+func TestExecuteFullStorage(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+
+	// returns the sum of the slots 0, 1 and 2
+	code := []byte{
+		byte(ourVm.PUSH0), byte(ourVm.SLOAD),
+		byte(ourVm.PUSH1), 0x01, byte(ourVm.SLOAD), byte(ourVm.ADD),
+		byte(ourVm.PUSH1), 0x02, byte(ourVm.SLOAD), byte(ourVm.ADD),
+		byte(ourVm.PUSH0), byte(ourVm.MSTORE), byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN),
+	}
+
+	for _, test := range []struct {
+		maxSlots int
+		singles  int
+	}{
+		// the storage is fetched at once, the slot 2 is empty
+		{maxSlots: 2, singles: 0},
+		// too large, the slots are fetched one at a time
+		{maxSlots: 1, singles: 3},
+	} {
+		provider := &fullStorageProvider{storage: map[common.Hash]common.Hash{
+			common.BigToHash(common.Big0): common.BigToHash(common.Big1),
+			common.BigToHash(common.Big1): common.BigToHash(common.Big2),
+		}}
+		provider.code = map[common.Address][]byte{contract: code}
+
+		statedb, err := NewRemoteStateDB(context.Background(), provider, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := &Config{StateProvider: provider, FullStorageSlots: test.maxSlots}
+		result, err := Execute(context.Background(), contract, big.NewInt(0), code, nil, cfg, statedb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := common.BigToHash(big.NewInt(1 + 2)); common.BytesToHash(result.Ret) != want {
+			t.Fatalf("returned %x expected %x", result.Ret, want)
+		}
+
+		if provider.ranges != 1 || provider.singles != test.singles {
+			t.Fatalf("with %d slots at most, storage fetched %d times and %d slots fetched, expected once and %d",
+				test.maxSlots, provider.ranges, provider.singles, test.singles)
+		}
+	}
+}
+
+func TestExecuteStepLimit(t *testing.T) {
+	contract := common.HexToAddress("0x0000000000000000000000000000000000000011")
+	// loops until out of gas
+	code := []byte{byte(ourVm.JUMPDEST), byte(ourVm.PUSH0), byte(ourVm.JUMP)}
+
+	for _, cfg := range []*Config{
+		{MaxSteps: 1000},
+		{StepDeadline: 10 * time.Millisecond},
+	} {
+		cfg.StateProvider = emptyProvider{}
+		cfg.GasLimit = math.MaxUint64 / 2
+
+		statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		_, err = Execute(context.Background(), contract, big.NewInt(0), code, nil, cfg, statedb)
+		if !errors.Is(err, ourVm.ErrStepLimitExceeded) {
+			t.Fatalf("execution with %d steps and a deadline of %v failed with %v, expected %v", cfg.MaxSteps, cfg.StepDeadline, err, ourVm.ErrStepLimitExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("execution aborted after %v", elapsed)
+		}
+	}
+}
+
+func TestExecuteWithVerifyingProvider(t *testing.T) {
+	var (
+		address = common.HexToAddress("0x0000000000000000000000000000000000000011")
+		slot    = common.BigToHash(big.NewInt(7))
+		// returns the value of the slot
+		code = []byte{
+			byte(ourVm.PUSH1), 0x07, byte(ourVm.SLOAD),
+			byte(ourVm.PUSH0), byte(ourVm.MSTORE), byte(ourVm.PUSH1), 0x20, byte(ourVm.PUSH0), byte(ourVm.RETURN),
+		}
+		db = triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil)
+	)
+
+	storageTrie := trie.NewEmpty(db)
+	value, _ := rlp.EncodeToBytes(big.NewInt(42).Bytes())
+	storageTrie.MustUpdate(crypto.Keccak256(slot.Bytes()), value)
+
+	account, _ := rlp.EncodeToBytes(&types.StateAccount{
+		Nonce:    1,
+		Balance:  uint256.NewInt(0),
+		Root:     storageTrie.Hash(),
+		CodeHash: crypto.Keccak256(code),
+	})
+	accountTrie := trie.NewEmpty(db)
+	accountTrie.MustUpdate(crypto.Keccak256(address.Bytes()), account)
+
+	var accountProof, storageProof proofList
+	if err := accountTrie.Prove(crypto.Keccak256(address.Bytes()), &accountProof); err != nil {
+		t.Fatal(err)
+	}
+	if err := storageTrie.Prove(crypto.Keccak256(slot.Bytes()), &storageProof); err != nil {
+		t.Fatal(err)
+	}
+
+	// reported is the value of the slot answered by the node
+	execute := func(reported int64) ([]byte, error) {
+		respond := func(method string) interface{} {
+			switch method {
+			case "eth_getBlockByNumber":
+				return map[string]interface{}{"stateRoot": accountTrie.Hash().Hex()}
+			case "eth_getProof":
+				return map[string]interface{}{
+					"address":      address.Hex(),
+					"accountProof": accountProof,
+					"balance":      "0x0",
+					"codeHash":     crypto.Keccak256Hash(code).Hex(),
+					"nonce":        "0x1",
+					"storageHash":  storageTrie.Hash().Hex(),
+					"storageProof": []map[string]interface{}{{
+						"key":   slot.Hex(),
+						"value": hexutil.EncodeBig(big.NewInt(reported)),
+						"proof": storageProof,
+					}},
+				}
+			case "eth_getCode":
+				return hexutil.Encode(code)
+			}
+			return nil
+		}
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var raw json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var req struct {
+				ID     int    `json:"id"`
+				Method string `json:"method"`
+			}
+			if json.Unmarshal(raw, &req) == nil {
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "jsonrpc": "2.0", "result": respond(req.Method)})
+				return
+			}
+
+			var batch []struct {
+				ID     int    `json:"id"`
+				Method string `json:"method"`
+			}
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			responses := make([]map[string]interface{}, len(batch))
+			for i, req := range batch {
+				responses[i] = map[string]interface{}{"id": req.ID, "jsonrpc": "2.0", "result": respond(req.Method)}
+			}
+			json.NewEncoder(w).Encode(responses)
+		}))
+		defer srv.Close()
+
+		statedb, err := NewRemoteStateDB(context.Background(), ourVm.NewVerifyingProvider(rpc.NewClient(srv.URL), accountTrie.Hash()), big.NewInt(1), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the contract calls itself, so it's the only account read
+		cfg := &Config{BlockNumber: big.NewInt(1), Origin: address}
+
+		result, err := Execute(context.Background(), address, big.NewInt(0), code, nil, cfg, statedb)
+		if err != nil {
+			return nil, err
+		}
+
+		return result.Ret, nil
+	}
+
+	ret, err := execute(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := new(big.Int).SetBytes(ret); v.Cmp(big.NewInt(42)) != 0 {
+		t.Fatalf("slot: %s expected: 42", v)
+	}
+
+	if _, err := execute(43); !errors.Is(err, ourVm.ErrInvalidProof) {
+		t.Fatalf("tampered slot failed with %v, expected %v", err, ourVm.ErrInvalidProof)
+	}
+}
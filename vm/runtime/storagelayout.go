@@ -0,0 +1,287 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// StorageLayout is the storage layout of a contract as output by solc with
+// --storage-layout.
+type StorageLayout struct {
+	Storage []StorageLayoutEntry         `json:"storage"`
+	Types   map[string]StorageLayoutType `json:"types"`
+}
+
+// StorageLayoutEntry is a state variable, or a member of a struct.
+type StorageLayoutEntry struct {
+	Label string `json:"label"`
+	// Offset is the byte of the slot the variable starts at, from the lowest order one
+	Offset uint64 `json:"offset"`
+	// Slot is the decimal number of the slot the variable starts at
+	Slot string `json:"slot"`
+	Type string `json:"type"`
+}
+
+// StorageLayoutType is a type of the layout, Encoding is one of inplace, mapping,
+// dynamic_array and bytes.
+type StorageLayoutType struct {
+	Encoding      string               `json:"encoding"`
+	Label         string               `json:"label"`
+	NumberOfBytes string               `json:"numberOfBytes"`
+	Key           string               `json:"key,omitempty"`
+	Value         string               `json:"value,omitempty"`
+	Base          string               `json:"base,omitempty"`
+	Members       []StorageLayoutEntry `json:"members,omitempty"`
+}
+
+// ParseStorageLayout parses the storage layout JSON of solc, either alone or in the
+// storageLayout field of the output of a contract.
+func ParseStorageLayout(data []byte) (*StorageLayout, error) {
+	var output struct {
+		StorageLayout *StorageLayout `json:"storageLayout"`
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, err
+	}
+
+	if output.StorageLayout != nil {
+		return output.StorageLayout, nil
+	}
+
+	var layout StorageLayout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return nil, err
+	}
+
+	return &layout, nil
+}
+
+// StorageVariable is a variable held in a storage slot, or the part of it there.
+type StorageVariable struct {
+	// Name is the path to the variable, as balances[0x5B38…].amount
+	Name string
+	// Type is the Solidity type of the variable
+	Type string
+	// Offset and Size are the bytes of the slot the variable takes, Offset counted
+	// from the lowest order byte
+	Offset uint64
+	Size   uint64
+
+	encoding string
+}
+
+// Format formats the value of the variable in word, the value of its slot. Strings
+// and bytes longer than 31 bytes are stored elsewhere, only their length is formatted.
+func (v StorageVariable) Format(word common.Hash) string {
+	if v.encoding == "bytes" {
+		if word[31]&1 == 0 {
+			data := word[:min(word[31]/2, 31)]
+			if v.Type == "string" {
+				return strconv.Quote(string(data))
+			}
+			return hexutil.Encode(data)
+		}
+
+		length := new(big.Int).Rsh(word.Big(), 1)
+		return fmt.Sprintf("(%s bytes)", length)
+	}
+
+	if v.Offset+v.Size > 32 || v.Size == 0 {
+		return word.Hex()
+	}
+
+	return formatStorageValue(v.Type, word[32-v.Offset-v.Size:32-v.Offset])
+}
+
+// DecodedVariable is a variable with its value.
+type DecodedVariable struct {
+	StorageVariable
+	Value string
+}
+
+// Decode returns the variables held in the slot derived by path, none when the
+// layout doesn't describe it. The keys of mappings of strings and bytes aren't
+// decoded, their preimages aren't recorded.
+func (l *StorageLayout) Decode(path SlotPath) []StorageVariable {
+	return l.decodeEntries(l.Storage, "", path.Base.Big(), path.Steps)
+}
+
+// decodeEntries returns the variables of entries in slot, relative to the slot the
+// entries start at
+func (l *StorageLayout) decodeEntries(entries []StorageLayoutEntry, prefix string, slot *big.Int, steps []SlotStep) []StorageVariable {
+	var variables []StorageVariable
+	for _, entry := range entries {
+		start, ok := new(big.Int).SetString(entry.Slot, 10)
+		if !ok {
+			continue
+		}
+
+		rel := new(big.Int).Sub(slot, start)
+		if rel.Sign() < 0 || !rel.IsUint64() || rel.Uint64() >= l.slots(entry.Type) {
+			continue
+		}
+
+		variables = append(variables, l.decodeType(entry.Type, prefix+entry.Label, rel.Uint64(), entry.Offset, steps)...)
+	}
+
+	return variables
+}
+
+// decodeType returns the variables of a value of typ named name in slot, relative
+// to the slot the value starts at. offset is the byte of the slot the value starts
+// at, when it's packed.
+func (l *StorageLayout) decodeType(typ, name string, slot, offset uint64, steps []SlotStep) []StorageVariable {
+	t, ok := l.Types[typ]
+	if !ok {
+		return nil
+	}
+
+	switch t.Encoding {
+	case "mapping":
+		// the slot of a mapping is empty, its values are in the slots derived from it
+		if slot != 0 || len(steps) == 0 || !steps[0].Mapping {
+			return nil
+		}
+
+		key := l.formatKey(t.Key, steps[0].Key)
+		return l.decodeType(t.Value, name+"["+key+"]", steps[0].Offset, 0, steps[1:])
+
+	case "dynamic_array":
+		if slot != 0 {
+			return nil
+		}
+
+		if len(steps) == 0 {
+			return []StorageVariable{{Name: name + ".length", Type: "uint256", Size: 32, encoding: "inplace"}}
+		}
+
+		if steps[0].Mapping {
+			return nil
+		}
+
+		return l.decodeElements(t.Base, name, steps[0].Offset, 0, steps[1:])
+
+	case "bytes":
+		if slot != 0 {
+			return nil
+		}
+
+		if len(steps) == 0 {
+			return []StorageVariable{{Name: name, Type: t.Label, Size: 32, encoding: t.Encoding}}
+		}
+
+		// the data of long strings and bytes, 32 bytes by slot
+		if steps[0].Mapping || len(steps) > 1 {
+			return nil
+		}
+
+		return []StorageVariable{{Name: fmt.Sprintf("%s.data[%d]", name, steps[0].Offset), Type: "bytes32", Size: 32, encoding: "inplace"}}
+	}
+
+	switch {
+	case len(t.Members) > 0:
+		return l.decodeEntries(t.Members, name+".", new(big.Int).SetUint64(slot), steps)
+
+	case t.Base != "":
+		return l.decodeElements(t.Base, name, slot, staticLength(t.Label), steps)
+
+	case slot == 0 && len(steps) == 0:
+		return []StorageVariable{{Name: name, Type: t.Label, Offset: offset, Size: l.bytes(typ), encoding: t.Encoding}}
+	}
+
+	return nil
+}
+
+// decodeElements returns the elements of an array of base in slot, relative to the
+// slot the elements start at. Elements smaller than a slot are packed. length
+// bounds the elements of static arrays, 0 for dynamic ones.
+func (l *StorageLayout) decodeElements(base, name string, slot, length uint64, steps []SlotStep) []StorageVariable {
+	size := l.bytes(base)
+	if size == 0 {
+		return nil
+	}
+
+	if size > 16 {
+		perElement := l.slots(base)
+		return l.decodeType(base, fmt.Sprintf("%s[%d]", name, slot/perElement), slot%perElement, 0, steps)
+	}
+
+	var (
+		variables []StorageVariable
+		perSlot   = 32 / size
+	)
+	for i := uint64(0); i < perSlot; i++ {
+		index := slot*perSlot + i
+		if length > 0 && index >= length {
+			break
+		}
+
+		variables = append(variables, l.decodeType(base, fmt.Sprintf("%s[%d]", name, index), 0, i*size, steps)...)
+	}
+
+	return variables
+}
+
+// bytes returns the size of typ
+func (l *StorageLayout) bytes(typ string) uint64 {
+	size, _ := strconv.ParseUint(l.Types[typ].NumberOfBytes, 10, 64)
+	return size
+}
+
+// slots returns the number of slots typ takes
+func (l *StorageLayout) slots(typ string) uint64 {
+	return max((l.bytes(typ)+31)/32, 1)
+}
+
+// formatKey formats the key of a mapping with keys of typ
+func (l *StorageLayout) formatKey(typ string, key common.Hash) string {
+	t := l.Types[typ]
+	size := l.bytes(typ)
+	if size == 0 || size > 32 {
+		return key.Hex()
+	}
+
+	// fixed size byte arrays are left aligned
+	if strings.HasPrefix(t.Label, "bytes") {
+		return hexutil.Encode(key[:size])
+	}
+
+	return formatStorageValue(t.Label, key[32-size:])
+}
+
+// staticLength returns the length of the static array of label, as uint8[10]
+func staticLength(label string) uint64 {
+	i := strings.LastIndexByte(label, '[')
+	if i < 0 || !strings.HasSuffix(label, "]") {
+		return 0
+	}
+
+	length, _ := strconv.ParseUint(label[i+1:len(label)-1], 10, 64)
+	return length
+}
+
+// formatStorageValue formats value, big endian, as a value of the type label
+func formatStorageValue(label string, value []byte) string {
+	switch {
+	case label == "bool":
+		return strconv.FormatBool(new(big.Int).SetBytes(value).Sign() != 0)
+	case label == "address" || label == "address payable" || strings.HasPrefix(label, "contract "):
+		return common.BytesToAddress(value).Hex()
+	case strings.HasPrefix(label, "uint") || strings.HasPrefix(label, "enum "):
+		return new(big.Int).SetBytes(value).String()
+	case strings.HasPrefix(label, "int"):
+		n := new(big.Int).SetBytes(value)
+		if len(value) > 0 && value[0]&0x80 != 0 {
+			n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(value))*8))
+		}
+		return n.String()
+	}
+
+	return hexutil.Encode(value)
+}
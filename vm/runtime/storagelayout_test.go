@@ -0,0 +1,125 @@
+package runtime
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const testStorageLayout = `{"storageLayout": {
+	"storage": [
+		{"label": "owner", "offset": 0, "slot": "0", "type": "t_address"},
+		{"label": "paused", "offset": 20, "slot": "0", "type": "t_bool"},
+		{"label": "balances", "offset": 0, "slot": "1", "type": "t_mapping(t_address,t_uint256)"},
+		{"label": "positions", "offset": 0, "slot": "2", "type": "t_mapping(t_address,t_struct(Position)_storage)"},
+		{"label": "small", "offset": 0, "slot": "3", "type": "t_array(t_uint64)dyn_storage"},
+		{"label": "name", "offset": 0, "slot": "4", "type": "t_string_storage"}
+	],
+	"types": {
+		"t_address": {"encoding": "inplace", "label": "address", "numberOfBytes": "20"},
+		"t_bool": {"encoding": "inplace", "label": "bool", "numberOfBytes": "1"},
+		"t_int128": {"encoding": "inplace", "label": "int128", "numberOfBytes": "16"},
+		"t_uint64": {"encoding": "inplace", "label": "uint64", "numberOfBytes": "8"},
+		"t_uint256": {"encoding": "inplace", "label": "uint256", "numberOfBytes": "32"},
+		"t_string_storage": {"encoding": "bytes", "label": "string", "numberOfBytes": "32"},
+		"t_array(t_uint64)dyn_storage": {"base": "t_uint64", "encoding": "dynamic_array", "label": "uint64[]", "numberOfBytes": "32"},
+		"t_mapping(t_address,t_uint256)": {"encoding": "mapping", "key": "t_address", "label": "mapping(address => uint256)", "numberOfBytes": "32", "value": "t_uint256"},
+		"t_mapping(t_address,t_struct(Position)_storage)": {"encoding": "mapping", "key": "t_address", "label": "mapping(address => struct Position)", "numberOfBytes": "32", "value": "t_struct(Position)_storage"},
+		"t_struct(Position)_storage": {"encoding": "inplace", "label": "struct Position", "numberOfBytes": "64", "members": [
+			{"label": "amount", "offset": 0, "slot": "0", "type": "t_uint256"},
+			{"label": "debt", "offset": 0, "slot": "1", "type": "t_int128"},
+			{"label": "open", "offset": 16, "slot": "1", "type": "t_bool"}
+		]}
+	}
+}}`
+
+func TestStorageLayoutDecode(t *testing.T) {
+	layout, err := ParseStorageLayout([]byte(testStorageLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		user    = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		userKey = common.BytesToHash(user.Bytes())
+		slot    = func(n int64) common.Hash { return common.BigToHash(big.NewInt(n)) }
+	)
+
+	// -2 as int128 and true packed after it
+	debtWord := common.HexToHash("0x0000000000000000000000000000000001fffffffffffffffffffffffffffffffe")
+
+	tests := []struct {
+		name   string
+		path   SlotPath
+		word   common.Hash
+		names  []string
+		values []string
+	}{
+		{
+			name:   "packed variables",
+			path:   SlotPath{Base: slot(0)},
+			word:   common.HexToHash("0x00000000000000000000000100000000000000000000000000000000000000aa"),
+			names:  []string{"owner", "paused"},
+			values: []string{user.Hex(), "true"},
+		},
+		{
+			name:   "mapping",
+			path:   SlotPath{Base: slot(1), Steps: []SlotStep{{Mapping: true, Key: userKey}}},
+			word:   slot(42),
+			names:  []string{"balances[" + user.Hex() + "]"},
+			values: []string{"42"},
+		},
+		{
+			name:   "struct in mapping",
+			path:   SlotPath{Base: slot(2), Steps: []SlotStep{{Mapping: true, Key: userKey, Offset: 1}}},
+			word:   debtWord,
+			names:  []string{"positions[" + user.Hex() + "].debt", "positions[" + user.Hex() + "].open"},
+			values: []string{"-2", "true"},
+		},
+		{
+			name:   "array length",
+			path:   SlotPath{Base: slot(3)},
+			word:   slot(6),
+			names:  []string{"small.length"},
+			values: []string{"6"},
+		},
+		{
+			name:   "packed array elements",
+			path:   SlotPath{Base: slot(3), Steps: []SlotStep{{Offset: 1}}},
+			word:   common.HexToHash("0x0000000000000004000000000000000300000000000000020000000000000001"),
+			names:  []string{"small[4]", "small[5]", "small[6]", "small[7]"},
+			values: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:   "short string",
+			path:   SlotPath{Base: slot(4)},
+			word:   common.HexToHash("0x6162630000000000000000000000000000000000000000000000000000000006"),
+			names:  []string{"name"},
+			values: []string{`"abc"`},
+		},
+		{
+			name: "not in the layout",
+			path: SlotPath{Base: slot(9)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variables := layout.Decode(tt.path)
+			if len(variables) != len(tt.names) {
+				t.Fatalf("variables = %+v, want %v", variables, tt.names)
+			}
+
+			for i, variable := range variables {
+				if variable.Name != tt.names[i] {
+					t.Errorf("variable %d name = %s, want %s", i, variable.Name, tt.names[i])
+				}
+
+				if value := variable.Format(tt.word); value != tt.values[i] {
+					t.Errorf("variable %s = %s, want %s", variable.Name, value, tt.values[i])
+				}
+			}
+		})
+	}
+}
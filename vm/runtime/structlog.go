@@ -0,0 +1,84 @@
+package runtime
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// chainHooks returns hooks calling the ones of tracer followed by the ones of next,
+// for the hooks used by the loggers of go-ethereum.
+func chainHooks(tracer, next *tracing.Hooks) *tracing.Hooks {
+	if tracer == nil {
+		return next
+	}
+
+	hooks := *tracer
+
+	if next.OnTxStart != nil {
+		hooks.OnTxStart = func(env *tracing.VMContext, tx *types.Transaction, from common.Address) {
+			if tracer.OnTxStart != nil {
+				tracer.OnTxStart(env, tx, from)
+			}
+			next.OnTxStart(env, tx, from)
+		}
+	}
+
+	if next.OnTxEnd != nil {
+		hooks.OnTxEnd = func(receipt *types.Receipt, err error) {
+			if tracer.OnTxEnd != nil {
+				tracer.OnTxEnd(receipt, err)
+			}
+			next.OnTxEnd(receipt, err)
+		}
+	}
+
+	if next.OnSystemCallStart != nil {
+		hooks.OnSystemCallStart = func() {
+			if tracer.OnSystemCallStart != nil {
+				tracer.OnSystemCallStart()
+			}
+			next.OnSystemCallStart()
+		}
+	}
+
+	if next.OnEnter != nil {
+		hooks.OnEnter = func(depth int, typ byte, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+			if tracer.OnEnter != nil {
+				tracer.OnEnter(depth, typ, from, to, input, gas, value)
+			}
+			next.OnEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+
+	if next.OnExit != nil {
+		hooks.OnExit = func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+			if tracer.OnExit != nil {
+				tracer.OnExit(depth, output, gasUsed, err, reverted)
+			}
+			next.OnExit(depth, output, gasUsed, err, reverted)
+		}
+	}
+
+	if next.OnOpcode != nil {
+		hooks.OnOpcode = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			if tracer.OnOpcode != nil {
+				tracer.OnOpcode(pc, op, gas, cost, scope, rData, depth, err)
+			}
+			next.OnOpcode(pc, op, gas, cost, scope, rData, depth, err)
+		}
+	}
+
+	if next.OnFault != nil {
+		hooks.OnFault = func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, depth int, err error) {
+			if tracer.OnFault != nil {
+				tracer.OnFault(pc, op, gas, cost, scope, depth, err)
+			}
+			next.OnFault(pc, op, gas, cost, scope, depth, err)
+		}
+	}
+
+	return &hooks
+}
@@ -0,0 +1,75 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestStructLogs(t *testing.T) {
+	contract := common.HexToAddress("0x000000000000000000000000000000000000cafe")
+	// SSTORE(1, 0x2a)
+	code := []byte{byte(ourVm.PUSH1), 0x2a, byte(ourVm.PUSH1), 0x01, byte(ourVm.SSTORE), byte(ourVm.STOP)}
+
+	execute := func(cfg *Config) *ExecutionResult {
+		statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := Execute(context.Background(), contract, big.NewInt(0), code, nil, cfg, statedb)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return result
+	}
+
+	t.Run("attached to the result", func(t *testing.T) {
+		result := execute(&Config{StructLogger: &logger.Config{}})
+
+		var trace logger.ExecutionResult
+		if err := json.Unmarshal(result.StructLogs, &trace); err != nil {
+			t.Fatal(err)
+		}
+
+		if trace.Gas != result.GasUsed || trace.Failed {
+			t.Fatalf("unexpected trace: %+v", trace)
+		}
+
+		if len(trace.StructLogs) != 4 {
+			t.Fatalf("struct logs: %+v", trace.StructLogs)
+		}
+
+		sstore := trace.StructLogs[2]
+		slot := common.BigToHash(big.NewInt(1)).Hex()[2:]
+		if sstore.Op != "SSTORE" || sstore.Pc != 4 || (*sstore.Storage)[slot] != common.BigToHash(big.NewInt(0x2a)).Hex()[2:] {
+			t.Fatalf("unexpected sstore: %+v", sstore)
+		}
+	})
+
+	t.Run("streamed to the writer", func(t *testing.T) {
+		var buf bytes.Buffer
+		result := execute(&Config{StructLogger: &logger.Config{}, StructLogWriter: &buf})
+
+		if result.StructLogs != nil {
+			t.Fatalf("struct logs attached: %s", result.StructLogs)
+		}
+
+		// one line per opcode and one for the end of the execution
+		if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 5 {
+			t.Fatalf("unexpected output: %s", buf.String())
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package runtime
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+// ValueTransfer is a transfer of ether made by a call, a creation or a selfdestruct
+// during an execution.
+type ValueTransfer struct {
+	From  common.Address
+	To    common.Address
+	Value *big.Int
+}
+
+// valueTransferHooks returns hooks appending to transfers the ether moved between
+// accounts, transfers reverted afterwards, by their call or by a parent one, are
+// removed. The hooks in tracer keep being called.
+func valueTransferHooks(tracer *tracing.Hooks, transfers *[]ValueTransfer) *tracing.Hooks {
+	hooks := &tracing.Hooks{}
+	if tracer != nil {
+		*hooks = *tracer
+	}
+
+	// number of transfers when each frame was entered
+	var frames []int
+
+	hooks.OnEnter = func(depth int, typ byte, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+		frames = append(frames, len(*transfers))
+		// the value of DELEGATECALL and CALLCODE stays in the caller
+		op := ourVm.OpCode(typ)
+		if op != ourVm.DELEGATECALL && op != ourVm.CALLCODE && value != nil && value.Sign() > 0 {
+			*transfers = append(*transfers, ValueTransfer{
+				From:  from,
+				To:    to,
+				Value: new(big.Int).Set(value),
+			})
+		}
+
+		if tracer != nil && tracer.OnEnter != nil {
+			tracer.OnEnter(depth, typ, from, to, input, gas, value)
+		}
+	}
+
+	hooks.OnExit = func(depth int, output []byte, gasUsed uint64, err error, reverted bool) {
+		if len(frames) > 0 {
+			start := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			if reverted {
+				*transfers = (*transfers)[:start]
+			}
+		}
+
+		if tracer != nil && tracer.OnExit != nil {
+			tracer.OnExit(depth, output, gasUsed, err, reverted)
+		}
+	}
+
+	return hooks
+}
@@ -0,0 +1,56 @@
+package runtime
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	ourVm "github.com/Gealber/evm-simulator/vm"
+)
+
+func TestValueTransfers(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0x000000000000000000000000000000000000cafe")
+		origin   = common.HexToAddress("0x0000000000000000000000000000000000000001")
+		identity = common.BytesToAddress([]byte{0x04})
+	)
+
+	// CALL(gas, identity, 1, 0, 0, 0, 0)
+	code := []byte{
+		byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0), byte(ourVm.PUSH0),
+		byte(ourVm.PUSH1), 0x01, byte(ourVm.PUSH1), 0x04, byte(ourVm.GAS), byte(ourVm.CALL),
+		byte(ourVm.STOP),
+	}
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{Origin: origin, Value: big.NewInt(4)}
+	result, err := Execute(context.Background(), contract, big.NewInt(10), code, nil, cfg, statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ValueTransfer{
+		{From: origin, To: contract, Value: big.NewInt(4)},
+		{From: contract, To: identity, Value: big.NewInt(1)},
+	}
+
+	if len(result.ValueTransfers) != len(expected) {
+		t.Fatalf("transfers: %+v", result.ValueTransfers)
+	}
+
+	for i, transfer := range result.ValueTransfers {
+		want := expected[i]
+		if transfer.From != want.From || transfer.To != want.To || transfer.Value.Cmp(want.Value) != 0 {
+			t.Fatalf("transfer %d: %+v expected %+v", i, transfer, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+const (
+	// maxSpeculativeSlots bounds the slots fetched along with a missing one
+	maxSpeculativeSlots = 16
+	// speculationWindow is the number of bytes of code scanned for the slots read
+	// after a missing one
+	speculationWindow = 512
+	// slotHistorySize is the number of functions whose slots are kept by a SlotHistory
+	slotHistorySize = 4096
+)
+
+// StorageBatchProvider is a StateProvider fetching several slots of an account in a
+// single request, it's needed to fetch slots speculatively.
+type StorageBatchProvider interface {
+	StateProvider
+	GetStorageBatch(ctx context.Context, address string, positions []string, blk string) ([]common.Hash, error)
+}
+
+// functionKey identifies a function of a contract by the hash of its code and the
+// selector it's called with
+type functionKey struct {
+	codeHash common.Hash
+	selector [4]byte
+}
+
+// SlotHistory holds the slots fetched by the previous runs of every function, they
+// are likely read again by its next runs. It's safe for concurrent use, the slices
+// are never modified once added.
+type SlotHistory struct {
+	slots *lru.Cache[functionKey, []common.Hash]
+}
+
+// NewSlotHistory returns an empty SlotHistory
+func NewSlotHistory() *SlotHistory {
+	return &SlotHistory{slots: lru.NewCache[functionKey, []common.Hash](slotHistorySize)}
+}
+
+var _ StorageBatchProvider = (*rpc.Client)(nil)
+
+// SetSpeculativeFetch makes the interpreter fetch, along with a slot missing from the
+// fork state, the slots the code is about to read and the ones read by previous runs
+// of the same function, kept in history, in a single request. A nil history disables
+// it. It needs a StorageBatchProvider and a fork state, and must be called before Run.
+func (in *EVMInterpreter) SetSpeculativeFetch(history *SlotHistory) {
+	in.slotHistory = history
+}
+
+// functionOf returns the function contract runs, false for code without a hash or
+// called without a selector
+func functionOf(contract *Contract) (functionKey, bool) {
+	if contract.CodeHash == (common.Hash{}) || len(contract.Input) < 4 {
+		return functionKey{}, false
+	}
+
+	return functionKey{codeHash: contract.CodeHash, selector: [4]byte(contract.Input[:4])}, true
+}
+
+// remember adds slot to the history of the function contract runs
+func (h *SlotHistory) remember(contract *Contract, slot common.Hash) {
+	fn, ok := functionOf(contract)
+	if !ok {
+		return
+	}
+
+	slots, _ := h.slots.Get(fn)
+	if len(slots) >= maxSpeculativeSlots {
+		return
+	}
+	for _, s := range slots {
+		if s == slot {
+			return
+		}
+	}
+
+	// copied, the slices of the history are shared
+	updated := make([]common.Hash, len(slots), len(slots)+1)
+	copy(updated, slots)
+	h.slots.Add(fn, append(updated, slot))
+}
+
+// upcomingSlots returns the constant slots read by the SLOADs of code after pc, the
+// ones pushed right before them.
+func upcomingSlots(code []byte, pc uint64) []common.Hash {
+	var (
+		slots  []common.Hash
+		pushed *common.Hash
+		end    = min(uint64(len(code)), pc+speculationWindow)
+	)
+
+	for i := pc + 1; i < end && len(slots) < maxSpeculativeSlots; {
+		op := OpCode(code[i])
+		switch {
+		case op == PUSH0:
+			pushed = &common.Hash{}
+		case op >= PUSH1 && op <= PUSH32:
+			n := uint64(op-PUSH1) + 1
+			if i+n >= uint64(len(code)) {
+				return slots
+			}
+			slot := common.BytesToHash(code[i+1 : i+1+n])
+			pushed = &slot
+			i += n
+		case op == SLOAD && pushed != nil:
+			slots = append(slots, *pushed)
+			pushed = nil
+		default:
+			pushed = nil
+		}
+		i++
+	}
+
+	return slots
+}
+
+// speculate fetches slot of the account of contract, missing from the fork state,
+// along with the slots it's likely to read next, adding them to the fork state.
+func (in *EVMInterpreter) speculate(contract *Contract, pc uint64, slot common.Hash) {
+	provider, ok := in.provider.(StorageBatchProvider)
+	if !ok {
+		return
+	}
+
+	addr := contract.Address()
+	candidates := upcomingSlots(contract.Code, pc)
+	if fn, ok := functionOf(contract); ok {
+		history, _ := in.slotHistory.slots.Get(fn)
+		candidates = append(candidates, history...)
+	}
+
+	slots := []common.Hash{slot}
+	seen := map[common.Hash]struct{}{slot: {}}
+	for _, candidate := range candidates {
+		if _, ok := seen[candidate]; ok {
+			continue
+		}
+		seen[candidate] = struct{}{}
+
+		if in.forkState.StorageFetched(addr, candidate) {
+			continue
+		}
+		slots = append(slots, candidate)
+	}
+
+	if len(slots) == 1 {
+		return
+	}
+
+	positions := make([]string, len(slots))
+	for i, s := range slots {
+		positions[i] = s.Hex()
+	}
+
+	values, err := provider.GetStorageBatch(in.ctx, addr.Hex(), positions, in.forkState.Block())
+	if err != nil {
+		logger.DebugContext(in.ctx, "speculative fetch failed", "address", addr, "slots", len(slots), "error", err)
+		return
+	}
+
+	fetched := make(map[common.Hash]common.Hash, len(slots))
+	for i, s := range slots {
+		fetched[s] = values[i]
+	}
+	in.forkState.AddStorage(addr, fetched, false)
+}
@@ -0,0 +1,63 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStepLimitExceeded aborts an execution running more opcodes, or for longer, than
+// allowed with SetStepLimit.
+var ErrStepLimitExceeded = errors.New("step limit exceeded")
+
+// deadlineCheckInterval is the number of opcodes run between two checks of the deadline
+const deadlineCheckInterval = 1024
+
+// stepLimit bounds the opcodes run by all the frames of an execution
+type stepLimit struct {
+	max      uint64
+	deadline time.Time
+	steps    uint64
+	// err is kept once exceeded, so the frames still running fail as well instead
+	// of going on after the failed call
+	err error
+}
+
+// SetStepLimit aborts the execution with ErrStepLimitExceeded once it runs more than
+// maxSteps opcodes, counting the ones of every call, or once deadline passes. A zero
+// maxSteps or deadline doesn't limit it. It must be called before Run.
+func (in *EVMInterpreter) SetStepLimit(maxSteps uint64, deadline time.Time) {
+	if maxSteps == 0 && deadline.IsZero() {
+		in.stepLimit = nil
+		return
+	}
+
+	in.stepLimit = &stepLimit{max: maxSteps, deadline: deadline}
+}
+
+// StepLimitErr returns the error the execution was aborted with by the step limit,
+// nil when it wasn't.
+func (in *EVMInterpreter) StepLimitErr() error {
+	if in.stepLimit == nil {
+		return nil
+	}
+
+	return in.stepLimit.err
+}
+
+// step counts an opcode about to run, failing once the limit is exceeded
+func (l *stepLimit) step() error {
+	if l.err != nil {
+		return l.err
+	}
+
+	l.steps++
+	switch {
+	case l.max > 0 && l.steps > l.max:
+		l.err = fmt.Errorf("%w: more than %d opcodes", ErrStepLimitExceeded, l.max)
+	case !l.deadline.IsZero() && l.steps%deadlineCheckInterval == 0 && time.Now().After(l.deadline):
+		l.err = fmt.Errorf("%w: deadline passed after %d opcodes", ErrStepLimitExceeded, l.steps)
+	}
+
+	return l.err
+}
@@ -0,0 +1,217 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+
+	"github.com/Gealber/evm-simulator/rpc"
+)
+
+// ErrInvalidProof is returned by a VerifyingProvider when the state answered by the
+// node doesn't match the state root of the block.
+var ErrInvalidProof = errors.New("invalid state proof")
+
+// StorageProofProvider is a ProofProvider fetching the proofs of an account and its
+// slots alone, it's needed to verify the state fetched.
+type StorageProofProvider interface {
+	ProofProvider
+	GetProof(ctx context.Context, address string, positions []string, blk string) (*rpc.AccountResult, error)
+}
+
+var _ StorageProofProvider = (*rpc.Client)(nil)
+
+// VerifyingProvider fetches the accounts and slots read by the executions with their
+// Merkle proofs, eth_getProof, and verifies them against the state root of the block
+// before answering. Accounts are verified once per block number, together with their
+// code, every slot is a request of its own. Block hashes aren't verified.
+//
+// The state root is the one of the header answered by the same node, unless the
+// provider is built with the one expected, which must be known when the node isn't
+// trusted with the headers either. It's safe for concurrent use.
+type VerifyingProvider struct {
+	provider StorageProofProvider
+	// stateRoot expected, zero when the one of the header is trusted
+	stateRoot common.Hash
+
+	// roots by block, accounts by block and address, all verified. The ones of the
+	// latest block aren't kept, it changes
+	mu       sync.Mutex
+	roots    lru.BasicLRU[string, common.Hash]
+	accounts *lru.Cache[string, verifiedAccount]
+}
+
+// verifiedAccount is an account verified against the state root of its block
+type verifiedAccount struct {
+	account *rpc.AccountResult
+	root    common.Hash
+}
+
+const (
+	// verifiedRoots and verifiedAccounts bound the blocks and accounts kept verified
+	verifiedRoots    = 256
+	verifiedAccounts = 4096
+)
+
+var _ ProofProvider = (*VerifyingProvider)(nil)
+
+// NewVerifyingProvider returns a provider verifying the state fetched with provider
+// against stateRoot, the one of the block forked, or against the state root of the
+// header of every block when zero.
+func NewVerifyingProvider(provider StorageProofProvider, stateRoot common.Hash) *VerifyingProvider {
+	return &VerifyingProvider{
+		provider:  provider,
+		stateRoot: stateRoot,
+		roots:     lru.NewBasicLRU[string, common.Hash](verifiedRoots),
+		accounts:  lru.NewCache[string, verifiedAccount](verifiedAccounts),
+	}
+}
+
+// GetProofBatch fetches the proofs of addrs as the provider, verified.
+func (p *VerifyingProvider) GetProofBatch(ctx context.Context, addrs map[common.Address][]common.Hash, blk string) (*rpc.ProofBatch, error) {
+	batch, err := p.provider.GetProofBatch(ctx, addrs, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.checkRoot(batch.StateRoot); err != nil {
+		return nil, err
+	}
+
+	for _, account := range batch.Accounts {
+		// the proofs are of the account they report
+		if _, ok := addrs[account.Address]; !ok {
+			return nil, fmt.Errorf("%w: account %s not requested", ErrInvalidProof, account.Address.Hex())
+		}
+		if err := account.Verify(batch.StateRoot); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidProof, err)
+		}
+	}
+
+	return batch, nil
+}
+
+func (p *VerifyingProvider) GetCode(ctx context.Context, address, blk string) ([]byte, error) {
+	account, _, err := p.account(ctx, address, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.Code, nil
+}
+
+func (p *VerifyingProvider) GetBalance(ctx context.Context, address, blk string) (*big.Int, error) {
+	account, _, err := p.account(ctx, address, blk)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Balance == nil {
+		return new(big.Int), nil
+	}
+
+	return new(big.Int).Set(account.Balance.ToInt()), nil
+}
+
+func (p *VerifyingProvider) GetNonce(ctx context.Context, address, blk string) (uint64, error) {
+	account, _, err := p.account(ctx, address, blk)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(account.Nonce), nil
+}
+
+func (p *VerifyingProvider) GetStorageAt(ctx context.Context, address, position, blk string) (common.Hash, error) {
+	account, root, err := p.account(ctx, address, blk)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	proof, err := p.provider.GetProof(ctx, address, []string{position}, blk)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	if proof.Address != account.Address {
+		return common.Hash{}, fmt.Errorf("%w: proof of %s answered for %s", ErrInvalidProof, proof.Address.Hex(), address)
+	}
+
+	// the code was verified with the account, the proof is of the same one
+	proof.Code = account.Code
+	if err := proof.Verify(root); err != nil {
+		return common.Hash{}, fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+
+	slot := common.HexToHash(position)
+	for _, storage := range proof.StorageProof {
+		if common.HexToHash(storage.Key) != slot {
+			continue
+		}
+
+		if storage.Value == nil {
+			return common.Hash{}, nil
+		}
+
+		return common.BigToHash(storage.Value.ToInt()), nil
+	}
+
+	return common.Hash{}, fmt.Errorf("%w: slot %s of %s missing in the proof", ErrInvalidProof, slot.Hex(), address)
+}
+
+func (p *VerifyingProvider) GetBlockHash(ctx context.Context, blk string) (common.Hash, error) {
+	return p.provider.GetBlockHash(ctx, blk)
+}
+
+// account returns the verified account of address in blk with the state root of the
+// block, fetching it the first time
+func (p *VerifyingProvider) account(ctx context.Context, address, blk string) (*rpc.AccountResult, common.Hash, error) {
+	addr := common.HexToAddress(address)
+	key := blk + ":" + addr.Hex()
+
+	blkNumber, ok := new(big.Int).SetString(strings.TrimPrefix(blk, "0x"), 16)
+	keep := ok && blkNumber.Sign() > 0
+	if verified, ok := p.accounts.Get(key); keep && ok {
+		return verified.account, verified.root, nil
+	}
+
+	batch, err := p.GetProofBatch(ctx, map[common.Address][]common.Hash{addr: nil}, blk)
+	if err != nil {
+		return nil, common.Hash{}, err
+	}
+	if len(batch.Accounts) != 1 {
+		return nil, common.Hash{}, fmt.Errorf("%w: %d accounts answered for %s", ErrInvalidProof, len(batch.Accounts), address)
+	}
+
+	account, root := batch.Accounts[0], batch.StateRoot
+	if !keep {
+		return account, root, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the root of a block doesn't change
+	if known, ok := p.roots.Get(blk); ok && known != root {
+		return nil, common.Hash{}, fmt.Errorf("%w: state root of block %s changed from %s to %s", ErrInvalidProof, blk, known.Hex(), root.Hex())
+	}
+	p.roots.Add(blk, root)
+	p.accounts.Add(key, verifiedAccount{account: account, root: root})
+
+	return account, root, nil
+}
+
+// checkRoot fails when root isn't the state root expected
+func (p *VerifyingProvider) checkRoot(root common.Hash) error {
+	if p.stateRoot != (common.Hash{}) && root != p.stateRoot {
+		return fmt.Errorf("%w: state root %s, expected %s", ErrInvalidProof, root.Hex(), p.stateRoot.Hex())
+	}
+
+	return nil
+}